@@ -0,0 +1,127 @@
+// Package accesslog writes one structured line per HTTP request served, with
+// its status code, sizes and duration, for debugging client issues.
+//
+// The collector and the aggregator API wrap their handlers in the same
+// Middleware. Lines go to the process log, or to a file such as a collector
+// FileWriter, which rotates it like the JSONL output.
+package accesslog
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// LineWriter receives each access log line, without a trailing newline
+type LineWriter interface {
+	WriteLine(s string) error
+}
+
+// Entry is one request's access log line
+type Entry struct {
+	Time          time.Time `json:"time"`
+	Server        string    `json:"server"`
+	RemoteAddr    string    `json:"remote_addr"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"`
+	Status        int       `json:"status"`
+	RequestBytes  int64     `json:"request_bytes"` // Content-Length; -1 when unknown
+	ResponseBytes int64     `json:"response_bytes"`
+	DurationMS    float64   `json:"duration_ms"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+}
+
+// Logger writes access log lines to its output, or to the process log when
+// it has none
+type Logger struct {
+	out LineWriter
+}
+
+// New returns a Logger writing to out, or to the process log when out is nil
+func New(out LineWriter) *Logger {
+	return &Logger{out: out}
+}
+
+// SetOutput sends lines to out instead, or to the process log when out is
+// nil. It must be called before the server starts.
+func (l *Logger) SetOutput(out LineWriter) {
+	l.out = out
+}
+
+// Log writes entry as a single JSON line
+func (l *Logger) Log(entry *Entry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode access log entry: %v", err)
+		return
+	}
+	if l.out == nil {
+		log.Printf("Access: %s", line)
+		return
+	}
+	if err := l.out.WriteLine(string(line)); err != nil {
+		log.Printf("Failed to write access log: %v", err)
+	}
+}
+
+// Middleware logs each request next serves, naming server as the one that
+// served it
+func (l *Logger) Middleware(server string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Skip logging HTTP/2 connection preface
+		if r.Method == "PRI" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		l.Log(&Entry{
+			Time:          start.UTC(),
+			Server:        server,
+			RemoteAddr:    r.RemoteAddr,
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Status:        rec.status,
+			RequestBytes:  r.ContentLength,
+			ResponseBytes: rec.bytes,
+			DurationMS:    float64(time.Since(start).Microseconds()) / 1000,
+			UserAgent:     r.UserAgent(),
+		})
+	})
+}
+
+// responseRecorder captures the status code and body size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController, so
+// handlers can still flush and set deadlines
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,67 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type lines []string
+
+func (l *lines) WriteLine(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func TestMiddlewareLogsStatusAndSizes(t *testing.T) {
+	var out lines
+	handler := New(&out).Middleware("collector", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader(`{"resourceLogs":[]}`)),
+		httptest.NewRequest(http.MethodGet, "/missing", nil),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if len(out) != 2 {
+		t.Fatalf("Expected one line per request, got %v", out)
+	}
+
+	var ok, missing Entry
+	if err := json.Unmarshal([]byte(out[0]), &ok); err != nil {
+		t.Fatalf("Failed to decode %q: %v", out[0], err)
+	}
+	if ok.Server != "collector" || ok.Method != http.MethodPost || ok.Path != "/v1/logs" ||
+		ok.Status != http.StatusOK || ok.RequestBytes != 19 || ok.ResponseBytes != 5 || ok.Time.IsZero() {
+		t.Errorf("Unexpected entry for the POST: %+v", ok)
+	}
+	if err := json.Unmarshal([]byte(out[1]), &missing); err != nil {
+		t.Fatalf("Failed to decode %q: %v", out[1], err)
+	}
+	if missing.Status != http.StatusNotFound || missing.RequestBytes != 0 || missing.ResponseBytes != int64(len("not found\n")) {
+		t.Errorf("Unexpected entry for the 404: %+v", missing)
+	}
+}
+
+func TestMiddlewareKeepsResponseController(t *testing.T) {
+	var out lines
+	handler := New(&out).Middleware("aggregator", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Expected the recorder to unwrap for flushing, got %v", err)
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stream", nil))
+	if !rec.Flushed || len(out) != 1 || !strings.Contains(out[0], `"status":200`) {
+		t.Errorf("Expected a flushed response logged as 200, got %v", out)
+	}
+}
@@ -0,0 +1,69 @@
+package aggregator
+
+import "time"
+
+// DefaultActiveTimeGap is the longest pause between api_request events that
+// still counts as active time when active time is estimated
+const DefaultActiveTimeGap = 5 * time.Minute
+
+// activeTimeEstimate sums the gaps between a session's api_request events
+// that are shorter than the engine's activeTimeGap. Events are assumed to
+// arrive roughly in order; one older than the latest seen adds nothing.
+type activeTimeEstimate struct {
+	last     time.Time
+	seconds  float64
+	reported bool // The session reports claude_code.active_time.total itself
+}
+
+// SetActiveTimeEstimation estimates active time for sessions that never report
+// the claude_code.active_time.total metric, counting pauses between api_request
+// events shorter than gap as active. A zero gap disables estimation.
+func (e *Engine) SetActiveTimeEstimation(gap time.Duration) {
+	e.cacheMutex.Lock()
+	defer e.cacheMutex.Unlock()
+	e.activeTimeGap = gap
+}
+
+// activeTime gets or creates a session's active time estimate
+func (e *Engine) activeTime(sessionID string) *activeTimeEstimate {
+	est, exists := e.activeTimeCache[sessionID]
+	if !exists {
+		est = &activeTimeEstimate{}
+		e.activeTimeCache[sessionID] = est
+	}
+	return est
+}
+
+// estimateActiveTime extends a session's estimate with an api_request event
+// at timestamp, unless estimation is disabled or the session reports the metric
+func (e *Engine) estimateActiveTime(session *Session, timestamp time.Time) {
+	if e.activeTimeGap <= 0 {
+		return
+	}
+
+	est := e.activeTime(session.SessionID)
+	if est.reported {
+		return
+	}
+	if !est.last.IsZero() {
+		if gap := timestamp.Sub(est.last); gap > 0 && gap < e.activeTimeGap {
+			est.seconds += gap.Seconds()
+		}
+	}
+	if timestamp.After(est.last) {
+		est.last = timestamp
+	}
+
+	session.TotalActiveTimeSeconds = est.seconds
+	session.ActiveTimeEstimated = true
+}
+
+// useReportedActiveTime discards any estimate once a session reports the
+// claude_code.active_time.total metric, so the reported values replace it
+func (e *Engine) useReportedActiveTime(session *Session) {
+	e.activeTime(session.SessionID).reported = true
+	if session.ActiveTimeEstimated {
+		session.TotalActiveTimeSeconds = 0
+		session.ActiveTimeEstimated = false
+	}
+}
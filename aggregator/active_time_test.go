@@ -0,0 +1,101 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEngineEstimatesActiveTime(t *testing.T) {
+	dbPath := "./test_engine_active_time.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+	engine.SetActiveTimeEstimation(DefaultActiveTimeGap)
+
+	// Gaps of 1m, 2m and 2m are active; the 17m pause is not
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	for _, offset := range []time.Duration{0, time.Minute, 3 * time.Minute, 20 * time.Minute, 22 * time.Minute} {
+		for _, sessionID := range []string{"estimated-session", "reported-session"} {
+			engine.ProcessLog(&LogRecord{
+				Timestamp: start.Add(offset),
+				SessionID: sessionID,
+				Body:      "claude_code.api_request",
+			})
+		}
+	}
+
+	// A session reporting the metric keeps the reported value
+	engine.ProcessMetric(&MetricRecord{
+		Timestamp:   start.Add(22 * time.Minute),
+		SessionID:   "reported-session",
+		MetricName:  "claude_code.active_time.total",
+		MetricValue: 42.0,
+	})
+	engine.ProcessLog(&LogRecord{
+		Timestamp: start.Add(23 * time.Minute),
+		SessionID: "reported-session",
+		Body:      "claude_code.api_request",
+	})
+	engine.FlushCache()
+
+	session, err := store.GetSession("estimated-session")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if !session.ActiveTimeEstimated {
+		t.Errorf("Expected active time to be flagged as estimated")
+	}
+	if session.TotalActiveTimeSeconds != 300 {
+		t.Errorf("Expected 300 estimated active seconds, got %f", session.TotalActiveTimeSeconds)
+	}
+
+	session, err = store.GetSession("reported-session")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if session.ActiveTimeEstimated || session.TotalActiveTimeSeconds != 42 {
+		t.Errorf("Expected 42 reported active seconds, got %f (estimated %v)", session.TotalActiveTimeSeconds, session.ActiveTimeEstimated)
+	}
+}
+
+func TestSessionActiveTimeAccumulates(t *testing.T) {
+	server := newTestAPIServer(t, "./test_session_active_time.db")
+	engine := newEngine(server.store)
+
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i, value := range []interface{}{30.5, int64(12)} {
+		engine.ProcessMetric(&MetricRecord{
+			Timestamp:   start.Add(time.Duration(i) * time.Minute),
+			SessionID:   "active-session",
+			UserID:      "user-1",
+			MetricName:  "claude_code.active_time.total",
+			MetricValue: value,
+		})
+	}
+	engine.FlushCache()
+
+	rec := httptest.NewRecorder()
+	server.handleV2Session(rec, httptest.NewRequest(http.MethodGet, "/api/v2/sessions/active-session", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response struct {
+		TotalActiveTimeSeconds float64 `json:"total_active_time_seconds"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.TotalActiveTimeSeconds != 42.5 {
+		t.Errorf("Expected 42.5 active seconds, got %f", response.TotalActiveTimeSeconds)
+	}
+}
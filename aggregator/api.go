@@ -2,12 +2,19 @@ package aggregator
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/zmack/otis/accesslog"
+	"github.com/zmack/otis/buildinfo"
 )
 
 type APIServer struct {
@@ -15,14 +22,43 @@ type APIServer struct {
 	engine     *Engine
 	httpServer *http.Server
 	port       int
+	features   map[string]bool
+	pricing    Pricing
+	costCache  *orgCostCache
+	// comparativeOptOut lists orgs whose user stats omit the relative block
+	comparativeOptOut map[string]bool
+	// dataDir, inputFiles and adminToken enable /api/admin/raw
+	dataDir    string
+	inputFiles InputFiles
+	adminToken string
+	exporter   *Exporter
+	// traceURLTemplate links session traces to an external tracing UI
+	traceURLTemplate string
+	processor        *Processor
+	// publicSummary serves /api/public/summary; nil disables it
+	publicSummary *publicSummary
+	// seats serves /api/stats/org/{org_id}/seats; nil disables it
+	seats *seatCounts
+	// metricsUserLabel splits the usage series served at /metrics by user
+	// as well as organization
+	metricsUserLabel bool
+	// accessLog logs every request served on the API's own listener
+	accessLog *accesslog.Logger
+	// handler serves the API without the access log, for Handler
+	handler http.Handler
+	// degraded serves cached reads while the database is unavailable; nil
+	// disables it
+	degraded *degradedMode
 }
 
 // NewAPIServer creates a new API server
 func NewAPIServer(port int, store *Store, engine *Engine) *APIServer {
 	server := &APIServer{
-		store:  store,
-		engine: engine,
-		port:   port,
+		store:     store,
+		engine:    engine,
+		port:      port,
+		costCache: newOrgCostCache(),
+		accessLog: accesslog.New(nil),
 	}
 
 	mux := http.NewServeMux()
@@ -33,41 +69,161 @@ func NewAPIServer(port int, store *Store, engine *Engine) *APIServer {
 	mux.HandleFunc("/api/stats/org/", server.handleOrgStats)
 	mux.HandleFunc("/api/stats/models", server.handleModelsStats)
 	mux.HandleFunc("/api/stats/tools", server.handleToolsStats)
+	mux.HandleFunc("/api/stats/session-durations", server.handleSessionDurations)
+	mux.HandleFunc("/api/stats/services", server.handleServiceStats)
+	mux.HandleFunc("/api/stats/prompts/top", server.handleTopPrompts)
+	mux.HandleFunc("/api/prompts/search", server.handleSearchPrompts)
+	mux.HandleFunc("/api/stats/timeseries", server.handleTimeseries)
+	mux.HandleFunc("/api/compare/models", server.handleCompareModels)
 	mux.HandleFunc("/api/health", server.handleHealth)
+	mux.HandleFunc("/api/health/ready", server.handleReady)
+	mux.HandleFunc("/api/version", server.handleVersion)
+	mux.HandleFunc("/api/processing", server.handleProcessing)
+	mux.HandleFunc("/api/public/summary", server.handlePublicSummary)
 
 	// New schema endpoints
 	mux.HandleFunc("/api/v2/sessions/", server.handleV2Session)
 	mux.HandleFunc("/api/v2/sessions", server.handleV2SessionsList)
+	mux.HandleFunc("/api/v2/sessions/active", server.handleV2ActiveSessions)
 	mux.HandleFunc("/api/v2/tools", server.handleV2Tools)
 
+	// Background exports
+	mux.HandleFunc("/api/exports", server.handleCreateExport)
+	mux.HandleFunc("/api/exports/", server.handleExport)
+
+	// Admin endpoints
+	mux.HandleFunc("/api/admin/raw", server.handleAdminRaw)
+	mux.HandleFunc("/api/admin/verify", server.handleAdminVerify)
+	mux.HandleFunc("/api/admin/db", server.handleAdminDB)
+	mux.HandleFunc("/api/admin/batches", server.handleAdminBatches)
+
+	// Prometheus gauges
+	mux.HandleFunc("/metrics", server.handleMetrics)
+
+	server.handler = server.degradedMiddleware(mux)
 	server.httpServer = &http.Server{
-		Addr:         fmt.Sprintf(":%d", port),
-		Handler:      server.loggingMiddleware(mux),
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Addr:              net.JoinHostPort("", strconv.Itoa(port)),
+		Handler:           server.accessLog.Middleware("aggregator", server.handler),
+		ReadTimeout:       10 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       10 * time.Second,
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
 	}
 
 	return server
 }
 
+// SetAccessLog writes the access log to out instead of the process log
+func (s *APIServer) SetAccessLog(out accesslog.LineWriter) {
+	s.accessLog.SetOutput(out)
+}
+
+// SetMetricsUserLabel labels the cost and token series served at /metrics
+// by user as well as organization
+func (s *APIServer) SetMetricsUserLabel(enabled bool) {
+	s.metricsUserLabel = enabled
+}
+
+// SetFeatures records the enabled features reported by /api/version
+func (s *APIServer) SetFeatures(features map[string]bool) {
+	s.features = features
+}
+
+// SetBindAddr listens on host, such as "localhost" to accept only local
+// requests, instead of all interfaces
+func (s *APIServer) SetBindAddr(host string) {
+	s.httpServer.Addr = net.JoinHostPort(host, strconv.Itoa(s.port))
+}
+
+// SetHTTPTimeouts bounds how long the API waits to read a whole request, to
+// read its headers, to write a response and for the next request on an idle
+// connection
+func (s *APIServer) SetHTTPTimeouts(read, readHeader, write, idle time.Duration) {
+	s.httpServer.ReadTimeout = read
+	s.httpServer.ReadHeaderTimeout = readHeader
+	s.httpServer.WriteTimeout = write
+	s.httpServer.IdleTimeout = idle
+}
+
+// SetMaxHeaderBytes caps the size of the request headers the API accepts
+func (s *APIServer) SetMaxHeaderBytes(n int) {
+	s.httpServer.MaxHeaderBytes = n
+}
+
+// Handler returns the API's HTTP handler, for serving it on another server's
+// listener instead of calling Start. It doesn't write the access log; the
+// other server's own access log covers its requests.
+func (s *APIServer) Handler() http.Handler {
+	return s.handler
+}
+
+// SetPricing enables per-model cost breakdowns computed from token counts
+func (s *APIServer) SetPricing(pricing Pricing) {
+	s.pricing = pricing
+}
+
+// SetComparativeStatsOptOut hides the cost rank among peers for these orgs
+func (s *APIServer) SetComparativeStatsOptOut(orgIDs []string) {
+	s.comparativeOptOut = make(map[string]bool, len(orgIDs))
+	for _, orgID := range orgIDs {
+		s.comparativeOptOut[orgID] = true
+	}
+}
+
 // Start starts the API server
 func (s *APIServer) Start() error {
-	log.Printf("Starting aggregation API server on port %d", s.port)
+	log.Printf("Starting aggregation API server on %s", s.httpServer.Addr)
 	log.Printf("Legacy endpoints:")
 	log.Printf("  GET http://localhost:%d/api/stats/session/{session_id}", s.port)
 	log.Printf("  GET http://localhost:%d/api/stats/session/{session_id}/models", s.port)
 	log.Printf("  GET http://localhost:%d/api/stats/session/{session_id}/tools", s.port)
 	log.Printf("  GET http://localhost:%d/api/stats/user/{user_id}?limit=10", s.port)
 	log.Printf("  GET http://localhost:%d/api/stats/org/{org_id}?limit=10", s.port)
-	log.Printf("  GET http://localhost:%d/api/stats/models?limit=50", s.port)
+	log.Printf("  GET http://localhost:%d/api/stats/org/{org_id}/cache-analysis?window=30d", s.port)
+	log.Printf("  GET http://localhost:%d/api/stats/org/{org_id}/after-hours?window=30d", s.port)
+	log.Printf("  GET http://localhost:%d/api/stats/org/{org_id}/heatmap?window=30d", s.port)
+	log.Printf("  GET http://localhost:%d/api/stats/org/{org_id}/models/inventory", s.port)
+	if s.seats != nil {
+		log.Printf("  GET http://localhost:%d/api/stats/org/{org_id}/seats?months=6&inactive_days=30", s.port)
+	}
+	log.Printf("  GET http://localhost:%d/api/stats/models?limit=50&from=X&to=Y", s.port)
 	log.Printf("  GET http://localhost:%d/api/stats/tools?limit=50", s.port)
+	log.Printf("  GET http://localhost:%d/api/stats/session-durations?window=7d", s.port)
+	log.Printf("  GET http://localhost:%d/api/stats/prompts/top?window=30d&limit=10", s.port)
+	log.Printf("  GET http://localhost:%d/api/prompts/search?q=X&user_id=Y&limit=100", s.port)
+	log.Printf("  GET http://localhost:%d/api/stats/timeseries?metric=cost&bucket=1h", s.port)
+	log.Printf("  GET http://localhost:%d/api/compare/models?a=X&b=Y&window=30d", s.port)
 	log.Printf("  GET http://localhost:%d/api/health", s.port)
+	log.Printf("  GET http://localhost:%d/api/health/ready", s.port)
+	log.Printf("  GET http://localhost:%d/api/version", s.port)
+	log.Printf("  GET http://localhost:%d/api/processing", s.port)
 	log.Printf("V2 endpoints (new schema):")
 	log.Printf("  GET http://localhost:%d/api/v2/sessions?org_id=X&user_id=Y&limit=10", s.port)
+	log.Printf("  GET http://localhost:%d/api/v2/sessions?stream=true&window=30d", s.port)
+	log.Printf("  GET http://localhost:%d/api/v2/sessions/active", s.port)
 	log.Printf("  GET http://localhost:%d/api/v2/sessions/{session_id}", s.port)
 	log.Printf("  GET http://localhost:%d/api/v2/sessions/{session_id}/tools", s.port)
-	log.Printf("  GET http://localhost:%d/api/v2/sessions/{session_id}/prompts", s.port)
+	log.Printf("  GET http://localhost:%d/api/v2/sessions/{session_id}/prompts?limit=100&after=C", s.port)
+	log.Printf("  GET http://localhost:%d/api/v2/sessions/{session_id}/timeline", s.port)
 	log.Printf("  GET http://localhost:%d/api/v2/tools?limit=50", s.port)
+	log.Printf("  GET http://localhost:%d/metrics", s.port)
+	if s.publicSummary != nil {
+		log.Printf("Public (unauthenticated):")
+		log.Printf("  GET http://localhost:%d/api/public/summary?window=7d", s.port)
+	}
+	if s.exporter != nil {
+		log.Printf("Exports:")
+		log.Printf("  POST http://localhost:%d/api/exports?org_id=X&user_id=Y&window=30d", s.port)
+		log.Printf("  GET http://localhost:%d/api/exports/{job_id}", s.port)
+		log.Printf("  GET http://localhost:%d/api/exports/{job_id}/download", s.port)
+	}
+	if s.adminToken != "" {
+		log.Printf("Admin endpoints:")
+		log.Printf("  GET http://localhost:%d/api/admin/raw?file=logs.jsonl&from=X&to=Y&session_id=Z", s.port)
+		log.Printf("  GET http://localhost:%d/api/admin/db", s.port)
+		log.Printf("  GET http://localhost:%d/api/admin/batches?session_id=X", s.port)
+	}
 
 	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("failed to start API server: %w", err)
@@ -81,7 +237,7 @@ func (s *APIServer) Shutdown(ctx context.Context) error {
 	return s.httpServer.Shutdown(ctx)
 }
 
-// handleSessionStats handles GET /api/stats/session/{session_id}[/models|/tools]
+// handleSessionStats handles GET /api/stats/session/{session_id}[/models|/tools][?strict=true]
 func (s *APIServer) handleSessionStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -114,15 +270,41 @@ func (s *APIServer) handleSessionStats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Get session stats from database
-	stats, err := s.store.GetSessionStats(sessionID)
+	// Get session from database
+	session, err := s.store.GetSession(sessionID)
+	if err == sql.ErrNoRows {
+		// Unknown sessions return an empty-but-valid body unless the client asks for strict 404s
+		if r.URL.Query().Get("strict") == "true" {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"session_id": sessionID,
+			"found":      false,
+		})
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Session not found: %v", err), http.StatusNotFound)
+		http.Error(w, fmt.Sprintf("Error retrieving session stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	models, err := s.store.GetSessionModels(sessionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving model stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	tools, err := s.store.GetSessionTools(sessionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving tool stats: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	// Build response
-	response := buildSessionStatsResponse(stats)
+	response := buildSessionStatsResponse(session, models, tools)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -153,15 +335,93 @@ func (s *APIServer) handleUserStats(w http.ResponseWriter, r *http.Request) {
 		limit = 100
 	}
 
-	// Get user sessions from database
-	sessions, err := s.store.GetUserSessionStats(userID, limit)
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, err := ParseSessionCursor(r.URL.Query().Get("before"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get a page of user sessions from database
+	sessions, err := s.store.GetSessionsByUser(userID, before, fetchLimit(limit))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving user stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sessions, next := pageSessions(sessions, limit)
+
+	sessionIDs := make([]string, len(sessions))
+	for i, session := range sessions {
+		sessionIDs[i] = session.SessionID
+	}
+	modelCounts, err := s.store.GetModelSessionCounts(sessionIDs)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving user stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	toolCounts, err := s.store.GetToolCallCounts(sessionIDs)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving user stats: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	// Build aggregated response
-	response := buildUserStatsResponse(userID, sessions)
+	response := buildUserStatsResponse(userID, sessions, modelCounts, toolCounts)
+	response["next_cursor"] = jsonCursor(next)
+
+	// Attach the precomputed rollup for rolling windows; custom windows and
+	// users not yet rolled up get null
+	response["window_stats"] = nil
+	if window.Type != "custom" {
+		stats, err := s.store.GetUserStats(userID, window.Type)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving user stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if stats != nil {
+			response["window_stats"] = buildUserStatsRollupResponse(stats)
+		}
+	}
+
+	// Usage imported from before otis, which has no sessions of its own
+	imported, err := s.store.GetImportedUsageTotals(userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving user stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	response["imported_usage"] = nil
+	if imported.Days > 0 {
+		response["imported_usage"] = map[string]interface{}{
+			"days":         imported.Days,
+			"first_day":    imported.FirstDay,
+			"last_day":     imported.LastDay,
+			"total_tokens": imported.InputTokens + imported.OutputTokens + imported.CacheReadTokens,
+			"cost_usd":     imported.CostUSD,
+		}
+	}
+
+	// Rank the user's cost within their org, unless the org opted out
+	if len(sessions) > 0 && sessions[0].OrganizationID != "" && !s.comparativeOptOut[sessions[0].OrganizationID] {
+		costs, err := s.costCache.get(s.store, sessions[0].OrganizationID, window)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving user stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		rank := rankUserCost(costs, userID)
+		response["relative"] = map[string]interface{}{
+			"window":              window.Type,
+			"rank":                rank.Rank,
+			"users":               rank.Users,
+			"percentile":          rank.Percentile,
+			"cost_usd":            rank.CostUSD,
+			"org_median_cost_usd": rank.OrgMedianUSD,
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -176,13 +436,42 @@ func (s *APIServer) handleOrgStats(w http.ResponseWriter, r *http.Request) {
 
 	// Extract org ID from path
 	path := strings.TrimPrefix(r.URL.Path, "/api/stats/org/")
-	orgID := strings.TrimSpace(path)
+	parts := strings.Split(path, "/")
+	orgID := strings.TrimSpace(parts[0])
 
 	if orgID == "" {
 		http.Error(w, "Organization ID required", http.StatusBadRequest)
 		return
 	}
 
+	// Check for sub-routes
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "cache-analysis":
+			s.handleOrgCacheAnalysis(w, r, orgID)
+			return
+		case "after-hours":
+			s.handleOrgAfterHours(w, r, orgID)
+			return
+		case "heatmap":
+			s.handleOrgHeatmap(w, r, orgID)
+			return
+		case "seats":
+			s.handleOrgSeats(w, r, orgID)
+			return
+		case "models":
+			if len(parts) == 3 && parts[2] == "inventory" {
+				s.handleOrgModelInventory(w, r, orgID)
+				return
+			}
+			http.Error(w, "Unknown sub-resource", http.StatusNotFound)
+			return
+		default:
+			http.Error(w, "Unknown sub-resource", http.StatusNotFound)
+			return
+		}
+	}
+
 	// Get limit from query params
 	limit := 10
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
@@ -192,15 +481,170 @@ func (s *APIServer) handleOrgStats(w http.ResponseWriter, r *http.Request) {
 		limit = 100
 	}
 
-	// Get org sessions from database
-	sessions, err := s.store.GetOrgSessionStats(orgID, limit)
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, err := ParseSessionCursor(r.URL.Query().Get("before"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get a page of org sessions from database
+	sessions, err := s.store.GetSessionsByOrg(orgID, before, fetchLimit(limit))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving org stats: %v", err), http.StatusInternalServerError)
 		return
 	}
+	sessions, next := pageSessions(sessions, limit)
 
 	// Build aggregated response
 	response := buildOrgStatsResponse(orgID, sessions)
+	response["next_cursor"] = jsonCursor(next)
+
+	// Attach the precomputed rollup for rolling windows
+	response["window_stats"] = nil
+	if window.Type != "custom" {
+		stats, err := s.store.GetOrgStats(orgID, window.Type)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving org stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if stats != nil {
+			response["window_stats"] = buildOrgStatsRollupResponse(stats)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleOrgCacheAnalysis handles GET /api/stats/org/{org_id}/cache-analysis
+func (s *APIServer) handleOrgCacheAnalysis(w http.ResponseWriter, r *http.Request, orgID string) {
+	if len(s.pricing) == 0 {
+		http.Error(w, "Cache analysis requires OTIS_PRICING_FILE", http.StatusServiceUnavailable)
+		return
+	}
+
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	usage, err := s.store.GetOrgModelCacheUsage(orgID, window)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving cache analysis: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Unpriced models can't be weighed, so they are listed but left out of the totals
+	var total CacheTradeoff
+	models := make([]map[string]interface{}, 0, len(usage))
+	unpriced := make([]map[string]interface{}, 0)
+	for _, mu := range usage {
+		t, ok := s.pricing.CacheTradeoff(mu.Model, mu.CacheCreationTokens, mu.CacheReadTokens)
+		if !ok {
+			unpriced = append(unpriced, map[string]interface{}{
+				"model": mu.Model,
+				"tokens": map[string]interface{}{
+					"cache_creation": mu.CacheCreationTokens,
+					"cache_read":     mu.CacheReadTokens,
+				},
+			})
+			continue
+		}
+		total.add(t)
+		model := buildCacheTradeoffResponse(t)
+		model["model"] = mu.Model
+		models = append(models, model)
+	}
+
+	response := buildCacheTradeoffResponse(total)
+	response["organization_id"] = orgID
+	response["window"] = window.Type
+	response["models"] = models
+	response["unpriced_models"] = unpriced
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleOrgAfterHours handles GET /api/stats/org/{org_id}/after-hours,
+// splitting the org's active time and cost between business and after hours
+// overall, per user and per day
+func (s *APIServer) handleOrgAfterHours(w http.ResponseWriter, r *http.Request, orgID string) {
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := s.store.GetOrgSessionHours(orgID, window)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving after hours usage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Days are the sessions' start days in the business hours' timezone
+	hours := s.engine.BusinessHours()
+	var total hoursSplit
+	byUser := make(map[string]*hoursSplit)
+	byDay := make(map[string]*hoursSplit)
+	for _, sh := range sessions {
+		inHours := hours.contains(sh.StartTime)
+		total.add(sh, inHours)
+		if byUser[sh.UserID] == nil {
+			byUser[sh.UserID] = &hoursSplit{}
+		}
+		byUser[sh.UserID].add(sh, inHours)
+		day := sh.StartTime.In(hours.Location).Format("2006-01-02")
+		if byDay[day] == nil {
+			byDay[day] = &hoursSplit{}
+		}
+		byDay[day].add(sh, inHours)
+	}
+
+	// Users with the most after hours time first
+	userIDs := make([]string, 0, len(byUser))
+	for userID := range byUser {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool {
+		a, b := byUser[userIDs[i]], byUser[userIDs[j]]
+		if a.afterHoursSeconds != b.afterHoursSeconds {
+			return a.afterHoursSeconds > b.afterHoursSeconds
+		}
+		return userIDs[i] < userIDs[j]
+	})
+	users := make([]map[string]interface{}, 0, len(userIDs))
+	for _, userID := range userIDs {
+		user := byUser[userID].toJSON()
+		user["user_id"] = userID
+		users = append(users, user)
+	}
+
+	dayKeys := make([]string, 0, len(byDay))
+	for day := range byDay {
+		dayKeys = append(dayKeys, day)
+	}
+	sort.Strings(dayKeys)
+	days := make([]map[string]interface{}, 0, len(dayKeys))
+	for _, day := range dayKeys {
+		entry := byDay[day].toJSON()
+		entry["day"] = day
+		days = append(days, entry)
+	}
+
+	response := total.toJSON()
+	response["organization_id"] = orgID
+	response["window"] = window.Type
+	response["business_hours"] = hours.describe()
+	response["users"] = users
+	response["days"] = days
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -226,83 +670,118 @@ func (s *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(health)
 }
 
-// loggingMiddleware logs HTTP requests
-func (s *APIServer) loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		log.Printf("API: Started %s %s", r.Method, r.URL.Path)
-		next.ServeHTTP(w, r)
-		log.Printf("API: Completed %s %s in %v", r.Method, r.URL.Path, time.Since(start))
-	})
-}
+// handleVersion handles GET /api/version
+func (s *APIServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-// buildSessionStatsResponse builds a JSON response for session stats
-func buildSessionStatsResponse(stats *SessionStats) map[string]interface{} {
-	// Parse models and tools from JSON
-	var models []string
-	json.Unmarshal([]byte(stats.ModelsUsed), &models)
+	schemaVersion, err := s.store.SchemaVersion()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving schema version: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	var tools map[string]int
-	json.Unmarshal([]byte(stats.ToolsUsed), &tools)
+	features := s.features
+	if features == nil {
+		features = map[string]bool{}
+	}
 
-	// Calculate cost by model (simplified - assuming single model for now)
-	costByModel := make(map[string]float64)
-	if len(models) > 0 {
-		// Distribute cost evenly across models (simplified)
-		costPerModel := stats.TotalCostUSD / float64(len(models))
-		for _, model := range models {
-			costByModel[model] = costPerModel
+	info := buildinfo.Get()
+	response := map[string]interface{}{
+		"version":        info.Version,
+		"commit":         info.Commit,
+		"build_date":     info.Date,
+		"go_version":     info.GoVersion,
+		"schema_version": schemaVersion,
+		"features":       features,
+		"uptime_seconds": buildinfo.Uptime().Seconds(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// buildSessionStatsResponse builds a JSON response for session stats, with
+// the cost, tokens and requests of each of models and the calls of each of
+// tools
+func buildSessionStatsResponse(session *Session, models []*SessionModel, tools []*SessionTool) map[string]interface{} {
+	modelNames := make([]string, len(models))
+	costByModel := make(map[string]interface{}, len(models))
+	for i, sm := range models {
+		modelNames[i] = sm.Model
+		costByModel[sm.Model] = map[string]interface{}{
+			"cost_usd":      sm.CostUSD,
+			"request_count": sm.RequestCount,
+			"tokens": map[string]interface{}{
+				"input":          sm.InputTokens,
+				"output":         sm.OutputTokens,
+				"cache_read":     sm.CacheReadTokens,
+				"cache_creation": sm.CacheCreationTokens,
+			},
 		}
 	}
 
+	toolCalls := make(map[string]int, len(tools))
+	for _, st := range tools {
+		toolCalls[st.ToolName] = st.CallCount
+	}
+
 	return map[string]interface{}{
-		"session_id":      stats.SessionID,
-		"user_id":         stats.UserID,
-		"organization_id": stats.OrganizationID,
-		"service_name":    stats.ServiceName,
+		"session_id":      session.SessionID,
+		"found":           true,
+		"user_id":         session.UserID,
+		"organization_id": session.OrganizationID,
+		"service_name":    jsonString(session.ClientName),
 		"window": map[string]interface{}{
-			"start":            stats.StartTime.Format(time.RFC3339),
-			"end":              stats.LastUpdateTime.Format(time.RFC3339),
-			"duration_seconds": stats.LastUpdateTime.Sub(stats.StartTime).Seconds(),
+			"start":            session.StartTime.Format(time.RFC3339),
+			"end":              jsonTime(session.EndTime),
+			"duration_seconds": sessionDuration(session),
 		},
 		"environment": map[string]interface{}{
-			"terminal_type": stats.TerminalType,
-			"host_arch":     stats.HostArch,
-			"os_type":       stats.OSType,
+			"terminal_type": jsonString(session.TerminalType),
+			"host_arch":     jsonString(session.HostArch),
+			"os_type":       jsonString(session.OSType),
 		},
 		"costs": map[string]interface{}{
-			"total_usd": stats.TotalCostUSD,
+			"total_usd": session.TotalCostUSD,
 			"by_model":  costByModel,
 		},
 		"tokens": map[string]interface{}{
-			"total":          stats.TotalInputTokens + stats.TotalOutputTokens + stats.TotalCacheReadTokens,
-			"input":          stats.TotalInputTokens,
-			"output":         stats.TotalOutputTokens,
-			"cache_read":     stats.TotalCacheReadTokens,
-			"cache_creation": stats.TotalCacheCreationTokens,
+			"total":          session.TotalInputTokens + session.TotalOutputTokens + session.TotalCacheReadTokens,
+			"input":          session.TotalInputTokens,
+			"output":         session.TotalOutputTokens,
+			"cache_read":     session.TotalCacheReadTokens,
+			"cache_creation": session.TotalCacheCreationTokens,
 		},
 		"activity": map[string]interface{}{
-			"api_requests":     stats.APIRequestCount,
-			"user_prompts":     stats.UserPromptCount,
-			"tools_executed":   stats.ToolExecutionCount,
-			"tools_succeeded":  stats.ToolSuccessCount,
-			"tools_failed":     stats.ToolFailureCount,
-			"active_time_seconds": stats.TotalActiveTimeSeconds,
+			"api_requests":          session.APIRequestCount,
+			"api_error_count":       session.APIErrorCount,
+			"api_error_rate":        session.APIErrorRate,
+			"user_prompts":          session.UserPromptCount,
+			"tools_executed":        session.ToolCallCount,
+			"tools_succeeded":       session.ToolSuccessCount,
+			"tools_failed":          session.ToolFailureCount,
+			"active_time_seconds":   session.TotalActiveTimeSeconds,
+			"active_time_estimated": session.ActiveTimeEstimated,
 		},
 		"performance": map[string]interface{}{
-			"avg_api_latency_ms": stats.AvgAPILatencyMS,
+			"avg_api_latency_ms": ratio(session.TotalAPILatencyMS, float64(session.APIRequestCount)),
 		},
-		"tools":  tools,
-		"models": models,
+		"tools":  toolCalls,
+		"models": modelNames,
 		"metadata": map[string]interface{}{
-			"created_at": stats.CreatedAt.Format(time.RFC3339),
-			"updated_at": stats.UpdatedAt.Format(time.RFC3339),
+			"created_at": session.CreatedAt.Format(time.RFC3339),
+			"updated_at": session.UpdatedAt.Format(time.RFC3339),
 		},
 	}
 }
 
 // buildUserStatsResponse builds aggregated stats for a user across sessions
-func buildUserStatsResponse(userID string, sessions []*SessionStats) map[string]interface{} {
+// modelCounts is the number of sessions using each model and toolCounts the
+// calls of each tool, over sessions
+func buildUserStatsResponse(userID string, sessions []*Session, modelCounts, toolCounts map[string]int) map[string]interface{} {
 	if len(sessions) == 0 {
 		return map[string]interface{}{
 			"user_id":        userID,
@@ -316,8 +795,6 @@ func buildUserStatsResponse(userID string, sessions []*SessionStats) map[string]
 	var totalInputTokens, totalOutputTokens, totalCacheRead, totalCacheCreation int64
 	var totalActiveTime float64
 	var totalAPIRequests, totalPrompts, totalToolExecs int
-	modelCounts := make(map[string]int)
-	toolCounts := make(map[string]int)
 	var firstSession, lastSession time.Time
 
 	for i, session := range sessions {
@@ -329,7 +806,7 @@ func buildUserStatsResponse(userID string, sessions []*SessionStats) map[string]
 		totalActiveTime += session.TotalActiveTimeSeconds
 		totalAPIRequests += session.APIRequestCount
 		totalPrompts += session.UserPromptCount
-		totalToolExecs += session.ToolExecutionCount
+		totalToolExecs += session.ToolCallCount
 
 		// Track first and last session times
 		if i == 0 || session.StartTime.After(lastSession) {
@@ -338,20 +815,6 @@ func buildUserStatsResponse(userID string, sessions []*SessionStats) map[string]
 		if i == 0 || session.StartTime.Before(firstSession) {
 			firstSession = session.StartTime
 		}
-
-		// Count models
-		var models []string
-		json.Unmarshal([]byte(session.ModelsUsed), &models)
-		for _, model := range models {
-			modelCounts[model]++
-		}
-
-		// Count tools
-		var tools map[string]int
-		json.Unmarshal([]byte(session.ToolsUsed), &tools)
-		for tool, count := range tools {
-			toolCounts[tool] += count
-		}
 	}
 
 	numSessions := len(sessions)
@@ -360,28 +823,28 @@ func buildUserStatsResponse(userID string, sessions []*SessionStats) map[string]
 		"user_id":         userID,
 		"organization_id": sessions[0].OrganizationID,
 		"summary": map[string]interface{}{
-			"total_sessions":       numSessions,
-			"first_session":        firstSession.Format(time.RFC3339),
-			"last_session":         lastSession.Format(time.RFC3339),
+			"total_sessions":            numSessions,
+			"first_session":             firstSession.Format(time.RFC3339),
+			"last_session":              lastSession.Format(time.RFC3339),
 			"total_active_time_seconds": totalActiveTime,
 		},
 		"costs": map[string]interface{}{
-			"total_usd":          totalCost,
-			"avg_per_session":    totalCost / float64(numSessions),
+			"total_usd":       totalCost,
+			"avg_per_session": ratio(totalCost, float64(numSessions)),
 		},
 		"tokens": map[string]interface{}{
-			"total":              totalInputTokens + totalOutputTokens + totalCacheRead,
-			"input":              totalInputTokens,
-			"output":             totalOutputTokens,
-			"cache_read":         totalCacheRead,
-			"cache_creation":     totalCacheCreation,
-			"avg_per_session":    float64(totalInputTokens+totalOutputTokens+totalCacheRead) / float64(numSessions),
+			"total":           totalInputTokens + totalOutputTokens + totalCacheRead,
+			"input":           totalInputTokens,
+			"output":          totalOutputTokens,
+			"cache_read":      totalCacheRead,
+			"cache_creation":  totalCacheCreation,
+			"avg_per_session": ratio(float64(totalInputTokens+totalOutputTokens+totalCacheRead), float64(numSessions)),
 		},
 		"activity": map[string]interface{}{
-			"total_api_requests": totalAPIRequests,
-			"total_prompts":      totalPrompts,
-			"total_tool_execs":   totalToolExecs,
-			"avg_api_per_session": float64(totalAPIRequests) / float64(numSessions),
+			"total_api_requests":  totalAPIRequests,
+			"total_prompts":       totalPrompts,
+			"total_tool_execs":    totalToolExecs,
+			"avg_api_per_session": ratio(float64(totalAPIRequests), float64(numSessions)),
 		},
 		"models":   modelCounts,
 		"tools":    toolCounts,
@@ -390,7 +853,7 @@ func buildUserStatsResponse(userID string, sessions []*SessionStats) map[string]
 }
 
 // buildOrgStatsResponse builds aggregated stats for an organization across sessions
-func buildOrgStatsResponse(orgID string, sessions []*SessionStats) map[string]interface{} {
+func buildOrgStatsResponse(orgID string, sessions []*Session) map[string]interface{} {
 	if len(sessions) == 0 {
 		return map[string]interface{}{
 			"organization_id": orgID,
@@ -426,28 +889,28 @@ func buildOrgStatsResponse(orgID string, sessions []*SessionStats) map[string]in
 	return map[string]interface{}{
 		"organization_id": orgID,
 		"summary": map[string]interface{}{
-			"total_users":          numUsers,
-			"total_sessions":       numSessions,
-			"first_session":        firstSession.Format(time.RFC3339),
-			"last_session":         lastSession.Format(time.RFC3339),
+			"total_users":               numUsers,
+			"total_sessions":            numSessions,
+			"first_session":             firstSession.Format(time.RFC3339),
+			"last_session":              lastSession.Format(time.RFC3339),
 			"total_active_time_seconds": totalActiveTime,
 		},
 		"costs": map[string]interface{}{
-			"total_usd":          totalCost,
-			"avg_per_session":    totalCost / float64(numSessions),
-			"avg_per_user":       totalCost / float64(numUsers),
+			"total_usd":       totalCost,
+			"avg_per_session": ratio(totalCost, float64(numSessions)),
+			"avg_per_user":    ratio(totalCost, float64(numUsers)),
 		},
 		"tokens": map[string]interface{}{
 			"total":           totalTokens,
-			"avg_per_session": float64(totalTokens) / float64(numSessions),
-			"avg_per_user":    float64(totalTokens) / float64(numUsers),
+			"avg_per_session": ratio(float64(totalTokens), float64(numSessions)),
+			"avg_per_user":    ratio(float64(totalTokens), float64(numUsers)),
 		},
 		"sessions": buildSessionList(sessions),
 	}
 }
 
 // buildSessionList builds a simplified list of sessions
-func buildSessionList(sessions []*SessionStats) []map[string]interface{} {
+func buildSessionList(sessions []*Session) []map[string]interface{} {
 	result := make([]map[string]interface{}, len(sessions))
 	for i, session := range sessions {
 		result[i] = map[string]interface{}{
@@ -464,7 +927,7 @@ func buildSessionList(sessions []*SessionStats) []map[string]interface{} {
 
 // handleSessionModels handles GET /api/stats/session/{session_id}/models
 func (s *APIServer) handleSessionModels(w http.ResponseWriter, r *http.Request, sessionID string) {
-	modelStats, err := s.store.GetSessionModelStats(sessionID)
+	modelStats, err := s.store.GetSessionModels(sessionID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving model stats: %v", err), http.StatusInternalServerError)
 		return
@@ -484,7 +947,13 @@ func (s *APIServer) handleSessionModels(w http.ResponseWriter, r *http.Request,
 				"total":          ms.InputTokens + ms.OutputTokens + ms.CacheReadTokens,
 			},
 			"request_count":  ms.RequestCount,
-			"avg_latency_ms": ms.AvgLatencyMS,
+			"avg_latency_ms": ratio(ms.TotalLatencyMS, float64(ms.RequestCount)),
+			"p50_latency_ms": ms.P50LatencyMS,
+			"p95_latency_ms": ms.P95LatencyMS,
+			"p99_latency_ms": ms.P99LatencyMS,
+		}
+		if b, ok := s.pricing.Breakdown(ms.Model, ms.InputTokens, ms.OutputTokens, ms.CacheCreationTokens, ms.CacheReadTokens, ms.CostUSD); ok {
+			models[i]["cost_breakdown"] = buildCostBreakdownResponse(b)
 		}
 	}
 
@@ -499,7 +968,7 @@ func (s *APIServer) handleSessionModels(w http.ResponseWriter, r *http.Request,
 
 // handleSessionTools handles GET /api/stats/session/{session_id}/tools
 func (s *APIServer) handleSessionTools(w http.ResponseWriter, r *http.Request, sessionID string) {
-	toolStats, err := s.store.GetSessionToolStats(sessionID)
+	toolStats, err := s.store.GetSessionTools(sessionID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving tool stats: %v", err), http.StatusInternalServerError)
 		return
@@ -508,23 +977,21 @@ func (s *APIServer) handleSessionTools(w http.ResponseWriter, r *http.Request, s
 	// Build response
 	tools := make([]map[string]interface{}, len(toolStats))
 	for i, ts := range toolStats {
-		successRate := 0.0
-		if ts.ExecutionCount > 0 {
-			successRate = float64(ts.SuccessCount) / float64(ts.ExecutionCount)
-		}
-
 		tools[i] = map[string]interface{}{
 			"tool_name":       ts.ToolName,
-			"execution_count": ts.ExecutionCount,
+			"execution_count": ts.CallCount,
 			"success_count":   ts.SuccessCount,
 			"failure_count":   ts.FailureCount,
 			"duration": map[string]interface{}{
-				"avg_ms":   ts.AvgDurationMS,
-				"min_ms":   ts.MinDurationMS,
-				"max_ms":   ts.MaxDurationMS,
-				"total_ms": ts.TotalDurationMS,
+				"avg_ms":   ratio(ts.TotalExecutionTimeMS, float64(ts.CallCount)),
+				"min_ms":   ts.MinExecutionTimeMS,
+				"max_ms":   ts.MaxExecutionTimeMS,
+				"p50_ms":   ts.P50ExecutionTimeMS,
+				"p95_ms":   ts.P95ExecutionTimeMS,
+				"p99_ms":   ts.P99ExecutionTimeMS,
+				"total_ms": ts.TotalExecutionTimeMS,
 			},
-			"success_rate": successRate,
+			"success_rate": ratio(float64(ts.SuccessCount), float64(ts.CallCount)),
 		}
 	}
 
@@ -537,7 +1004,7 @@ func (s *APIServer) handleSessionTools(w http.ResponseWriter, r *http.Request, s
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleModelsStats handles GET /api/stats/models
+// handleModelsStats handles GET /api/stats/models?limit=50&from=X&to=Y
 func (s *APIServer) handleModelsStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -553,7 +1020,13 @@ func (s *APIServer) handleModelsStats(w http.ResponseWriter, r *http.Request) {
 		limit = 100
 	}
 
-	modelAggs, err := s.store.GetAllModelStats(limit)
+	window, err := parseModelStatsRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	modelAggs, err := s.store.GetModelAggregates(window, limit)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving model stats: %v", err), http.StatusInternalServerError)
 		return
@@ -577,9 +1050,14 @@ func (s *APIServer) handleModelsStats(w http.ResponseWriter, r *http.Request) {
 			"avg_cost_per_session": ma.AvgCostPerSession,
 			"avg_latency_ms":       ma.AvgLatencyMS,
 		}
+		if b, ok := s.pricing.Breakdown(ma.Model, ma.TotalInputTokens, ma.TotalOutputTokens, ma.TotalCacheCreationTokens, ma.TotalCacheReadTokens, ma.TotalCostUSD); ok {
+			models[i]["cost_breakdown"] = buildCostBreakdownResponse(b)
+		}
 	}
 
 	response := map[string]interface{}{
+		"from":   jsonTime(window.Start),
+		"to":     jsonTime(window.End),
 		"models": models,
 	}
 
@@ -587,6 +1065,27 @@ func (s *APIServer) handleModelsStats(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// parseModelStatsRange reads the optional RFC3339 from and to params bounding
+// the sessions the model stats cover; either may be left open
+func parseModelStatsRange(r *http.Request) (TimeWindow, error) {
+	window := TimeWindow{Type: "custom"}
+	var err error
+	if from := r.URL.Query().Get("from"); from != "" {
+		if window.Start, err = time.Parse(time.RFC3339, from); err != nil {
+			return window, fmt.Errorf("invalid from: %v", err)
+		}
+	}
+	if to := r.URL.Query().Get("to"); to != "" {
+		if window.End, err = time.Parse(time.RFC3339, to); err != nil {
+			return window, fmt.Errorf("invalid to: %v", err)
+		}
+	}
+	if !window.Start.IsZero() && !window.End.IsZero() && !window.Start.Before(window.End) {
+		return window, fmt.Errorf("from must be before to")
+	}
+	return window, nil
+}
+
 // handleToolsStats handles GET /api/stats/tools
 func (s *APIServer) handleToolsStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -603,7 +1102,7 @@ func (s *APIServer) handleToolsStats(w http.ResponseWriter, r *http.Request) {
 		limit = 100
 	}
 
-	toolAggs, err := s.store.GetAllToolStats(limit)
+	toolAggs, err := s.store.GetToolAggregates(limit)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving tool stats: %v", err), http.StatusInternalServerError)
 		return
@@ -631,15 +1130,185 @@ func (s *APIServer) handleToolsStats(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleSessionDurations handles GET /api/stats/session-durations?window=all-time|7d|30d
+func (s *APIServer) handleSessionDurations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	histogram, err := s.store.GetSessionDurationHistogram(window, DefaultSessionDurationBuckets)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving session durations: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Build response
+	totalSessions := 0
+	buckets := make([]map[string]interface{}, len(histogram))
+	for i, bucket := range histogram {
+		totalSessions += bucket.Count
+		buckets[i] = map[string]interface{}{
+			"label":       bucket.Label,
+			"min_seconds": bucket.MinSeconds,
+			"count":       bucket.Count,
+		}
+		if bucket.MaxSeconds > 0 {
+			buckets[i]["max_seconds"] = bucket.MaxSeconds
+		}
+	}
+
+	response := map[string]interface{}{
+		"window":         window.Type,
+		"total_sessions": totalSessions,
+		"buckets":        buckets,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleServiceStats handles GET /api/stats/services?window=all-time|7d|30d
+func (s *APIServer) handleServiceStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	breakdown, err := s.store.GetServiceBreakdown(window)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving service stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	services := make([]map[string]interface{}, len(breakdown))
+	for i, su := range breakdown {
+		services[i] = map[string]interface{}{
+			"service_name":   su.ServiceName,
+			"total_sessions": su.Sessions,
+			"total_cost_usd": su.CostUSD,
+			"tokens": map[string]interface{}{
+				"input":          su.InputTokens,
+				"output":         su.OutputTokens,
+				"cache_read":     su.CacheReadTokens,
+				"cache_creation": su.CacheCreationTokens,
+			},
+		}
+	}
+
+	response := map[string]interface{}{
+		"window":   window.Type,
+		"services": services,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleTopPrompts handles GET /api/stats/prompts/top?window=30d&limit=10
+func (s *APIServer) handleTopPrompts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := 10
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		fmt.Sscanf(limitStr, "%d", &limit)
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	top, err := s.store.GetTopPrompts(window, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving top prompts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	prompts := make([]map[string]interface{}, len(top))
+	for i, pf := range top {
+		prompts[i] = map[string]interface{}{
+			"prompt":    pf.PromptText,
+			"count":     pf.Count,
+			"sessions":  pf.Sessions,
+			"last_seen": pf.LastSeen.Format(time.RFC3339),
+		}
+	}
+
+	response := map[string]interface{}{
+		"window":  window.Type,
+		"prompts": prompts,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseTimeWindow reads the window query param: all-time (default), 7d, 30d,
+// or custom with RFC3339 start and end params
+func parseTimeWindow(r *http.Request, now time.Time) (TimeWindow, error) {
+	query := r.URL.Query()
+	return ParseTimeWindow(query.Get("window"), query.Get("start"), query.Get("end"), now)
+}
+
+// ParseTimeWindow builds a window ending at now from its type: all-time
+// (default), 7d, 30d, or custom with RFC3339 start and end
+func ParseTimeWindow(windowType, start, end string, now time.Time) (TimeWindow, error) {
+	switch windowType {
+	case "", "all-time":
+		return TimeWindow{Type: "all-time"}, nil
+	case "7d":
+		return TimeWindow{Start: now.AddDate(0, 0, -7), End: now, Type: windowType}, nil
+	case "30d":
+		return TimeWindow{Start: now.AddDate(0, 0, -30), End: now, Type: windowType}, nil
+	case "custom":
+		startTime, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return TimeWindow{}, fmt.Errorf("invalid start: %v", err)
+		}
+		endTime, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return TimeWindow{}, fmt.Errorf("invalid end: %v", err)
+		}
+		return TimeWindow{Start: startTime, End: endTime, Type: windowType}, nil
+	default:
+		return TimeWindow{}, fmt.Errorf("unknown window %q (use all-time, 7d, 30d or custom)", windowType)
+	}
+}
+
 // V2 API handlers for new schema
 
-// handleV2SessionsList handles GET /api/v2/sessions?org_id=X&user_id=Y&limit=N
+// handleV2SessionsList handles GET /api/v2/sessions?org_id=X&user_id=Y&limit=N[&stream=true]
 func (s *APIServer) handleV2SessionsList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if r.URL.Query().Get("stream") == "true" {
+		s.streamV2Sessions(w, r)
+		return
+	}
+
 	// Get query params
 	orgID := r.URL.Query().Get("org_id")
 	userID := r.URL.Query().Get("user_id")
@@ -655,9 +1324,9 @@ func (s *APIServer) handleV2SessionsList(w http.ResponseWriter, r *http.Request)
 	var err error
 
 	if userID != "" {
-		sessions, err = s.store.GetSessionsByUser(userID, limit)
+		sessions, err = s.store.GetSessionsByUser(userID, nil, limit)
 	} else if orgID != "" {
-		sessions, err = s.store.GetSessionsByOrg(orgID, limit)
+		sessions, err = s.store.GetSessionsByOrg(orgID, nil, limit)
 	} else {
 		sessions, err = s.store.GetAllSessions(limit)
 	}
@@ -682,7 +1351,7 @@ func (s *APIServer) handleV2SessionsList(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleV2Session handles GET /api/v2/sessions/{session_id}[/tools]
+// handleV2Session handles GET /api/v2/sessions/{session_id}[/tools|/prompts|/timeline]
 func (s *APIServer) handleV2Session(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -709,6 +1378,9 @@ func (s *APIServer) handleV2Session(w http.ResponseWriter, r *http.Request) {
 		case "prompts":
 			s.handleV2SessionPrompts(w, r, sessionID)
 			return
+		case "timeline":
+			s.handleV2SessionTimeline(w, r, sessionID)
+			return
 		default:
 			http.Error(w, "Unknown sub-resource", http.StatusNotFound)
 			return
@@ -722,15 +1394,168 @@ func (s *APIServer) handleV2Session(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	traces, err := s.store.GetSessionTraces(sessionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving session traces: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	response := buildV2SessionResponse(session)
+	response["environment"] = map[string]interface{}{
+		"client_name":    jsonString(session.ClientName),
+		"client_version": jsonString(session.ClientVersion),
+		"terminal_type":  jsonString(session.TerminalType),
+		"host_arch":      jsonString(session.HostArch),
+		"os_type":        jsonString(session.OSType),
+		"os_version":     jsonString(session.OSVersion),
+		"changed":        session.EnvChanged,
+	}
+	response["total_active_time_seconds"] = session.TotalActiveTimeSeconds
+	response["traces"] = s.buildTraceList(traces)
+	if throughput, active := s.engine.SessionThroughput(sessionID); active {
+		response["throughput"] = buildThroughputResponse(throughput)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleV2ActiveSessions handles GET /api/v2/sessions/active
+func (s *APIServer) handleV2ActiveSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	active := s.engine.ActiveSessions()
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].Throughput.TokensPerSecond > active[j].Throughput.TokensPerSecond
+	})
+
+	sessionList := make([]map[string]interface{}, len(active))
+	for i, session := range active {
+		sessionList[i] = map[string]interface{}{
+			"session_id":      session.SessionID,
+			"organization_id": session.OrganizationID,
+			"throughput":      buildThroughputResponse(session.Throughput),
+		}
+	}
+
+	response := map[string]interface{}{
+		"sessions": sessionList,
+		"count":    len(active),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleMetrics handles GET /metrics in the Prometheus text format
+func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	usage, err := s.store.GetUsageTotals(s.metricsUserLabel)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving usage totals: %v", err), http.StatusInternalServerError)
+		return
+	}
+	tools, err := s.store.GetToolCallTotals()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving tool call totals: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	orgs := s.engine.OrgThroughput()
+	orgIDs := make([]string, 0, len(orgs))
+	for orgID := range orgs {
+		orgIDs = append(orgIDs, orgID)
+	}
+	sort.Strings(orgIDs)
+	if len(orgIDs) > maxMetricLabelValues {
+		var other Throughput
+		for _, orgID := range orgIDs[maxMetricLabelValues:] {
+			other.TokensPerSecond += orgs[orgID].TokensPerSecond
+			other.RequestsPerMinute += orgs[orgID].RequestsPerMinute
+		}
+		orgIDs = append(orgIDs[:maxMetricLabelValues], otherLabelValue)
+		orgs[otherLabelValue] = other
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metricOrgTokensPerSecond.writeHeader(w)
+	for _, orgID := range orgIDs {
+		metricOrgTokensPerSecond.writeSample(w, orgs[orgID].TokensPerSecond, "organization_id", orgID)
+	}
+
+	metricOrgRequestsPerMinute.writeHeader(w)
+	for _, orgID := range orgIDs {
+		metricOrgRequestsPerMinute.writeSample(w, orgs[orgID].RequestsPerMinute, "organization_id", orgID)
+	}
+
+	metricRejectedRecords.writeHeader(w)
+	metricRejectedRecords.writeSample(w, float64(s.engine.RejectedRecords()))
+
+	metricDroppedPrompts.writeHeader(w)
+	metricDroppedPrompts.writeSample(w, float64(s.engine.DroppedPrompts()))
+
+	// Users only get a label when asked for, since there are many more of
+	// them than organizations
+	usage = capUsageTotals(usage, maxMetricLabelValues)
+	usageLabels := func(t *UsageTotals, labels ...string) []string {
+		base := []string{"organization_id", t.OrganizationID}
+		if s.metricsUserLabel {
+			base = append(base, "user_id", t.UserID)
+		}
+		return append(base, labels...)
+	}
+
+	metricSessionCost.writeHeader(w)
+	for _, t := range usage {
+		metricSessionCost.writeSample(w, t.CostUSD, usageLabels(t)...)
+	}
+
+	metricTokens.writeHeader(w)
+	for _, t := range usage {
+		metricTokens.writeSample(w, float64(t.InputTokens), usageLabels(t, "type", "input")...)
+		metricTokens.writeSample(w, float64(t.OutputTokens), usageLabels(t, "type", "output")...)
+		metricTokens.writeSample(w, float64(t.CacheReadTokens), usageLabels(t, "type", "cache_read")...)
+		metricTokens.writeSample(w, float64(t.CacheCreationTokens), usageLabels(t, "type", "cache_creation")...)
+	}
+
+	metricToolCalls.writeHeader(w)
+	for _, t := range capToolTotals(tools, maxMetricLabelValues) {
+		metricToolCalls.writeSample(w, float64(t.Successes), "tool", t.ToolName, "result", "success")
+		metricToolCalls.writeSample(w, float64(t.Failures), "tool", t.ToolName, "result", "failure")
+	}
+}
+
+// buildThroughputResponse builds the JSON response for live throughput
+func buildThroughputResponse(throughput Throughput) map[string]interface{} {
+	return map[string]interface{}{
+		"tokens_per_second":   throughput.TokensPerSecond,
+		"requests_per_minute": throughput.RequestsPerMinute,
+		"window_seconds":      throughputWindow.Seconds(),
+	}
+}
+
 // handleV2SessionPrompts handles GET /api/v2/sessions/{session_id}/prompts
 func (s *APIServer) handleV2SessionPrompts(w http.ResponseWriter, r *http.Request, sessionID string) {
-	prompts, err := s.store.GetSessionPrompts(sessionID)
+	limit, err := parsePromptPageSize(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, err := ParsePromptCursor(r.URL.Query().Get("after"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prompts, next, err := s.store.GetSessionPromptsPage(sessionID, after, limit)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving session prompts: %v", err), http.StatusInternalServerError)
 		return
@@ -746,10 +1571,53 @@ func (s *APIServer) handleV2SessionPrompts(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	response := map[string]interface{}{
+		"session_id":  sessionID,
+		"count":       len(prompts),
+		"prompts":     promptList,
+		"next_cursor": jsonPromptCursor(next),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleV2SessionTimeline handles GET /api/v2/sessions/{session_id}/timeline
+func (s *APIServer) handleV2SessionTimeline(w http.ResponseWriter, r *http.Request, sessionID string) {
+	turns, err := s.store.GetSessionTurns(sessionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving session turns: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	traces, err := s.store.GetSessionTraces(sessionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving session traces: %v", err), http.StatusInternalServerError)
+		return
+	}
+	turnTraces := tracesByTurn(turns, traces)
+
+	turnList := make([]map[string]interface{}, len(turns))
+	for i, turn := range turns {
+		turnList[i] = map[string]interface{}{
+			"turn_index":    turn.TurnIndex,
+			"start_time":    turn.StartTime.Format(time.RFC3339Nano),
+			"end_time":      turn.EndTime.Format(time.RFC3339Nano),
+			"prompted":      turn.Prompted,
+			"request_count": turn.RequestCount,
+			"tokens": map[string]interface{}{
+				"input":  turn.InputTokens,
+				"output": turn.OutputTokens,
+				"total":  turn.InputTokens + turn.OutputTokens,
+			},
+			"cost_usd": turn.CostUSD,
+			"traces":   s.buildTraceList(turnTraces[i]),
+		}
+	}
+
 	response := map[string]interface{}{
 		"session_id": sessionID,
-		"count":      len(prompts),
-		"prompts":    promptList,
+		"turns":      turnList,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -767,14 +1635,6 @@ func (s *APIServer) handleV2SessionTools(w http.ResponseWriter, r *http.Request,
 	// Build response
 	toolList := make([]map[string]interface{}, len(tools))
 	for i, tool := range tools {
-		successRate := 0.0
-		if tool.CallCount > 0 {
-			successRate = float64(tool.SuccessCount) / float64(tool.CallCount)
-		}
-		avgDurationMS := 0.0
-		if tool.CallCount > 0 {
-			avgDurationMS = tool.TotalExecutionTimeMS / float64(tool.CallCount)
-		}
 		avgResultSizeBytes := int64(0)
 		if tool.CallCount > 0 {
 			avgResultSizeBytes = tool.TotalResultSizeBytes / int64(tool.CallCount)
@@ -785,9 +1645,12 @@ func (s *APIServer) handleV2SessionTools(w http.ResponseWriter, r *http.Request,
 			"call_count":              tool.CallCount,
 			"success_count":           tool.SuccessCount,
 			"failure_count":           tool.FailureCount,
-			"success_rate":            successRate,
+			"success_rate":            ratio(float64(tool.SuccessCount), float64(tool.CallCount)),
 			"total_execution_time_ms": tool.TotalExecutionTimeMS,
-			"avg_execution_time_ms":   avgDurationMS,
+			"avg_execution_time_ms":   ratio(tool.TotalExecutionTimeMS, float64(tool.CallCount)),
+			"p50_execution_time_ms":   tool.P50ExecutionTimeMS,
+			"p95_execution_time_ms":   tool.P95ExecutionTimeMS,
+			"p99_execution_time_ms":   tool.P99ExecutionTimeMS,
 			"decisions": map[string]interface{}{
 				"auto_approved":  tool.AutoApprovedCount,
 				"user_approved":  tool.UserApprovedCount,
@@ -855,11 +1718,13 @@ func (s *APIServer) handleV2Tools(w http.ResponseWriter, r *http.Request) {
 
 // buildV2SessionResponse builds the JSON response for a session
 func buildV2SessionResponse(session *Session) map[string]interface{} {
-	response := map[string]interface{}{
-		"session_id":      session.SessionID,
-		"organization_id": session.OrganizationID,
-		"user_id":         session.UserID,
-		"start_time":      session.StartTime.Format(time.RFC3339),
+	return map[string]interface{}{
+		"session_id":       session.SessionID,
+		"organization_id":  session.OrganizationID,
+		"user_id":          session.UserID,
+		"start_time":       session.StartTime.Format(time.RFC3339),
+		"end_time":         jsonTime(session.EndTime),
+		"duration_seconds": sessionDuration(session),
 		"costs": map[string]interface{}{
 			"total_usd": session.TotalCostUSD,
 		},
@@ -871,16 +1736,15 @@ func buildV2SessionResponse(session *Session) map[string]interface{} {
 			"total":          session.TotalInputTokens + session.TotalOutputTokens + session.TotalCacheReadTokens,
 		},
 		"tool_call_count": session.ToolCallCount,
+		"first_model":     jsonString(session.FirstModel),
+		"primary_model":   jsonString(session.PrimaryModel),
+		"turns": map[string]interface{}{
+			"count":               session.TurnCount,
+			"avg_tokens_per_turn": session.AvgTokensPerTurn,
+		},
 		"metadata": map[string]interface{}{
 			"created_at": session.CreatedAt.Format(time.RFC3339),
 			"updated_at": session.UpdatedAt.Format(time.RFC3339),
 		},
 	}
-
-	if !session.EndTime.IsZero() {
-		response["end_time"] = session.EndTime.Format(time.RFC3339)
-		response["duration_seconds"] = session.EndTime.Sub(session.StartTime).Seconds()
-	}
-
-	return response
 }
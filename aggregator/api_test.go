@@ -0,0 +1,383 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestAPIServer(t *testing.T, dbPath string) *APIServer {
+	t.Helper()
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		os.Remove(dbPath)
+	})
+
+	return NewAPIServer(0, store, NewEngine(store))
+}
+
+func TestAPIServerBindAddr(t *testing.T) {
+	server := NewAPIServer(8080, nil, nil)
+	if server.httpServer.Addr != ":8080" {
+		t.Errorf("Expected all interfaces by default, got %s", server.httpServer.Addr)
+	}
+
+	server.SetBindAddr("localhost")
+	if server.httpServer.Addr != "localhost:8080" {
+		t.Errorf("Expected localhost:8080, got %s", server.httpServer.Addr)
+	}
+	server.SetBindAddr("::1")
+	if server.httpServer.Addr != "[::1]:8080" {
+		t.Errorf("Expected [::1]:8080, got %s", server.httpServer.Addr)
+	}
+}
+
+func TestAPIServerHandlerServesWithoutStart(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_handler.db")
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+}
+
+func TestSessionStatsUnknownSessionReturnsNotFoundBody(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_unknown.db")
+
+	rec := httptest.NewRecorder()
+	server.handleSessionStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/session/missing", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body["found"] != false {
+		t.Errorf("Expected found=false, got %v", body["found"])
+	}
+	if body["session_id"] != "missing" {
+		t.Errorf("Expected session_id missing, got %v", body["session_id"])
+	}
+}
+
+func TestSessionStatsUnknownSessionStrictReturns404(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_strict.db")
+
+	rec := httptest.NewRecorder()
+	server.handleSessionStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/session/missing?strict=true", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rec.Code)
+	}
+}
+
+func TestSessionStatsKnownSessionReturnsFound(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_found.db")
+
+	now := time.Now()
+	if err := server.store.UpsertSession(&Session{
+		SessionID:      "session-1",
+		UserID:         "user-1",
+		OrganizationID: "org-1",
+		StartTime:      now,
+		EndTime:        now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}); err != nil {
+		t.Fatalf("Failed to upsert session: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleSessionStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/session/session-1?strict=true", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body["found"] != true {
+		t.Errorf("Expected found=true, got %v", body["found"])
+	}
+}
+
+func TestSessionStatsReportsAPIErrorRate(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_error_rate.db")
+	engine := newEngine(server.store)
+
+	for _, body := range []string{
+		"claude_code.api_request", "claude_code.api_request", "claude_code.api_request", "claude_code.api_error",
+	} {
+		engine.ProcessLog(&LogRecord{
+			Timestamp: time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC),
+			SessionID: "failing-session",
+			UserID:    "user-1",
+			Body:      body,
+		})
+	}
+	engine.FlushCache()
+
+	var storedRate float64
+	if err := server.store.db.QueryRow(`SELECT api_error_rate FROM sessions WHERE session_id = ?`, "failing-session").Scan(&storedRate); err != nil {
+		t.Fatalf("Failed to read session: %v", err)
+	}
+	if storedRate != 0.25 {
+		t.Errorf("Expected an error rate of 0.25 in sessions, got %f", storedRate)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleSessionStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/session/failing-session", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var body struct {
+		Activity struct {
+			APIRequests   int     `json:"api_requests"`
+			APIErrorCount int     `json:"api_error_count"`
+			APIErrorRate  float64 `json:"api_error_rate"`
+		} `json:"activity"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Activity.APIRequests != 3 || body.Activity.APIErrorCount != 1 || body.Activity.APIErrorRate != 0.25 {
+		t.Errorf("Expected 3 requests, 1 error and a 0.25 error rate, got %+v", body.Activity)
+	}
+}
+
+func TestSessionStatsReportsRealCostByModel(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_cost_by_model.db")
+	engine := newEngine(server.store)
+
+	timestamp := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	for model, usage := range map[string]struct {
+		cost     float64
+		input    int64
+		requests int
+	}{
+		"claude-opus-4":  {cost: 0.90, input: 3000, requests: 3},
+		"claude-haiku-4": {cost: 0.10, input: 1000, requests: 1},
+	} {
+		// Each request reports its own cost
+		for i := 0; i < usage.requests; i++ {
+			engine.ProcessMetric(&MetricRecord{
+				Timestamp:   timestamp,
+				SessionID:   "mixed-session",
+				UserID:      "user-1",
+				MetricName:  "claude_code.cost.usage",
+				MetricValue: usage.cost / float64(usage.requests),
+				Attributes:  map[string]string{"model": model},
+			})
+		}
+		engine.ProcessMetric(&MetricRecord{
+			Timestamp:   timestamp,
+			SessionID:   "mixed-session",
+			UserID:      "user-1",
+			MetricName:  "claude_code.token.usage",
+			MetricValue: usage.input,
+			Attributes:  map[string]string{"model": model, "type": "input"},
+		})
+	}
+	engine.FlushCache()
+
+	rec := httptest.NewRecorder()
+	server.handleSessionStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/session/mixed-session", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	type modelUsage struct {
+		CostUSD      float64 `json:"cost_usd"`
+		RequestCount int     `json:"request_count"`
+		Tokens       struct {
+			Input int64 `json:"input"`
+		} `json:"tokens"`
+	}
+	var body struct {
+		Costs struct {
+			TotalUSD float64               `json:"total_usd"`
+			ByModel  map[string]modelUsage `json:"by_model"`
+		} `json:"costs"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if math.Abs(body.Costs.TotalUSD-1.0) > 1e-9 {
+		t.Errorf("Expected a total of 1.00, got %f", body.Costs.TotalUSD)
+	}
+	opus, haiku := body.Costs.ByModel["claude-opus-4"], body.Costs.ByModel["claude-haiku-4"]
+	if math.Abs(opus.CostUSD-0.90) > 1e-9 || opus.RequestCount != 3 || opus.Tokens.Input != 3000 {
+		t.Errorf("Expected opus at 0.90 over 3 requests and 3000 input tokens, got %+v", opus)
+	}
+	if math.Abs(haiku.CostUSD-0.10) > 1e-9 || haiku.RequestCount != 1 || haiku.Tokens.Input != 1000 {
+		t.Errorf("Expected haiku at 0.10 over 1 request and 1000 input tokens, got %+v", haiku)
+	}
+}
+
+func TestAPIErrorRateWithoutCalls(t *testing.T) {
+	if rate := apiErrorRate(0, 0); rate != 0 {
+		t.Errorf("Expected 0 before any API call, got %f", rate)
+	}
+	if rate := apiErrorRate(0, 2); rate != 1 {
+		t.Errorf("Expected 1 when every call failed, got %f", rate)
+	}
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_version.db")
+	server.SetFeatures(map[string]bool{"aggregator": true})
+
+	rec := httptest.NewRecorder()
+	server.handleVersion(rec, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	for _, field := range []string{"version", "commit", "build_date", "go_version"} {
+		if value, _ := body[field].(string); value == "" {
+			t.Errorf("Expected %s to be populated, got %v", field, body[field])
+		}
+	}
+
+	latest, err := server.store.MigrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	if body["schema_version"] != float64(latest[len(latest)-1].Version) {
+		t.Errorf("Expected schema version %d, got %v", latest[len(latest)-1].Version, body["schema_version"])
+	}
+
+	features, _ := body["features"].(map[string]interface{})
+	if features["aggregator"] != true {
+		t.Errorf("Expected aggregator feature, got %v", body["features"])
+	}
+	if _, ok := body["uptime_seconds"].(float64); !ok {
+		t.Errorf("Expected uptime_seconds, got %v", body["uptime_seconds"])
+	}
+}
+
+func TestModelsStatsRange(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_models_range.db")
+	now := time.Now().Truncate(time.Second)
+
+	for _, spec := range []SessionSpec{
+		{SessionID: "recent", StartTime: now.Add(-time.Hour), Models: []ModelSpec{
+			{Model: "model-a", Requests: 4, CostUSD: 2, InputTokens: 100, LatencyMS: 400},
+			{Model: "model-b", Requests: 1, CostUSD: 0.5},
+		}},
+		{SessionID: "old", StartTime: now.AddDate(0, 0, -10), Models: []ModelSpec{
+			{Model: "model-b", Requests: 10, CostUSD: 9},
+		}},
+	} {
+		if err := SeedSession(server.store, spec); err != nil {
+			t.Fatalf("Failed to seed %s: %v", spec.SessionID, err)
+		}
+	}
+
+	get := func(query string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats/models"+query, nil))
+		var body map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+
+	// All time, model b leads on cost
+	code, body := get("")
+	models, _ := body["models"].([]interface{})
+	if code != http.StatusOK || len(models) != 2 || models[0].(map[string]interface{})["model"] != "model-b" || body["from"] != nil {
+		t.Fatalf("Expected both models led by model-b over all time, got %d: %v", code, body)
+	}
+
+	// Over the last day only the recent session counts, so model a leads
+	from := now.AddDate(0, 0, -1).Format(time.RFC3339)
+	code, body = get("?from=" + from)
+	models, _ = body["models"].([]interface{})
+	if code != http.StatusOK || len(models) != 2 || body["from"] != from || body["to"] != nil {
+		t.Fatalf("Expected both models since %s, got %d: %v", from, code, body)
+	}
+	top := models[0].(map[string]interface{})
+	if top["model"] != "model-a" || top["total_cost_usd"] != 2.0 || top["avg_latency_ms"] != 100.0 {
+		t.Errorf("Expected model-a at $2 and 100ms, got %v", top)
+	}
+	if cost := models[1].(map[string]interface{})["total_cost_usd"]; cost != 0.5 {
+		t.Errorf("Expected only the recent model-b cost, got %v", cost)
+	}
+
+	// Up to two days ago only the old session counts
+	code, body = get("?to=" + now.AddDate(0, 0, -2).Format(time.RFC3339) + "&limit=1")
+	models, _ = body["models"].([]interface{})
+	if code != http.StatusOK || len(models) != 1 || models[0].(map[string]interface{})["total_cost_usd"] != 9.0 {
+		t.Errorf("Expected only the old model-b session, got %d: %v", code, body)
+	}
+
+	for _, query := range []string{"?from=yesterday", "?from=" + from + "&to=" + from} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, code)
+		}
+	}
+}
+
+func TestToolsStats(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_tools.db")
+
+	for i, tools := range [][]ToolSpec{
+		{{Name: "Read", Successes: 3, Failures: 1, ExecutionTimeMS: 100}, {Name: "Edit", Successes: 2, ExecutionTimeMS: 30}},
+		{{Name: "Read", Successes: 4, ExecutionTimeMS: 60}, {Name: "Bash", Successes: 2, ExecutionTimeMS: 500}},
+	} {
+		spec := SessionSpec{SessionID: fmt.Sprintf("s%d", i), StartTime: time.Now(), Tools: tools}
+		if err := SeedSession(server.store, spec); err != nil {
+			t.Fatalf("Failed to seed %s: %v", spec.SessionID, err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats/tools?limit=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	var body struct {
+		Tools []struct {
+			ToolName        string  `json:"tool_name"`
+			TotalExecutions int     `json:"total_executions"`
+			SuccessRate     float64 `json:"success_rate"`
+			AvgDurationMS   float64 `json:"avg_duration_ms"`
+			UsedInSessions  int     `json:"used_in_sessions"`
+		} `json:"tools"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// Read leads on executions; Bash and Edit tie, so the limit keeps Bash
+	if len(body.Tools) != 2 || body.Tools[0].ToolName != "Read" || body.Tools[1].ToolName != "Bash" {
+		t.Fatalf("Expected Read then Bash, got %+v", body.Tools)
+	}
+	read := body.Tools[0]
+	if read.TotalExecutions != 8 || read.SuccessRate != 0.875 || read.AvgDurationMS != 20 || read.UsedInSessions != 2 {
+		t.Errorf("Expected Read with 8 executions at 87.5%% over 2 sessions averaging 20ms, got %+v", read)
+	}
+}
@@ -0,0 +1,96 @@
+package aggregator
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// backfillArchives processes filePath's rotated generations, plain or
+// gzipped, from oldest to newest. It runs the first time the processor meets
+// a file, so data rotated away before the aggregator started is not lost.
+// Archives are only ever read whole, and each is recorded as processed once
+// done so a restart partway through the backfill skips it. An unreadable
+// archive is logged and skipped; only failing to track progress is an error.
+func (p *Processor) backfillArchives(filePath string) error {
+	paths, err := generationsOldestFirst(filePath)
+	if err != nil {
+		return err
+	}
+	filename := p.relativeName(filePath)
+
+	for _, path := range paths[:len(paths)-1] {
+		if err := p.processArchive(filename, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processArchive reads the rotated generation at path whole, unless it is
+// already recorded as processed, and then records it. An unreadable archive
+// is logged and skipped; only failing to track progress is an error.
+func (p *Processor) processArchive(filename, path string) error {
+	reader, inode, err := openArchive(path)
+	if err != nil {
+		log.Printf("Skipping archive %s: %v", path, err)
+		return nil
+	}
+
+	processed, err := p.store.IsArchiveProcessed(filename, inode)
+	if err != nil || processed {
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to check archive %s: %w", path, err)
+		}
+		return nil
+	}
+
+	linesProcessed, err := p.processRotatedLines(filename, path, reader, inode, 0)
+	reader.Close()
+	if err != nil {
+		log.Printf("Error reading archive %s after %d lines: %v", path, linesProcessed, err)
+		return nil
+	}
+	if err := p.store.MarkArchiveProcessed(filename, inode); err != nil {
+		return fmt.Errorf("failed to record archive %s: %w", path, err)
+	}
+	log.Printf("Processed %d lines from archive %s", linesProcessed, path)
+	return nil
+}
+
+// openArchive opens a rotated generation for reading from the start,
+// decompressing it when gzipped. Its inode is the one the file had before
+// compression, from the gzip header the collector writes, so a generation
+// keeps its identity whether or not it has been compressed yet; archives
+// compressed by something else fall back to their own inode.
+func openArchive(path string) (io.ReadCloser, uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open archive: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to stat archive: %w", err)
+	}
+	inode := getInode(info)
+
+	if !strings.HasSuffix(path, ".gz") {
+		return file, inode, nil
+	}
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to read archive %s: %w", path, err)
+	}
+	var original uint64
+	if _, err := fmt.Sscanf(zr.Comment, gzipInodeComment, &original); err == nil {
+		inode = original
+	}
+	return gzipFile{Reader: zr, file: file}, inode, nil
+}
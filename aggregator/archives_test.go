@@ -0,0 +1,149 @@
+package aggregator
+
+import (
+	"compress/gzip"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// workerCostLines joins workerCostLine for lines first up to but excluding last
+func workerCostLines(first, last int) string {
+	var b strings.Builder
+	for i := first; i < last; i++ {
+		b.WriteString(workerCostLine(i) + "\n")
+	}
+	return b.String()
+}
+
+// writeGzipArchive writes content to path gzipped, with comment in the header
+func writeGzipArchive(t *testing.T, path, comment, content string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	zw.Comment = comment
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close %s: %v", path, err)
+	}
+}
+
+// totalCost sums the cost of every session the engine has seen
+func totalCost(engine *Engine) float64 {
+	engine.cacheMutex.RLock()
+	defer engine.cacheMutex.RUnlock()
+	var total float64
+	for _, session := range engine.sessionsCache {
+		total += session.TotalCostUSD
+	}
+	return total
+}
+
+// workerCost is the total cost of workerCostLine for lines first up to but excluding last
+func workerCost(first, last int) float64 {
+	var total float64
+	for i := first; i < last; i++ {
+		total += float64(i%7)*0.01 + 0.001
+	}
+	return total
+}
+
+func TestProcessorBackfillsRotatedArchives(t *testing.T) {
+	dbPath := "./test_backfill_archives.db"
+	defer os.Remove(dbPath)
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Three generations rotated away before the aggregator started: one the
+	// collector compressed, one compressed by something else and one not yet
+	// compressed, then the live file
+	dataDir := t.TempDir()
+	livePath := filepath.Join(dataDir, "metrics.jsonl")
+	writeGzipArchive(t, livePath+".3.gz", fmt.Sprintf(gzipInodeComment, 424242), workerCostLines(0, 10))
+	writeGzipArchive(t, livePath+".2.gz", "", workerCostLines(10, 20))
+	appendToFile(t, livePath+".1", workerCostLines(20, 30))
+	appendToFile(t, livePath, workerCostLines(30, 40))
+
+	engine := newEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+	if err := processor.ProcessFile(livePath); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	if got, want := totalCost(engine), workerCost(0, 40); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected every generation processed for a cost of %f, got %f", want, got)
+	}
+	engine.cacheMutex.RLock()
+	start := engine.sessionsCache["session-0"].StartTime
+	engine.cacheMutex.RUnlock()
+	if !start.Equal(workerLinesStart) {
+		t.Errorf("Expected the oldest archive first, starting session-0 at %v, got %v", workerLinesStart, start)
+	}
+
+	for _, path := range []string{livePath + ".3.gz", livePath + ".2.gz", livePath + ".1"} {
+		reader, inode, err := openArchive(path)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", path, err)
+		}
+		reader.Close()
+		if processed, err := store.IsArchiveProcessed("metrics.jsonl", inode); err != nil || !processed {
+			t.Errorf("Expected %s recorded as processed, got %v (%v)", path, processed, err)
+		}
+	}
+
+	// The next processor picks up where this one left off without reading
+	// any archive again
+	engine = newEngine(store)
+	processor = NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+	appendToFile(t, livePath, workerCostLines(40, 45))
+	if err := processor.ProcessFile(livePath); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	if got, want := totalCost(engine), workerCost(40, 45); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected only the new lines for a cost of %f, got %f", want, got)
+	}
+}
+
+func TestProcessorBackfillSkipsProcessedArchives(t *testing.T) {
+	dbPath := "./test_backfill_resume.db"
+	defer os.Remove(dbPath)
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	livePath := filepath.Join(dataDir, "metrics.jsonl")
+	writeGzipArchive(t, livePath+".2.gz", fmt.Sprintf(gzipInodeComment, 424242), workerCostLines(0, 10))
+	if err := os.WriteFile(livePath+".1.gz", []byte("corrupt"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt archive: %v", err)
+	}
+	appendToFile(t, livePath, workerCostLines(10, 20))
+
+	// A backfill interrupted after the oldest archive
+	if err := store.MarkArchiveProcessed("metrics.jsonl", 424242); err != nil {
+		t.Fatalf("Failed to mark archive: %v", err)
+	}
+
+	engine := newEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+	if err := processor.ProcessFile(livePath); err != nil {
+		t.Fatalf("Expected an unreadable archive to be skipped, got %v", err)
+	}
+	if got, want := totalCost(engine), workerCost(10, 20); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected only the live file for a cost of %f, got %f", want, got)
+	}
+}
@@ -0,0 +1,68 @@
+package aggregator
+
+// OTLP attributes can be set at several levels of a request. The most specific
+// level wins: a log record's or span's own attributes, then a metric data
+// point's, then the instrumentation scope's, then the resource's. An empty
+// value counts as unset, so a blank attribute never hides one set further out.
+//
+// Extraction code passes the levels it has most specific first and never
+// merges attributes by hand, so every signal and both the file and direct
+// ingestion paths resolve the same way.
+
+// Identity is who a record belongs to and the service that sent it
+type Identity struct {
+	SessionID      string
+	UserID         string
+	OrganizationID string
+	ServiceName    string
+}
+
+// ResolveAttr returns key's value from the first of levels, ordered most
+// specific first, that has it set
+func ResolveAttr(key string, levels ...map[string]string) string {
+	for _, attrs := range levels {
+		if value := attrs[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// ResolveIdentity resolves a record's identifiers from its attribute levels,
+// ordered most specific first
+func ResolveIdentity(levels ...map[string]string) Identity {
+	return Identity{
+		SessionID:      ResolveAttr("session.id", levels...),
+		UserID:         ResolveAttr("user.id", levels...),
+		OrganizationID: ResolveAttr("organization.id", levels...),
+		ServiceName:    ResolveAttr("service.name", levels...),
+	}
+}
+
+// mergeAttrs flattens levels, ordered most specific first, into one map
+// holding what ResolveAttr would return for each key
+func mergeAttrs(levels ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for i := len(levels) - 1; i >= 0; i-- {
+		for key, value := range levels[i] {
+			if value != "" {
+				merged[key] = value
+			}
+		}
+	}
+	return merged
+}
+
+// mergeLogAttrs adds the enclosing levels' string attributes to a log
+// record's attribute values wherever the record leaves them unset, so engine
+// lookups like the model see the same precedence as the identifiers
+func mergeLogAttrs(logAttrs map[string]interface{}, logAttrsStr map[string]string, enclosing ...map[string]string) map[string]interface{} {
+	for key, value := range mergeAttrs(enclosing...) {
+		_, set := logAttrs[key]
+		if str, isString := logAttrsStr[key]; set && (!isString || str != "") {
+			continue // Set on the record, possibly to a non-string value
+		}
+		logAttrs[key] = map[string]interface{}{"stringValue": value}
+	}
+	return logAttrs
+}
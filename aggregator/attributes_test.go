@@ -0,0 +1,233 @@
+package aggregator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// attributeLevels are the levels an attribute can be set at, most specific first
+var attributeLevels = []string{"record", "datapoint", "scope", "resource"}
+
+// identityKeys are the attributes ResolveIdentity reads
+var identityKeys = []string{"session.id", "user.id", "organization.id", "service.name"}
+
+func TestResolveAttrPrecedence(t *testing.T) {
+	// Every combination of levels setting every identity key, each level with
+	// its own conflicting value
+	for mask := 0; mask < 1<<len(attributeLevels); mask++ {
+		levels := make([]map[string]string, len(attributeLevels))
+		var set []string
+		expected := ""
+		for i, level := range attributeLevels {
+			levels[i] = map[string]string{}
+			if mask&(1<<i) == 0 {
+				continue
+			}
+			set = append(set, level)
+			for _, key := range identityKeys {
+				levels[i][key] = level + ":" + key
+			}
+			if expected == "" {
+				expected = level
+			}
+		}
+
+		t.Run(fmt.Sprintf("set=%s", strings.Join(set, "+")), func(t *testing.T) {
+			merged := mergeAttrs(levels...)
+			for _, key := range identityKeys {
+				want := ""
+				if expected != "" {
+					want = expected + ":" + key
+				}
+				if got := ResolveAttr(key, levels...); got != want {
+					t.Errorf("ResolveAttr(%q) = %q, want %q", key, got, want)
+				}
+				if merged[key] != want {
+					t.Errorf("mergeAttrs[%q] = %q, want %q", key, merged[key], want)
+				}
+			}
+
+			id := ResolveIdentity(levels...)
+			got := []string{id.SessionID, id.UserID, id.OrganizationID, id.ServiceName}
+			for i, key := range identityKeys {
+				if got[i] != ResolveAttr(key, levels...) {
+					t.Errorf("ResolveIdentity %s = %q, want %q", key, got[i], ResolveAttr(key, levels...))
+				}
+			}
+		})
+	}
+}
+
+func TestResolveAttrSkipsEmptyValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		levels   []map[string]string
+		expected string
+	}{
+		{"no levels", nil, ""},
+		{"nil levels", []map[string]string{nil, nil}, ""},
+		{"empty record value", []map[string]string{{"model": ""}, {"model": "scope-model"}}, "scope-model"},
+		{"empty everywhere but resource", []map[string]string{{"model": ""}, {}, {"model": ""}, {"model": "resource-model"}}, "resource-model"},
+		{"only empty values", []map[string]string{{"model": ""}, {"model": ""}}, ""},
+		{"nil record level", []map[string]string{nil, {"model": "scope-model"}}, "scope-model"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolveAttr("model", tt.levels...); got != tt.expected {
+				t.Errorf("ResolveAttr = %q, want %q", got, tt.expected)
+			}
+			if got := mergeAttrs(tt.levels...)["model"]; got != tt.expected {
+				t.Errorf("mergeAttrs = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMergeLogAttrsKeepsRecordValues(t *testing.T) {
+	logAttrs := map[string]interface{}{
+		"model":    map[string]interface{}{"stringValue": "record-model"},
+		"terminal": map[string]interface{}{"stringValue": ""},
+		"cost_usd": map[string]interface{}{"doubleValue": 0.5},
+	}
+	logAttrsStr := map[string]string{"model": "record-model", "terminal": ""}
+	enclosing := map[string]string{"model": "scope-model", "terminal": "vscode", "cost_usd": "9.99", "os.type": "linux"}
+
+	merged := mergeLogAttrs(logAttrs, logAttrsStr, enclosing)
+	expected := map[string]string{"model": "record-model", "terminal": "vscode", "os.type": "linux"}
+	for key, want := range expected {
+		if got := extractString(merged, key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if got := extractFloat(merged, "cost_usd"); got != 0.5 {
+		t.Errorf("Expected the record's non-string cost_usd kept, got %g", got)
+	}
+}
+
+// recordCapture keeps every record it is handed
+type recordCapture struct {
+	metrics []*MetricRecord
+	logs    []*LogRecord
+	traces  []*TraceRecord
+}
+
+func (c *recordCapture) ProcessMetric(record *MetricRecord) { c.metrics = append(c.metrics, record) }
+func (c *recordCapture) ProcessLog(record *LogRecord)       { c.logs = append(c.logs, record) }
+func (c *recordCapture) ProcessTrace(record *TraceRecord)   { c.traces = append(c.traces, record) }
+
+// levelAttributes sets session.id, service.name and model to values naming
+// level when set is true
+func levelAttributes(level string, set bool) []*commonpb.KeyValue {
+	if !set {
+		return nil
+	}
+	return []*commonpb.KeyValue{
+		stringKV("session.id", level+"-session"),
+		stringKV("service.name", level+"-service"),
+		stringKV("model", level+"-model"),
+	}
+}
+
+// TestRecordAttributePrecedence checks every signal resolves attributes set at
+// any combination of its levels the same way, through both the file and the
+// direct ingestion paths
+func TestRecordAttributePrecedence(t *testing.T) {
+	base := uint64(time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC).UnixNano())
+	receivedAt := time.Date(2025, 6, 1, 10, 0, 5, 0, time.UTC)
+
+	// The record level is the log record, span or data point
+	levels := []string{"record", "scope", "resource"}
+	for mask := 0; mask < 1<<len(levels); mask++ {
+		set := make([]bool, len(levels))
+		var names []string
+		expected := ""
+		for i, level := range levels {
+			set[i] = mask&(1<<i) != 0
+			if set[i] {
+				names = append(names, level)
+				if expected == "" {
+					expected = level
+				}
+			}
+		}
+		want := func(suffix string) string {
+			if expected == "" {
+				return ""
+			}
+			return expected + "-" + suffix
+		}
+
+		resource := &resourcepb.Resource{Attributes: levelAttributes("resource", set[2])}
+		scope := &commonpb.InstrumentationScope{Name: "claude-code", Attributes: levelAttributes("scope", set[1])}
+		recordAttrs := levelAttributes("record", set[0])
+
+		traces := &tracev1.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+			Resource: resource,
+			ScopeSpans: []*tracepb.ScopeSpans{{Scope: scope, Spans: []*tracepb.Span{
+				{Name: "claude_code.request", StartTimeUnixNano: base, EndTimeUnixNano: base + 1000, Attributes: recordAttrs},
+			}}},
+		}}}
+		metrics := &metricsv1.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Scope: scope, Metrics: []*metricspb.Metric{
+				{Name: "claude_code.cost.usage", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{DataPoints: []*metricspb.NumberDataPoint{
+					{TimeUnixNano: base, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 0.25}, Attributes: recordAttrs},
+				}}}},
+			}}},
+		}}}
+		logs := &logsv1.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: resource,
+			ScopeLogs: []*logspb.ScopeLogs{{Scope: scope, LogRecords: []*logspb.LogRecord{
+				{TimeUnixNano: base, Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "claude_code.api_request"}},
+					Attributes: recordAttrs},
+			}}},
+		}}}
+
+		t.Run(fmt.Sprintf("set=%s", strings.Join(names, "+")), func(t *testing.T) {
+			direct := &recordCapture{}
+			ingester := &DirectIngester{engine: direct}
+			ingester.ConsumeTraces(traces, receivedAt, nil)
+			ingester.ConsumeMetrics(metrics, receivedAt, nil)
+			ingester.ConsumeLogs(logs, receivedAt, nil)
+
+			file := &recordCapture{}
+			for signal, req := range map[Signal]proto.Message{SignalTraces: traces, SignalMetrics: metrics, SignalLogs: logs} {
+				if err := decodeLine(signal, protojson.Format(req), file); err != nil {
+					t.Fatalf("Failed to decode signal %d: %v", signal, err)
+				}
+			}
+
+			for path, c := range map[string]*recordCapture{"direct": direct, "file": file} {
+				if len(c.metrics) != 1 || len(c.logs) != 1 || len(c.traces) != 1 {
+					t.Fatalf("%s: expected one record per signal, got %d metrics, %d logs, %d traces",
+						path, len(c.metrics), len(c.logs), len(c.traces))
+				}
+
+				got := map[string][3]string{
+					"metric": {c.metrics[0].SessionID, c.metrics[0].ServiceName, c.metrics[0].Attributes["model"]},
+					"log":    {c.logs[0].SessionID, c.logs[0].ServiceName, extractString(c.logs[0].Attributes, "model")},
+					"trace":  {c.traces[0].SessionID, c.traces[0].ServiceName, c.traces[0].Attributes["model"]},
+				}
+				for signal, values := range got {
+					if values != [3]string{want("session"), want("service"), want("model")} {
+						t.Errorf("%s %s: expected session, service and model from %q, got %v", path, signal, expected, values)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxSessionBatches bounds the batches kept per session, dropping the oldest
+const maxSessionBatches = 100
+
+// batchFromEnvelope reads the batch a JSONL envelope was tagged with by the
+// collector, or nil when it wasn't
+func batchFromEnvelope(envelope map[string]interface{}) *IngestBatch {
+	meta, ok := envelope["batch"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	id, _ := meta["id"].(string)
+	if id == "" {
+		return nil
+	}
+	traceParent, _ := meta["traceparent"].(string)
+	traceState, _ := meta["tracestate"].(string)
+	return &IngestBatch{ID: id, TraceParent: traceParent, TraceState: traceState}
+}
+
+// batchTagger sets the batch of every record decoded from a line before
+// passing it on
+type batchTagger struct {
+	recordHandler
+	batch *IngestBatch
+}
+
+func (t batchTagger) ProcessMetric(record *MetricRecord) {
+	record.Batch = t.batch
+	t.recordHandler.ProcessMetric(record)
+}
+
+func (t batchTagger) ProcessLog(record *LogRecord) {
+	record.Batch = t.batch
+	t.recordHandler.ProcessLog(record)
+}
+
+func (t batchTagger) ProcessTrace(record *TraceRecord) {
+	record.Batch = t.batch
+	t.recordHandler.ProcessTrace(record)
+}
+
+// recordSessionBatch counts a record of signal against the batch it arrived
+// in, evicting the session's oldest batch once it has maxSessionBatches.
+// Untagged records are ignored. Callers must hold cacheMutex.
+func (e *Engine) recordSessionBatch(sessionID string, batch *IngestBatch, signal Signal, timestamp time.Time) {
+	if batch == nil {
+		return
+	}
+	batches, exists := e.sessionBatchesCache[sessionID]
+	if !exists {
+		batches = make(map[string]*SessionBatch)
+		e.sessionBatchesCache[sessionID] = batches
+	}
+
+	sb, exists := batches[batch.ID]
+	if !exists {
+		if len(batches) >= maxSessionBatches {
+			var oldest *SessionBatch
+			for _, b := range batches {
+				if oldest == nil || b.FirstSeen.Before(oldest.FirstSeen) {
+					oldest = b
+				}
+			}
+			delete(batches, oldest.BatchID)
+		}
+		sb = &SessionBatch{
+			SessionID:   sessionID,
+			BatchID:     batch.ID,
+			TraceParent: batch.TraceParent,
+			TraceState:  batch.TraceState,
+			FirstSeen:   timestamp,
+		}
+		batches[batch.ID] = sb
+	}
+	if timestamp.Before(sb.FirstSeen) {
+		sb.FirstSeen = timestamp
+	}
+
+	switch signal {
+	case SignalMetrics:
+		sb.MetricCount++
+	case SignalLogs:
+		sb.LogCount++
+	case SignalTraces:
+		sb.SpanCount++
+	}
+}
+
+// UpsertSessionBatches saves the batches that contributed to a session, then
+// drops all but its maxSessionBatches most recent
+func (s *Store) UpsertSessionBatches(sessionID string, batches []*SessionBatch) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert := `
+	INSERT INTO session_batches (session_id, batch_id, traceparent, tracestate, first_seen, metric_count, log_count, span_count)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(session_id, batch_id) DO UPDATE SET
+		first_seen = MIN(first_seen, excluded.first_seen),
+		metric_count = excluded.metric_count,
+		log_count = excluded.log_count,
+		span_count = excluded.span_count
+	`
+	stmt, err := tx.Prepare(upsert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, b := range batches {
+		if _, err := s.execStmt(tx, stmt, queryUpsertSessionBatches, upsert,
+			sessionID, b.BatchID, nilIfEmpty(b.TraceParent), nilIfEmpty(b.TraceState),
+			b.FirstSeen.UnixNano(), b.MetricCount, b.LogCount, b.SpanCount); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.execOn(tx, queryUpsertSessionBatches, `
+	DELETE FROM session_batches
+	WHERE session_id = ? AND batch_id NOT IN (
+		SELECT batch_id FROM session_batches WHERE session_id = ?
+		ORDER BY first_seen DESC LIMIT ?
+	)
+	`, sessionID, sessionID, maxSessionBatches)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSessionBatches retrieves the batches that contributed to a session,
+// oldest first
+func (s *Store) GetSessionBatches(sessionID string) ([]*SessionBatch, error) {
+	query := `
+	SELECT session_id, batch_id, COALESCE(traceparent, ''), COALESCE(tracestate, ''),
+		first_seen, metric_count, log_count, span_count
+	FROM session_batches
+	WHERE session_id = ?
+	ORDER BY first_seen ASC, batch_id ASC
+	`
+
+	rows, err := s.query(queryGetSessionBatches, query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []*SessionBatch
+	for rows.Next() {
+		var b SessionBatch
+		var firstSeen int64
+		if err := rows.Scan(&b.SessionID, &b.BatchID, &b.TraceParent, &b.TraceState,
+			&firstSeen, &b.MetricCount, &b.LogCount, &b.SpanCount); err != nil {
+			return nil, err
+		}
+		b.FirstSeen = time.Unix(0, firstSeen)
+		batches = append(batches, &b)
+	}
+
+	return batches, rows.Err()
+}
+
+// handleAdminBatches handles GET /api/admin/batches?session_id=X, the tagged
+// ingest batches that contributed records to a session
+func (s *APIServer) handleAdminBatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		http.Error(w, "session_id is required", http.StatusBadRequest)
+		return
+	}
+
+	batches, err := s.store.GetSessionBatches(sessionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving session batches: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("API: Listed %d batches for session %s", len(batches), sessionID)
+
+	batchList := make([]map[string]interface{}, len(batches))
+	for i, b := range batches {
+		batchList[i] = map[string]interface{}{
+			"batch_id":     b.BatchID,
+			"traceparent":  b.TraceParent,
+			"tracestate":   b.TraceState,
+			"first_seen":   b.FirstSeen.Format(time.RFC3339Nano),
+			"metric_count": b.MetricCount,
+			"log_count":    b.LogCount,
+			"span_count":   b.SpanCount,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session_id": sessionID,
+		"batches":    batchList,
+	})
+}
@@ -0,0 +1,172 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zmack/otis/collector"
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTaggedBatchesFollowRecordsToTheirSession(t *testing.T) {
+	server := newTestAPIServer(t, "./test_session_batches.db")
+	server.SetRawAccess(t.TempDir(), DefaultInputFiles, "admin-secret")
+	dataDir := t.TempDir()
+
+	writer, err := collector.NewFileWriter(filepath.Join(dataDir, "logs.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	handler := collector.NewLogsHandler(writer, collector.RequestLimits{MaxBytes: 1 << 20}, collector.NewMetrics())
+	handler.SetBatchTagging(true)
+
+	timestamp := uint64(time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC).UnixNano())
+	post := func(records int, traceParent string) {
+		var logRecords []*logspb.LogRecord
+		for i := 0; i < records; i++ {
+			logRecords = append(logRecords, &logspb.LogRecord{
+				TimeUnixNano: timestamp + uint64(i),
+				Body:         &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "claude_code.user_prompt"}},
+			})
+		}
+		body, err := proto.Marshal(&logsv1.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+			Resource:  testResource("batched-session"),
+			ScopeLogs: []*logspb.ScopeLogs{{LogRecords: logRecords}},
+		}}})
+		if err != nil {
+			t.Fatalf("Failed to marshal request: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/v1/logs", bytes.NewReader(body))
+		if traceParent != "" {
+			req.Header.Set("traceparent", traceParent)
+			req.Header.Set("tracestate", "vendor=abc")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+	}
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	post(3, traceParent)
+	post(2, "")
+	post(1, "not-a-traceparent")
+
+	engine := newEngine(server.store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, server.store, engine, 60)
+	if err := processor.ProcessFile(filepath.Join(dataDir, "logs.jsonl")); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	engine.FlushCache()
+
+	batches, err := server.store.GetSessionBatches("batched-session")
+	if err != nil {
+		t.Fatalf("Failed to get batches: %v", err)
+	}
+	if len(batches) != 3 {
+		t.Fatalf("Expected 3 batches, got %d", len(batches))
+	}
+	var traced []*SessionBatch
+	counts := 0
+	for _, b := range batches {
+		counts += b.LogCount
+		if b.TraceParent != "" {
+			traced = append(traced, b)
+		}
+	}
+	if counts != 6 {
+		t.Errorf("Expected the batches to account for all 6 records, got %d", counts)
+	}
+	if len(traced) != 1 || traced[0].TraceParent != traceParent || traced[0].TraceState != "vendor=abc" || traced[0].LogCount != 3 {
+		t.Errorf("Expected one batch of 3 records carrying the trace context, got %+v", traced)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/batches?session_id=batched-session", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rec := httptest.NewRecorder()
+	server.handleAdminBatches(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response struct {
+		Batches []struct {
+			BatchID     string `json:"batch_id"`
+			TraceParent string `json:"traceparent"`
+			LogCount    int    `json:"log_count"`
+		} `json:"batches"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	found := false
+	for _, b := range response.Batches {
+		if b.TraceParent == traceParent {
+			found = b.BatchID == traced[0].BatchID && b.LogCount == 3
+		}
+	}
+	if !found {
+		t.Errorf("Expected the traced batch in the response, got %s", rec.Body.String())
+	}
+}
+
+func TestDirectIngestTagsBatches(t *testing.T) {
+	server := newTestAPIServer(t, "./test_direct_batches.db")
+	engine := newEngine(server.store)
+
+	// No writer: the batch only reaches the aggregator through the sink
+	handler := collector.NewLogsHandler(nil, collector.RequestLimits{MaxBytes: 1 << 20}, collector.NewMetrics())
+	handler.SetBatchTagging(true)
+	handler.SetSink(NewDirectIngester(engine))
+
+	body, err := proto.Marshal(&logsv1.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource: testResource("direct-batched-session"),
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{{
+			TimeUnixNano: uint64(time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC).UnixNano()),
+			Body:         &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "claude_code.user_prompt"}},
+		}}}},
+	}}})
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs", bytes.NewReader(body))
+	req.Header.Set("traceparent", traceParent)
+	req.Header.Set("tracestate", "vendor=abc")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	engine.FlushCache()
+
+	batches, err := server.store.GetSessionBatches("direct-batched-session")
+	if err != nil {
+		t.Fatalf("Failed to get batches: %v", err)
+	}
+	if len(batches) != 1 || batches[0].BatchID == "" || batches[0].TraceParent != traceParent ||
+		batches[0].TraceState != "vendor=abc" || batches[0].LogCount != 1 {
+		t.Errorf("Expected one batch carrying the trace context, got %+v", batches)
+	}
+}
+
+func TestUntaggedLinesRecordNoBatches(t *testing.T) {
+	engine := newEngine(nil)
+	line := `{"receivedAt":"2025-06-01T10:00:00Z","data":{"resourceLogs":[{"resource":{"attributes":[{"key":"session.id","value":{"stringValue":"plain-session"}}]},"scopeLogs":[{"logRecords":[{"timeUnixNano":"1748772000000000000","body":{"stringValue":"claude_code.user_prompt"}}]}]}]}}`
+	if err := decodeLine(SignalLogs, line, engine); err != nil {
+		t.Fatalf("Failed to decode line: %v", err)
+	}
+	if engine.sessionsCache["plain-session"] == nil {
+		t.Fatalf("Expected the line's session to be processed")
+	}
+	if len(engine.sessionBatchesCache) != 0 {
+		t.Errorf("Expected no batches from an untagged line, got %v", engine.sessionBatchesCache)
+	}
+}
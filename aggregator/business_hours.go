@@ -0,0 +1,258 @@
+package aggregator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BusinessHours is when work counts as happening in business hours: from
+// Start to End on each business day, in wall clock time in Location.
+// Everything else, weekends included, is after hours.
+type BusinessHours struct {
+	Location *time.Location
+	Days     [7]bool       // Indexed by time.Weekday
+	Start    time.Duration // Since midnight
+	End      time.Duration
+}
+
+// weekdayNames are the day names accepted in a business days list, indexed by
+// time.Weekday
+var weekdayNames = [7]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// DefaultBusinessHours is 09:00 to 17:00 UTC, Monday to Friday
+func DefaultBusinessHours() BusinessHours {
+	hours, _ := ParseBusinessHours("UTC", "mon-fri", "09:00-17:00")
+	return hours
+}
+
+// ParseBusinessHours builds business hours from an IANA timezone name (empty
+// for UTC), a comma-separated list of days or day ranges such as "mon-fri" or
+// "mon,wed,fri", and a span of hours such as "09:00-17:00"
+func ParseBusinessHours(timezone, days, hours string) (BusinessHours, error) {
+	var b BusinessHours
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return b, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+	b.Location = location
+
+	for _, part := range strings.Split(days, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			last = first
+		}
+		from, to := parseWeekday(first), parseWeekday(last)
+		if from < 0 || to < 0 {
+			return b, fmt.Errorf("invalid business days %q: want day names like mon or ranges like mon-fri", days)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			b.Days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	startStr, endStr, ok := strings.Cut(hours, "-")
+	if !ok {
+		return b, fmt.Errorf("invalid business hours %q: want a span like 09:00-17:00", hours)
+	}
+	if b.Start, err = parseClock(startStr); err != nil {
+		return b, fmt.Errorf("invalid business hours %q: %w", hours, err)
+	}
+	if b.End, err = parseClock(endStr); err != nil {
+		return b, fmt.Errorf("invalid business hours %q: %w", hours, err)
+	}
+	if b.End <= b.Start {
+		return b, fmt.Errorf("invalid business hours %q: the end must be after the start", hours)
+	}
+
+	return b, nil
+}
+
+// parseWeekday returns the time.Weekday named by the first three letters of
+// name, or -1 when it names none
+func parseWeekday(name string) int {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) < 3 {
+		return -1
+	}
+	for i, day := range weekdayNames {
+		if name[:3] == day {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseClock parses an HH:MM time of day, allowing 24:00 for midnight at the
+// end of the day
+func parseClock(s string) (time.Duration, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "%d:%d", &hour, &minute); err != nil {
+		return 0, fmt.Errorf("invalid time %q: want HH:MM", s)
+	}
+	clock := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute
+	if hour < 0 || minute < 0 || minute > 59 || clock > 24*time.Hour {
+		return 0, fmt.Errorf("invalid time %q: want HH:MM", s)
+	}
+	return clock, nil
+}
+
+// SetBusinessHours sets the hours sessions' active time is split by when
+// they are flushed
+func (e *Engine) SetBusinessHours(hours BusinessHours) {
+	e.cacheMutex.Lock()
+	defer e.cacheMutex.Unlock()
+	e.businessHours = hours
+}
+
+// BusinessHours returns the hours sessions' active time is split by
+func (e *Engine) BusinessHours() BusinessHours {
+	e.cacheMutex.RLock()
+	defer e.cacheMutex.RUnlock()
+	return e.businessHours
+}
+
+// splitBusinessHours splits a session's active time between business and
+// after hours. Sessions without reported or estimated active time count
+// their whole span as active. Callers hold cacheMutex.
+func (e *Engine) splitBusinessHours(session *Session) {
+	end := session.EndTime
+	if end.Before(session.StartTime) {
+		end = session.StartTime
+	}
+	active := end.Sub(session.StartTime).Seconds()
+	if session.TotalActiveTimeSeconds > 0 {
+		active = session.TotalActiveTimeSeconds
+	}
+	session.BusinessSeconds, session.AfterHoursSeconds = e.businessHours.splitSeconds(session.StartTime, end, active)
+}
+
+// Split divides the span from start to end into the time within business
+// hours and the time after hours
+func (b BusinessHours) Split(start, end time.Time) (business, afterHours time.Duration) {
+	if !end.After(start) {
+		return 0, 0
+	}
+
+	start, end = start.In(b.Location), end.In(b.Location)
+	year, month, day := start.Date()
+	for midnight := time.Date(year, month, day, 0, 0, 0, 0, b.Location); midnight.Before(end); midnight = midnight.AddDate(0, 0, 1) {
+		if !b.Days[midnight.Weekday()] {
+			continue
+		}
+		// Built from the wall clock, so the span stays put across DST changes
+		year, month, day := midnight.Date()
+		opens := time.Date(year, month, day, 0, int(b.Start/time.Minute), 0, 0, b.Location)
+		closes := time.Date(year, month, day, 0, int(b.End/time.Minute), 0, 0, b.Location)
+		if overlap := minTime(end, closes).Sub(maxTime(start, opens)); overlap > 0 {
+			business += overlap
+		}
+	}
+
+	return business, end.Sub(start) - business
+}
+
+// contains reports whether t falls within business hours
+func (b BusinessHours) contains(t time.Time) bool {
+	t = t.In(b.Location)
+	if !b.Days[t.Weekday()] {
+		return false
+	}
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	return clock >= b.Start && clock < b.End
+}
+
+// splitSeconds divides a session's active seconds between business and after
+// hours in proportion to how its span from start to end divides. A session
+// without a span counts entirely towards the hours it started in.
+func (b BusinessHours) splitSeconds(start, end time.Time, seconds float64) (business, afterHours float64) {
+	inHours, outside := b.Split(start, end)
+	if total := inHours + outside; total > 0 {
+		business = seconds * float64(inHours) / float64(total)
+		return business, seconds - business
+	}
+	if b.contains(start) {
+		return seconds, 0
+	}
+	return 0, seconds
+}
+
+// describe is the business hours as reported by the API
+func (b BusinessHours) describe() map[string]interface{} {
+	days := []string{}
+	for i, name := range weekdayNames {
+		if b.Days[i] {
+			days = append(days, name)
+		}
+	}
+	return map[string]interface{}{
+		"timezone": b.Location.String(),
+		"days":     days,
+		"start":    formatClock(b.Start),
+		"end":      formatClock(b.End),
+	}
+}
+
+// formatClock formats a duration since midnight as HH:MM
+func formatClock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+// hoursSplit sums sessions' active time and cost on either side of business
+// hours. Cost is split in proportion to active time; a session without any
+// goes to the hours it started in.
+type hoursSplit struct {
+	sessions          int
+	businessSeconds   float64
+	afterHoursSeconds float64
+	businessCostUSD   float64
+	afterHoursCostUSD float64
+}
+
+func (h *hoursSplit) add(sh *SessionHours, startedInHours bool) {
+	h.sessions++
+	h.businessSeconds += sh.BusinessSeconds
+	h.afterHoursSeconds += sh.AfterHoursSeconds
+
+	businessShare := 0.0
+	if total := sh.BusinessSeconds + sh.AfterHoursSeconds; total > 0 {
+		businessShare = sh.BusinessSeconds / total
+	} else if startedInHours {
+		businessShare = 1
+	}
+	h.businessCostUSD += sh.CostUSD * businessShare
+	h.afterHoursCostUSD += sh.CostUSD * (1 - businessShare)
+}
+
+func (h *hoursSplit) toJSON() map[string]interface{} {
+	afterHoursPercent := 0.0
+	if total := h.businessSeconds + h.afterHoursSeconds; total > 0 {
+		afterHoursPercent = h.afterHoursSeconds / total * 100
+	}
+	return map[string]interface{}{
+		"sessions":             h.sessions,
+		"business_seconds":     h.businessSeconds,
+		"after_hours_seconds":  h.afterHoursSeconds,
+		"after_hours_percent":  afterHoursPercent,
+		"business_cost_usd":    h.businessCostUSD,
+		"after_hours_cost_usd": h.afterHoursCostUSD,
+	}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,150 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBusinessHoursSplit(t *testing.T) {
+	hours := DefaultBusinessHours()
+	// 2025-06-02 is a Monday
+	at := func(day, hour, minute int) time.Time { return time.Date(2025, 6, day, hour, minute, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name                 string
+		start, end           time.Time
+		business, afterHours time.Duration
+	}{
+		{"inside", at(2, 10, 0), at(2, 12, 0), 2 * time.Hour, 0},
+		{"evening", at(2, 18, 0), at(2, 20, 0), 0, 2 * time.Hour},
+		{"weekend", at(7, 10, 0), at(7, 12, 0), 0, 2 * time.Hour},
+		{"straddling the close", at(2, 16, 0), at(2, 18, 30), time.Hour, 90 * time.Minute},
+		{"straddling the open", at(3, 8, 15), at(3, 9, 15), 15 * time.Minute, 45 * time.Minute},
+		{"over the weekend", at(6, 16, 0), at(9, 10, 0), 2 * time.Hour, 64 * time.Hour},
+		{"empty", at(2, 10, 0), at(2, 10, 0), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			business, afterHours := hours.Split(tt.start, tt.end)
+			if business != tt.business || afterHours != tt.afterHours {
+				t.Errorf("Expected %s business and %s after hours, got %s and %s", tt.business, tt.afterHours, business, afterHours)
+			}
+		})
+	}
+
+	// Active time is split in the span's proportions; a session without a
+	// span goes to the hours it started in
+	if business, afterHours := hours.splitSeconds(at(2, 16, 0), at(2, 18, 0), 600); business != 300 || afterHours != 300 {
+		t.Errorf("Expected 300s either side, got %f and %f", business, afterHours)
+	}
+	if business, afterHours := hours.splitSeconds(at(7, 10, 0), at(7, 10, 0), 60); business != 0 || afterHours != 60 {
+		t.Errorf("Expected a weekend instant after hours, got %f and %f", business, afterHours)
+	}
+}
+
+func TestCustomBusinessHours(t *testing.T) {
+	hours, err := ParseBusinessHours("America/New_York", "sun-thu", "08:30-12:00")
+	if err != nil {
+		t.Fatalf("Failed to parse business hours: %v", err)
+	}
+	ny := hours.Location
+
+	// Sunday 2025-06-01 is a business day, Friday is not
+	sunday := time.Date(2025, 6, 1, 8, 0, 0, 0, ny)
+	if business, afterHours := hours.Split(sunday, sunday.Add(time.Hour)); business != 30*time.Minute || afterHours != 30*time.Minute {
+		t.Errorf("Expected 30m either side of 08:30 on Sunday, got %s and %s", business, afterHours)
+	}
+	friday := time.Date(2025, 6, 6, 9, 0, 0, 0, ny)
+	if business, _ := hours.Split(friday, friday.Add(time.Hour)); business != 0 {
+		t.Errorf("Expected Friday to be after hours, got %s business", business)
+	}
+	// The hours are local: 13:00 UTC is 09:00 in New York
+	if business, _ := hours.Split(time.Date(2025, 6, 2, 13, 0, 0, 0, time.UTC), time.Date(2025, 6, 2, 14, 0, 0, 0, time.UTC)); business != time.Hour {
+		t.Errorf("Expected an hour of business time, got %s", business)
+	}
+
+	described := hours.describe()
+	if described["timezone"] != "America/New_York" || described["start"] != "08:30" || described["end"] != "12:00" ||
+		strings.Join(described["days"].([]string), ",") != "sun,mon,tue,wed,thu" {
+		t.Errorf("Unexpected description %v", described)
+	}
+
+	for _, bad := range [][3]string{
+		{"Mars/Olympus", "mon-fri", "09:00-17:00"},
+		{"UTC", "mon-fry", "09:00-17:00"},
+		{"UTC", "mon-fri", "17:00-09:00"},
+		{"UTC", "mon-fri", "9am-5pm"},
+		{"UTC", "mon-fri", "09:00-25:00"},
+	} {
+		if _, err := ParseBusinessHours(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestOrgAfterHoursEndpoint(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_after_hours.db")
+	engine := server.engine
+
+	// Reported active time is split by the session's span: 16:00 to 18:00
+	// on a Monday is half in business hours
+	monday := time.Now().UTC().AddDate(0, 0, -7)
+	for monday.Weekday() != time.Monday {
+		monday = monday.AddDate(0, 0, -1)
+	}
+	at := func(days, hour int) time.Time {
+		return time.Date(monday.Year(), monday.Month(), monday.Day()+days, hour, 0, 0, 0, time.UTC)
+	}
+	record := func(sessionID, userID string, timestamp time.Time, name string, value float64) *MetricRecord {
+		return &MetricRecord{Timestamp: timestamp, SessionID: sessionID, UserID: userID, OrganizationID: "org-hours",
+			MetricName: name, MetricValue: value, Attributes: map[string]string{"model": "claude-sonnet-4-5"}}
+	}
+	engine.ProcessMetric(record("straddling", "alice", at(0, 16), "claude_code.cost.usage", 1.0))
+	engine.ProcessMetric(record("straddling", "alice", at(0, 18), "claude_code.active_time.total", 600))
+	engine.ProcessMetric(record("inside", "bob", at(1, 10), "claude_code.cost.usage", 0.5))
+	engine.ProcessMetric(record("inside", "bob", at(1, 11), "claude_code.cost.usage", 0.5))
+	engine.ProcessMetric(record("weekend", "bob", at(5, 10), "claude_code.cost.usage", 2.0))
+	engine.ProcessMetric(record("weekend", "bob", at(5, 11), "claude_code.cost.usage", 0))
+	engine.FlushCache()
+
+	rec := httptest.NewRecorder()
+	server.handleOrgStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/org/org-hours/after-hours?window=30d", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	type split struct {
+		UserID            string  `json:"user_id"`
+		Day               string  `json:"day"`
+		Sessions          int     `json:"sessions"`
+		BusinessSeconds   float64 `json:"business_seconds"`
+		AfterHoursSeconds float64 `json:"after_hours_seconds"`
+		AfterHoursCostUSD float64 `json:"after_hours_cost_usd"`
+	}
+	var body struct {
+		split
+		Users []split `json:"users"`
+		Days  []split `json:"days"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// alice: 300s either side; bob: an hour inside, an hour on Saturday
+	if body.Sessions != 3 || body.BusinessSeconds != 3900 || body.AfterHoursSeconds != 3900 {
+		t.Errorf("Expected 3 sessions with 3900s either side, got %+v", body.split)
+	}
+	if body.AfterHoursCostUSD < 2.49 || body.AfterHoursCostUSD > 2.51 {
+		t.Errorf("Expected $2.50 after hours, got $%f", body.AfterHoursCostUSD)
+	}
+	if len(body.Users) != 2 || body.Users[0].UserID != "bob" || body.Users[0].AfterHoursSeconds != 3600 || body.Users[1].BusinessSeconds != 300 {
+		t.Errorf("Expected bob first with an hour after hours, got %+v", body.Users)
+	}
+	if len(body.Days) != 3 || body.Days[2].Day != at(5, 0).Format("2006-01-02") || body.Days[2].BusinessSeconds != 0 {
+		t.Errorf("Expected three days ending on Saturday, got %+v", body.Days)
+	}
+}
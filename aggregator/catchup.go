@@ -0,0 +1,246 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// catchUpSlice is how many throttled lines are processed between CPU share
+// pauses and yields to other goroutines
+const catchUpSlice = 100
+
+// catchUpThrottle slows the processor down while a file has a large unread
+// backlog, such as after a weekend of downtime, so catching up leaves CPU for
+// the collector. Below the lag threshold lines are processed at full speed.
+type catchUpThrottle struct {
+	linesPerSecond float64 // Zero is unlimited
+	cpuShare       float64 // Share of time spent processing; zero or one is unlimited
+	lagBytes       int64
+
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	mu             sync.Mutex
+	active         bool
+	tokens         float64
+	last           time.Time
+	sliceStart     time.Time
+	sliceSlept     time.Duration
+	throttledLines int64
+	throttledTime  time.Duration
+}
+
+func newCatchUpThrottle(linesPerSecond int, cpuShare float64, lagBytes int64) *catchUpThrottle {
+	return &catchUpThrottle{
+		linesPerSecond: float64(linesPerSecond),
+		cpuShare:       cpuShare,
+		lagBytes:       lagBytes,
+		now:            time.Now,
+		sleep:          time.Sleep,
+	}
+}
+
+// wait is called before each line with the bytes left to read in its file,
+// and blocks as long as the limits require while that lag is over the
+// threshold. A nil throttle never blocks.
+func (t *catchUpThrottle) wait(lag int64) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if lag < t.lagBytes {
+		caughtUp := t.active
+		t.active = false
+		t.mu.Unlock()
+		if caughtUp {
+			log.Printf("Caught up, processing at full speed")
+		}
+		return
+	}
+
+	now := t.now()
+	if !t.active {
+		t.active = true
+		t.tokens = t.burst()
+		t.last = now
+		t.sliceStart = now
+		t.sliceSlept = 0
+		log.Printf("Catching up on a %d byte backlog, throttling processing", lag)
+	}
+	t.throttledLines++
+
+	// Token bucket for the line rate
+	var pause time.Duration
+	if t.linesPerSecond > 0 {
+		t.tokens = math.Min(t.burst(), t.tokens+now.Sub(t.last).Seconds()*t.linesPerSecond)
+		t.last = now
+		if t.tokens >= 1 {
+			t.tokens--
+		} else {
+			pause = time.Duration((1 - t.tokens) / t.linesPerSecond * float64(time.Second))
+			t.tokens = 0
+			t.last = now.Add(pause)
+		}
+	}
+
+	// Every slice, rest in proportion to the time spent working
+	yield := t.throttledLines%catchUpSlice == 0
+	if yield && t.cpuShare > 0 && t.cpuShare < 1 {
+		busy := now.Sub(t.sliceStart) - t.sliceSlept
+		if busy > 0 {
+			pause += time.Duration(float64(busy) * (1 - t.cpuShare) / t.cpuShare)
+		}
+	}
+	t.throttledTime += pause
+	t.sliceSlept += pause
+	if yield {
+		t.sliceStart = now.Add(pause)
+		t.sliceSlept = 0
+	}
+	t.mu.Unlock()
+
+	if pause > 0 {
+		t.sleep(pause)
+	} else if yield {
+		runtime.Gosched()
+	}
+}
+
+// burst is the line rate's bucket size, one second's worth
+func (t *catchUpThrottle) burst() float64 {
+	return math.Max(1, t.linesPerSecond)
+}
+
+// buildCatchUpResponse builds the JSON response for the throttle's state
+func (t *catchUpThrottle) buildCatchUpResponse() map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":              true,
+		"active":               t.active,
+		"max_lines_per_second": t.linesPerSecond,
+		"max_cpu_share":        t.cpuShare,
+		"lag_threshold_bytes":  t.lagBytes,
+		"throttled_lines":      t.throttledLines,
+		"throttled_seconds":    t.throttledTime.Seconds(),
+	}
+}
+
+// SetCatchUpThrottle limits processing to linesPerSecond and to cpuShare of
+// the processor's time while a file has at least lagBytes left to read. Zero
+// linesPerSecond and a cpuShare of zero or one leave that limit off. With a
+// throttle the first pass no longer holds up Start, so the collector can
+// serve requests while the backlog is worked through.
+func (p *Processor) SetCatchUpThrottle(linesPerSecond int, cpuShare float64, lagBytes int64) {
+	if linesPerSecond <= 0 && (cpuShare <= 0 || cpuShare >= 1) {
+		p.throttle = nil
+		return
+	}
+	p.throttle = newCatchUpThrottle(linesPerSecond, cpuShare, lagBytes)
+	p.throttle.sleep = p.sleepUnlessStopped
+}
+
+// FileProgress is how far the processor has read one of the JSONL files
+type FileProgress struct {
+	FileName          string
+	LastProcessedLine int64
+	LastByteOffset    int64
+	SizeBytes         int64
+	UpdatedAt         time.Time
+}
+
+// LagBytes is how much of the file is still to be processed
+func (f *FileProgress) LagBytes() int64 {
+	if f.SizeBytes < f.LastByteOffset {
+		return 0 // Rotated or truncated since the last pass
+	}
+	return f.SizeBytes - f.LastByteOffset
+}
+
+// Progress reports how far each JSONL file has been processed
+func (p *Processor) Progress() ([]*FileProgress, error) {
+	var progress []*FileProgress
+	for _, filename := range p.files.paths(p.dataDir) {
+		state, err := p.store.GetProcessingState(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		fp := &FileProgress{
+			FileName:          filename,
+			LastProcessedLine: state.LastProcessedLine,
+			LastByteOffset:    state.LastByteOffset,
+			UpdatedAt:         state.UpdatedAt,
+		}
+		if info, err := os.Stat(filepath.Join(p.dataDir, filename)); err == nil {
+			fp.SizeBytes = info.Size()
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		progress = append(progress, fp)
+	}
+	return progress, nil
+}
+
+// SetProcessor enables /api/processing for the processor's progress
+func (s *APIServer) SetProcessor(processor *Processor) {
+	s.processor = processor
+}
+
+// handleProcessing handles GET /api/processing
+func (s *APIServer) handleProcessing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.processor == nil {
+		http.Error(w, "File processing is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	progress, err := s.processor.Progress()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving processing progress: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var totalLag int64
+	files := make([]map[string]interface{}, len(progress))
+	for i, fp := range progress {
+		totalLag += fp.LagBytes()
+		files[i] = map[string]interface{}{
+			"file":        fp.FileName,
+			"line":        fp.LastProcessedLine,
+			"byte_offset": fp.LastByteOffset,
+			"size_bytes":  fp.SizeBytes,
+			"lag_bytes":   fp.LagBytes(),
+		}
+		if !fp.UpdatedAt.IsZero() {
+			files[i]["updated_at"] = fp.UpdatedAt.Format(time.RFC3339)
+		}
+	}
+
+	response := map[string]interface{}{
+		"files":         files,
+		"lag_bytes":     totalLag,
+		"direct_ingest": s.processor.directIngest,
+		"catch_up":      s.processor.throttle.buildCatchUpResponse(),
+		"retention":     s.processor.retention.buildRetentionResponse(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,154 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock stands in for the throttle's clock, advancing only when it sleeps
+// or a test moves it on
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time        { return c.now }
+func (c *fakeClock) Sleep(d time.Duration) { c.now = c.now.Add(d) }
+
+func newFakeThrottle(linesPerSecond int, cpuShare float64, lagBytes int64) (*catchUpThrottle, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)}
+	throttle := newCatchUpThrottle(linesPerSecond, cpuShare, lagBytes)
+	throttle.now = clock.Now
+	throttle.sleep = clock.Sleep
+	return throttle, clock
+}
+
+func TestCatchUpThrottleLimitsBacklogLineRate(t *testing.T) {
+	server := newTestAPIServer(t, "./test_catchup.db")
+	dataDir := t.TempDir()
+
+	line := `{"data":"{\"resourceLogs\":[]}"}`
+	backlog := strings.Repeat(line+"\n", 5000)
+	if err := os.WriteFile(filepath.Join(dataDir, "logs.jsonl"), []byte(backlog), 0644); err != nil {
+		t.Fatalf("Failed to write backlog: %v", err)
+	}
+
+	processor := NewProcessor(dataDir, DefaultInputFiles, server.store, server.engine, 60)
+	throttle, clock := newFakeThrottle(500, 0, 10*int64(len(line)+1))
+	processor.throttle = throttle
+	server.SetProcessor(processor)
+	start := clock.now
+
+	if err := processor.ProcessFile(filepath.Join(dataDir, "logs.jsonl")); err != nil {
+		t.Fatalf("Failed to process backlog: %v", err)
+	}
+
+	// Lines with less than 10 lines' worth left run unthrottled; the rest go
+	// at 500 a second after a one second burst
+	if throttle.throttledLines != 4991 {
+		t.Errorf("Expected 4991 throttled lines, got %d", throttle.throttledLines)
+	}
+	want := time.Duration(float64(4991-500) / 500 * float64(time.Second))
+	if elapsed := clock.now.Sub(start); elapsed < want-time.Millisecond || elapsed > want+time.Millisecond {
+		t.Errorf("Expected the backlog to take %v, took %v", want, elapsed)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleProcessing(rec, httptest.NewRequest(http.MethodGet, "/api/processing", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Files []struct {
+			File     string `json:"file"`
+			Line     int64  `json:"line"`
+			LagBytes int64  `json:"lag_bytes"`
+		} `json:"files"`
+		CatchUp map[string]interface{} `json:"catch_up"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	for _, f := range body.Files {
+		if f.File == "logs.jsonl" && (f.Line != 5000 || f.LagBytes != 0) {
+			t.Errorf("Expected logs.jsonl fully processed, got line %d with %d bytes lag", f.Line, f.LagBytes)
+		}
+	}
+	if body.CatchUp["active"] != false || body.CatchUp["throttled_lines"] != 4991.0 {
+		t.Errorf("Expected an inactive throttle after catching up, got %v", body.CatchUp)
+	}
+}
+
+func TestCatchUpThrottleCPUShare(t *testing.T) {
+	throttle, clock := newFakeThrottle(0, 0.25, 1)
+	start := clock.now
+
+	// Each line takes 1ms of work; at a quarter share the throttle rests 3ms
+	// for every 1ms worked
+	for i := 0; i < 1000; i++ {
+		throttle.wait(1 << 20)
+		clock.now = clock.now.Add(time.Millisecond)
+	}
+
+	if throttle.throttledTime < 2900*time.Millisecond || throttle.throttledTime > 3000*time.Millisecond {
+		t.Errorf("Expected about 3s of rest, got %v", throttle.throttledTime)
+	}
+	if elapsed := clock.now.Sub(start); elapsed < 3900*time.Millisecond {
+		t.Errorf("Expected 1000 lines to take about 4s, took %v", elapsed)
+	}
+}
+
+func TestCatchUpThrottleIdleBelowLagThreshold(t *testing.T) {
+	throttle, clock := newFakeThrottle(10, 0.5, 1<<20)
+	start := clock.now
+
+	for i := 0; i < 1000; i++ {
+		throttle.wait(1 << 10)
+	}
+	if clock.now != start || throttle.throttledLines != 0 || throttle.active {
+		t.Errorf("Expected no throttling below the lag threshold, slept %v over %d lines", clock.now.Sub(start), throttle.throttledLines)
+	}
+
+	// A nil throttle never blocks
+	var disabled *catchUpThrottle
+	disabled.wait(1 << 30)
+}
+
+func TestStopInterruptsThrottledCatchUp(t *testing.T) {
+	server := newTestAPIServer(t, "./test_catchup_stop.db")
+	dataDir := t.TempDir()
+
+	line := `{"data":"{\"resourceLogs\":[]}"}`
+	backlog := strings.Repeat(line+"\n", 5000)
+	if err := os.WriteFile(filepath.Join(dataDir, "logs.jsonl"), []byte(backlog), 0644); err != nil {
+		t.Fatalf("Failed to write backlog: %v", err)
+	}
+
+	// A second's burst, then a line every 100ms: the backlog would take
+	// minutes, so Start returns and the catch-up runs in the background
+	processor := NewProcessor(dataDir, DefaultInputFiles, server.store, server.engine, 3600)
+	processor.SetCatchUpThrottle(10, 0, 1)
+	processor.Start()
+	time.Sleep(300 * time.Millisecond)
+
+	start := time.Now()
+	processor.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Stop to interrupt the throttle, took %v", elapsed)
+	}
+
+	// The lines done so far are saved, on a line boundary
+	state, err := server.store.GetProcessingState("logs.jsonl")
+	if err != nil {
+		t.Fatalf("Failed to get processing state: %v", err)
+	}
+	if state.LastProcessedLine < 10 || state.LastProcessedLine >= 5000 {
+		t.Errorf("Expected the catch-up stopped partway, got line %d", state.LastProcessedLine)
+	}
+	if state.LastByteOffset != state.LastProcessedLine*int64(len(line)+1) {
+		t.Errorf("Expected the offset of line %d, got %d", state.LastProcessedLine, state.LastByteOffset)
+	}
+}
@@ -0,0 +1,179 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ModelUsage summarizes one model's requests within a group of sessions.
+// Tool calls and their failure rate cover the group's sessions as a whole,
+// whichever model made them.
+type ModelUsage struct {
+	Model             string  `json:"model"`
+	Sessions          int     `json:"sessions"`
+	Requests          int64   `json:"requests"`
+	CostUSD           float64 `json:"cost_usd"`
+	AvgLatencyMS      float64 `json:"avg_latency_ms"`
+	CostPerRequestUSD float64 `json:"cost_per_request_usd"`
+	TokensPerRequest  float64 `json:"tokens_per_request"`
+	ToolCalls         int64   `json:"tool_calls"`
+	ToolFailureRate   float64 `json:"tool_failure_rate"`
+
+	latencyMS    float64
+	tokens       int64
+	toolFailures int64
+}
+
+// add accumulates the totals of another group of sessions
+func (u *ModelUsage) add(other ModelUsage) {
+	u.Sessions += other.Sessions
+	u.Requests += other.Requests
+	u.CostUSD += other.CostUSD
+	u.latencyMS += other.latencyMS
+	u.tokens += other.tokens
+	u.ToolCalls += other.ToolCalls
+	u.toolFailures += other.toolFailures
+}
+
+// finish computes the per-request averages and failure rate from the totals
+func (u *ModelUsage) finish() {
+	u.AvgLatencyMS = ratio(u.latencyMS, float64(u.Requests))
+	u.CostPerRequestUSD = ratio(u.CostUSD, float64(u.Requests))
+	u.TokensPerRequest = ratio(float64(u.tokens), float64(u.Requests))
+	u.ToolFailureRate = ratio(float64(u.toolFailures), float64(u.ToolCalls))
+}
+
+// ModelDelta is how model B's averages differ from model A's, B minus A
+type ModelDelta struct {
+	AvgLatencyMS      float64 `json:"avg_latency_ms"`
+	CostPerRequestUSD float64 `json:"cost_per_request_usd"`
+	TokensPerRequest  float64 `json:"tokens_per_request"`
+	ToolFailureRate   float64 `json:"tool_failure_rate"`
+}
+
+// ModelOverlap is the sessions that used both compared models, with each
+// model's share of their requests
+type ModelOverlap struct {
+	Sessions int        `json:"sessions"`
+	A        ModelUsage `json:"a"`
+	B        ModelUsage `json:"b"`
+}
+
+// ModelComparison compares two models over the sessions that used only one
+// of them. Sessions that used both are reported apart in Overlap, so a
+// session switching models mid-way doesn't blur the comparison. Delta is nil
+// when either model has no requests of its own to compare.
+type ModelComparison struct {
+	Window  string       `json:"window"`
+	A       ModelUsage   `json:"a"`
+	B       ModelUsage   `json:"b"`
+	Delta   *ModelDelta  `json:"delta"`
+	Overlap ModelOverlap `json:"overlap"`
+}
+
+// GetModelComparison compares models a and b over the sessions started in
+// the window, from their session_models rows
+func (s *Store) GetModelComparison(a, b string, window TimeWindow) (*ModelComparison, error) {
+	query := `
+	SELECT
+		m.model,
+		(SELECT COUNT(*) FROM session_models o WHERE o.session_id = m.session_id AND o.model IN (?, ?)) = 2 AS overlap,
+		COUNT(*),
+		COALESCE(SUM(m.request_count), 0),
+		COALESCE(SUM(m.cost_usd), 0),
+		COALESCE(SUM(m.total_latency_ms), 0),
+		COALESCE(SUM(m.input_tokens + m.output_tokens), 0),
+		COALESCE(SUM(s.tool_success_count + s.tool_failure_count), 0),
+		COALESCE(SUM(s.tool_failure_count), 0)
+	FROM session_models m
+	JOIN sessions s ON s.session_id = m.session_id
+	WHERE m.model IN (?, ?) AND s.start_time >= ? AND s.start_time < ?
+	GROUP BY m.model, overlap
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryGetModelComparison, query, a, b, a, b, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comparison := &ModelComparison{
+		Window:  window.Type,
+		A:       ModelUsage{Model: a},
+		B:       ModelUsage{Model: b},
+		Overlap: ModelOverlap{A: ModelUsage{Model: a}, B: ModelUsage{Model: b}},
+	}
+	for rows.Next() {
+		var model string
+		var overlap bool
+		var usage ModelUsage
+		if err := rows.Scan(&model, &overlap, &usage.Sessions, &usage.Requests, &usage.CostUSD,
+			&usage.latencyMS, &usage.tokens, &usage.ToolCalls, &usage.toolFailures); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case overlap && model == a:
+			comparison.Overlap.A.add(usage)
+		case overlap:
+			comparison.Overlap.B.add(usage)
+		case model == a:
+			comparison.A.add(usage)
+		default:
+			comparison.B.add(usage)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, usage := range []*ModelUsage{&comparison.A, &comparison.B, &comparison.Overlap.A, &comparison.Overlap.B} {
+		usage.finish()
+	}
+	comparison.Overlap.Sessions = comparison.Overlap.A.Sessions
+	if comparison.A.Requests > 0 && comparison.B.Requests > 0 {
+		comparison.Delta = &ModelDelta{
+			AvgLatencyMS:      comparison.B.AvgLatencyMS - comparison.A.AvgLatencyMS,
+			CostPerRequestUSD: comparison.B.CostPerRequestUSD - comparison.A.CostPerRequestUSD,
+			TokensPerRequest:  comparison.B.TokensPerRequest - comparison.A.TokensPerRequest,
+			ToolFailureRate:   comparison.B.ToolFailureRate - comparison.A.ToolFailureRate,
+		}
+	}
+	return comparison, nil
+}
+
+// handleCompareModels handles GET /api/compare/models?a=X&b=Y&window=30d
+func (s *APIServer) handleCompareModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a, b := r.URL.Query().Get("a"), r.URL.Query().Get("b")
+	if a == "" || b == "" {
+		http.Error(w, "Models a and b required", http.StatusBadRequest)
+		return
+	}
+	if a == b {
+		http.Error(w, "Models a and b must differ", http.StatusBadRequest)
+		return
+	}
+
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	comparison, err := s.store.GetModelComparison(a, b, window)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving model comparison: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comparison)
+}
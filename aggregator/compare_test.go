@@ -0,0 +1,133 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCompareModelsEndpoint(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_compare_models.db")
+	now := time.Now()
+
+	// Model a answers in 1000ms for $0.10 and 100 tokens a request, with 1
+	// tool failure in 20 calls; model b in 800ms for $0.15 and 150 tokens,
+	// with 2 failures in 10
+	for _, spec := range []SessionSpec{
+		{
+			SessionID: "a-1", StartTime: now.Add(-time.Hour),
+			Models: []ModelSpec{
+				{Model: "model-a", Requests: 10, CostUSD: 1, InputTokens: 800, OutputTokens: 200, LatencyMS: 10000},
+				{Model: "model-c", Requests: 1, CostUSD: 5, InputTokens: 1, LatencyMS: 1},
+			},
+			Tools: []ToolSpec{{Name: "Read", Successes: 9, Failures: 1}},
+		},
+		{
+			SessionID: "a-2", StartTime: now.Add(-2 * time.Hour),
+			Models: []ModelSpec{{Model: "model-a", Requests: 10, CostUSD: 1, InputTokens: 900, OutputTokens: 100, LatencyMS: 10000}},
+			Tools:  []ToolSpec{{Name: "Edit", Successes: 10}},
+		},
+		{
+			SessionID: "b-1", StartTime: now.Add(-3 * time.Hour),
+			Models: []ModelSpec{{Model: "model-b", Requests: 20, CostUSD: 3, InputTokens: 2000, OutputTokens: 1000, LatencyMS: 16000}},
+			Tools:  []ToolSpec{{Name: "Bash", Successes: 8, Failures: 2}},
+		},
+		{
+			SessionID: "both", StartTime: now.Add(-4 * time.Hour),
+			Models: []ModelSpec{
+				{Model: "model-a", Requests: 5, CostUSD: 0.5, InputTokens: 500, LatencyMS: 2500},
+				{Model: "model-b", Requests: 2, CostUSD: 0.4, InputTokens: 400, LatencyMS: 3000},
+			},
+			Tools: []ToolSpec{{Name: "Read", Successes: 3, Failures: 1}},
+		},
+		{
+			SessionID: "a-old", StartTime: now.AddDate(0, 0, -40),
+			Models: []ModelSpec{{Model: "model-a", Requests: 100, CostUSD: 100, LatencyMS: 1}},
+		},
+	} {
+		if err := SeedSession(server.store, spec); err != nil {
+			t.Fatalf("Failed to seed %s: %v", spec.SessionID, err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/compare/models?a=model-a&b=model-b&window=30d", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var comparison ModelComparison
+	if err := json.NewDecoder(rec.Body).Decode(&comparison); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+	tests := []struct {
+		name string
+		got  ModelUsage
+		want ModelUsage
+	}{
+		{"a", comparison.A, ModelUsage{Model: "model-a", Sessions: 2, Requests: 20, CostUSD: 2, AvgLatencyMS: 1000,
+			CostPerRequestUSD: 0.1, TokensPerRequest: 100, ToolCalls: 20, ToolFailureRate: 0.05}},
+		{"b", comparison.B, ModelUsage{Model: "model-b", Sessions: 1, Requests: 20, CostUSD: 3, AvgLatencyMS: 800,
+			CostPerRequestUSD: 0.15, TokensPerRequest: 150, ToolCalls: 10, ToolFailureRate: 0.2}},
+		{"overlap a", comparison.Overlap.A, ModelUsage{Model: "model-a", Sessions: 1, Requests: 5, CostUSD: 0.5, AvgLatencyMS: 500,
+			CostPerRequestUSD: 0.1, TokensPerRequest: 100, ToolCalls: 4, ToolFailureRate: 0.25}},
+		{"overlap b", comparison.Overlap.B, ModelUsage{Model: "model-b", Sessions: 1, Requests: 2, CostUSD: 0.4, AvgLatencyMS: 1500,
+			CostPerRequestUSD: 0.2, TokensPerRequest: 200, ToolCalls: 4, ToolFailureRate: 0.25}},
+	}
+	for _, tt := range tests {
+		got, want := tt.got, tt.want
+		if got.Model != want.Model || got.Sessions != want.Sessions || got.Requests != want.Requests || got.ToolCalls != want.ToolCalls ||
+			!near(got.CostUSD, want.CostUSD) || !near(got.AvgLatencyMS, want.AvgLatencyMS) || !near(got.CostPerRequestUSD, want.CostPerRequestUSD) ||
+			!near(got.TokensPerRequest, want.TokensPerRequest) || !near(got.ToolFailureRate, want.ToolFailureRate) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, want, got)
+		}
+	}
+
+	if comparison.Window != "30d" || comparison.Overlap.Sessions != 1 {
+		t.Errorf("Expected the 30d window and 1 overlapping session, got %s and %d", comparison.Window, comparison.Overlap.Sessions)
+	}
+	delta := comparison.Delta
+	if delta == nil || !near(delta.AvgLatencyMS, -200) || !near(delta.CostPerRequestUSD, 0.05) ||
+		!near(delta.TokensPerRequest, 50) || !near(delta.ToolFailureRate, 0.15) {
+		t.Errorf("Expected b 200ms faster, $0.05 and 50 tokens a request dearer, failing 15 points more, got %+v", delta)
+	}
+}
+
+func TestCompareModelsWithoutRequests(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_compare_models_empty.db")
+
+	for _, tt := range []struct {
+		query string
+		code  int
+	}{
+		{"?a=model-a", http.StatusBadRequest},
+		{"?a=model-a&b=model-a", http.StatusBadRequest},
+		{"?a=model-a&b=model-b&window=90d", http.StatusBadRequest},
+		{"?a=model-a&b=model-b", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/compare/models"+tt.query, nil))
+		if rec.Code != tt.code {
+			t.Fatalf("%s: expected status %d, got %d", tt.query, tt.code, rec.Code)
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+
+		// Without requests there is nothing to compare, so no delta
+		var decoded map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&decoded); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if value, ok := field(decoded, "delta"); !ok || value != nil {
+			t.Errorf("Expected a null delta, got %v (present %v)", value, ok)
+		}
+		if value, _ := field(decoded, "a", "avg_latency_ms"); value != float64(0) {
+			t.Errorf("Expected an average latency of 0 without requests, got %v", value)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package aggregator
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consoleSource marks daily usage rows imported from Anthropic Console exports
+const consoleSource = "console"
+
+// consoleColumns lists the accepted header names for each Console CSV field
+var consoleColumns = map[string][]string{
+	"day":            {"usage_date_utc", "date"},
+	"api_key":        {"api_key", "api_key_name"},
+	"model":          {"model_version", "model"},
+	"input":          {"uncached_input_tokens", "input_tokens"},
+	"output":         {"output_tokens"},
+	"cache_read":     {"cache_read_input_tokens", "cache_read_tokens"},
+	"cache_creation": {"cache_creation_input_tokens", "cache_creation_tokens"},
+	"cost":           {"cost_usd", "cost"},
+}
+
+// ConsoleImportResult summarizes a Console CSV import
+type ConsoleImportResult struct {
+	RowsRead       int
+	RowsImported   int // Daily usage rows written
+	UnmappedKeys   []string
+	ConflictedDays int // User-days skipped because otis already has data
+}
+
+// ImportConsoleCSV backfills daily usage from an Anthropic Console usage export.
+// API keys are mapped to users through the api_key_identities table. Rows for
+// a user-day that otis already recorded sessions for are skipped in favor of
+// otis data, and the cost discrepancy is logged.
+func (s *Store) ImportConsoleCSV(r io.Reader) (*ConsoleImportResult, error) {
+	identities, err := s.GetAPIKeyIdentities()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API key identities: %w", err)
+	}
+
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns, err := resolveConsoleColumns(header)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ConsoleImportResult{}
+	unmapped := make(map[string]bool)
+	rollups := make(map[string]*DailyUsage) // day|user|model -> usage
+	var order []string
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV row %d: %w", result.RowsRead+1, err)
+		}
+		result.RowsRead++
+
+		day, err := parseConsoleDay(record[columns["day"]])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", result.RowsRead, err)
+		}
+
+		apiKey := strings.TrimSpace(record[columns["api_key"]])
+		identity, ok := identities[apiKey]
+		if !ok {
+			if !unmapped[apiKey] {
+				unmapped[apiKey] = true
+				result.UnmappedKeys = append(result.UnmappedKeys, apiKey)
+			}
+			continue
+		}
+
+		model := strings.TrimSpace(record[columns["model"]])
+		key := day + "|" + identity.UserID + "|" + model
+		usage, exists := rollups[key]
+		if !exists {
+			usage = &DailyUsage{
+				Day:            day,
+				UserID:         identity.UserID,
+				OrganizationID: identity.OrganizationID,
+				Model:          model,
+				Source:         consoleSource,
+			}
+			rollups[key] = usage
+			order = append(order, key)
+		}
+
+		usage.InputTokens += consoleInt(record, columns, "input")
+		usage.OutputTokens += consoleInt(record, columns, "output")
+		usage.CacheReadTokens += consoleInt(record, columns, "cache_read")
+		usage.CacheCreationTokens += consoleInt(record, columns, "cache_creation")
+		usage.CostUSD += consoleFloat(record, columns, "cost")
+	}
+
+	// Group rollups by user-day so the otis conflict check runs once per day
+	type userDay struct{ day, userID string }
+	byUserDay := make(map[userDay][]*DailyUsage)
+	var userDays []userDay
+	for _, key := range order {
+		usage := rollups[key]
+		ud := userDay{usage.Day, usage.UserID}
+		if _, exists := byUserDay[ud]; !exists {
+			userDays = append(userDays, ud)
+		}
+		byUserDay[ud] = append(byUserDay[ud], usage)
+	}
+
+	now := time.Now()
+	for _, ud := range userDays {
+		usages := byUserDay[ud]
+
+		day, _ := time.Parse("2006-01-02", ud.day)
+		sessions, otisCost, err := s.GetUserDayCost(ud.userID, day)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check otis data for %s on %s: %w", ud.userID, ud.day, err)
+		}
+		if sessions > 0 {
+			var consoleCost float64
+			for _, usage := range usages {
+				consoleCost += usage.CostUSD
+			}
+			log.Printf("Skipping console data for %s on %s: otis has %d sessions ($%.4f) vs console $%.4f",
+				ud.userID, ud.day, sessions, otisCost, consoleCost)
+			result.ConflictedDays++
+			continue
+		}
+
+		for _, usage := range usages {
+			usage.CreatedAt = now
+			usage.UpdatedAt = now
+			if err := s.UpsertDailyUsage(usage); err != nil {
+				return nil, fmt.Errorf("failed to write daily usage for %s on %s: %w", usage.UserID, usage.Day, err)
+			}
+			result.RowsImported++
+		}
+	}
+
+	return result, nil
+}
+
+// resolveConsoleColumns maps each Console field to its column index
+func resolveConsoleColumns(header []string) (map[string]int, error) {
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	columns := make(map[string]int)
+	for field, aliases := range consoleColumns {
+		for _, alias := range aliases {
+			if i, ok := index[alias]; ok {
+				columns[field] = i
+				break
+			}
+		}
+	}
+
+	for _, required := range []string{"day", "api_key", "model"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV is missing a %s column (accepted: %s)",
+				required, strings.Join(consoleColumns[required], ", "))
+		}
+	}
+
+	return columns, nil
+}
+
+// parseConsoleDay normalizes a Console date or timestamp to YYYY-MM-DD in UTC
+func parseConsoleDay(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2006-01-02", time.RFC3339, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC().Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %q", value)
+}
+
+func consoleInt(record []string, columns map[string]int, field string) int64 {
+	i, ok := columns[field]
+	if !ok || i >= len(record) {
+		return 0
+	}
+	value, _ := strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+	return int64(math.Round(value))
+}
+
+func consoleFloat(record []string, columns map[string]int, field string) float64 {
+	i, ok := columns[field]
+	if !ok || i >= len(record) {
+		return 0
+	}
+	value, _ := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(record[i]), "$"), 64)
+	return value
+}
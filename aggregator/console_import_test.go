@@ -0,0 +1,191 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestImportConsoleCSV(t *testing.T) {
+	dbPath := "./test_console_import.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for _, apiKey := range []string{"alice-laptop", "alice-ci"} {
+		if err := store.UpsertAPIKeyIdentity(&APIKeyIdentity{
+			APIKey:         apiKey,
+			UserID:         "alice",
+			OrganizationID: "org-1",
+		}); err != nil {
+			t.Fatalf("Failed to upsert identity: %v", err)
+		}
+	}
+
+	// otis already has a session for alice on 2025-03-02, so console data for that day loses
+	otisDay := time.Date(2025, 3, 2, 15, 0, 0, 0, time.UTC)
+	if err := SeedSession(store, SessionSpec{
+		SessionID:      "session-1",
+		OrganizationID: "org-1",
+		UserID:         "alice",
+		StartTime:      otisDay,
+		TotalCostUSD:   0.02,
+	}); err != nil {
+		t.Fatalf("Failed to seed session: %v", err)
+	}
+
+	f, err := os.Open("testdata/console_usage.csv")
+	if err != nil {
+		t.Fatalf("Failed to open fixture: %v", err)
+	}
+	defer f.Close()
+
+	result, err := store.ImportConsoleCSV(f)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	if result.RowsRead != 5 {
+		t.Errorf("Expected 5 rows read, got %d", result.RowsRead)
+	}
+	if result.RowsImported != 2 {
+		t.Errorf("Expected 2 rows imported, got %d", result.RowsImported)
+	}
+	if result.ConflictedDays != 1 {
+		t.Errorf("Expected 1 conflicted day, got %d", result.ConflictedDays)
+	}
+	if len(result.UnmappedKeys) != 1 || result.UnmappedKeys[0] != "unknown-key" {
+		t.Errorf("Expected unknown-key to be unmapped, got %v", result.UnmappedKeys)
+	}
+
+	usages, err := store.GetDailyUsageByUser("alice")
+	if err != nil {
+		t.Fatalf("Failed to get daily usage: %v", err)
+	}
+	if len(usages) != 2 {
+		t.Fatalf("Expected 2 daily usage rows, got %d", len(usages))
+	}
+
+	// Rows are ordered by day then model
+	haiku, sonnet := usages[0], usages[1]
+	if haiku.Day != "2025-03-01" || haiku.Model != "claude-haiku-3-5" {
+		t.Errorf("Unexpected first row: %+v", haiku)
+	}
+	if sonnet.Source != "console" {
+		t.Errorf("Expected source console, got %s", sonnet.Source)
+	}
+	// Both alice API keys roll up into one row
+	if sonnet.InputTokens != 1500 || sonnet.OutputTokens != 500 {
+		t.Errorf("Expected 1500 input / 500 output tokens, got %d / %d", sonnet.InputTokens, sonnet.OutputTokens)
+	}
+	if sonnet.CacheReadTokens != 200 || sonnet.CacheCreationTokens != 50 {
+		t.Errorf("Expected 200 cache read / 50 cache creation tokens, got %d / %d", sonnet.CacheReadTokens, sonnet.CacheCreationTokens)
+	}
+	if diff := sonnet.CostUSD - 0.0155; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("Expected cost 0.0155, got %f", sonnet.CostUSD)
+	}
+
+	for _, usage := range usages {
+		if usage.Day == "2025-03-02" {
+			t.Errorf("Expected console data for the otis day to be skipped, got %+v", usage)
+		}
+	}
+}
+
+func TestImportConsoleCSVMissingColumns(t *testing.T) {
+	dbPath := "./test_console_import_columns.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	f, err := os.CreateTemp(t.TempDir(), "usage-*.csv")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	f.WriteString("date,output_tokens\n2025-03-01,10\n")
+	f.Seek(0, 0)
+	defer f.Close()
+
+	if _, err := store.ImportConsoleCSV(f); err == nil {
+		t.Error("Expected an error for a CSV without api_key and model columns")
+	}
+}
+
+func TestImportedConsoleUsageReachesTimeseriesAndUserStats(t *testing.T) {
+	server := newTestAPIServer(t, "./test_console_import_api.db")
+	if err := server.store.UpsertAPIKeyIdentity(&APIKeyIdentity{
+		APIKey:         "alice-laptop",
+		UserID:         "alice",
+		OrganizationID: "org-1",
+	}); err != nil {
+		t.Fatalf("Failed to upsert identity: %v", err)
+	}
+
+	f, err := os.Open("testdata/console_usage.csv")
+	if err != nil {
+		t.Fatalf("Failed to open fixture: %v", err)
+	}
+	defer f.Close()
+	if _, err := server.store.ImportConsoleCSV(f); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	// Only alice-laptop is mapped: 0.0125 + 0.0028 on the 1st, 0.0225 on the 2nd
+	window := "&bucket=1d&from=2025-03-01T00:00:00Z&to=2025-03-04T00:00:00Z"
+	for _, tt := range []struct {
+		query    string
+		expected []float64
+	}{
+		{"metric=cost&user_id=alice" + window, []float64{0.0153, 0.0225, 0}},
+		{"metric=tokens&org_id=org-1" + window, []float64{3900, 3900, 0}},
+		{"metric=api_requests&user_id=alice" + window, []float64{0, 0, 0}},
+		{"metric=cost&user_id=bob" + window, []float64{0, 0, 0}},
+	} {
+		values := getTimeseries(t, server, tt.query)
+		if len(values) != len(tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.query, tt.expected, values)
+			continue
+		}
+		for i := range values {
+			if math.Abs(values[i]-tt.expected[i]) > 1e-9 {
+				t.Errorf("%s: expected %v, got %v", tt.query, tt.expected, values)
+				break
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleUserStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/user/alice", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		ImportedUsage struct {
+			Days        int     `json:"days"`
+			FirstDay    string  `json:"first_day"`
+			LastDay     string  `json:"last_day"`
+			TotalTokens int64   `json:"total_tokens"`
+			CostUSD     float64 `json:"cost_usd"`
+		} `json:"imported_usage"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	imported := body.ImportedUsage
+	if imported.Days != 2 || imported.FirstDay != "2025-03-01" || imported.LastDay != "2025-03-02" || imported.TotalTokens != 7800 {
+		t.Errorf("Expected two imported days of 7800 tokens, got %+v", imported)
+	}
+	if math.Abs(imported.CostUSD-0.0378) > 1e-9 {
+		t.Errorf("Expected 0.0378 imported cost, got %f", imported.CostUSD)
+	}
+}
@@ -0,0 +1,101 @@
+package aggregator
+
+import "time"
+
+// costEstimate is the cost of a session model's tokens computed from the
+// engine's pricing, counted until the model reports claude_code.cost.usage
+type costEstimate struct {
+	usd        float64
+	byInterval map[int64]float64 // The share of usd in each rollup interval
+	reported   bool              // The model reports claude_code.cost.usage itself
+}
+
+// SetPricing computes cost from token usage for models that never report the
+// claude_code.cost.usage metric, such as self-hosted or proxied models. Once a
+// model reports cost in a session, the reported cost replaces the estimate.
+func (e *Engine) SetPricing(pricing Pricing) {
+	e.cacheMutex.Lock()
+	defer e.cacheMutex.Unlock()
+	e.pricing = pricing
+}
+
+// costEstimate gets or creates the cost estimate of a model in a session
+func (e *Engine) costEstimate(sessionID, model string) *costEstimate {
+	if e.costEstimateCache[sessionID] == nil {
+		e.costEstimateCache[sessionID] = make(map[string]*costEstimate)
+	}
+	est, exists := e.costEstimateCache[sessionID][model]
+	if !exists {
+		est = &costEstimate{}
+		e.costEstimateCache[sessionID][model] = est
+	}
+	return est
+}
+
+// estimateCost prices tokens of tokenType used by a model at timestamp and
+// adds them to the session's and model's cost, unless the model has no
+// pricing or reports cost
+func (e *Engine) estimateCost(session *Session, model, tokenType string, tokens int64, timestamp time.Time) {
+	if e.pricing == nil {
+		return
+	}
+	rates, ok := e.pricing.Lookup(model)
+	if !ok {
+		return
+	}
+	est := e.costEstimate(session.SessionID, model)
+	if est.reported {
+		return
+	}
+
+	var perMTok float64
+	switch tokenType {
+	case "input":
+		perMTok = rates.InputPerMTok
+	case "output":
+		perMTok = rates.OutputPerMTok
+	case "cacheRead":
+		perMTok = rates.CacheReadPerMTok
+	case "cacheCreation":
+		perMTok = rates.CacheCreationPerMTok
+	}
+	cost := float64(tokens) * perMTok / 1e6
+	if cost == 0 {
+		return
+	}
+
+	est.usd += cost
+	e.addModelCost(session, model, cost)
+
+	if est.byInterval == nil {
+		est.byInterval = make(map[int64]float64)
+	}
+	est.byInterval[rollupStart(timestamp)] += cost
+	e.sessionRollup(session, timestamp).CostUSD += cost
+}
+
+// useReportedCost discards a model's estimated cost once the session reports
+// claude_code.cost.usage for it, so the reported cost replaces the estimate
+func (e *Engine) useReportedCost(session *Session, model string) {
+	est := e.costEstimate(session.SessionID, model)
+	if est.reported {
+		return
+	}
+	est.reported = true
+	if est.usd != 0 {
+		e.addModelCost(session, model, -est.usd)
+		est.usd = 0
+	}
+	for start, usd := range est.byInterval {
+		e.sessionRollup(session, time.Unix(start, 0)).CostUSD -= usd
+	}
+	est.byInterval = nil
+}
+
+// addModelCost adds cost to a session's total and to the model's share of it
+func (e *Engine) addModelCost(session *Session, model string, cost float64) {
+	session.TotalCostUSD += cost
+	e.updateSessionModel(session.SessionID, model, func(sm *SessionModel) {
+		sm.CostUSD += cost
+	})
+}
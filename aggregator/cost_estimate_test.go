@@ -0,0 +1,123 @@
+package aggregator
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// tokenUsage builds a claude_code.token.usage metric for a model
+func tokenUsage(sessionID, model, tokenType string, tokens int64) *MetricRecord {
+	return &MetricRecord{
+		Timestamp:   time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC),
+		SessionID:   sessionID,
+		UserID:      "user-1",
+		MetricName:  "claude_code.token.usage",
+		MetricValue: tokens,
+		Attributes:  map[string]string{"model": model, "type": tokenType},
+	}
+}
+
+func TestEngineEstimatesCostFromTokens(t *testing.T) {
+	dbPath := "./test_engine_cost_estimate.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+	engine.SetPricing(Pricing{
+		"llama-3": {InputPerMTok: 1, OutputPerMTok: 2, CacheReadPerMTok: 0.1, CacheCreationPerMTok: 1.25},
+	})
+
+	// 1M input, 500K output, 1M cache read and 400K cache creation tokens
+	// cost $1 + $1 + $0.10 + $0.50
+	engine.ProcessMetric(tokenUsage("estimated-session", "llama-3-70b", "input", 1_000_000))
+	engine.ProcessMetric(tokenUsage("estimated-session", "llama-3-70b", "output", 500_000))
+	engine.ProcessMetric(tokenUsage("estimated-session", "llama-3-70b", "cacheRead", 1_000_000))
+	engine.ProcessMetric(tokenUsage("estimated-session", "llama-3-70b", "cacheCreation", 400_000))
+
+	// Models without pricing stay at zero
+	engine.ProcessMetric(tokenUsage("estimated-session", "unpriced-model", "input", 1_000_000))
+	engine.FlushCache()
+
+	session, err := store.GetSession("estimated-session")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if math.Abs(session.TotalCostUSD-2.6) > 1e-9 {
+		t.Errorf("Expected estimated cost 2.6, got %f", session.TotalCostUSD)
+	}
+
+	models, err := store.GetSessionModels("estimated-session")
+	if err != nil {
+		t.Fatalf("Failed to get session models: %v", err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("Expected 2 models, got %d", len(models))
+	}
+	for _, m := range models {
+		want := 2.6
+		if m.Model == "unpriced-model" {
+			want = 0
+		}
+		if math.Abs(m.CostUSD-want) > 1e-9 {
+			t.Errorf("Expected %s to cost %f, got %f", m.Model, want, m.CostUSD)
+		}
+	}
+}
+
+func TestEngineReportedCostReplacesEstimate(t *testing.T) {
+	engine := newEngine(nil)
+	engine.SetPricing(Pricing{"claude-sonnet-4": {InputPerMTok: 3, OutputPerMTok: 15}})
+
+	cost := func(sessionID, model string, usd float64) *MetricRecord {
+		return &MetricRecord{
+			Timestamp:   time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC),
+			SessionID:   sessionID,
+			MetricName:  "claude_code.cost.usage",
+			MetricValue: usd,
+			Attributes:  map[string]string{"model": model},
+		}
+	}
+
+	// Cost reported after tokens discards the estimate so far
+	engine.ProcessMetric(tokenUsage("late-cost", "claude-sonnet-4", "input", 1_000_000))
+	engine.ProcessMetric(cost("late-cost", "claude-sonnet-4", 2.5))
+	engine.ProcessMetric(tokenUsage("late-cost", "claude-sonnet-4", "output", 1_000_000))
+
+	// Cost reported before tokens means they are never estimated
+	engine.ProcessMetric(cost("early-cost", "claude-sonnet-4", 0.5))
+	engine.ProcessMetric(tokenUsage("early-cost", "claude-sonnet-4", "input", 1_000_000))
+
+	// A reported cost for one model leaves another model's estimate alone
+	engine.ProcessMetric(tokenUsage("late-cost", "claude-sonnet-4-proxy", "input", 1_000_000))
+
+	engine.cacheMutex.RLock()
+	defer engine.cacheMutex.RUnlock()
+
+	if got := engine.sessionsCache["late-cost"].TotalCostUSD; math.Abs(got-5.5) > 1e-9 {
+		t.Errorf("Expected the reported 2.5 plus the other model's estimated 3, got %f", got)
+	}
+	if got := engine.sessionModelsCache["late-cost"]["claude-sonnet-4"].CostUSD; math.Abs(got-2.5) > 1e-9 {
+		t.Errorf("Expected the model's reported cost 2.5, got %f", got)
+	}
+	if got := engine.sessionsCache["early-cost"].TotalCostUSD; math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("Expected only the reported 0.5, got %f", got)
+	}
+}
+
+func TestEngineWithoutPricingLeavesCostAtZero(t *testing.T) {
+	engine := newEngine(nil)
+	engine.ProcessMetric(tokenUsage("unpriced", "claude-sonnet-4", "input", 1_000_000))
+
+	engine.cacheMutex.RLock()
+	defer engine.cacheMutex.RUnlock()
+	if got := engine.sessionsCache["unpriced"].TotalCostUSD; got != 0 {
+		t.Errorf("Expected no cost without pricing, got %f", got)
+	}
+}
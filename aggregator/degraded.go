@@ -0,0 +1,338 @@
+package aggregator
+
+import (
+	"bytes"
+	"container/list"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// DefaultProbeInterval is how often an unavailable database is retried
+	DefaultProbeInterval = 5 * time.Second
+
+	// staleCacheMaxBytes bounds the response bodies kept for degraded mode;
+	// the least recently stored are evicted first. Every successful read
+	// response is copied in, so with degraded mode on the aggregator holds
+	// up to this much more memory.
+	staleCacheMaxBytes = 32 << 20
+
+	// StaleHeader marks a response served from the cache because the
+	// database is unavailable; the standard Age header gives its age
+	StaleHeader = "X-Otis-Stale"
+)
+
+// unavailableCodes are the SQLite errors meaning the database can't be read
+// right now, such as while backup tooling holds a lock or a network
+// filesystem blips, rather than that a statement is wrong
+var unavailableCodes = map[sqlite3.ErrNo]bool{
+	sqlite3.ErrBusy:     true,
+	sqlite3.ErrLocked:   true,
+	sqlite3.ErrIoErr:    true,
+	sqlite3.ErrCantOpen: true,
+	sqlite3.ErrProtocol: true,
+}
+
+// isUnavailable reports whether err means the database is unavailable
+func isUnavailable(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && unavailableCodes[sqliteErr.Code]
+}
+
+// availability tracks whether the store's database is reachable. A statement
+// failing with one of unavailableCodes marks it unavailable and any
+// statement that succeeds marks it available again; other errors change
+// nothing.
+type availability struct {
+	mu      sync.Mutex
+	since   time.Time // Zero while available
+	lastErr error
+}
+
+// observe records the outcome of a statement
+func (a *availability) observe(err error) {
+	available := err == nil || err == sql.ErrNoRows
+	if !available && !isUnavailable(err) {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case available && !a.since.IsZero():
+		log.Printf("Database available again after %v", time.Since(a.since).Round(time.Millisecond))
+		a.since, a.lastErr = time.Time{}, nil
+	case !available:
+		if a.since.IsZero() {
+			log.Printf("Database unavailable: %v", err)
+			a.since = time.Now()
+		}
+		a.lastErr = err
+	}
+}
+
+// Unavailable reports since when the database has been unavailable, with the
+// last error that said so, or the zero time while it is available
+func (s *Store) Unavailable() (time.Time, error) {
+	s.availability.mu.Lock()
+	defer s.availability.mu.Unlock()
+	return s.availability.since, s.availability.lastErr
+}
+
+// Ping runs a statement that reads the database, so an unavailable database
+// is noticed and a recovered one marked available
+func (s *Store) Ping() error {
+	var tables int
+	return s.queryRow(queryPing, `SELECT COUNT(*) FROM sqlite_master`).Scan(&tables)
+}
+
+// cachedResponse is a successful response kept to be served stale
+type cachedResponse struct {
+	key         string
+	contentType string
+	body        []byte
+	storedAt    time.Time
+}
+
+// degradedMode is a circuit breaker in front of the read endpoints, driven
+// by the store's availability. While the database is available it is closed:
+// requests reach their handlers and successful responses are cached. Once
+// the database is unavailable it opens, serving the cached response for a
+// request, up to staleTTL old, without touching the database. Every
+// probeInterval it half-opens, letting one request through to find out
+// whether the database is back.
+type degradedMode struct {
+	staleTTL      time.Duration
+	probeInterval time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element // key -> *cachedResponse
+	order     *list.List               // Least recently stored first
+	bytes     int
+	lastProbe time.Time
+	now       func() time.Time
+}
+
+func newDegradedMode(staleTTL, probeInterval time.Duration) *degradedMode {
+	return &degradedMode{
+		staleTTL:      staleTTL,
+		probeInterval: probeInterval,
+		entries:       make(map[string]*list.Element),
+		order:         list.New(),
+		now:           time.Now,
+	}
+}
+
+// SetDegradedMode serves cached read responses up to staleTTL old while the
+// database is unavailable, retrying it every probeInterval. A zero staleTTL
+// disables degraded mode, so requests fail while the database is down; it
+// is disabled until this is called.
+func (s *APIServer) SetDegradedMode(staleTTL, probeInterval time.Duration) {
+	if staleTTL <= 0 {
+		s.degraded = nil
+		return
+	}
+	if probeInterval <= 0 {
+		probeInterval = DefaultProbeInterval
+	}
+	s.degraded = newDegradedMode(staleTTL, probeInterval)
+}
+
+// probeDue reports whether it is time to retry the database, and if so
+// counts this as the attempt
+func (d *degradedMode) probeDue() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	if now.Sub(d.lastProbe) < d.probeInterval {
+		return false
+	}
+	d.lastProbe = now
+	return true
+}
+
+// store caches a successful response, evicting the least recently stored
+// ones past the size limit
+func (d *degradedMode) store(key, contentType string, body []byte) {
+	if len(body) > staleCacheMaxBytes {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.entries[key]; ok {
+		d.bytes -= len(elem.Value.(*cachedResponse).body)
+		d.order.Remove(elem)
+	}
+	d.entries[key] = d.order.PushBack(&cachedResponse{key: key, contentType: contentType, body: body, storedAt: d.now()})
+	d.bytes += len(body)
+
+	for d.bytes > staleCacheMaxBytes {
+		oldest := d.order.Front()
+		entry := oldest.Value.(*cachedResponse)
+		d.order.Remove(oldest)
+		delete(d.entries, entry.key)
+		d.bytes -= len(entry.body)
+	}
+}
+
+// serveStale writes the cached response for key with its age, reporting
+// false when there is none recent enough
+func (d *degradedMode) serveStale(w http.ResponseWriter, key string) bool {
+	d.mu.Lock()
+	elem, ok := d.entries[key]
+	var entry *cachedResponse
+	var age time.Duration
+	if ok {
+		entry = elem.Value.(*cachedResponse)
+		age = d.now().Sub(entry.storedAt)
+	}
+	d.mu.Unlock()
+	if !ok || age > d.staleTTL {
+		return false
+	}
+
+	w.Header().Set("Content-Type", entry.contentType)
+	w.Header().Set(StaleHeader, "true")
+	w.Header().Set("Age", fmt.Sprintf("%d", int(age.Seconds())))
+	w.Write(entry.body)
+	return true
+}
+
+// degradableRequest reports whether a request reads data that may be served
+// stale: the stats, session and comparison endpoints, except streams
+func degradableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.URL.Query().Get("stream") == "true" {
+		return false
+	}
+	for _, prefix := range []string{"/api/stats/", "/api/v2/", "/api/compare/"} {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedResponse holds a handler's response until it is known whether to
+// send it or a cached one
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+// sendTo writes the buffered response to w
+func (b *bufferedResponse) sendTo(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	if b.status != 0 {
+		w.WriteHeader(b.status)
+	}
+	w.Write(b.body.Bytes())
+}
+
+// degradedMiddleware puts the read endpoints behind degraded mode's circuit
+// breaker
+func (s *APIServer) degradedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := s.degraded
+		if d == nil || !degradableRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := r.URL.RequestURI()
+
+		// Open: serve from the cache unless it's time to probe
+		if since, _ := s.store.Unavailable(); !since.IsZero() && !d.probeDue() {
+			s.serveDegraded(w, key)
+			return
+		}
+
+		buffered := &bufferedResponse{header: make(http.Header)}
+		next.ServeHTTP(buffered, r)
+		switch {
+		case buffered.status == http.StatusOK || buffered.status == 0:
+			d.store(key, buffered.header.Get("Content-Type"), buffered.body.Bytes())
+		case buffered.status >= 500 || buffered.status == http.StatusNotFound:
+			// Some handlers report any failed lookup as not found, so while
+			// the database is unavailable a 404 can't be trusted either
+			if since, _ := s.store.Unavailable(); !since.IsZero() {
+				s.serveDegraded(w, key)
+				return
+			}
+		}
+		buffered.sendTo(w)
+	})
+}
+
+// serveDegraded serves a request from the cache while the database is
+// unavailable, or answers 503 when nothing recent enough is cached
+func (s *APIServer) serveDegraded(w http.ResponseWriter, key string) {
+	if s.degraded.serveStale(w, key) {
+		return
+	}
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(s.degraded.probeInterval.Seconds()+0.5)))
+	http.Error(w, "Database unavailable and no recent cached response", http.StatusServiceUnavailable)
+}
+
+// handleReady handles GET /api/health/ready, reporting whether the database
+// is available. While it isn't, the read endpoints serve cached responses
+// and this answers 503 with status degraded.
+func (s *APIServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Probe an unavailable database here too, so it is noticed to be back
+	// without waiting for a read request
+	if since, _ := s.store.Unavailable(); !since.IsZero() && (s.degraded == nil || s.degraded.probeDue()) {
+		s.store.Ping()
+	}
+
+	response := map[string]interface{}{
+		"status":            "ready",
+		"unavailable_since": nil,
+		"error":             nil,
+		"stale_ttl_seconds": 0,
+	}
+	if s.degraded != nil {
+		response["stale_ttl_seconds"] = s.degraded.staleTTL.Seconds()
+	}
+	status := http.StatusOK
+	if since, err := s.store.Unavailable(); !since.IsZero() {
+		status = http.StatusServiceUnavailable
+		response["status"] = "degraded"
+		response["unavailable_since"] = jsonTime(since)
+		if err != nil {
+			response["error"] = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,131 @@
+package aggregator
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// lockDatabase takes an exclusive lock on the database at path from another
+// connection, so the store's statements fail as busy until the returned
+// function releases it
+func lockDatabase(t *testing.T, path string) func() {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open the locking connection: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{"PRAGMA locking_mode=EXCLUSIVE", "BEGIN EXCLUSIVE"} {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			t.Fatalf("Failed to lock the database: %v", err)
+		}
+	}
+	return func() { db.Close() }
+}
+
+func TestDegradedModeServesStaleResponses(t *testing.T) {
+	const path = "./test_api_degraded.db"
+	store, err := NewStore("file:" + path + "?_busy_timeout=20")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(path + suffix)
+		}
+	})
+	// Idle connections would hold shared locks keeping the lock out
+	store.db.SetMaxIdleConns(0)
+	if err := SeedSession(store, SessionSpec{SessionID: "s1", UserID: "u1", OrganizationID: "o1", StartTime: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("Failed to seed: %v", err)
+	}
+
+	server := NewAPIServer(0, store, NewEngine(store))
+	server.SetDegradedMode(time.Minute, 10*time.Second)
+	now := time.Now()
+	server.degraded.now = func() time.Time { return now }
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	fresh := get("/api/v2/sessions/s1")
+	if fresh.Code != http.StatusOK || fresh.Header().Get(StaleHeader) != "" {
+		t.Fatalf("Expected a fresh 200, got %d (stale %q)", fresh.Code, fresh.Header().Get(StaleHeader))
+	}
+	if rec := get("/api/health/ready"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected ready while the database is available, got %d", rec.Code)
+	}
+
+	unlock := lockDatabase(t, path)
+	defer unlock()
+	now = now.Add(30 * time.Second)
+
+	// The first request finds the database locked and falls back to the
+	// cached response; until the next probe no request touches the database
+	for i := 0; i < 2; i++ {
+		stale := get("/api/v2/sessions/s1")
+		if stale.Code != http.StatusOK || stale.Header().Get(StaleHeader) != "true" || stale.Header().Get("Age") != "30" {
+			t.Fatalf("Expected a stale 200 aged 30s, got %d (stale %q, age %q): %s", stale.Code,
+				stale.Header().Get(StaleHeader), stale.Header().Get("Age"), stale.Body.String())
+		}
+		if stale.Body.String() != fresh.Body.String() {
+			t.Errorf("Expected the cached body, got %s", stale.Body.String())
+		}
+	}
+	if rec := get("/api/v2/sessions/unknown"); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "10" {
+		t.Errorf("Expected 503 retrying after 10s without a cached response, got %d (%q)", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	ready := get("/api/health/ready")
+	var body map[string]interface{}
+	if err := json.NewDecoder(ready.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode readiness: %v", err)
+	}
+	if ready.Code != http.StatusServiceUnavailable || body["status"] != "degraded" || body["unavailable_since"] == nil || body["error"] == nil {
+		t.Errorf("Expected a degraded 503 with the error, got %d: %v", ready.Code, body)
+	}
+
+	// Past the TTL, nothing is served stale
+	now = now.Add(time.Minute)
+	if rec := get("/api/v2/sessions/s1"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once the cached response expired, got %d", rec.Code)
+	}
+
+	// Once unlocked, the next probe finds the database back
+	unlock()
+	now = now.Add(10 * time.Second)
+	if rec := get("/api/health/ready"); rec.Code != http.StatusOK {
+		t.Errorf("Expected ready after the probe, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/api/v2/sessions/s1"); rec.Code != http.StatusOK || rec.Header().Get(StaleHeader) != "" {
+		t.Errorf("Expected a fresh 200 after recovery, got %d (stale %q)", rec.Code, rec.Header().Get(StaleHeader))
+	}
+}
+
+func TestDegradedModeDisabled(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_degraded_disabled.db")
+	if server.degraded != nil {
+		t.Fatal("Expected degraded mode off by default")
+	}
+	server.SetDegradedMode(time.Minute, 0)
+	server.SetDegradedMode(0, 0)
+	if server.degraded != nil {
+		t.Fatal("Expected a zero TTL to disable degraded mode")
+	}
+
+	rec := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/health/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected ready, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/zmack/otis/collector"
+	collogsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcev1 "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// DirectIngester feeds decoded OTLP requests from the collector straight into
+// the engine, skipping the JSONL write and re-read. Records are built the same
+// way the file processor builds them from the JSON encoding.
+type DirectIngester struct {
+	engine recordHandler
+}
+
+// NewDirectIngester creates an ingester for the collector's direct mode
+func NewDirectIngester(engine *Engine) *DirectIngester {
+	return &DirectIngester{engine: engine}
+}
+
+// ConsumeTraces processes the spans of a trace export request
+func (d *DirectIngester) ConsumeTraces(req *coltracev1.ExportTraceServiceRequest, receivedAt time.Time, batch *collector.BatchContext) {
+	handler := d.handler(batch)
+	for _, rs := range req.GetResourceSpans() {
+		resourceAttrs := resourceAttributesFromProto(rs.GetResource())
+		for _, ss := range rs.GetScopeSpans() {
+			attrs := mergeAttrs(stringAttributes(ss.GetScope().GetAttributes()), resourceAttrs)
+			for _, span := range ss.GetSpans() {
+				if span.GetName() == "" {
+					continue
+				}
+				handler.ProcessTrace(newTraceRecord(span.GetName(),
+					encodeOTLPID(span.GetTraceId(), traceIDSize), encodeOTLPID(span.GetSpanId(), spanIDSize),
+					int64(span.GetStartTimeUnixNano()), int64(span.GetEndTimeUnixNano()),
+					stringAttributes(span.GetAttributes()), attrs, receivedAt))
+			}
+		}
+	}
+}
+
+// ConsumeMetrics processes the data points of a metrics export request
+func (d *DirectIngester) ConsumeMetrics(req *colmetricsv1.ExportMetricsServiceRequest, receivedAt time.Time, batch *collector.BatchContext) {
+	handler := d.handler(batch)
+	for _, rm := range req.GetResourceMetrics() {
+		resourceAttrs := resourceAttributesFromProto(rm.GetResource())
+		for _, sm := range rm.GetScopeMetrics() {
+			attrs := mergeAttrs(stringAttributes(sm.GetScope().GetAttributes()), resourceAttrs)
+			for _, metric := range sm.GetMetrics() {
+				for _, record := range metricRecordsFromProto(metric, attrs, receivedAt) {
+					handler.ProcessMetric(record)
+				}
+			}
+		}
+	}
+}
+
+// ConsumeLogs processes the records of a logs export request
+func (d *DirectIngester) ConsumeLogs(req *collogsv1.ExportLogsServiceRequest, receivedAt time.Time, batch *collector.BatchContext) {
+	handler := d.handler(batch)
+	for _, rl := range req.GetResourceLogs() {
+		resourceAttrs := resourceAttributesFromProto(rl.GetResource())
+		for _, sl := range rl.GetScopeLogs() {
+			attrs := mergeAttrs(stringAttributes(sl.GetScope().GetAttributes()), resourceAttrs)
+			for _, lr := range sl.GetLogRecords() {
+				logAttrs := make(map[string]interface{})
+				logAttrsStr := make(map[string]string)
+				for _, kv := range lr.GetAttributes() {
+					if kv.GetValue() == nil {
+						continue
+					}
+					logAttrs[kv.GetKey()] = anyValueMap(kv.GetValue())
+					if sv, ok := kv.GetValue().GetValue().(*commonv1.AnyValue_StringValue); ok {
+						logAttrsStr[kv.GetKey()] = sv.StringValue
+					}
+				}
+
+				timestamp := timestampOrReceived(int64(lr.GetTimeUnixNano()), receivedAt)
+				handler.ProcessLog(newLogRecord(timestamp, lr.GetSeverityText(), lr.GetBody().GetStringValue(),
+					logAttrs, logAttrsStr, attrs))
+			}
+		}
+	}
+}
+
+// handler returns the engine, tagging each record with the request's batch
+// as the file processor does from the JSONL envelope
+func (d *DirectIngester) handler(batch *collector.BatchContext) recordHandler {
+	if batch == nil {
+		return d.engine
+	}
+	return batchTagger{
+		recordHandler: d.engine,
+		batch:         &IngestBatch{ID: batch.ID, TraceParent: batch.TraceParent, TraceState: batch.TraceState},
+	}
+}
+
+// metricRecordsFromProto mirrors extractMetricRecords: only sums are recorded
+func metricRecordsFromProto(metric *metricsv1.Metric, enclosingAttrs map[string]string, receivedAt time.Time) []*MetricRecord {
+	if metric.GetName() == "" || metric.GetSum() == nil {
+		return nil
+	}
+
+	var records []*MetricRecord
+	for _, dp := range metric.GetSum().GetDataPoints() {
+		var value interface{}
+		switch v := dp.GetValue().(type) {
+		case *metricsv1.NumberDataPoint_AsInt:
+			value = v.AsInt
+		case *metricsv1.NumberDataPoint_AsDouble:
+			value = v.AsDouble
+		}
+
+		timestamp := timestampOrReceived(int64(dp.GetTimeUnixNano()), receivedAt)
+		records = append(records, newMetricRecord(metric.GetName(), timestamp, value,
+			stringAttributes(dp.GetAttributes()), enclosingAttrs))
+	}
+	return records
+}
+
+// resourceAttributesFromProto mirrors extractResourceAttributes
+func resourceAttributesFromProto(resource *resourcev1.Resource) map[string]string {
+	return stringAttributes(resource.GetAttributes())
+}
+
+// stringAttributes keeps the string-valued attributes, as the JSON path does
+func stringAttributes(kvs []*commonv1.KeyValue) map[string]string {
+	attrs := make(map[string]string)
+	for _, kv := range kvs {
+		if sv, ok := kv.GetValue().GetValue().(*commonv1.AnyValue_StringValue); ok {
+			attrs[kv.GetKey()] = sv.StringValue
+		}
+	}
+	return attrs
+}
+
+// anyValueMap converts an attribute value to the map the processor sees after
+// decoding the protojson encoding, e.g. {"intValue": "42"}
+func anyValueMap(value *commonv1.AnyValue) map[string]interface{} {
+	switch v := value.GetValue().(type) {
+	case nil:
+		return map[string]interface{}{}
+	case *commonv1.AnyValue_StringValue:
+		return map[string]interface{}{"stringValue": v.StringValue}
+	case *commonv1.AnyValue_BoolValue:
+		return map[string]interface{}{"boolValue": v.BoolValue}
+	case *commonv1.AnyValue_IntValue:
+		// protojson encodes 64-bit integers as strings
+		return map[string]interface{}{"intValue": strconv.FormatInt(v.IntValue, 10)}
+	case *commonv1.AnyValue_DoubleValue:
+		return map[string]interface{}{"doubleValue": v.DoubleValue}
+	}
+
+	// Arrays, key-value lists and bytes are rare; round-trip them through JSON
+	m := map[string]interface{}{}
+	if data, err := protojson.Marshal(value); err == nil {
+		json.Unmarshal(data, &m)
+	}
+	return m
+}
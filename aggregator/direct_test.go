@@ -0,0 +1,270 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zmack/otis/collector"
+	"github.com/zmack/otis/config"
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ collector.Sink = (*DirectIngester)(nil)
+
+func stringKV(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func intKV(key string, value int64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value}}}
+}
+
+func doubleKV(key string, value float64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value}}}
+}
+
+func boolKV(key string, value bool) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: value}}}
+}
+
+// directTestRequests builds one request per signal covering the attribute
+// types and timestamp cases the engine cares about
+func directTestRequests() (*tracev1.ExportTraceServiceRequest, *metricsv1.ExportMetricsServiceRequest, *logsv1.ExportLogsServiceRequest) {
+	base := uint64(time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC).UnixNano())
+	ids := []*commonpb.KeyValue{stringKV("session.id", "direct-session"), stringKV("user.id", "user-1"), stringKV("organization.id", "org-1")}
+
+	traces := &tracev1.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource: testResource("direct-session"),
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{
+			{Name: "claude_code.request", StartTimeUnixNano: base, EndTimeUnixNano: base + 1500000000},
+			{Name: "claude_code.no_start", EndTimeUnixNano: base},
+		}}},
+	}}}
+
+	metrics := &metricsv1.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: testResource("direct-session"),
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+			{Name: "claude_code.cost.usage", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{DataPoints: []*metricspb.NumberDataPoint{
+				{TimeUnixNano: base, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 0.25}, Attributes: append(ids, stringKV("model", "claude-sonnet-4-5"))},
+			}}}},
+			{Name: "claude_code.token.usage", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{DataPoints: []*metricspb.NumberDataPoint{
+				{TimeUnixNano: base, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 1200}, Attributes: append(ids, stringKV("type", "input"), stringKV("model", "claude-sonnet-4-5"))},
+				{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 300}, Attributes: append(ids, stringKV("type", "output"), stringKV("model", "claude-sonnet-4-5"))},
+			}}}},
+			{Name: "claude_code.session.count", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}},
+		}}},
+	}}}
+
+	logs := &logsv1.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource: testResource("direct-session"),
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{
+			{TimeUnixNano: base, Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "claude_code.user_prompt"}},
+				Attributes: append(ids, intKV("prompt_length", 42))},
+			{TimeUnixNano: base + 1000, Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "claude_code.api_request"}},
+				Attributes: append(ids, stringKV("model", "claude-sonnet-4-5"), intKV("input_tokens", 1200), doubleKV("cost_usd", 0.25), doubleKV("duration_ms", 850))},
+			{Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "claude_code.tool_result"}},
+				Attributes: append(ids, stringKV("tool_name", "bash"), boolKV("success", true), doubleKV("duration_ms", 12.5), intKV("tool_result_size_bytes", 512))},
+		}}},
+	}}}
+
+	return traces, metrics, logs
+}
+
+// normalizedEngineState returns the engine caches with wall-clock fields cleared
+func normalizedEngineState(e *Engine) []interface{} {
+	for _, session := range e.sessionsCache {
+		session.CreatedAt, session.UpdatedAt = time.Time{}, time.Time{}
+	}
+	return []interface{}{
+		e.sessionsCache, e.sessionModelsCache, e.sessionToolsCache, e.sessionTurnsCache, e.sessionRollupsCache,
+	}
+}
+
+func TestDirectIngestMatchesFileProcessing(t *testing.T) {
+	receivedAt := time.Date(2025, 6, 1, 10, 0, 5, 0, time.UTC)
+	traces, metrics, logs := directTestRequests()
+
+	// Direct path
+	directStore, err := NewStore("./test_direct_ingest.db")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer os.Remove("./test_direct_ingest.db")
+	defer directStore.Close()
+
+	directEngine := NewEngine(directStore)
+	ingester := NewDirectIngester(directEngine)
+	ingester.ConsumeTraces(traces, receivedAt, nil)
+	ingester.ConsumeMetrics(metrics, receivedAt, nil)
+	ingester.ConsumeLogs(logs, receivedAt, nil)
+
+	// File path, from the same requests in the collector's envelope
+	fileStore, err := NewStore("./test_direct_file.db")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer os.Remove("./test_direct_file.db")
+	defer fileStore.Close()
+
+	fileEngine := NewEngine(fileStore)
+	processor := NewProcessor(t.TempDir(), DefaultInputFiles, fileStore, fileEngine, 60)
+	// Same order as the direct path; the engine keeps the first IDs it sees
+	for _, input := range []struct {
+		filename string
+		req      proto.Message
+	}{{"traces.jsonl", traces}, {"metrics.jsonl", metrics}, {"logs.jsonl", logs}} {
+		line := fmt.Sprintf(`{"receivedAt":%q,"data":%s}`, receivedAt.Format(time.RFC3339Nano), protojson.Format(input.req))
+		if err := processor.processLine(input.filename, line); err != nil {
+			t.Fatalf("Failed to process %s line: %v", input.filename, err)
+		}
+	}
+
+	if len(directEngine.sessionsCache) == 0 {
+		t.Fatal("Expected the direct path to create a session")
+	}
+
+	direct := normalizedEngineState(directEngine)
+	file := normalizedEngineState(fileEngine)
+	names := []string{"sessions", "session models", "session tools", "session turns", "session rollups"}
+	for i, name := range names {
+		if !reflect.DeepEqual(direct[i], file[i]) {
+			t.Errorf("%s differ between direct and file ingestion:\ndirect: %s\nfile:   %s",
+				name, dumpCache(direct[i]), dumpCache(file[i]))
+		}
+	}
+}
+
+// dumpCache renders a cache map with its pointed-to values for diffing
+func dumpCache(cache interface{}) string {
+	v := reflect.ValueOf(cache)
+	out := ""
+	for _, key := range v.MapKeys() {
+		out += fmt.Sprintf("%v=%+v ", key, reflect.Indirect(v.MapIndex(key)).Interface())
+	}
+	return out
+}
+
+func TestDirectIngestThroughCollectorHandler(t *testing.T) {
+	store, err := NewStore("./test_direct_handler.db")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer os.Remove("./test_direct_handler.db")
+	defer store.Close()
+
+	engine := NewEngine(store)
+
+	// No writer: the request only reaches the engine
+	handler := collector.NewLogsHandler(nil, collector.RequestLimits{MaxBytes: 1 << 20}, collector.NewMetrics())
+	handler.SetSink(NewDirectIngester(engine))
+
+	_, _, logs := directTestRequests()
+	postOTLP(t, handler, "/v1/logs", logs)
+
+	engine.FlushCache()
+	session, err := store.GetSession("direct-session")
+	if err != nil {
+		t.Fatalf("Expected the session to be stored after a flush: %v", err)
+	}
+	if session.ToolCallCount != 1 {
+		t.Errorf("Expected 1 tool call, got %d", session.ToolCallCount)
+	}
+}
+
+func TestInMemoryPipeline(t *testing.T) {
+	t.Setenv("OTIS_IN_MEMORY", "true")
+	t.Setenv("OTIS_OUTPUT_DIR", t.TempDir())
+	cfg := config.Load()
+	if cfg.DBPath != ":memory:" || !cfg.DirectIngest || cfg.DirectIngestWriteFiles {
+		t.Fatalf("Expected an in-memory direct ingest config without files, got %+v", cfg)
+	}
+
+	store, err := NewStore(cfg.DBPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	engine := NewEngine(store)
+
+	server, err := collector.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+	server.SetSink(NewDirectIngester(engine))
+
+	traces, metrics, logs := directTestRequests()
+	postOTLP(t, server.Handler(), "/v1/traces", traces)
+	postOTLP(t, server.Handler(), "/v1/metrics", metrics)
+	postOTLP(t, server.Handler(), "/v1/logs", logs)
+	engine.FlushCache()
+
+	api := NewAPIServer(0, store, engine)
+	rec := httptest.NewRecorder()
+	api.handleV2Session(rec, httptest.NewRequest(http.MethodGet, "/api/v2/sessions/direct-session", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var session map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&session)
+	costs, _ := session["costs"].(map[string]interface{})
+	if costs == nil || costs["total_usd"] != 0.25 {
+		t.Errorf("Expected the session's cost aggregated in memory, got %v", session)
+	}
+
+	if entries, _ := os.ReadDir(cfg.OutputDir); len(entries) != 0 {
+		t.Errorf("Expected nothing written to the output directory, found %d entries", len(entries))
+	}
+}
+
+func TestInMemoryStreamDoesNotStallIngest(t *testing.T) {
+	store, err := NewStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	engine := NewEngine(store)
+	ingester := NewDirectIngester(engine)
+
+	_, _, logs := directTestRequests()
+	ingester.ConsumeLogs(logs, time.Now(), nil)
+	engine.FlushCache()
+
+	// A client reading the stream slowly must not keep the only connection
+	// from the flush, nor the flush's lock from ingestion
+	streamed := 0
+	err = store.StreamSessions("", "", TimeWindow{}, func(*Session) error {
+		streamed++
+		done := make(chan struct{})
+		go func() {
+			ingester.ConsumeLogs(logs, time.Now(), nil)
+			engine.FlushCache()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected ingestion and flushing to carry on while a stream is open")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to stream sessions: %v", err)
+	}
+	if streamed != 1 {
+		t.Errorf("Expected 1 streamed session, got %d", streamed)
+	}
+}
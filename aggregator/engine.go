@@ -1,11 +1,13 @@
 package aggregator
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/zmack/otis/anonymize"
 )
 
 type Engine struct {
@@ -17,26 +19,62 @@ type Engine struct {
 	sessionsCache      map[string]*Session                 // sessionID -> Session
 	sessionModelsCache map[string]map[string]*SessionModel // sessionID -> model -> SessionModel
 	sessionToolsCache  map[string]map[string]*SessionTool  // sessionID -> toolName -> SessionTool
+	sessionTurnsCache  map[string][]*SessionTurn           // sessionID -> turns, last one open
+	sessionTracesCache map[string]map[string]*SessionTrace // sessionID -> traceID -> SessionTrace
+
+	// Tagged ingest batches that contributed to each session
+	sessionBatchesCache map[string]map[string]*SessionBatch // sessionID -> batchID -> SessionBatch
+
+	// Usage per rollup interval, behind time series
+	sessionRollupsCache map[string]map[int64]*SessionRollup // sessionID -> interval start -> SessionRollup
+
+	// Live sliding-window throughput for active sessions
+	throughput *throughputTracker
+
+	// Active time estimated from api_request events; zero gap disables it
+	activeTimeGap   time.Duration
+	activeTimeCache map[string]*activeTimeEstimate // sessionID -> estimate
+
+	// Cost computed from token usage for models that never report it; nil
+	// pricing disables it
+	pricing           Pricing
+	costEstimateCache map[string]map[string]*costEstimate // sessionID -> model -> estimate
+
+	// Latency distributions behind the p50/p95/p99 of models and tools
+	modelLatencyCache map[string]map[string]*latencySketch // sessionID -> model -> sketch
+	toolLatencyCache  map[string]map[string]*latencySketch // sessionID -> toolName -> sketch
+
+	// Sessions' active time is split between business and after hours
+	businessHours BusinessHours
+
+	// Records with an identifier over maxIDLength bytes or with control
+	// characters are dropped and counted in rejectedRecords
+	maxIDLength     atomic.Int64
+	rejectedRecords atomic.Int64
+
+	// statsRollupInterval is how often, in nanoseconds, the periodic flush
+	// also rolls up user and org stats; zero disables it
+	statsRollupInterval atomic.Int64
+
+	// Models off the allowlist are flagged as anomalies after each flush,
+	// and the webhook notified; nil disables either. Once every stored
+	// session has been checked, only the models in sessions flushed since,
+	// gathered in modelChecks under cacheMutex, are.
+	modelAllowlist atomic.Pointer[modelAllowlist]
+	webhook        atomic.Pointer[webhookNotifier]
+	modelsScanned  atomic.Bool
+	modelChecks    map[[2]string]*Anomaly // [org, model] -> first session using it
 
-	// Legacy caches (to be removed)
-	sessionCache    map[string]*SessionStats
-	modelStatsCache map[string]map[string]*SessionModelStats // sessionID -> model -> stats
-	toolStatsCache  map[string]map[string]*SessionToolStats  // sessionID -> toolName -> stats
+	// User and organization IDs are replaced with their hashes when set
+	anonymizer *anonymize.Hasher
+
+	// Prompts are written by their own goroutine; nil without a store
+	prompts *promptWriter
 }
 
 // NewEngine creates a new aggregation engine
 func NewEngine(store *Store) *Engine {
-	engine := &Engine{
-		store:              store,
-		flushInterval:      10 * time.Second,
-		sessionsCache:      make(map[string]*Session),
-		sessionModelsCache: make(map[string]map[string]*SessionModel),
-		sessionToolsCache:  make(map[string]map[string]*SessionTool),
-		// Legacy caches (to be removed)
-		sessionCache:    make(map[string]*SessionStats),
-		modelStatsCache: make(map[string]map[string]*SessionModelStats),
-		toolStatsCache:  make(map[string]map[string]*SessionToolStats),
-	}
+	engine := newEngine(store)
 
 	// Start periodic flush
 	go engine.periodicFlush()
@@ -44,11 +82,51 @@ func NewEngine(store *Store) *Engine {
 	return engine
 }
 
-// periodicFlush periodically writes cached data to database
+// newEngine creates an engine that only flushes when asked. A nil store gives
+// a scratch engine that aggregates in memory without writing anything.
+func newEngine(store *Store) *Engine {
+	engine := &Engine{
+		store:               store,
+		flushInterval:       10 * time.Second,
+		sessionsCache:       make(map[string]*Session),
+		sessionModelsCache:  make(map[string]map[string]*SessionModel),
+		sessionToolsCache:   make(map[string]map[string]*SessionTool),
+		sessionTurnsCache:   make(map[string][]*SessionTurn),
+		sessionTracesCache:  make(map[string]map[string]*SessionTrace),
+		sessionBatchesCache: make(map[string]map[string]*SessionBatch),
+		sessionRollupsCache: make(map[string]map[int64]*SessionRollup),
+		throughput:          newThroughputTracker(),
+		activeTimeCache:     make(map[string]*activeTimeEstimate),
+		costEstimateCache:   make(map[string]map[string]*costEstimate),
+		modelLatencyCache:   make(map[string]map[string]*latencySketch),
+		toolLatencyCache:    make(map[string]map[string]*latencySketch),
+		businessHours:       DefaultBusinessHours(),
+	}
+	if store != nil {
+		engine.prompts = newPromptWriter(store, DefaultPromptQueueSize)
+		go engine.prompts.run()
+	}
+	engine.maxIDLength.Store(DefaultMaxIDLength)
+	engine.statsRollupInterval.Store(int64(DefaultStatsRollupInterval))
+	return engine
+}
+
+// periodicFlush periodically writes cached data to database, then flags
+// unapproved models and rolls up user and org stats whenever they are due
 func (e *Engine) periodicFlush() {
 	ticker := time.NewTicker(e.flushInterval)
+	var lastRollup time.Time
 	for range ticker.C {
 		e.FlushCache()
+		if err := e.FlagUnapprovedModels(time.Now()); err != nil {
+			log.Printf("Error flagging unapproved models: %v", err)
+		}
+		if e.statsRollupDue(lastRollup) {
+			if err := e.RollupStats(time.Now()); err != nil {
+				log.Printf("Error rolling up user and org stats: %v", err)
+			}
+			lastRollup = time.Now()
+		}
 	}
 }
 
@@ -57,10 +135,17 @@ func (e *Engine) FlushCache() {
 	e.cacheMutex.Lock()
 	defer e.cacheMutex.Unlock()
 
+	e.applyLatencyPercentiles()
+
 	// Flush sessions
 	sessionsCount := 0
 	for sessionID, session := range e.sessionsCache {
 		session.UpdatedAt = time.Now()
+		if primary := primaryModel(e.sessionModelsCache[sessionID]); primary != "" {
+			session.PrimaryModel = primary
+		}
+		e.splitBusinessHours(session)
+		session.APIErrorRate = apiErrorRate(session.APIRequestCount, session.APIErrorCount)
 		if err := e.store.UpsertSession(session); err != nil {
 			log.Printf("Error upserting session for %s: %v", sessionID, err)
 		} else {
@@ -76,6 +161,7 @@ func (e *Engine) FlushCache() {
 				log.Printf("Error upserting session model for session %s, model %s: %v", sessionID, model.Model, err)
 			} else {
 				sessionModelsCount++
+				e.queueModelCheck(e.sessionsCache[sessionID], model.Model)
 			}
 		}
 	}
@@ -92,40 +178,74 @@ func (e *Engine) FlushCache() {
 		}
 	}
 
-	// Legacy: Flush to old schema (to be removed)
-	for sessionID, stats := range e.sessionCache {
-		stats.UpdatedAt = time.Now()
-		if err := e.store.UpsertSessionStats(stats); err != nil {
-			log.Printf("Error upserting session stats for %s: %v", sessionID, err)
+	// Flush session_turns
+	sessionTurnsCount := 0
+	for sessionID, turns := range e.sessionTurnsCache {
+		for _, turn := range turns {
+			if err := e.store.UpsertSessionTurn(turn); err != nil {
+				log.Printf("Error upserting turn %d for session %s: %v", turn.TurnIndex, sessionID, err)
+			} else {
+				sessionTurnsCount++
+			}
 		}
 	}
-	for sessionID, modelMap := range e.modelStatsCache {
-		for _, modelStats := range modelMap {
-			if err := e.store.UpsertSessionModelStats(modelStats); err != nil {
-				log.Printf("Error upserting model stats for session %s, model %s: %v", sessionID, modelStats.Model, err)
-			}
+
+	// Flush session_traces
+	sessionTracesCount := 0
+	for sessionID, traceMap := range e.sessionTracesCache {
+		traces := make([]*SessionTrace, 0, len(traceMap))
+		for _, trace := range traceMap {
+			traces = append(traces, trace)
+		}
+		if err := e.store.UpsertSessionTraces(sessionID, traces); err != nil {
+			log.Printf("Error upserting traces for session %s: %v", sessionID, err)
+		} else {
+			sessionTracesCount += len(traces)
 		}
 	}
-	for sessionID, toolMap := range e.toolStatsCache {
-		for _, toolStats := range toolMap {
-			if err := e.store.UpsertSessionToolStats(toolStats); err != nil {
-				log.Printf("Error upserting tool stats for session %s, tool %s: %v", sessionID, toolStats.ToolName, err)
-			}
+
+	// Flush session_batches
+	for sessionID, batchMap := range e.sessionBatchesCache {
+		batches := make([]*SessionBatch, 0, len(batchMap))
+		for _, batch := range batchMap {
+			batches = append(batches, batch)
+		}
+		if err := e.store.UpsertSessionBatches(sessionID, batches); err != nil {
+			log.Printf("Error upserting batches for session %s: %v", sessionID, err)
 		}
 	}
 
-	log.Printf("Flushed %d sessions, %d session models, %d session tools to database",
-		sessionsCount, sessionModelsCount, sessionToolsCount)
+	// Flush session_rollups
+	for sessionID, rollupMap := range e.sessionRollupsCache {
+		rollups := make([]*SessionRollup, 0, len(rollupMap))
+		for _, rollup := range rollupMap {
+			rollups = append(rollups, rollup)
+		}
+		if err := e.store.UpsertSessionRollups(sessionID, rollups); err != nil {
+			log.Printf("Error upserting rollups for session %s: %v", sessionID, err)
+		}
+	}
+
+	// Drop throughput windows for sessions that are no longer active
+	e.throughput.prune()
+
+	log.Printf("Flushed %d sessions, %d session models, %d session tools, %d session turns, %d session traces to database",
+		sessionsCount, sessionModelsCount, sessionToolsCount, sessionTurnsCount, sessionTracesCount)
 }
 
 // ProcessMetric processes a metric record and updates aggregations
 func (e *Engine) ProcessMetric(record *MetricRecord) {
+	if !e.acceptIdentity(&record.SessionID, &record.UserID, &record.OrganizationID) {
+		return
+	}
+	e.anonymizeIdentity(&record.UserID, &record.OrganizationID)
 	if record.SessionID == "" {
 		return // Skip if no session ID
 	}
 
 	e.cacheMutex.Lock()
 	defer e.cacheMutex.Unlock()
+	e.recordSessionBatch(record.SessionID, record.Batch, SignalMetrics, record.Timestamp)
 
 	// Build environment info from attributes
 	env := &SessionEnv{
@@ -137,36 +257,15 @@ func (e *Engine) ProcessMetric(record *MetricRecord) {
 		OSVersion:     record.Attributes["os.version"],
 	}
 
-	// Get or create session (new schema)
+	// Get or create session
 	session := e.getOrCreateSession(record.SessionID, record.OrganizationID, record.UserID, record.Timestamp, env)
 
-	// Legacy: Get or create session stats (old schema)
-	stats, exists := e.sessionCache[record.SessionID]
-	if !exists {
-		stats = &SessionStats{
-			SessionID:      record.SessionID,
-			UserID:         record.UserID,
-			OrganizationID: record.OrganizationID,
-			ServiceName:    record.ServiceName,
-			StartTime:      record.Timestamp,
-			CreatedAt:      time.Now(),
-			TerminalType:   record.Attributes["terminal.type"],
-			HostArch:       record.Attributes["host.arch"],
-			OSType:         record.Attributes["os.type"],
-			ModelsUsed:     "[]",
-			ToolsUsed:      "{}",
-		}
-		e.sessionCache[record.SessionID] = stats
-	}
-
-	stats.LastUpdateTime = record.Timestamp
-
 	// Process specific metric types
 	switch record.MetricName {
 	case "claude_code.session.count":
 		// Session start marker
-		if stats.StartTime.IsZero() || record.Timestamp.Before(stats.StartTime) {
-			stats.StartTime = record.Timestamp
+		if session.StartTime.IsZero() || record.Timestamp.Before(session.StartTime) {
+			session.StartTime = record.Timestamp
 		}
 
 	case "claude_code.cost.usage":
@@ -174,25 +273,24 @@ func (e *Engine) ProcessMetric(record *MetricRecord) {
 		var cost float64
 		if c, ok := record.MetricValue.(float64); ok {
 			cost = c
-			stats.TotalCostUSD += cost
 			session.TotalCostUSD += cost
 		} else if costInt, ok := record.MetricValue.(int64); ok {
 			cost = float64(costInt)
-			stats.TotalCostUSD += cost
 			session.TotalCostUSD += cost
 		}
+		if cost != 0 {
+			e.sessionRollup(session, record.Timestamp).CostUSD += cost
+		}
 
-		// Track per-model cost
+		// Track per-model cost, replacing any estimate
+		if model := record.Attributes["model"]; model != "" {
+			e.useReportedCost(session, model)
+		}
 		if model := record.Attributes["model"]; model != "" && cost > 0 {
 			e.updateSessionModel(record.SessionID, model, func(sm *SessionModel) {
 				sm.CostUSD += cost
 				sm.RequestCount++
 			})
-			// Legacy
-			e.updateModelStats(record.SessionID, model, func(ms *SessionModelStats) {
-				ms.CostUSD += cost
-				ms.RequestCount++
-			})
 		}
 
 	case "claude_code.token.usage":
@@ -206,19 +304,25 @@ func (e *Engine) ProcessMetric(record *MetricRecord) {
 			tokenValue = int64(val)
 		}
 
+		rollup := e.sessionRollup(session, record.Timestamp)
 		switch tokenType {
 		case "input":
-			stats.TotalInputTokens += tokenValue
 			session.TotalInputTokens += tokenValue
+			rollup.InputTokens += tokenValue
 		case "output":
-			stats.TotalOutputTokens += tokenValue
 			session.TotalOutputTokens += tokenValue
+			rollup.OutputTokens += tokenValue
 		case "cacheRead":
-			stats.TotalCacheReadTokens += tokenValue
 			session.TotalCacheReadTokens += tokenValue
+			rollup.CacheReadTokens += tokenValue
 		case "cacheCreation":
-			stats.TotalCacheCreationTokens += tokenValue
 			session.TotalCacheCreationTokens += tokenValue
+			rollup.CacheCreationTokens += tokenValue
+		}
+
+		// Live throughput counts the same token types as the reported total
+		if tokenType == "input" || tokenType == "output" || tokenType == "cacheRead" {
+			e.throughput.record(record.SessionID, record.OrganizationID, record.Timestamp, tokenValue, 0)
 		}
 
 		// Track per-model tokens
@@ -235,44 +339,33 @@ func (e *Engine) ProcessMetric(record *MetricRecord) {
 					sm.CacheCreationTokens += tokenValue
 				}
 			})
-			// Legacy
-			e.updateModelStats(record.SessionID, model, func(ms *SessionModelStats) {
-				switch tokenType {
-				case "input":
-					ms.InputTokens += tokenValue
-				case "output":
-					ms.OutputTokens += tokenValue
-				case "cacheRead":
-					ms.CacheReadTokens += tokenValue
-				case "cacheCreation":
-					ms.CacheCreationTokens += tokenValue
-				}
-			})
+			e.estimateCost(session, model, tokenType, tokenValue, record.Timestamp)
 		}
 
 	case "claude_code.active_time.total":
-		// Add to active time
+		// Add to active time, replacing any estimate
+		e.useReportedActiveTime(session)
 		if activeTime, ok := record.MetricValue.(float64); ok {
-			stats.TotalActiveTimeSeconds += activeTime
+			session.TotalActiveTimeSeconds += activeTime
 		} else if activeTimeInt, ok := record.MetricValue.(int64); ok {
-			stats.TotalActiveTimeSeconds += float64(activeTimeInt)
+			session.TotalActiveTimeSeconds += float64(activeTimeInt)
 		}
 	}
-
-	// Track models used
-	if model := record.Attributes["model"]; model != "" {
-		e.addToModelsUsed(stats, model)
-	}
 }
 
 // ProcessLog processes a log record and updates aggregations
 func (e *Engine) ProcessLog(record *LogRecord) {
+	if !e.acceptIdentity(&record.SessionID, &record.UserID, &record.OrganizationID) {
+		return
+	}
+	e.anonymizeIdentity(&record.UserID, &record.OrganizationID)
 	if record.SessionID == "" {
 		return
 	}
 
 	e.cacheMutex.Lock()
 	defer e.cacheMutex.Unlock()
+	e.recordSessionBatch(record.SessionID, record.Batch, SignalLogs, record.Timestamp)
 
 	// Build environment info from attributes
 	env := &SessionEnv{
@@ -280,92 +373,59 @@ func (e *Engine) ProcessLog(record *LogRecord) {
 		TerminalType: extractString(record.Attributes, "terminal.type"),
 	}
 
-	// Get or create session (new schema)
+	// Get or create session
 	session := e.getOrCreateSession(record.SessionID, record.OrganizationID, record.UserID, record.Timestamp, env)
 
-	// Legacy: Get or create session stats (old schema)
-	stats, exists := e.sessionCache[record.SessionID]
-	if !exists {
-		stats = &SessionStats{
-			SessionID:      record.SessionID,
-			UserID:         record.UserID,
-			OrganizationID: record.OrganizationID,
-			ServiceName:    record.ServiceName,
-			StartTime:      record.Timestamp,
-			CreatedAt:      time.Now(),
-			ModelsUsed:     "[]",
-			ToolsUsed:      "{}",
-		}
-		e.sessionCache[record.SessionID] = stats
-	}
-
-	stats.LastUpdateTime = record.Timestamp
-
-	// Determine log type from body
-	if containsString(record.Body, "claude_code.api_request") {
-		stats.APIRequestCount++
+	// The body holds the event name exactly, so a prompt that merely mentions
+	// one is not mistaken for it
+	switch record.Body {
+	case "claude_code.api_request":
 		session.APIRequestCount++
+		e.sessionRollup(session, record.Timestamp).APIRequestCount++
+		e.throughput.record(record.SessionID, record.OrganizationID, record.Timestamp, 0, 1)
+		e.recordTurnRequest(session, record)
+		e.estimateActiveTime(session, record.Timestamp)
 
 		// Extract latency if available
 		durationMS := extractFloat(record.Attributes, "duration_ms")
 		if durationMS > 0 {
-			stats.TotalAPILatencyMS += durationMS
-			stats.AvgAPILatencyMS = stats.TotalAPILatencyMS / float64(stats.APIRequestCount)
 			session.TotalAPILatencyMS += durationMS
 		}
 
 		// Track per-model latency
 		if model := extractString(record.Attributes, "model"); model != "" && durationMS > 0 {
+			e.recordModelLatency(record.SessionID, model, durationMS)
 			e.updateSessionModel(record.SessionID, model, func(sm *SessionModel) {
 				sm.TotalLatencyMS += durationMS
 			})
-			// Legacy
-			e.updateModelStats(record.SessionID, model, func(ms *SessionModelStats) {
-				ms.TotalLatencyMS += durationMS
-				if ms.RequestCount > 0 {
-					ms.AvgLatencyMS = ms.TotalLatencyMS / float64(ms.RequestCount)
-				}
-			})
 		}
-
-	} else if containsString(record.Body, "claude_code.api_error") {
+	case "claude_code.api_error":
 		session.APIErrorCount++
-
-	} else if containsString(record.Body, "claude_code.user_prompt") {
-		stats.UserPromptCount++
+	case "claude_code.user_prompt":
 		session.UserPromptCount++
+		e.startTurn(session, record.Timestamp, true)
 
 		// Extract and store the prompt if it's not redacted
 		promptText := extractString(record.Attributes, "prompt")
-		if promptText != "" && promptText != "<REDACTED>" {
+		if promptText != "" && promptText != "<REDACTED>" && e.prompts != nil {
 			promptLength := extractInt(record.Attributes, "prompt_length")
-			prompt := &SessionPrompt{
+			e.prompts.enqueue(&SessionPrompt{
 				SessionID:    record.SessionID,
 				PromptText:   promptText,
 				PromptLength: int(promptLength),
 				Timestamp:    record.Timestamp,
-			}
-			if err := e.store.InsertSessionPrompt(prompt); err != nil {
-				log.Printf("Error inserting prompt for session %s: %v", record.SessionID, err)
-			}
-		}
-
-	} else if containsString(record.Body, "claude_code.tool_decision") {
-		// Track tool usage from decisions
-		if toolName := extractString(record.Attributes, "tool_name"); toolName != "" {
-			e.addToToolsUsed(stats, toolName)
+			})
 		}
-
-	} else if containsString(record.Body, "claude_code.tool_result") {
-		stats.ToolExecutionCount++
+	case "claude_code.tool_result":
 		session.ToolCallCount++
+		e.sessionRollup(session, record.Timestamp).ToolCallCount++
 
 		// Track success/failure
 		success := extractBool(record.Attributes, "success")
 		if success {
-			stats.ToolSuccessCount++
+			session.ToolSuccessCount++
 		} else {
-			stats.ToolFailureCount++
+			session.ToolFailureCount++
 		}
 
 		// Extract decision info
@@ -376,30 +436,12 @@ func (e *Engine) ProcessLog(record *LogRecord) {
 		// Track tool name
 		toolName := extractString(record.Attributes, "tool_name")
 		if toolName != "" {
-			e.addToToolsUsed(stats, toolName)
-
-			// Track per-tool stats (old schema)
 			durationMS := extractFloat(record.Attributes, "duration_ms")
-			e.updateToolStats(record.SessionID, toolName, func(ts *SessionToolStats) {
-				ts.ExecutionCount++
-				if success {
-					ts.SuccessCount++
-				} else {
-					ts.FailureCount++
-				}
-				if durationMS > 0 {
-					ts.TotalDurationMS += durationMS
-					ts.AvgDurationMS = ts.TotalDurationMS / float64(ts.ExecutionCount)
-					if ts.MinDurationMS == 0 || durationMS < ts.MinDurationMS {
-						ts.MinDurationMS = durationMS
-					}
-					if durationMS > ts.MaxDurationMS {
-						ts.MaxDurationMS = durationMS
-					}
-				}
-			})
+			if durationMS > 0 {
+				e.recordToolDuration(record.SessionID, toolName, durationMS)
+			}
 
-			// Track per-tool stats (new schema)
+			// Track per-tool stats
 			e.updateSessionTool(record.SessionID, toolName, func(st *SessionTool) {
 				st.CallCount++
 				if success {
@@ -409,6 +451,12 @@ func (e *Engine) ProcessLog(record *LogRecord) {
 				}
 				if durationMS > 0 {
 					st.TotalExecutionTimeMS += durationMS
+					if st.MinExecutionTimeMS == 0 || durationMS < st.MinExecutionTimeMS {
+						st.MinExecutionTimeMS = durationMS
+					}
+					if durationMS > st.MaxExecutionTimeMS {
+						st.MaxExecutionTimeMS = durationMS
+					}
 				}
 
 				// Track decision type
@@ -430,30 +478,24 @@ func (e *Engine) ProcessLog(record *LogRecord) {
 
 // ProcessTrace processes a trace record and updates aggregations
 func (e *Engine) ProcessTrace(record *TraceRecord) {
+	if !e.acceptIdentity(&record.SessionID, &record.UserID, &record.OrganizationID) {
+		return
+	}
+	e.anonymizeIdentity(&record.UserID, &record.OrganizationID)
 	if record.SessionID == "" {
 		return
 	}
 
 	e.cacheMutex.Lock()
 	defer e.cacheMutex.Unlock()
+	e.recordSessionBatch(record.SessionID, record.Batch, SignalTraces, record.Timestamp)
 
-	// Get or create session stats
-	stats, exists := e.sessionCache[record.SessionID]
-	if !exists {
-		stats = &SessionStats{
-			SessionID:      record.SessionID,
-			UserID:         record.UserID,
-			OrganizationID: record.OrganizationID,
-			ServiceName:    record.ServiceName,
-			StartTime:      record.Timestamp,
-			CreatedAt:      time.Now(),
-			ModelsUsed:     "[]",
-			ToolsUsed:      "{}",
-		}
-		e.sessionCache[record.SessionID] = stats
-	}
+	// Spans count as session activity, even in sessions with nothing else
+	e.getOrCreateSession(record.SessionID, record.OrganizationID, record.UserID, record.Timestamp, &SessionEnv{ClientName: record.ServiceName})
 
-	stats.LastUpdateTime = record.Timestamp
+	if record.TraceID != "" {
+		e.recordSessionTrace(record)
+	}
 
 	// Could track span performance metrics here
 	// For now, we're mainly using logs for detailed tracking
@@ -461,63 +503,6 @@ func (e *Engine) ProcessTrace(record *TraceRecord) {
 
 // Helper functions
 
-func (e *Engine) addToModelsUsed(stats *SessionStats, model string) {
-	var models []string
-	if err := json.Unmarshal([]byte(stats.ModelsUsed), &models); err != nil {
-		models = []string{}
-	}
-
-	// Add if not already present
-	found := false
-	for _, m := range models {
-		if m == model {
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		models = append(models, model)
-		if data, err := json.Marshal(models); err == nil {
-			stats.ModelsUsed = string(data)
-		}
-	}
-}
-
-func (e *Engine) addToToolsUsed(stats *SessionStats, toolName string) {
-	var tools map[string]int
-	if err := json.Unmarshal([]byte(stats.ToolsUsed), &tools); err != nil {
-		tools = make(map[string]int)
-	}
-
-	tools[toolName]++
-
-	if data, err := json.Marshal(tools); err == nil {
-		stats.ToolsUsed = string(data)
-	}
-}
-
-func containsString(s, substr string) bool {
-	return len(s) > 0 && len(substr) > 0 &&
-		(s == substr || len(s) > len(substr) && stringContains(s, substr))
-}
-
-func stringContains(s, substr string) bool {
-	return len(s) >= len(substr) &&
-		(s == substr ||
-		 (len(s) > len(substr) &&
-		  (hasSubstring(s, substr))))
-}
-
-func hasSubstring(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
 func extractFloat(attrs map[string]interface{}, key string) float64 {
 	if val, ok := attrs[key]; ok {
 		// Try different numeric types
@@ -612,48 +597,6 @@ func extractBool(attrs map[string]interface{}, key string) bool {
 	return false
 }
 
-// updateModelStats gets or creates model stats for a session and applies the update function
-func (e *Engine) updateModelStats(sessionID, model string, updateFn func(*SessionModelStats)) {
-	// Get or create session-level map
-	if e.modelStatsCache[sessionID] == nil {
-		e.modelStatsCache[sessionID] = make(map[string]*SessionModelStats)
-	}
-
-	// Get or create model stats
-	modelStats, exists := e.modelStatsCache[sessionID][model]
-	if !exists {
-		modelStats = &SessionModelStats{
-			SessionID: sessionID,
-			Model:     model,
-		}
-		e.modelStatsCache[sessionID][model] = modelStats
-	}
-
-	// Apply update
-	updateFn(modelStats)
-}
-
-// updateToolStats gets or creates tool stats for a session and applies the update function
-func (e *Engine) updateToolStats(sessionID, toolName string, updateFn func(*SessionToolStats)) {
-	// Get or create session-level map
-	if e.toolStatsCache[sessionID] == nil {
-		e.toolStatsCache[sessionID] = make(map[string]*SessionToolStats)
-	}
-
-	// Get or create tool stats
-	toolStats, exists := e.toolStatsCache[sessionID][toolName]
-	if !exists {
-		toolStats = &SessionToolStats{
-			SessionID: sessionID,
-			ToolName:  toolName,
-		}
-		e.toolStatsCache[sessionID][toolName] = toolStats
-	}
-
-	// Apply update
-	updateFn(toolStats)
-}
-
 // SessionEnv holds environment information for a session
 type SessionEnv struct {
 	ClientName    string
@@ -664,7 +607,7 @@ type SessionEnv struct {
 	OSVersion     string
 }
 
-// getOrCreateSession gets or creates a session in the new schema cache
+// getOrCreateSession gets or creates a session in the cache
 func (e *Engine) getOrCreateSession(sessionID, orgID, userID string, timestamp time.Time, env *SessionEnv) *Session {
 	session, exists := e.sessionsCache[sessionID]
 	if !exists {
@@ -678,26 +621,8 @@ func (e *Engine) getOrCreateSession(sessionID, orgID, userID string, timestamp t
 		e.sessionsCache[sessionID] = session
 	}
 
-	// Update environment info if provided (first time we see it)
 	if env != nil {
-		if session.ClientName == "" && env.ClientName != "" {
-			session.ClientName = env.ClientName
-		}
-		if session.ClientVersion == "" && env.ClientVersion != "" {
-			session.ClientVersion = env.ClientVersion
-		}
-		if session.TerminalType == "" && env.TerminalType != "" {
-			session.TerminalType = env.TerminalType
-		}
-		if session.HostArch == "" && env.HostArch != "" {
-			session.HostArch = env.HostArch
-		}
-		if session.OSType == "" && env.OSType != "" {
-			session.OSType = env.OSType
-		}
-		if session.OSVersion == "" && env.OSVersion != "" {
-			session.OSVersion = env.OSVersion
-		}
+		mergeSessionEnv(session, env, timestamp)
 	}
 
 	// Update end_time to track last activity
@@ -705,6 +630,43 @@ func (e *Engine) getOrCreateSession(sessionID, orgID, userID string, timestamp t
 	return session
 }
 
+// mergeSessionEnv folds a record's environment info into its session. Empty
+// fields are filled in whenever a value arrives. A field that already has a
+// different value marks the session as changed and takes the new value,
+// unless the record predates the one that last set the environment.
+func mergeSessionEnv(session *Session, env *SessionEnv, timestamp time.Time) {
+	latest := !timestamp.Before(session.envSeenAt)
+	carried := false
+	for _, f := range []struct {
+		field *string
+		value string
+	}{
+		{&session.ClientName, env.ClientName},
+		{&session.ClientVersion, env.ClientVersion},
+		{&session.TerminalType, env.TerminalType},
+		{&session.HostArch, env.HostArch},
+		{&session.OSType, env.OSType},
+		{&session.OSVersion, env.OSVersion},
+	} {
+		if f.value != "" {
+			carried = true
+		}
+		switch {
+		case f.value == "" || f.value == *f.field:
+		case *f.field == "":
+			*f.field = f.value
+		default:
+			session.EnvChanged = true
+			if latest {
+				*f.field = f.value
+			}
+		}
+	}
+	if carried && latest {
+		session.envSeenAt = timestamp
+	}
+}
+
 // updateSessionModel gets or creates a session model in the cache and applies the update function
 func (e *Engine) updateSessionModel(sessionID, model string, updateFn func(*SessionModel)) {
 	if e.sessionModelsCache[sessionID] == nil {
@@ -718,13 +680,45 @@ func (e *Engine) updateSessionModel(sessionID, model string, updateFn func(*Sess
 			Model:     model,
 		}
 		e.sessionModelsCache[sessionID][model] = sm
+
+		if session, ok := e.sessionsCache[sessionID]; ok && session.FirstModel == "" {
+			session.FirstModel = model
+		}
 	}
 
 	updateFn(sm)
 }
 
-// updateSessionTool gets or creates a session tool in the new schema cache and applies the update function
+// apiErrorRate is the fraction of API calls that failed, zero before any
+func apiErrorRate(requests, errors int) float64 {
+	if requests+errors == 0 {
+		return 0
+	}
+	return float64(errors) / float64(requests+errors)
+}
+
+// primaryModel picks the model with the most requests, breaking ties by cost
+// and then by name so the choice is stable
+func primaryModel(models map[string]*SessionModel) string {
+	var primary *SessionModel
+	for _, sm := range models {
+		if primary == nil ||
+			sm.RequestCount > primary.RequestCount ||
+			(sm.RequestCount == primary.RequestCount && sm.CostUSD > primary.CostUSD) ||
+			(sm.RequestCount == primary.RequestCount && sm.CostUSD == primary.CostUSD && sm.Model < primary.Model) {
+			primary = sm
+		}
+	}
+	if primary == nil {
+		return ""
+	}
+	return primary.Model
+}
+
+// updateSessionTool gets or creates a session tool in the cache and applies the update function
 func (e *Engine) updateSessionTool(sessionID, toolName string, updateFn func(*SessionTool)) {
+	toolName = normalizeToolName(toolName)
+
 	// Get or create session-level map
 	if e.sessionToolsCache[sessionID] == nil {
 		e.sessionToolsCache[sessionID] = make(map[string]*SessionTool)
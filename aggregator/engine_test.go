@@ -36,7 +36,7 @@ func TestEngineProcessMetric(t *testing.T) {
 
 	// Verify session was created in cache
 	engine.cacheMutex.RLock()
-	session, exists := engine.sessionCache["session-123"]
+	session, exists := engine.sessionsCache["session-123"]
 	engine.cacheMutex.RUnlock()
 
 	if !exists {
@@ -63,7 +63,7 @@ func TestEngineProcessMetric(t *testing.T) {
 	engine.ProcessMetric(tokenRecord)
 
 	engine.cacheMutex.RLock()
-	session = engine.sessionCache["session-123"]
+	session = engine.sessionsCache["session-123"]
 	engine.cacheMutex.RUnlock()
 
 	if session.TotalInputTokens != 1000 {
@@ -84,7 +84,7 @@ func TestEngineProcessMetric(t *testing.T) {
 	engine.ProcessMetric(outputTokenRecord)
 
 	engine.cacheMutex.RLock()
-	session = engine.sessionCache["session-123"]
+	session = engine.sessionsCache["session-123"]
 	engine.cacheMutex.RUnlock()
 
 	if session.TotalOutputTokens != 500 {
@@ -121,7 +121,7 @@ func TestEngineProcessLog(t *testing.T) {
 	engine.ProcessLog(apiRecord)
 
 	engine.cacheMutex.RLock()
-	session := engine.sessionCache["session-456"]
+	session := engine.sessionsCache["session-456"]
 	engine.cacheMutex.RUnlock()
 
 	if session.APIRequestCount != 1 {
@@ -142,7 +142,7 @@ func TestEngineProcessLog(t *testing.T) {
 	engine.ProcessLog(promptRecord)
 
 	engine.cacheMutex.RLock()
-	session = engine.sessionCache["session-456"]
+	session = engine.sessionsCache["session-456"]
 	engine.cacheMutex.RUnlock()
 
 	if session.UserPromptCount != 1 {
@@ -167,11 +167,11 @@ func TestEngineProcessLog(t *testing.T) {
 	engine.ProcessLog(toolRecord)
 
 	engine.cacheMutex.RLock()
-	session = engine.sessionCache["session-456"]
+	session = engine.sessionsCache["session-456"]
 	engine.cacheMutex.RUnlock()
 
-	if session.ToolExecutionCount != 1 {
-		t.Errorf("Expected 1 tool execution, got %d", session.ToolExecutionCount)
+	if session.ToolCallCount != 1 {
+		t.Errorf("Expected 1 tool execution, got %d", session.ToolCallCount)
 	}
 
 	if session.ToolSuccessCount != 1 {
@@ -179,6 +179,46 @@ func TestEngineProcessLog(t *testing.T) {
 	}
 }
 
+func TestEngineProcessLogMatchesEventNamesExactly(t *testing.T) {
+	dbPath := "./test_engine_event_names.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+
+	// Bodies that mention an event name without being it are not that event
+	for _, body := range []string{
+		"why is claude_code.tool_result counted twice?",
+		"claude_code.api_request.retry",
+		"",
+	} {
+		engine.ProcessLog(&LogRecord{
+			Timestamp: time.Now(),
+			SessionID: "session-mention",
+			Body:      body,
+			Attributes: map[string]interface{}{
+				"tool_name": map[string]interface{}{"stringValue": "Read"},
+			},
+		})
+	}
+
+	engine.cacheMutex.RLock()
+	session := engine.sessionsCache["session-mention"]
+	engine.cacheMutex.RUnlock()
+
+	if session.ToolCallCount != 0 {
+		t.Errorf("Expected no tool executions, got %d", session.ToolCallCount)
+	}
+	if session.APIRequestCount != 0 {
+		t.Errorf("Expected no API requests, got %d", session.APIRequestCount)
+	}
+}
+
 func TestEngineFlushCache(t *testing.T) {
 	dbPath := "./test_engine_flush.db"
 	defer os.Remove(dbPath)
@@ -207,13 +247,13 @@ func TestEngineFlushCache(t *testing.T) {
 	engine.FlushCache()
 
 	// Verify data was written to database
-	stats, err := store.GetSessionStats("flush-test")
+	session, err := store.GetSession("flush-test")
 	if err != nil {
-		t.Fatalf("Failed to retrieve flushed stats: %v", err)
+		t.Fatalf("Failed to retrieve flushed session: %v", err)
 	}
 
-	if stats.TotalCostUSD != 5.0 {
-		t.Errorf("Expected flushed cost 5.0, got %f", stats.TotalCostUSD)
+	if session.TotalCostUSD != 5.0 {
+		t.Errorf("Expected flushed cost 5.0, got %f", session.TotalCostUSD)
 	}
 }
 
@@ -284,7 +324,7 @@ func TestEnginePerModelTracking(t *testing.T) {
 
 	// Verify model stats in cache
 	engine.cacheMutex.RLock()
-	modelStats, exists := engine.modelStatsCache[sessionID]["claude-sonnet-4-5"]
+	modelStats, exists := engine.sessionModelsCache[sessionID]["claude-sonnet-4-5"]
 	engine.cacheMutex.RUnlock()
 
 	if !exists {
@@ -310,10 +350,10 @@ func TestEnginePerModelTracking(t *testing.T) {
 	// Flush and verify it was written to database
 	engine.FlushCache()
 
-	var retrievedModelStats SessionModelStats
+	var retrievedModelStats SessionModel
 	err = store.db.QueryRow(`
 		SELECT session_id, model, cost_usd, input_tokens, output_tokens, cache_read_tokens, request_count
-		FROM session_model_stats
+		FROM session_models
 		WHERE session_id = ? AND model = ?
 	`, sessionID, "claude-sonnet-4-5").Scan(
 		&retrievedModelStats.SessionID, &retrievedModelStats.Model,
@@ -405,15 +445,15 @@ func TestEnginePerToolTracking(t *testing.T) {
 
 	// Verify tool stats in cache
 	engine.cacheMutex.RLock()
-	toolStats, exists := engine.toolStatsCache[sessionID]["Read"]
+	toolStats, exists := engine.sessionToolsCache[sessionID]["Read"]
 	engine.cacheMutex.RUnlock()
 
 	if !exists {
 		t.Fatal("Tool stats not found in cache")
 	}
 
-	if toolStats.ExecutionCount != 3 {
-		t.Errorf("Expected execution count 3, got %d", toolStats.ExecutionCount)
+	if toolStats.CallCount != 3 {
+		t.Errorf("Expected execution count 3, got %d", toolStats.CallCount)
 	}
 	if toolStats.SuccessCount != 2 {
 		t.Errorf("Expected success count 2, got %d", toolStats.SuccessCount)
@@ -421,40 +461,40 @@ func TestEnginePerToolTracking(t *testing.T) {
 	if toolStats.FailureCount != 1 {
 		t.Errorf("Expected failure count 1, got %d", toolStats.FailureCount)
 	}
-	if toolStats.MinDurationMS != 12.3 {
-		t.Errorf("Expected min duration 12.3, got %f", toolStats.MinDurationMS)
+	if toolStats.MinExecutionTimeMS != 12.3 {
+		t.Errorf("Expected min duration 12.3, got %f", toolStats.MinExecutionTimeMS)
 	}
-	if toolStats.MaxDurationMS != 120.8 {
-		t.Errorf("Expected max duration 120.8, got %f", toolStats.MaxDurationMS)
+	if toolStats.MaxExecutionTimeMS != 120.8 {
+		t.Errorf("Expected max duration 120.8, got %f", toolStats.MaxExecutionTimeMS)
 	}
 
-	expectedAvg := (45.2 + 120.8 + 12.3) / 3.0
+	expectedTotal := 45.2 + 120.8 + 12.3
 	// Use approximate comparison for floating point
-	if diff := toolStats.AvgDurationMS - expectedAvg; diff < -0.001 || diff > 0.001 {
-		t.Errorf("Expected avg duration %f, got %f", expectedAvg, toolStats.AvgDurationMS)
+	if diff := toolStats.TotalExecutionTimeMS - expectedTotal; diff < -0.001 || diff > 0.001 {
+		t.Errorf("Expected total duration %f, got %f", expectedTotal, toolStats.TotalExecutionTimeMS)
 	}
 
 	// Flush and verify it was written to database
 	engine.FlushCache()
 
-	var retrievedToolStats SessionToolStats
+	var retrievedToolStats SessionTool
 	err = store.db.QueryRow(`
-		SELECT session_id, tool_name, execution_count, success_count, failure_count,
-			avg_duration_ms, min_duration_ms, max_duration_ms
-		FROM session_tool_stats
+		SELECT session_id, tool_name, call_count, success_count, failure_count,
+			min_execution_time_ms, max_execution_time_ms
+		FROM session_tools
 		WHERE session_id = ? AND tool_name = ?
 	`, sessionID, "Read").Scan(
 		&retrievedToolStats.SessionID, &retrievedToolStats.ToolName,
-		&retrievedToolStats.ExecutionCount, &retrievedToolStats.SuccessCount,
-		&retrievedToolStats.FailureCount, &retrievedToolStats.AvgDurationMS,
-		&retrievedToolStats.MinDurationMS, &retrievedToolStats.MaxDurationMS,
+		&retrievedToolStats.CallCount, &retrievedToolStats.SuccessCount,
+		&retrievedToolStats.FailureCount,
+		&retrievedToolStats.MinExecutionTimeMS, &retrievedToolStats.MaxExecutionTimeMS,
 	)
 	if err != nil {
 		t.Fatalf("Failed to retrieve flushed tool stats: %v", err)
 	}
 
-	if retrievedToolStats.ExecutionCount != 3 {
-		t.Errorf("Expected flushed execution count 3, got %d", retrievedToolStats.ExecutionCount)
+	if retrievedToolStats.CallCount != 3 {
+		t.Errorf("Expected flushed execution count 3, got %d", retrievedToolStats.CallCount)
 	}
 	if retrievedToolStats.SuccessCount != 2 {
 		t.Errorf("Expected flushed success count 2, got %d", retrievedToolStats.SuccessCount)
@@ -587,24 +627,24 @@ func TestToolResultWithStringValues(t *testing.T) {
 
 	// Verify tool stats were captured correctly
 	engine.cacheMutex.RLock()
-	toolStats, exists := engine.toolStatsCache[sessionID]["Bash"]
-	session := engine.sessionCache[sessionID]
+	toolStats, exists := engine.sessionToolsCache[sessionID]["Bash"]
+	session := engine.sessionsCache[sessionID]
 	engine.cacheMutex.RUnlock()
 
 	if !exists {
 		t.Fatal("Tool stats not found in cache - stringValue format not handled correctly")
 	}
 
-	if toolStats.ExecutionCount != 1 {
-		t.Errorf("Expected execution count 1, got %d", toolStats.ExecutionCount)
+	if toolStats.CallCount != 1 {
+		t.Errorf("Expected execution count 1, got %d", toolStats.CallCount)
 	}
 
 	if toolStats.SuccessCount != 1 {
 		t.Errorf("Expected success count 1, got %d (stringValue 'true' not parsed correctly)", toolStats.SuccessCount)
 	}
 
-	if toolStats.AvgDurationMS != 37.0 {
-		t.Errorf("Expected duration 37.0, got %f (stringValue '37' not parsed correctly)", toolStats.AvgDurationMS)
+	if toolStats.TotalExecutionTimeMS != 37.0 {
+		t.Errorf("Expected duration 37.0, got %f (stringValue '37' not parsed correctly)", toolStats.TotalExecutionTimeMS)
 	}
 
 	if session.ToolSuccessCount != 1 {
@@ -644,8 +684,8 @@ func TestToolResultWithStringFalse(t *testing.T) {
 	engine.ProcessLog(toolRecord)
 
 	engine.cacheMutex.RLock()
-	toolStats := engine.toolStatsCache[sessionID]["Bash"]
-	session := engine.sessionCache[sessionID]
+	toolStats := engine.sessionToolsCache[sessionID]["Bash"]
+	session := engine.sessionsCache[sessionID]
 	engine.cacheMutex.RUnlock()
 
 	if toolStats.SuccessCount != 0 {
@@ -691,7 +731,7 @@ func TestEngineMultipleModels(t *testing.T) {
 
 	// Verify all models are tracked
 	engine.cacheMutex.RLock()
-	modelMap := engine.modelStatsCache[sessionID]
+	modelMap := engine.sessionModelsCache[sessionID]
 	engine.cacheMutex.RUnlock()
 
 	if len(modelMap) != 3 {
@@ -715,7 +755,7 @@ func TestEngineMultipleModels(t *testing.T) {
 
 	var count int
 	err = store.db.QueryRow(`
-		SELECT COUNT(*) FROM session_model_stats WHERE session_id = ?
+		SELECT COUNT(*) FROM session_models WHERE session_id = ?
 	`, sessionID).Scan(&count)
 	if err != nil {
 		t.Fatalf("Failed to count flushed model stats: %v", err)
@@ -726,6 +766,46 @@ func TestEngineMultipleModels(t *testing.T) {
 	}
 }
 
+func TestEnginePrimaryModel(t *testing.T) {
+	dbPath := "./test_engine_primary_model.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+	sessionID := "primary-model-session"
+
+	// haiku is seen first, but sonnet handles the most requests
+	requests := []string{"claude-haiku-4", "claude-sonnet-4-5", "claude-opus-4-5", "claude-sonnet-4-5", "claude-sonnet-4-5", "claude-opus-4-5"}
+	for _, model := range requests {
+		engine.ProcessMetric(&MetricRecord{
+			Timestamp:   time.Now(),
+			SessionID:   sessionID,
+			MetricName:  "claude_code.cost.usage",
+			MetricValue: 0.01,
+			Attributes: map[string]string{
+				"model": model,
+			},
+		})
+	}
+	engine.FlushCache()
+
+	session, err := store.GetSession(sessionID)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if session.PrimaryModel != "claude-sonnet-4-5" {
+		t.Errorf("Expected primary model claude-sonnet-4-5, got %q", session.PrimaryModel)
+	}
+	if session.FirstModel != "claude-haiku-4" {
+		t.Errorf("Expected first model claude-haiku-4, got %q", session.FirstModel)
+	}
+}
+
 func TestEngineProcessLogUserPromptStorage(t *testing.T) {
 	dbPath := "./test_engine_prompts.db"
 	defer os.Remove(dbPath)
@@ -756,6 +836,7 @@ func TestEngineProcessLogUserPromptStorage(t *testing.T) {
 	}
 
 	engine.ProcessLog(promptRecord)
+	engine.FlushPrompts()
 
 	// Verify prompt was stored
 	prompts, err := store.GetSessionPrompts(sessionID)
@@ -805,6 +886,7 @@ func TestEngineProcessLogUserPromptSkipsRedacted(t *testing.T) {
 	}
 
 	engine.ProcessLog(redactedRecord)
+	engine.FlushPrompts()
 
 	// Verify prompt was NOT stored
 	prompts, err := store.GetSessionPrompts(sessionID)
@@ -818,7 +900,7 @@ func TestEngineProcessLogUserPromptSkipsRedacted(t *testing.T) {
 
 	// Verify count was still incremented
 	engine.cacheMutex.RLock()
-	session := engine.sessionCache[sessionID]
+	session := engine.sessionsCache[sessionID]
 	engine.cacheMutex.RUnlock()
 
 	if session.UserPromptCount != 1 {
@@ -848,6 +930,7 @@ func TestEngineProcessLogUserPromptSkipsEmpty(t *testing.T) {
 	}
 
 	engine.ProcessLog(emptyRecord)
+	engine.FlushPrompts()
 
 	// Verify prompt was NOT stored
 	prompts, err := store.GetSessionPrompts(sessionID)
@@ -859,3 +942,109 @@ func TestEngineProcessLogUserPromptSkipsEmpty(t *testing.T) {
 		t.Errorf("Expected 0 prompts (empty should be skipped), got %d", len(prompts))
 	}
 }
+
+func TestEngineSessionEnvFillsInAndTracksChanges(t *testing.T) {
+	engine := newEngine(nil)
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	record := func(minutes int, attrs map[string]string) *MetricRecord {
+		return &MetricRecord{
+			Timestamp:   start.Add(time.Duration(minutes) * time.Minute),
+			SessionID:   "resumed-session",
+			ServiceName: "claude-code",
+			MetricName:  "claude_code.session.count",
+			MetricValue: int64(1),
+			Attributes:  attrs,
+		}
+	}
+	session := func() Session {
+		engine.cacheMutex.RLock()
+		defer engine.cacheMutex.RUnlock()
+		return *engine.sessionsCache["resumed-session"]
+	}
+
+	// Values missing from the first record fill in as they arrive
+	engine.ProcessMetric(record(0, map[string]string{"os.type": "darwin"}))
+	engine.ProcessMetric(record(1, map[string]string{"service.version": "1.0.40", "terminal.type": "iTerm.app"}))
+	if s := session(); s.ClientVersion != "1.0.40" || s.TerminalType != "iTerm.app" || s.OSType != "darwin" || s.EnvChanged {
+		t.Errorf("Expected filled in environment without a change, got %+v", s)
+	}
+
+	// A client upgrade takes the new version and marks the change
+	engine.ProcessMetric(record(60, map[string]string{"service.version": "1.0.41", "terminal.type": "iTerm.app"}))
+	if s := session(); s.ClientVersion != "1.0.41" || !s.EnvChanged {
+		t.Errorf("Expected the upgraded version flagged as a change, got %+v", s)
+	}
+
+	// A late record from before the upgrade is a change but not the latest value
+	engine.ProcessMetric(record(30, map[string]string{"service.version": "1.0.40"}))
+	if s := session(); s.ClientVersion != "1.0.41" {
+		t.Errorf("Expected the latest version to stick, got %s", s.ClientVersion)
+	}
+
+	// Records without environment info leave the latest timestamp alone
+	bare := record(90, nil)
+	bare.ServiceName = ""
+	engine.ProcessMetric(bare)
+	engine.ProcessMetric(record(75, map[string]string{"service.version": "1.0.42"}))
+	if s := session(); s.ClientVersion != "1.0.42" {
+		t.Errorf("Expected 1.0.42 as the most recent reported version, got %s", s.ClientVersion)
+	}
+}
+
+func TestStoreSessionEnvRoundTrip(t *testing.T) {
+	dbPath := "./test_session_env.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	session := &Session{
+		SessionID:     "env-session",
+		UserID:        "user-1",
+		StartTime:     now,
+		ClientName:    "claude-code",
+		ClientVersion: "1.0.40",
+		TerminalType:  "vscode",
+		HostArch:      "arm64",
+		OSType:        "darwin",
+		OSVersion:     "24.5.0",
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := store.UpsertSession(session); err != nil {
+		t.Fatalf("Failed to upsert session: %v", err)
+	}
+
+	got, err := store.GetSession("env-session")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if got.ClientName != "claude-code" || got.ClientVersion != "1.0.40" || got.TerminalType != "vscode" ||
+		got.HostArch != "arm64" || got.OSType != "darwin" || got.OSVersion != "24.5.0" || got.EnvChanged {
+		t.Errorf("Expected every environment field to round trip, got %+v", got)
+	}
+
+	// A restarted engine only knows what it has seen since; empty fields keep
+	// the stored values and a differing one is still recorded as a change
+	if err := store.UpsertSession(&Session{
+		SessionID:     "env-session",
+		UserID:        "user-1",
+		StartTime:     now,
+		ClientVersion: "1.0.41",
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}); err != nil {
+		t.Fatalf("Failed to upsert session: %v", err)
+	}
+	got, err = store.GetSession("env-session")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if got.ClientVersion != "1.0.41" || got.OSVersion != "24.5.0" || !got.EnvChanged {
+		t.Errorf("Expected the new version, the kept OS version and a change, got %+v", got)
+	}
+}
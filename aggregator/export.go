@@ -0,0 +1,526 @@
+package aggregator
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export job statuses
+const (
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+)
+
+// exportColumns is the CSV header of a session export
+var exportColumns = []string{
+	"session_id", "organization_id", "user_id", "start_time", "end_time",
+	"total_cost_usd", "input_tokens", "output_tokens", "cache_read_tokens", "cache_creation_tokens",
+	"tool_call_count", "first_model", "primary_model",
+}
+
+// Exporter runs export jobs in the background, writing each as CSV.
+// Finished files stay in dir until the job expires, then the file and the
+// job are removed. Jobs are picked up by the exporter's own ticker.
+type Exporter struct {
+	store     *Store
+	dir       string
+	retention time.Duration
+	interval  time.Duration
+	wake      chan struct{}
+	stopChan  chan bool
+	done      chan struct{}
+	now       func() time.Time
+}
+
+// NewExporter creates an exporter writing to dir and keeping finished
+// exports for retention
+func NewExporter(store *Store, dir string, retention time.Duration) *Exporter {
+	return &Exporter{
+		store:     store,
+		dir:       dir,
+		retention: retention,
+		interval:  5 * time.Second,
+		wake:      make(chan struct{}, 1),
+		stopChan:  make(chan bool),
+		done:      make(chan struct{}),
+		now:       time.Now,
+	}
+}
+
+// Start runs queued jobs as they are submitted and removes expired exports.
+// Jobs left running by a previous process are queued again.
+func (e *Exporter) Start() error {
+	if err := os.MkdirAll(e.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create export directory %s: %w", e.dir, err)
+	}
+	if err := e.store.RequeueRunningExportJobs(); err != nil {
+		return fmt.Errorf("failed to requeue export jobs: %w", err)
+	}
+
+	ticker := time.NewTicker(e.interval)
+	go func() {
+		defer close(e.done)
+		for {
+			e.runPending()
+			e.removeExpired()
+
+			select {
+			case <-ticker.C:
+			case <-e.wake:
+			case <-e.stopChan:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the exporter, waiting for a job in progress to finish.
+// Start must have been called.
+func (e *Exporter) Stop() {
+	close(e.stopChan)
+	<-e.done
+}
+
+// Submit queues an export of the sessions matching the filters
+func (e *Exporter) Submit(orgID, userID string, window TimeWindow) (*ExportJob, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate job ID: %w", err)
+	}
+
+	start, end := windowBounds(window)
+	job := &ExportJob{
+		JobID:          hex.EncodeToString(id),
+		Status:         ExportPending,
+		OrganizationID: orgID,
+		UserID:         userID,
+		WindowType:     window.Type,
+		WindowStart:    start,
+		WindowEnd:      end,
+		CreatedAt:      e.now(),
+	}
+	if err := e.store.CreateExportJob(job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case e.wake <- struct{}{}:
+	default: // A wake-up is already pending
+	}
+	return job, nil
+}
+
+// runPending runs queued jobs, oldest first, until none are left
+func (e *Exporter) runPending() {
+	for {
+		job, err := e.store.ClaimExportJob()
+		if err != nil {
+			log.Printf("Error claiming export job: %v", err)
+			return
+		}
+		if job == nil {
+			return
+		}
+
+		if err := e.runJob(job); err != nil {
+			log.Printf("Export job %s failed: %v", job.JobID, err)
+			if err := e.store.FailExportJob(job.JobID, err.Error(), e.now(), e.now().Add(e.retention)); err != nil {
+				log.Printf("Error recording failure of export job %s: %v", job.JobID, err)
+			}
+		}
+	}
+}
+
+// runJob writes the job's CSV to a temporary name and renames it into place,
+// so a completed job never points at a partial file
+func (e *Exporter) runJob(job *ExportJob) error {
+	path := filepath.Join(e.dir, job.JobID+".csv")
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	w := csv.NewWriter(f)
+	w.Write(exportColumns)
+	rows := 0
+	err = e.store.ExportSessions(job, func(session *Session) error {
+		rows++
+		endTime := ""
+		if !session.EndTime.IsZero() {
+			endTime = session.EndTime.UTC().Format(time.RFC3339)
+		}
+		return w.Write([]string{
+			session.SessionID, session.OrganizationID, session.UserID,
+			session.StartTime.UTC().Format(time.RFC3339), endTime,
+			strconv.FormatFloat(session.TotalCostUSD, 'f', -1, 64),
+			strconv.FormatInt(session.TotalInputTokens, 10),
+			strconv.FormatInt(session.TotalOutputTokens, 10),
+			strconv.FormatInt(session.TotalCacheReadTokens, 10),
+			strconv.FormatInt(session.TotalCacheCreationTokens, 10),
+			strconv.Itoa(session.ToolCallCount),
+			session.FirstModel, session.PrimaryModel,
+		})
+	})
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat export: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename export: %w", err)
+	}
+
+	job.FilePath = path
+	job.SizeBytes = info.Size()
+	job.RowCount = rows
+	job.CompletedAt = e.now()
+	job.ExpiresAt = job.CompletedAt.Add(e.retention)
+	return e.store.CompleteExportJob(job)
+}
+
+// removeExpired deletes finished jobs past their retention with their files
+func (e *Exporter) removeExpired() {
+	jobs, err := e.store.GetExpiredExportJobs(e.now())
+	if err != nil {
+		log.Printf("Error listing expired export jobs: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if job.FilePath != "" {
+			if err := os.Remove(job.FilePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error removing export %s: %v", job.FilePath, err)
+				continue
+			}
+		}
+		if err := e.store.DeleteExportJob(job.JobID); err != nil {
+			log.Printf("Error deleting export job %s: %v", job.JobID, err)
+		}
+	}
+}
+
+// CreateExportJob inserts a new export job
+func (s *Store) CreateExportJob(job *ExportJob) error {
+	query := `
+	INSERT INTO export_jobs (
+		job_id, status, organization_id, user_id,
+		window_type, window_start, window_end, created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := s.exec(queryCreateExportJob, query,
+		job.JobID, job.Status, job.OrganizationID, job.UserID,
+		job.WindowType, job.WindowStart, job.WindowEnd, job.CreatedAt.Unix(),
+	)
+	return err
+}
+
+// exportJobColumns is the column list scanned by scanExportJob
+const exportJobColumns = `job_id, status, COALESCE(organization_id, ''), COALESCE(user_id, ''),
+	window_type, window_start, window_end,
+	COALESCE(file_path, ''), size_bytes, row_count, COALESCE(error, ''),
+	created_at, completed_at, expires_at`
+
+// scanExportJob scans a row selected with exportJobColumns
+func scanExportJob(scan func(dest ...interface{}) error) (*ExportJob, error) {
+	var job ExportJob
+	var createdAt int64
+	var completedAt, expiresAt sql.NullInt64
+
+	err := scan(
+		&job.JobID, &job.Status, &job.OrganizationID, &job.UserID,
+		&job.WindowType, &job.WindowStart, &job.WindowEnd,
+		&job.FilePath, &job.SizeBytes, &job.RowCount, &job.Error,
+		&createdAt, &completedAt, &expiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.CreatedAt = time.Unix(createdAt, 0)
+	if completedAt.Valid {
+		job.CompletedAt = time.Unix(completedAt.Int64, 0)
+	}
+	if expiresAt.Valid {
+		job.ExpiresAt = time.Unix(expiresAt.Int64, 0)
+	}
+	return &job, nil
+}
+
+// GetExportJob retrieves an export job by ID
+func (s *Store) GetExportJob(jobID string) (*ExportJob, error) {
+	row := s.queryRow(queryGetExportJob, `SELECT `+exportJobColumns+` FROM export_jobs WHERE job_id = ?`, jobID)
+	return scanExportJob(row.Scan)
+}
+
+// ClaimExportJob marks the oldest pending job as running and returns it, or
+// nil when no job is pending
+func (s *Store) ClaimExportJob() (*ExportJob, error) {
+	row := s.queryRow(queryClaimExportJob, `
+	UPDATE export_jobs SET status = ?
+	WHERE job_id = (
+		SELECT job_id FROM export_jobs WHERE status = ?
+		ORDER BY created_at, job_id LIMIT 1
+	)
+	RETURNING `+exportJobColumns, ExportRunning, ExportPending)
+
+	job, err := scanExportJob(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// RequeueRunningExportJobs returns jobs interrupted by a restart to the queue
+func (s *Store) RequeueRunningExportJobs() error {
+	_, err := s.exec(queryRequeueRunningExportJobs, `UPDATE export_jobs SET status = ? WHERE status = ?`, ExportPending, ExportRunning)
+	return err
+}
+
+// CompleteExportJob records a finished export
+func (s *Store) CompleteExportJob(job *ExportJob) error {
+	query := `
+	UPDATE export_jobs
+	SET status = ?, file_path = ?, size_bytes = ?, row_count = ?, completed_at = ?, expires_at = ?
+	WHERE job_id = ?
+	`
+
+	_, err := s.exec(queryCompleteExportJob, query,
+		ExportCompleted, job.FilePath, job.SizeBytes, job.RowCount,
+		job.CompletedAt.Unix(), job.ExpiresAt.Unix(), job.JobID,
+	)
+	return err
+}
+
+// FailExportJob records a failed export; it is kept until expiresAt so its
+// error can be read
+func (s *Store) FailExportJob(jobID, message string, completedAt, expiresAt time.Time) error {
+	query := `
+	UPDATE export_jobs SET status = ?, error = ?, completed_at = ?, expires_at = ?
+	WHERE job_id = ?
+	`
+
+	_, err := s.exec(queryFailExportJob, query, ExportFailed, message, completedAt.Unix(), expiresAt.Unix(), jobID)
+	return err
+}
+
+// GetExpiredExportJobs retrieves finished jobs whose retention has passed
+func (s *Store) GetExpiredExportJobs(now time.Time) ([]*ExportJob, error) {
+	rows, err := s.query(queryGetExpiredExportJobs, `SELECT `+exportJobColumns+` FROM export_jobs WHERE expires_at <= ?`, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*ExportJob
+	for rows.Next() {
+		job, err := scanExportJob(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// DeleteExportJob removes an export job
+func (s *Store) DeleteExportJob(jobID string) error {
+	_, err := s.exec(queryDeleteExportJob, `DELETE FROM export_jobs WHERE job_id = ?`, jobID)
+	return err
+}
+
+// ExportSessions calls fn for each session matching the job's filters, in
+// start time order, without loading them all into memory
+func (s *Store) ExportSessions(job *ExportJob, fn func(*Session) error) error {
+	query := `
+	SELECT session_id, organization_id, user_id, start_time, end_time,
+		total_cost_usd, total_input_tokens, total_output_tokens,
+		total_cache_read_tokens, total_cache_creation_tokens, tool_call_count,
+		COALESCE(first_model, ''), COALESCE(primary_model, '')
+	FROM sessions
+	WHERE start_time >= ? AND start_time < ?
+		AND (? = '' OR organization_id = ?)
+		AND (? = '' OR user_id = ?)
+	ORDER BY start_time
+	`
+
+	rows, err := s.query(queryExportSessions, query, job.WindowStart, job.WindowEnd,
+		job.OrganizationID, job.OrganizationID, job.UserID, job.UserID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session Session
+		var startTime int64
+		var endTime sql.NullInt64
+
+		err := rows.Scan(
+			&session.SessionID, &session.OrganizationID, &session.UserID,
+			&startTime, &endTime,
+			&session.TotalCostUSD, &session.TotalInputTokens, &session.TotalOutputTokens,
+			&session.TotalCacheReadTokens, &session.TotalCacheCreationTokens, &session.ToolCallCount,
+			&session.FirstModel, &session.PrimaryModel,
+		)
+		if err != nil {
+			return err
+		}
+
+		session.StartTime = time.Unix(startTime, 0)
+		if endTime.Valid {
+			session.EndTime = time.Unix(endTime.Int64, 0)
+		}
+		if err := fn(&session); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// SetExporter enables the /api/exports endpoints
+func (s *APIServer) SetExporter(exporter *Exporter) {
+	s.exporter = exporter
+}
+
+// handleCreateExport handles POST /api/exports?org_id=X&user_id=Y&window=30d
+func (s *APIServer) handleCreateExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.exporter == nil {
+		http.Error(w, "Exports are disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.exporter.Submit(r.URL.Query().Get("org_id"), r.URL.Query().Get("user_id"), window)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error creating export: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/exports/"+job.JobID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(buildExportJobResponse(job))
+}
+
+// handleExport handles GET /api/exports/{job_id} and GET /api/exports/{job_id}/download
+func (s *APIServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.exporter == nil {
+		http.Error(w, "Exports are disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/exports/")
+	jobID, download := strings.CutSuffix(path, "/download")
+	if jobID == "" || strings.Contains(jobID, "/") {
+		http.Error(w, "Export job ID required", http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.store.GetExportJob(jobID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving export: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !download {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(buildExportJobResponse(job))
+		return
+	}
+
+	if job.Status != ExportCompleted {
+		http.Error(w, fmt.Sprintf("Export is %s", job.Status), http.StatusConflict)
+		return
+	}
+
+	f, err := os.Open(job.FilePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error opening export: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// Large exports outlast the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Error clearing write deadline for export %s: %v", job.JobID, err)
+	}
+
+	// ServeContent answers Range and If-Range requests, so an interrupted
+	// download can resume where it stopped
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "otis-sessions-"+job.JobID+".csv"))
+	w.Header().Set("ETag", `"`+job.JobID+`"`)
+	http.ServeContent(w, r, "", job.CompletedAt, f)
+}
+
+// buildExportJobResponse builds a JSON response for an export job
+func buildExportJobResponse(job *ExportJob) map[string]interface{} {
+	response := map[string]interface{}{
+		"job_id":          job.JobID,
+		"status":          job.Status,
+		"organization_id": job.OrganizationID,
+		"user_id":         job.UserID,
+		"window":          job.WindowType,
+		"created_at":      job.CreatedAt.Format(time.RFC3339),
+		"completed_at":    jsonTime(job.CompletedAt),
+		"expires_at":      jsonTime(job.ExpiresAt),
+	}
+
+	switch job.Status {
+	case ExportCompleted:
+		response["row_count"] = job.RowCount
+		response["size_bytes"] = job.SizeBytes
+		response["download_url"] = "/api/exports/" + job.JobID + "/download"
+	case ExportFailed:
+		response["error"] = job.Error
+	}
+	return response
+}
@@ -0,0 +1,149 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getExportJSON fetches an export job's status
+func getExportJSON(t *testing.T, server *APIServer, jobID string) (int, map[string]interface{}) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	server.handleExport(rec, httptest.NewRequest(http.MethodGet, "/api/exports/"+jobID, nil))
+	var body map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&body)
+	return rec.Code, body
+}
+
+func TestExportJobLifecycle(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_export.db")
+	exporter := NewExporter(server.store, t.TempDir(), time.Hour)
+	server.SetExporter(exporter)
+
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		orgID := "org-export"
+		if i%2 == 1 {
+			orgID = "org-other"
+		}
+		if err := SeedSession(server.store, SessionSpec{
+			SessionID: fmt.Sprintf("export-session-%02d", i), OrganizationID: orgID, UserID: "user-export",
+			StartTime: start.Add(time.Duration(i) * time.Minute), Duration: time.Minute,
+			Models: []ModelSpec{{Model: "claude-sonnet-4-5", Requests: 1, CostUSD: 0.5}},
+		}); err != nil {
+			t.Fatalf("Failed to seed session: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleCreateExport(rec, httptest.NewRequest(http.MethodPost, "/api/exports?org_id=org-export", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&created)
+	jobID, _ := created["job_id"].(string)
+	if created["status"] != ExportPending || rec.Header().Get("Location") != "/api/exports/"+jobID {
+		t.Fatalf("Unexpected create response: %v", created)
+	}
+
+	// Not downloadable until the worker has run it
+	rec = httptest.NewRecorder()
+	server.handleExport(rec, httptest.NewRequest(http.MethodGet, "/api/exports/"+jobID+"/download", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a pending export, got %d", rec.Code)
+	}
+
+	if err := exporter.Start(); err != nil {
+		t.Fatalf("Failed to start exporter: %v", err)
+	}
+
+	var status map[string]interface{}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, status = getExportJSON(t, server, jobID)
+		if status["status"] == ExportCompleted || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status["status"] != ExportCompleted || status["row_count"] != 10.0 {
+		t.Fatalf("Expected a completed export of 10 sessions, got %v", status)
+	}
+
+	rec = httptest.NewRecorder()
+	server.handleExport(rec, httptest.NewRequest(http.MethodGet, "/api/exports/"+jobID+"/download", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	full := rec.Body.String()
+	lines := strings.Split(strings.TrimSpace(full), "\n")
+	if len(lines) != 11 || !strings.HasPrefix(lines[0], "session_id,") || !strings.HasPrefix(lines[1], "export-session-00,org-export,") {
+		t.Fatalf("Unexpected export contents:\n%s", full)
+	}
+
+	// Resume an interrupted download from byte 100
+	req := httptest.NewRequest(http.MethodGet, "/api/exports/"+jobID+"/download", nil)
+	req.Header.Set("Range", "bytes=100-")
+	rec = httptest.NewRecorder()
+	server.handleExport(rec, req)
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status 206, got %d", rec.Code)
+	}
+	rest, _ := io.ReadAll(rec.Body)
+	if string(rest) != full[100:] {
+		t.Errorf("Expected the ranged download to return the rest of the file")
+	}
+	if want := fmt.Sprintf("bytes 100-%d/%d", len(full)-1, len(full)); rec.Header().Get("Content-Range") != want {
+		t.Errorf("Expected Content-Range %q, got %q", want, rec.Header().Get("Content-Range"))
+	}
+
+	// Past retention the file and job are removed
+	exporter.Stop()
+	exporter.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	exporter.removeExpired()
+	if code, _ := getExportJSON(t, server, jobID); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after expiry, got %d", code)
+	}
+	if files, _ := filepath.Glob(filepath.Join(exporter.dir, "*")); len(files) != 0 {
+		t.Errorf("Expected the export file to be removed, found %v", files)
+	}
+}
+
+func TestExportJobFailure(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_export_failure.db")
+
+	// A file where the export directory should be makes every job fail
+	dir := filepath.Join(t.TempDir(), "exports")
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	exporter := NewExporter(server.store, dir, time.Hour)
+	server.SetExporter(exporter)
+
+	job, err := exporter.Submit("", "", TimeWindow{Type: "all-time"})
+	if err != nil {
+		t.Fatalf("Failed to submit export: %v", err)
+	}
+	exporter.runPending()
+
+	code, status := getExportJSON(t, server, job.JobID)
+	if code != http.StatusOK || status["status"] != ExportFailed || status["error"] == "" {
+		t.Errorf("Expected a failed job with an error, got %d %v", code, status)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleExport(rec, httptest.NewRequest(http.MethodGet, "/api/exports/"+job.JobID+"/download", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a failed export, got %d", rec.Code)
+	}
+}
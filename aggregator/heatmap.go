@@ -0,0 +1,200 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// usageHeatmap spreads cost and session starts over the hours of the week,
+// indexed by time.Weekday and hour of the day in its location
+type usageHeatmap struct {
+	location *time.Location
+	costUSD  [7][24]float64
+	sessions [7][24]int
+}
+
+func newUsageHeatmap(location *time.Location) *usageHeatmap {
+	return &usageHeatmap{location: location}
+}
+
+// cell returns the day and hour t falls in
+func (h *usageHeatmap) cell(t time.Time) (time.Weekday, int) {
+	t = t.In(h.location)
+	return t.Weekday(), t.Hour()
+}
+
+// addCost adds cost incurred at t
+func (h *usageHeatmap) addCost(t time.Time, costUSD float64) {
+	day, hour := h.cell(t)
+	h.costUSD[day][hour] += costUSD
+}
+
+// addSession counts a session started at start
+func (h *usageHeatmap) addSession(start time.Time) {
+	day, hour := h.cell(start)
+	h.sessions[day][hour]++
+}
+
+// spreadCost apportions cost over the span from start to end by how much of
+// it falls in each hour. A session without a span puts it all in the hour it
+// started.
+func (h *usageHeatmap) spreadCost(start, end time.Time, costUSD float64) {
+	if !end.After(start) {
+		h.addCost(start, costUSD)
+		return
+	}
+
+	span := end.Sub(start)
+	for from := start; from.Before(end); {
+		// Built from the wall clock so hours line up in zones with
+		// half-hour offsets
+		local := from.In(h.location)
+		year, month, day := local.Date()
+		next := time.Date(year, month, day, local.Hour(), 0, 0, 0, h.location).Add(time.Hour)
+		if !next.After(from) {
+			next = from.Add(time.Hour)
+		}
+		to := minTime(next, end)
+		h.addCost(from, costUSD*float64(to.Sub(from))/float64(span))
+		from = to
+	}
+}
+
+// toJSON reports the matrices along with their busiest cells, for scaling
+func (h *usageHeatmap) toJSON() map[string]interface{} {
+	costUSD := make([][]float64, 7)
+	sessions := make([][]int, 7)
+	var maxCost, maxSessions map[string]interface{}
+	for day := range costUSD {
+		costUSD[day] = h.costUSD[day][:]
+		sessions[day] = h.sessions[day][:]
+		for hour := 0; hour < 24; hour++ {
+			if maxCost == nil || h.costUSD[day][hour] > maxCost["cost_usd"].(float64) {
+				maxCost = map[string]interface{}{"day": weekdayNames[day], "hour": hour, "cost_usd": h.costUSD[day][hour]}
+			}
+			if maxSessions == nil || h.sessions[day][hour] > maxSessions["sessions"].(int) {
+				maxSessions = map[string]interface{}{"day": weekdayNames[day], "hour": hour, "sessions": h.sessions[day][hour]}
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"timezone":     h.location.String(),
+		"days":         weekdayNames[:],
+		"cost_usd":     costUSD,
+		"sessions":     sessions,
+		"max_cost":     maxCost,
+		"max_sessions": maxSessions,
+	}
+}
+
+// GetOrgSessionSpans retrieves the span and cost of each of an
+// organization's sessions started in the window. Open sessions end where
+// they start.
+func (s *Store) GetOrgSessionSpans(orgID string, window TimeWindow) ([]*SessionSpan, error) {
+	query := `
+	SELECT session_id, start_time, COALESCE(end_time, start_time), total_cost_usd
+	FROM sessions
+	WHERE organization_id = ? AND start_time >= ? AND start_time < ?
+	ORDER BY start_time ASC, session_id ASC
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryGetOrgSessionSpans, query, orgID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var spans []*SessionSpan
+	for rows.Next() {
+		var span SessionSpan
+		var startTime, endTime int64
+		if err := rows.Scan(&span.SessionID, &startTime, &endTime, &span.CostUSD); err != nil {
+			return nil, err
+		}
+		span.StartTime = time.Unix(startTime, 0)
+		span.EndTime = time.Unix(endTime, 0)
+		spans = append(spans, &span)
+	}
+
+	return spans, rows.Err()
+}
+
+// GetOrgRollupCosts retrieves the cost in each rollup interval of an
+// organization's sessions started in the window. Only SessionID, BucketStart
+// and CostUSD are set.
+func (s *Store) GetOrgRollupCosts(orgID string, window TimeWindow) ([]*SessionRollup, error) {
+	query := `
+	SELECT r.session_id, r.bucket_start, r.cost_usd
+	FROM session_rollups r
+	JOIN sessions s ON s.session_id = r.session_id
+	WHERE s.organization_id = ? AND s.start_time >= ? AND s.start_time < ?
+	ORDER BY r.session_id ASC, r.bucket_start ASC
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryGetOrgRollupCosts, query, orgID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollups []*SessionRollup
+	for rows.Next() {
+		var r SessionRollup
+		var bucketStart int64
+		if err := rows.Scan(&r.SessionID, &bucketStart, &r.CostUSD); err != nil {
+			return nil, err
+		}
+		r.BucketStart = time.Unix(bucketStart, 0)
+		rollups = append(rollups, &r)
+	}
+
+	return rollups, rows.Err()
+}
+
+// handleOrgHeatmap handles GET /api/stats/org/{org_id}/heatmap, spreading
+// the org's cost and session starts over the hours of the week
+func (s *APIServer) handleOrgHeatmap(w http.ResponseWriter, r *http.Request, orgID string) {
+	window, err := parseTimeWindow(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	spans, err := s.store.GetOrgSessionSpans(orgID, window)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving heatmap: %v", err), http.StatusInternalServerError)
+		return
+	}
+	rollups, err := s.store.GetOrgRollupCosts(orgID, window)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving heatmap: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Cost goes where the rollups put it; sessions recorded before rollups
+	// existed have their cost spread evenly over their span
+	heatmap := newUsageHeatmap(s.engine.BusinessHours().Location)
+	rolledUp := make(map[string]bool)
+	for _, r := range rollups {
+		heatmap.addCost(r.BucketStart, r.CostUSD)
+		rolledUp[r.SessionID] = true
+	}
+	for _, span := range spans {
+		heatmap.addSession(span.StartTime)
+		if !rolledUp[span.SessionID] {
+			heatmap.spreadCost(span.StartTime, span.EndTime, span.CostUSD)
+		}
+	}
+
+	response := heatmap.toJSON()
+	response["organization_id"] = orgID
+	response["window"] = window.Type
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
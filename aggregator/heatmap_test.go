@@ -0,0 +1,151 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// heatmapCell is a day and hour of a heatmap, days indexed by time.Weekday
+type heatmapCell struct {
+	day  time.Weekday
+	hour int
+}
+
+func TestOrgHeatmapEndpoint(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_heatmap.db")
+	engine := server.engine
+
+	// Seeded sessions have no rollups, so their cost is spread over their
+	// span: 09:30 to 10:30 on Monday 2 June is half in each hour
+	for _, spec := range []SessionSpec{
+		{SessionID: "spread", OrganizationID: "org-heat", StartTime: time.Date(2025, 6, 2, 9, 30, 0, 0, time.UTC), Duration: time.Hour, TotalCostUSD: 2},
+		{SessionID: "early", OrganizationID: "org-heat", StartTime: time.Date(2025, 6, 2, 2, 0, 0, 0, time.UTC), TotalCostUSD: 0.5},
+		{SessionID: "elsewhere", OrganizationID: "org-other", StartTime: time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC), TotalCostUSD: 100},
+	} {
+		if err := SeedSession(server.store, spec); err != nil {
+			t.Fatalf("Failed to seed %s: %v", spec.SessionID, err)
+		}
+	}
+
+	// Recorded sessions' cost lands in the minutes it was reported in
+	for _, r := range []struct {
+		timestamp time.Time
+		cost      float64
+	}{
+		{time.Date(2025, 6, 3, 14, 10, 0, 0, time.UTC), 3},
+		{time.Date(2025, 6, 3, 16, 20, 0, 0, time.UTC), 1},
+	} {
+		engine.ProcessMetric(&MetricRecord{
+			Timestamp: r.timestamp, SessionID: "rolled", UserID: "user-1", OrganizationID: "org-heat",
+			MetricName: "claude_code.cost.usage", MetricValue: r.cost,
+		})
+	}
+	engine.FlushCache()
+
+	tests := []struct {
+		timezone    string
+		costs       map[heatmapCell]float64
+		sessions    map[heatmapCell]int
+		maxCost     heatmapCell
+		maxSessions heatmapCell
+	}{
+		{
+			timezone: "UTC",
+			costs: map[heatmapCell]float64{
+				{time.Monday, 2}: 0.5, {time.Monday, 9}: 1, {time.Monday, 10}: 1,
+				{time.Tuesday, 14}: 3, {time.Tuesday, 16}: 1,
+			},
+			sessions:    map[heatmapCell]int{{time.Monday, 2}: 1, {time.Monday, 9}: 1, {time.Tuesday, 14}: 1},
+			maxCost:     heatmapCell{time.Tuesday, 14},
+			maxSessions: heatmapCell{time.Monday, 2},
+		},
+		{
+			// Four hours behind UTC in June, moving the early session back
+			// to Sunday night
+			timezone: "America/New_York",
+			costs: map[heatmapCell]float64{
+				{time.Sunday, 22}: 0.5, {time.Monday, 5}: 1, {time.Monday, 6}: 1,
+				{time.Tuesday, 10}: 3, {time.Tuesday, 12}: 1,
+			},
+			sessions:    map[heatmapCell]int{{time.Sunday, 22}: 1, {time.Monday, 5}: 1, {time.Tuesday, 10}: 1},
+			maxCost:     heatmapCell{time.Tuesday, 10},
+			maxSessions: heatmapCell{time.Sunday, 22},
+		},
+	}
+	for _, tt := range tests {
+		hours, err := ParseBusinessHours(tt.timezone, "mon-fri", "09:00-17:00")
+		if err != nil {
+			t.Fatalf("Failed to parse business hours: %v", err)
+		}
+		engine.SetBusinessHours(hours)
+
+		rec := httptest.NewRecorder()
+		server.handleOrgStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/org/org-heat/heatmap", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		type maxCell struct {
+			Day      string  `json:"day"`
+			Hour     int     `json:"hour"`
+			CostUSD  float64 `json:"cost_usd"`
+			Sessions int     `json:"sessions"`
+		}
+		var body struct {
+			Timezone    string      `json:"timezone"`
+			Days        []string    `json:"days"`
+			CostUSD     [][]float64 `json:"cost_usd"`
+			Sessions    [][]int     `json:"sessions"`
+			MaxCost     maxCell     `json:"max_cost"`
+			MaxSessions maxCell     `json:"max_sessions"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		if body.Timezone != tt.timezone || len(body.Days) != 7 || body.Days[0] != "sun" {
+			t.Errorf("%s: expected the week from Sunday in %s, got %s %v", tt.timezone, tt.timezone, body.Timezone, body.Days)
+		}
+		if len(body.CostUSD) != 7 || len(body.Sessions) != 7 {
+			t.Fatalf("%s: expected 7 days, got %d and %d", tt.timezone, len(body.CostUSD), len(body.Sessions))
+		}
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			for hour := 0; hour < 24; hour++ {
+				cell := heatmapCell{day, hour}
+				if got := body.CostUSD[day][hour]; math.Abs(got-tt.costs[cell]) > 1e-9 {
+					t.Errorf("%s: expected $%v on %s at %02d:00, got $%v", tt.timezone, tt.costs[cell], day, hour, got)
+				}
+				if got := body.Sessions[day][hour]; got != tt.sessions[cell] {
+					t.Errorf("%s: expected %d sessions on %s at %02d:00, got %d", tt.timezone, tt.sessions[cell], day, hour, got)
+				}
+			}
+		}
+
+		if body.MaxCost.Day != weekdayNames[tt.maxCost.day] || body.MaxCost.Hour != tt.maxCost.hour || body.MaxCost.CostUSD != 3 {
+			t.Errorf("%s: expected the most cost on %v, got %+v", tt.timezone, tt.maxCost, body.MaxCost)
+		}
+		if body.MaxSessions.Day != weekdayNames[tt.maxSessions.day] || body.MaxSessions.Hour != tt.maxSessions.hour || body.MaxSessions.Sessions != 1 {
+			t.Errorf("%s: expected the first busiest hour on %v, got %+v", tt.timezone, tt.maxSessions, body.MaxSessions)
+		}
+	}
+}
+
+func TestUsageHeatmapSpreadsCostOverLocalHours(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+
+	// 09:00 to 10:00 UTC is 14:30 to 15:30 in Kolkata, half in each hour
+	heatmap := newUsageHeatmap(kolkata)
+	start := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	heatmap.spreadCost(start, start.Add(time.Hour), 4)
+
+	if heatmap.costUSD[time.Monday][14] != 2 || heatmap.costUSD[time.Monday][15] != 2 {
+		t.Errorf("Expected $2 at 14:00 and 15:00, got $%v and $%v", heatmap.costUSD[time.Monday][14], heatmap.costUSD[time.Monday][15])
+	}
+}
@@ -0,0 +1,69 @@
+package aggregator
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zmack/otis/anonymize"
+)
+
+// DefaultMaxIDLength is the longest session, user or organization ID accepted
+// by default. Claude Code's IDs are UUIDs, well under it.
+const DefaultMaxIDLength = 256
+
+// SetMaxIDLength sets the longest session, user or organization ID the engine
+// accepts; records with a longer one are rejected. Zero or less restores
+// DefaultMaxIDLength. Safe to call while records are being processed.
+func (e *Engine) SetMaxIDLength(n int) {
+	if n <= 0 {
+		n = DefaultMaxIDLength
+	}
+	e.maxIDLength.Store(int64(n))
+}
+
+// RejectedRecords is how many records were dropped for an invalid identifier
+func (e *Engine) RejectedRecords() int64 {
+	return e.rejectedRecords.Load()
+}
+
+// acceptIdentity trims a record's identifiers in place and reports whether
+// they are all valid, counting the record as rejected when one is not. The
+// identifiers are map keys and SQL parameters, so an oversized one would bloat
+// the caches and the database.
+func (e *Engine) acceptIdentity(ids ...*string) bool {
+	maxLength := int(e.maxIDLength.Load())
+	for _, id := range ids {
+		*id = strings.TrimSpace(*id)
+		if !validID(*id, maxLength) {
+			e.rejectedRecords.Add(1)
+			return false
+		}
+	}
+	return true
+}
+
+// SetAnonymizer replaces the user and organization IDs of every record with
+// their hashes before they are aggregated, so files written before the
+// collector anonymized them never put raw IDs in the database. IDs the
+// collector already hashed are left alone. Call before processing starts.
+func (e *Engine) SetAnonymizer(anonymizer *anonymize.Hasher) {
+	e.anonymizer = anonymizer
+}
+
+// anonymizeIdentity replaces ids in place with their hashes when the engine
+// anonymizes them
+func (e *Engine) anonymizeIdentity(ids ...*string) {
+	for _, id := range ids {
+		*id = e.anonymizer.ID(*id)
+	}
+}
+
+// validID reports whether id is valid UTF-8 of at most maxLength bytes
+// without control characters. An empty ID is valid; it means unset.
+func validID(id string, maxLength int) bool {
+	if len(id) > maxLength || !utf8.ValidString(id) {
+		return false
+	}
+	return strings.IndexFunc(id, unicode.IsControl) < 0
+}
@@ -0,0 +1,150 @@
+package aggregator
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zmack/otis/anonymize"
+)
+
+func TestEngineRejectsInvalidIdentifiers(t *testing.T) {
+	server := newTestAPIServer(t, "./test_identifiers.db")
+	engine := server.engine
+	engine.SetMaxIDLength(64)
+
+	costRecord := func(sessionID, userID string) *MetricRecord {
+		return &MetricRecord{
+			Timestamp:      time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC),
+			SessionID:      sessionID,
+			UserID:         userID,
+			OrganizationID: "org-1",
+			MetricName:     "claude_code.cost.usage",
+			MetricValue:    0.5,
+			Attributes:     map[string]string{"model": "claude-sonnet-4-5"},
+		}
+	}
+
+	oversized := strings.Repeat("s", 1<<20)
+	engine.ProcessMetric(costRecord(oversized, "user-1"))
+	engine.ProcessLog(&LogRecord{SessionID: "session-ok", UserID: strings.Repeat("u", 65), Body: "claude_code.api_request"})
+	engine.ProcessTrace(&TraceRecord{SessionID: "session\x00null", SpanName: "claude_code.request"})
+	engine.ProcessMetric(costRecord("session-\x1b[31m", "user-1"))
+	engine.ProcessMetric(costRecord("session-\xff", "user-1"))
+
+	// Surrounding whitespace is trimmed, not rejected, and the limit is inclusive
+	engine.ProcessMetric(costRecord("  session-ok\n", "user-1"))
+	engine.ProcessMetric(costRecord(strings.Repeat("s", 64), "user-1"))
+
+	if got := engine.RejectedRecords(); got != 5 {
+		t.Errorf("Expected 5 rejected records, got %d", got)
+	}
+
+	engine.cacheMutex.RLock()
+	sessions := make([]string, 0, len(engine.sessionsCache))
+	for sessionID := range engine.sessionsCache {
+		sessions = append(sessions, fmt.Sprintf("%.20s", sessionID))
+	}
+	_, trimmed := engine.sessionsCache["session-ok"]
+	engine.cacheMutex.RUnlock()
+	if len(sessions) != 2 || !trimmed {
+		t.Fatalf("Expected only the trimmed and the 64 byte sessions cached, got %q", sessions)
+	}
+
+	engine.FlushCache()
+	var stored int
+	if err := server.store.db.QueryRow(`SELECT COUNT(*) FROM sessions`).Scan(&stored); err != nil || stored != 2 {
+		t.Errorf("Expected only the 2 valid sessions stored, got %d (%v)", stored, err)
+	}
+	if session, err := server.store.GetSession("session-ok"); err != nil || session == nil || session.UserID != "user-1" {
+		t.Errorf("Expected the trimmed session stored, got %v (%v)", session, err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "otis_rejected_records_total 5\n") {
+		t.Errorf("Expected the reject count in /metrics, got:\n%s", rec.Body.String())
+	}
+}
+
+func TestEngineCountsRejectsConcurrently(t *testing.T) {
+	engine := newEngine(nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 0 {
+				engine.SetMaxIDLength(DefaultMaxIDLength)
+			}
+			for j := 0; j < 100; j++ {
+				engine.ProcessLog(&LogRecord{SessionID: strings.Repeat("x", DefaultMaxIDLength+1), Body: "claude_code.api_request"})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := engine.RejectedRecords(); got != 800 {
+		t.Errorf("Expected 800 rejected records, got %d", got)
+	}
+}
+
+func TestEngineAnonymizesIDsFromExistingFiles(t *testing.T) {
+	dbPath := "./test_anonymize.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	hasher := anonymize.New("secret")
+	engine := NewEngine(store)
+	engine.SetAnonymizer(hasher)
+
+	// Files written before anonymization was enabled hold raw IDs; newer lines
+	// hold the collector's hashes, which must land on the same user
+	dataDir := t.TempDir()
+	line := func(sessionID, userID, orgID string) string {
+		return fmt.Sprintf(`{"resourceMetrics":[{"resource":{"attributes":[{"key":"user.id","value":{"stringValue":"%s"}},{"key":"organization.id","value":{"stringValue":"%s"}}]},`+
+			`"scopeMetrics":[{"metrics":[{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"timeUnixNano":"1000000000","asDouble":0.5,`+
+			`"attributes":[{"key":"session.id","value":{"stringValue":"%s"}}]}]}}]}]}]}`, userID, orgID, sessionID)
+	}
+	writeLines(t, filepath.Join(dataDir, "metrics.jsonl"),
+		line("session-raw", "alice@example.com", "acme-org"),
+		line("session-hashed", hasher.ID("alice@example.com"), hasher.ID("acme-org")))
+
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+	if err := processor.ProcessFile(filepath.Join(dataDir, "metrics.jsonl")); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	engine.FlushCache()
+
+	userSessions, err := store.GetSessionsByUser(hasher.ID("alice@example.com"), nil, 10)
+	if err != nil || len(userSessions) != 2 {
+		t.Fatalf("Expected both sessions under the hashed user, got %d (%v)", len(userSessions), err)
+	}
+	for _, session := range userSessions {
+		if session.OrganizationID != hasher.ID("acme-org") {
+			t.Errorf("Expected the hashed organization on %s, got %s", session.SessionID, session.OrganizationID)
+		}
+	}
+	store.Close()
+
+	for _, path := range []string{dbPath, dbPath + "-wal"} {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if bytes.Contains(data, []byte("alice")) || bytes.Contains(data, []byte("acme-org")) {
+			t.Errorf("Expected no raw IDs in %s", path)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package aggregator
+
+import (
+	"math"
+	"sort"
+)
+
+// latencyAccuracy is the relative error of the percentiles a latencySketch
+// reports: within 1% of the true value
+const latencyAccuracy = 0.01
+
+// latencyGamma is the ratio between a latencySketch's consecutive bucket
+// bounds, chosen so a bucket's midpoint is within latencyAccuracy of any
+// value in it
+var latencyGamma = (1 + latencyAccuracy) / (1 - latencyAccuracy)
+
+// latencySketch estimates percentiles of a stream of latencies in constant
+// memory per order of magnitude, by counting them in logarithmically sized
+// buckets. Each reported percentile is within latencyAccuracy of the true
+// value however skewed the latencies are.
+type latencySketch struct {
+	buckets map[int]int // Bucket index -> count; bucket i holds (gamma^(i-1), gamma^i]
+	zeros   int         // Latencies of zero or less
+	count   int
+}
+
+func newLatencySketch() *latencySketch {
+	return &latencySketch{buckets: make(map[int]int)}
+}
+
+// add records a latency
+func (l *latencySketch) add(ms float64) {
+	l.count++
+	if ms <= 0 {
+		l.zeros++
+		return
+	}
+	l.buckets[int(math.Ceil(math.Log(ms)/math.Log(latencyGamma)))]++
+}
+
+// quantile estimates the latency below which a fraction q of those recorded
+// fall, or 0 when none were
+func (l *latencySketch) quantile(q float64) float64 {
+	if l.count == 0 {
+		return 0
+	}
+	rank := int(q * float64(l.count-1))
+	if rank < l.zeros {
+		return 0
+	}
+
+	indexes := make([]int, 0, len(l.buckets))
+	for i := range l.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	seen := l.zeros
+	for _, i := range indexes {
+		seen += l.buckets[i]
+		if seen > rank {
+			return 2 * math.Pow(latencyGamma, float64(i)) / (latencyGamma + 1)
+		}
+	}
+	return 2 * math.Pow(latencyGamma, float64(indexes[len(indexes)-1])) / (latencyGamma + 1)
+}
+
+// percentiles returns the sketch's p50, p95 and p99
+func (l *latencySketch) percentiles() (p50, p95, p99 float64) {
+	return l.quantile(0.50), l.quantile(0.95), l.quantile(0.99)
+}
+
+// latencySketchFor gets or creates the sketch for name within a session in cache
+func latencySketchFor(cache map[string]map[string]*latencySketch, sessionID, name string) *latencySketch {
+	if cache[sessionID] == nil {
+		cache[sessionID] = make(map[string]*latencySketch)
+	}
+	sketch, exists := cache[sessionID][name]
+	if !exists {
+		sketch = newLatencySketch()
+		cache[sessionID][name] = sketch
+	}
+	return sketch
+}
+
+// recordModelLatency adds an API request's latency to its model's sketch
+func (e *Engine) recordModelLatency(sessionID, model string, ms float64) {
+	latencySketchFor(e.modelLatencyCache, sessionID, model).add(ms)
+}
+
+// recordToolDuration adds a tool call's duration to its tool's sketch
+func (e *Engine) recordToolDuration(sessionID, toolName string, ms float64) {
+	latencySketchFor(e.toolLatencyCache, sessionID, normalizeToolName(toolName)).add(ms)
+}
+
+// applyLatencyPercentiles copies each sketch's percentiles onto the cached
+// session models and tools before they are flushed. Callers hold cacheMutex.
+func (e *Engine) applyLatencyPercentiles() {
+	for sessionID, sketches := range e.modelLatencyCache {
+		for model, sketch := range sketches {
+			if sm := e.sessionModelsCache[sessionID][model]; sm != nil {
+				sm.P50LatencyMS, sm.P95LatencyMS, sm.P99LatencyMS = sketch.percentiles()
+			}
+		}
+	}
+	for sessionID, sketches := range e.toolLatencyCache {
+		for toolName, sketch := range sketches {
+			if st := e.sessionToolsCache[sessionID][toolName]; st != nil {
+				st.P50ExecutionTimeMS, st.P95ExecutionTimeMS, st.P99ExecutionTimeMS = sketch.percentiles()
+			}
+		}
+	}
+}
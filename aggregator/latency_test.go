@@ -0,0 +1,125 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestLatencySketchAccuracy(t *testing.T) {
+	// A long tail: mostly fast requests with a few orders of magnitude slower
+	sketch := newLatencySketch()
+	var values []float64
+	for i := 1; i <= 10000; i++ {
+		ms := float64(i%200) + 50
+		if i%50 == 0 {
+			ms = float64(i) * 3
+		}
+		values = append(values, ms)
+		sketch.add(ms)
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		want := values[int(q*float64(len(values)-1))]
+		got := sketch.quantile(q)
+		if math.Abs(got-want)/want > latencyAccuracy {
+			t.Errorf("p%g: expected within 1%% of %f, got %f", q*100, want, got)
+		}
+	}
+}
+
+func TestLatencySketchEdgeCases(t *testing.T) {
+	sketch := newLatencySketch()
+	if p50, p95, p99 := sketch.percentiles(); p50 != 0 || p95 != 0 || p99 != 0 {
+		t.Errorf("Expected zeros from an empty sketch, got %f %f %f", p50, p95, p99)
+	}
+
+	sketch.add(0)
+	sketch.add(0)
+	sketch.add(1000)
+	if got := sketch.quantile(0.5); got != 0 {
+		t.Errorf("Expected a median of 0, got %f", got)
+	}
+	if got := sketch.quantile(1); math.Abs(got-1000)/1000 > latencyAccuracy {
+		t.Errorf("Expected a maximum near 1000, got %f", got)
+	}
+}
+
+func TestEngineFlushesLatencyPercentiles(t *testing.T) {
+	server := newTestAPIServer(t, "./test_latency_percentiles.db")
+	engine := newEngine(server.store)
+
+	timestamp := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i := 1; i <= 100; i++ {
+		engine.ProcessLog(&LogRecord{
+			Timestamp: timestamp,
+			SessionID: "latency-session",
+			UserID:    "user-1",
+			Body:      "claude_code.api_request",
+			Attributes: map[string]interface{}{
+				"model":       "claude-sonnet-4",
+				"duration_ms": float64(i * 10),
+			},
+		})
+		engine.ProcessLog(&LogRecord{
+			Timestamp: timestamp,
+			SessionID: "latency-session",
+			UserID:    "user-1",
+			Body:      "claude_code.tool_result",
+			Attributes: map[string]interface{}{
+				"tool_name":   "Bash",
+				"success":     "true",
+				"duration_ms": float64(i),
+			},
+		})
+	}
+	engine.FlushCache()
+
+	near := func(got, want float64) bool {
+		return math.Abs(got-want)/want <= latencyAccuracy
+	}
+
+	models, err := server.store.GetSessionModels("latency-session")
+	if err != nil || len(models) != 1 {
+		t.Fatalf("Expected 1 model, got %v (%v)", models, err)
+	}
+	if m := models[0]; !near(m.P50LatencyMS, 500) || !near(m.P95LatencyMS, 950) || !near(m.P99LatencyMS, 990) {
+		t.Errorf("Unexpected model percentiles: p50=%f p95=%f p99=%f", m.P50LatencyMS, m.P95LatencyMS, m.P99LatencyMS)
+	}
+
+	tools, err := server.store.GetSessionTools("latency-session")
+	if err != nil || len(tools) != 1 {
+		t.Fatalf("Expected 1 tool, got %v (%v)", tools, err)
+	}
+	if st := tools[0]; !near(st.P50ExecutionTimeMS, 50) || !near(st.P99ExecutionTimeMS, 99) {
+		t.Errorf("Unexpected tool percentiles: p50=%f p99=%f", st.P50ExecutionTimeMS, st.P99ExecutionTimeMS)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/session/latency-session/tools", nil)
+	w := httptest.NewRecorder()
+	server.handleSessionTools(w, req, "latency-session")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Tools []struct {
+			ToolName string `json:"tool_name"`
+			Duration struct {
+				P50MS float64 `json:"p50_ms"`
+				P95MS float64 `json:"p95_ms"`
+				P99MS float64 `json:"p99_ms"`
+			} `json:"duration"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Tools) != 1 || !near(response.Tools[0].Duration.P95MS, 95) {
+		t.Errorf("Expected Bash's p95 near 95ms, got %+v", response.Tools)
+	}
+}
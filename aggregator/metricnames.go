@@ -0,0 +1,151 @@
+package aggregator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxMetricLabelValues caps how many organizations, users or tools get their
+// own series in a family; the rest are summed under otherLabelValue, so a
+// large deployment can't blow up a Prometheus server's series count
+const maxMetricLabelValues = 100
+
+// otherLabelValue labels the series summing everything over the cap
+const otherLabelValue = "other"
+
+// metricDesc names and documents one family of series the aggregator
+// exports. Names follow the Prometheus conventions: an otis_ prefix, the
+// unit spelled out, and _total on counters. Every family served lives in
+// metricRegistry, so exporters share the same names, types and help.
+type metricDesc struct {
+	Name   string
+	Type   string // "counter" or "gauge"
+	Help   string
+	Labels []string // Every label its series may carry
+}
+
+var (
+	metricOrgTokensPerSecond = &metricDesc{
+		Name:   "otis_org_tokens_per_second",
+		Type:   "gauge",
+		Help:   "Tokens per second across active sessions over the last minute.",
+		Labels: []string{"organization_id"},
+	}
+	metricOrgRequestsPerMinute = &metricDesc{
+		Name:   "otis_org_requests_per_minute",
+		Type:   "gauge",
+		Help:   "API requests per minute across active sessions over the last minute.",
+		Labels: []string{"organization_id"},
+	}
+	metricRejectedRecords = &metricDesc{
+		Name: "otis_rejected_records_total",
+		Type: "counter",
+		Help: "Records dropped for an oversized or malformed session, user or organization ID.",
+	}
+	metricDroppedPrompts = &metricDesc{
+		Name: "otis_dropped_prompts_total",
+		Type: "counter",
+		Help: "Prompts not stored because the prompt queue was full or their insert failed.",
+	}
+	metricSessionCost = &metricDesc{
+		Name:   "otis_session_cost_usd_total",
+		Type:   "counter",
+		Help:   "Cost in USD of the stored sessions, as of their last flush.",
+		Labels: []string{"organization_id", "user_id"},
+	}
+	metricTokens = &metricDesc{
+		Name:   "otis_tokens_total",
+		Type:   "counter",
+		Help:   "Tokens used by the stored sessions, by type: input, output, cache_read or cache_creation.",
+		Labels: []string{"organization_id", "user_id", "type"},
+	}
+	metricToolCalls = &metricDesc{
+		Name:   "otis_tool_calls_total",
+		Type:   "counter",
+		Help:   "Tool calls in the stored sessions, by tool and result: success or failure.",
+		Labels: []string{"tool", "result"},
+	}
+)
+
+// metricRegistry lists every family the aggregator exports, in the order
+// they are served
+var metricRegistry = []*metricDesc{
+	metricOrgTokensPerSecond,
+	metricOrgRequestsPerMinute,
+	metricRejectedRecords,
+	metricDroppedPrompts,
+	metricSessionCost,
+	metricTokens,
+	metricToolCalls,
+}
+
+// writeHeader writes the family's HELP and TYPE lines
+func (m *metricDesc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.Name, m.Help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.Name, m.Type)
+}
+
+// writeSample writes one series, labelled by the label name and value pairs
+// in labels
+func (m *metricDesc) writeSample(w io.Writer, value float64, labels ...string) {
+	if len(labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", m.Name, formatMetricValue(value))
+		return
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", m.Name, strings.Join(pairs, ","), formatMetricValue(value))
+}
+
+// formatMetricValue writes value in full, so large counters aren't printed
+// in exponent form
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// capUsageTotals keeps the max organizations, or users, with the highest
+// cost and sums the rest into one row labelled otherLabelValue
+func capUsageTotals(totals []*UsageTotals, max int) []*UsageTotals {
+	if len(totals) <= max {
+		return totals
+	}
+	sorted := append([]*UsageTotals(nil), totals...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CostUSD > sorted[j].CostUSD })
+
+	other := &UsageTotals{OrganizationID: otherLabelValue}
+	if sorted[0].UserID != "" {
+		other.UserID = otherLabelValue
+	}
+	for _, t := range sorted[max:] {
+		other.CostUSD += t.CostUSD
+		other.InputTokens += t.InputTokens
+		other.OutputTokens += t.OutputTokens
+		other.CacheReadTokens += t.CacheReadTokens
+		other.CacheCreationTokens += t.CacheCreationTokens
+	}
+	return append(sorted[:max], other)
+}
+
+// capToolTotals keeps the max most called tools and sums the rest into one
+// row named otherLabelValue
+func capToolTotals(totals []*ToolCallTotals, max int) []*ToolCallTotals {
+	if len(totals) <= max {
+		return totals
+	}
+	sorted := append([]*ToolCallTotals(nil), totals...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Successes+sorted[i].Failures > sorted[j].Successes+sorted[j].Failures
+	})
+
+	other := &ToolCallTotals{ToolName: otherLabelValue}
+	for _, t := range sorted[max:] {
+		other.Successes += t.Successes
+		other.Failures += t.Failures
+	}
+	return append(sorted[:max], other)
+}
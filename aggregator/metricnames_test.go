@@ -0,0 +1,135 @@
+package aggregator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricRegistry(t *testing.T) {
+	names := make(map[string]bool)
+	for _, desc := range metricRegistry {
+		if names[desc.Name] {
+			t.Errorf("%s is registered twice", desc.Name)
+		}
+		names[desc.Name] = true
+		if !strings.HasPrefix(desc.Name, "otis_") || desc.Help == "" {
+			t.Errorf("%s needs the otis_ prefix and help text", desc.Name)
+		}
+		switch desc.Type {
+		case "counter":
+			if !strings.HasSuffix(desc.Name, "_total") {
+				t.Errorf("Counter %s should end in _total", desc.Name)
+			}
+		case "gauge":
+		default:
+			t.Errorf("%s has unknown type %q", desc.Name, desc.Type)
+		}
+	}
+}
+
+// sampleLine matches a series line: its name, label set and value
+var sampleLine = regexp.MustCompile(`^(\w+)(?:\{(.*)\})? \S+$`)
+
+func TestMetricsServesOnlyRegisteredFamilies(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_metric_names.db")
+	start := time.Now().Add(-time.Hour)
+
+	for _, spec := range []SessionSpec{
+		{SessionID: "s1", UserID: "u1", OrganizationID: "o1", StartTime: start, Duration: time.Minute,
+			Models: []ModelSpec{{Model: "m", Requests: 1, CostUSD: 1.5, InputTokens: 1250000, OutputTokens: 20}},
+			Tools:  []ToolSpec{{Name: "Bash", Successes: 3, Failures: 1}}},
+		{SessionID: "s2", UserID: "u2", OrganizationID: "o1", StartTime: start, Duration: time.Minute,
+			Models: []ModelSpec{{Model: "m", Requests: 1, CostUSD: 0.5, InputTokens: 10, CacheReadTokens: 7}}},
+	} {
+		if err := SeedSession(server.store, spec); err != nil {
+			t.Fatalf("Failed to seed %s: %v", spec.SessionID, err)
+		}
+	}
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		server.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	registered := make(map[string]*metricDesc)
+	for _, desc := range metricRegistry {
+		registered[desc.Name] = desc
+	}
+	body := scrape()
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		match := sampleLine.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("Unparseable line %q", line)
+			continue
+		}
+		desc := registered[match[1]]
+		if desc == nil {
+			t.Errorf("%s is served but not registered", match[1])
+			continue
+		}
+		if !strings.Contains(body, "# HELP "+desc.Name+" ") {
+			t.Errorf("%s is served without its HELP line", desc.Name)
+		}
+		for _, pair := range strings.Split(match[2], ",") {
+			if name, _, ok := strings.Cut(pair, "="); ok && !slices.Contains(desc.Labels, name) {
+				t.Errorf("%s is served with unregistered label %s", desc.Name, name)
+			}
+		}
+	}
+
+	for _, want := range []string{
+		`otis_session_cost_usd_total{organization_id="o1"} 2` + "\n",
+		`otis_tokens_total{organization_id="o1",type="input"} 1250010` + "\n",
+		`otis_tokens_total{organization_id="o1",type="cache_read"} 7` + "\n",
+		`otis_tool_calls_total{tool="Bash",result="failure"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in /metrics, got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "user_id") {
+		t.Errorf("Expected no user_id label by default, got:\n%s", body)
+	}
+
+	server.SetMetricsUserLabel(true)
+	if body := scrape(); !strings.Contains(body, `otis_session_cost_usd_total{organization_id="o1",user_id="u2"} 0.5`+"\n") {
+		t.Errorf("Expected per-user cost with the user label enabled, got:\n%s", body)
+	}
+}
+
+func TestCapUsageTotalsFoldsIntoOther(t *testing.T) {
+	totals := []*UsageTotals{
+		{OrganizationID: "o1", CostUSD: 1, InputTokens: 10},
+		{OrganizationID: "o2", CostUSD: 3, InputTokens: 30},
+		{OrganizationID: "o3", CostUSD: 2, InputTokens: 20},
+	}
+	capped := capUsageTotals(totals, 1)
+	if len(capped) != 2 || capped[0].OrganizationID != "o2" {
+		t.Fatalf("Expected the costliest org then other, got %+v", capped)
+	}
+	other := capped[1]
+	if other.OrganizationID != otherLabelValue || other.UserID != "" || other.CostUSD != 3 || other.InputTokens != 30 {
+		t.Errorf("Expected o1 and o3 summed under other, got %+v", other)
+	}
+
+	tools := capToolTotals([]*ToolCallTotals{
+		{ToolName: "Read", Successes: 1},
+		{ToolName: "Bash", Successes: 5, Failures: 1},
+		{ToolName: "Edit", Failures: 2},
+	}, 2)
+	if len(tools) != 3 || tools[0].ToolName != "Bash" || tools[2].ToolName != otherLabelValue || tools[2].Successes != 1 {
+		t.Errorf("Expected Bash and Edit kept and Read under other, got %+v", tools)
+	}
+}
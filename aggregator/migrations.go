@@ -0,0 +1,125 @@
+package aggregator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+// MigrationState describes whether a single migration has been applied
+type MigrationState struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time // Zero when pending
+}
+
+// migrationsFS returns the embedded migrations rooted at the migrations directory
+func migrationsFS() fs.FS {
+	fsys, err := fs.Sub(embedMigrations, "migrations")
+	if err != nil {
+		panic(err) // The directory is embedded at build time
+	}
+	return fsys
+}
+
+// migrationProvider prepares a goose provider over fsys. Each SQL migration
+// runs in its own transaction, so a failing migration is rolled back rather
+// than left partially applied.
+func (s *Store) migrationProvider(fsys fs.FS) (*goose.Provider, error) {
+	// Handle legacy databases that exist but weren't created with goose
+	// by applying necessary schema fixes before running migrations
+	if err := s.applyLegacyFixes(); err != nil {
+		return nil, fmt.Errorf("failed to apply legacy fixes: %w", err)
+	}
+
+	provider, err := goose.NewProvider(goose.DialectSQLite3, s.db, fsys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	return provider, nil
+}
+
+// runMigrations applies all pending migrations from fsys
+func (s *Store) runMigrations(fsys fs.FS) error {
+	provider, err := s.migrationProvider(fsys)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	results, err := provider.Up(ctx)
+	if err != nil {
+		var partial *goose.PartialError
+		if !errors.As(err, &partial) {
+			return err
+		}
+
+		version, verr := provider.GetDBVersion(ctx)
+		if verr != nil {
+			return fmt.Errorf("migration %s failed: %w", filepath.Base(partial.Failed.Source.Path), partial.Err)
+		}
+		return fmt.Errorf("migration %s failed and was rolled back, database is at version %d "+
+			"(inspect with `otis migrate status`, roll back with `otis migrate down`, "+
+			"then fix the cause and restart): %w",
+			filepath.Base(partial.Failed.Source.Path), version, partial.Err)
+	}
+
+	for _, result := range results {
+		log.Printf("Applied migration %s (%s)", filepath.Base(result.Source.Path), result.Duration)
+	}
+	return nil
+}
+
+// MigrationStatus reports every known migration and whether it has been applied
+func (s *Store) MigrationStatus() ([]*MigrationState, error) {
+	provider, err := s.migrationProvider(migrationsFS())
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := provider.Status(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration status: %w", err)
+	}
+
+	var states []*MigrationState
+	for _, status := range statuses {
+		states = append(states, &MigrationState{
+			Version:   status.Source.Version,
+			Name:      filepath.Base(status.Source.Path),
+			Applied:   status.State == goose.StateApplied,
+			AppliedAt: status.AppliedAt,
+		})
+	}
+	return states, nil
+}
+
+// MigrateDown rolls back the most recently applied migration and returns its name
+func (s *Store) MigrateDown() (string, error) {
+	provider, err := s.migrationProvider(migrationsFS())
+	if err != nil {
+		return "", err
+	}
+
+	result, err := provider.Down(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("failed to roll back migration: %w", err)
+	}
+	return filepath.Base(result.Source.Path), nil
+}
+
+// SchemaVersion returns the version of the most recently applied migration
+func (s *Store) SchemaVersion() (int64, error) {
+	provider, err := s.migrationProvider(migrationsFS())
+	if err != nil {
+		return 0, err
+	}
+	return provider.GetDBVersion(context.Background())
+}
@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Maps Anthropic Console API keys to otis identities
+CREATE TABLE api_key_identities (
+    api_key TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    organization_id TEXT NOT NULL
+);
+
+-- Daily per-user, per-model usage rollup. The source column records where
+-- the row came from ('console' for imported Anthropic Console exports).
+CREATE TABLE daily_usage (
+    day TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    organization_id TEXT NOT NULL,
+    model TEXT NOT NULL,
+    source TEXT NOT NULL,
+
+    input_tokens INTEGER DEFAULT 0,
+    output_tokens INTEGER DEFAULT 0,
+    cache_read_tokens INTEGER DEFAULT 0,
+    cache_creation_tokens INTEGER DEFAULT 0,
+    cost_usd REAL DEFAULT 0,
+
+    created_at INTEGER,
+    updated_at INTEGER,
+
+    PRIMARY KEY (day, user_id, model, source)
+);
+
+CREATE INDEX idx_daily_usage_org ON daily_usage(organization_id, day);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_daily_usage_org;
+DROP TABLE IF EXISTS daily_usage;
+DROP TABLE IF EXISTS api_key_identities;
+-- +goose StatementEnd
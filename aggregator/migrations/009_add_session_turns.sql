@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- A turn is a user prompt plus the API requests that follow it
+ALTER TABLE sessions ADD COLUMN turn_count INTEGER DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN avg_tokens_per_turn REAL DEFAULT 0;
+
+CREATE TABLE session_turns (
+    session_id TEXT NOT NULL,
+    turn_index INTEGER NOT NULL,
+    start_time INTEGER NOT NULL,
+    end_time INTEGER NOT NULL,
+    prompted INTEGER DEFAULT 1,
+
+    request_count INTEGER DEFAULT 0,
+    input_tokens INTEGER DEFAULT 0,
+    output_tokens INTEGER DEFAULT 0,
+    cost_usd REAL DEFAULT 0,
+
+    PRIMARY KEY (session_id, turn_index),
+    FOREIGN KEY (session_id) REFERENCES sessions(session_id)
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS session_turns;
+-- The bundled SQLite (3.35.0+) supports DROP COLUMN
+ALTER TABLE sessions DROP COLUMN avg_tokens_per_turn;
+ALTER TABLE sessions DROP COLUMN turn_count;
+-- +goose StatementEnd
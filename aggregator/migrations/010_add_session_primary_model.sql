@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- first_model is the first model a session used; primary_model is the one
+-- with the most requests, refreshed from session_models on every flush
+ALTER TABLE sessions ADD COLUMN first_model TEXT;
+ALTER TABLE sessions ADD COLUMN primary_model TEXT;
+
+CREATE INDEX idx_sessions_primary_model ON sessions(primary_model);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_sessions_primary_model;
+ALTER TABLE sessions DROP COLUMN primary_model;
+ALTER TABLE sessions DROP COLUMN first_model;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Export jobs write session CSVs in the background; the finished file is kept
+-- on disk until expires_at so interrupted downloads can resume
+CREATE TABLE export_jobs (
+    job_id TEXT PRIMARY KEY,
+    status TEXT NOT NULL,
+    organization_id TEXT,
+    user_id TEXT,
+    window_type TEXT NOT NULL,
+    window_start INTEGER NOT NULL,
+    window_end INTEGER NOT NULL,
+
+    file_path TEXT,
+    size_bytes INTEGER DEFAULT 0,
+    row_count INTEGER DEFAULT 0,
+    error TEXT,
+
+    created_at INTEGER NOT NULL,
+    completed_at INTEGER,
+    expires_at INTEGER
+);
+
+CREATE INDEX idx_export_jobs_status ON export_jobs(status, created_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_export_jobs_status;
+DROP TABLE IF EXISTS export_jobs;
+-- +goose StatementEnd
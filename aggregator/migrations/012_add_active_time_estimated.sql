@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- active_time_estimated marks sessions whose total_active_time_seconds was
+-- estimated from api_request timestamps because the client never reported
+-- the claude_code.active_time.total metric
+ALTER TABLE session_stats ADD COLUMN active_time_estimated INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE session_stats DROP COLUMN active_time_estimated;
+-- +goose StatementEnd
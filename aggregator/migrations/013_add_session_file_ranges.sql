@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- session_file_ranges records, per session and file generation (identified
+-- by inode), the byte range holding the session's lines, so otis verify can
+-- re-read a session without scanning every file
+CREATE TABLE IF NOT EXISTS session_file_ranges (
+    session_id TEXT NOT NULL,
+    file_name TEXT NOT NULL,
+    inode INTEGER NOT NULL,
+    start_offset INTEGER NOT NULL,
+    end_offset INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    PRIMARY KEY (session_id, file_name, inode)
+);
+
+CREATE INDEX idx_session_file_ranges_file ON session_file_ranges(file_name, inode);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_session_file_ranges_file;
+DROP TABLE IF EXISTS session_file_ranges;
+-- +goose StatementEnd
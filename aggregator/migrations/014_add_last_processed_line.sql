@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- last_processed_line counts the lines of the current file generation that
+-- have been processed, alongside the byte offset used for seeking
+ALTER TABLE processing_state ADD COLUMN last_processed_line INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE processing_state DROP COLUMN last_processed_line;
+-- +goose StatementEnd
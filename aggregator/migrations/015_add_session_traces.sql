@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- session_traces records the distinct OTLP trace IDs (hex) seen for each
+-- session, so sessions and their turns can link out to an external tracing
+-- UI. Only the most recent traces of a session are kept.
+CREATE TABLE IF NOT EXISTS session_traces (
+    session_id TEXT NOT NULL,
+    trace_id TEXT NOT NULL,
+    first_seen INTEGER NOT NULL,
+    last_seen INTEGER NOT NULL,
+    PRIMARY KEY (session_id, trace_id)
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS session_traces;
+-- +goose StatementEnd
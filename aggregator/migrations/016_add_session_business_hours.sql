@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- business_seconds and after_hours_seconds split a session's active time by
+-- whether it fell within the configured business hours, in proportion to how
+-- much of the session's span did; recomputed on every flush
+ALTER TABLE sessions ADD COLUMN business_seconds REAL NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN after_hours_seconds REAL NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE sessions DROP COLUMN after_hours_seconds;
+ALTER TABLE sessions DROP COLUMN business_seconds;
+-- +goose StatementEnd
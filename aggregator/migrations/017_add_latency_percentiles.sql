@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- p50, p95 and p99 of API request latency per session model, and of tool call
+-- duration per session tool, estimated by the engine to within 1% and
+-- recomputed on every flush
+ALTER TABLE session_model_stats ADD COLUMN p50_latency_ms REAL NOT NULL DEFAULT 0;
+ALTER TABLE session_model_stats ADD COLUMN p95_latency_ms REAL NOT NULL DEFAULT 0;
+ALTER TABLE session_model_stats ADD COLUMN p99_latency_ms REAL NOT NULL DEFAULT 0;
+
+ALTER TABLE session_tool_stats ADD COLUMN p50_duration_ms REAL NOT NULL DEFAULT 0;
+ALTER TABLE session_tool_stats ADD COLUMN p95_duration_ms REAL NOT NULL DEFAULT 0;
+ALTER TABLE session_tool_stats ADD COLUMN p99_duration_ms REAL NOT NULL DEFAULT 0;
+
+ALTER TABLE session_tools ADD COLUMN p50_execution_time_ms REAL NOT NULL DEFAULT 0;
+ALTER TABLE session_tools ADD COLUMN p95_execution_time_ms REAL NOT NULL DEFAULT 0;
+ALTER TABLE session_tools ADD COLUMN p99_execution_time_ms REAL NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE session_tools DROP COLUMN p99_execution_time_ms;
+ALTER TABLE session_tools DROP COLUMN p95_execution_time_ms;
+ALTER TABLE session_tools DROP COLUMN p50_execution_time_ms;
+
+ALTER TABLE session_tool_stats DROP COLUMN p99_duration_ms;
+ALTER TABLE session_tool_stats DROP COLUMN p95_duration_ms;
+ALTER TABLE session_tool_stats DROP COLUMN p50_duration_ms;
+
+ALTER TABLE session_model_stats DROP COLUMN p99_latency_ms;
+ALTER TABLE session_model_stats DROP COLUMN p95_latency_ms;
+ALTER TABLE session_model_stats DROP COLUMN p50_latency_ms;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- api_error_rate is api_error_count / (api_request_count + api_error_count),
+-- computed on flush so sessions failing most of their requests stand out
+ALTER TABLE session_stats ADD COLUMN api_error_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE session_stats ADD COLUMN api_error_rate REAL NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN api_error_rate REAL NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE sessions DROP COLUMN api_error_rate;
+ALTER TABLE session_stats DROP COLUMN api_error_rate;
+ALTER TABLE session_stats DROP COLUMN api_error_count;
+-- +goose StatementEnd
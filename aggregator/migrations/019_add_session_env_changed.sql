@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- env_changed marks sessions whose client, terminal, architecture or OS
+-- reported different values mid-session, such as a client upgraded while a
+-- long-lived session was resumed. The environment columns hold the latest.
+ALTER TABLE sessions ADD COLUMN env_changed INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE sessions DROP COLUMN env_changed;
+-- +goose StatementEnd
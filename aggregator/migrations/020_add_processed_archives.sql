@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- processed_archives records rotated generations of an input file that were
+-- backfilled whole, by the inode of the file as originally written (kept in
+-- the gzip header once compressed), since their names shift on every rotation
+CREATE TABLE processed_archives (
+    file_name TEXT NOT NULL,
+    inode INTEGER NOT NULL,
+    processed_at INTEGER NOT NULL,
+    PRIMARY KEY (file_name, inode)
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE processed_archives;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- total_active_time_seconds carries the claude_code.active_time.total metric,
+-- or the estimate from api_request timestamps, over to the sessions table
+ALTER TABLE sessions ADD COLUMN total_active_time_seconds REAL NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE sessions DROP COLUMN total_active_time_seconds;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Tagged OTLP export requests that contributed records to each session, with
+-- the W3C trace context the exporter sent, for end-to-end debugging
+CREATE TABLE session_batches (
+    session_id TEXT NOT NULL,
+    batch_id TEXT NOT NULL,
+    traceparent TEXT,
+    tracestate TEXT,
+    first_seen INTEGER NOT NULL,
+    metric_count INTEGER NOT NULL DEFAULT 0,
+    log_count INTEGER NOT NULL DEFAULT 0,
+    span_count INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (session_id, batch_id)
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS session_batches;
+-- +goose StatementEnd
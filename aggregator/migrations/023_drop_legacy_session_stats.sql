@@ -0,0 +1,179 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- The columns only the legacy session_stats, session_model_stats and
+-- session_tool_stats tables had move to sessions, session_models and
+-- session_tools, which become the only copy of the session aggregates
+ALTER TABLE sessions ADD COLUMN tool_success_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN tool_failure_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN active_time_estimated INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE session_models ADD COLUMN p50_latency_ms REAL NOT NULL DEFAULT 0;
+ALTER TABLE session_models ADD COLUMN p95_latency_ms REAL NOT NULL DEFAULT 0;
+ALTER TABLE session_models ADD COLUMN p99_latency_ms REAL NOT NULL DEFAULT 0;
+
+ALTER TABLE session_tools ADD COLUMN min_execution_time_ms REAL NOT NULL DEFAULT 0;
+ALTER TABLE session_tools ADD COLUMN max_execution_time_ms REAL NOT NULL DEFAULT 0;
+
+-- Sessions recorded before the sessions table existed
+INSERT INTO sessions (
+    session_id, organization_id, user_id, start_time, end_time,
+    client_name, terminal_type, host_arch, os_type,
+    total_cost_usd, total_input_tokens, total_output_tokens,
+    total_cache_read_tokens, total_cache_creation_tokens, tool_call_count,
+    api_request_count, api_error_count, api_error_rate, user_prompt_count, total_api_latency_ms,
+    total_active_time_seconds, created_at, updated_at
+)
+SELECT
+    session_id, organization_id, user_id, COALESCE(start_time, 0), last_update_time,
+    service_name, terminal_type, host_arch, os_type,
+    COALESCE(total_cost_usd, 0), COALESCE(total_input_tokens, 0), COALESCE(total_output_tokens, 0),
+    COALESCE(total_cache_read_tokens, 0), COALESCE(total_cache_creation_tokens, 0), COALESCE(tool_execution_count, 0),
+    COALESCE(api_request_count, 0), api_error_count, api_error_rate, COALESCE(user_prompt_count, 0),
+    COALESCE(total_api_latency_ms, 0), COALESCE(total_active_time_seconds, 0),
+    COALESCE(created_at, start_time, 0), COALESCE(updated_at, last_update_time, start_time, 0)
+FROM session_stats
+WHERE session_id NOT IN (SELECT session_id FROM sessions);
+
+-- Active time reached the sessions table in 021, so older sessions take the
+-- legacy value
+UPDATE sessions SET
+    tool_success_count = (SELECT COALESCE(tool_success_count, 0) FROM session_stats st WHERE st.session_id = sessions.session_id),
+    tool_failure_count = (SELECT COALESCE(tool_failure_count, 0) FROM session_stats st WHERE st.session_id = sessions.session_id),
+    active_time_estimated = (SELECT active_time_estimated FROM session_stats st WHERE st.session_id = sessions.session_id),
+    total_active_time_seconds = CASE WHEN total_active_time_seconds > 0 THEN total_active_time_seconds
+        ELSE (SELECT COALESCE(total_active_time_seconds, 0) FROM session_stats st WHERE st.session_id = sessions.session_id) END
+WHERE session_id IN (SELECT session_id FROM session_stats);
+
+INSERT INTO session_models (
+    session_id, model, request_count, cost_usd,
+    input_tokens, output_tokens, cache_read_tokens, cache_creation_tokens, total_latency_ms
+)
+SELECT
+    session_id, model, COALESCE(request_count, 0), COALESCE(cost_usd, 0),
+    COALESCE(input_tokens, 0), COALESCE(output_tokens, 0), COALESCE(cache_read_tokens, 0),
+    COALESCE(cache_creation_tokens, 0), COALESCE(total_latency_ms, 0)
+FROM session_model_stats ms
+WHERE NOT EXISTS (SELECT 1 FROM session_models sm WHERE sm.session_id = ms.session_id AND sm.model = ms.model);
+
+UPDATE session_models SET
+    p50_latency_ms = (SELECT p50_latency_ms FROM session_model_stats ms WHERE ms.session_id = session_models.session_id AND ms.model = session_models.model),
+    p95_latency_ms = (SELECT p95_latency_ms FROM session_model_stats ms WHERE ms.session_id = session_models.session_id AND ms.model = session_models.model),
+    p99_latency_ms = (SELECT p99_latency_ms FROM session_model_stats ms WHERE ms.session_id = session_models.session_id AND ms.model = session_models.model)
+WHERE EXISTS (SELECT 1 FROM session_model_stats ms WHERE ms.session_id = session_models.session_id AND ms.model = session_models.model);
+
+INSERT INTO session_tools (
+    session_id, tool_name, call_count, success_count, failure_count, total_execution_time_ms,
+    p50_execution_time_ms, p95_execution_time_ms, p99_execution_time_ms
+)
+SELECT
+    session_id, tool_name, COALESCE(execution_count, 0), COALESCE(success_count, 0),
+    COALESCE(failure_count, 0), COALESCE(total_duration_ms, 0),
+    p50_duration_ms, p95_duration_ms, p99_duration_ms
+FROM session_tool_stats ts
+WHERE NOT EXISTS (SELECT 1 FROM session_tools st WHERE st.session_id = ts.session_id AND st.tool_name = ts.tool_name);
+
+UPDATE session_tools SET
+    min_execution_time_ms = (SELECT COALESCE(min_duration_ms, 0) FROM session_tool_stats ts WHERE ts.session_id = session_tools.session_id AND ts.tool_name = session_tools.tool_name),
+    max_execution_time_ms = (SELECT COALESCE(max_duration_ms, 0) FROM session_tool_stats ts WHERE ts.session_id = session_tools.session_id AND ts.tool_name = session_tools.tool_name)
+WHERE EXISTS (SELECT 1 FROM session_tool_stats ts WHERE ts.session_id = session_tools.session_id AND ts.tool_name = session_tools.tool_name);
+
+DROP TABLE session_tool_stats;
+DROP TABLE session_model_stats;
+DROP TABLE session_stats;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+-- The legacy tables come back empty
+CREATE TABLE session_stats (
+    session_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    organization_id TEXT NOT NULL,
+    service_name TEXT,
+    start_time INTEGER,
+    last_update_time INTEGER,
+    terminal_type TEXT,
+    host_arch TEXT,
+    os_type TEXT,
+    total_cost_usd REAL DEFAULT 0,
+    total_input_tokens INTEGER DEFAULT 0,
+    total_output_tokens INTEGER DEFAULT 0,
+    total_cache_read_tokens INTEGER DEFAULT 0,
+    total_cache_creation_tokens INTEGER DEFAULT 0,
+    total_active_time_seconds REAL DEFAULT 0,
+    api_request_count INTEGER DEFAULT 0,
+    user_prompt_count INTEGER DEFAULT 0,
+    tool_execution_count INTEGER DEFAULT 0,
+    tool_success_count INTEGER DEFAULT 0,
+    tool_failure_count INTEGER DEFAULT 0,
+    avg_api_latency_ms REAL DEFAULT 0,
+    total_api_latency_ms REAL DEFAULT 0,
+    models_used TEXT,
+    tools_used TEXT,
+    created_at INTEGER,
+    updated_at INTEGER,
+    active_time_estimated INTEGER NOT NULL DEFAULT 0,
+    api_error_count INTEGER NOT NULL DEFAULT 0,
+    api_error_rate REAL NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_session_user_id ON session_stats(user_id);
+CREATE INDEX idx_session_org_id ON session_stats(organization_id);
+CREATE INDEX idx_session_start_time ON session_stats(start_time);
+
+CREATE TABLE session_model_stats (
+    session_id TEXT NOT NULL,
+    model TEXT NOT NULL,
+    cost_usd REAL DEFAULT 0,
+    input_tokens INTEGER DEFAULT 0,
+    output_tokens INTEGER DEFAULT 0,
+    cache_read_tokens INTEGER DEFAULT 0,
+    cache_creation_tokens INTEGER DEFAULT 0,
+    request_count INTEGER DEFAULT 0,
+    total_latency_ms REAL DEFAULT 0,
+    avg_latency_ms REAL DEFAULT 0,
+    p50_latency_ms REAL NOT NULL DEFAULT 0,
+    p95_latency_ms REAL NOT NULL DEFAULT 0,
+    p99_latency_ms REAL NOT NULL DEFAULT 0,
+    PRIMARY KEY (session_id, model),
+    FOREIGN KEY (session_id) REFERENCES session_stats(session_id)
+);
+
+CREATE INDEX idx_model_name ON session_model_stats(model);
+CREATE INDEX idx_model_session ON session_model_stats(session_id);
+
+CREATE TABLE session_tool_stats (
+    session_id TEXT NOT NULL,
+    tool_name TEXT NOT NULL,
+    execution_count INTEGER DEFAULT 0,
+    success_count INTEGER DEFAULT 0,
+    failure_count INTEGER DEFAULT 0,
+    total_duration_ms REAL DEFAULT 0,
+    avg_duration_ms REAL DEFAULT 0,
+    min_duration_ms REAL DEFAULT 0,
+    max_duration_ms REAL DEFAULT 0,
+    p50_duration_ms REAL NOT NULL DEFAULT 0,
+    p95_duration_ms REAL NOT NULL DEFAULT 0,
+    p99_duration_ms REAL NOT NULL DEFAULT 0,
+    PRIMARY KEY (session_id, tool_name),
+    FOREIGN KEY (session_id) REFERENCES session_stats(session_id)
+);
+
+CREATE INDEX idx_tool_name ON session_tool_stats(tool_name);
+CREATE INDEX idx_tool_session ON session_tool_stats(session_id);
+
+ALTER TABLE session_tools DROP COLUMN max_execution_time_ms;
+ALTER TABLE session_tools DROP COLUMN min_execution_time_ms;
+
+ALTER TABLE session_models DROP COLUMN p99_latency_ms;
+ALTER TABLE session_models DROP COLUMN p95_latency_ms;
+ALTER TABLE session_models DROP COLUMN p50_latency_ms;
+
+ALTER TABLE sessions DROP COLUMN active_time_estimated;
+ALTER TABLE sessions DROP COLUMN tool_failure_count;
+ALTER TABLE sessions DROP COLUMN tool_success_count;
+
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Each session's usage per minute, summed into the buckets of
+-- /api/stats/timeseries
+CREATE TABLE session_rollups (
+    session_id TEXT NOT NULL,
+    bucket_start INTEGER NOT NULL,
+    organization_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    cost_usd REAL NOT NULL DEFAULT 0,
+    input_tokens INTEGER NOT NULL DEFAULT 0,
+    output_tokens INTEGER NOT NULL DEFAULT 0,
+    cache_read_tokens INTEGER NOT NULL DEFAULT 0,
+    cache_creation_tokens INTEGER NOT NULL DEFAULT 0,
+    api_request_count INTEGER NOT NULL DEFAULT 0,
+    tool_call_count INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (session_id, bucket_start)
+);
+
+CREATE INDEX idx_session_rollups_bucket ON session_rollups(bucket_start);
+CREATE INDEX idx_session_rollups_user ON session_rollups(user_id, bucket_start);
+CREATE INDEX idx_session_rollups_org ON session_rollups(organization_id, bucket_start);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS session_rollups;
+-- +goose StatementEnd
@@ -0,0 +1,133 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- The user_stats and org_stats tables from the initial schema were never
+-- written. They are rebuilt keyed by window type alone, holding the latest
+-- rollup of each rolling window rather than one row per window start.
+DROP TABLE IF EXISTS user_stats;
+DROP TABLE IF EXISTS org_stats;
+
+CREATE TABLE user_stats (
+    user_id TEXT NOT NULL,
+    window_type TEXT NOT NULL,
+    organization_id TEXT NOT NULL DEFAULT '',
+    window_start INTEGER,
+    window_end INTEGER,
+
+    total_sessions INTEGER NOT NULL DEFAULT 0,
+    total_cost_usd REAL NOT NULL DEFAULT 0,
+    total_input_tokens INTEGER NOT NULL DEFAULT 0,
+    total_output_tokens INTEGER NOT NULL DEFAULT 0,
+    total_cache_read_tokens INTEGER NOT NULL DEFAULT 0,
+    total_cache_creation_tokens INTEGER NOT NULL DEFAULT 0,
+    total_active_time_seconds REAL NOT NULL DEFAULT 0,
+
+    avg_cost_per_session REAL NOT NULL DEFAULT 0,
+    avg_tokens_per_session REAL NOT NULL DEFAULT 0,
+    avg_session_duration_seconds REAL NOT NULL DEFAULT 0,
+
+    preferred_models TEXT NOT NULL DEFAULT '[]',
+    favorite_tools TEXT NOT NULL DEFAULT '[]',
+
+    tool_success_rate REAL NOT NULL DEFAULT 0,
+
+    last_session_time INTEGER,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+
+    PRIMARY KEY (user_id, window_type)
+);
+
+CREATE INDEX idx_user_stats_org ON user_stats(organization_id, window_type);
+
+CREATE TABLE org_stats (
+    organization_id TEXT NOT NULL,
+    window_type TEXT NOT NULL,
+    window_start INTEGER,
+    window_end INTEGER,
+
+    total_users INTEGER NOT NULL DEFAULT 0,
+    total_sessions INTEGER NOT NULL DEFAULT 0,
+    total_cost_usd REAL NOT NULL DEFAULT 0,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    total_active_time_seconds REAL NOT NULL DEFAULT 0,
+
+    avg_cost_per_user REAL NOT NULL DEFAULT 0,
+    avg_sessions_per_user REAL NOT NULL DEFAULT 0,
+
+    top_users_by_cost TEXT NOT NULL DEFAULT '[]',
+    top_users_by_usage TEXT NOT NULL DEFAULT '[]',
+
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+
+    PRIMARY KEY (organization_id, window_type)
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_stats;
+DROP TABLE IF EXISTS org_stats;
+
+CREATE TABLE user_stats (
+    user_id TEXT NOT NULL,
+    organization_id TEXT NOT NULL,
+    window_start INTEGER,
+    window_end INTEGER,
+    window_type TEXT,
+
+    total_sessions INTEGER DEFAULT 0,
+    total_cost_usd REAL DEFAULT 0,
+    total_input_tokens INTEGER DEFAULT 0,
+    total_output_tokens INTEGER DEFAULT 0,
+    total_cache_read_tokens INTEGER DEFAULT 0,
+    total_cache_creation_tokens INTEGER DEFAULT 0,
+    total_active_time_seconds REAL DEFAULT 0,
+
+    avg_cost_per_session REAL DEFAULT 0,
+    avg_tokens_per_session REAL DEFAULT 0,
+    avg_session_duration_seconds REAL DEFAULT 0,
+
+    preferred_models TEXT,
+    favorite_tools TEXT,
+
+    tool_success_rate REAL DEFAULT 0,
+
+    last_session_time INTEGER,
+    created_at INTEGER,
+    updated_at INTEGER,
+
+    PRIMARY KEY (user_id, window_type, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_org_id ON user_stats(organization_id);
+CREATE INDEX IF NOT EXISTS idx_user_window ON user_stats(window_start, window_end);
+
+CREATE TABLE org_stats (
+    organization_id TEXT NOT NULL,
+    window_start INTEGER,
+    window_end INTEGER,
+    window_type TEXT,
+
+    total_users INTEGER DEFAULT 0,
+    total_sessions INTEGER DEFAULT 0,
+    total_cost_usd REAL DEFAULT 0,
+    total_tokens INTEGER DEFAULT 0,
+    total_active_time_seconds REAL DEFAULT 0,
+
+    avg_cost_per_user REAL DEFAULT 0,
+    avg_sessions_per_user REAL DEFAULT 0,
+
+    top_users_by_cost TEXT,
+    top_users_by_usage TEXT,
+
+    created_at INTEGER,
+    updated_at INTEGER,
+
+    PRIMARY KEY (organization_id, window_type, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_window ON org_stats(window_start, window_end);
+-- +goose StatementEnd
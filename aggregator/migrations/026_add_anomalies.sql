@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Noteworthy usage flagged for review, such as an organization using a model
+-- that isn't on the allowlist. Each kind of anomaly is flagged once per
+-- organization and subject, when first detected.
+CREATE TABLE anomalies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    organization_id TEXT NOT NULL DEFAULT '',
+    subject TEXT NOT NULL,
+    session_id TEXT NOT NULL DEFAULT '',
+    first_seen INTEGER NOT NULL,
+    detected_at INTEGER NOT NULL,
+
+    UNIQUE (kind, organization_id, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_anomalies_org ON anomalies(organization_id, detected_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS anomalies;
+-- +goose StatementEnd
@@ -0,0 +1,184 @@
+package aggregator
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRunMigrationsFailureIsRolledBack(t *testing.T) {
+	dbPath := "./test_migration_failure.db"
+	defer os.Remove(dbPath)
+
+	store, err := OpenStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	// The second statement of 002 fails after the first succeeded
+	fsys := fstest.MapFS{
+		"001_create_widgets.sql": {Data: []byte("-- +goose Up\nCREATE TABLE widgets (id INTEGER PRIMARY KEY);\n")},
+		"002_broken.sql": {Data: []byte("-- +goose Up\nCREATE TABLE gadgets (id INTEGER PRIMARY KEY);\n" +
+			"ALTER TABLE missing_table ADD COLUMN name TEXT;\n")},
+	}
+
+	err = store.runMigrations(fsys)
+	if err == nil {
+		t.Fatal("Expected migration to fail")
+	}
+	for _, want := range []string{"002_broken.sql", "database is at version 1", "otis migrate status", "missing_table"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got: %v", want, err)
+		}
+	}
+
+	// The partial 002 must not leave the gadgets table behind
+	var count int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='gadgets'`).Scan(&count); err != nil {
+		t.Fatalf("Failed to query schema: %v", err)
+	}
+	if count != 0 {
+		t.Error("Expected the failed migration to be rolled back")
+	}
+
+	// Fixing the migration lets a restart succeed
+	fsys["002_broken.sql"] = &fstest.MapFile{Data: []byte("-- +goose Up\nCREATE TABLE gadgets (id INTEGER PRIMARY KEY);\n")}
+	if err := store.runMigrations(fsys); err != nil {
+		t.Fatalf("Expected fixed migration to apply, got %v", err)
+	}
+}
+
+func TestMigrationStatusAndDown(t *testing.T) {
+	dbPath := "./test_migration_status.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	states, err := store.MigrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	if len(states) == 0 {
+		t.Fatal("Expected migrations to be listed")
+	}
+	for _, state := range states {
+		if !state.Applied {
+			t.Errorf("Expected %s to be applied", state.Name)
+		}
+	}
+
+	latest := states[len(states)-1]
+	name, err := store.MigrateDown()
+	if err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	if name != latest.Name {
+		t.Errorf("Expected %s to be rolled back, got %s", latest.Name, name)
+	}
+
+	states, err = store.MigrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	if states[len(states)-1].Applied {
+		t.Errorf("Expected %s to be pending after rollback", latest.Name)
+	}
+}
+
+func TestLegacySessionStatsMigrateToSessions(t *testing.T) {
+	dbPath := "./test_migration_legacy_stats.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Back to the schema that still had the legacy tables
+	for {
+		version, err := store.SchemaVersion()
+		if err != nil {
+			t.Fatalf("Failed to get schema version: %v", err)
+		}
+		if version <= 22 {
+			break
+		}
+		if _, err := store.MigrateDown(); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+	}
+
+	// One session only the legacy tables know, one both know
+	for _, stmt := range []string{
+		`INSERT INTO session_stats (session_id, user_id, organization_id, service_name, start_time, last_update_time,
+			total_cost_usd, total_active_time_seconds, api_request_count, tool_execution_count,
+			tool_success_count, tool_failure_count, active_time_estimated)
+		VALUES ('legacy-only', 'user-1', 'org-1', 'claude-code', 100, 200, 1.5, 30, 4, 3, 2, 1, 1)`,
+		`INSERT INTO session_model_stats (session_id, model, cost_usd, request_count, total_latency_ms, p95_latency_ms)
+		VALUES ('legacy-only', 'claude-sonnet-4-5', 1.5, 4, 400, 180)`,
+		`INSERT INTO session_tool_stats (session_id, tool_name, execution_count, success_count, failure_count,
+			total_duration_ms, min_duration_ms, max_duration_ms)
+		VALUES ('legacy-only', 'Bash', 3, 2, 1, 60, 10, 30)`,
+		`INSERT INTO sessions (session_id, organization_id, user_id, start_time, tool_call_count, created_at, updated_at)
+		VALUES ('both', 'org-1', 'user-1', 100, 5, 100, 200)`,
+		`INSERT INTO session_stats (session_id, user_id, organization_id, start_time, total_active_time_seconds,
+			tool_success_count, tool_failure_count)
+		VALUES ('both', 'user-1', 'org-1', 100, 45, 4, 1)`,
+		`INSERT INTO session_tools (session_id, tool_name, call_count) VALUES ('both', 'Read', 5)`,
+		`INSERT INTO session_tool_stats (session_id, tool_name, execution_count, min_duration_ms, max_duration_ms)
+		VALUES ('both', 'Read', 5, 2, 8)`,
+	} {
+		if _, err := store.db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to seed legacy rows: %v", err)
+		}
+	}
+
+	if err := store.runMigrations(migrationsFS()); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+
+	legacy, err := store.GetSession("legacy-only")
+	if err != nil {
+		t.Fatalf("Expected the legacy session to be copied: %v", err)
+	}
+	if legacy.TotalCostUSD != 1.5 || legacy.ToolCallCount != 3 || legacy.ToolSuccessCount != 2 ||
+		legacy.ToolFailureCount != 1 || legacy.TotalActiveTimeSeconds != 30 || !legacy.ActiveTimeEstimated {
+		t.Errorf("Unexpected copied session: %+v", legacy)
+	}
+	models, err := store.GetSessionModels("legacy-only")
+	if err != nil || len(models) != 1 || models[0].RequestCount != 4 || models[0].P95LatencyMS != 180 {
+		t.Errorf("Expected the legacy model row to be copied, got %v (%v)", models, err)
+	}
+	tools, err := store.GetSessionTools("legacy-only")
+	if err != nil || len(tools) != 1 || tools[0].CallCount != 3 || tools[0].MinExecutionTimeMS != 10 || tools[0].MaxExecutionTimeMS != 30 {
+		t.Errorf("Expected the legacy tool row to be copied, got %v (%v)", tools, err)
+	}
+
+	// A session in both keeps its own counts and gains the legacy-only columns
+	both, err := store.GetSession("both")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if both.ToolCallCount != 5 || both.ToolSuccessCount != 4 || both.ToolFailureCount != 1 || both.TotalActiveTimeSeconds != 45 {
+		t.Errorf("Unexpected backfilled session: %+v", both)
+	}
+	tools, err = store.GetSessionTools("both")
+	if err != nil || len(tools) != 1 || tools[0].MinExecutionTimeMS != 2 || tools[0].MaxExecutionTimeMS != 8 {
+		t.Errorf("Expected the tool's execution time range to be backfilled, got %v (%v)", tools, err)
+	}
+
+	var count int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name LIKE 'session%_stats'`).Scan(&count); err != nil {
+		t.Fatalf("Failed to query schema: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected the legacy tables to be dropped, found %d", count)
+	}
+}
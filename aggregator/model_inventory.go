@@ -0,0 +1,309 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// anomalyUnapprovedModel flags an organization's first use of a model that
+// isn't on the allowlist; the anomaly's subject is the model
+const anomalyUnapprovedModel = "unapproved_model"
+
+// ModelInventoryEntry is one model an organization has used, over all time
+type ModelInventoryEntry struct {
+	Model     string
+	FirstUsed time.Time
+	LastUsed  time.Time
+	Sessions  int
+	Requests  int64
+	CostUSD   float64
+	FlaggedAt time.Time // When flagged as unapproved; zero if never
+}
+
+// Anomaly is noteworthy usage flagged for review
+type Anomaly struct {
+	Kind           string    `json:"kind"`
+	OrganizationID string    `json:"organization_id"`
+	Subject        string    `json:"subject"`
+	SessionID      string    `json:"session_id"`
+	FirstSeen      time.Time `json:"first_seen"`
+	DetectedAt     time.Time `json:"detected_at"`
+}
+
+// modelAllowlist is the set of models organizations are expected to use
+type modelAllowlist map[string]bool
+
+// SetModelAllowlist flags, after each flush, every organization's first use
+// of a model not in models as an unapproved model anomaly. An empty list
+// disables the check.
+func (e *Engine) SetModelAllowlist(models []string) {
+	if len(models) == 0 {
+		e.modelAllowlist.Store(nil)
+		return
+	}
+	allowlist := make(modelAllowlist, len(models))
+	for _, model := range models {
+		allowlist[model] = true
+	}
+	e.modelAllowlist.Store(&allowlist)
+}
+
+// modelApproved reports whether model is on the allowlist, or nil without one
+func (e *Engine) modelApproved(model string) interface{} {
+	if e == nil {
+		return nil
+	}
+	allowlist := e.modelAllowlist.Load()
+	if allowlist == nil {
+		return nil
+	}
+	return (*allowlist)[model]
+}
+
+// queueModelCheck notes a flushed session's use of a model off the
+// allowlist for the next FlagUnapprovedModels, keeping the earliest session
+// per organization and model. Callers must hold cacheMutex.
+func (e *Engine) queueModelCheck(session *Session, model string) {
+	allowlist := e.modelAllowlist.Load()
+	if session == nil || allowlist == nil || (*allowlist)[model] {
+		return
+	}
+	key := [2]string{session.OrganizationID, model}
+	if queued, ok := e.modelChecks[key]; ok && !session.StartTime.Before(queued.FirstSeen) {
+		return
+	}
+	if e.modelChecks == nil {
+		e.modelChecks = make(map[[2]string]*Anomaly)
+	}
+	e.modelChecks[key] = &Anomaly{
+		Kind:           anomalyUnapprovedModel,
+		OrganizationID: session.OrganizationID,
+		Subject:        model,
+		SessionID:      session.SessionID,
+		FirstSeen:      time.Unix(session.StartTime.Unix(), 0),
+	}
+}
+
+// FlagUnapprovedModels records an anomaly for every organization using a
+// model off the allowlist for the first time, and queues a webhook
+// notification for each. The first call checks every stored session; later
+// ones only the sessions flushed since the previous call, so the check
+// doesn't grow with history. It does nothing without an allowlist.
+func (e *Engine) FlagUnapprovedModels(now time.Time) error {
+	allowlist := e.modelAllowlist.Load()
+	if e.store == nil || allowlist == nil {
+		return nil
+	}
+
+	// Sessions flushed before the full scan starts are covered by it
+	e.cacheMutex.Lock()
+	checks := e.modelChecks
+	e.modelChecks = nil
+	e.cacheMutex.Unlock()
+
+	var flagged []*Anomaly
+	var err error
+	if e.modelsScanned.Load() {
+		candidates := make([]*Anomaly, 0, len(checks))
+		for _, anomaly := range checks {
+			candidates = append(candidates, anomaly)
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if !candidates[i].FirstSeen.Equal(candidates[j].FirstSeen) {
+				return candidates[i].FirstSeen.Before(candidates[j].FirstSeen)
+			}
+			return candidates[i].SessionID < candidates[j].SessionID
+		})
+		flagged, err = e.store.RecordAnomalies(candidates, now)
+		if err != nil {
+			e.requeueModelChecks(checks)
+		}
+	} else {
+		models := make([]string, 0, len(*allowlist))
+		for model := range *allowlist {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		flagged, err = e.store.FlagUnapprovedModels(models, now)
+		if err == nil {
+			e.modelsScanned.Store(true)
+		}
+	}
+
+	for _, anomaly := range flagged {
+		log.Printf("Organization %q used unapproved model %q, first in session %s", anomaly.OrganizationID, anomaly.Subject, anomaly.SessionID)
+		e.webhook.Load().notify(anomaly)
+	}
+	return err
+}
+
+// requeueModelChecks puts back checks that failed to be recorded, so the
+// next call tries them again
+func (e *Engine) requeueModelChecks(checks map[[2]string]*Anomaly) {
+	e.cacheMutex.Lock()
+	defer e.cacheMutex.Unlock()
+
+	for key, anomaly := range checks {
+		if queued, ok := e.modelChecks[key]; ok && !anomaly.FirstSeen.Before(queued.FirstSeen) {
+			continue
+		}
+		if e.modelChecks == nil {
+			e.modelChecks = make(map[[2]string]*Anomaly)
+		}
+		e.modelChecks[key] = anomaly
+	}
+}
+
+// FlagUnapprovedModels records an anomaly for each organization and model
+// off the allowed list that has none yet, naming the session that first used
+// it, and returns the ones recorded
+func (s *Store) FlagUnapprovedModels(allowed []string, now time.Time) ([]*Anomaly, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(allowed)), ", ")
+	query := fmt.Sprintf(`
+	SELECT s.organization_id, m.model, s.session_id, s.start_time
+	FROM session_models m
+	JOIN sessions s ON s.session_id = m.session_id
+	WHERE m.model NOT IN (%s)
+	AND NOT EXISTS (
+		SELECT 1 FROM anomalies a
+		WHERE a.kind = ? AND a.organization_id = s.organization_id AND a.subject = m.model
+	)
+	ORDER BY s.start_time, s.session_id
+	`, placeholders)
+
+	args := make([]interface{}, 0, len(allowed)+1)
+	for _, model := range allowed {
+		args = append(args, model)
+	}
+	args = append(args, anomalyUnapprovedModel)
+
+	rows, err := s.query(queryFindUnapprovedModels, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Sessions come oldest first, so the first one seen for an organization
+	// and model is where it was first used
+	var candidates []*Anomaly
+	seen := make(map[[2]string]bool)
+	for rows.Next() {
+		anomaly := &Anomaly{Kind: anomalyUnapprovedModel}
+		var startTime int64
+		if err := rows.Scan(&anomaly.OrganizationID, &anomaly.Subject, &anomaly.SessionID, &startTime); err != nil {
+			return nil, err
+		}
+		key := [2]string{anomaly.OrganizationID, anomaly.Subject}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		anomaly.FirstSeen = time.Unix(startTime, 0)
+		candidates = append(candidates, anomaly)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return s.RecordAnomalies(candidates, now)
+}
+
+// RecordAnomalies records each anomaly not already recorded for its kind,
+// organization and subject, detected at now, and returns the ones recorded
+func (s *Store) RecordAnomalies(candidates []*Anomaly, now time.Time) ([]*Anomaly, error) {
+	var recorded []*Anomaly
+	for _, anomaly := range candidates {
+		anomaly.DetectedAt = time.Unix(now.Unix(), 0)
+		result, err := s.exec(queryInsertAnomaly, `
+		INSERT OR IGNORE INTO anomalies (kind, organization_id, subject, session_id, first_seen, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		`, anomaly.Kind, anomaly.OrganizationID, anomaly.Subject, anomaly.SessionID, anomaly.FirstSeen.Unix(), anomaly.DetectedAt.Unix())
+		if err != nil {
+			return recorded, err
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			recorded = append(recorded, anomaly)
+		}
+	}
+	return recorded, nil
+}
+
+// GetOrgModelInventory lists every model an organization has used, in the
+// order first used, with when it was flagged if it is unapproved
+func (s *Store) GetOrgModelInventory(orgID string) ([]*ModelInventoryEntry, error) {
+	query := `
+	SELECT
+		m.model,
+		MIN(s.start_time),
+		MAX(COALESCE(s.end_time, s.start_time)),
+		COUNT(DISTINCT s.session_id),
+		COALESCE(SUM(m.request_count), 0),
+		COALESCE(SUM(m.cost_usd), 0),
+		(SELECT a.detected_at FROM anomalies a
+			WHERE a.kind = ? AND a.organization_id = s.organization_id AND a.subject = m.model)
+	FROM session_models m
+	JOIN sessions s ON s.session_id = m.session_id
+	WHERE s.organization_id = ?
+	GROUP BY m.model
+	ORDER BY MIN(s.start_time), m.model
+	`
+
+	rows, err := s.query(queryGetOrgModelInventory, query, anomalyUnapprovedModel, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inventory []*ModelInventoryEntry
+	for rows.Next() {
+		var entry ModelInventoryEntry
+		var firstUsed, lastUsed int64
+		var flaggedAt *int64
+		if err := rows.Scan(&entry.Model, &firstUsed, &lastUsed, &entry.Sessions, &entry.Requests, &entry.CostUSD, &flaggedAt); err != nil {
+			return nil, err
+		}
+		entry.FirstUsed = time.Unix(firstUsed, 0)
+		entry.LastUsed = time.Unix(lastUsed, 0)
+		if flaggedAt != nil {
+			entry.FlaggedAt = time.Unix(*flaggedAt, 0)
+		}
+		inventory = append(inventory, &entry)
+	}
+	return inventory, rows.Err()
+}
+
+// handleOrgModelInventory handles GET /api/stats/org/{org_id}/models/inventory
+func (s *APIServer) handleOrgModelInventory(w http.ResponseWriter, r *http.Request, orgID string) {
+	inventory, err := s.store.GetOrgModelInventory(orgID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving model inventory: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	models := make([]map[string]interface{}, len(inventory))
+	for i, entry := range inventory {
+		models[i] = map[string]interface{}{
+			"model":      entry.Model,
+			"first_used": jsonTime(entry.FirstUsed),
+			"last_used":  jsonTime(entry.LastUsed),
+			"sessions":   entry.Sessions,
+			"requests":   entry.Requests,
+			"cost_usd":   entry.CostUSD,
+			"approved":   s.engine.modelApproved(entry.Model),
+			"flagged_at": jsonTime(entry.FlaggedAt),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"organization_id":   orgID,
+		"allowlist_enabled": s.engine != nil && s.engine.modelAllowlist.Load() != nil,
+		"models":            models,
+	})
+}
@@ -0,0 +1,178 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnapprovedModelsFlaggedOnce(t *testing.T) {
+	dbPath := "./test_model_inventory.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	var mu sync.Mutex
+	var delivered []Anomaly
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var anomaly Anomaly
+		if err := json.NewDecoder(r.Body).Decode(&anomaly); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+		mu.Lock()
+		delivered = append(delivered, anomaly)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	engine := newEngine(store)
+	engine.SetModelAllowlist([]string{"model-a"})
+	engine.SetAnomalyWebhook(hook.URL)
+
+	now := time.Now().Truncate(time.Second)
+	seed := func(id, org string, start time.Time, models ...string) {
+		t.Helper()
+		spec := SessionSpec{SessionID: id, OrganizationID: org, UserID: "u-" + id, StartTime: start, Duration: time.Minute}
+		for _, model := range models {
+			spec.Models = append(spec.Models, ModelSpec{Model: model, Requests: 2, CostUSD: 0.5})
+		}
+		if err := SeedSession(store, spec); err != nil {
+			t.Fatalf("Failed to seed %s: %v", id, err)
+		}
+	}
+
+	// The surprise model shows up twice in org-1, first in s2
+	seed("s1", "org-1", now.Add(-3*time.Hour), "model-a")
+	seed("s2", "org-1", now.Add(-2*time.Hour), "model-a", "model-x")
+	seed("s3", "org-1", now.Add(-time.Hour), "model-x")
+
+	// Deliveries happen in the background
+	waitForDeliveries := func(n int) []Anomaly {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			got := append([]Anomaly(nil), delivered...)
+			mu.Unlock()
+			if len(got) >= n || time.Now().After(deadline) {
+				return got
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The first pass checks every stored session
+	for i := 0; i < 2; i++ {
+		if err := engine.FlagUnapprovedModels(now); err != nil {
+			t.Fatalf("Failed to flag models: %v", err)
+		}
+	}
+	got := waitForDeliveries(1)
+	if len(got) != 1 || got[0].OrganizationID != "org-1" || got[0].Subject != "model-x" ||
+		got[0].SessionID != "s2" || got[0].Kind != anomalyUnapprovedModel {
+		t.Fatalf("Expected one flag for model-x first used in s2, got %+v", got)
+	}
+
+	// Later passes check the sessions flushed since: another org using the
+	// same model is flagged on its own, and org-1's further use isn't again
+	for _, org := range []string{"org-2", "org-1"} {
+		engine.ProcessMetric(&MetricRecord{
+			Timestamp: now, SessionID: "s-" + org, UserID: "u-" + org, OrganizationID: org,
+			MetricName: "claude_code.cost.usage", MetricValue: 0.5, Attributes: map[string]string{"model": "model-x"},
+		})
+	}
+	engine.FlushCache()
+	if err := engine.FlagUnapprovedModels(now); err != nil {
+		t.Fatalf("Failed to flag models: %v", err)
+	}
+	got = waitForDeliveries(2)
+	if len(got) != 2 || got[1].OrganizationID != "org-2" || got[1].SessionID != "s-org-2" {
+		t.Fatalf("Expected a second flag for org-2, got %+v", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := waitForDeliveries(0); len(got) != 2 {
+		t.Fatalf("Expected no further flags, got %+v", got)
+	}
+
+	server := NewAPIServer(0, store, engine)
+	rec := httptest.NewRecorder()
+	server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats/org/org-1/models/inventory", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		AllowlistEnabled bool `json:"allowlist_enabled"`
+		Models           []struct {
+			Model     string  `json:"model"`
+			FirstUsed string  `json:"first_used"`
+			Sessions  int     `json:"sessions"`
+			Requests  int64   `json:"requests"`
+			CostUSD   float64 `json:"cost_usd"`
+			Approved  *bool   `json:"approved"`
+			FlaggedAt *string `json:"flagged_at"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !body.AllowlistEnabled || len(body.Models) != 2 {
+		t.Fatalf("Expected 2 models with the allowlist enabled, got %+v", body)
+	}
+	a, x := body.Models[0], body.Models[1]
+	if a.Model != "model-a" || a.Sessions != 2 || a.Requests != 4 || a.Approved == nil || !*a.Approved || a.FlaggedAt != nil {
+		t.Errorf("Expected approved model-a in 2 sessions, got %+v", a)
+	}
+	if x.Model != "model-x" || x.Sessions != 3 || x.CostUSD != 1.5 || x.Approved == nil || *x.Approved || x.FlaggedAt == nil ||
+		x.FirstUsed != now.Add(-2*time.Hour).Format(time.RFC3339) {
+		t.Errorf("Expected flagged model-x first used 2 hours ago, got %+v", x)
+	}
+}
+
+func TestSlowAnomalyWebhookDoesNotHoldUpFlagging(t *testing.T) {
+	dbPath := "./test_model_inventory_webhook.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	release := make(chan struct{})
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hook.Close()
+	defer close(release)
+
+	engine := newEngine(store)
+	defer engine.Close()
+	engine.SetModelAllowlist([]string{"model-a"})
+	engine.SetAnomalyWebhook(hook.URL)
+
+	now := time.Now().Truncate(time.Second)
+	for i, org := range []string{"org-1", "org-2", "org-3"} {
+		spec := SessionSpec{SessionID: fmt.Sprintf("s%d", i), OrganizationID: org, UserID: "u", StartTime: now, Duration: time.Minute,
+			Models: []ModelSpec{{Model: "model-x", Requests: 1, CostUSD: 0.1}}}
+		if err := SeedSession(store, spec); err != nil {
+			t.Fatalf("Failed to seed session: %v", err)
+		}
+	}
+
+	start := time.Now()
+	if err := engine.FlagUnapprovedModels(now); err != nil {
+		t.Fatalf("Failed to flag models: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected flagging not to wait on the webhook, took %v", elapsed)
+	}
+}
@@ -2,45 +2,6 @@ package aggregator
 
 import "time"
 
-// SessionStats represents aggregated statistics for a single session
-type SessionStats struct {
-	SessionID        string
-	UserID           string
-	OrganizationID   string
-	ServiceName      string
-	StartTime        time.Time
-	LastUpdateTime   time.Time
-	TerminalType     string
-	HostArch         string
-	OSType           string
-
-	// Aggregated metrics
-	TotalCostUSD             float64
-	TotalInputTokens         int64
-	TotalOutputTokens        int64
-	TotalCacheReadTokens     int64
-	TotalCacheCreationTokens int64
-	TotalActiveTimeSeconds   float64
-
-	// Event counts
-	APIRequestCount     int
-	UserPromptCount     int
-	ToolExecutionCount  int
-	ToolSuccessCount    int
-	ToolFailureCount    int
-
-	// Performance metrics
-	AvgAPILatencyMS   float64
-	TotalAPILatencyMS float64
-
-	// JSON-encoded data
-	ModelsUsed string // JSON array
-	ToolsUsed  string // JSON object
-
-	CreatedAt time.Time
-	UpdatedAt time.Time
-}
-
 // UserStats represents aggregated statistics for a user within a time window
 type UserStats struct {
 	UserID         string
@@ -101,36 +62,10 @@ type OrgStats struct {
 	UpdatedAt time.Time
 }
 
-// SessionModelStats represents per-model statistics within a session
-type SessionModelStats struct {
-	SessionID             string
-	Model                 string
-	CostUSD               float64
-	InputTokens           int64
-	OutputTokens          int64
-	CacheReadTokens       int64
-	CacheCreationTokens   int64
-	RequestCount          int
-	TotalLatencyMS        float64
-	AvgLatencyMS          float64
-}
-
-// SessionToolStats represents per-tool statistics within a session
-type SessionToolStats struct {
-	SessionID       string
-	ToolName        string
-	ExecutionCount  int
-	SuccessCount    int
-	FailureCount    int
-	TotalDurationMS float64
-	AvgDurationMS   float64
-	MinDurationMS   float64
-	MaxDurationMS   float64
-}
-
 // ProcessingState tracks the processing position for each JSONL file
 type ProcessingState struct {
 	FileName          string
+	LastProcessedLine int64 // Lines processed in the current file generation
 	LastByteOffset    int64 // Byte position in file (for efficient seeking)
 	LastProcessedTime time.Time
 	FileSizeBytes     int64
@@ -148,6 +83,7 @@ type MetricRecord struct {
 	MetricName     string
 	MetricValue    interface{}
 	Attributes     map[string]string
+	Batch          *IngestBatch // Nil unless the collector tagged the request
 }
 
 // LogRecord represents a parsed log from the JSONL file
@@ -160,6 +96,7 @@ type LogRecord struct {
 	SeverityText   string
 	Body           string
 	Attributes     map[string]interface{}
+	Batch          *IngestBatch // Nil unless the collector tagged the request
 }
 
 // TraceRecord represents a parsed trace/span from the JSONL file
@@ -170,8 +107,11 @@ type TraceRecord struct {
 	OrganizationID string
 	ServiceName    string
 	SpanName       string
+	TraceID        string // Lowercase hex, empty when missing or invalid
+	SpanID         string // Lowercase hex, empty when missing or invalid
 	DurationMS     float64
 	Attributes     map[string]string
+	Batch          *IngestBatch // Nil unless the collector tagged the request
 }
 
 // TimeWindow represents a time range for queries
@@ -189,13 +129,15 @@ type Session struct {
 	StartTime      time.Time
 	EndTime        time.Time
 
-	// Environment info
+	// Environment info, the latest value seen for each field
 	ClientName    string
 	ClientVersion string
 	TerminalType  string
 	HostArch      string
 	OSType        string
 	OSVersion     string
+	EnvChanged    bool      // A field reported a different value mid-session
+	envSeenAt     time.Time // Timestamp of the latest record carrying environment info
 
 	// Summary stats
 	TotalCostUSD             float64
@@ -204,10 +146,27 @@ type Session struct {
 	TotalCacheReadTokens     int64
 	TotalCacheCreationTokens int64
 	ToolCallCount            int
+	ToolSuccessCount         int
+	ToolFailureCount         int
 	APIRequestCount          int
 	APIErrorCount            int
+	APIErrorRate             float64 // Errors over requests plus errors
 	UserPromptCount          int
 	TotalAPILatencyMS        float64
+	TotalActiveTimeSeconds   float64 // Reported by the active time metric, or estimated
+	ActiveTimeEstimated      bool    // Estimated from api_request timestamps
+
+	// Models
+	FirstModel   string
+	PrimaryModel string // Most requests, ties broken by cost
+
+	// Conversation turns
+	TurnCount        int
+	AvgTokensPerTurn float64 // Input plus output tokens
+
+	// Active time split by the configured business hours
+	BusinessSeconds   float64
+	AfterHoursSeconds float64
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -224,6 +183,9 @@ type SessionModel struct {
 	CacheReadTokens     int64
 	CacheCreationTokens int64
 	TotalLatencyMS      float64
+	P50LatencyMS        float64
+	P95LatencyMS        float64
+	P99LatencyMS        float64
 }
 
 // SessionTool represents per-tool statistics within a session
@@ -234,6 +196,11 @@ type SessionTool struct {
 	SuccessCount         int
 	FailureCount         int
 	TotalExecutionTimeMS float64
+	MinExecutionTimeMS   float64
+	MaxExecutionTimeMS   float64
+	P50ExecutionTimeMS   float64
+	P95ExecutionTimeMS   float64
+	P99ExecutionTimeMS   float64
 
 	// Decision tracking
 	AutoApprovedCount    int
@@ -250,3 +217,215 @@ type SessionPrompt struct {
 	PromptLength int
 	Timestamp    time.Time
 }
+
+// PromptFrequency is how often a normalized prompt was sent
+type PromptFrequency struct {
+	PromptText string
+	Count      int
+	Sessions   int
+	LastSeen   time.Time
+}
+
+// SessionTurn is a user prompt and the API requests that follow it until the
+// next prompt. Prompted is false for a turn whose prompt was not observed
+// because the session started mid-stream.
+type SessionTurn struct {
+	SessionID    string
+	TurnIndex    int
+	StartTime    time.Time
+	EndTime      time.Time
+	Prompted     bool
+	RequestCount int
+	InputTokens  int64
+	OutputTokens int64
+	CostUSD      float64
+}
+
+// APIKeyIdentity maps an Anthropic Console API key to an otis user
+type APIKeyIdentity struct {
+	APIKey         string
+	UserID         string
+	OrganizationID string
+}
+
+// DailyUsage represents per-user, per-model usage for a single UTC day
+type DailyUsage struct {
+	Day                 string // YYYY-MM-DD
+	UserID              string
+	OrganizationID      string
+	Model               string
+	Source              string // 'console' for imported Anthropic Console data
+	InputTokens         int64
+	OutputTokens        int64
+	CacheReadTokens     int64
+	CacheCreationTokens int64
+	CostUSD             float64
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+// ImportedUsageTotals sums a user's daily usage imported from before otis.
+// Days is zero when nothing has been imported for the user.
+type ImportedUsageTotals struct {
+	Days                int
+	FirstDay            string // YYYY-MM-DD
+	LastDay             string
+	InputTokens         int64
+	OutputTokens        int64
+	CacheReadTokens     int64
+	CacheCreationTokens int64
+	CostUSD             float64
+}
+
+// DurationBucket is one bucket of a session duration histogram.
+// MaxSeconds is zero for the final, open-ended bucket.
+type DurationBucket struct {
+	Label      string
+	MinSeconds float64
+	MaxSeconds float64
+	Count      int
+}
+
+// UserCost is a user's summed session cost within an organization
+type UserCost struct {
+	UserID  string
+	CostUSD float64
+}
+
+// SessionHours is how a session's active time and cost split between
+// business and after hours
+type SessionHours struct {
+	SessionID         string
+	UserID            string
+	StartTime         time.Time
+	BusinessSeconds   float64
+	AfterHoursSeconds float64
+	CostUSD           float64
+}
+
+// SessionSpan is when a session ran and what it cost
+type SessionSpan struct {
+	SessionID string
+	StartTime time.Time
+	EndTime   time.Time
+	CostUSD   float64
+}
+
+// UserActivity is when a user last had a session in an organization
+type UserActivity struct {
+	UserID     string
+	LastActive time.Time
+}
+
+// ServiceUsage is the summed usage of the sessions reported by one service,
+// taken from the OpenTelemetry service.name resource attribute
+type ServiceUsage struct {
+	ServiceName         string
+	Sessions            int
+	CostUSD             float64
+	InputTokens         int64
+	OutputTokens        int64
+	CacheReadTokens     int64
+	CacheCreationTokens int64
+}
+
+// ModelCacheUsage is the cache token usage of one model summed over a set of
+// sessions
+type ModelCacheUsage struct {
+	Model               string
+	CacheCreationTokens int64
+	CacheReadTokens     int64
+}
+
+// CostRank places a user's cost among the other users in their organization.
+// Tied users share a rank, and Percentile is the share of users at or below
+// the user's cost.
+type CostRank struct {
+	Rank         int
+	Users        int
+	Percentile   float64
+	CostUSD      float64
+	OrgMedianUSD float64
+}
+
+// ExportJob is a background CSV export of the sessions matching its filters.
+// WindowStart and WindowEnd are Unix second bounds, as from windowBounds.
+type ExportJob struct {
+	JobID          string
+	Status         string // 'pending', 'running', 'completed', 'failed'
+	OrganizationID string
+	UserID         string
+	WindowType     string
+	WindowStart    int64
+	WindowEnd      int64
+	FilePath       string
+	SizeBytes      int64
+	RowCount       int
+	Error          string
+	CreatedAt      time.Time
+	CompletedAt    time.Time // Zero until the job finishes
+	ExpiresAt      time.Time // Zero until the job finishes
+}
+
+// SessionFileRange is the byte range [StartOffset, EndOffset) of one JSONL
+// file generation that holds a session's lines, possibly among other
+// sessions' lines
+type SessionFileRange struct {
+	SessionID   string
+	FileName    string
+	Inode       uint64
+	StartOffset int64
+	EndOffset   int64
+}
+
+// SessionTrace is an OTLP trace seen for a session, with the start times of
+// its first and last spans
+type SessionTrace struct {
+	SessionID string
+	TraceID   string // Lowercase hex
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// IngestBatch identifies the OTLP export request a record arrived in, with
+// the W3C trace context the exporter sent, when the collector tags batches
+type IngestBatch struct {
+	ID          string
+	TraceParent string
+	TraceState  string
+}
+
+// SessionBatch counts the records a tagged ingest batch contributed to a
+// session
+type SessionBatch struct {
+	SessionID   string
+	BatchID     string
+	TraceParent string
+	TraceState  string
+	FirstSeen   time.Time // Timestamp of the batch's earliest record for the session
+	MetricCount int
+	LogCount    int
+	SpanCount   int
+}
+
+// SessionRollup is a session's usage within one rollup interval, the
+// granularity time series are built from
+type SessionRollup struct {
+	SessionID           string
+	OrganizationID      string
+	UserID              string
+	BucketStart         time.Time
+	CostUSD             float64
+	InputTokens         int64
+	OutputTokens        int64
+	CacheReadTokens     int64
+	CacheCreationTokens int64
+	APIRequestCount     int
+	ToolCallCount       int
+}
+
+// TimeseriesPoint is a metric's total over one time series bucket
+type TimeseriesPoint struct {
+	BucketStart time.Time
+	Value       float64
+}
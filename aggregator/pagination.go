@@ -0,0 +1,95 @@
+package aggregator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionCursor marks where a page of sessions, newest first, ended. The
+// next page holds the sessions started before StartTime, or at StartTime
+// with a lower session ID, so sessions sharing a start second are neither
+// skipped nor repeated. Without a SessionID it covers every session started
+// at StartTime.
+type SessionCursor struct {
+	StartTime time.Time
+	SessionID string
+}
+
+// String encodes the cursor as the opaque next_cursor value
+func (c *SessionCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", c.StartTime.Unix(), c.SessionID)))
+}
+
+// ParseSessionCursor reads a before parameter: an RFC3339 or Unix seconds
+// start time, or a next_cursor value. An empty value means the first page.
+func ParseSessionCursor(value string) (*SessionCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &SessionCursor{StartTime: t}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return &SessionCursor{StartTime: time.Unix(seconds, 0)}, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid before %q", value)
+	}
+	secondsStr, sessionID, ok := strings.Cut(string(decoded), ":")
+	seconds, err := strconv.ParseInt(secondsStr, 10, 64)
+	if !ok || err != nil || sessionID == "" {
+		return nil, fmt.Errorf("invalid before %q", value)
+	}
+	return &SessionCursor{StartTime: time.Unix(seconds, 0), SessionID: sessionID}, nil
+}
+
+// sessionCursorCondition is the WHERE clause condition selecting the
+// sessions after the cursor, with its args; a nil cursor selects them all
+func sessionCursorCondition(cursor *SessionCursor) (string, []interface{}) {
+	switch {
+	case cursor == nil:
+		return "1 = 1", nil
+	case cursor.SessionID == "":
+		return "start_time < ?", []interface{}{cursor.StartTime.Unix()}
+	default:
+		return "(start_time < ? OR (start_time = ? AND session_id < ?))",
+			[]interface{}{cursor.StartTime.Unix(), cursor.StartTime.Unix(), cursor.SessionID}
+	}
+}
+
+// fetchLimit is how many sessions to fetch for a page of limit, one more to
+// tell whether there is a next page; a negative limit fetches them all
+func fetchLimit(limit int) int {
+	if limit < 0 {
+		return limit
+	}
+	return limit + 1
+}
+
+// pageSessions trims sessions fetched with one extra row down to limit,
+// returning the cursor for the next page when the extra row shows there is
+// one
+func pageSessions(sessions []*Session, limit int) ([]*Session, *SessionCursor) {
+	if limit < 0 || len(sessions) <= limit {
+		return sessions, nil
+	}
+	sessions = sessions[:limit]
+	if limit == 0 {
+		return sessions, nil
+	}
+	last := sessions[limit-1]
+	return sessions, &SessionCursor{StartTime: last.StartTime, SessionID: last.SessionID}
+}
+
+// jsonCursor renders the next page's cursor, or null on the last page
+func jsonCursor(cursor *SessionCursor) interface{} {
+	if cursor == nil {
+		return nil
+	}
+	return cursor.String()
+}
@@ -0,0 +1,108 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUserStatsPagination(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_pagination.db")
+	start := time.Now().Truncate(time.Second).Add(-time.Hour)
+
+	// Pairs of sessions share a start second, so pages must split ties
+	for i := 0; i < 7; i++ {
+		spec := SessionSpec{
+			SessionID:      fmt.Sprintf("s%d", i),
+			UserID:         "u1",
+			OrganizationID: "o1",
+			StartTime:      start.Add(-time.Duration(i/2) * time.Minute),
+		}
+		if err := SeedSession(server.store, spec); err != nil {
+			t.Fatalf("Failed to seed %s: %v", spec.SessionID, err)
+		}
+	}
+
+	get := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var body map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+
+	for _, base := range []string{"/api/stats/user/u1", "/api/stats/org/o1"} {
+		seen := make(map[string]bool)
+		pages := 0
+		before := ""
+		for {
+			code, body := get(base + "?limit=2&before=" + url.QueryEscape(before))
+			if code != http.StatusOK {
+				t.Fatalf("%s: expected status 200, got %d", base, code)
+			}
+			pages++
+			for _, session := range body["sessions"].([]interface{}) {
+				seen[session.(map[string]interface{})["session_id"].(string)] = true
+			}
+			next, ok := body["next_cursor"]
+			if !ok {
+				t.Fatalf("%s: expected a next_cursor field", base)
+			}
+			if next == nil {
+				break
+			}
+			before = next.(string)
+		}
+		if pages != 4 || len(seen) != 7 {
+			t.Errorf("%s: expected 7 distinct sessions over 4 pages, got %d over %d", base, len(seen), pages)
+		}
+	}
+
+	// A start time lists the sessions started before it: the 3 sessions
+	// from two and three minutes earlier
+	code, body := get("/api/stats/user/u1?limit=10&before=" + url.QueryEscape(start.Add(-time.Minute).Format(time.RFC3339)))
+	if total, _ := field(body, "summary", "total_sessions"); code != http.StatusOK || total != float64(3) || body["next_cursor"] != nil {
+		t.Errorf("Expected the 3 earlier sessions on one page, got %d: %v", code, body)
+	}
+
+	if code, _ := get("/api/stats/user/u1?before=not-a-cursor"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid cursor, got %d", code)
+	}
+}
+
+func TestGetSessionsByUserCursor(t *testing.T) {
+	dbPath := "./test_sessions_cursor.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	start := time.Now().Truncate(time.Second)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := SeedSession(store, SessionSpec{SessionID: id, UserID: "u1", StartTime: start}); err != nil {
+			t.Fatalf("Failed to seed %s: %v", id, err)
+		}
+	}
+
+	// Ties on start time are ordered by session ID, highest first
+	sessions, err := store.GetSessionsByUser("u1", &SessionCursor{StartTime: start, SessionID: "c"}, 10)
+	if err != nil {
+		t.Fatalf("Failed to get sessions: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].SessionID != "b" || sessions[1].SessionID != "a" {
+		t.Errorf("Expected b then a after c, got %v", sessions)
+	}
+
+	cursor, err := ParseSessionCursor((&SessionCursor{StartTime: start, SessionID: "b"}).String())
+	if err != nil || !cursor.StartTime.Equal(start) || cursor.SessionID != "b" {
+		t.Errorf("Expected the cursor to round-trip, got %+v (err %v)", cursor, err)
+	}
+}
@@ -0,0 +1,165 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// costMismatchTolerance is the relative difference between computed and
+// reported cost beyond which a breakdown is flagged
+const costMismatchTolerance = 0.01
+
+// ModelPricing holds per-million-token rates in USD
+type ModelPricing struct {
+	InputPerMTok         float64 `json:"input"`
+	OutputPerMTok        float64 `json:"output"`
+	CacheCreationPerMTok float64 `json:"cache_creation"`
+	CacheReadPerMTok     float64 `json:"cache_read"`
+}
+
+// Pricing maps a model name, or a model name prefix, to its rates
+type Pricing map[string]ModelPricing
+
+// CostBreakdown splits a model's cost into its token components and
+// reconciles the computed total against the reported cost
+type CostBreakdown struct {
+	InputUSD         float64
+	OutputUSD        float64
+	CacheCreationUSD float64
+	CacheReadUSD     float64
+	ComputedUSD      float64
+	ReportedUSD      float64
+	DifferenceUSD    float64 // Computed minus reported
+	Mismatch         bool
+}
+
+// LoadPricing reads a JSON pricing file of the form
+// {"claude-sonnet-4-5": {"input": 3, "output": 15, "cache_creation": 3.75, "cache_read": 0.3}}
+func LoadPricing(path string) (Pricing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+
+	var pricing Pricing
+	if err := json.Unmarshal(data, &pricing); err != nil {
+		return nil, fmt.Errorf("failed to parse pricing file %s: %w", path, err)
+	}
+	return pricing, nil
+}
+
+// Lookup finds the rates for a model. An exact match wins, otherwise the
+// longest configured prefix, so "claude-sonnet-4-5" prices dated variants.
+func (p Pricing) Lookup(model string) (ModelPricing, bool) {
+	if rates, ok := p[model]; ok {
+		return rates, true
+	}
+
+	var best string
+	for name := range p {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPricing{}, false
+	}
+	return p[best], true
+}
+
+// Breakdown computes a model's cost components from its token counts. It
+// returns false when the model has no configured pricing.
+func (p Pricing) Breakdown(model string, input, output, cacheCreation, cacheRead int64, reportedUSD float64) (CostBreakdown, bool) {
+	rates, ok := p.Lookup(model)
+	if !ok {
+		return CostBreakdown{}, false
+	}
+
+	b := CostBreakdown{
+		InputUSD:         float64(input) * rates.InputPerMTok / 1e6,
+		OutputUSD:        float64(output) * rates.OutputPerMTok / 1e6,
+		CacheCreationUSD: float64(cacheCreation) * rates.CacheCreationPerMTok / 1e6,
+		CacheReadUSD:     float64(cacheRead) * rates.CacheReadPerMTok / 1e6,
+		ReportedUSD:      reportedUSD,
+	}
+	b.ComputedUSD = b.InputUSD + b.OutputUSD + b.CacheCreationUSD + b.CacheReadUSD
+	b.DifferenceUSD = b.ComputedUSD - reportedUSD
+	b.Mismatch = math.Abs(b.DifferenceUSD) > costMismatchTolerance*math.Max(reportedUSD, b.ComputedUSD)
+	return b, true
+}
+
+// buildCostBreakdownResponse renders a breakdown for the per-model endpoints
+func buildCostBreakdownResponse(b CostBreakdown) map[string]interface{} {
+	return map[string]interface{}{
+		"input_usd":          b.InputUSD,
+		"output_usd":         b.OutputUSD,
+		"cache_creation_usd": b.CacheCreationUSD,
+		"cache_read_usd":     b.CacheReadUSD,
+		"computed_usd":       b.ComputedUSD,
+		"reported_usd":       b.ReportedUSD,
+		"difference_usd":     b.DifferenceUSD,
+		"mismatch":           b.Mismatch,
+	}
+}
+
+// CacheTradeoff weighs what prompt caching cost against what it saved,
+// relative to sending the same tokens as uncached input
+type CacheTradeoff struct {
+	CacheCreationTokens int64
+	CacheReadTokens     int64
+	CacheCreationUSD    float64 // Paid for cache writes
+	CreationPremiumUSD  float64 // Paid for cache writes over the input rate
+	CacheReadUSD        float64 // Paid for cache reads
+	ReadSavingsUSD      float64 // Saved by cache reads under the input rate
+	NetSavingsUSD       float64 // Read savings minus the creation premium
+}
+
+// CacheTradeoff prices a model's cache tokens. It returns false when the
+// model has no configured pricing.
+func (p Pricing) CacheTradeoff(model string, cacheCreation, cacheRead int64) (CacheTradeoff, bool) {
+	rates, ok := p.Lookup(model)
+	if !ok {
+		return CacheTradeoff{}, false
+	}
+
+	t := CacheTradeoff{
+		CacheCreationTokens: cacheCreation,
+		CacheReadTokens:     cacheRead,
+		CacheCreationUSD:    float64(cacheCreation) * rates.CacheCreationPerMTok / 1e6,
+		CreationPremiumUSD:  float64(cacheCreation) * (rates.CacheCreationPerMTok - rates.InputPerMTok) / 1e6,
+		CacheReadUSD:        float64(cacheRead) * rates.CacheReadPerMTok / 1e6,
+		ReadSavingsUSD:      float64(cacheRead) * (rates.InputPerMTok - rates.CacheReadPerMTok) / 1e6,
+	}
+	t.NetSavingsUSD = t.ReadSavingsUSD - t.CreationPremiumUSD
+	return t, true
+}
+
+// add sums another tradeoff into t
+func (t *CacheTradeoff) add(o CacheTradeoff) {
+	t.CacheCreationTokens += o.CacheCreationTokens
+	t.CacheReadTokens += o.CacheReadTokens
+	t.CacheCreationUSD += o.CacheCreationUSD
+	t.CreationPremiumUSD += o.CreationPremiumUSD
+	t.CacheReadUSD += o.CacheReadUSD
+	t.ReadSavingsUSD += o.ReadSavingsUSD
+	t.NetSavingsUSD += o.NetSavingsUSD
+}
+
+// buildCacheTradeoffResponse renders a tradeoff for the cache analysis endpoint
+func buildCacheTradeoffResponse(t CacheTradeoff) map[string]interface{} {
+	return map[string]interface{}{
+		"tokens": map[string]interface{}{
+			"cache_creation": t.CacheCreationTokens,
+			"cache_read":     t.CacheReadTokens,
+		},
+		"cache_creation_usd":   t.CacheCreationUSD,
+		"creation_premium_usd": t.CreationPremiumUSD,
+		"cache_read_usd":       t.CacheReadUSD,
+		"read_savings_usd":     t.ReadSavingsUSD,
+		"net_savings_usd":      t.NetSavingsUSD,
+		"net_positive":         t.NetSavingsUSD > 0,
+	}
+}
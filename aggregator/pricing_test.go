@@ -0,0 +1,229 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testPricing = Pricing{
+	"claude-sonnet-4-5": {InputPerMTok: 3, OutputPerMTok: 15, CacheCreationPerMTok: 3.75, CacheReadPerMTok: 0.3},
+	"claude-haiku-4":    {InputPerMTok: 1, OutputPerMTok: 5, CacheCreationPerMTok: 1.25, CacheReadPerMTok: 0.1},
+}
+
+func TestPricingBreakdownComponentsSumToTotal(t *testing.T) {
+	// 0.003 + 0.0075 + 0.0075 + 0.006 = 0.024
+	b, ok := testPricing.Breakdown("claude-sonnet-4-5", 1000, 500, 2000, 20000, 0.024)
+	if !ok {
+		t.Fatal("Expected pricing for claude-sonnet-4-5")
+	}
+
+	components := map[string][2]float64{
+		"input":          {b.InputUSD, 0.003},
+		"output":         {b.OutputUSD, 0.0075},
+		"cache_creation": {b.CacheCreationUSD, 0.0075},
+		"cache_read":     {b.CacheReadUSD, 0.006},
+	}
+	for name, c := range components {
+		if math.Abs(c[0]-c[1]) > 1e-12 {
+			t.Errorf("Expected %s cost %v, got %v", name, c[1], c[0])
+		}
+	}
+
+	sum := b.InputUSD + b.OutputUSD + b.CacheCreationUSD + b.CacheReadUSD
+	if math.Abs(sum-b.ComputedUSD) > 1e-12 || math.Abs(sum-0.024) > 1e-12 {
+		t.Errorf("Expected components to sum to 0.024, got %v (computed %v)", sum, b.ComputedUSD)
+	}
+	if b.Mismatch {
+		t.Errorf("Expected no mismatch, difference %v", b.DifferenceUSD)
+	}
+}
+
+func TestPricingBreakdownFlagsMismatch(t *testing.T) {
+	b, ok := testPricing.Breakdown("claude-haiku-4", 1000000, 0, 0, 0, 2.0)
+	if !ok {
+		t.Fatal("Expected pricing for claude-haiku-4")
+	}
+	if !b.Mismatch {
+		t.Error("Expected a $1 computed vs $2 reported cost to be flagged")
+	}
+	if b.DifferenceUSD != -1.0 {
+		t.Errorf("Expected difference -1.0, got %v", b.DifferenceUSD)
+	}
+}
+
+func TestPricingLookup(t *testing.T) {
+	if _, ok := testPricing.Lookup("claude-sonnet-4-5-20250929"); !ok {
+		t.Error("Expected a dated model to match its prefix")
+	}
+	if _, ok := testPricing.Lookup("gpt-4"); ok {
+		t.Error("Expected no pricing for an unknown model")
+	}
+	if _, ok := Pricing(nil).Breakdown("claude-haiku-4", 1, 1, 1, 1, 0); ok {
+		t.Error("Expected no breakdown without pricing")
+	}
+}
+
+func TestLoadPricing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	data := `{"claude-opus-4-5": {"input": 5, "output": 25, "cache_creation": 6.25, "cache_read": 0.5}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write pricing file: %v", err)
+	}
+
+	pricing, err := LoadPricing(path)
+	if err != nil {
+		t.Fatalf("Failed to load pricing: %v", err)
+	}
+	if rates := pricing["claude-opus-4-5"]; rates.OutputPerMTok != 25 || rates.CacheReadPerMTok != 0.5 {
+		t.Errorf("Unexpected rates: %+v", rates)
+	}
+}
+
+func TestSessionModelsIncludesCostBreakdown(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_pricing.db")
+	server.SetPricing(testPricing)
+
+	for _, sm := range []*SessionModel{
+		{SessionID: "priced-session", Model: "claude-sonnet-4-5", CostUSD: 0.024, InputTokens: 1000, OutputTokens: 500, CacheCreationTokens: 2000, CacheReadTokens: 20000},
+		{SessionID: "priced-session", Model: "unpriced-model", CostUSD: 0.01, InputTokens: 1000},
+	} {
+		if err := server.store.UpsertSessionModel(sm); err != nil {
+			t.Fatalf("Failed to seed model stats: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleSessionStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/session/priced-session/models", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var body struct {
+		Models []struct {
+			Model         string                 `json:"model"`
+			CostBreakdown map[string]interface{} `json:"cost_breakdown"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	for _, m := range body.Models {
+		switch m.Model {
+		case "claude-sonnet-4-5":
+			if m.CostBreakdown == nil {
+				t.Fatal("Expected a cost breakdown for the priced model")
+			}
+			if m.CostBreakdown["mismatch"] != false {
+				t.Errorf("Expected no mismatch, got %v", m.CostBreakdown)
+			}
+		case "unpriced-model":
+			if m.CostBreakdown != nil {
+				t.Errorf("Expected no breakdown for an unpriced model, got %v", m.CostBreakdown)
+			}
+		}
+	}
+}
+
+func TestOrgCacheAnalysisNetSavings(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_cache_analysis.db")
+
+	rec := httptest.NewRecorder()
+	server.handleOrgStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/org/org-cache/cache-analysis", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without pricing, got %d", rec.Code)
+	}
+	server.SetPricing(testPricing)
+
+	now := time.Now()
+	for _, spec := range []SessionSpec{
+		{SessionID: "cache-recent", OrganizationID: "org-cache", StartTime: now.Add(-time.Hour), Models: []ModelSpec{
+			// Sonnet: writes 1M at $3.75 (premium $0.75), reads 10M at $0.30 (saving $27)
+			{Model: "claude-sonnet-4-5", CacheCreationTokens: 600000, CacheReadTokens: 4000000},
+			{Model: "unpriced-model", CacheCreationTokens: 500, CacheReadTokens: 700},
+		}},
+		{SessionID: "cache-older", OrganizationID: "org-cache", StartTime: now.AddDate(0, 0, -10), Models: []ModelSpec{
+			{Model: "claude-sonnet-4-5-20250929", CacheCreationTokens: 400000, CacheReadTokens: 6000000},
+			// Haiku: writes 4M at $1.25 (premium $1) and never reads them back
+			{Model: "claude-haiku-4", CacheCreationTokens: 4000000},
+		}},
+		{SessionID: "cache-stale", OrganizationID: "org-cache", StartTime: now.AddDate(0, 0, -60), Models: []ModelSpec{
+			{Model: "claude-sonnet-4-5", CacheCreationTokens: 9000000},
+		}},
+		{SessionID: "cache-other-org", OrganizationID: "org-other", StartTime: now.Add(-time.Hour), Models: []ModelSpec{
+			{Model: "claude-sonnet-4-5", CacheCreationTokens: 9000000},
+		}},
+	} {
+		if err := SeedSession(server.store, spec); err != nil {
+			t.Fatalf("Failed to seed session: %v", err)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	server.handleOrgStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/org/org-cache/cache-analysis?window=30d", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var body struct {
+		Tokens             map[string]int64 `json:"tokens"`
+		CacheCreationUSD   float64          `json:"cache_creation_usd"`
+		CreationPremiumUSD float64          `json:"creation_premium_usd"`
+		CacheReadUSD       float64          `json:"cache_read_usd"`
+		ReadSavingsUSD     float64          `json:"read_savings_usd"`
+		NetSavingsUSD      float64          `json:"net_savings_usd"`
+		NetPositive        bool             `json:"net_positive"`
+		Models             []struct {
+			Model         string  `json:"model"`
+			NetSavingsUSD float64 `json:"net_savings_usd"`
+		} `json:"models"`
+		UnpricedModels []struct {
+			Model string `json:"model"`
+		} `json:"unpriced_models"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if body.Tokens["cache_creation"] != 5000000 || body.Tokens["cache_read"] != 10000000 {
+		t.Errorf("Expected 5M cache creation and 10M cache read tokens, got %v", body.Tokens)
+	}
+	for name, c := range map[string][2]float64{
+		"cache_creation_usd":   {body.CacheCreationUSD, 8.75},
+		"creation_premium_usd": {body.CreationPremiumUSD, 1.75},
+		"cache_read_usd":       {body.CacheReadUSD, 3.0},
+		"read_savings_usd":     {body.ReadSavingsUSD, 27.0},
+		"net_savings_usd":      {body.NetSavingsUSD, 25.25},
+	} {
+		if math.Abs(c[0]-c[1]) > 1e-9 {
+			t.Errorf("Expected %s %v, got %v", name, c[1], c[0])
+		}
+	}
+	if !body.NetPositive {
+		t.Error("Expected caching to be net positive")
+	}
+
+	// Dated variants are reported under their own names
+	nets := map[string]float64{}
+	for _, m := range body.Models {
+		nets[m.Model] = m.NetSavingsUSD
+	}
+	expected := map[string]float64{"claude-haiku-4": -1.0, "claude-sonnet-4-5": 10.8 - 0.45, "claude-sonnet-4-5-20250929": 16.2 - 0.3}
+	if len(nets) != len(expected) {
+		t.Errorf("Expected models %v, got %v", expected, nets)
+	}
+	for model, want := range expected {
+		if math.Abs(nets[model]-want) > 1e-9 {
+			t.Errorf("Expected %s net savings %v, got %v", model, want, nets[model])
+		}
+	}
+	if len(body.UnpricedModels) != 1 || body.UnpricedModels[0].Model != "unpriced-model" {
+		t.Errorf("Expected unpriced-model listed as unpriced, got %v", body.UnpricedModels)
+	}
+}
@@ -2,51 +2,253 @@ package aggregator
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
+// Signal is the kind of OTLP data a JSONL file holds
+type Signal int
+
+const (
+	SignalMetrics Signal = iota
+	SignalLogs
+	SignalTraces
+)
+
+// InputFiles names the collector's JSONL file for each signal
+type InputFiles struct {
+	Metrics string
+	Logs    string
+	Traces  string
+
+	// ByOrg also reads each organization's files from <org>/<name> under
+	// the data directory, as the collector writes them when partitioning
+	// output by organization. Their processing state and other per-file
+	// records are kept under that relative path.
+	ByOrg bool
+}
+
+// DefaultInputFiles are the file names the collector writes by default
+var DefaultInputFiles = InputFiles{Metrics: "metrics.jsonl", Logs: "logs.jsonl", Traces: "traces.jsonl"}
+
+// names lists the files in processing order
+func (f InputFiles) names() []string {
+	return []string{f.Metrics, f.Logs, f.Traces}
+}
+
+// signal returns the signal held by the named file, which may be an
+// organization's <org>/<name> when f is partitioned by organization
+func (f InputFiles) signal(filename string) (Signal, bool) {
+	if org, name, found := strings.Cut(filename, "/"); found {
+		if !f.ByOrg || !orgDirPattern.MatchString(org) {
+			return 0, false
+		}
+		filename = name
+	}
+	switch filename {
+	case f.Metrics:
+		return SignalMetrics, true
+	case f.Logs:
+		return SignalLogs, true
+	case f.Traces:
+		return SignalTraces, true
+	}
+	return 0, false
+}
+
+// orgDirPattern matches the organization directories the collector writes
+// partitioned output to, the same pattern it uses to name them
+var orgDirPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// paths lists the files to process under dataDir, relative to it: the
+// shared files, then each organization's in directory order when f is
+// partitioned by organization. An organization's file is listed once it or
+// one of its rotated generations exists.
+func (f InputFiles) paths(dataDir string) []string {
+	paths := f.names()
+	if !f.ByOrg {
+		return paths
+	}
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error listing organization directories in %s: %v", dataDir, err)
+		}
+		return paths
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !orgDirPattern.MatchString(entry.Name()) {
+			continue
+		}
+		for _, name := range f.names() {
+			matches, _ := filepath.Glob(filepath.Join(dataDir, entry.Name(), name) + "*")
+			if len(matches) > 0 {
+				paths = append(paths, entry.Name()+"/"+name)
+			}
+		}
+	}
+	return paths
+}
+
+// relativeName returns filePath relative to the data directory, the name its
+// processing state is kept under
+func (p *Processor) relativeName(filePath string) string {
+	rel, err := filepath.Rel(p.dataDir, filePath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.Base(filePath)
+	}
+	return filepath.ToSlash(rel)
+}
+
 type Processor struct {
 	dataDir  string
+	files    InputFiles
 	store    *Store
 	engine   *Engine
 	interval time.Duration
 	stopChan chan bool
+	running  sync.WaitGroup // The monitoring goroutine, which Stop waits for
+
+	// directIngest follows the files without processing them
+	directIngest bool
+
+	// sessionIndex records session byte ranges in session_file_ranges
+	sessionIndex bool
+
+	// watchMode is WatchPoll or WatchNotify
+	watchMode string
+
+	// throttle slows processing of large backlogs; nil runs at full speed
+	throttle *catchUpThrottle
+
+	// maxLineBytes is the longest line processed; longer ones are skipped
+	maxLineBytes int
+
+	// workers bounds the files processed at once and the goroutines
+	// decoding each file's lines
+	workers int
+
+	// retention deletes old, fully processed rotated files; nil keeps them
+	retention *rawRetention
 }
 
-// NewProcessor creates a new file processor
-func NewProcessor(dataDir string, store *Store, engine *Engine, intervalSeconds int) *Processor {
+// NewProcessor creates a new file processor for the given files in dataDir
+func NewProcessor(dataDir string, files InputFiles, store *Store, engine *Engine, intervalSeconds int) *Processor {
 	return &Processor{
-		dataDir:  dataDir,
-		store:    store,
-		engine:   engine,
-		interval: time.Duration(intervalSeconds) * time.Second,
-		stopChan: make(chan bool),
+		dataDir:      dataDir,
+		files:        files,
+		store:        store,
+		engine:       engine,
+		interval:     time.Duration(intervalSeconds) * time.Second,
+		stopChan:     make(chan bool),
+		watchMode:    WatchPoll,
+		maxLineBytes: DefaultMaxLineBytes,
+		workers:      DefaultProcessingWorkers,
 	}
 }
 
+// DefaultMaxLineBytes is the longest JSONL line processed by default, the
+// size of the largest request the collector accepts by default
+const DefaultMaxLineBytes = 16 << 20
+
+// SetMaxLineBytes sets the longest line processed, terminator included.
+// Longer lines are logged and skipped so they can't stall their file. Zero or
+// less restores DefaultMaxLineBytes.
+func (p *Processor) SetMaxLineBytes(n int) {
+	if n <= 0 {
+		n = DefaultMaxLineBytes
+	}
+	p.maxLineBytes = n
+}
+
+// SetDirectIngest is used when the collector hands data straight to the
+// engine. Lines already on disk are still processed by the first pass in
+// Start; after that the processor only advances its offsets, so switching
+// back to file processing later does not count direct-ingested lines twice.
+func (p *Processor) SetDirectIngest(enabled bool) {
+	p.directIngest = enabled
+}
+
 // Start begins monitoring and processing files
 func (p *Processor) Start() {
 	log.Println("Starting file processor...")
 
-	// Process existing data once at startup
-	p.processAllFiles()
+	// Process existing data once at startup. A throttled catch-up runs in
+	// the background instead, so it doesn't hold up the collector; direct
+	// ingestion still needs it finished before the collector starts.
+	background := p.throttle != nil && !p.directIngest
+	if !background {
+		p.processAllFiles()
+	}
+
+	// Then monitor for changes, by notification when asked and available,
+	// with polling as the fallback
+	interval := p.interval
+	var watcher *fsnotify.Watcher
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	if p.watchMode == WatchNotify {
+		var err error
+		watcher, err = p.newWatcher()
+		if err != nil {
+			log.Printf("Cannot watch %s, polling instead: %v", p.dataDir, err)
+		} else {
+			events = watcher.Events
+			watchErrors = watcher.Errors
+			if interval < watchFallbackInterval {
+				interval = watchFallbackInterval
+			}
+			log.Printf("Watching %s for changes", p.dataDir)
+		}
+	}
 
-	// Then monitor for changes
-	ticker := time.NewTicker(p.interval)
+	ticker := time.NewTicker(interval)
+	p.running.Add(1)
 	go func() {
+		defer p.running.Done()
+		if background {
+			p.processAllFiles()
+		}
 		for {
 			select {
 			case <-ticker.C:
-				p.processAllFiles()
+				if p.directIngest {
+					p.skipAllFiles()
+				} else {
+					p.processAllFiles()
+				}
+				p.sweepRetention()
+			case event, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				p.handleEvents(watcher, event, events)
+			case err, ok := <-watchErrors:
+				if !ok {
+					watchErrors = nil
+					continue
+				}
+				log.Printf("File watcher error: %v", err)
 			case <-p.stopChan:
 				ticker.Stop()
+				if watcher != nil {
+					watcher.Close()
+				}
 				log.Println("File processor stopped")
 				return
 			}
@@ -54,23 +256,216 @@ func (p *Processor) Start() {
 	}()
 }
 
-// Stop stops the file processor
+// Stop stops the file processor and waits for it to finish. A pass in
+// progress, including a throttled catch-up, ends after the line it is on and
+// saves its position, so the engine and store can be flushed and closed
+// once Stop returns.
 func (p *Processor) Stop() {
 	close(p.stopChan)
+	p.running.Wait()
 }
 
-// processAllFiles processes all JSONL files in the data directory
+// stopping reports whether Stop has been called
+func (p *Processor) stopping() bool {
+	select {
+	case <-p.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleepUnlessStopped sleeps for d, waking early when the processor stops
+func (p *Processor) sleepUnlessStopped(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-p.stopChan:
+	}
+}
+
+// processAllFiles processes all JSONL files in the data directory, up to
+// p.workers of them at once. Each file has its own processing state row, and
+// the engine serializes the records they feed it. Files not yet started
+// when the processor stops are left for the next run.
 func (p *Processor) processAllFiles() {
-	files := []string{"metrics.jsonl", "logs.jsonl", "traces.jsonl"}
+	sem := make(chan struct{}, max(1, p.workers))
+	var wg sync.WaitGroup
+	for _, filename := range p.files.paths(p.dataDir) {
+		select {
+		case sem <- struct{}{}:
+		case <-p.stopChan:
+		}
+		if p.stopping() {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			filePath := filepath.Join(p.dataDir, filename)
+			if err := p.ProcessFile(filePath); err != nil {
+				log.Printf("Error processing %s: %v", filename, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// skipAllFiles marks every JSONL file as processed up to its current size
+func (p *Processor) skipAllFiles() {
+	for _, filename := range p.files.paths(p.dataDir) {
+		p.skipFile(filename)
+	}
+}
+
+// skipFile marks a JSONL file as processed up to its last complete line,
+// counting the skipped lines so the line number stays in step with the offset
+func (p *Processor) skipFile(filename string) {
+	filePath := filepath.Join(p.dataDir, filename)
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error checking %s: %v", filename, err)
+		}
+		return
+	}
+
+	state, err := p.store.GetProcessingState(filename)
+	if err != nil {
+		log.Printf("Error getting processing state for %s: %v", filename, err)
+		return
+	}
+	currentInode := getInode(fileInfo)
+	if inodeChanged, truncated := detectRotation(state, currentInode, fileInfo.Size()); inodeChanged || truncated {
+		// The rotated generation's records were all ingested directly
+		if inodeChanged {
+			if err := p.store.MarkArchiveProcessed(filename, state.Inode); err != nil {
+				log.Printf("Error recording rotated file for %s: %v", filename, err)
+			}
+		}
+		state.LastProcessedLine = 0
+		state.LastByteOffset = 0
+	}
+	if state.LastByteOffset == fileInfo.Size() && state.Inode == currentInode {
+		return // Nothing new to skip
+	}
+
+	// A line still being written is left for the next pass, so the offset
+	// stays on a line boundary
+	lines, end, err := countLines(filePath, state.LastByteOffset, fileInfo.Size())
+	if err != nil {
+		log.Printf("Error counting lines in %s: %v", filename, err)
+		return
+	}
+	if err := p.store.UpdateProcessingState(filename, state.LastProcessedLine+lines, end, fileInfo.Size(), currentInode); err != nil {
+		log.Printf("Error updating processing state for %s: %v", filename, err)
+	}
+}
 
-	for _, filename := range files {
-		filePath := filepath.Join(p.dataDir, filename)
-		if err := p.ProcessFile(filePath); err != nil {
-			log.Printf("Error processing %s: %v", filename, err)
+// countLines counts the newline-terminated lines in filePath between the
+// start and end byte offsets, returning the offset just past the last of them
+func countLines(filePath string, start, end int64) (int64, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	var lines int64
+	lastEnd := start
+	offset := start
+	buf := make([]byte, 32*1024)
+	reader := io.NewSectionReader(file, start, end-start)
+	for {
+		n, err := reader.Read(buf)
+		if count := bytes.Count(buf[:n], []byte{'\n'}); count > 0 {
+			lines += int64(count)
+			lastEnd = offset + int64(bytes.LastIndexByte(buf[:n], '\n')) + 1
+		}
+		offset += int64(n)
+		if err == io.EOF {
+			return lines, lastEnd, nil
+		}
+		if err != nil {
+			return 0, 0, err
 		}
 	}
 }
 
+// scanRawLines is a bufio.SplitFunc like bufio.ScanLines that keeps each
+// line's terminator, \n or \r\n, so the bytes consumed can be counted exactly.
+// A final line without a terminator is returned as is.
+func scanRawLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// rawLineScanner scans lines as scanRawLines does, but discards lines longer
+// than maxBytes rather than failing with bufio.ErrTooLong, which would leave
+// the offset stuck before them. An oversized line is returned as its final
+// bytes, with the length of the rest in discarded.
+type rawLineScanner struct {
+	*bufio.Scanner
+	maxBytes  int
+	skipping  bool
+	discarded int64 // Bytes of the current line dropped before Bytes()
+}
+
+func newRawLineScanner(r io.Reader, maxBytes int) *rawLineScanner {
+	s := &rawLineScanner{Scanner: bufio.NewScanner(r), maxBytes: maxBytes}
+	s.Buffer(make([]byte, 0, min(64<<10, maxBytes)), maxBytes)
+	s.Split(s.split)
+	return s
+}
+
+// oversized reports whether the line just scanned exceeded maxBytes, and its
+// full length
+func (s *rawLineScanner) oversized() (bool, int64) {
+	return s.discarded > 0, s.discarded + int64(len(s.Bytes()))
+}
+
+func (s *rawLineScanner) split(data []byte, atEOF bool) (int, []byte, error) {
+	if !s.skipping {
+		s.discarded = 0
+		advance, token, err := scanRawLines(data, atEOF)
+		if advance > 0 || token != nil || err != nil || len(data) < s.maxBytes {
+			return advance, token, err
+		}
+		s.skipping = true // The buffer is full without a line end
+	}
+
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		s.skipping = false
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF {
+		s.skipping = false
+		return len(data), data, nil
+	}
+	s.discarded += int64(len(data))
+	return len(data), nil, nil
+}
+
+// trimLineEnd returns a raw line from scanRawLines without its terminator,
+// and whether it had one
+func trimLineEnd(raw []byte) (string, bool) {
+	line, terminated := bytes.CutSuffix(raw, []byte{'\n'})
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	return string(line), terminated
+}
+
 // ProcessFile processes new lines from a specific file
 func (p *Processor) ProcessFile(filePath string) error {
 	// Get file info
@@ -85,7 +480,7 @@ func (p *Processor) ProcessFile(filePath string) error {
 	// Get inode for rotation detection
 	currentInode := getInode(fileInfo)
 
-	filename := filepath.Base(filePath)
+	filename := p.relativeName(filePath)
 
 	// Get processing state
 	state, err := p.store.GetProcessingState(filename)
@@ -93,20 +488,42 @@ func (p *Processor) ProcessFile(filePath string) error {
 		return fmt.Errorf("failed to get processing state: %w", err)
 	}
 
-	// Detect file rotation using two methods:
-	// 1. Inode changed - file was renamed and new file created (most reliable)
-	// 2. File size < last offset - file was truncated in place (copytruncate style)
-	inodeChanged := state.Inode != 0 && currentInode != state.Inode
-	truncated := state.LastByteOffset > fileInfo.Size()
+	// Generations rotated away before the aggregator first saw the file are
+	// read ahead of it, oldest first
+	if state.Inode == 0 && state.LastByteOffset == 0 {
+		if err := p.backfillArchives(filePath); err != nil {
+			return fmt.Errorf("failed to backfill archives: %w", err)
+		}
+		if err := p.store.UpdateProcessingState(filename, 0, 0, 0, currentInode); err != nil {
+			return fmt.Errorf("failed to update processing state: %w", err)
+		}
+		state.Inode = currentInode
+	}
+
+	inodeChanged, truncated := detectRotation(state, currentInode, fileInfo.Size())
 
 	if inodeChanged || truncated {
 		if inodeChanged {
 			log.Printf("File %s was rotated (inode changed from %d to %d), resetting position",
 				filename, state.Inode, currentInode)
+			// Lines written between the last pass and the rotation live in the renamed file
+			if err := p.drainRotatedFile(filePath, state); err != nil {
+				log.Printf("Error draining rotated file for %s: %v", filename, err)
+			}
 		} else {
 			log.Printf("File %s was truncated (size %d < offset %d), resetting position",
 				filename, fileInfo.Size(), state.LastByteOffset)
 		}
+		if p.sessionIndex {
+			var truncatedInode uint64
+			if truncated && !inodeChanged {
+				truncatedInode = currentInode
+			}
+			if err := p.pruneSessionIndex(filePath, truncatedInode); err != nil {
+				log.Printf("Error pruning session index for %s: %v", filename, err)
+			}
+		}
+		state.LastProcessedLine = 0
 		state.LastByteOffset = 0
 		state.FileSizeBytes = 0
 		state.Inode = currentInode
@@ -130,80 +547,356 @@ func (p *Processor) ProcessFile(filePath string) error {
 		return fmt.Errorf("failed to seek to position %d: %w", state.LastByteOffset, err)
 	}
 
-	scanner := bufio.NewScanner(file)
+	scanner := newRawLineScanner(file, p.maxLineBytes)
 	newLinesProcessed := 0
+	currentLine := state.LastProcessedLine
 	currentOffset := state.LastByteOffset
 
-	// Process new lines (starting from where we left off)
-	for scanner.Scan() {
-		line := scanner.Text()
+	var index *sessionFileIndex
+	if p.sessionIndex {
+		index = newSessionFileIndex(filename, currentInode)
+	}
 
-		if strings.TrimSpace(line) == "" {
-			// Track offset even for empty lines
-			currentOffset += int64(len(line) + 1) // +1 for newline
-			continue
-		}
+	// Lines are read ahead in batches and decoded in parallel, then applied
+	// to the engine one by one in file order
+	batch := make([]pendingLine, 0, processBatchLines)
+	stopped := false
+	applyBatch := func() {
+		p.decodeBatch(filename, batch)
+		for i := range batch {
+			pl := &batch[i]
+			if !pl.skip {
+				p.throttle.wait(fileInfo.Size() - pl.start)
+			}
+			// Stopping leaves the rest for the next run
+			if p.stopping() {
+				stopped = true
+				break
+			}
+			currentLine++
+			currentOffset = pl.end
+			if pl.skip {
+				continue // Track position even for empty lines
+			}
 
-		if err := p.processLine(filename, line); err != nil {
-			log.Printf("Error processing line in %s at offset %d: %v", filename, currentOffset, err)
-			// Continue processing even on error
+			if err := p.applyLine(pl, index); err != nil {
+				log.Printf("Error processing line in %s at offset %d: %v", filename, pl.start, err)
+				// Continue processing even on error
+			}
+			newLinesProcessed++
+
+			// Update processing state periodically (every 100 lines)
+			if newLinesProcessed%100 == 0 {
+				if err := p.saveSessionIndex(index); err != nil {
+					log.Printf("Error updating session index: %v", err)
+				}
+				if err := p.store.UpdateProcessingState(filename, currentLine, currentOffset, fileInfo.Size(), currentInode); err != nil {
+					log.Printf("Error updating processing state: %v", err)
+				}
+			}
 		}
+		batch = batch[:0]
+	}
 
-		newLinesProcessed++
-		currentOffset += int64(len(line) + 1) // +1 for newline
+	// Process new lines (starting from where we left off). Offsets advance by
+	// the bytes actually read, terminator included, so they always land on a
+	// line boundary whether lines end in \n or \r\n.
+	readOffset := currentOffset
+	for scanner.Scan() {
+		line, terminated := trimLineEnd(scanner.Bytes())
+		if !terminated {
+			break // The collector may still be writing it; the next pass picks it up
+		}
 
-		// Update processing state periodically (every 100 lines)
-		if newLinesProcessed%100 == 0 {
-			if err := p.store.UpdateProcessingState(filename, currentOffset, fileInfo.Size(), currentInode); err != nil {
-				log.Printf("Error updating processing state: %v", err)
+		oversized, length := scanner.oversized()
+		if oversized {
+			log.Printf("Skipping line in %s at offset %d: %d bytes exceeds the %d byte line limit", filename, readOffset, length, p.maxLineBytes)
+			line = ""
+		}
+		batch = append(batch, pendingLine{
+			line:  line,
+			start: readOffset,
+			end:   readOffset + length,
+			skip:  oversized || strings.TrimSpace(line) == "",
+		})
+		readOffset += length
+
+		if len(batch) == processBatchLines {
+			applyBatch()
+			if stopped {
+				break
 			}
 		}
 	}
+	applyBatch()
 
 	if err := scanner.Err(); err != nil {
 		return fmt.Errorf("error reading file: %w", err)
 	}
 
-	// Final state update
-	if newLinesProcessed > 0 {
-		if err := p.store.UpdateProcessingState(filename, currentOffset, fileInfo.Size(), currentInode); err != nil {
+	// Final state update, also when only skipped or empty lines were read
+	if currentOffset != state.LastByteOffset {
+		if err := p.saveSessionIndex(index); err != nil {
+			log.Printf("Error updating session index: %v", err)
+		}
+		if err := p.store.UpdateProcessingState(filename, currentLine, currentOffset, fileInfo.Size(), currentInode); err != nil {
 			return fmt.Errorf("failed to update processing state: %w", err)
 		}
-		log.Printf("Processed %d new lines from %s (now at byte offset %d)", newLinesProcessed, filename, currentOffset)
+	}
+	if newLinesProcessed > 0 {
+		log.Printf("Processed %d new lines from %s (now at line %d, byte offset %d)", newLinesProcessed, filename, currentLine, currentOffset)
 	}
 
 	return nil
 }
 
-// processLine processes a single JSONL line
+// detectRotation compares a file's current inode and size with its processing
+// state, detecting rotation in two ways:
+//  1. Inode changed - file was renamed and new file created (most reliable).
+//     This also catches a rotated file that has already grown past the old offset.
+//  2. File size < last offset - file was truncated in place (copytruncate style)
+func detectRotation(state *ProcessingState, inode uint64, size int64) (inodeChanged, truncated bool) {
+	inodeChanged = state.Inode != 0 && inode != state.Inode
+	truncated = state.LastByteOffset > size
+	return inodeChanged, truncated
+}
+
+// drainRotatedFile processes the unread tail of a file that was rotated away.
+// The rotated file is found by its inode among <name>.* siblings, which covers
+// the collector's own numbered <name>.N rotation, including generations it has
+// since compressed to <name>.N.gz. Once drained it is recorded as processed,
+// which lets the retention sweep delete it. When the collector rotated more
+// than once since the last pass, the numbered generations newer than it were
+// never live to the processor, so they are then read whole, oldest first.
+func (p *Processor) drainRotatedFile(filePath string, state *ProcessingState) error {
+	candidates, err := filepath.Glob(filePath + ".*")
+	if err != nil {
+		return err
+	}
+	filename := p.relativeName(filePath)
+
+	for _, candidate := range candidates {
+		reader, found, err := openRotatedFile(candidate, state.Inode, state.LastByteOffset)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		// A nil reader was fully processed before rotation
+		if reader != nil {
+			linesProcessed, err := p.processRotatedLines(filename, candidate, reader, state.Inode, state.LastByteOffset)
+			reader.Close()
+			if err != nil {
+				return err
+			}
+			log.Printf("Processed %d remaining lines from rotated file %s", linesProcessed, candidate)
+		}
+		if err := p.store.MarkArchiveProcessed(filename, state.Inode); err != nil {
+			return err
+		}
+
+		newer, err := newerGenerations(filePath, candidate)
+		if err != nil {
+			return err
+		}
+		for _, path := range newer {
+			if err := p.processArchive(filename, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// newerGenerations lists filePath's numbered generations rotated away after
+// the generation at rotated, oldest first; none when rotated isn't numbered
+func newerGenerations(filePath, rotated string) ([]string, error) {
+	paths, err := generationsOldestFirst(filePath)
+	if err != nil {
+		return nil, err
+	}
+	archives := paths[:len(paths)-1]
+	for i, path := range archives {
+		if path == rotated {
+			return archives[i+1:], nil
+		}
+	}
+	return nil, nil
+}
+
+// processRotatedLines processes every line read from a rotated generation of
+// filename, starting at offset. The generation is complete, so a final
+// unterminated line is processed too.
+func (p *Processor) processRotatedLines(filename, source string, reader io.Reader, inode uint64, offset int64) (int, error) {
+	var index *sessionFileIndex
+	if p.sessionIndex {
+		index = newSessionFileIndex(filename, inode)
+	}
+
+	linesProcessed := 0
+	scanner := newRawLineScanner(reader, p.maxLineBytes)
+	for scanner.Scan() {
+		line, _ := trimLineEnd(scanner.Bytes())
+		oversized, length := scanner.oversized()
+		lineOffset := offset
+		offset += length
+		if oversized {
+			log.Printf("Skipping line in %s at offset %d: %d bytes exceeds the %d byte line limit", source, lineOffset, length, p.maxLineBytes)
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if err := p.processIndexedLine(filename, line, index, lineOffset, offset); err != nil {
+			log.Printf("Error processing line in %s: %v", source, err)
+		}
+		linesProcessed++
+	}
+	if err := scanner.Err(); err != nil {
+		return linesProcessed, fmt.Errorf("error reading rotated file: %w", err)
+	}
+	if err := p.saveSessionIndex(index); err != nil {
+		log.Printf("Error updating session index: %v", err)
+	}
+	return linesProcessed, nil
+}
+
+// gzipInodeComment is the gzip header comment the collector writes when it
+// compresses a rotated file, recording the original file's inode
+const gzipInodeComment = "otis-inode=%d"
+
+// openRotatedFile opens candidate if it is the rotated file with the given
+// inode, positioned at offset. Compressed candidates are matched by the inode
+// in their gzip header and read from the same uncompressed offset. The reader
+// is nil when the file is found but has nothing past offset; in-progress
+// .tmp archives are never matched.
+func openRotatedFile(candidate string, inode uint64, offset int64) (io.ReadCloser, bool, error) {
+	if strings.HasSuffix(candidate, ".tmp") {
+		return nil, false, nil
+	}
+
+	if !strings.HasSuffix(candidate, ".gz") {
+		info, err := os.Stat(candidate)
+		if err != nil || getInode(info) != inode {
+			return nil, false, nil
+		}
+		if info.Size() <= offset {
+			return nil, true, nil
+		}
+
+		file, err := os.Open(candidate)
+		if err != nil {
+			return nil, true, fmt.Errorf("failed to open rotated file: %w", err)
+		}
+		if _, err := file.Seek(offset, 0); err != nil {
+			file.Close()
+			return nil, true, fmt.Errorf("failed to seek to position %d: %w", offset, err)
+		}
+		return file, true, nil
+	}
+
+	file, err := os.Open(candidate)
+	if err != nil {
+		return nil, false, nil
+	}
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, false, nil
+	}
+	var gzInode uint64
+	if _, err := fmt.Sscanf(zr.Comment, gzipInodeComment, &gzInode); err != nil || gzInode != inode {
+		file.Close()
+		return nil, false, nil
+	}
+
+	if _, err := io.CopyN(io.Discard, zr, offset); err != nil {
+		file.Close()
+		if err == io.EOF {
+			return nil, true, nil
+		}
+		return nil, true, fmt.Errorf("failed to skip to position %d: %w", offset, err)
+	}
+	return gzipFile{Reader: zr, file: file}, true, nil
+}
+
+// gzipFile closes the underlying file along with the gzip reader
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// processLine processes a single JSONL line of the named file
 func (p *Processor) processLine(filename, line string) error {
+	signal, ok := p.files.signal(filename)
+	if !ok {
+		return fmt.Errorf("unknown file type: %s", filename)
+	}
+	return decodeLine(signal, line, p.engine)
+}
+
+// recordHandler receives the records decoded from a JSONL line; the Engine
+// is the usual one
+type recordHandler interface {
+	ProcessMetric(record *MetricRecord)
+	ProcessLog(record *LogRecord)
+	ProcessTrace(record *TraceRecord)
+}
+
+// decodeLine extracts the records of one JSONL line, by signal, into h
+func decodeLine(signal Signal, line string, h recordHandler) error {
 	var data map[string]interface{}
 	if err := json.Unmarshal([]byte(line), &data); err != nil {
 		return fmt.Errorf("failed to unmarshal line: %w", err)
 	}
 
-	// Handle legacy wrapped format: {"data": "<json string>"}
-	if dataStr, ok := data["data"].(string); ok {
-		if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
+	// Lines come in three shapes:
+	// - envelope: {"receivedAt": "...", "batch": {...}, "data": {<otlp json>}},
+	//   where the batch is only present when the collector tags batches
+	// - legacy wrapped: {"data": "<otlp json string>"}
+	// - legacy bare: {<otlp json>}
+	// Legacy lines carry no receive time, so processing time stands in for it.
+	receivedAt := time.Now()
+	switch wrapped := data["data"].(type) {
+	case map[string]interface{}:
+		if ts, ok := data["receivedAt"].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+				receivedAt = parsed
+			}
+		}
+		if batch := batchFromEnvelope(data); batch != nil {
+			h = batchTagger{recordHandler: h, batch: batch}
+		}
+		data = wrapped
+	case string:
+		if err := json.Unmarshal([]byte(wrapped), &data); err != nil {
 			return fmt.Errorf("failed to unmarshal wrapped data: %w", err)
 		}
 	}
 
-	// Route to appropriate handler based on filename
-	switch filename {
-	case "metrics.jsonl":
-		return p.processMetricData(data)
-	case "logs.jsonl":
-		return p.processLogData(data)
-	case "traces.jsonl":
-		return p.processTraceData(data)
+	// Route to appropriate handler based on signal
+	switch signal {
+	case SignalMetrics:
+		return processMetricData(data, receivedAt, h)
+	case SignalLogs:
+		return processLogData(data, receivedAt, h)
+	case SignalTraces:
+		return processTraceData(data, receivedAt, h)
 	default:
-		return fmt.Errorf("unknown file type: %s", filename)
+		return fmt.Errorf("unknown signal: %d", signal)
 	}
 }
 
 // processMetricData processes metric data
-func (p *Processor) processMetricData(data map[string]interface{}) error {
+func processMetricData(data map[string]interface{}, receivedAt time.Time, h recordHandler) error {
 	// Extract resource metrics
 	resourceMetrics, ok := data["resourceMetrics"].([]interface{})
 	if !ok {
@@ -217,7 +910,7 @@ func (p *Processor) processMetricData(data map[string]interface{}) error {
 		}
 
 		// Extract resource attributes
-		attrs := extractResourceAttributes(rmMap)
+		resourceAttrs := extractResourceAttributes(rmMap)
 
 		// Extract scope metrics
 		scopeMetrics, ok := rmMap["scopeMetrics"].([]interface{})
@@ -230,6 +923,7 @@ func (p *Processor) processMetricData(data map[string]interface{}) error {
 			if !ok {
 				continue
 			}
+			attrs := mergeAttrs(extractScopeAttributes(smMap), resourceAttrs)
 
 			// Extract metrics
 			metrics, ok := smMap["metrics"].([]interface{})
@@ -244,9 +938,9 @@ func (p *Processor) processMetricData(data map[string]interface{}) error {
 				}
 
 				// Extract all data points from this metric
-				records := extractMetricRecords(mMap, attrs)
+				records := extractMetricRecords(mMap, attrs, receivedAt)
 				for _, record := range records {
-					p.engine.ProcessMetric(record)
+					h.ProcessMetric(record)
 				}
 			}
 		}
@@ -256,7 +950,7 @@ func (p *Processor) processMetricData(data map[string]interface{}) error {
 }
 
 // processLogData processes log data
-func (p *Processor) processLogData(data map[string]interface{}) error {
+func processLogData(data map[string]interface{}, receivedAt time.Time, h recordHandler) error {
 	// Extract resource logs
 	resourceLogs, ok := data["resourceLogs"].([]interface{})
 	if !ok {
@@ -270,7 +964,7 @@ func (p *Processor) processLogData(data map[string]interface{}) error {
 		}
 
 		// Extract resource attributes
-		attrs := extractResourceAttributes(rlMap)
+		resourceAttrs := extractResourceAttributes(rlMap)
 
 		// Extract scope logs
 		scopeLogs, ok := rlMap["scopeLogs"].([]interface{})
@@ -283,6 +977,7 @@ func (p *Processor) processLogData(data map[string]interface{}) error {
 			if !ok {
 				continue
 			}
+			attrs := mergeAttrs(extractScopeAttributes(slMap), resourceAttrs)
 
 			// Extract log records
 			logRecords, ok := slMap["logRecords"].([]interface{})
@@ -296,9 +991,9 @@ func (p *Processor) processLogData(data map[string]interface{}) error {
 					continue
 				}
 
-				record := extractLogRecord(lrMap, attrs)
+				record := extractLogRecord(lrMap, attrs, receivedAt)
 				if record != nil {
-					p.engine.ProcessLog(record)
+					h.ProcessLog(record)
 				}
 			}
 		}
@@ -308,7 +1003,7 @@ func (p *Processor) processLogData(data map[string]interface{}) error {
 }
 
 // processTraceData processes trace data
-func (p *Processor) processTraceData(data map[string]interface{}) error {
+func processTraceData(data map[string]interface{}, receivedAt time.Time, h recordHandler) error {
 	// Extract resource spans
 	resourceSpans, ok := data["resourceSpans"].([]interface{})
 	if !ok {
@@ -322,7 +1017,7 @@ func (p *Processor) processTraceData(data map[string]interface{}) error {
 		}
 
 		// Extract resource attributes
-		attrs := extractResourceAttributes(rsMap)
+		resourceAttrs := extractResourceAttributes(rsMap)
 
 		// Extract scope spans
 		scopeSpans, ok := rsMap["scopeSpans"].([]interface{})
@@ -335,6 +1030,7 @@ func (p *Processor) processTraceData(data map[string]interface{}) error {
 			if !ok {
 				continue
 			}
+			attrs := mergeAttrs(extractScopeAttributes(ssMap), resourceAttrs)
 
 			// Extract spans
 			spans, ok := ssMap["spans"].([]interface{})
@@ -348,9 +1044,9 @@ func (p *Processor) processTraceData(data map[string]interface{}) error {
 					continue
 				}
 
-				record := extractTraceRecord(sMap, attrs)
+				record := extractTraceRecord(sMap, attrs, receivedAt)
 				if record != nil {
-					p.engine.ProcessTrace(record)
+					h.ProcessTrace(record)
 				}
 			}
 		}
@@ -361,15 +1057,46 @@ func (p *Processor) processTraceData(data map[string]interface{}) error {
 
 // Helper functions to extract data from OTLP structures
 
-func extractResourceAttributes(resourceMap map[string]interface{}) map[string]string {
-	attrs := make(map[string]string)
+// minValidTimestamp is the earliest record time we trust. Anything older,
+// including a zero timeUnixNano, falls back to when the collector received it.
+var minValidTimestamp = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
 
-	resource, ok := resourceMap["resource"].(map[string]interface{})
-	if !ok {
-		return attrs
+// parseTimestamp parses an OTLP nanosecond timestamp, falling back to
+// receivedAt when it is missing, zero or implausibly old
+func parseTimestamp(value interface{}, receivedAt time.Time) time.Time {
+	var nanos int64
+	if timeStr, ok := value.(string); ok {
+		fmt.Sscanf(timeStr, "%d", &nanos)
 	}
+	return timestampOrReceived(nanos, receivedAt)
+}
 
-	attributes, ok := resource["attributes"].([]interface{})
+// timestampOrReceived converts nanoseconds since the epoch, falling back to
+// receivedAt when the value is zero or implausibly old
+func timestampOrReceived(nanos int64, receivedAt time.Time) time.Time {
+	if timestamp := time.Unix(0, nanos); !timestamp.Before(minValidTimestamp) {
+		return timestamp
+	}
+	return receivedAt
+}
+
+func extractResourceAttributes(resourceMap map[string]interface{}) map[string]string {
+	resource, _ := resourceMap["resource"].(map[string]interface{})
+	return extractAttributes(resource["attributes"])
+}
+
+// extractScopeAttributes reads the instrumentation scope's attributes from a
+// scopeMetrics, scopeLogs or scopeSpans entry
+func extractScopeAttributes(scopeMap map[string]interface{}) map[string]string {
+	scope, _ := scopeMap["scope"].(map[string]interface{})
+	return extractAttributes(scope["attributes"])
+}
+
+// extractAttributes reads the string values of an OTLP attribute list
+func extractAttributes(list interface{}) map[string]string {
+	attrs := make(map[string]string)
+
+	attributes, ok := list.([]interface{})
 	if !ok {
 		return attrs
 	}
@@ -395,7 +1122,9 @@ func extractResourceAttributes(resourceMap map[string]interface{}) map[string]st
 	return attrs
 }
 
-func extractMetricRecords(metricMap map[string]interface{}, resourceAttrs map[string]string) []*MetricRecord {
+// extractMetricRecords extracts a metric's data points; enclosingAttrs are the
+// scope's and resource's attributes, already merged
+func extractMetricRecords(metricMap map[string]interface{}, enclosingAttrs map[string]string, receivedAt time.Time) []*MetricRecord {
 	name, _ := metricMap["name"].(string)
 	if name == "" {
 		return nil
@@ -413,34 +1142,12 @@ func extractMetricRecords(metricMap map[string]interface{}, resourceAttrs map[st
 					continue
 				}
 
-				var timestamp time.Time
 				var value interface{}
-				dataPointAttrs := make(map[string]string)
 
 				// Extract data point attributes (session.id, user.id, etc. are here in Claude Code metrics)
-				if attributes, ok := dp["attributes"].([]interface{}); ok {
-					for _, attr := range attributes {
-						attrMap, ok := attr.(map[string]interface{})
-						if !ok {
-							continue
-						}
-						key, _ := attrMap["key"].(string)
-						valueMap, ok := attrMap["value"].(map[string]interface{})
-						if !ok {
-							continue
-						}
-						if strValue, ok := valueMap["stringValue"].(string); ok {
-							dataPointAttrs[key] = strValue
-						}
-					}
-				}
+				dataPointAttrs := extractAttributes(dp["attributes"])
 
-				if timeStr, ok := dp["timeUnixNano"].(string); ok {
-					// Parse nanoseconds timestamp
-					var nanos int64
-					fmt.Sscanf(timeStr, "%d", &nanos)
-					timestamp = time.Unix(0, nanos)
-				}
+				timestamp := parseTimestamp(dp["timeUnixNano"], receivedAt)
 				if asInt, ok := dp["asInt"].(string); ok {
 					var intVal int64
 					fmt.Sscanf(asInt, "%d", &intVal)
@@ -449,25 +1156,7 @@ func extractMetricRecords(metricMap map[string]interface{}, resourceAttrs map[st
 					value = asDouble
 				}
 
-				// Merge resource attrs and data point attrs, with data point taking precedence
-				allAttrs := make(map[string]string)
-				for k, v := range resourceAttrs {
-					allAttrs[k] = v
-				}
-				for k, v := range dataPointAttrs {
-					allAttrs[k] = v
-				}
-
-				records = append(records, &MetricRecord{
-					Timestamp:      timestamp,
-					SessionID:      allAttrs["session.id"],
-					UserID:         allAttrs["user.id"],
-					OrganizationID: allAttrs["organization.id"],
-					ServiceName:    allAttrs["service.name"],
-					MetricName:     name,
-					MetricValue:    value,
-					Attributes:     allAttrs,
-				})
+				records = append(records, newMetricRecord(name, timestamp, value, dataPointAttrs, enclosingAttrs))
 			}
 		}
 	}
@@ -475,13 +1164,26 @@ func extractMetricRecords(metricMap map[string]interface{}, resourceAttrs map[st
 	return records
 }
 
-func extractLogRecord(logMap map[string]interface{}, resourceAttrs map[string]string) *LogRecord {
-	var timestamp time.Time
-	if timeStr, ok := logMap["timeUnixNano"].(string); ok {
-		var nanos int64
-		fmt.Sscanf(timeStr, "%d", &nanos)
-		timestamp = time.Unix(0, nanos)
+// newMetricRecord builds a metric record from its data point's attributes and
+// the enclosing scope's and resource's
+func newMetricRecord(name string, timestamp time.Time, value interface{}, dataPointAttrs, enclosingAttrs map[string]string) *MetricRecord {
+	id := ResolveIdentity(dataPointAttrs, enclosingAttrs)
+	return &MetricRecord{
+		Timestamp:      timestamp,
+		SessionID:      id.SessionID,
+		UserID:         id.UserID,
+		OrganizationID: id.OrganizationID,
+		ServiceName:    id.ServiceName,
+		MetricName:     name,
+		MetricValue:    value,
+		Attributes:     mergeAttrs(dataPointAttrs, enclosingAttrs),
 	}
+}
+
+// extractLogRecord extracts a log record; enclosingAttrs are the scope's and
+// resource's attributes, already merged
+func extractLogRecord(logMap map[string]interface{}, enclosingAttrs map[string]string, receivedAt time.Time) *LogRecord {
+	timestamp := parseTimestamp(logMap["timeUnixNano"], receivedAt)
 
 	severityText, _ := logMap["severityText"].(string)
 
@@ -511,66 +1213,70 @@ func extractLogRecord(logMap map[string]interface{}, resourceAttrs map[string]st
 		}
 	}
 
-	// Get identifiers from log attributes first, fall back to resource attributes
-	sessionID := logAttrsStr["session.id"]
-	if sessionID == "" {
-		sessionID = resourceAttrs["session.id"]
-	}
-	userID := logAttrsStr["user.id"]
-	if userID == "" {
-		userID = resourceAttrs["user.id"]
-	}
-	orgID := logAttrsStr["organization.id"]
-	if orgID == "" {
-		orgID = resourceAttrs["organization.id"]
-	}
-	serviceName := resourceAttrs["service.name"]
+	return newLogRecord(timestamp, severityText, body, logAttrs, logAttrsStr, enclosingAttrs)
+}
 
+// newLogRecord builds a log record from its own attribute values, their string
+// forms, and the enclosing scope's and resource's attributes
+func newLogRecord(timestamp time.Time, severityText, body string, logAttrs map[string]interface{}, logAttrsStr, enclosingAttrs map[string]string) *LogRecord {
+	id := ResolveIdentity(logAttrsStr, enclosingAttrs)
 	return &LogRecord{
 		Timestamp:      timestamp,
-		SessionID:      sessionID,
-		UserID:         userID,
-		OrganizationID: orgID,
-		ServiceName:    serviceName,
+		SessionID:      id.SessionID,
+		UserID:         id.UserID,
+		OrganizationID: id.OrganizationID,
+		ServiceName:    id.ServiceName,
 		SeverityText:   severityText,
 		Body:           body,
-		Attributes:     logAttrs,
+		Attributes:     mergeLogAttrs(logAttrs, logAttrsStr, enclosingAttrs),
 	}
 }
 
-func extractTraceRecord(spanMap map[string]interface{}, resourceAttrs map[string]string) *TraceRecord {
+// extractTraceRecord extracts a span; enclosingAttrs are the scope's and
+// resource's attributes, already merged
+func extractTraceRecord(spanMap map[string]interface{}, enclosingAttrs map[string]string, receivedAt time.Time) *TraceRecord {
 	name, _ := spanMap["name"].(string)
 	if name == "" {
 		return nil
 	}
 
-	var timestamp time.Time
-	var durationMS float64
-
+	var startNanos, endNanos int64
 	if startTimeStr, ok := spanMap["startTimeUnixNano"].(string); ok {
-		var nanos int64
-		fmt.Sscanf(startTimeStr, "%d", &nanos)
-		timestamp = time.Unix(0, nanos)
+		fmt.Sscanf(startTimeStr, "%d", &startNanos)
 	}
-
 	if endTimeStr, ok := spanMap["endTimeUnixNano"].(string); ok {
-		var startNanos, endNanos int64
-		if startTimeStr, ok := spanMap["startTimeUnixNano"].(string); ok {
-			fmt.Sscanf(startTimeStr, "%d", &startNanos)
-			fmt.Sscanf(endTimeStr, "%d", &endNanos)
-			durationMS = float64(endNanos-startNanos) / 1e6 // Convert to milliseconds
-		}
+		fmt.Sscanf(endTimeStr, "%d", &endNanos)
+	}
+
+	// OTLP/JSON encodes the IDs as hex, protojson as base64
+	traceID, _ := spanMap["traceId"].(string)
+	spanID, _ := spanMap["spanId"].(string)
+
+	return newTraceRecord(name, normalizeOTLPID(traceID, traceIDSize), normalizeOTLPID(spanID, spanIDSize),
+		startNanos, endNanos, extractAttributes(spanMap["attributes"]), enclosingAttrs, receivedAt)
+}
+
+// newTraceRecord builds a trace record from a span's hex IDs, its start and
+// end nanoseconds, and its own and the enclosing attributes
+func newTraceRecord(name, traceID, spanID string, startNanos, endNanos int64, spanAttrs, enclosingAttrs map[string]string, receivedAt time.Time) *TraceRecord {
+	// A missing start time would turn the duration into time since the epoch
+	var durationMS float64
+	if !time.Unix(0, startNanos).Before(minValidTimestamp) && endNanos >= startNanos {
+		durationMS = float64(endNanos-startNanos) / 1e6 // Convert to milliseconds
 	}
 
+	id := ResolveIdentity(spanAttrs, enclosingAttrs)
 	return &TraceRecord{
-		Timestamp:      timestamp,
-		SessionID:      resourceAttrs["session.id"],
-		UserID:         resourceAttrs["user.id"],
-		OrganizationID: resourceAttrs["organization.id"],
-		ServiceName:    resourceAttrs["service.name"],
+		Timestamp:      timestampOrReceived(startNanos, receivedAt),
+		SessionID:      id.SessionID,
+		UserID:         id.UserID,
+		OrganizationID: id.OrganizationID,
+		ServiceName:    id.ServiceName,
 		SpanName:       name,
+		TraceID:        traceID,
+		SpanID:         spanID,
 		DurationMS:     durationMS,
-		Attributes:     resourceAttrs,
+		Attributes:     mergeAttrs(spanAttrs, enclosingAttrs),
 	}
 }
 
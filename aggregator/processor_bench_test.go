@@ -53,7 +53,7 @@ func benchmarkProcessFile(b *testing.B, lineCount int) {
 	}
 	f.Close()
 	
-	processor := NewProcessor(testDir, store, engine, 5)
+	processor := NewProcessor(testDir, DefaultInputFiles, store, engine, 5)
 	
 	// Benchmark: Process the file multiple times
 	// This simulates the "already processed N lines, process a few new ones" scenario
@@ -1,9 +1,15 @@
 package aggregator
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/zmack/otis/collector"
 )
 
 // TestProcessLineBackwardsCompatibility tests that processLine handles both
@@ -23,7 +29,7 @@ func TestProcessLineBackwardsCompatibility(t *testing.T) {
 	defer store.Close()
 
 	engine := NewEngine(store)
-	processor := NewProcessor(dataDir, store, engine, 60)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
 
 	// Old wrapped format
 	oldFormat := `{"data":"{\"resourceMetrics\":[{\"resource\":{\"attributes\":[{\"key\":\"service.name\",\"value\":{\"stringValue\":\"test\"}}]},\"scopeMetrics\":[{\"metrics\":[{\"name\":\"test.metric\",\"sum\":{\"dataPoints\":[{\"timeUnixNano\":\"1000000000\",\"asDouble\":1.0}]}}]}]}]}"}`
@@ -57,7 +63,7 @@ func TestProcessLineRejectsInvalidJSON(t *testing.T) {
 	defer store.Close()
 
 	engine := NewEngine(store)
-	processor := NewProcessor(dataDir, store, engine, 60)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
 
 	invalidJSON := `{not valid json}`
 
@@ -66,6 +72,89 @@ func TestProcessLineRejectsInvalidJSON(t *testing.T) {
 	}
 }
 
+// TestProcessLineZeroTimestampFallsBackToReceivedAt is a regression test for
+// log records with timeUnixNano 0 creating sessions that start at the epoch.
+func TestProcessLineZeroTimestampFallsBackToReceivedAt(t *testing.T) {
+	dbPath := "./test_zero_timestamp.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+	processor := NewProcessor(t.TempDir(), DefaultInputFiles, store, engine, 60)
+
+	line := `{"receivedAt":"2025-06-01T12:00:00.5Z","data":{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"timeUnixNano":"0","body":{"stringValue":"claude_code.user_prompt"},"attributes":[{"key":"session.id","value":{"stringValue":"zero-ts-session"}},{"key":"user.id","value":{"stringValue":"user-1"}},{"key":"organization.id","value":{"stringValue":"org-1"}}]}]}]}]}}`
+	if err := processor.processLine("logs.jsonl", line); err != nil {
+		t.Fatalf("Failed to process line: %v", err)
+	}
+	engine.FlushCache()
+
+	session, err := store.GetSession("zero-ts-session")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	expected := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	if session.StartTime.Before(minValidTimestamp) {
+		t.Fatalf("Expected session to start after 2020, got %v", session.StartTime)
+	}
+	if !session.StartTime.Equal(expected) {
+		t.Errorf("Expected start time %v from receivedAt, got %v", expected, session.StartTime)
+	}
+}
+
+func TestExtractRecordsTimestampFallback(t *testing.T) {
+	receivedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	valid := time.Date(2025, 5, 31, 8, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected time.Time
+	}{
+		{"missing", nil, receivedAt},
+		{"zero", "0", receivedAt},
+		{"before 2020", "1000000000", receivedAt},
+		{"valid", "1748680200000000000", valid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := map[string]interface{}{"asDouble": 1.0}
+			logMap := map[string]interface{}{}
+			span := map[string]interface{}{"name": "span", "endTimeUnixNano": "1748680201000000000"}
+			if tt.value != nil {
+				dp["timeUnixNano"] = tt.value
+				logMap["timeUnixNano"] = tt.value
+				span["startTimeUnixNano"] = tt.value
+			}
+
+			metrics := extractMetricRecords(map[string]interface{}{
+				"name": "test.metric",
+				"sum":  map[string]interface{}{"dataPoints": []interface{}{dp}},
+			}, nil, receivedAt)
+			if len(metrics) != 1 || !metrics[0].Timestamp.Equal(tt.expected) {
+				t.Errorf("Metric: expected %v, got %+v", tt.expected, metrics)
+			}
+
+			if record := extractLogRecord(logMap, nil, receivedAt); !record.Timestamp.Equal(tt.expected) {
+				t.Errorf("Log: expected %v, got %v", tt.expected, record.Timestamp)
+			}
+
+			trace := extractTraceRecord(span, nil, receivedAt)
+			if !trace.Timestamp.Equal(tt.expected) {
+				t.Errorf("Trace: expected %v, got %v", tt.expected, trace.Timestamp)
+			}
+			if trace.DurationMS < 0 || trace.DurationMS > 1000 {
+				t.Errorf("Trace: expected a sane duration, got %vms", trace.DurationMS)
+			}
+		})
+	}
+}
+
 // TestExtractLogRecordFromLogAttributes tests that session.id, user.id, organization.id
 // are correctly extracted from log record attributes (not just resource attributes).
 // This is critical because Claude Code sends these identifiers in log attributes.
@@ -118,7 +207,7 @@ func TestExtractLogRecordFromLogAttributes(t *testing.T) {
 		"host.arch":       "arm64",
 	}
 
-	record := extractLogRecord(logMap, resourceAttrs)
+	record := extractLogRecord(logMap, resourceAttrs, time.Now())
 
 	// Verify identifiers were extracted from log attributes
 	if record.SessionID != "test-session-123" {
@@ -171,7 +260,7 @@ func TestExtractLogRecordFallbackToResourceAttrs(t *testing.T) {
 		"organization.id": "resource-org",
 	}
 
-	record := extractLogRecord(logMap, resourceAttrs)
+	record := extractLogRecord(logMap, resourceAttrs, time.Now())
 
 	// Should fall back to resource attributes
 	if record.SessionID != "resource-session" {
@@ -210,7 +299,7 @@ func TestExtractLogRecordLogAttrsTakePrecedence(t *testing.T) {
 		"session.id":   "resource-session", // Should be overridden
 	}
 
-	record := extractLogRecord(logMap, resourceAttrs)
+	record := extractLogRecord(logMap, resourceAttrs, time.Now())
 
 	// Log attributes should take precedence
 	if record.SessionID != "log-session" {
@@ -250,7 +339,7 @@ func TestRotationDetectionByInode(t *testing.T) {
 	inode1 := getInode(info1)
 
 	// Simulate having processed the file
-	store.UpdateProcessingState("test.jsonl", 100, 100, inode1)
+	store.UpdateProcessingState("test.jsonl", 0, 100, 100, inode1)
 
 	// Simulate rotation: rename old file, create new file
 	os.Rename(testFile, testFile+".1")
@@ -280,7 +369,7 @@ func TestRotationDetectionByInode(t *testing.T) {
 	}
 
 	// The rotation detection logic should detect inode changed
-	inodeChanged := state.Inode != 0 && inode2 != state.Inode
+	inodeChanged, _ := detectRotation(state, inode2, info2.Size())
 	if !inodeChanged {
 		t.Error("Expected inode change to be detected")
 	}
@@ -300,7 +389,7 @@ func TestRotationDetectionByTruncation(t *testing.T) {
 	defer store.Close()
 
 	// Simulate having processed a file up to byte 10000
-	store.UpdateProcessingState("test.jsonl", 10000, 10000, 12345)
+	store.UpdateProcessingState("test.jsonl", 0, 10000, 10000, 12345)
 
 	state, _ := store.GetProcessingState("test.jsonl")
 
@@ -308,7 +397,7 @@ func TestRotationDetectionByTruncation(t *testing.T) {
 	currentFileSize := int64(5000)
 
 	// The truncation detection logic
-	truncated := state.LastByteOffset > currentFileSize
+	_, truncated := detectRotation(state, 12345, currentFileSize)
 	if !truncated {
 		t.Error("Expected truncation to be detected when file size < last offset")
 	}
@@ -327,7 +416,7 @@ func TestNoFalsePositiveRotationOnGrowth(t *testing.T) {
 	defer store.Close()
 
 	// Simulate having processed a file up to byte 10000
-	store.UpdateProcessingState("test.jsonl", 10000, 10000, 12345)
+	store.UpdateProcessingState("test.jsonl", 0, 10000, 10000, 12345)
 
 	state, _ := store.GetProcessingState("test.jsonl")
 
@@ -336,8 +425,7 @@ func TestNoFalsePositiveRotationOnGrowth(t *testing.T) {
 	currentInode := uint64(12345) // Same inode
 
 	// Neither condition should trigger
-	inodeChanged := state.Inode != 0 && currentInode != state.Inode
-	truncated := state.LastByteOffset > currentFileSize
+	inodeChanged, truncated := detectRotation(state, currentInode, currentFileSize)
 
 	if inodeChanged {
 		t.Error("Should not detect inode change when inode is the same")
@@ -360,7 +448,7 @@ func TestRotationBugScenario(t *testing.T) {
 	defer store.Close()
 
 	// Simulate: file was 10KB, we processed to byte 10000
-	store.UpdateProcessingState("test.jsonl", 10000, 10000, 11111)
+	store.UpdateProcessingState("test.jsonl", 0, 10000, 10000, 11111)
 
 	state, _ := store.GetProcessingState("test.jsonl")
 
@@ -376,8 +464,641 @@ func TestRotationBugScenario(t *testing.T) {
 	}
 
 	// New inode-based check should catch it:
-	inodeChanged := state.Inode != 0 && currentInode != state.Inode
+	inodeChanged, _ := detectRotation(state, currentInode, currentFileSize)
 	if !inodeChanged {
 		t.Error("Inode check SHOULD detect this rotation")
 	}
 }
+
+// TestProcessFileDrainsCollectorRotatedFile tests that lines written to a file
+// after the last pass but before the collector rotated it are still processed.
+func TestProcessFileDrainsCollectorRotatedFile(t *testing.T) {
+	dbPath := "./test_rotation_drain.db"
+	dataDir := "./test_rotation_drain_data"
+	defer os.Remove(dbPath)
+	defer os.RemoveAll(dataDir)
+
+	os.MkdirAll(dataDir, 0755)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+
+	costLine := func(cost string) string {
+		return `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"timeUnixNano":"1000000000","asDouble":` +
+			cost + `,"attributes":[{"key":"session.id","value":{"stringValue":"session-rotated"}}]}]}}]}]}]}` + "\n"
+	}
+
+	testFile := filepath.Join(dataDir, "metrics.jsonl")
+	f, err := os.Create(testFile)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	f.WriteString(costLine("1.0"))
+	f.Close()
+
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	// Written after the first pass, then rotated away by the collector
+	f, _ = os.OpenFile(testFile, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(costLine("2.0"))
+	f.Close()
+	os.Rename(testFile, testFile+".20250101T000000.000000000")
+
+	f, err = os.Create(testFile)
+	if err != nil {
+		t.Fatalf("Failed to create rotated file: %v", err)
+	}
+	f.WriteString(costLine("4.0"))
+	f.Close()
+
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	session, exists := engine.sessionsCache["session-rotated"]
+	if !exists {
+		t.Fatal("Expected session to be cached")
+	}
+	if session.TotalCostUSD != 7.0 {
+		t.Errorf("Expected cost 7.0 including the rotated tail, got %f", session.TotalCostUSD)
+	}
+}
+
+func TestProcessFileDrainsCompressedRotatedFile(t *testing.T) {
+	dbPath := "./test_rotation_gzip.db"
+	dataDir := "./test_rotation_gzip_data"
+	defer os.Remove(dbPath)
+	defer os.RemoveAll(dataDir)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+
+	costLine := func(cost string) string {
+		return `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"timeUnixNano":"1000000000","asDouble":` +
+			cost + `,"attributes":[{"key":"session.id","value":{"stringValue":"session-gzip"}}]}]}}]}]}]}`
+	}
+
+	testFile := filepath.Join(dataDir, "metrics.jsonl")
+	writer, err := collector.NewFileWriter(testFile)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	// The third line triggers rotation, leaving the first two in <name>.1.gz
+	writer.SetMaxFileSize(int64(len(costLine("1.0")) + 2))
+	writer.SetCompressRotated(true)
+
+	writer.WriteLine(costLine("1.0"))
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	writer.WriteLine(costLine("2.0"))
+	writer.WriteLine(costLine("4.0"))
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	if _, err := os.Stat(testFile + ".1.gz"); err != nil {
+		t.Fatalf("Expected a compressed generation: %v", err)
+	}
+
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	session, exists := engine.sessionsCache["session-gzip"]
+	if !exists {
+		t.Fatal("Expected session to be cached")
+	}
+	if session.TotalCostUSD != 7.0 {
+		t.Errorf("Expected cost 7.0 including the compressed tail, got %f", session.TotalCostUSD)
+	}
+}
+
+func TestProcessFileTracksLineNumber(t *testing.T) {
+	dbPath := "./test_processing_line.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, NewEngine(store), 60)
+	testFile := filepath.Join(dataDir, "logs.jsonl")
+	line := `{"data":"{\"resourceLogs\":[]}"}` + "\n"
+
+	// Blank lines count towards the line number like any other
+	os.WriteFile(testFile, []byte(line+"\n"+line), 0644)
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	state, _ := store.GetProcessingState("logs.jsonl")
+	if state.LastProcessedLine != 3 || state.LastByteOffset != int64(2*len(line)+1) {
+		t.Errorf("Expected line 3 at offset %d, got line %d at offset %d", 2*len(line)+1, state.LastProcessedLine, state.LastByteOffset)
+	}
+
+	f, _ := os.OpenFile(testFile, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(line + line)
+	f.Close()
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	state, _ = store.GetProcessingState("logs.jsonl")
+	if state.LastProcessedLine != 5 {
+		t.Errorf("Expected line 5 after appending, got %d", state.LastProcessedLine)
+	}
+
+	// Skipping counts the skipped lines too
+	f, _ = os.OpenFile(testFile, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(line + line)
+	f.Close()
+	processor.skipFile("logs.jsonl")
+	state, _ = store.GetProcessingState("logs.jsonl")
+	if state.LastProcessedLine != 7 {
+		t.Errorf("Expected line 7 after skipping, got %d", state.LastProcessedLine)
+	}
+
+	// A truncated file starts counting again
+	os.WriteFile(testFile, []byte(line), 0644)
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	state, _ = store.GetProcessingState("logs.jsonl")
+	if state.LastProcessedLine != 1 {
+		t.Errorf("Expected line 1 after truncation, got %d", state.LastProcessedLine)
+	}
+}
+
+func TestProcessFileRotatedFileGrownPastOffset(t *testing.T) {
+	dbPath := "./test_rotation_grown.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+
+	costLine := func(cost string) string {
+		return `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"timeUnixNano":"1000000000","asDouble":` +
+			cost + `,"attributes":[{"key":"session.id","value":{"stringValue":"session-grown"}}]}]}}]}]}]}` + "\n"
+	}
+
+	testFile := filepath.Join(dataDir, "metrics.jsonl")
+	os.WriteFile(testFile, []byte(costLine("1.0")), 0644)
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	// Rotated out of the data directory, then the new file grows past the
+	// old offset before the next pass, so only the inode shows the rotation
+	if err := os.Rename(testFile, filepath.Join(t.TempDir(), "archived.jsonl")); err != nil {
+		t.Fatalf("Failed to rotate file: %v", err)
+	}
+	os.WriteFile(testFile, []byte(costLine("2.0")+costLine("4.0")), 0644)
+
+	info, _ := os.Stat(testFile)
+	state, _ := store.GetProcessingState("metrics.jsonl")
+	if getInode(info) == state.Inode {
+		t.Skip("Filesystem reused inode - can't test inode-based rotation detection")
+	}
+	if info.Size() <= state.LastByteOffset {
+		t.Fatalf("Expected the new file to grow past offset %d, got size %d", state.LastByteOffset, info.Size())
+	}
+
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	if cost := engine.sessionsCache["session-grown"].TotalCostUSD; cost != 7.0 {
+		t.Errorf("Expected cost 7.0 with every line of the new file, got %f", cost)
+	}
+	state, _ = store.GetProcessingState("metrics.jsonl")
+	if state.Inode != getInode(info) || state.LastByteOffset != info.Size() || state.LastProcessedLine != 2 {
+		t.Errorf("Expected state at the end of the new file, got %+v", state)
+	}
+}
+
+func TestProcessFileDrainsNumberedRotationBurst(t *testing.T) {
+	dbPath := "./test_rotation_burst.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+
+	costLine := func(cost string) string {
+		return `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"timeUnixNano":"1000000000","asDouble":` +
+			cost + `,"attributes":[{"key":"session.id","value":{"stringValue":"session-burst"}}]}]}}]}]}]}` + "\n"
+	}
+
+	testFile := filepath.Join(dataDir, "metrics.jsonl")
+	os.WriteFile(testFile, []byte(costLine("1")+costLine("1")+costLine("1")), 0644)
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	// A burst lands just before the file is rotated to metrics.jsonl.1
+	f, _ := os.OpenFile(testFile, os.O_APPEND|os.O_WRONLY, 0644)
+	for i := 0; i < 4; i++ {
+		f.WriteString(costLine("10"))
+	}
+	f.Close()
+	if err := os.Rename(testFile, testFile+".1"); err != nil {
+		t.Fatalf("Failed to rotate file: %v", err)
+	}
+	os.WriteFile(testFile, []byte(costLine("100")+costLine("100")), 0644)
+
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	// 3 lines before the rotation, the 4 line burst from metrics.jsonl.1 and
+	// 2 lines from the new file
+	if cost := engine.sessionsCache["session-burst"].TotalCostUSD; cost != 243 {
+		t.Errorf("Expected cost 243 from every line across both files, got %f", cost)
+	}
+	state, _ := store.GetProcessingState("metrics.jsonl")
+	if state.LastProcessedLine != 2 {
+		t.Errorf("Expected the new file to be read from its start, at line %d", state.LastProcessedLine)
+	}
+}
+
+func TestProcessFileDrainsEveryGenerationRotatedBetweenPasses(t *testing.T) {
+	dbPath := "./test_rotation_generations.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+
+	costLine := func(cost string) string {
+		return `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"timeUnixNano":"1000000000","asDouble":` +
+			cost + `,"attributes":[{"key":"session.id","value":{"stringValue":"session-generations"}}]}]}}]}]}]}` + "\n"
+	}
+
+	testFile := filepath.Join(dataDir, "metrics.jsonl")
+	os.WriteFile(testFile, []byte(costLine("1")), 0644)
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	// The collector rotates three times before the next pass: the file the
+	// processor was reading ends up as metrics.jsonl.3, with two generations
+	// it has never seen after it
+	rotate := func() {
+		for n := 2; n >= 1; n-- {
+			os.Rename(fmt.Sprintf("%s.%d", testFile, n), fmt.Sprintf("%s.%d", testFile, n+1))
+		}
+		if err := os.Rename(testFile, testFile+".1"); err != nil {
+			t.Fatalf("Failed to rotate file: %v", err)
+		}
+	}
+	f, _ := os.OpenFile(testFile, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(costLine("10"))
+	f.Close()
+	rotate()
+	os.WriteFile(testFile, []byte(costLine("100")), 0644)
+	rotate()
+	os.WriteFile(testFile, []byte(costLine("1000")+costLine("1000")), 0644)
+	rotate()
+	os.WriteFile(testFile, []byte(costLine("10000")), 0644)
+
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	if cost := engine.sessionsCache["session-generations"].TotalCostUSD; cost != 12111 {
+		t.Errorf("Expected cost 12111 from every generation, got %f", cost)
+	}
+	for n := 1; n <= 3; n++ {
+		info, err := os.Stat(fmt.Sprintf("%s.%d", testFile, n))
+		if err != nil {
+			t.Fatalf("Failed to stat generation %d: %v", n, err)
+		}
+		if processed, _ := store.IsArchiveProcessed("metrics.jsonl", getInode(info)); !processed {
+			t.Errorf("Expected generation %d recorded as processed", n)
+		}
+	}
+
+	// A later pass reads none of them again
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	if cost := engine.sessionsCache["session-generations"].TotalCostUSD; cost != 12111 {
+		t.Errorf("Expected cost to stay 12111, got %f", cost)
+	}
+}
+
+func TestProcessAllFilesUsesConfiguredFileNames(t *testing.T) {
+	dbPath := "./test_custom_file_names.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	files := InputFiles{Metrics: "otel-metrics.jsonl", Logs: "otel-logs.jsonl", Traces: "otel-traces.jsonl"}
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, files, store, engine, 60)
+
+	metric := `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"timeUnixNano":"1000000000","asDouble":1.5,` +
+		`"attributes":[{"key":"session.id","value":{"stringValue":"session-custom"}}]}]}}]}]}]}`
+	writeLines(t, filepath.Join(dataDir, "otel-metrics.jsonl"), metric)
+	// A file under the default name is not an input any more
+	writeLines(t, filepath.Join(dataDir, "metrics.jsonl"), strings.Replace(metric, "1.5", "100", 1))
+
+	processor.processAllFiles()
+
+	session, exists := engine.sessionsCache["session-custom"]
+	if !exists {
+		t.Fatal("Expected session to be cached")
+	}
+	if session.TotalCostUSD != 1.5 {
+		t.Errorf("Expected cost 1.5 from the configured file only, got %f", session.TotalCostUSD)
+	}
+
+	if err := processor.processLine("metrics.jsonl", metric); err == nil {
+		t.Error("Expected an error for a file that is not configured")
+	}
+}
+
+func TestProcessAllFilesReadsOrgDirectories(t *testing.T) {
+	dbPath := "./test_org_directories.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	appendToFile(t, filepath.Join(dataDir, "metrics.jsonl"), workerCostLines(0, 5))
+	for _, dir := range []string{"acme", "globex", ".hidden"} {
+		if err := os.Mkdir(filepath.Join(dataDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	appendToFile(t, filepath.Join(dataDir, "acme", "metrics.jsonl"), workerCostLines(5, 10))
+	// A generation rotated before the aggregator first saw it is backfilled
+	appendToFile(t, filepath.Join(dataDir, "globex", "metrics.jsonl.1"), workerCostLines(10, 12))
+	appendToFile(t, filepath.Join(dataDir, "globex", "metrics.jsonl"), workerCostLines(12, 15))
+	appendToFile(t, filepath.Join(dataDir, ".hidden", "metrics.jsonl"), workerCostLines(15, 20))
+
+	files := DefaultInputFiles
+	files.ByOrg = true
+	engine := newEngine(store)
+	processor := NewProcessor(dataDir, files, store, engine, 60)
+
+	want := []string{"metrics.jsonl", "logs.jsonl", "traces.jsonl", "acme/metrics.jsonl", "globex/metrics.jsonl"}
+	if paths := processor.files.paths(dataDir); strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected paths %v, got %v", want, paths)
+	}
+
+	processor.processAllFiles()
+	if got, want := totalCost(engine), workerCost(0, 15); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected cost %f from the shared and organization files, got %f", want, got)
+	}
+	state, err := store.GetProcessingState("acme/metrics.jsonl")
+	if err != nil || state.LastProcessedLine != 5 {
+		t.Errorf("Expected acme's state under its relative path, got %+v (%v)", state, err)
+	}
+
+	for name, ok := range map[string]bool{"acme/metrics.jsonl": true, "../metrics.jsonl": false, ".hidden/metrics.jsonl": false, "acme/other.jsonl": false} {
+		if _, got := files.signal(name); got != ok {
+			t.Errorf("Expected signal(%q) ok=%v, got %v", name, ok, got)
+		}
+	}
+	if _, ok := DefaultInputFiles.signal("acme/metrics.jsonl"); ok {
+		t.Error("Expected organization paths rejected when not partitioned")
+	}
+}
+
+// crlfCostLine is an OTLP cost metric line for session-crlf without a terminator
+func crlfCostLine(cost string) string {
+	return `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"timeUnixNano":"1000000000","asDouble":` +
+		cost + `,"attributes":[{"key":"session.id","value":{"stringValue":"session-crlf"}}]}]}}]}]}]}`
+}
+
+func appendToFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestProcessFileCRLFOffsets(t *testing.T) {
+	dbPath := "./test_crlf_offsets.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+	testFile := filepath.Join(dataDir, "metrics.jsonl")
+
+	// Multi-byte characters and blank CRLF lines must not throw the offset off
+	first := crlfCostLine("1.0") + "\r\n\r\n" + strings.Replace(crlfCostLine("2.0"), "session-crlf", "sessión-ünïcode", 1) + "\r\n"
+	appendToFile(t, testFile, first)
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	state, _ := store.GetProcessingState("metrics.jsonl")
+	if state.LastByteOffset != int64(len(first)) || state.LastProcessedLine != 3 {
+		t.Fatalf("Expected offset %d at line 3, got %d at line %d", len(first), state.LastByteOffset, state.LastProcessedLine)
+	}
+
+	// A later pass seeks to the stored offset and parses the next line whole
+	appendToFile(t, testFile, crlfCostLine("4.0")+"\r\n")
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+
+	if session := engine.sessionsCache["session-crlf"]; session == nil || session.TotalCostUSD != 5.0 {
+		t.Errorf("Expected session-crlf to cost 5.0, got %+v", session)
+	}
+	if session := engine.sessionsCache["sessión-ünïcode"]; session == nil || session.TotalCostUSD != 2.0 {
+		t.Errorf("Expected the multi-byte session to cost 2.0, got %+v", session)
+	}
+	if info, _ := os.Stat(testFile); info != nil {
+		state, _ = store.GetProcessingState("metrics.jsonl")
+		if state.LastByteOffset != info.Size() || state.LastProcessedLine != 4 {
+			t.Errorf("Expected offset %d at line 4, got %d at line %d", info.Size(), state.LastByteOffset, state.LastProcessedLine)
+		}
+	}
+}
+
+func TestProcessFileHoldsBackUnterminatedLine(t *testing.T) {
+	dbPath := "./test_unterminated_line.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+	testFile := filepath.Join(dataDir, "metrics.jsonl")
+
+	// The collector has written the first line and half of the second
+	second := crlfCostLine("2.0")
+	appendToFile(t, testFile, crlfCostLine("1.0")+"\n"+second[:40])
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	state, _ := store.GetProcessingState("metrics.jsonl")
+	if boundary := int64(len(crlfCostLine("1.0")) + 1); state.LastByteOffset != boundary || state.LastProcessedLine != 1 {
+		t.Fatalf("Expected the offset held at the line boundary %d, got %d at line %d", boundary, state.LastByteOffset, state.LastProcessedLine)
+	}
+
+	appendToFile(t, testFile, second[40:]+"\n")
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	if session := engine.sessionsCache["session-crlf"]; session == nil || session.TotalCostUSD != 3.0 {
+		t.Errorf("Expected the completed line processed once for a cost of 3.0, got %+v", session)
+	}
+
+	// Skipping in direct ingest mode stops at the same boundary
+	appendToFile(t, testFile, crlfCostLine("4.0")+"\r\n"+second[:40])
+	processor.skipFile("metrics.jsonl")
+	info, _ := os.Stat(testFile)
+	state, _ = store.GetProcessingState("metrics.jsonl")
+	if boundary := info.Size() - 40; state.LastByteOffset != boundary || state.LastProcessedLine != 3 {
+		t.Errorf("Expected the skip to stop at %d on line 3, got %d at line %d", boundary, state.LastByteOffset, state.LastProcessedLine)
+	}
+}
+
+// paddedCostLine is crlfCostLine padded with an attribute of size bytes
+func paddedCostLine(cost string, size int) string {
+	padding := `{"key":"padding","value":{"stringValue":"` + strings.Repeat("x", size) + `"}},`
+	return strings.Replace(crlfCostLine(cost), `"attributes":[`, `"attributes":[`+padding, 1)
+}
+
+func TestProcessFileSkipsOversizedLines(t *testing.T) {
+	dbPath := "./test_oversized_lines.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+	testFile := filepath.Join(dataDir, "metrics.jsonl")
+
+	// A 1MB line is well past bufio.Scanner's 64KB default but within the
+	// default limit
+	content := crlfCostLine("1.0") + "\n" + paddedCostLine("2.0", 1<<20) + "\n"
+	appendToFile(t, testFile, content)
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	if session := engine.sessionsCache["session-crlf"]; session == nil || session.TotalCostUSD != 3.0 {
+		t.Fatalf("Expected the 1MB line processed for a cost of 3.0, got %+v", session)
+	}
+
+	// Past the limit, the line is skipped and the lines after it still processed
+	processor.SetMaxLineBytes(256 << 10)
+	more := paddedCostLine("4.0", 1<<20) + "\n" + crlfCostLine("8.0") + "\n"
+	appendToFile(t, testFile, more)
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file with an oversized line: %v", err)
+	}
+	if session := engine.sessionsCache["session-crlf"]; session == nil || session.TotalCostUSD != 11.0 {
+		t.Errorf("Expected the oversized line skipped for a cost of 11.0, got %+v", session)
+	}
+	state, _ := store.GetProcessingState("metrics.jsonl")
+	if size := int64(len(content) + len(more)); state.LastByteOffset != size || state.LastProcessedLine != 4 {
+		t.Errorf("Expected offset %d at line 4, got %d at line %d", size, state.LastByteOffset, state.LastProcessedLine)
+	}
+
+	// An oversized line on its own still moves the offset, so it isn't re-read
+	last := paddedCostLine("16.0", 1<<20) + "\n"
+	appendToFile(t, testFile, last)
+	if err := processor.ProcessFile(testFile); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	state, _ = store.GetProcessingState("metrics.jsonl")
+	if size := int64(len(content) + len(more) + len(last)); state.LastByteOffset != size || state.LastProcessedLine != 5 {
+		t.Errorf("Expected offset %d at line 5, got %d at line %d", size, state.LastByteOffset, state.LastProcessedLine)
+	}
+}
+
+func TestRawLineScannerOversizedLines(t *testing.T) {
+	input := "short\n" + strings.Repeat("a", 100) + "\r\nmid\n" + strings.Repeat("b", 50)
+	scanner := newRawLineScanner(strings.NewReader(input), 16)
+
+	type scanned struct {
+		line      string
+		oversized bool
+		length    int64
+	}
+	var got []scanned
+	for scanner.Scan() {
+		line, _ := trimLineEnd(scanner.Bytes())
+		oversized, length := scanner.oversized()
+		if oversized {
+			line = ""
+		}
+		got = append(got, scanned{line, oversized, length})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Unexpected scan error: %v", err)
+	}
+
+	expected := []scanned{{"short", false, 6}, {"", true, 102}, {"mid", false, 4}, {"", true, 50}}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,185 @@
+package aggregator
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DefaultPromptQueueSize is how many prompts can wait to be written before
+// more are dropped
+const DefaultPromptQueueSize = 4096
+
+const (
+	promptBatchSize    = 100
+	promptRetries      = 5
+	promptRetryBackoff = 50 * time.Millisecond
+)
+
+// promptWriter persists user prompts off the ingest path. The engine queues
+// each prompt and moves on; one goroutine inserts them in batches, one
+// transaction each, retrying a batch while the database is busy. Prompts
+// arriving to a full queue, or in a batch that still fails, are dropped and
+// counted.
+type promptWriter struct {
+	insert    func([]*SessionPrompt) error
+	queue     chan *SessionPrompt
+	flushes   chan chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	batchSize int
+	retries   int
+	backoff   time.Duration
+	sleep     func(time.Duration)
+
+	dropped       atomic.Int64
+	overflowDrops atomic.Int64 // Dropped since the queue last had room
+}
+
+// newPromptWriter creates a writer inserting prompts into store; run starts
+// it writing
+func newPromptWriter(store *Store, queueSize int) *promptWriter {
+	return &promptWriter{
+		insert:    store.InsertSessionPrompts,
+		queue:     make(chan *SessionPrompt, queueSize),
+		flushes:   make(chan chan struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		batchSize: promptBatchSize,
+		retries:   promptRetries,
+		backoff:   promptRetryBackoff,
+		sleep:     time.Sleep,
+	}
+}
+
+// enqueue queues prompt to be written, dropping it if the queue is full
+func (w *promptWriter) enqueue(prompt *SessionPrompt) {
+	select {
+	case w.queue <- prompt:
+		if n := w.overflowDrops.Swap(0); n > 0 {
+			log.Printf("Prompt queue has room again after dropping %d prompts", n)
+		}
+	default:
+		w.dropped.Add(1)
+		if w.overflowDrops.Add(1) == 1 {
+			log.Printf("Prompt queue full at %d prompts, dropping prompts until it drains", cap(w.queue))
+		}
+	}
+}
+
+// flush waits until every prompt queued so far has been written or dropped
+func (w *promptWriter) flush() {
+	written := make(chan struct{})
+	select {
+	case w.flushes <- written:
+		<-written
+	case <-w.done:
+	}
+}
+
+// close writes the prompts still queued, then stops the writer
+func (w *promptWriter) close() {
+	w.closeOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (w *promptWriter) run() {
+	defer close(w.done)
+	for {
+		select {
+		case prompt := <-w.queue:
+			w.write(w.collect(prompt))
+		case written := <-w.flushes:
+			w.drain()
+			close(written)
+		case <-w.stop:
+			w.drain()
+			return
+		}
+	}
+}
+
+// collect gathers the prompts already queued behind first into one batch
+func (w *promptWriter) collect(first *SessionPrompt) []*SessionPrompt {
+	batch := []*SessionPrompt{first}
+	for len(batch) < w.batchSize {
+		select {
+		case prompt := <-w.queue:
+			batch = append(batch, prompt)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// drain writes everything queued
+func (w *promptWriter) drain() {
+	for {
+		select {
+		case prompt := <-w.queue:
+			w.write(w.collect(prompt))
+		default:
+			return
+		}
+	}
+}
+
+// write inserts batch, retrying with doubling backoff while the database is
+// busy
+func (w *promptWriter) write(batch []*SessionPrompt) {
+	backoff := w.backoff
+	for attempt := 0; ; attempt++ {
+		err := w.insert(batch)
+		if err == nil {
+			return
+		}
+		if !isBusy(err) || attempt == w.retries {
+			w.dropped.Add(int64(len(batch)))
+			log.Printf("Error inserting %d prompts, dropping them: %v", len(batch), err)
+			return
+		}
+		w.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isBusy reports whether err means another connection holds the database's
+// lock, so the statement may succeed if retried
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// DroppedPrompts is how many prompts were dropped, because the queue was full
+// or they couldn't be inserted
+func (e *Engine) DroppedPrompts() int64 {
+	if e.prompts == nil {
+		return 0
+	}
+	return e.prompts.dropped.Load()
+}
+
+// FlushPrompts waits until every prompt queued so far has been written or
+// dropped
+func (e *Engine) FlushPrompts() {
+	if e.prompts != nil {
+		e.prompts.flush()
+	}
+}
+
+// Close writes the prompts still queued and stops the prompt writer. Prompts
+// processed afterwards are never written. Anomaly webhooks not yet delivered
+// are dropped.
+func (e *Engine) Close() {
+	if e.prompts != nil {
+		e.prompts.close()
+	}
+	e.webhook.Swap(nil).close()
+}
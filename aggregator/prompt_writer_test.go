@@ -0,0 +1,143 @@
+package aggregator
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPrompt(sessionID string, i int) *SessionPrompt {
+	text := fmt.Sprintf("prompt %d", i)
+	return &SessionPrompt{SessionID: sessionID, PromptText: text, PromptLength: len(text), Timestamp: time.Unix(0, int64(i))}
+}
+
+func TestPromptWriterBatches(t *testing.T) {
+	dbPath := "./test_prompt_writer_batches.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	writer := newPromptWriter(store, 10)
+	writer.batchSize = 3
+	var batches []int
+	writer.insert = func(prompts []*SessionPrompt) error {
+		batches = append(batches, len(prompts))
+		return store.InsertSessionPrompts(prompts)
+	}
+
+	// Queued before the writer starts, so they are waiting together
+	for i := 0; i < 7; i++ {
+		writer.enqueue(testPrompt("s1", i))
+	}
+	go writer.run()
+	writer.close()
+
+	if fmt.Sprint(batches) != "[3 3 1]" {
+		t.Errorf("Expected batches of 3, 3 and 1, got %v", batches)
+	}
+	prompts, err := store.GetSessionPrompts("s1")
+	if err != nil {
+		t.Fatalf("Failed to get prompts: %v", err)
+	}
+	if len(prompts) != 7 || writer.dropped.Load() != 0 {
+		t.Errorf("Expected all 7 prompts stored, got %d with %d dropped", len(prompts), writer.dropped.Load())
+	}
+}
+
+func TestPromptWriterRetriesWhileLocked(t *testing.T) {
+	const path = "./test_prompt_writer_locked.db"
+	store, err := NewStore("file:" + path + "?_busy_timeout=20")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(path + suffix)
+		}
+	})
+	store.db.SetMaxIdleConns(0)
+
+	unlock := lockDatabase(t, path)
+	defer unlock()
+
+	// The first attempts find the database locked; it is released on the
+	// second backoff
+	writer := newPromptWriter(store, 10)
+	var sleeps []time.Duration
+	writer.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		if len(sleeps) == 2 {
+			unlock()
+		}
+	}
+	writer.enqueue(testPrompt("s1", 1))
+	writer.enqueue(testPrompt("s1", 2))
+	go writer.run()
+	writer.close()
+
+	if len(sleeps) != 2 || sleeps[1] != 2*sleeps[0] {
+		t.Errorf("Expected 2 doubling backoffs, got %v", sleeps)
+	}
+	prompts, err := store.GetSessionPrompts("s1")
+	if err != nil {
+		t.Fatalf("Failed to get prompts: %v", err)
+	}
+	if len(prompts) != 2 || writer.dropped.Load() != 0 {
+		t.Errorf("Expected both prompts stored once unlocked, got %d with %d dropped", len(prompts), writer.dropped.Load())
+	}
+}
+
+func TestPromptWriterCountsDrops(t *testing.T) {
+	dbPath := "./test_prompt_writer_drops.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := newEngine(store)
+	defer engine.Close()
+
+	// Swap in a writer that isn't running yet, so the queue fills up
+	engine.prompts.close()
+	engine.prompts = newPromptWriter(store, 2)
+	for i := 0; i < 5; i++ {
+		engine.ProcessLog(&LogRecord{
+			Timestamp:  time.Now(),
+			SessionID:  "s1",
+			Body:       "claude_code.user_prompt",
+			Attributes: map[string]interface{}{"prompt": fmt.Sprintf("prompt %d", i)},
+		})
+	}
+	if got := engine.DroppedPrompts(); got != 3 {
+		t.Errorf("Expected 3 prompts dropped from a queue of 2, got %d", got)
+	}
+
+	go engine.prompts.run()
+	engine.FlushPrompts()
+	prompts, err := store.GetSessionPrompts("s1")
+	if err != nil {
+		t.Fatalf("Failed to get prompts: %v", err)
+	}
+	if len(prompts) != 2 {
+		t.Errorf("Expected the 2 queued prompts stored, got %d", len(prompts))
+	}
+
+	server := NewAPIServer(0, store, engine)
+	rec := httptest.NewRecorder()
+	server.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "otis_dropped_prompts_total 3\n") {
+		t.Errorf("Expected the drop count in /metrics, got:\n%s", rec.Body.String())
+	}
+}
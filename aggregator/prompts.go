@@ -0,0 +1,266 @@
+package aggregator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes for prompt lists
+const (
+	defaultPromptPageSize = 100
+	maxPromptPageSize     = 1000
+)
+
+// PromptCursor marks where a page of prompts ended: the last prompt's
+// timestamp and ID, so prompts sharing a timestamp are neither skipped nor
+// repeated
+type PromptCursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+// String encodes the cursor as the opaque next_cursor value
+func (c *PromptCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.Timestamp.UnixNano(), c.ID)))
+}
+
+// ParsePromptCursor reads a next_cursor value; an empty value means the
+// first page
+func ParsePromptCursor(value string) (*PromptCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", value)
+	}
+	nanosStr, idStr, ok := strings.Cut(string(decoded), ":")
+	nanos, nanosErr := strconv.ParseInt(nanosStr, 10, 64)
+	id, idErr := strconv.ParseInt(idStr, 10, 64)
+	if !ok || nanosErr != nil || idErr != nil {
+		return nil, fmt.Errorf("invalid cursor %q", value)
+	}
+	return &PromptCursor{Timestamp: time.Unix(0, nanos), ID: id}, nil
+}
+
+// PromptSearch selects the prompts containing Text, optionally only those
+// sent in a user's or organization's sessions
+type PromptSearch struct {
+	Text           string
+	UserID         string
+	OrganizationID string
+}
+
+// PromptMatch is a prompt found by a search, with who sent it
+type PromptMatch struct {
+	SessionPrompt
+	UserID         string
+	OrganizationID string
+}
+
+// GetSessionPromptsPage retrieves up to limit of a session's prompts, oldest
+// first, starting after the cursor, and the cursor for the next page; nil
+// when there is none. A negative limit retrieves them all.
+func (s *Store) GetSessionPromptsPage(sessionID string, after *PromptCursor, limit int) ([]*SessionPrompt, *PromptCursor, error) {
+	query := `
+	SELECT id, session_id, prompt_text, prompt_length, timestamp
+	FROM session_prompts
+	WHERE session_id = ?
+	`
+	args := []interface{}{sessionID}
+	if after != nil {
+		query += ` AND (timestamp > ? OR (timestamp = ? AND id > ?))`
+		args = append(args, after.Timestamp.UnixNano(), after.Timestamp.UnixNano(), after.ID)
+	}
+	query += `
+	ORDER BY timestamp ASC, id ASC
+	LIMIT ?
+	`
+
+	rows, err := s.query(queryGetSessionPrompts, query, append(args, fetchLimit(limit))...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var prompts []*SessionPrompt
+	for rows.Next() {
+		var prompt SessionPrompt
+		var timestamp int64
+		err := rows.Scan(
+			&prompt.ID, &prompt.SessionID, &prompt.PromptText,
+			&prompt.PromptLength, &timestamp,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		prompt.Timestamp = time.Unix(0, timestamp)
+		prompts = append(prompts, &prompt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if limit < 0 || len(prompts) <= limit {
+		return prompts, nil, nil
+	}
+	prompts = prompts[:limit]
+	if limit == 0 {
+		return prompts, nil, nil
+	}
+	last := prompts[limit-1]
+	return prompts, &PromptCursor{Timestamp: last.Timestamp, ID: last.ID}, nil
+}
+
+// SearchPrompts retrieves up to limit prompts containing search.Text,
+// ignoring ASCII case, newest first, starting before the cursor, and the
+// cursor for the next page; nil when there is none. Redacted prompts are
+// never stored, so they are never found.
+func (s *Store) SearchPrompts(search PromptSearch, before *PromptCursor, limit int) ([]*PromptMatch, *PromptCursor, error) {
+	query := `
+	SELECT p.id, p.session_id, p.prompt_text, p.prompt_length, p.timestamp,
+		COALESCE(s.user_id, ''), COALESCE(s.organization_id, '')
+	FROM session_prompts p
+	LEFT JOIN sessions s ON s.session_id = p.session_id
+	WHERE p.prompt_text LIKE ? ESCAPE '\'
+	`
+	args := []interface{}{"%" + escapeLike(search.Text) + "%"}
+	if search.UserID != "" {
+		query += ` AND s.user_id = ?`
+		args = append(args, search.UserID)
+	}
+	if search.OrganizationID != "" {
+		query += ` AND s.organization_id = ?`
+		args = append(args, search.OrganizationID)
+	}
+	if before != nil {
+		query += ` AND (p.timestamp < ? OR (p.timestamp = ? AND p.id < ?))`
+		args = append(args, before.Timestamp.UnixNano(), before.Timestamp.UnixNano(), before.ID)
+	}
+	query += `
+	ORDER BY p.timestamp DESC, p.id DESC
+	LIMIT ?
+	`
+
+	rows, err := s.query(querySearchPrompts, query, append(args, fetchLimit(limit))...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var matches []*PromptMatch
+	for rows.Next() {
+		var match PromptMatch
+		var timestamp int64
+		err := rows.Scan(
+			&match.ID, &match.SessionID, &match.PromptText, &match.PromptLength, &timestamp,
+			&match.UserID, &match.OrganizationID,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		match.Timestamp = time.Unix(0, timestamp)
+		matches = append(matches, &match)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if limit < 0 || len(matches) <= limit {
+		return matches, nil, nil
+	}
+	matches = matches[:limit]
+	if limit == 0 {
+		return matches, nil, nil
+	}
+	last := matches[limit-1]
+	return matches, &PromptCursor{Timestamp: last.Timestamp, ID: last.ID}, nil
+}
+
+// escapeLike escapes LIKE's wildcards in text, with backslash as the escape
+// character, so it matches literally
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+// parsePromptPageSize reads the limit parameter for a page of prompts
+func parsePromptPageSize(r *http.Request) (int, error) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return defaultPromptPageSize, nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit %q", limitStr)
+	}
+	return min(limit, maxPromptPageSize), nil
+}
+
+// jsonPromptCursor renders the next page's cursor, or null on the last page
+func jsonPromptCursor(cursor *PromptCursor) interface{} {
+	if cursor == nil {
+		return nil
+	}
+	return cursor.String()
+}
+
+// handleSearchPrompts handles GET /api/prompts/search?q=X&user_id=Y&org_id=Z&limit=100&before=C
+func (s *APIServer) handleSearchPrompts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	search := PromptSearch{
+		Text:           strings.TrimSpace(query.Get("q")),
+		UserID:         query.Get("user_id"),
+		OrganizationID: query.Get("org_id"),
+	}
+	if search.Text == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit, err := parsePromptPageSize(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	before, err := ParsePromptCursor(query.Get("before"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	matches, next, err := s.store.SearchPrompts(search, before, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error searching prompts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	prompts := make([]map[string]interface{}, len(matches))
+	for i, match := range matches {
+		prompts[i] = map[string]interface{}{
+			"id":              match.ID,
+			"session_id":      match.SessionID,
+			"user_id":         jsonString(match.UserID),
+			"organization_id": jsonString(match.OrganizationID),
+			"prompt_text":     match.PromptText,
+			"prompt_length":   match.PromptLength,
+			"timestamp":       match.Timestamp.Format(time.RFC3339Nano),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":       search.Text,
+		"count":       len(prompts),
+		"prompts":     prompts,
+		"next_cursor": jsonPromptCursor(next),
+	})
+}
@@ -0,0 +1,119 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSessionPromptsPagination(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_session_prompts.db")
+	start := time.Now().Truncate(time.Second).Add(-time.Hour)
+
+	spec := SessionSpec{SessionID: "s1", UserID: "u1", StartTime: start, Duration: time.Minute, Prompts: []PromptSpec{
+		{Text: "first", Offset: 0},
+		{Text: "second", Offset: time.Second},
+		{Text: "third", Offset: 2 * time.Second},
+		{Text: "fourth", Offset: 3 * time.Second},
+		{Text: "fifth", Offset: 4 * time.Second},
+	}}
+	if err := SeedSession(server.store, spec); err != nil {
+		t.Fatalf("Failed to seed: %v", err)
+	}
+
+	get := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var body map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+
+	var texts []string
+	after := ""
+	for pages := 1; ; pages++ {
+		code, body := get("/api/v2/sessions/s1/prompts?limit=2&after=" + url.QueryEscape(after))
+		if code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", code)
+		}
+		for _, prompt := range body["prompts"].([]interface{}) {
+			texts = append(texts, prompt.(map[string]interface{})["prompt_text"].(string))
+		}
+		if body["next_cursor"] == nil {
+			if pages != 3 {
+				t.Errorf("Expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		after = body["next_cursor"].(string)
+	}
+	if len(texts) != 5 || texts[0] != "first" || texts[4] != "fifth" {
+		t.Errorf("Expected all 5 prompts oldest first, got %v", texts)
+	}
+
+	if code, _ := get("/api/v2/sessions/s1/prompts?after=not-a-cursor"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid cursor, got %d", code)
+	}
+}
+
+func TestSearchPrompts(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_search_prompts.db")
+	start := time.Now().Truncate(time.Second).Add(-time.Hour)
+
+	for _, spec := range []SessionSpec{
+		{SessionID: "s1", UserID: "u1", OrganizationID: "o1", StartTime: start, Duration: time.Hour, Prompts: []PromptSpec{
+			{Text: "Fix the flaky test"},
+			{Text: "Explain 100% coverage", Offset: time.Minute},
+		}},
+		{SessionID: "s2", UserID: "u2", OrganizationID: "o1", StartTime: start.Add(time.Minute), Duration: time.Hour, Prompts: []PromptSpec{
+			{Text: "Write a test for the parser", Offset: time.Minute},
+			{Text: "Rename the package"},
+		}},
+	} {
+		if err := SeedSession(server.store, spec); err != nil {
+			t.Fatalf("Failed to seed %s: %v", spec.SessionID, err)
+		}
+	}
+
+	search := func(query string) (int, []map[string]interface{}, interface{}) {
+		rec := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/prompts/search?"+query, nil))
+		var body struct {
+			Prompts    []map[string]interface{} `json:"prompts"`
+			NextCursor interface{}              `json:"next_cursor"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body.Prompts, body.NextCursor
+	}
+
+	// Matching ignores case and lists the newest first
+	code, prompts, next := search("q=TEST")
+	if code != http.StatusOK || len(prompts) != 2 || prompts[0]["session_id"] != "s2" || prompts[1]["user_id"] != "u1" || next != nil {
+		t.Fatalf("Expected the 2 test prompts newest first, got %d: %v", code, prompts)
+	}
+
+	code, prompts, _ = search("q=test&user_id=u1")
+	if code != http.StatusOK || len(prompts) != 1 || prompts[0]["prompt_text"] != "Fix the flaky test" {
+		t.Errorf("Expected only u1's prompt, got %d: %v", code, prompts)
+	}
+
+	// Wildcards match literally
+	if _, prompts, _ = search("q=" + url.QueryEscape("%")); len(prompts) != 1 || prompts[0]["prompt_text"] != "Explain 100% coverage" {
+		t.Errorf("Expected only the prompt with a percent sign, got %v", prompts)
+	}
+
+	code, prompts, next = search("q=the&limit=2")
+	if code != http.StatusOK || len(prompts) != 2 || next == nil {
+		t.Fatalf("Expected a full first page with a cursor, got %d: %v", code, prompts)
+	}
+	if _, prompts, next = search("q=the&limit=2&before=" + url.QueryEscape(next.(string))); len(prompts) != 1 || next != nil {
+		t.Errorf("Expected the last prompt on the second page, got %v", prompts)
+	}
+
+	if code, _, _ := search("q="); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without q, got %d", code)
+	}
+}
@@ -0,0 +1,184 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// publicSummaryTTL is how long a public summary is served before it is
+// recomputed, and how long clients and proxies may cache it
+const publicSummaryTTL = 5 * time.Minute
+
+// publicFields are the aggregates /api/public/summary can serve, each its own
+// SQL expression over the sessions started in the window. Only allowlisted
+// fields are queried, so nothing else ever reaches the response.
+var publicFields = map[string]string{
+	"sessions":     "COUNT(*)",
+	"tool_calls":   "COALESCE(SUM(tool_call_count), 0)",
+	"api_requests": "COALESCE(SUM(api_request_count), 0)",
+	"prompts":      "COALESCE(SUM(user_prompt_count), 0)",
+	"tokens":       "COALESCE(SUM(total_input_tokens + total_output_tokens), 0)",
+	"users":        "COUNT(DISTINCT user_id)",
+	"models":       "COUNT(DISTINCT NULLIF(primary_model, ''))",
+	"cost_usd":     "COALESCE(SUM(total_cost_usd), 0)",
+}
+
+// publicSummary serves the allowlisted aggregates, cached per window, with
+// recomputations rate limited so a busy status page can't load the database
+type publicSummary struct {
+	fields []string
+
+	mu      sync.Mutex
+	now     func() time.Time
+	cache   map[string]publicSummaryEntry // window type -> response
+	rate    float64
+	tokens  float64
+	last    time.Time
+	limited bool
+}
+
+type publicSummaryEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+// SetPublicSummary enables the unauthenticated /api/public/summary with only
+// the given fields, out of sessions, tool_calls, api_requests, prompts,
+// tokens, users, models and cost_usd, recomputed at most requestsPerSecond
+// times a second. No fields leaves it disabled.
+func (s *APIServer) SetPublicSummary(fields []string, requestsPerSecond int) error {
+	if len(fields) == 0 {
+		s.publicSummary = nil
+		return nil
+	}
+	for _, field := range fields {
+		if _, ok := publicFields[field]; !ok {
+			known := make([]string, 0, len(publicFields))
+			for name := range publicFields {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown public summary field %q (use %s)", field, strings.Join(known, ", "))
+		}
+	}
+
+	s.publicSummary = &publicSummary{
+		fields:  fields,
+		now:     time.Now,
+		cache:   make(map[string]publicSummaryEntry),
+		rate:    float64(requestsPerSecond),
+		limited: requestsPerSecond > 0,
+		tokens:  math.Max(1, float64(requestsPerSecond)),
+	}
+	return nil
+}
+
+// allow takes a token from the endpoint's recompute bucket, reporting how long until
+// the next one when it is empty
+func (p *publicSummary) allow() (bool, time.Duration) {
+	if !p.limited {
+		return true, 0
+	}
+
+	now := p.now()
+	if !p.last.IsZero() {
+		p.tokens = math.Min(math.Max(1, p.rate), p.tokens+now.Sub(p.last).Seconds()*p.rate)
+	}
+	p.last = now
+	if p.tokens >= 1 {
+		p.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - p.tokens) / p.rate * float64(time.Second))
+}
+
+// GetPublicSummary computes the given aggregates over the sessions started in
+// the window. Fields must be keys of publicFields.
+func (s *Store) GetPublicSummary(fields []string, window TimeWindow) (map[string]interface{}, error) {
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i] = publicFields[field]
+	}
+	query := `SELECT ` + strings.Join(columns, ", ") + ` FROM sessions WHERE start_time >= ? AND start_time < ?`
+
+	values := make([]interface{}, len(fields))
+	dest := make([]interface{}, len(fields))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	start, end := windowBounds(window)
+	if err := s.queryRow(queryGetPublicSummary, query, start, end).Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	summary := make(map[string]interface{}, len(fields))
+	for i, field := range fields {
+		summary[field] = values[i]
+	}
+	return summary, nil
+}
+
+// handlePublicSummary handles GET /api/public/summary?window=7d|30d|all-time
+func (s *APIServer) handlePublicSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := s.publicSummary
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Custom windows would make the cache unbounded
+	now := p.now()
+	window, err := parseTimeWindow(r, now)
+	if err == nil && window.Type == "custom" {
+		err = fmt.Errorf("custom windows are not available publicly")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only recomputing costs a query, so cached summaries are served to
+	// everyone and the bucket limits misses alone
+	entry, cached := p.cache[window.Type]
+	if !cached || !now.Before(entry.expires) {
+		if allowed, wait := p.allow(); !allowed {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Max(1, math.Ceil(wait.Seconds())))))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		summary, err := s.store.GetPublicSummary(p.fields, window)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving public summary: %v", err), http.StatusInternalServerError)
+			return
+		}
+		summary["window"] = window.Type
+		summary["generated_at"] = now.UTC().Format(time.RFC3339)
+
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(summary)
+		entry = publicSummaryEntry{body: body.Bytes(), expires: now.Add(publicSummaryTTL)}
+		p.cache[window.Type] = entry
+	}
+
+	maxAge := int(entry.expires.Sub(now).Seconds())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	w.Header().Set("Expires", entry.expires.UTC().Format(http.TimeFormat))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(entry.body)
+}
@@ -0,0 +1,150 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+// getPublicSummary requests the public summary, decoding the body
+func getPublicSummary(t *testing.T, server *APIServer, query string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	server.handlePublicSummary(rec, httptest.NewRequest(http.MethodGet, "/api/public/summary"+query, nil))
+	var body map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&body)
+	return rec, body
+}
+
+func seedPublicSessions(t *testing.T, server *APIServer, start time.Time, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		if err := SeedSession(server.store, SessionSpec{
+			SessionID: fmt.Sprintf("public-session-%d-%d", start.Unix(), i), OrganizationID: "org-secret", UserID: fmt.Sprintf("user-%d", i%2),
+			StartTime: start.Add(time.Duration(i) * time.Minute),
+			Models:    []ModelSpec{{Model: "claude-sonnet-4-5", Requests: 4, CostUSD: 2.5}},
+			Tools:     []ToolSpec{{Name: "Bash", Successes: 10}},
+		}); err != nil {
+			t.Fatalf("Failed to seed session: %v", err)
+		}
+	}
+}
+
+func TestPublicSummaryDisabledByDefault(t *testing.T) {
+	server := newTestAPIServer(t, "./test_public_disabled.db")
+
+	rec, _ := getPublicSummary(t, server, "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 while disabled, got %d", rec.Code)
+	}
+
+	if err := server.SetPublicSummary([]string{"sessions", "session_ids"}, 5); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+	if rec, _ := getPublicSummary(t, server, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an invalid allowlist to leave the endpoint disabled, got %d", rec.Code)
+	}
+}
+
+func TestPublicSummaryServesOnlyAllowedFields(t *testing.T) {
+	server := newTestAPIServer(t, "./test_public_allowlist.db")
+	now := time.Now()
+	seedPublicSessions(t, server, now.Add(-24*time.Hour), 3)
+	seedPublicSessions(t, server, now.AddDate(0, 0, -20), 2)
+
+	if err := server.SetPublicSummary([]string{"sessions", "tool_calls"}, 0); err != nil {
+		t.Fatalf("Failed to enable public summary: %v", err)
+	}
+
+	rec, body := getPublicSummary(t, server, "?window=7d")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	keys := make([]string, 0, len(body))
+	for key := range body {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if fmt.Sprint(keys) != "[generated_at sessions tool_calls window]" {
+		t.Errorf("Expected only the allowlisted fields, got %v", keys)
+	}
+	if body["sessions"] != 3.0 || body["tool_calls"] != 30.0 || body["window"] != "7d" {
+		t.Errorf("Unexpected summary: %v", body)
+	}
+
+	// Sensitive aggregates are served only when allowlisted themselves
+	if err := server.SetPublicSummary([]string{"sessions", "users", "cost_usd"}, 0); err != nil {
+		t.Fatalf("Failed to enable public summary: %v", err)
+	}
+	_, body = getPublicSummary(t, server, "?window=30d")
+	if body["sessions"] != 5.0 || body["users"] != 2.0 || body["cost_usd"] != 12.5 || body["tool_calls"] != nil {
+		t.Errorf("Unexpected summary: %v", body)
+	}
+
+	if rec, _ := getPublicSummary(t, server, "?window=custom&start=2025-01-01T00:00:00Z&end=2025-02-01T00:00:00Z"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a custom window, got %d", rec.Code)
+	}
+}
+
+func TestPublicSummaryCachingAndRateLimit(t *testing.T) {
+	server := newTestAPIServer(t, "./test_public_cache.db")
+	clock := time.Now()
+	seedPublicSessions(t, server, clock.Add(-time.Hour), 1)
+
+	if err := server.SetPublicSummary([]string{"sessions"}, 2); err != nil {
+		t.Fatalf("Failed to enable public summary: %v", err)
+	}
+	server.publicSummary.now = func() time.Time { return clock }
+
+	rec, body := getPublicSummary(t, server, "?window=7d")
+	if rec.Code != http.StatusOK || body["sessions"] != 1.0 {
+		t.Fatalf("Unexpected response %d: %v", rec.Code, body)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=300" {
+		t.Errorf("Expected Cache-Control public, max-age=300, got %q", got)
+	}
+	if got := rec.Header().Get("Expires"); got != clock.Add(publicSummaryTTL).UTC().Format(http.TimeFormat) {
+		t.Errorf("Unexpected Expires %q", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("Expected the summary to be readable cross-origin")
+	}
+
+	// New sessions don't show until the cached summary expires, and the
+	// max-age counts down meanwhile
+	seedPublicSessions(t, server, clock.Add(-30*time.Minute), 4)
+	clock = clock.Add(2 * time.Minute)
+	rec, body = getPublicSummary(t, server, "?window=7d")
+	if body["sessions"] != 1.0 || rec.Header().Get("Cache-Control") != "public, max-age=180" {
+		t.Errorf("Expected the cached summary with 180s left, got %v and %q", body, rec.Header().Get("Cache-Control"))
+	}
+
+	// Cached summaries don't draw on the bucket
+	for i := 0; i < 5; i++ {
+		if rec, _ := getPublicSummary(t, server, "?window=7d"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected cached request %d to be served, got %d", i, rec.Code)
+		}
+	}
+
+	// Once the cache expires, recomputing draws on the bucket of two
+	clock = clock.Add(5 * time.Minute)
+	rec, body = getPublicSummary(t, server, "?window=7d")
+	if rec.Code != http.StatusOK || body["sessions"] != 5.0 {
+		t.Errorf("Expected a fresh summary of 5 sessions after expiry, got %d %v", rec.Code, body)
+	}
+	if rec, _ := getPublicSummary(t, server, "?window=30d"); rec.Code != http.StatusOK {
+		t.Errorf("Expected the second token of the bucket to be served, got %d", rec.Code)
+	}
+	rec, _ = getPublicSummary(t, server, "?window=all-time")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected status 429 with Retry-After 1, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec, _ := getPublicSummary(t, server, "?window=7d"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a cached summary to be served while limited, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,422 @@
+package aggregator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSlowQueryThreshold is how long a statement may run before it is
+// logged as slow
+const DefaultSlowQueryThreshold = 500 * time.Millisecond
+
+// Query names, one per Store method, under which QueryStats reports each
+// method's statements
+const (
+	queryApplyLegacyFixes            = "applyLegacyFixes"
+	queryClaimExportJob              = "ClaimExportJob"
+	queryCompleteExportJob           = "CompleteExportJob"
+	queryComputeOrgStats             = "ComputeOrgStats"
+	queryComputeOrgTopUsersByCost    = "ComputeOrgTopUsersByCost"
+	queryComputeOrgTopUsersByUsage   = "ComputeOrgTopUsersByUsage"
+	queryComputeUserModels           = "ComputeUserModels"
+	queryComputeUserStats            = "ComputeUserStats"
+	queryComputeUserTools            = "ComputeUserTools"
+	queryCountOrgActiveUsers         = "CountOrgActiveUsers"
+	queryCreateExportJob             = "CreateExportJob"
+	queryDeleteExportJob             = "DeleteExportJob"
+	queryExportSessions              = "ExportSessions"
+	queryFailExportJob               = "FailExportJob"
+	queryFindUnapprovedModels        = "FindUnapprovedModels"
+	queryForgetArchive               = "ForgetArchive"
+	queryGetAPIKeyIdentities         = "GetAPIKeyIdentities"
+	queryGetAllSessions              = "GetAllSessions"
+	queryGetDailyUsageByUser         = "GetDailyUsageByUser"
+	queryGetExpiredExportJobs        = "GetExpiredExportJobs"
+	queryGetExportJob                = "GetExportJob"
+	queryGetImportedUsageTotals      = "GetImportedUsageTotals"
+	queryGetModelAggregates          = "GetModelAggregates"
+	queryGetModelComparison          = "GetModelComparison"
+	queryGetModelSessionCounts       = "GetModelSessionCounts"
+	queryGetOrgModelCacheUsage       = "GetOrgModelCacheUsage"
+	queryGetOrgModelInventory        = "GetOrgModelInventory"
+	queryGetOrgRollupCosts           = "GetOrgRollupCosts"
+	queryGetOrgSessionHours          = "GetOrgSessionHours"
+	queryGetOrgSessionSpans          = "GetOrgSessionSpans"
+	queryGetOrgStats                 = "GetOrgStats"
+	queryGetOrgUserCosts             = "GetOrgUserCosts"
+	queryGetOrgUserLastActivity      = "GetOrgUserLastActivity"
+	queryGetProcessingState          = "GetProcessingState"
+	queryGetPublicSummary            = "GetPublicSummary"
+	queryGetServiceBreakdown         = "GetServiceBreakdown"
+	queryGetSession                  = "GetSession"
+	queryGetSessionBatches           = "GetSessionBatches"
+	queryGetSessionDurationHistogram = "GetSessionDurationHistogram"
+	queryGetSessionFileRanges        = "GetSessionFileRanges"
+	queryGetSessionIDsInWindow       = "GetSessionIDsInWindow"
+	queryGetSessionModels            = "GetSessionModels"
+	queryGetSessionPrompts           = "GetSessionPrompts"
+	queryGetSessionTools             = "GetSessionTools"
+	queryGetSessionTraces            = "GetSessionTraces"
+	queryGetSessionTurns             = "GetSessionTurns"
+	queryGetSessionsByOrg            = "GetSessionsByOrg"
+	queryGetSessionsByUser           = "GetSessionsByUser"
+	queryGetTimeseries               = "GetTimeseries"
+	queryGetToolAggregates           = "GetToolAggregates"
+	queryGetToolCallCounts           = "GetToolCallCounts"
+	queryGetToolCallTotals           = "GetToolCallTotals"
+	queryGetTopPrompts               = "GetTopPrompts"
+	queryGetUsageTotals              = "GetUsageTotals"
+	queryGetUserDayCost              = "GetUserDayCost"
+	queryGetUserStats                = "GetUserStats"
+	queryGetVerifiedTotals           = "getVerifiedTotals"
+	queryInsertAnomaly               = "InsertAnomaly"
+	queryInsertSessionPrompt         = "InsertSessionPrompt"
+	queryInsertSessionPrompts        = "InsertSessionPrompts"
+	queryIsArchiveProcessed          = "IsArchiveProcessed"
+	queryMarkArchiveProcessed        = "MarkArchiveProcessed"
+	queryPing                        = "Ping"
+	queryPruneOrgStats               = "PruneOrgStats"
+	queryPruneSessionFileRanges      = "PruneSessionFileRanges"
+	queryPruneUserStats              = "PruneUserStats"
+	queryRepairToolNames             = "RepairToolNames"
+	queryRequeueRunningExportJobs    = "RequeueRunningExportJobs"
+	querySearchPrompts               = "SearchPrompts"
+	queryStreamSessions              = "StreamSessions"
+	queryUpdateProcessingState       = "UpdateProcessingState"
+	queryUpsertAPIKeyIdentity        = "UpsertAPIKeyIdentity"
+	queryUpsertDailyUsage            = "UpsertDailyUsage"
+	queryUpsertOrgStats              = "UpsertOrgStats"
+	queryUpsertSession               = "UpsertSession"
+	queryUpsertSessionBatches        = "UpsertSessionBatches"
+	queryUpsertSessionFileRanges     = "UpsertSessionFileRanges"
+	queryUpsertSessionModel          = "UpsertSessionModel"
+	queryUpsertSessionRollups        = "UpsertSessionRollups"
+	queryUpsertSessionTool           = "UpsertSessionTool"
+	queryUpsertSessionTraces         = "UpsertSessionTraces"
+	queryUpsertSessionTurn           = "UpsertSessionTurn"
+	queryUpsertUserStats             = "UpsertUserStats"
+)
+
+// queryRunner runs statements on the database or within a transaction
+type queryRunner interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// QueryStat summarises the executions of one named query
+type QueryStat struct {
+	Name      string
+	Count     int64
+	Errors    int64
+	Slow      int64
+	TotalTime time.Duration
+	MaxTime   time.Duration
+}
+
+// queryLog times every statement the store runs, keeping per-name stats and
+// logging those that run past its slow threshold
+type queryLog struct {
+	mu            sync.Mutex
+	stats         map[string]*QueryStat
+	slowThreshold time.Duration
+	timeout       time.Duration
+	explain       bool
+}
+
+func newQueryLog() *queryLog {
+	return &queryLog{
+		stats:         make(map[string]*QueryStat),
+		slowThreshold: DefaultSlowQueryThreshold,
+	}
+}
+
+// SetSlowQueryThreshold logs statements that take longer than threshold;
+// zero or less stops slow-query logging
+func (s *Store) SetSlowQueryThreshold(threshold time.Duration) {
+	s.queries.mu.Lock()
+	defer s.queries.mu.Unlock()
+	s.queries.slowThreshold = threshold
+}
+
+// SetQueryTimeout interrupts a statement that has not produced its first
+// result within timeout; zero or less lets statements run indefinitely.
+// Reading the rows of a query that started in time is not limited, and a
+// statement waiting on another connection's lock waits out SQLite's busy
+// timeout regardless.
+func (s *Store) SetQueryTimeout(timeout time.Duration) {
+	s.queries.mu.Lock()
+	defer s.queries.mu.Unlock()
+	s.queries.timeout = timeout
+}
+
+// SetExplainSlowQueries also logs the EXPLAIN QUERY PLAN of each slow statement
+func (s *Store) SetExplainSlowQueries(enabled bool) {
+	s.queries.mu.Lock()
+	defer s.queries.mu.Unlock()
+	s.queries.explain = enabled
+}
+
+// QueryStats returns a snapshot of the stats of every query run so far,
+// sorted by name
+func (s *Store) QueryStats() []QueryStat {
+	s.queries.mu.Lock()
+	defer s.queries.mu.Unlock()
+	stats := make([]QueryStat, 0, len(s.queries.stats))
+	for _, stat := range s.queries.stats {
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// queryTiming times one execution of a named statement
+type queryTiming struct {
+	store     *Store
+	on        queryRunner
+	name      string
+	query     string
+	args      []interface{}
+	start     time.Time
+	timer     *time.Timer
+	threshold time.Duration
+	explain   bool
+	elapsed   time.Duration
+	stopped   bool
+	slow      bool
+	reported  bool
+}
+
+// startQuery begins timing the statement query, named name, which runs with
+// the returned context so the query timeout can interrupt it. args are only
+// used to explain a slow statement and are never logged.
+func (s *Store) startQuery(on queryRunner, name, query string, args []interface{}) (context.Context, *queryTiming) {
+	s.queries.mu.Lock()
+	timeout, threshold, explain := s.queries.timeout, s.queries.slowThreshold, s.queries.explain
+	s.queries.mu.Unlock()
+
+	timing := &queryTiming{store: s, on: on, name: name, query: query, args: args, threshold: threshold, explain: explain}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		timing.timer = time.AfterFunc(timeout, cancel)
+	}
+	timing.start = time.Now()
+	return ctx, timing
+}
+
+// stop ends the timing once the statement has produced its first result and
+// adds it to the query's stats; only the first call counts
+func (t *queryTiming) stop(err error) {
+	if t.stopped {
+		return
+	}
+	t.stopped = true
+	t.elapsed = time.Since(t.start)
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.slow = t.threshold > 0 && t.elapsed > t.threshold
+	t.store.queries.record(t.name, t.elapsed, err, t.slow)
+	t.store.availability.observe(err)
+}
+
+// report logs the statement once if it was slow, with its plan when
+// explaining is enabled. It runs once the statement's rows are closed, so
+// explaining doesn't wait on the connection they hold.
+func (t *queryTiming) report() {
+	if !t.slow || t.reported {
+		return
+	}
+	t.reported = true
+	log.Printf("Slow query %s took %v (%d params): %s", t.name, t.elapsed.Round(time.Millisecond), len(t.args), compactSQL(t.query))
+	if t.explain {
+		plan, err := explainQuery(t.on, t.query, t.args)
+		if err != nil {
+			log.Printf("Slow query %s: failed to explain: %v", t.name, err)
+			return
+		}
+		log.Printf("Slow query %s plan:\n%s", t.name, plan)
+	}
+}
+
+// record adds one execution of the query named name to its stats
+func (q *queryLog) record(name string, elapsed time.Duration, err error, slow bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stat, exists := q.stats[name]
+	if !exists {
+		stat = &QueryStat{Name: name}
+		q.stats[name] = stat
+	}
+	stat.Count++
+	stat.TotalTime += elapsed
+	if elapsed > stat.MaxTime {
+		stat.MaxTime = elapsed
+	}
+	if err != nil && err != sql.ErrNoRows {
+		stat.Errors++
+	}
+	if slow {
+		stat.Slow++
+	}
+}
+
+// timedRows is a query's rows, whose timing stops when the first row is
+// read; reading the rest isn't counted, so streaming a large result to a slow
+// client doesn't make its query look slow
+type timedRows struct {
+	*sql.Rows
+	timing *queryTiming
+}
+
+// Next advances to the next row, stopping the query's timing on the first
+func (r *timedRows) Next() bool {
+	next := r.Rows.Next()
+	r.timing.stop(r.Rows.Err())
+	return next
+}
+
+// Close closes the rows, then reports the query if it was slow
+func (r *timedRows) Close() error {
+	r.timing.stop(nil)
+	err := r.Rows.Close()
+	r.timing.report()
+	return err
+}
+
+// timedRow is a single-row query, whose timing stops when it is scanned
+type timedRow struct {
+	row    *sql.Row
+	timing *queryTiming
+}
+
+// Scan copies the row's columns into dest like sql.Row.Scan
+func (r *timedRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	r.timing.stop(err)
+	r.timing.report()
+	return err
+}
+
+// exec runs a statement named name that returns no rows
+func (s *Store) exec(name, query string, args ...interface{}) (sql.Result, error) {
+	return s.execOn(s.db, name, query, args...)
+}
+
+// execOn runs a statement named name that returns no rows on a database or transaction
+func (s *Store) execOn(on queryRunner, name, query string, args ...interface{}) (sql.Result, error) {
+	ctx, timing := s.startQuery(on, name, query, args)
+	result, err := on.ExecContext(ctx, query, args...)
+	timing.stop(err)
+	timing.report()
+	return result, err
+}
+
+// execStmt runs the prepared statement stmt, prepared from query, under name
+func (s *Store) execStmt(tx *sql.Tx, stmt *sql.Stmt, name, query string, args ...interface{}) (sql.Result, error) {
+	ctx, timing := s.startQuery(tx, name, query, args)
+	result, err := stmt.ExecContext(ctx, args...)
+	timing.stop(err)
+	timing.report()
+	return result, err
+}
+
+// query runs a query named name that returns rows
+func (s *Store) query(name, query string, args ...interface{}) (*timedRows, error) {
+	return s.queryOn(s.db, name, query, args...)
+}
+
+// queryOn runs a query named name that returns rows on a database or transaction
+func (s *Store) queryOn(on queryRunner, name, query string, args ...interface{}) (*timedRows, error) {
+	ctx, timing := s.startQuery(on, name, query, args)
+	rows, err := on.QueryContext(ctx, query, args...)
+	if err != nil {
+		timing.stop(err)
+		timing.report()
+		return nil, err
+	}
+	return &timedRows{Rows: rows, timing: timing}, nil
+}
+
+// queryRow runs a query named name that returns at most one row
+func (s *Store) queryRow(name, query string, args ...interface{}) *timedRow {
+	ctx, timing := s.startQuery(s.db, name, query, args)
+	return &timedRow{row: s.db.QueryRowContext(ctx, query, args...), timing: timing}
+}
+
+// compactSQL collapses a statement's whitespace onto one line for logging.
+// Parameters stay as placeholders, so no values are logged.
+func compactSQL(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// explainQuery returns SQLite's plan for query, one step per line
+func explainQuery(on queryRunner, query string, args []interface{}) (string, error) {
+	rows, err := on.QueryContext(context.Background(), "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("  %d %d %s", id, parent, detail))
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// handleAdminDB handles GET /api/admin/db, the latency stats of every named
+// store query
+func (s *APIServer) handleAdminDB(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	stats := s.store.QueryStats()
+	queries := make([]map[string]interface{}, 0, len(stats))
+	for _, stat := range stats {
+		var avgMS float64
+		if stat.Count > 0 {
+			avgMS = float64(stat.TotalTime.Microseconds()) / 1000 / float64(stat.Count)
+		}
+		queries = append(queries, map[string]interface{}{
+			"name":          stat.Name,
+			"count":         stat.Count,
+			"errors":        stat.Errors,
+			"slow_count":    stat.Slow,
+			"total_time_ms": float64(stat.TotalTime.Microseconds()) / 1000,
+			"avg_time_ms":   avgMS,
+			"max_time_ms":   float64(stat.MaxTime.Microseconds()) / 1000,
+		})
+	}
+
+	s.store.queries.mu.Lock()
+	threshold, timeout := s.store.queries.slowThreshold, s.store.queries.timeout
+	s.store.queries.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"slow_query_threshold_ms": threshold.Milliseconds(),
+		"query_timeout_ms":        timeout.Milliseconds(),
+		"queries":                 queries,
+	})
+}
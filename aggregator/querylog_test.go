@@ -0,0 +1,155 @@
+package aggregator
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// holdWriteLock takes the database's write lock from a second connection,
+// releasing it after hold
+func holdWriteLock(t *testing.T, dbPath string, hold time.Duration) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open second connection: %v", err)
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to open second connection: %v", err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "BEGIN IMMEDIATE"); err != nil {
+		t.Fatalf("Failed to take the write lock: %v", err)
+	}
+	go func() {
+		time.Sleep(hold)
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		conn.Close()
+		db.Close()
+	}()
+}
+
+func TestStoreLogsSlowQueries(t *testing.T) {
+	server := newTestAPIServer(t, "./test_slow_queries.db")
+	server.SetRawAccess(t.TempDir(), DefaultInputFiles, "admin-secret")
+	store := server.store
+	store.SetSlowQueryThreshold(100 * time.Millisecond)
+	store.SetExplainSlowQueries(true)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	// A fast query first, then one stuck behind another connection's lock
+	if _, err := store.IsArchiveProcessed("metrics.jsonl", 1); err != nil {
+		t.Fatalf("Failed to check archive: %v", err)
+	}
+	holdWriteLock(t, "./test_slow_queries.db", 300*time.Millisecond)
+	if err := store.MarkArchiveProcessed("secret-file.jsonl", 1); err != nil {
+		t.Fatalf("Failed to mark archive: %v", err)
+	}
+
+	output := logs.String()
+	if !strings.Contains(output, "Slow query MarkArchiveProcessed took") ||
+		!strings.Contains(output, "INSERT INTO processed_archives (file_name, inode, processed_at) VALUES (?, ?, ?)") {
+		t.Errorf("Expected a slow-query entry for MarkArchiveProcessed, got:\n%s", output)
+	}
+	if strings.Contains(output, "secret-file.jsonl") {
+		t.Errorf("Expected parameters redacted from the log, got:\n%s", output)
+	}
+	if !strings.Contains(output, "Slow query MarkArchiveProcessed plan:") {
+		t.Errorf("Expected the slow query's plan logged, got:\n%s", output)
+	}
+	if strings.Contains(output, "IsArchiveProcessed") {
+		t.Errorf("Expected the fast query left out of the log, got:\n%s", output)
+	}
+
+	stats := make(map[string]QueryStat)
+	for _, stat := range store.QueryStats() {
+		stats[stat.Name] = stat
+	}
+	if stat := stats[queryMarkArchiveProcessed]; stat.Count != 1 || stat.Slow != 1 || stat.MaxTime < 100*time.Millisecond {
+		t.Errorf("Expected 1 slow MarkArchiveProcessed, got %+v", stat)
+	}
+	if stat := stats[queryIsArchiveProcessed]; stat.Count != 1 || stat.Slow != 0 {
+		t.Errorf("Expected 1 fast IsArchiveProcessed, got %+v", stat)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/db", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	w := httptest.NewRecorder()
+	server.handleAdminDB(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		SlowQueryThresholdMS int64 `json:"slow_query_threshold_ms"`
+		Queries              []struct {
+			Name      string  `json:"name"`
+			Count     int64   `json:"count"`
+			SlowCount int64   `json:"slow_count"`
+			MaxTimeMS float64 `json:"max_time_ms"`
+		} `json:"queries"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.SlowQueryThresholdMS != 100 {
+		t.Errorf("Expected a threshold of 100ms, got %d", response.SlowQueryThresholdMS)
+	}
+	found := false
+	for _, q := range response.Queries {
+		if q.Name == queryMarkArchiveProcessed {
+			found = true
+			if q.Count != 1 || q.SlowCount != 1 || q.MaxTimeMS < 100 {
+				t.Errorf("Unexpected MarkArchiveProcessed stats: %+v", q)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected MarkArchiveProcessed in %s", w.Body.String())
+	}
+}
+
+func TestStoreQueryTimeout(t *testing.T) {
+	dbPath := "./test_query_timeout.db"
+	defer os.Remove(dbPath)
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	store.SetQueryTimeout(100 * time.Millisecond)
+
+	// Counting to a billion takes far longer than the timeout
+	start := time.Now()
+	var count int64
+	err = store.queryRow("CountForever", `
+	WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000000000)
+	SELECT COUNT(*) FROM n
+	`).Scan(&count)
+	if err == nil {
+		t.Errorf("Expected the statement interrupted, got a count of %d", count)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the timeout to interrupt the statement, took %v", elapsed)
+	}
+	for _, stat := range store.QueryStats() {
+		if stat.Name == "CountForever" && stat.Errors != 1 {
+			t.Errorf("Expected the interrupted statement counted as an error, got %+v", stat)
+		}
+	}
+
+	// A statement that finishes in time is unaffected
+	if err := store.MarkArchiveProcessed("metrics.jsonl", 1); err != nil {
+		t.Errorf("Expected a fast statement to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package aggregator
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// orgCostCacheTTL is how long per-org user costs are reused between requests
+const orgCostCacheTTL = 30 * time.Second
+
+// rankUserCost ranks a user's cost among their organization. A user missing
+// from costs, e.g. with no sessions in the window, is ranked with zero cost.
+func rankUserCost(costs []*UserCost, userID string) CostRank {
+	values := make([]float64, 0, len(costs)+1)
+	var userCost float64
+	found := false
+	for _, uc := range costs {
+		values = append(values, uc.CostUSD)
+		if uc.UserID == userID {
+			userCost = uc.CostUSD
+			found = true
+		}
+	}
+	if !found {
+		values = append(values, 0)
+	}
+	sort.Float64s(values)
+
+	rank := CostRank{
+		Rank:         1,
+		Users:        len(values),
+		CostUSD:      userCost,
+		OrgMedianUSD: median(values),
+	}
+	atOrBelow := 0
+	for _, v := range values {
+		if v > userCost {
+			rank.Rank++
+		} else {
+			atOrBelow++
+		}
+	}
+	rank.Percentile = 100 * float64(atOrBelow) / float64(len(values))
+	return rank
+}
+
+// median returns the middle of sorted values, averaging the two middle
+// values for an even count
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// orgCostCache briefly caches GetOrgUserCosts so a dashboard showing many
+// users of one org runs the ranking query once
+type orgCostCache struct {
+	mu      sync.Mutex
+	entries map[string]orgCostEntry
+	now     func() time.Time
+}
+
+type orgCostEntry struct {
+	costs   []*UserCost
+	expires time.Time
+}
+
+func newOrgCostCache() *orgCostCache {
+	return &orgCostCache{
+		entries: make(map[string]orgCostEntry),
+		now:     time.Now,
+	}
+}
+
+// get returns the org's user costs for a window, querying the store on a miss
+func (c *orgCostCache) get(store *Store, orgID string, window TimeWindow) ([]*UserCost, error) {
+	start, end := windowBounds(window)
+	key := orgID + "|" + window.Type
+	if window.Type == "custom" {
+		key += "|" + time.Unix(start, 0).UTC().Format(time.RFC3339) + "|" + time.Unix(end, 0).UTC().Format(time.RFC3339)
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.costs, nil
+	}
+
+	costs, err := store.GetOrgUserCosts(orgID, window)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[key] = orgCostEntry{costs: costs, expires: c.now().Add(orgCostCacheTTL)}
+	c.mu.Unlock()
+	return costs, nil
+}
@@ -0,0 +1,96 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRankUserCostWithTies(t *testing.T) {
+	costs := []*UserCost{
+		{UserID: "alice", CostUSD: 10},
+		{UserID: "bob", CostUSD: 5},
+		{UserID: "carol", CostUSD: 5},
+		{UserID: "dave", CostUSD: 2},
+		{UserID: "erin", CostUSD: 0},
+	}
+
+	tests := []struct {
+		userID     string
+		rank       int
+		percentile float64
+	}{
+		{"alice", 1, 100},
+		{"bob", 2, 80},
+		{"carol", 2, 80},
+		{"dave", 4, 40},
+		{"erin", 5, 20},
+	}
+
+	for _, tt := range tests {
+		rank := rankUserCost(costs, tt.userID)
+		if rank.Rank != tt.rank || rank.Percentile != tt.percentile {
+			t.Errorf("%s: expected rank %d at %v%%, got rank %d at %v%%",
+				tt.userID, tt.rank, tt.percentile, rank.Rank, rank.Percentile)
+		}
+		if rank.Users != 5 {
+			t.Errorf("%s: expected 5 users, got %d", tt.userID, rank.Users)
+		}
+		if rank.OrgMedianUSD != 5 {
+			t.Errorf("%s: expected median 5, got %v", tt.userID, rank.OrgMedianUSD)
+		}
+	}
+
+	// A user without sessions in the window ranks last with zero cost
+	rank := rankUserCost(costs[:4], "erin")
+	if rank.Rank != 5 || rank.Users != 5 || rank.CostUSD != 0 {
+		t.Errorf("Expected an absent user ranked 5 of 5 with zero cost, got %+v", rank)
+	}
+	if got := median([]float64{2, 5, 5, 10}); got != 5 {
+		t.Errorf("Expected even-count median 5, got %v", got)
+	}
+}
+
+func TestUserStatsRelativeBlock(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_ranking.db")
+
+	start := time.Now().Add(-time.Hour)
+	for i, cost := range []float64{10, 5, 5, 2, 0} {
+		userID := fmt.Sprintf("user-%d", i)
+		sessionID := fmt.Sprintf("ranked-session-%d", i)
+		if err := SeedSession(server.store, SessionSpec{SessionID: sessionID, OrganizationID: "org-rank", UserID: userID,
+			StartTime: start, TotalCostUSD: cost}); err != nil {
+			t.Fatalf("Failed to seed session: %v", err)
+		}
+	}
+
+	getRelative := func() map[string]interface{} {
+		rec := httptest.NewRecorder()
+		server.handleUserStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/user/user-2?window=7d", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		relative, _ := body["relative"].(map[string]interface{})
+		return relative
+	}
+
+	relative := getRelative()
+	if relative == nil {
+		t.Fatal("Expected a relative block")
+	}
+	if relative["rank"] != 2.0 || relative["users"] != 5.0 || relative["percentile"] != 80.0 || relative["org_median_cost_usd"] != 5.0 {
+		t.Errorf("Unexpected relative block: %v", relative)
+	}
+
+	server.SetComparativeStatsOptOut([]string{"org-rank"})
+	if relative := getRelative(); relative != nil {
+		t.Errorf("Expected no relative block for an opted-out org, got %v", relative)
+	}
+}
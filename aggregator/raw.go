@@ -0,0 +1,171 @@
+package aggregator
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// maxRawResponseBytes caps the lines returned by /api/admin/raw
+	maxRawResponseBytes = 8 << 20
+
+	// maxRawScanBytes caps how much of the file one request reads
+	maxRawScanBytes = 512 << 20
+
+	// maxRawLineBytes matches the largest request the collector accepts by default
+	maxRawLineBytes = 16 << 20
+)
+
+// rawLineFilter is a recordHandler that notes whether any record of a line
+// falls in [from, to) and, when sessionID is set, belongs to that session.
+// Zero bounds are open.
+type rawLineFilter struct {
+	from, to  time.Time
+	sessionID string
+	matched   bool
+}
+
+func (f *rawLineFilter) match(timestamp time.Time, sessionID string) {
+	if f.sessionID != "" && sessionID != f.sessionID {
+		return
+	}
+	if !f.from.IsZero() && timestamp.Before(f.from) {
+		return
+	}
+	if !f.to.IsZero() && !timestamp.Before(f.to) {
+		return
+	}
+	f.matched = true
+}
+
+func (f *rawLineFilter) ProcessMetric(record *MetricRecord) {
+	f.match(record.Timestamp, record.SessionID)
+}
+
+func (f *rawLineFilter) ProcessLog(record *LogRecord) {
+	f.match(record.Timestamp, record.SessionID)
+}
+
+func (f *rawLineFilter) ProcessTrace(record *TraceRecord) {
+	f.match(record.Timestamp, record.SessionID)
+}
+
+// SetRawAccess enables the admin endpoints over the collector's output
+// directory, /api/admin/raw and /api/admin/verify, guarded by a bearer token.
+// Only the given files are read. Without a token the endpoints stay disabled.
+func (s *APIServer) SetRawAccess(dataDir string, files InputFiles, adminToken string) {
+	s.dataDir = dataDir
+	s.inputFiles = files
+	s.adminToken = adminToken
+}
+
+// authorizeAdmin checks the admin bearer token, writing the error response
+// and returning false when the request may not proceed
+func (s *APIServer) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+		return false
+	}
+	provided := []byte(r.Header.Get("Authorization"))
+	if subtle.ConstantTimeCompare(provided, []byte("Bearer "+s.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleAdminRaw handles GET /api/admin/raw?file=logs.jsonl&from=&to=&session_id=
+func (s *APIServer) handleAdminRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	file := query.Get("file")
+	signal, ok := s.inputFiles.signal(file)
+	if !ok {
+		http.Error(w, "file must be one of "+strings.Join(s.inputFiles.names(), ", "), http.StatusBadRequest)
+		return
+	}
+
+	filter := &rawLineFilter{sessionID: query.Get("session_id")}
+	for name, bound := range map[string]*time.Time{"from": &filter.from, "to": &filter.to} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %v", name, err), http.StatusBadRequest)
+				return
+			}
+			*bound = parsed
+		}
+	}
+
+	f, err := os.Open(filepath.Join(s.dataDir, file))
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("Error reading %s: %v", file, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Truncation is only known once the body is written, so it goes in a trailer
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Trailer", "X-Otis-Truncated")
+	if f == nil {
+		return
+	}
+	defer f.Close()
+
+	written, truncated, err := writeRawLines(w, &io.LimitedReader{R: f, N: maxRawScanBytes}, signal, filter)
+	if err != nil {
+		log.Printf("Error streaming %s: %v", file, err)
+	}
+	w.Header().Set("X-Otis-Truncated", fmt.Sprintf("%t", truncated))
+	log.Printf("API: Streamed %d bytes of raw %s", written, file)
+}
+
+// writeRawLines copies the lines of r that match filter to w, stopping before
+// a line that would exceed maxRawResponseBytes. Lines longer than
+// maxRawLineBytes are skipped, as the processor skips them. It reports
+// whether output or input was cut short.
+func writeRawLines(w io.Writer, r *io.LimitedReader, signal Signal, filter *rawLineFilter) (int, bool, error) {
+	scanner := newRawLineScanner(r, maxRawLineBytes)
+
+	written := 0
+	for scanner.Scan() {
+		if oversized, _ := scanner.oversized(); oversized {
+			continue
+		}
+		line, _ := trimLineEnd(scanner.Bytes())
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		filter.matched = false
+		if err := decodeLine(signal, line, filter); err != nil || !filter.matched {
+			continue
+		}
+
+		if written+len(line)+1 > maxRawResponseBytes {
+			return written, true, nil
+		}
+		n, err := io.WriteString(w, line+"\n")
+		written += n
+		if err != nil {
+			return written, false, err
+		}
+	}
+
+	return written, r.N <= 0, scanner.Err()
+}
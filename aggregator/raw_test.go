@@ -0,0 +1,127 @@
+package aggregator
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func rawLogLine(sessionID string, at time.Time) string {
+	return fmt.Sprintf(`{"receivedAt":%q,"data":{"resourceLogs":[{"resource":{"attributes":[{"key":"session.id","value":{"stringValue":%q}}]},`+
+		`"scopeLogs":[{"logRecords":[{"timeUnixNano":"%d","body":{"stringValue":"claude_code.user_prompt"}}]}]}]}}`,
+		at.Format(time.RFC3339Nano), sessionID, at.UnixNano())
+}
+
+func TestAdminRawReturnsOnlyLinesInRange(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_raw.db")
+	dataDir := t.TempDir()
+	server.SetRawAccess(dataDir, DefaultInputFiles, "admin-secret")
+
+	base := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	lines := []string{
+		rawLogLine("session-a", base),
+		rawLogLine("session-a", base.Add(time.Hour)),
+		rawLogLine("session-b", base.Add(time.Hour)),
+		rawLogLine("session-a", base.Add(2*time.Hour)),
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "logs.jsonl"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write logs: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"range", "from=2025-06-01T10:30:00Z&to=2025-06-01T11:30:00Z", lines[1:3]},
+		{"range and session", "from=2025-06-01T10:30:00Z&to=2025-06-01T11:30:00Z&session_id=session-a", lines[1:2]},
+		{"open end", "from=2025-06-01T11:00:00Z&session_id=session-a", []string{lines[1], lines[3]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/raw?file=logs.jsonl&"+tt.query, nil)
+			req.Header.Set("Authorization", "Bearer admin-secret")
+			rec := httptest.NewRecorder()
+			server.handleAdminRaw(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			want := strings.Join(tt.expected, "\n") + "\n"
+			if rec.Body.String() != want {
+				t.Errorf("Expected lines:\n%s\ngot:\n%s", want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminRawRequiresToken(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_raw_auth.db")
+
+	tests := []struct {
+		name       string
+		adminToken string
+		authHeader string
+		query      string
+		expected   int
+	}{
+		{"disabled", "", "Bearer anything", "file=logs.jsonl", http.StatusForbidden},
+		{"missing token", "admin-secret", "", "file=logs.jsonl", http.StatusUnauthorized},
+		{"wrong token", "admin-secret", "Bearer wrong", "file=logs.jsonl", http.StatusUnauthorized},
+		{"unknown file", "admin-secret", "Bearer admin-secret", "file=../otis.db", http.StatusBadRequest},
+		{"missing file", "admin-secret", "Bearer admin-secret", "file=traces.jsonl", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.SetRawAccess(t.TempDir(), DefaultInputFiles, tt.adminToken)
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/raw?"+tt.query, nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			rec := httptest.NewRecorder()
+			server.handleAdminRaw(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAdminRawSkipsOversizedLines(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_raw_oversized.db")
+	dataDir := t.TempDir()
+	server.SetRawAccess(dataDir, DefaultInputFiles, "admin-secret")
+
+	base := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	lines := []string{
+		rawLogLine("session-a", base),
+		`{"data":"` + strings.Repeat("x", maxRawLineBytes) + `"}`,
+		rawLogLine("session-a", base.Add(time.Hour)),
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "logs.jsonl"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write logs: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/raw?file=logs.jsonl&session_id=session-a", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rec := httptest.NewRecorder()
+	server.handleAdminRaw(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if want := lines[0] + "\n" + lines[2] + "\n"; rec.Body.String() != want {
+		t.Errorf("Expected the lines around the oversized one, got %d bytes", rec.Body.Len())
+	}
+	if got := rec.Result().Trailer.Get("X-Otis-Truncated"); got != "false" {
+		t.Errorf("Expected X-Otis-Truncated false, got %q", got)
+	}
+}
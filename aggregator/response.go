@@ -0,0 +1,46 @@
+package aggregator
+
+import "time"
+
+// API responses treat absent and undefined values the same way everywhere,
+// so a client can tell "none" from a real zero:
+//
+//   - Every documented field is present; none is left out for being empty
+//   - A time that hasn't happened, such as the end of an open session, is null
+//   - A string that was never reported, such as a session's terminal type,
+//     is null rather than ""
+//   - An average or rate with nothing to divide by is 0, never NaN or Inf,
+//     which encoding/json refuses to marshal
+
+// jsonTime formats t as RFC3339, or returns nil for the zero time
+func jsonTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+// jsonString returns s, or nil when it is empty
+func jsonString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// ratio divides num by den, returning 0 when den is 0
+func ratio(num, den float64) float64 {
+	if den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// sessionDuration returns how long a session ran in seconds, or nil while it
+// has no end time
+func sessionDuration(session *Session) interface{} {
+	if session.EndTime.IsZero() {
+		return nil
+	}
+	return session.EndTime.Sub(session.StartTime).Seconds()
+}
@@ -0,0 +1,165 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// marshalResponse round-trips a response through JSON, failing on values
+// encoding/json rejects such as NaN
+func marshalResponse(t *testing.T, response map[string]interface{}) map[string]interface{} {
+	t.Helper()
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		t.Fatalf("Failed to marshal response: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return decoded
+}
+
+// field walks a decoded response through nested objects by key, reporting
+// whether the last key is present
+func field(response map[string]interface{}, keys ...string) (interface{}, bool) {
+	var value interface{} = response
+	for _, key := range keys {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func TestResponsesWithoutSessions(t *testing.T) {
+	for name, response := range map[string]map[string]interface{}{
+		"user": buildUserStatsResponse("user-1", nil, nil, nil),
+		"org":  buildOrgStatsResponse("org-1", nil),
+	} {
+		decoded := marshalResponse(t, response)
+		if decoded["total_sessions"] != float64(0) {
+			t.Errorf("%s: expected 0 sessions, got %v", name, decoded)
+		}
+	}
+}
+
+func TestResponsesForOpenSession(t *testing.T) {
+	session := &Session{
+		SessionID: "open",
+		UserID:    "user-1",
+		StartTime: time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC),
+		CreatedAt: time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name     string
+		response map[string]interface{}
+		nulls    [][]string
+	}{
+		{"legacy", buildSessionStatsResponse(session, nil, nil), [][]string{
+			{"window", "end"},
+			{"window", "duration_seconds"},
+			{"service_name"},
+			{"environment", "terminal_type"},
+			{"environment", "host_arch"},
+			{"environment", "os_type"},
+		}},
+		{"v2", buildV2SessionResponse(session), [][]string{
+			{"end_time"},
+			{"duration_seconds"},
+			{"first_model"},
+			{"primary_model"},
+		}},
+		{"export job", buildExportJobResponse(&ExportJob{JobID: "job-1", Status: ExportRunning, CreatedAt: session.StartTime}), [][]string{
+			{"completed_at"},
+			{"expires_at"},
+		}},
+	}
+	for _, tt := range tests {
+		decoded := marshalResponse(t, tt.response)
+		for _, keys := range tt.nulls {
+			if value, ok := field(decoded, keys...); !ok || value != nil {
+				t.Errorf("%s: expected %v present and null, got %v (present %v)", tt.name, keys, value, ok)
+			}
+		}
+	}
+
+	// Ended sessions report their end
+	session.EndTime = session.StartTime.Add(90 * time.Second)
+	decoded := marshalResponse(t, buildV2SessionResponse(session))
+	if decoded["end_time"] != "2025-06-01T10:01:30Z" || decoded["duration_seconds"] != float64(90) {
+		t.Errorf("Expected the end time and a 90s duration, got %v and %v", decoded["end_time"], decoded["duration_seconds"])
+	}
+}
+
+func TestResponsesWithZeroDenominators(t *testing.T) {
+	idle := &Session{SessionID: "idle", UserID: "user-1", OrganizationID: "org-1", StartTime: time.Now()}
+	noCalls := &SessionTool{SessionID: "idle", ToolName: "Read"}
+
+	responses := map[string]map[string]interface{}{
+		"user":    buildUserStatsResponse("user-1", []*Session{idle}, nil, nil),
+		"org":     buildOrgStatsResponse("org-1", []*Session{idle}),
+		"session": buildSessionStatsResponse(idle, nil, []*SessionTool{noCalls}),
+	}
+	for _, response := range responses {
+		marshalResponse(t, response)
+	}
+
+	for _, keys := range [][]string{
+		{"costs", "avg_per_session"},
+		{"tokens", "avg_per_session"},
+		{"activity", "avg_api_per_session"},
+	} {
+		if value, _ := field(marshalResponse(t, responses["user"]), keys...); value != float64(0) {
+			t.Errorf("Expected user %v of 0, got %v", keys, value)
+		}
+	}
+	if value, _ := field(marshalResponse(t, responses["session"]), "performance", "avg_api_latency_ms"); value != float64(0) {
+		t.Errorf("Expected an average latency of 0 without requests, got %v", value)
+	}
+	if utilization := seatUtilization(3, 0); utilization != 0 {
+		t.Errorf("Expected 0%% utilization without seats, got %v", utilization)
+	}
+}
+
+// Regression: a user whose sessions had no cost and no API requests must
+// still get their stats rather than a 500
+func TestUserStatsWithoutCostOrRequests(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_zero_usage.db")
+	for _, sessionID := range []string{"idle-1", "idle-2"} {
+		if err := SeedSession(server.store, SessionSpec{
+			SessionID:      sessionID,
+			UserID:         "idle-user",
+			OrganizationID: "org-idle",
+			StartTime:      time.Now().Add(-time.Hour),
+		}); err != nil {
+			t.Fatalf("Failed to seed %s: %v", sessionID, err)
+		}
+	}
+
+	for _, path := range []string{"/api/stats/user/idle-user", "/api/stats/org/org-idle"} {
+		rec := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+
+		var decoded map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&decoded); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", path, err)
+		}
+		if value, _ := field(decoded, "costs", "avg_per_session"); value != float64(0) {
+			t.Errorf("%s: expected an average cost of 0, got %v", path, value)
+		}
+	}
+}
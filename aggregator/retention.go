@@ -0,0 +1,124 @@
+package aggregator
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// rawRetention deletes rotated generations of the JSONL files once they are
+// older than maxAge and have been fully processed
+type rawRetention struct {
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu           sync.Mutex
+	deletedFiles int64
+	deletedBytes int64
+	lastSweep    time.Time
+}
+
+// SetRawRetention deletes rotated JSONL files last written more than days
+// ago, once they have been fully processed. The live files are never
+// touched. Zero or less keeps raw data forever.
+func (p *Processor) SetRawRetention(days int) {
+	if days <= 0 {
+		p.retention = nil
+		return
+	}
+	p.retention = &rawRetention{
+		maxAge: time.Duration(days) * 24 * time.Hour,
+		now:    time.Now,
+	}
+}
+
+// sweepRetention deletes every input file's expired generations. Errors are
+// logged and the sweep moves on to the next file.
+func (p *Processor) sweepRetention() {
+	if p.retention == nil {
+		return
+	}
+	for _, filename := range p.files.paths(p.dataDir) {
+		if err := p.sweepFile(filename); err != nil {
+			log.Printf("Error applying retention to %s: %v", filename, err)
+		}
+	}
+	p.retention.mu.Lock()
+	p.retention.lastSweep = p.retention.now()
+	p.retention.mu.Unlock()
+}
+
+// sweepFile deletes the rotated generations of filename older than the
+// retention window that processed_archives records as fully consumed. A
+// generation still carrying the inode in the file's processing state is the
+// one being read, its rotation not yet noticed, and is kept regardless.
+func (p *Processor) sweepFile(filename string) error {
+	state, err := p.store.GetProcessingState(filename)
+	if err != nil {
+		return err
+	}
+	paths, err := generationsOldestFirst(filepath.Join(p.dataDir, filename))
+	if err != nil {
+		return err
+	}
+	cutoff := p.retention.now().Add(-p.retention.maxAge)
+
+	// The last path is the live file
+	for _, path := range paths[:len(paths)-1] {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+
+		reader, inode, err := openArchive(path)
+		if err != nil {
+			log.Printf("Retention skipping %s: %v", path, err)
+			continue
+		}
+		reader.Close()
+		if inode == state.Inode {
+			continue
+		}
+		processed, err := p.store.IsArchiveProcessed(filename, inode)
+		if err != nil {
+			return err
+		}
+		if !processed {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			log.Printf("Retention failed to delete %s: %v", path, err)
+			continue
+		}
+		if err := p.store.ForgetArchive(filename, inode); err != nil {
+			return err
+		}
+		log.Printf("Retention deleted %s (%d bytes, last written %s)", path, info.Size(), info.ModTime().Format(time.RFC3339))
+
+		p.retention.mu.Lock()
+		p.retention.deletedFiles++
+		p.retention.deletedBytes += info.Size()
+		p.retention.mu.Unlock()
+	}
+	return nil
+}
+
+// buildRetentionResponse reports the retention window and what the sweeps
+// have deleted, for /api/processing
+func (r *rawRetention) buildRetentionResponse() map[string]interface{} {
+	if r == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":       true,
+		"max_age_days":  int(r.maxAge / (24 * time.Hour)),
+		"deleted_files": r.deletedFiles,
+		"deleted_bytes": r.deletedBytes,
+		"last_sweep":    jsonTime(r.lastSweep),
+	}
+}
@@ -0,0 +1,165 @@
+package aggregator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fileInode returns the inode of the file at path
+func fileInode(t *testing.T, path string) uint64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	return getInode(info)
+}
+
+// backdate sets the modification time of the file at path to age ago
+func backdate(t *testing.T, path string, age time.Duration) {
+	t.Helper()
+	when := time.Now().Add(-age)
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatalf("Failed to backdate %s: %v", path, err)
+	}
+}
+
+func TestRetentionDeletesOldProcessedGenerations(t *testing.T) {
+	dbPath := "./test_raw_retention.db"
+	defer os.Remove(dbPath)
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	livePath := filepath.Join(dataDir, "metrics.jsonl")
+	appendToFile(t, livePath, workerCostLines(0, 5))
+	appendToFile(t, livePath+".1", workerCostLines(5, 10))
+	writeGzipArchive(t, livePath+".2.gz", fmt.Sprintf(gzipInodeComment, 515151), workerCostLines(10, 15))
+	appendToFile(t, livePath+".3", workerCostLines(15, 20))
+	appendToFile(t, livePath+".4", workerCostLines(20, 25))
+
+	// Rotated logs the processor hasn't noticed yet, still carrying the
+	// inode it is reading
+	logsPath := filepath.Join(dataDir, "logs.jsonl")
+	appendToFile(t, logsPath, "")
+	appendToFile(t, logsPath+".1", workerCostLines(0, 5))
+
+	for _, path := range []string{livePath, livePath + ".1", livePath + ".2.gz", livePath + ".3", logsPath, logsPath + ".1"} {
+		backdate(t, path, 30*24*time.Hour)
+	}
+	backdate(t, livePath+".4", 24*time.Hour)
+
+	// Generation 3 was never read; the rest were read in full
+	if err := store.UpdateProcessingState("metrics.jsonl", 5, 0, 0, fileInode(t, livePath)); err != nil {
+		t.Fatalf("Failed to set processing state: %v", err)
+	}
+	for _, inode := range []uint64{fileInode(t, livePath+".1"), 515151, fileInode(t, livePath+".4")} {
+		if err := store.MarkArchiveProcessed("metrics.jsonl", inode); err != nil {
+			t.Fatalf("Failed to mark archive: %v", err)
+		}
+	}
+	rotatedLogs := fileInode(t, logsPath+".1")
+	if err := store.UpdateProcessingState("logs.jsonl", 5, 0, 0, rotatedLogs); err != nil {
+		t.Fatalf("Failed to set processing state: %v", err)
+	}
+	if err := store.MarkArchiveProcessed("logs.jsonl", rotatedLogs); err != nil {
+		t.Fatalf("Failed to mark archive: %v", err)
+	}
+
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, newEngine(store), 60)
+	processor.SetRawRetention(7)
+	deletedInode := fileInode(t, livePath+".1")
+	processor.sweepRetention()
+
+	for path, kept := range map[string]bool{
+		livePath:           true,
+		livePath + ".1":    false,
+		livePath + ".2.gz": false,
+		livePath + ".3":    true,
+		livePath + ".4":    true,
+		logsPath:           true,
+		logsPath + ".1":    true,
+	} {
+		_, err := os.Stat(path)
+		if exists := err == nil; exists != kept {
+			t.Errorf("Expected %s kept=%v, exists=%v", filepath.Base(path), kept, exists)
+		}
+	}
+
+	// Deleted generations are forgotten, so a reused inode starts fresh
+	for _, inode := range []uint64{deletedInode, 515151} {
+		if processed, err := store.IsArchiveProcessed("metrics.jsonl", inode); err != nil || processed {
+			t.Errorf("Expected inode %d forgotten, got %v (%v)", inode, processed, err)
+		}
+	}
+
+	response := processor.retention.buildRetentionResponse()
+	if response["deleted_files"] != int64(2) || response["max_age_days"] != 7 {
+		t.Errorf("Expected 2 files deleted with a 7 day window, got %v", response)
+	}
+	if response["last_sweep"] == nil {
+		t.Errorf("Expected the sweep time reported, got %v", response)
+	}
+
+	// Without a window nothing is deleted
+	processor.SetRawRetention(0)
+	processor.sweepRetention()
+	if _, err := os.Stat(livePath + ".3"); err != nil {
+		t.Errorf("Expected nothing deleted with retention off, got %v", err)
+	}
+	if response := processor.retention.buildRetentionResponse(); response["enabled"] != false {
+		t.Errorf("Expected retention reported disabled, got %v", response)
+	}
+}
+
+func TestRotatedFilesRecordedAsProcessed(t *testing.T) {
+	dbPath := "./test_rotation_recorded.db"
+	defer os.Remove(dbPath)
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, newEngine(store), 60)
+
+	// Drained by the processor after rotation
+	livePath := filepath.Join(dataDir, "metrics.jsonl")
+	appendToFile(t, livePath, workerCostLines(0, 5))
+	if err := processor.ProcessFile(livePath); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	appendToFile(t, livePath, workerCostLines(5, 10))
+	drained := fileInode(t, livePath)
+	if err := os.Rename(livePath, livePath+".1"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	appendToFile(t, livePath, workerCostLines(10, 15))
+	if err := processor.ProcessFile(livePath); err != nil {
+		t.Fatalf("Failed to process file: %v", err)
+	}
+	if processed, err := store.IsArchiveProcessed("metrics.jsonl", drained); err != nil || !processed {
+		t.Errorf("Expected the drained file recorded as processed, got %v (%v)", processed, err)
+	}
+
+	// Ingested directly, with the processor only keeping up its offsets
+	logsPath := filepath.Join(dataDir, "logs.jsonl")
+	appendToFile(t, logsPath, "{}\n")
+	processor.skipFile("logs.jsonl")
+	skipped := fileInode(t, logsPath)
+	if err := os.Rename(logsPath, logsPath+".1"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	appendToFile(t, logsPath, "{}\n")
+	processor.skipFile("logs.jsonl")
+	if processed, err := store.IsArchiveProcessed("logs.jsonl", skipped); err != nil || !processed {
+		t.Errorf("Expected the directly ingested file recorded as processed, got %v (%v)", processed, err)
+	}
+}
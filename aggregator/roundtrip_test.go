@@ -0,0 +1,134 @@
+package aggregator
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zmack/otis/collector"
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// testResource identifies a session through resource attributes
+func testResource(sessionID string) *resourcepb.Resource {
+	return &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+		Key:   "session.id",
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: sessionID}},
+	}}}
+}
+
+// postOTLP sends a protobuf export request to a collector handler
+func postOTLP(t *testing.T, handler http.Handler, path string, req proto.Message) {
+	t.Helper()
+
+	body, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from %s, got %d", path, rec.Code)
+	}
+}
+
+// TestCollectorToProcessorRoundTrip posts to each collector endpoint and
+// checks the processor reads every resulting file, along with lines in the
+// historical formats.
+func TestCollectorToProcessorRoundTrip(t *testing.T) {
+	dbPath := "./test_roundtrip.db"
+	dataDir := "./test_roundtrip_data"
+	defer os.Remove(dbPath)
+	defer os.RemoveAll(dataDir)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+
+	newWriter := func(name string) *collector.FileWriter {
+		w, err := collector.NewFileWriter(filepath.Join(dataDir, name))
+		if err != nil {
+			t.Fatalf("Failed to create writer: %v", err)
+		}
+		return w
+	}
+	limits := collector.RequestLimits{MaxBytes: 1 << 20}
+	metrics := collector.NewMetrics()
+
+	postOTLP(t, collector.NewTraceHandler(newWriter("traces.jsonl"), limits, metrics), "/v1/traces",
+		&tracev1.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+			Resource: testResource("session-traces"),
+			ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{
+				Name:              "claude_code.request",
+				StartTimeUnixNano: 1000000000,
+				EndTimeUnixNano:   2000000000,
+			}}}},
+		}}})
+
+	postOTLP(t, collector.NewMetricsHandler(newWriter("metrics.jsonl"), limits, metrics), "/v1/metrics",
+		&metricsv1.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: testResource("session-metrics"),
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{{
+				Name: "claude_code.cost.usage",
+				Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{DataPoints: []*metricspb.NumberDataPoint{{
+					TimeUnixNano: 1000000000,
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 1.5},
+				}}}},
+			}}}},
+		}}})
+
+	postOTLP(t, collector.NewLogsHandler(newWriter("logs.jsonl"), limits, metrics), "/v1/logs",
+		&logsv1.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: testResource("session-logs"),
+			ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{{
+				TimeUnixNano: 1000000000,
+				Body:         &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "claude_code.api_request"}},
+			}}}},
+		}}})
+
+	// Historical formats appended after the envelope lines
+	f, err := os.OpenFile(filepath.Join(dataDir, "metrics.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open metrics file: %v", err)
+	}
+	f.WriteString(`{"resourceMetrics":[{"resource":{"attributes":[{"key":"session.id","value":{"stringValue":"session-bare"}}]},"scopeMetrics":[{"metrics":[{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"timeUnixNano":"1000000000","asDouble":2.0}]}}]}]}]}` + "\n")
+	f.WriteString(`{"data":"{\"resourceMetrics\":[{\"resource\":{\"attributes\":[{\"key\":\"session.id\",\"value\":{\"stringValue\":\"session-wrapped\"}}]},\"scopeMetrics\":[{\"metrics\":[{\"name\":\"claude_code.cost.usage\",\"sum\":{\"dataPoints\":[{\"timeUnixNano\":\"1000000000\",\"asDouble\":3.0}]}}]}]}]}"}` + "\n")
+	f.Close()
+
+	for _, name := range []string{"traces.jsonl", "metrics.jsonl", "logs.jsonl"} {
+		if err := processor.ProcessFile(filepath.Join(dataDir, name)); err != nil {
+			t.Fatalf("Failed to process %s: %v", name, err)
+		}
+	}
+
+	engine.cacheMutex.RLock()
+	defer engine.cacheMutex.RUnlock()
+
+	for _, sessionID := range []string{"session-traces", "session-metrics", "session-logs", "session-bare", "session-wrapped"} {
+		if _, exists := engine.sessionsCache[sessionID]; !exists {
+			t.Errorf("Expected %s to be processed", sessionID)
+		}
+	}
+	if cost := engine.sessionsCache["session-metrics"].TotalCostUSD; cost != 1.5 {
+		t.Errorf("Expected cost 1.5 from the envelope line, got %f", cost)
+	}
+	if engine.sessionsCache["session-logs"].APIRequestCount != 1 {
+		t.Error("Expected the api_request log to be counted")
+	}
+}
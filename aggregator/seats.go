@@ -0,0 +1,148 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Seat utilization defaults, overridable per request
+const (
+	defaultSeatMonths       = 6
+	maxSeatMonths           = 24
+	defaultSeatInactiveDays = 30
+)
+
+// seatCounts is how many paid seats each organization has
+type seatCounts struct {
+	defaultSeats int // For organizations not listed in orgs; zero for none
+	orgs         map[string]int
+	now          func() time.Time
+}
+
+// forOrg returns an organization's seat count, or false when it has none
+func (c *seatCounts) forOrg(orgID string) (int, bool) {
+	if seats, ok := c.orgs[orgID]; ok {
+		return seats, true
+	}
+	return c.defaultSeats, c.defaultSeats > 0
+}
+
+// SetSeats enables /api/stats/org/{org_id}/seats. Every organization has
+// defaultSeats paid seats unless orgSeats, mapping organization IDs to seat
+// counts, says otherwise. With neither, seat tracking stays disabled.
+func (s *APIServer) SetSeats(defaultSeats int, orgSeats map[string]string) error {
+	if defaultSeats < 0 {
+		return fmt.Errorf("invalid seat count %d: must not be negative", defaultSeats)
+	}
+	orgs := make(map[string]int, len(orgSeats))
+	for orgID, value := range orgSeats {
+		seats, err := strconv.Atoi(value)
+		if err != nil || seats <= 0 {
+			return fmt.Errorf("invalid seat count %q for organization %s: want a positive number", value, orgID)
+		}
+		orgs[orgID] = seats
+	}
+
+	if defaultSeats == 0 && len(orgs) == 0 {
+		s.seats = nil
+		return nil
+	}
+	s.seats = &seatCounts{defaultSeats: defaultSeats, orgs: orgs, now: time.Now}
+	return nil
+}
+
+// handleOrgSeats handles GET /api/stats/org/{org_id}/seats?months=6&inactive_days=30,
+// comparing an organization's seat count with its distinct active users per
+// calendar month and listing users inactive long enough to reclaim their seat
+func (s *APIServer) handleOrgSeats(w http.ResponseWriter, r *http.Request, orgID string) {
+	if s.seats == nil {
+		http.Error(w, "Seat tracking requires OTIS_SEATS or OTIS_ORG_SEATS", http.StatusServiceUnavailable)
+		return
+	}
+	seats, ok := s.seats.forOrg(orgID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("No seat count configured for organization %s", orgID), http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	months, inactiveDays := defaultSeatMonths, defaultSeatInactiveDays
+	for name, param := range map[string]*int{"months": &months, "inactive_days": &inactiveDays} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				http.Error(w, fmt.Sprintf("invalid %s %q: want a positive number", name, value), http.StatusBadRequest)
+				return
+			}
+			*param = parsed
+		}
+	}
+	if months > maxSeatMonths {
+		months = maxSeatMonths
+	}
+
+	// Calendar months are in the business hours' timezone, the current one
+	// last and still in progress
+	location := s.engine.BusinessHours().Location
+	now := s.seats.now().In(location)
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+	trend := make([]map[string]interface{}, 0, months)
+	var activeUsers int
+	for i := months - 1; i >= 0; i-- {
+		start := thisMonth.AddDate(0, -i, 0)
+		count, err := s.store.CountOrgActiveUsers(orgID, start, start.AddDate(0, 1, 0))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving seat utilization: %v", err), http.StatusInternalServerError)
+			return
+		}
+		trend = append(trend, map[string]interface{}{
+			"month":               start.Format("2006-01"),
+			"active_users":        count,
+			"utilization_percent": seatUtilization(count, seats),
+		})
+		activeUsers = count
+	}
+
+	users, err := s.store.GetOrgUserLastActivity(orgID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving seat utilization: %v", err), http.StatusInternalServerError)
+		return
+	}
+	cutoff := now.AddDate(0, 0, -inactiveDays)
+	candidates := make([]map[string]interface{}, 0)
+	for _, ua := range users {
+		if !ua.LastActive.Before(cutoff) {
+			break // Least recently active first, so the rest are active
+		}
+		candidates = append(candidates, map[string]interface{}{
+			"user_id":       ua.UserID,
+			"last_active":   ua.LastActive.UTC().Format(time.RFC3339),
+			"days_inactive": int(now.Sub(ua.LastActive).Hours() / 24),
+		})
+	}
+
+	response := map[string]interface{}{
+		"organization_id":     orgID,
+		"seats":               seats,
+		"month":               thisMonth.Format("2006-01"),
+		"active_users":        activeUsers,
+		"unused_seats":        max(0, seats-activeUsers),
+		"utilization_percent": seatUtilization(activeUsers, seats),
+		"timezone":            location.String(),
+		"trend":               trend,
+		"inactive_days":       inactiveDays,
+		"reclaim_candidates":  candidates,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// seatUtilization is active users as a percentage of seats; over 100 when
+// more users are active than there are seats
+func seatUtilization(activeUsers, seats int) float64 {
+	return ratio(float64(activeUsers), float64(seats)) * 100
+}
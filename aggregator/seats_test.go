@@ -0,0 +1,164 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOrgSeats(t *testing.T) {
+	server := newTestAPIServer(t, "./test_org_seats.db")
+	if err := server.SetSeats(0, map[string]string{"org-a": "4"}); err != nil {
+		t.Fatalf("Failed to set seats: %v", err)
+	}
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	server.seats.now = func() time.Time { return now }
+
+	for i, s := range []struct {
+		userID, orgID string
+		start         time.Time
+	}{
+		{"alice", "org-a", time.Date(2025, 5, 2, 10, 0, 0, 0, time.UTC)},
+		{"alice", "org-a", time.Date(2025, 6, 10, 10, 0, 0, 0, time.UTC)},
+		{"alice", "org-a", time.Date(2025, 6, 11, 10, 0, 0, 0, time.UTC)},
+		{"bob", "org-a", time.Date(2025, 6, 14, 10, 0, 0, 0, time.UTC)},
+		{"carol", "org-a", time.Date(2025, 4, 20, 10, 0, 0, 0, time.UTC)},
+		{"dave", "org-a", time.Date(2025, 5, 10, 10, 0, 0, 0, time.UTC)},
+		{"erin", "org-a", time.Date(2025, 5, 20, 10, 0, 0, 0, time.UTC)},
+		{"mallory", "org-b", time.Date(2025, 6, 12, 10, 0, 0, 0, time.UTC)},
+	} {
+		err := SeedSession(server.store, SessionSpec{
+			SessionID:      fmt.Sprintf("%s-%d", s.userID, i),
+			UserID:         s.userID,
+			OrganizationID: s.orgID,
+			StartTime:      s.start,
+			Duration:       time.Hour,
+		})
+		if err != nil {
+			t.Fatalf("Failed to seed session: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/org/org-a/seats?months=3", nil)
+	w := httptest.NewRecorder()
+	server.handleOrgStats(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Seats              int     `json:"seats"`
+		Month              string  `json:"month"`
+		ActiveUsers        int     `json:"active_users"`
+		UnusedSeats        int     `json:"unused_seats"`
+		UtilizationPercent float64 `json:"utilization_percent"`
+		Trend              []struct {
+			Month              string  `json:"month"`
+			ActiveUsers        int     `json:"active_users"`
+			UtilizationPercent float64 `json:"utilization_percent"`
+		} `json:"trend"`
+		ReclaimCandidates []struct {
+			UserID       string `json:"user_id"`
+			LastActive   string `json:"last_active"`
+			DaysInactive int    `json:"days_inactive"`
+		} `json:"reclaim_candidates"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// June has alice and bob, counted once each; mallory is in another org
+	if response.Seats != 4 || response.Month != "2025-06" || response.ActiveUsers != 2 ||
+		response.UnusedSeats != 2 || response.UtilizationPercent != 50 {
+		t.Errorf("Unexpected utilization: %+v", response)
+	}
+
+	wantTrend := []struct {
+		month   string
+		active  int
+		percent float64
+	}{
+		{"2025-04", 1, 25},
+		{"2025-05", 3, 75},
+		{"2025-06", 2, 50},
+	}
+	if len(response.Trend) != len(wantTrend) {
+		t.Fatalf("Expected %d months, got %d", len(wantTrend), len(response.Trend))
+	}
+	for i, want := range wantTrend {
+		got := response.Trend[i]
+		if got.Month != want.month || got.ActiveUsers != want.active || got.UtilizationPercent != want.percent {
+			t.Errorf("Expected %s with %d users (%g%%), got %+v", want.month, want.active, want.percent, got)
+		}
+	}
+
+	// erin was last active 26 days ago, short of the 30 day cutoff
+	if len(response.ReclaimCandidates) != 2 {
+		t.Fatalf("Expected 2 reclaim candidates, got %+v", response.ReclaimCandidates)
+	}
+	carol, dave := response.ReclaimCandidates[0], response.ReclaimCandidates[1]
+	if carol.UserID != "carol" || carol.LastActive != "2025-04-20T11:00:00Z" || carol.DaysInactive != 56 {
+		t.Errorf("Expected carol first, inactive 56 days, got %+v", carol)
+	}
+	if dave.UserID != "dave" || dave.DaysInactive != 36 {
+		t.Errorf("Expected dave inactive 36 days, got %+v", dave)
+	}
+
+	// A longer cutoff leaves only carol
+	req = httptest.NewRequest(http.MethodGet, "/api/stats/org/org-a/seats?inactive_days=50", nil)
+	w = httptest.NewRecorder()
+	server.handleOrgStats(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.ReclaimCandidates) != 1 || response.ReclaimCandidates[0].UserID != "carol" {
+		t.Errorf("Expected only carol past 50 days, got %+v", response.ReclaimCandidates)
+	}
+	if len(response.Trend) != defaultSeatMonths {
+		t.Errorf("Expected %d months by default, got %d", defaultSeatMonths, len(response.Trend))
+	}
+}
+
+func TestOrgSeatsErrors(t *testing.T) {
+	server := newTestAPIServer(t, "./test_org_seats_errors.db")
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		server.handleOrgStats(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	if code := get("/api/stats/org/org-a/seats"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without seat counts, got %d", code)
+	}
+
+	if err := server.SetSeats(0, map[string]string{"org-a": "10"}); err != nil {
+		t.Fatalf("Failed to set seats: %v", err)
+	}
+	if code := get("/api/stats/org/org-b/seats"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an org without a seat count, got %d", code)
+	}
+	if code := get("/api/stats/org/org-a/seats?months=0"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for months=0, got %d", code)
+	}
+
+	// A default covers every org
+	if err := server.SetSeats(5, nil); err != nil {
+		t.Fatalf("Failed to set seats: %v", err)
+	}
+	if code := get("/api/stats/org/org-b/seats"); code != http.StatusOK {
+		t.Errorf("Expected 200 with a default seat count, got %d", code)
+	}
+
+	for _, orgSeats := range []map[string]string{{"org-a": "ten"}, {"org-a": "0"}} {
+		if err := server.SetSeats(0, orgSeats); err == nil {
+			t.Errorf("Expected %v to be rejected", orgSeats)
+		}
+	}
+	if err := server.SetSeats(-1, nil); err == nil {
+		t.Errorf("Expected a negative seat count to be rejected")
+	}
+}
@@ -0,0 +1,346 @@
+package aggregator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SessionSpec declares a session for SeedSession to write. It describes what
+// the engine would have aggregated from the session's telemetry, so the rows
+// written are the ones the API and exports read, without going through OTLP.
+//
+// Session totals left zero are derived from Models and Tools. A total set
+// explicitly must cover them, as a session can have usage no model or tool
+// accounts for.
+type SessionSpec struct {
+	SessionID      string
+	UserID         string
+	OrganizationID string
+	ClientName     string
+	StartTime      time.Time
+	Duration       time.Duration
+
+	Models  []ModelSpec // In the order first used
+	Tools   []ToolSpec
+	Prompts []PromptSpec
+
+	TotalCostUSD             float64
+	TotalInputTokens         int64
+	TotalOutputTokens        int64
+	TotalCacheReadTokens     int64
+	TotalCacheCreationTokens int64
+	ToolCallCount            int
+	APIRequestCount          int
+	APIErrorCount            int
+
+	// ConsoleUsage also records each model's usage as Anthropic Console
+	// daily rollups for the session's start day, added to any already there,
+	// as if a matching Console export had been imported
+	ConsoleUsage bool
+}
+
+// ModelSpec is one model's usage within a SessionSpec
+type ModelSpec struct {
+	Model               string
+	Requests            int
+	CostUSD             float64
+	InputTokens         int64
+	OutputTokens        int64
+	CacheReadTokens     int64
+	CacheCreationTokens int64
+	LatencyMS           float64 // Total across requests
+}
+
+// ToolSpec is one tool's usage within a SessionSpec. Every call succeeded or
+// failed, and the approval counts cover at most every call.
+type ToolSpec struct {
+	Name            string
+	Successes       int
+	Failures        int
+	ExecutionTimeMS float64 // Total across calls
+	AutoApproved    int
+	UserApproved    int
+	Rejected        int
+	ResultSizeBytes int64
+}
+
+// PromptSpec is a user prompt sent Offset into a SessionSpec's session
+type PromptSpec struct {
+	Text   string
+	Offset time.Duration
+}
+
+// Validate reports every way spec is internally inconsistent, or nil when
+// SeedSession would write it as is
+func (spec *SessionSpec) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if spec.SessionID == "" {
+		fail("session ID is required")
+	}
+	if spec.StartTime.IsZero() {
+		fail("start time is required")
+	}
+	if spec.Duration < 0 {
+		fail("duration %s is negative", spec.Duration)
+	}
+
+	sum := spec.modelTotals()
+	models := make(map[string]bool)
+	for _, m := range spec.Models {
+		if m.Model == "" {
+			fail("model name is required")
+		} else if models[m.Model] {
+			fail("model %s is listed twice", m.Model)
+		}
+		models[m.Model] = true
+		if m.Requests < 0 || m.CostUSD < 0 || m.InputTokens < 0 || m.OutputTokens < 0 ||
+			m.CacheReadTokens < 0 || m.CacheCreationTokens < 0 || m.LatencyMS < 0 {
+			fail("model %s has negative usage", m.Model)
+		}
+	}
+	for _, total := range []struct {
+		name        string
+		set, summed float64
+	}{
+		{"cost", spec.TotalCostUSD, sum.CostUSD},
+		{"input tokens", float64(spec.TotalInputTokens), float64(sum.InputTokens)},
+		{"output tokens", float64(spec.TotalOutputTokens), float64(sum.OutputTokens)},
+		{"cache read tokens", float64(spec.TotalCacheReadTokens), float64(sum.CacheReadTokens)},
+		{"cache creation tokens", float64(spec.TotalCacheCreationTokens), float64(sum.CacheCreationTokens)},
+		{"API requests", float64(spec.APIRequestCount), float64(sum.RequestCount)},
+	} {
+		if total.set < 0 {
+			fail("session %s %g is negative", total.name, total.set)
+		} else if total.set != 0 && total.set < total.summed-1e-9 { // Allow for float rounding in the cost
+			fail("session %s %g is less than the models' %g", total.name, total.set, total.summed)
+		}
+	}
+	requests := spec.APIRequestCount
+	if requests == 0 {
+		requests = sum.RequestCount
+	}
+	if spec.APIErrorCount < 0 {
+		fail("API error count %d is negative", spec.APIErrorCount)
+	} else if spec.APIErrorCount > requests {
+		fail("API error count %d is more than the %d API requests", spec.APIErrorCount, requests)
+	}
+
+	var calls int
+	tools := make(map[string]bool)
+	for _, tool := range spec.Tools {
+		name := normalizeToolName(tool.Name)
+		if name == "" {
+			fail("tool name is required")
+		} else if tools[name] {
+			fail("tool %s is listed twice", name)
+		}
+		tools[name] = true
+		if tool.Successes < 0 || tool.Failures < 0 || tool.ExecutionTimeMS < 0 || tool.AutoApproved < 0 ||
+			tool.UserApproved < 0 || tool.Rejected < 0 || tool.ResultSizeBytes < 0 {
+			fail("tool %s has negative usage", name)
+		}
+		if decided := tool.AutoApproved + tool.UserApproved + tool.Rejected; decided > tool.calls() {
+			fail("tool %s has %d approval decisions for %d calls", name, decided, tool.calls())
+		}
+		calls += tool.calls()
+	}
+	if spec.ToolCallCount < 0 {
+		fail("tool call count %d is negative", spec.ToolCallCount)
+	} else if spec.ToolCallCount != 0 && spec.ToolCallCount != calls {
+		fail("tool call count %d is not the tools' %d successes and failures", spec.ToolCallCount, calls)
+	}
+
+	for i, prompt := range spec.Prompts {
+		if prompt.Text == "" {
+			fail("prompt %d is empty", i)
+		}
+		if prompt.Offset < 0 || prompt.Offset > spec.Duration {
+			fail("prompt %d at %s falls outside the session's %s", i, prompt.Offset, spec.Duration)
+		}
+	}
+
+	if spec.ConsoleUsage && spec.UserID == "" {
+		fail("console usage requires a user ID")
+	}
+
+	return errors.Join(errs...)
+}
+
+// calls is how many times the tool was called
+func (tool *ToolSpec) calls() int {
+	return tool.Successes + tool.Failures
+}
+
+// modelTotals sums the usage of spec's models
+func (spec *SessionSpec) modelTotals() SessionModel {
+	var sum SessionModel
+	for _, m := range spec.Models {
+		sum.RequestCount += m.Requests
+		sum.CostUSD += m.CostUSD
+		sum.InputTokens += m.InputTokens
+		sum.OutputTokens += m.OutputTokens
+		sum.CacheReadTokens += m.CacheReadTokens
+		sum.CacheCreationTokens += m.CacheCreationTokens
+		sum.TotalLatencyMS += m.LatencyMS
+	}
+	return sum
+}
+
+// SeedSession validates spec and writes its session with the matching
+// session_models, session_tools and session_prompts rows, and Console daily
+// rollups when asked. It is meant for building realistic databases in tests
+// and downstream tools; seeding a session ID already in the store replaces
+// its summary and model and tool rows but adds to its prompts.
+func SeedSession(store *Store, spec SessionSpec) error {
+	if err := spec.Validate(); err != nil {
+		return fmt.Errorf("invalid session spec %q: %w", spec.SessionID, err)
+	}
+
+	sum := spec.modelTotals()
+	orDerived := func(set, derived int64) int64 {
+		if set != 0 {
+			return set
+		}
+		return derived
+	}
+
+	var calls, successes, failures int
+	for _, tool := range spec.Tools {
+		calls += tool.calls()
+		successes += tool.Successes
+		failures += tool.Failures
+	}
+
+	models := make(map[string]*SessionModel, len(spec.Models))
+	for _, m := range spec.Models {
+		models[m.Model] = &SessionModel{
+			SessionID:           spec.SessionID,
+			Model:               m.Model,
+			RequestCount:        m.Requests,
+			CostUSD:             m.CostUSD,
+			InputTokens:         m.InputTokens,
+			OutputTokens:        m.OutputTokens,
+			CacheReadTokens:     m.CacheReadTokens,
+			CacheCreationTokens: m.CacheCreationTokens,
+			TotalLatencyMS:      m.LatencyMS,
+		}
+	}
+
+	session := &Session{
+		SessionID:                spec.SessionID,
+		OrganizationID:           spec.OrganizationID,
+		UserID:                   spec.UserID,
+		StartTime:                spec.StartTime,
+		EndTime:                  spec.StartTime.Add(spec.Duration),
+		ClientName:               spec.ClientName,
+		TotalCostUSD:             spec.TotalCostUSD,
+		TotalInputTokens:         orDerived(spec.TotalInputTokens, sum.InputTokens),
+		TotalOutputTokens:        orDerived(spec.TotalOutputTokens, sum.OutputTokens),
+		TotalCacheReadTokens:     orDerived(spec.TotalCacheReadTokens, sum.CacheReadTokens),
+		TotalCacheCreationTokens: orDerived(spec.TotalCacheCreationTokens, sum.CacheCreationTokens),
+		ToolCallCount:            int(orDerived(int64(spec.ToolCallCount), int64(calls))),
+		ToolSuccessCount:         successes,
+		ToolFailureCount:         failures,
+		APIRequestCount:          int(orDerived(int64(spec.APIRequestCount), int64(sum.RequestCount))),
+		APIErrorCount:            spec.APIErrorCount,
+		UserPromptCount:          len(spec.Prompts),
+		TotalAPILatencyMS:        sum.TotalLatencyMS,
+		PrimaryModel:             primaryModel(models),
+		CreatedAt:                spec.StartTime,
+		UpdatedAt:                spec.StartTime.Add(spec.Duration),
+	}
+	if session.TotalCostUSD == 0 {
+		session.TotalCostUSD = sum.CostUSD
+	}
+	if len(spec.Models) > 0 {
+		session.FirstModel = spec.Models[0].Model
+	}
+	if err := store.UpsertSession(session); err != nil {
+		return fmt.Errorf("failed to seed session %s: %w", spec.SessionID, err)
+	}
+
+	for _, m := range spec.Models {
+		if err := store.UpsertSessionModel(models[m.Model]); err != nil {
+			return fmt.Errorf("failed to seed model %s of session %s: %w", m.Model, spec.SessionID, err)
+		}
+	}
+
+	for _, tool := range spec.Tools {
+		err := store.UpsertSessionTool(&SessionTool{
+			SessionID:            spec.SessionID,
+			ToolName:             normalizeToolName(tool.Name),
+			CallCount:            tool.calls(),
+			SuccessCount:         tool.Successes,
+			FailureCount:         tool.Failures,
+			TotalExecutionTimeMS: tool.ExecutionTimeMS,
+			AutoApprovedCount:    tool.AutoApproved,
+			UserApprovedCount:    tool.UserApproved,
+			RejectedCount:        tool.Rejected,
+			TotalResultSizeBytes: tool.ResultSizeBytes,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to seed tool %s of session %s: %w", tool.Name, spec.SessionID, err)
+		}
+	}
+
+	for _, prompt := range spec.Prompts {
+		err := store.InsertSessionPrompt(&SessionPrompt{
+			SessionID:    spec.SessionID,
+			PromptText:   prompt.Text,
+			PromptLength: len(prompt.Text),
+			Timestamp:    spec.StartTime.Add(prompt.Offset),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to seed prompt of session %s: %w", spec.SessionID, err)
+		}
+	}
+
+	if spec.ConsoleUsage {
+		if err := seedConsoleUsage(store, &spec); err != nil {
+			return fmt.Errorf("failed to seed console usage of session %s: %w", spec.SessionID, err)
+		}
+	}
+
+	return nil
+}
+
+// seedConsoleUsage adds spec's model usage to the Console daily rollups of
+// its user for the session's start day
+func seedConsoleUsage(store *Store, spec *SessionSpec) error {
+	day := spec.StartTime.UTC().Format("2006-01-02")
+	existing, err := store.GetDailyUsageByUser(spec.UserID)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range spec.Models {
+		usage := &DailyUsage{
+			Day:            day,
+			UserID:         spec.UserID,
+			OrganizationID: spec.OrganizationID,
+			Model:          m.Model,
+			Source:         consoleSource,
+			CreatedAt:      spec.StartTime,
+		}
+		for _, row := range existing {
+			if row.Day == day && row.Model == m.Model && row.Source == consoleSource {
+				usage = row
+			}
+		}
+		usage.InputTokens += m.InputTokens
+		usage.OutputTokens += m.OutputTokens
+		usage.CacheReadTokens += m.CacheReadTokens
+		usage.CacheCreationTokens += m.CacheCreationTokens
+		usage.CostUSD += m.CostUSD
+		usage.UpdatedAt = spec.StartTime.Add(spec.Duration)
+		if err := store.UpsertDailyUsage(usage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package aggregator
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func validSessionSpec() SessionSpec {
+	return SessionSpec{
+		SessionID:      "seeded-session",
+		UserID:         "user-1",
+		OrganizationID: "org-1",
+		StartTime:      time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC),
+		Duration:       time.Hour,
+		Models: []ModelSpec{
+			{Model: "claude-haiku-4", Requests: 2, CostUSD: 0.1, InputTokens: 100, OutputTokens: 10, LatencyMS: 400},
+			{Model: "claude-sonnet-4-5", Requests: 3, CostUSD: 0.6, InputTokens: 300, OutputTokens: 30, CacheReadTokens: 50, LatencyMS: 900},
+		},
+		Tools: []ToolSpec{
+			{Name: "Bash", Successes: 3, Failures: 1, ExecutionTimeMS: 40, AutoApproved: 2, Rejected: 1},
+			{Name: " read ", Successes: 2},
+		},
+		Prompts: []PromptSpec{{Text: "run the tests"}, {Text: "fix the build", Offset: 30 * time.Minute}},
+	}
+}
+
+func TestSeedSessionWritesConsistentRows(t *testing.T) {
+	dbPath := "./test_seed_session.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	spec := validSessionSpec()
+	spec.ConsoleUsage = true
+	if err := SeedSession(store, spec); err != nil {
+		t.Fatalf("Failed to seed session: %v", err)
+	}
+
+	session, err := store.GetSession("seeded-session")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if session.TotalInputTokens != 400 || session.TotalOutputTokens != 40 || session.TotalCacheReadTokens != 50 || session.ToolCallCount != 6 {
+		t.Errorf("Expected totals derived from the models and tools, got %+v", session)
+	}
+	if session.TotalCostUSD < 0.699 || session.TotalCostUSD > 0.701 {
+		t.Errorf("Expected $0.70 across the models, got $%f", session.TotalCostUSD)
+	}
+	var requests, prompts int
+	var latencyMS float64
+	if err := store.db.QueryRow(`SELECT api_request_count, user_prompt_count, total_api_latency_ms FROM sessions WHERE session_id = ?`,
+		"seeded-session").Scan(&requests, &prompts, &latencyMS); err != nil || requests != 5 || prompts != 2 || latencyMS != 1300 {
+		t.Errorf("Expected 5 requests, 2 prompts and 1300ms, got %d, %d and %f (%v)", requests, prompts, latencyMS, err)
+	}
+	if session.FirstModel != "claude-haiku-4" || session.PrimaryModel != "claude-sonnet-4-5" {
+		t.Errorf("Expected haiku first and sonnet primary, got %s and %s", session.FirstModel, session.PrimaryModel)
+	}
+	if !session.EndTime.Equal(spec.StartTime.Add(time.Hour)) {
+		t.Errorf("Expected the session to end an hour in, got %v", session.EndTime)
+	}
+
+	tools, err := store.GetSessionTools("seeded-session")
+	if err != nil || len(tools) != 2 {
+		t.Fatalf("Expected 2 tools, got %d (%v)", len(tools), err)
+	}
+	for _, tool := range tools {
+		if tool.SuccessCount+tool.FailureCount != tool.CallCount {
+			t.Errorf("Expected %s's successes and failures to add up to its calls, got %+v", tool.ToolName, tool)
+		}
+		if tool.ToolName == "Bash" && (tool.CallCount != 4 || tool.RejectedCount != 1) {
+			t.Errorf("Expected 4 Bash calls with 1 rejected, got %+v", tool)
+		}
+	}
+
+	seeded, err := store.GetSessionPrompts("seeded-session")
+	if err != nil || len(seeded) != 2 || !seeded[1].Timestamp.Equal(spec.StartTime.Add(30*time.Minute)) {
+		t.Errorf("Expected 2 prompts, the second 30 minutes in, got %d (%v)", len(seeded), err)
+	}
+
+	// A second session the same day adds to the Console rollups
+	spec.SessionID = "seeded-session-2"
+	spec.Prompts = nil
+	if err := SeedSession(store, spec); err != nil {
+		t.Fatalf("Failed to seed second session: %v", err)
+	}
+	usage, err := store.GetDailyUsageByUser("user-1")
+	if err != nil || len(usage) != 2 {
+		t.Fatalf("Expected a rollup per model, got %d (%v)", len(usage), err)
+	}
+	if usage[1].Model != "claude-sonnet-4-5" || usage[1].Day != "2025-06-01" || usage[1].InputTokens != 600 || usage[1].Source != consoleSource {
+		t.Errorf("Expected both sessions' sonnet usage in one Console rollup, got %+v", usage[1])
+	}
+}
+
+func TestSessionSpecValidateRejectsInconsistentSpecs(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*SessionSpec)
+		want   string
+	}{
+		{"missing session ID", func(s *SessionSpec) { s.SessionID = "" }, "session ID is required"},
+		{"missing start", func(s *SessionSpec) { s.StartTime = time.Time{} }, "start time is required"},
+		{"negative duration", func(s *SessionSpec) { s.Duration = -time.Minute }, "duration -1m0s is negative"},
+		{"input total below models", func(s *SessionSpec) { s.TotalInputTokens = 399 }, "session input tokens 399 is less than the models' 400"},
+		{"cost total below models", func(s *SessionSpec) { s.TotalCostUSD = 0.5 }, "session cost 0.5 is less than the models' 0.7"},
+		{"request total below models", func(s *SessionSpec) { s.APIRequestCount = 1 }, "session API requests 1 is less than the models' 5"},
+		{"duplicate model", func(s *SessionSpec) { s.Models[1].Model = "claude-haiku-4" }, "model claude-haiku-4 is listed twice"},
+		{"negative model usage", func(s *SessionSpec) { s.Models[0].OutputTokens = -1 }, "model claude-haiku-4 has negative usage"},
+		{"duplicate tool after normalizing", func(s *SessionSpec) { s.Tools[1].Name = "bash" }, "tool Bash is listed twice"},
+		{"more decisions than calls", func(s *SessionSpec) { s.Tools[0].UserApproved = 2 }, "tool Bash has 5 approval decisions for 4 calls"},
+		{"tool total below tools", func(s *SessionSpec) { s.ToolCallCount = 5 }, "tool call count 5 is not the tools' 6 successes and failures"},
+		{"tool total above tools", func(s *SessionSpec) { s.ToolCallCount = 7 }, "tool call count 7 is not the tools' 6 successes and failures"},
+		{"more errors than requests", func(s *SessionSpec) { s.APIErrorCount = 6 }, "API error count 6 is more than the 5 API requests"},
+		{"prompt after the end", func(s *SessionSpec) { s.Prompts[1].Offset = 2 * time.Hour }, "prompt 1 at 2h0m0s falls outside the session's 1h0m0s"},
+		{"console usage without a user", func(s *SessionSpec) { s.UserID, s.ConsoleUsage = "", true }, "console usage requires a user ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := validSessionSpec()
+			tt.modify(&spec)
+			err := spec.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	valid := validSessionSpec()
+	valid.TotalInputTokens, valid.ToolCallCount, valid.APIErrorCount = 1000, 6, 5 // Token totals may exceed what the models account for
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected a valid spec, got %v", err)
+	}
+}
+
+func TestSeedSessionRejectsInvalidSpec(t *testing.T) {
+	dbPath := "./test_seed_invalid.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	spec := validSessionSpec()
+	spec.Tools[0].Failures = -1
+	spec.TotalOutputTokens = 1
+	err = SeedSession(store, spec)
+	if err == nil || !strings.Contains(err.Error(), "tool Bash has negative usage") || !strings.Contains(err.Error(), "session output tokens 1") {
+		t.Errorf("Expected every inconsistency reported, got %v", err)
+	}
+	if session, _ := store.GetSession("seeded-session"); session != nil {
+		t.Errorf("Expected nothing written for an invalid spec, got %+v", session)
+	}
+}
@@ -0,0 +1,234 @@
+package aggregator
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sessionRecorder passes records on to h, noting the sessions they belong to
+type sessionRecorder struct {
+	h        recordHandler
+	sessions map[string]bool
+}
+
+func (r *sessionRecorder) ProcessMetric(record *MetricRecord) {
+	r.sessions[record.SessionID] = true
+	r.h.ProcessMetric(record)
+}
+
+func (r *sessionRecorder) ProcessLog(record *LogRecord) {
+	r.sessions[record.SessionID] = true
+	r.h.ProcessLog(record)
+}
+
+func (r *sessionRecorder) ProcessTrace(record *TraceRecord) {
+	r.sessions[record.SessionID] = true
+	r.h.ProcessTrace(record)
+}
+
+// sessionFileIndex accumulates the session ranges of one file generation
+// between writes to the store
+type sessionFileIndex struct {
+	fileName string
+	inode    uint64
+	ranges   map[string]*SessionFileRange // sessionID -> range
+}
+
+func newSessionFileIndex(fileName string, inode uint64) *sessionFileIndex {
+	return &sessionFileIndex{
+		fileName: fileName,
+		inode:    inode,
+		ranges:   make(map[string]*SessionFileRange),
+	}
+}
+
+// add widens a session's range to cover [start, end)
+func (idx *sessionFileIndex) add(sessionID string, start, end int64) {
+	r, exists := idx.ranges[sessionID]
+	if !exists {
+		idx.ranges[sessionID] = &SessionFileRange{
+			SessionID:   sessionID,
+			FileName:    idx.fileName,
+			Inode:       idx.inode,
+			StartOffset: start,
+			EndOffset:   end,
+		}
+		return
+	}
+	if start < r.StartOffset {
+		r.StartOffset = start
+	}
+	if end > r.EndOffset {
+		r.EndOffset = end
+	}
+}
+
+// SetSessionIndex records which byte ranges of each file hold each session's
+// lines as they are processed, so a session can be re-read for verification
+// without scanning every file. Direct ingestion bypasses the index.
+func (p *Processor) SetSessionIndex(enabled bool) {
+	p.sessionIndex = enabled
+}
+
+// processIndexedLine processes a line occupying [start, end) of the file,
+// adding it to index under every session it holds. A nil index processes the
+// line unindexed.
+func (p *Processor) processIndexedLine(filename, line string, index *sessionFileIndex, start, end int64) error {
+	pl := &pendingLine{line: line, start: start, end: end}
+	pl.decode(p.files, filename)
+	return p.applyLine(pl, index)
+}
+
+// saveSessionIndex writes the ranges accumulated in index and clears them
+func (p *Processor) saveSessionIndex(index *sessionFileIndex) error {
+	if index == nil || len(index.ranges) == 0 {
+		return nil
+	}
+
+	ranges := make([]*SessionFileRange, 0, len(index.ranges))
+	for _, r := range index.ranges {
+		ranges = append(ranges, r)
+	}
+	if err := p.store.UpsertSessionFileRanges(ranges); err != nil {
+		return err
+	}
+
+	index.ranges = make(map[string]*SessionFileRange)
+	return nil
+}
+
+// pruneSessionIndex drops the index of sessions with lines in a generation
+// of the file that is no longer on disk, keeping the index bounded by the
+// retained rotations. A truncated file's offsets now hold new data, so its
+// sessions are dropped too.
+func (p *Processor) pruneSessionIndex(filePath string, truncatedInode uint64) error {
+	inodes, err := generationInodes(filePath)
+	if err != nil {
+		return err
+	}
+
+	var keep []uint64
+	for _, inode := range inodes {
+		if inode != truncatedInode {
+			keep = append(keep, inode)
+		}
+	}
+	return p.store.PruneSessionFileRanges(p.relativeName(filePath), keep)
+}
+
+// generationInodes lists the inodes of filePath and its rotated generations,
+// reading compressed generations' original inode from their gzip header
+func generationInodes(filePath string) ([]uint64, error) {
+	candidates, err := filepath.Glob(filePath + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var inodes []uint64
+	for _, candidate := range append([]string{filePath}, candidates...) {
+		if strings.HasSuffix(candidate, ".tmp") {
+			continue
+		}
+		if !strings.HasSuffix(candidate, ".gz") {
+			if info, err := os.Stat(candidate); err == nil {
+				inodes = append(inodes, getInode(info))
+			}
+			continue
+		}
+
+		file, err := os.Open(candidate)
+		if err != nil {
+			continue
+		}
+		zr, err := gzip.NewReader(file)
+		if err == nil {
+			var inode uint64
+			if _, err := fmt.Sscanf(zr.Comment, gzipInodeComment, &inode); err == nil {
+				inodes = append(inodes, inode)
+			}
+		}
+		file.Close()
+	}
+
+	return inodes, nil
+}
+
+// UpsertSessionFileRanges widens each session's stored range for its file
+// generation to include the given range
+func (s *Store) UpsertSessionFileRanges(ranges []*SessionFileRange) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert := `
+	INSERT INTO session_file_ranges (session_id, file_name, inode, start_offset, end_offset, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(session_id, file_name, inode) DO UPDATE SET
+		start_offset = MIN(start_offset, excluded.start_offset),
+		end_offset = MAX(end_offset, excluded.end_offset),
+		updated_at = excluded.updated_at
+	`
+	stmt, err := tx.Prepare(upsert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+	for _, r := range ranges {
+		if _, err := s.execStmt(tx, stmt, queryUpsertSessionFileRanges, upsert, r.SessionID, r.FileName, r.Inode, r.StartOffset, r.EndOffset, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetSessionFileRanges retrieves the indexed ranges of a session across files
+func (s *Store) GetSessionFileRanges(sessionID string) ([]*SessionFileRange, error) {
+	query := `
+	SELECT session_id, file_name, inode, start_offset, end_offset
+	FROM session_file_ranges WHERE session_id = ?
+	ORDER BY file_name, start_offset
+	`
+
+	rows, err := s.query(queryGetSessionFileRanges, query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ranges []*SessionFileRange
+	for rows.Next() {
+		var r SessionFileRange
+		if err := rows.Scan(&r.SessionID, &r.FileName, &r.Inode, &r.StartOffset, &r.EndOffset); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, &r)
+	}
+
+	return ranges, rows.Err()
+}
+
+// PruneSessionFileRanges drops the whole index of every session with a range
+// in a generation of fileName other than keepInodes. A partly indexed session
+// would look complete, so these sessions fall back to a full scan instead.
+func (s *Store) PruneSessionFileRanges(fileName string, keepInodes []uint64) error {
+	stale := `SELECT session_id FROM session_file_ranges WHERE file_name = ?`
+	args := []interface{}{fileName}
+	if len(keepInodes) > 0 {
+		stale += ` AND inode NOT IN (?` + strings.Repeat(", ?", len(keepInodes)-1) + `)`
+		for _, inode := range keepInodes {
+			args = append(args, inode)
+		}
+	}
+
+	_, err := s.exec(queryPruneSessionFileRanges, `DELETE FROM session_file_ranges WHERE session_id IN (`+stale+`)`, args...)
+	return err
+}
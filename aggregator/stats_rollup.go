@@ -0,0 +1,536 @@
+package aggregator
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	// statsTopN is how many preferred models, favorite tools and top users a
+	// rollup keeps
+	statsTopN = 5
+
+	// DefaultStatsRollupInterval is how often user and org stats are rolled
+	// up when SetStatsRollupInterval has not been called
+	DefaultStatsRollupInterval = 5 * time.Minute
+)
+
+// statsWindows are the rolling windows user and org stats are rolled up for.
+// Custom windows are always computed on request.
+var statsWindows = []string{"all-time", "7d", "30d"}
+
+// rankedName is an entry in a rollup's JSON-encoded top lists
+type rankedName struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+// topNames keeps the first statsTopN entries of a list already sorted best
+// first, encoded as JSON
+func topNames(ranked []rankedName) string {
+	if len(ranked) > statsTopN {
+		ranked = ranked[:statsTopN]
+	}
+	if ranked == nil {
+		ranked = []rankedName{}
+	}
+	data, _ := json.Marshal(ranked)
+	return string(data)
+}
+
+// SetStatsRollupInterval sets how often the periodic flush also rolls up user
+// and org stats; zero disables the rollup
+func (e *Engine) SetStatsRollupInterval(interval time.Duration) {
+	e.statsRollupInterval.Store(int64(interval))
+}
+
+// statsRollupDue reports whether a rollup last run at last is due again
+func (e *Engine) statsRollupDue(last time.Time) bool {
+	interval := time.Duration(e.statsRollupInterval.Load())
+	return interval > 0 && time.Since(last) >= interval
+}
+
+// RollupStats recomputes every user's and organization's stats for each
+// rolling window as of now, replacing the previous rollup
+func (e *Engine) RollupStats(now time.Time) error {
+	if e.store == nil {
+		return nil
+	}
+
+	for _, windowType := range statsWindows {
+		window, err := ParseTimeWindow(windowType, "", "", now)
+		if err != nil {
+			return err
+		}
+
+		users, err := e.store.ComputeUserStats(window, now)
+		if err != nil {
+			return err
+		}
+		if err := e.store.UpsertUserStats(windowType, users, now); err != nil {
+			return err
+		}
+
+		orgs, err := e.store.ComputeOrgStats(window, now)
+		if err != nil {
+			return err
+		}
+		if err := e.store.UpsertOrgStats(windowType, orgs, now); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Rolled up user and org stats for %d windows", len(statsWindows))
+	return nil
+}
+
+// ComputeUserStats sums each user's sessions started in the window
+func (s *Store) ComputeUserStats(window TimeWindow, now time.Time) ([]*UserStats, error) {
+	// SQLite takes bare columns from the row holding the MAX, so each user's
+	// organization is the one of their latest session
+	query := `
+	SELECT
+		user_id, organization_id, COUNT(*),
+		COALESCE(SUM(total_cost_usd), 0), COALESCE(SUM(total_input_tokens), 0),
+		COALESCE(SUM(total_output_tokens), 0), COALESCE(SUM(total_cache_read_tokens), 0),
+		COALESCE(SUM(total_cache_creation_tokens), 0), COALESCE(SUM(total_active_time_seconds), 0),
+		COALESCE(AVG(COALESCE(end_time, start_time) - start_time), 0),
+		COALESCE(SUM(tool_success_count), 0), COALESCE(SUM(tool_failure_count), 0),
+		MAX(start_time)
+	FROM sessions
+	WHERE user_id != '' AND start_time >= ? AND start_time < ?
+	GROUP BY user_id
+	ORDER BY user_id ASC
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryComputeUserStats, query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*UserStats
+	byUser := make(map[string]*UserStats)
+	for rows.Next() {
+		us := &UserStats{
+			WindowStart: window.Start,
+			WindowEnd:   window.End,
+			WindowType:  window.Type,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		var successes, failures int
+		var lastSession int64
+		if err := rows.Scan(
+			&us.UserID, &us.OrganizationID, &us.TotalSessions,
+			&us.TotalCostUSD, &us.TotalInputTokens, &us.TotalOutputTokens,
+			&us.TotalCacheReadTokens, &us.TotalCacheCreationTokens,
+			&us.TotalActiveTimeSeconds, &us.AvgSessionDurationSeconds,
+			&successes, &failures, &lastSession,
+		); err != nil {
+			return nil, err
+		}
+		us.AvgCostPerSession = ratio(us.TotalCostUSD, float64(us.TotalSessions))
+		us.AvgTokensPerSession = ratio(float64(us.TotalInputTokens+us.TotalOutputTokens+us.TotalCacheReadTokens), float64(us.TotalSessions))
+		us.ToolSuccessRate = ratio(float64(successes), float64(successes+failures))
+		us.LastSessionTime = time.Unix(lastSession, 0)
+		stats = append(stats, us)
+		byUser[us.UserID] = us
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	models, err := s.rankUserNames(queryComputeUserModels, `
+	SELECT s.user_id, m.model, COUNT(*) AS used
+	FROM session_models m
+	JOIN sessions s ON s.session_id = m.session_id
+	WHERE s.user_id != '' AND s.start_time >= ? AND s.start_time < ?
+	GROUP BY s.user_id, m.model
+	ORDER BY s.user_id ASC, used DESC, m.model ASC
+	`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	tools, err := s.rankUserNames(queryComputeUserTools, `
+	SELECT s.user_id, t.tool_name, SUM(t.call_count) AS calls
+	FROM session_tools t
+	JOIN sessions s ON s.session_id = t.session_id
+	WHERE s.user_id != '' AND s.start_time >= ? AND s.start_time < ?
+	GROUP BY s.user_id, t.tool_name
+	ORDER BY s.user_id ASC, calls DESC, t.tool_name ASC
+	`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for userID, us := range byUser {
+		us.PreferredModels = topNames(models[userID])
+		us.FavoriteTools = topNames(tools[userID])
+	}
+
+	return stats, nil
+}
+
+// rankUserNames runs a query returning user, name and value rows ordered
+// best first within each user, and groups them by user
+func (s *Store) rankUserNames(name, query string, args ...interface{}) (map[string][]rankedName, error) {
+	rows, err := s.query(name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ranked := make(map[string][]rankedName)
+	for rows.Next() {
+		var userID string
+		var rn rankedName
+		if err := rows.Scan(&userID, &rn.Name, &rn.Value); err != nil {
+			return nil, err
+		}
+		ranked[userID] = append(ranked[userID], rn)
+	}
+	return ranked, rows.Err()
+}
+
+// ComputeOrgStats sums each organization's sessions started in the window
+func (s *Store) ComputeOrgStats(window TimeWindow, now time.Time) ([]*OrgStats, error) {
+	query := `
+	SELECT
+		organization_id, COUNT(DISTINCT NULLIF(user_id, '')), COUNT(*),
+		COALESCE(SUM(total_cost_usd), 0),
+		COALESCE(SUM(total_input_tokens + total_output_tokens + total_cache_read_tokens), 0),
+		COALESCE(SUM(total_active_time_seconds), 0)
+	FROM sessions
+	WHERE organization_id != '' AND start_time >= ? AND start_time < ?
+	GROUP BY organization_id
+	ORDER BY organization_id ASC
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryComputeOrgStats, query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*OrgStats
+	byOrg := make(map[string]*OrgStats)
+	for rows.Next() {
+		org := &OrgStats{
+			WindowStart: window.Start,
+			WindowEnd:   window.End,
+			WindowType:  window.Type,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if err := rows.Scan(
+			&org.OrganizationID, &org.TotalUsers, &org.TotalSessions,
+			&org.TotalCostUSD, &org.TotalTokens, &org.TotalActiveTimeSeconds,
+		); err != nil {
+			return nil, err
+		}
+		org.AvgCostPerUser = ratio(org.TotalCostUSD, float64(org.TotalUsers))
+		org.AvgSessionsPerUser = ratio(float64(org.TotalSessions), float64(org.TotalUsers))
+		stats = append(stats, org)
+		byOrg[org.OrganizationID] = org
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byCost, err := s.rankUserNames(queryComputeOrgTopUsersByCost, `
+	SELECT organization_id, user_id, SUM(total_cost_usd) AS cost
+	FROM sessions
+	WHERE organization_id != '' AND user_id != '' AND start_time >= ? AND start_time < ?
+	GROUP BY organization_id, user_id
+	ORDER BY organization_id ASC, cost DESC, user_id ASC
+	`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	byUsage, err := s.rankUserNames(queryComputeOrgTopUsersByUsage, `
+	SELECT organization_id, user_id,
+		SUM(total_input_tokens + total_output_tokens + total_cache_read_tokens) AS tokens
+	FROM sessions
+	WHERE organization_id != '' AND user_id != '' AND start_time >= ? AND start_time < ?
+	GROUP BY organization_id, user_id
+	ORDER BY organization_id ASC, tokens DESC, user_id ASC
+	`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for orgID, org := range byOrg {
+		org.TopUsersByCost = topNames(byCost[orgID])
+		org.TopUsersByUsage = topNames(byUsage[orgID])
+	}
+
+	return stats, nil
+}
+
+// statsWindowBounds returns a window's bounds as stored, with NULL for the
+// open ends of all-time
+func statsWindowBounds(start, end time.Time) (interface{}, interface{}) {
+	var from, to interface{}
+	if !start.IsZero() {
+		from = start.Unix()
+	}
+	if !end.IsZero() {
+		to = end.Unix()
+	}
+	return from, to
+}
+
+// UpsertUserStats saves a rollup of users' stats for a window, computed at
+// now. Users the rollup no longer covers, with no sessions left in the
+// window, are removed.
+func (s *Store) UpsertUserStats(windowType string, stats []*UserStats, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert := `
+	INSERT INTO user_stats (
+		user_id, window_type, organization_id, window_start, window_end,
+		total_sessions, total_cost_usd, total_input_tokens, total_output_tokens,
+		total_cache_read_tokens, total_cache_creation_tokens, total_active_time_seconds,
+		avg_cost_per_session, avg_tokens_per_session, avg_session_duration_seconds,
+		preferred_models, favorite_tools, tool_success_rate, last_session_time,
+		created_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(user_id, window_type) DO UPDATE SET
+		organization_id = excluded.organization_id,
+		window_start = excluded.window_start,
+		window_end = excluded.window_end,
+		total_sessions = excluded.total_sessions,
+		total_cost_usd = excluded.total_cost_usd,
+		total_input_tokens = excluded.total_input_tokens,
+		total_output_tokens = excluded.total_output_tokens,
+		total_cache_read_tokens = excluded.total_cache_read_tokens,
+		total_cache_creation_tokens = excluded.total_cache_creation_tokens,
+		total_active_time_seconds = excluded.total_active_time_seconds,
+		avg_cost_per_session = excluded.avg_cost_per_session,
+		avg_tokens_per_session = excluded.avg_tokens_per_session,
+		avg_session_duration_seconds = excluded.avg_session_duration_seconds,
+		preferred_models = excluded.preferred_models,
+		favorite_tools = excluded.favorite_tools,
+		tool_success_rate = excluded.tool_success_rate,
+		last_session_time = excluded.last_session_time,
+		updated_at = excluded.updated_at
+	`
+	stmt, err := tx.Prepare(upsert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, us := range stats {
+		windowStart, windowEnd := statsWindowBounds(us.WindowStart, us.WindowEnd)
+		if _, err := s.execStmt(tx, stmt, queryUpsertUserStats, upsert,
+			us.UserID, windowType, us.OrganizationID, windowStart, windowEnd,
+			us.TotalSessions, us.TotalCostUSD, us.TotalInputTokens, us.TotalOutputTokens,
+			us.TotalCacheReadTokens, us.TotalCacheCreationTokens, us.TotalActiveTimeSeconds,
+			us.AvgCostPerSession, us.AvgTokensPerSession, us.AvgSessionDurationSeconds,
+			us.PreferredModels, us.FavoriteTools, us.ToolSuccessRate, us.LastSessionTime.Unix(),
+			now.Unix(), now.Unix()); err != nil {
+			return err
+		}
+	}
+
+	prune := `DELETE FROM user_stats WHERE window_type = ? AND updated_at < ?`
+	if _, err := s.execOn(tx, queryPruneUserStats, prune, windowType, now.Unix()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpsertOrgStats saves a rollup of organizations' stats for a window,
+// computed at now, removing organizations it no longer covers
+func (s *Store) UpsertOrgStats(windowType string, stats []*OrgStats, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert := `
+	INSERT INTO org_stats (
+		organization_id, window_type, window_start, window_end,
+		total_users, total_sessions, total_cost_usd, total_tokens, total_active_time_seconds,
+		avg_cost_per_user, avg_sessions_per_user, top_users_by_cost, top_users_by_usage,
+		created_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(organization_id, window_type) DO UPDATE SET
+		window_start = excluded.window_start,
+		window_end = excluded.window_end,
+		total_users = excluded.total_users,
+		total_sessions = excluded.total_sessions,
+		total_cost_usd = excluded.total_cost_usd,
+		total_tokens = excluded.total_tokens,
+		total_active_time_seconds = excluded.total_active_time_seconds,
+		avg_cost_per_user = excluded.avg_cost_per_user,
+		avg_sessions_per_user = excluded.avg_sessions_per_user,
+		top_users_by_cost = excluded.top_users_by_cost,
+		top_users_by_usage = excluded.top_users_by_usage,
+		updated_at = excluded.updated_at
+	`
+	stmt, err := tx.Prepare(upsert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, org := range stats {
+		windowStart, windowEnd := statsWindowBounds(org.WindowStart, org.WindowEnd)
+		if _, err := s.execStmt(tx, stmt, queryUpsertOrgStats, upsert,
+			org.OrganizationID, windowType, windowStart, windowEnd,
+			org.TotalUsers, org.TotalSessions, org.TotalCostUSD, org.TotalTokens, org.TotalActiveTimeSeconds,
+			org.AvgCostPerUser, org.AvgSessionsPerUser, org.TopUsersByCost, org.TopUsersByUsage,
+			now.Unix(), now.Unix()); err != nil {
+			return err
+		}
+	}
+
+	prune := `DELETE FROM org_stats WHERE window_type = ? AND updated_at < ?`
+	if _, err := s.execOn(tx, queryPruneOrgStats, prune, windowType, now.Unix()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetUserStats retrieves a user's rolled up stats for a window type, or nil
+// when there is no rollup for them
+func (s *Store) GetUserStats(userID, windowType string) (*UserStats, error) {
+	query := `
+	SELECT
+		user_id, window_type, organization_id, window_start, window_end,
+		total_sessions, total_cost_usd, total_input_tokens, total_output_tokens,
+		total_cache_read_tokens, total_cache_creation_tokens, total_active_time_seconds,
+		avg_cost_per_session, avg_tokens_per_session, avg_session_duration_seconds,
+		preferred_models, favorite_tools, tool_success_rate, last_session_time,
+		created_at, updated_at
+	FROM user_stats
+	WHERE user_id = ? AND window_type = ?
+	`
+
+	var us UserStats
+	var windowStart, windowEnd, lastSession sql.NullInt64
+	var createdAt, updatedAt int64
+	err := s.queryRow(queryGetUserStats, query, userID, windowType).Scan(
+		&us.UserID, &us.WindowType, &us.OrganizationID, &windowStart, &windowEnd,
+		&us.TotalSessions, &us.TotalCostUSD, &us.TotalInputTokens, &us.TotalOutputTokens,
+		&us.TotalCacheReadTokens, &us.TotalCacheCreationTokens, &us.TotalActiveTimeSeconds,
+		&us.AvgCostPerSession, &us.AvgTokensPerSession, &us.AvgSessionDurationSeconds,
+		&us.PreferredModels, &us.FavoriteTools, &us.ToolSuccessRate, &lastSession,
+		&createdAt, &updatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	us.WindowStart, us.WindowEnd = nullTime(windowStart), nullTime(windowEnd)
+	us.LastSessionTime = nullTime(lastSession)
+	us.CreatedAt, us.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+	return &us, nil
+}
+
+// GetOrgStats retrieves an organization's rolled up stats for a window type,
+// or nil when there is no rollup for it
+func (s *Store) GetOrgStats(orgID, windowType string) (*OrgStats, error) {
+	query := `
+	SELECT
+		organization_id, window_type, window_start, window_end,
+		total_users, total_sessions, total_cost_usd, total_tokens, total_active_time_seconds,
+		avg_cost_per_user, avg_sessions_per_user, top_users_by_cost, top_users_by_usage,
+		created_at, updated_at
+	FROM org_stats
+	WHERE organization_id = ? AND window_type = ?
+	`
+
+	var org OrgStats
+	var windowStart, windowEnd sql.NullInt64
+	var createdAt, updatedAt int64
+	err := s.queryRow(queryGetOrgStats, query, orgID, windowType).Scan(
+		&org.OrganizationID, &org.WindowType, &windowStart, &windowEnd,
+		&org.TotalUsers, &org.TotalSessions, &org.TotalCostUSD, &org.TotalTokens, &org.TotalActiveTimeSeconds,
+		&org.AvgCostPerUser, &org.AvgSessionsPerUser, &org.TopUsersByCost, &org.TopUsersByUsage,
+		&createdAt, &updatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	org.WindowStart, org.WindowEnd = nullTime(windowStart), nullTime(windowEnd)
+	org.CreatedAt, org.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+	return &org, nil
+}
+
+// nullTime converts a nullable Unix timestamp, giving the zero time for NULL
+func nullTime(t sql.NullInt64) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return time.Unix(t.Int64, 0)
+}
+
+// buildUserStatsRollupResponse builds the JSON for a user's rolled up stats
+func buildUserStatsRollupResponse(us *UserStats) map[string]interface{} {
+	return map[string]interface{}{
+		"window":       us.WindowType,
+		"window_start": jsonTime(us.WindowStart),
+		"window_end":   jsonTime(us.WindowEnd),
+		"computed_at":  jsonTime(us.UpdatedAt),
+		"sessions":     us.TotalSessions,
+		"costs": map[string]interface{}{
+			"total_usd":       us.TotalCostUSD,
+			"avg_per_session": us.AvgCostPerSession,
+		},
+		"tokens": map[string]interface{}{
+			"input":           us.TotalInputTokens,
+			"output":          us.TotalOutputTokens,
+			"cache_read":      us.TotalCacheReadTokens,
+			"cache_creation":  us.TotalCacheCreationTokens,
+			"avg_per_session": us.AvgTokensPerSession,
+		},
+		"active_time_seconds":          us.TotalActiveTimeSeconds,
+		"avg_session_duration_seconds": us.AvgSessionDurationSeconds,
+		"tool_success_rate":            us.ToolSuccessRate,
+		"preferred_models":             json.RawMessage(us.PreferredModels),
+		"favorite_tools":               json.RawMessage(us.FavoriteTools),
+		"last_session":                 jsonTime(us.LastSessionTime),
+	}
+}
+
+// buildOrgStatsRollupResponse builds the JSON for an organization's rolled
+// up stats
+func buildOrgStatsRollupResponse(org *OrgStats) map[string]interface{} {
+	return map[string]interface{}{
+		"window":       org.WindowType,
+		"window_start": jsonTime(org.WindowStart),
+		"window_end":   jsonTime(org.WindowEnd),
+		"computed_at":  jsonTime(org.UpdatedAt),
+		"users":        org.TotalUsers,
+		"sessions":     org.TotalSessions,
+		"costs": map[string]interface{}{
+			"total_usd":    org.TotalCostUSD,
+			"avg_per_user": org.AvgCostPerUser,
+		},
+		"tokens":                org.TotalTokens,
+		"active_time_seconds":   org.TotalActiveTimeSeconds,
+		"avg_sessions_per_user": org.AvgSessionsPerUser,
+		"top_users_by_cost":     json.RawMessage(org.TopUsersByCost),
+		"top_users_by_usage":    json.RawMessage(org.TopUsersByUsage),
+	}
+}
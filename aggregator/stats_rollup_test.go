@@ -0,0 +1,201 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// seedRollupSessions seeds sessions for two users in org-a, one recent and
+// one three weeks old for user-1, and an older one in org-b
+func seedRollupSessions(t *testing.T, store *Store, now time.Time) {
+	t.Helper()
+
+	for _, spec := range []SessionSpec{
+		{
+			SessionID: "recent", UserID: "user-1", OrganizationID: "org-a",
+			StartTime: now.Add(-48 * time.Hour), Duration: 30 * time.Minute,
+			Models: []ModelSpec{{Model: "claude-sonnet-4", Requests: 2, CostUSD: 3, InputTokens: 100, OutputTokens: 50}},
+			Tools:  []ToolSpec{{Name: "Read", Successes: 3, Failures: 1}, {Name: "Edit", Successes: 1}},
+		},
+		{
+			SessionID: "older", UserID: "user-1", OrganizationID: "org-a",
+			StartTime: now.AddDate(0, 0, -20), Duration: 10 * time.Minute,
+			Models: []ModelSpec{{Model: "claude-opus-4", Requests: 1, CostUSD: 1, InputTokens: 10, OutputTokens: 5}},
+		},
+		{
+			SessionID: "teammate", UserID: "user-2", OrganizationID: "org-a",
+			StartTime: now.Add(-24 * time.Hour), Duration: time.Hour,
+			Models: []ModelSpec{{Model: "claude-sonnet-4", Requests: 4, CostUSD: 5, InputTokens: 400, OutputTokens: 200}},
+		},
+		{
+			SessionID: "other-org", UserID: "user-3", OrganizationID: "org-b",
+			StartTime: now.AddDate(0, 0, -40), Duration: time.Hour, TotalCostUSD: 2,
+		},
+	} {
+		if err := SeedSession(store, spec); err != nil {
+			t.Fatalf("Failed to seed %s: %v", spec.SessionID, err)
+		}
+	}
+}
+
+func TestRollupStats(t *testing.T) {
+	server := newTestAPIServer(t, "./test_stats_rollup.db")
+	now := time.Now().Truncate(time.Second)
+	seedRollupSessions(t, server.store, now)
+
+	engine := newEngine(server.store)
+	if err := engine.RollupStats(now); err != nil {
+		t.Fatalf("Failed to roll up stats: %v", err)
+	}
+
+	tests := []struct {
+		userID, window string
+		sessions       int
+		cost           float64
+	}{
+		{"user-1", "7d", 1, 3},
+		{"user-1", "30d", 2, 4},
+		{"user-1", "all-time", 2, 4},
+		{"user-3", "30d", 0, 0},
+		{"user-3", "all-time", 1, 2},
+	}
+	for _, tt := range tests {
+		stats, err := server.store.GetUserStats(tt.userID, tt.window)
+		if err != nil {
+			t.Fatalf("Failed to get %s %s stats: %v", tt.userID, tt.window, err)
+		}
+		if tt.sessions == 0 {
+			if stats != nil {
+				t.Errorf("Expected no %s rollup for %s, got %+v", tt.window, tt.userID, stats)
+			}
+			continue
+		}
+		if stats == nil {
+			t.Fatalf("Expected a %s rollup for %s", tt.window, tt.userID)
+		}
+		if stats.TotalSessions != tt.sessions || stats.TotalCostUSD != tt.cost {
+			t.Errorf("%s %s: expected %d sessions costing %v, got %d costing %v",
+				tt.userID, tt.window, tt.sessions, tt.cost, stats.TotalSessions, stats.TotalCostUSD)
+		}
+	}
+
+	stats, err := server.store.GetUserStats("user-1", "30d")
+	if err != nil || stats == nil {
+		t.Fatalf("Failed to get user-1 30d stats: %v", err)
+	}
+	if stats.OrganizationID != "org-a" || stats.AvgCostPerSession != 2 || stats.ToolSuccessRate != 0.8 {
+		t.Errorf("Expected org-a, $2 per session and 80%% tool success, got %+v", stats)
+	}
+	if stats.PreferredModels != `[{"name":"claude-opus-4","value":1},{"name":"claude-sonnet-4","value":1}]` {
+		t.Errorf("Expected both models used once, got %s", stats.PreferredModels)
+	}
+	if stats.FavoriteTools != `[{"name":"Read","value":4},{"name":"Edit","value":1}]` {
+		t.Errorf("Expected Read then Edit, got %s", stats.FavoriteTools)
+	}
+	if !stats.LastSessionTime.Equal(now.Add(-48*time.Hour)) || !stats.WindowEnd.Equal(now) {
+		t.Errorf("Expected the recent session last and the window ending now, got %v and %v", stats.LastSessionTime, stats.WindowEnd)
+	}
+
+	org, err := server.store.GetOrgStats("org-a", "7d")
+	if err != nil || org == nil {
+		t.Fatalf("Failed to get org-a 7d stats: %v", err)
+	}
+	if org.TotalUsers != 2 || org.TotalSessions != 2 || org.TotalCostUSD != 8 || org.AvgCostPerUser != 4 {
+		t.Errorf("Expected 2 users with 2 sessions costing $8, got %+v", org)
+	}
+	if org.TopUsersByCost != `[{"name":"user-2","value":5},{"name":"user-1","value":3}]` {
+		t.Errorf("Expected user-2 then user-1 by cost, got %s", org.TopUsersByCost)
+	}
+	if org.TopUsersByUsage != `[{"name":"user-2","value":600},{"name":"user-1","value":150}]` {
+		t.Errorf("Expected user-2 then user-1 by tokens, got %s", org.TopUsersByUsage)
+	}
+}
+
+func TestRollupStatsPrunesUsersOutOfWindow(t *testing.T) {
+	server := newTestAPIServer(t, "./test_stats_rollup_prune.db")
+	now := time.Now().Truncate(time.Second)
+	seedRollupSessions(t, server.store, now)
+
+	engine := newEngine(server.store)
+	if err := engine.RollupStats(now); err != nil {
+		t.Fatalf("Failed to roll up stats: %v", err)
+	}
+
+	// Ten days on, nobody has a session in the last week
+	later := now.AddDate(0, 0, 10)
+	if err := engine.RollupStats(later); err != nil {
+		t.Fatalf("Failed to roll up stats: %v", err)
+	}
+
+	if stats, err := server.store.GetUserStats("user-1", "7d"); err != nil || stats != nil {
+		t.Errorf("Expected user-1's 7d rollup removed, got %+v (err %v)", stats, err)
+	}
+	if org, err := server.store.GetOrgStats("org-a", "7d"); err != nil || org != nil {
+		t.Errorf("Expected org-a's 7d rollup removed, got %+v (err %v)", org, err)
+	}
+	stats, err := server.store.GetUserStats("user-1", "all-time")
+	if err != nil || stats == nil || !stats.UpdatedAt.Equal(later) {
+		t.Errorf("Expected user-1's all-time rollup recomputed at %v, got %+v (err %v)", later, stats, err)
+	}
+}
+
+func TestStatsEndpointsWindowStats(t *testing.T) {
+	server := newTestAPIServer(t, "./test_api_window_stats.db")
+	now := time.Now().Truncate(time.Second)
+	seedRollupSessions(t, server.store, now)
+
+	get := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		server.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var decoded map[string]interface{}
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&decoded); err != nil {
+				t.Fatalf("%s: failed to decode response: %v", path, err)
+			}
+		}
+		return rec.Code, decoded
+	}
+
+	// Before the first rollup the field is present and null
+	for _, path := range []string{"/api/stats/user/user-1?window=7d", "/api/stats/org/org-a?window=7d"} {
+		code, decoded := get(path)
+		if value, ok := field(decoded, "window_stats"); code != http.StatusOK || !ok || value != nil {
+			t.Errorf("%s: expected a null window_stats, got %d and %v (present %v)", path, code, value, ok)
+		}
+	}
+
+	if err := newEngine(server.store).RollupStats(now); err != nil {
+		t.Fatalf("Failed to roll up stats: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		keys     []string
+		expected interface{}
+	}{
+		{"/api/stats/user/user-1?window=7d", []string{"window_stats", "sessions"}, float64(1)},
+		{"/api/stats/user/user-1?window=30d", []string{"window_stats", "costs", "total_usd"}, float64(4)},
+		{"/api/stats/user/user-1", []string{"window_stats", "window"}, "all-time"},
+		{"/api/stats/user/user-1", []string{"window_stats", "window_start"}, nil},
+		{"/api/stats/org/org-a?window=7d", []string{"window_stats", "users"}, float64(2)},
+		{"/api/stats/org/org-b?window=30d", []string{"window_stats"}, nil},
+		{"/api/stats/org/org-b", []string{"window_stats", "sessions"}, float64(1)},
+		{"/api/stats/user/user-1?window=custom&start=2020-01-01T00:00:00Z&end=2030-01-01T00:00:00Z", []string{"window_stats"}, nil},
+	}
+	for _, tt := range tests {
+		code, decoded := get(tt.path)
+		if code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", tt.path, code)
+		}
+		if value, ok := field(decoded, tt.keys...); !ok || value != tt.expected {
+			t.Errorf("%s: expected %v of %v, got %v (present %v)", tt.path, tt.keys, tt.expected, value, ok)
+		}
+	}
+
+	if code, _ := get("/api/stats/org/org-a?window=90d"); code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown window rejected, got %d", code)
+	}
+}
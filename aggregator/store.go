@@ -4,58 +4,74 @@ import (
 	"database/sql"
 	"embed"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/pressly/goose/v3"
 )
 
 //go:embed migrations/*.sql
 var embedMigrations embed.FS
 
 type Store struct {
-	db *sql.DB
+	db           *sql.DB
+	queries      *queryLog
+	availability availability
+
+	// memory is set for an in-memory database, which has a single
+	// connection that no query may hold while its caller waits on a client
+	memory bool
 }
 
 // NewStore creates a new Store instance and initializes the database
 func NewStore(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	store, err := OpenStore(dbPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
-
-	// Enable WAL mode for better concurrent access
-	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
-		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
+		return nil, err
 	}
 
-	store := &Store{db: db}
 	if err := store.RunMigrations(); err != nil {
+		store.Close()
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
 	return store, nil
 }
 
-// RunMigrations runs all pending database migrations using goose
-func (s *Store) RunMigrations() error {
-	// Handle legacy databases that exist but weren't created with goose
-	// by applying necessary schema fixes before running migrations
-	if err := s.applyLegacyFixes(); err != nil {
-		return fmt.Errorf("failed to apply legacy fixes: %w", err)
+// OpenStore opens the database without running migrations, so a database
+// whose migrations fail can still be inspected and repaired
+func OpenStore(dbPath string) (*Store, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	goose.SetBaseFS(embedMigrations)
-
-	if err := goose.SetDialect("sqlite3"); err != nil {
-		return fmt.Errorf("failed to set dialect: %w", err)
+	// Every connection to :memory: opens its own empty database, so an
+	// in-memory store keeps a single connection open for its lifetime
+	if isMemoryDB(dbPath) {
+		db.SetMaxOpenConns(1)
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
 	}
 
-	if err := goose.Up(s.db, "migrations"); err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
+	// Enable WAL mode for better concurrent access
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
 	}
 
-	return nil
+	return &Store{db: db, queries: newQueryLog(), memory: isMemoryDB(dbPath)}, nil
+}
+
+// isMemoryDB reports whether dbPath names an in-memory SQLite database
+func isMemoryDB(dbPath string) bool {
+	return dbPath == ":memory:" || strings.HasPrefix(dbPath, "file::memory:") || strings.Contains(dbPath, "mode=memory")
+}
+
+// RunMigrations runs all pending database migrations using goose
+func (s *Store) RunMigrations() error {
+	return s.runMigrations(migrationsFS())
 }
 
 // applyLegacyFixes handles databases that were created before goose migrations
@@ -64,7 +80,7 @@ func (s *Store) RunMigrations() error {
 func (s *Store) applyLegacyFixes() error {
 	// Check if this is a legacy database (has tables but no goose version table)
 	var hasLegacyTables int
-	err := s.db.QueryRow(`
+	err := s.queryRow(queryApplyLegacyFixes, `
 		SELECT COUNT(*) FROM sqlite_master
 		WHERE type='table' AND name='session_stats'
 	`).Scan(&hasLegacyTables)
@@ -73,7 +89,7 @@ func (s *Store) applyLegacyFixes() error {
 	}
 
 	var hasGooseTable int
-	err = s.db.QueryRow(`
+	err = s.queryRow(queryApplyLegacyFixes, `
 		SELECT COUNT(*) FROM sqlite_master
 		WHERE type='table' AND name='goose_db_version'
 	`).Scan(&hasGooseTable)
@@ -83,7 +99,7 @@ func (s *Store) applyLegacyFixes() error {
 
 	if hasLegacyTables > 0 && hasGooseTable == 0 {
 		// This is a legacy database - create goose table and mark migration 001 as applied
-		_, err = s.db.Exec(`
+		_, err = s.exec(queryApplyLegacyFixes, `
 			CREATE TABLE IF NOT EXISTS goose_db_version (
 				id INTEGER PRIMARY KEY AUTOINCREMENT,
 				version_id INTEGER NOT NULL,
@@ -96,7 +112,7 @@ func (s *Store) applyLegacyFixes() error {
 		}
 
 		// Mark migration 001 (initial schema) as already applied
-		_, err = s.db.Exec(`
+		_, err = s.exec(queryApplyLegacyFixes, `
 			INSERT INTO goose_db_version (version_id, is_applied) VALUES (1, 1)
 		`)
 		if err != nil {
@@ -112,204 +128,72 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
-// UpsertSessionStats inserts or updates session statistics
-func (s *Store) UpsertSessionStats(stats *SessionStats) error {
+// UpdateProcessingState updates the processing state for a file
+func (s *Store) UpdateProcessingState(fileName string, lineNumber int64, byteOffset int64, fileSize int64, inode uint64) error {
 	query := `
-	INSERT INTO session_stats (
-		session_id, user_id, organization_id, service_name,
-		start_time, last_update_time,
-		terminal_type, host_arch, os_type,
-		total_cost_usd, total_input_tokens, total_output_tokens,
-		total_cache_read_tokens, total_cache_creation_tokens, total_active_time_seconds,
-		api_request_count, user_prompt_count, tool_execution_count,
-		tool_success_count, tool_failure_count,
-		avg_api_latency_ms, total_api_latency_ms,
-		models_used, tools_used,
-		created_at, updated_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(session_id) DO UPDATE SET
-		last_update_time = excluded.last_update_time,
-		total_cost_usd = excluded.total_cost_usd,
-		total_input_tokens = excluded.total_input_tokens,
-		total_output_tokens = excluded.total_output_tokens,
-		total_cache_read_tokens = excluded.total_cache_read_tokens,
-		total_cache_creation_tokens = excluded.total_cache_creation_tokens,
-		total_active_time_seconds = excluded.total_active_time_seconds,
-		api_request_count = excluded.api_request_count,
-		user_prompt_count = excluded.user_prompt_count,
-		tool_execution_count = excluded.tool_execution_count,
-		tool_success_count = excluded.tool_success_count,
-		tool_failure_count = excluded.tool_failure_count,
-		avg_api_latency_ms = excluded.avg_api_latency_ms,
-		total_api_latency_ms = excluded.total_api_latency_ms,
-		models_used = excluded.models_used,
-		tools_used = excluded.tools_used,
+	INSERT INTO processing_state (file_name, last_processed_line, last_byte_offset, last_processed_time, file_size_bytes, inode, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(file_name) DO UPDATE SET
+		last_processed_line = excluded.last_processed_line,
+		last_byte_offset = excluded.last_byte_offset,
+		last_processed_time = excluded.last_processed_time,
+		file_size_bytes = excluded.file_size_bytes,
+		inode = excluded.inode,
 		updated_at = excluded.updated_at
 	`
 
-	_, err := s.db.Exec(query,
-		stats.SessionID, stats.UserID, stats.OrganizationID, stats.ServiceName,
-		stats.StartTime.Unix(), stats.LastUpdateTime.Unix(),
-		stats.TerminalType, stats.HostArch, stats.OSType,
-		stats.TotalCostUSD, stats.TotalInputTokens, stats.TotalOutputTokens,
-		stats.TotalCacheReadTokens, stats.TotalCacheCreationTokens, stats.TotalActiveTimeSeconds,
-		stats.APIRequestCount, stats.UserPromptCount, stats.ToolExecutionCount,
-		stats.ToolSuccessCount, stats.ToolFailureCount,
-		stats.AvgAPILatencyMS, stats.TotalAPILatencyMS,
-		stats.ModelsUsed, stats.ToolsUsed,
-		stats.CreatedAt.Unix(), stats.UpdatedAt.Unix(),
-	)
-
+	now := time.Now().Unix()
+	_, err := s.exec(queryUpdateProcessingState, query, fileName, lineNumber, byteOffset, now, fileSize, inode, now)
 	return err
 }
 
-// UpsertSessionModelStats upserts model statistics for a session
-func (s *Store) UpsertSessionModelStats(modelStats *SessionModelStats) error {
-	query := `
-	INSERT INTO session_model_stats (
-		session_id, model, cost_usd, input_tokens, output_tokens,
-		cache_read_tokens, cache_creation_tokens, request_count,
-		total_latency_ms, avg_latency_ms
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(session_id, model) DO UPDATE SET
-		cost_usd = excluded.cost_usd,
-		input_tokens = excluded.input_tokens,
-		output_tokens = excluded.output_tokens,
-		cache_read_tokens = excluded.cache_read_tokens,
-		cache_creation_tokens = excluded.cache_creation_tokens,
-		request_count = excluded.request_count,
-		total_latency_ms = excluded.total_latency_ms,
-		avg_latency_ms = excluded.avg_latency_ms
-	`
-
-	_, err := s.db.Exec(query,
-		modelStats.SessionID, modelStats.Model, modelStats.CostUSD,
-		modelStats.InputTokens, modelStats.OutputTokens,
-		modelStats.CacheReadTokens, modelStats.CacheCreationTokens,
-		modelStats.RequestCount, modelStats.TotalLatencyMS, modelStats.AvgLatencyMS,
-	)
-
-	return err
+// IsArchiveProcessed reports whether the rotated generation of fileName with
+// the given inode has been backfilled
+func (s *Store) IsArchiveProcessed(fileName string, inode uint64) (bool, error) {
+	var count int
+	err := s.queryRow(queryIsArchiveProcessed, `SELECT COUNT(*) FROM processed_archives WHERE file_name = ? AND inode = ?`, fileName, inode).Scan(&count)
+	return count > 0, err
 }
 
-// UpsertSessionToolStats upserts tool statistics for a session
-func (s *Store) UpsertSessionToolStats(toolStats *SessionToolStats) error {
-	query := `
-	INSERT INTO session_tool_stats (
-		session_id, tool_name, execution_count, success_count, failure_count,
-		total_duration_ms, avg_duration_ms, min_duration_ms, max_duration_ms
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(session_id, tool_name) DO UPDATE SET
-		execution_count = excluded.execution_count,
-		success_count = excluded.success_count,
-		failure_count = excluded.failure_count,
-		total_duration_ms = excluded.total_duration_ms,
-		avg_duration_ms = excluded.avg_duration_ms,
-		min_duration_ms = excluded.min_duration_ms,
-		max_duration_ms = excluded.max_duration_ms
-	`
-
-	_, err := s.db.Exec(query,
-		toolStats.SessionID, toolStats.ToolName,
-		toolStats.ExecutionCount, toolStats.SuccessCount, toolStats.FailureCount,
-		toolStats.TotalDurationMS, toolStats.AvgDurationMS,
-		toolStats.MinDurationMS, toolStats.MaxDurationMS,
-	)
-
+// MarkArchiveProcessed records that the rotated generation of fileName with
+// the given inode has been backfilled
+func (s *Store) MarkArchiveProcessed(fileName string, inode uint64) error {
+	_, err := s.exec(queryMarkArchiveProcessed, `
+	INSERT INTO processed_archives (file_name, inode, processed_at) VALUES (?, ?, ?)
+	ON CONFLICT(file_name, inode) DO NOTHING
+	`, fileName, inode, time.Now().Unix())
 	return err
 }
 
-// GetSessionStats retrieves statistics for a specific session
-func (s *Store) GetSessionStats(sessionID string) (*SessionStats, error) {
-	query := `
-	SELECT session_id, user_id, organization_id, service_name,
-		start_time, last_update_time,
-		terminal_type, host_arch, os_type,
-		total_cost_usd, total_input_tokens, total_output_tokens,
-		total_cache_read_tokens, total_cache_creation_tokens, total_active_time_seconds,
-		api_request_count, user_prompt_count, tool_execution_count,
-		tool_success_count, tool_failure_count,
-		avg_api_latency_ms, total_api_latency_ms,
-		models_used, tools_used,
-		created_at, updated_at
-	FROM session_stats WHERE session_id = ?
-	`
-
-	var stats SessionStats
-	var startTime, lastUpdateTime, createdAt, updatedAt int64
-	var serviceName, terminalType, hostArch, osType sql.NullString
-	var modelsUsed, toolsUsed sql.NullString
-
-	err := s.db.QueryRow(query, sessionID).Scan(
-		&stats.SessionID, &stats.UserID, &stats.OrganizationID, &serviceName,
-		&startTime, &lastUpdateTime,
-		&terminalType, &hostArch, &osType,
-		&stats.TotalCostUSD, &stats.TotalInputTokens, &stats.TotalOutputTokens,
-		&stats.TotalCacheReadTokens, &stats.TotalCacheCreationTokens, &stats.TotalActiveTimeSeconds,
-		&stats.APIRequestCount, &stats.UserPromptCount, &stats.ToolExecutionCount,
-		&stats.ToolSuccessCount, &stats.ToolFailureCount,
-		&stats.AvgAPILatencyMS, &stats.TotalAPILatencyMS,
-		&modelsUsed, &toolsUsed,
-		&createdAt, &updatedAt,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	stats.ServiceName = serviceName.String
-	stats.TerminalType = terminalType.String
-	stats.HostArch = hostArch.String
-	stats.OSType = osType.String
-	stats.ModelsUsed = modelsUsed.String
-	stats.ToolsUsed = toolsUsed.String
-	stats.StartTime = time.Unix(startTime, 0)
-	stats.LastUpdateTime = time.Unix(lastUpdateTime, 0)
-	stats.CreatedAt = time.Unix(createdAt, 0)
-	stats.UpdatedAt = time.Unix(updatedAt, 0)
-
-	return &stats, nil
-}
-
-// UpdateProcessingState updates the processing state for a file
-func (s *Store) UpdateProcessingState(fileName string, byteOffset int64, fileSize int64, inode uint64) error {
-	query := `
-	INSERT INTO processing_state (file_name, last_byte_offset, last_processed_time, file_size_bytes, inode, updated_at)
-	VALUES (?, ?, ?, ?, ?, ?)
-	ON CONFLICT(file_name) DO UPDATE SET
-		last_byte_offset = excluded.last_byte_offset,
-		last_processed_time = excluded.last_processed_time,
-		file_size_bytes = excluded.file_size_bytes,
-		inode = excluded.inode,
-		updated_at = excluded.updated_at
-	`
-
-	now := time.Now().Unix()
-	_, err := s.db.Exec(query, fileName, byteOffset, now, fileSize, inode, now)
+// ForgetArchive removes the record of a rotated generation of fileName once
+// it has been deleted, so a later file reusing its inode isn't mistaken for it
+func (s *Store) ForgetArchive(fileName string, inode uint64) error {
+	_, err := s.exec(queryForgetArchive, `DELETE FROM processed_archives WHERE file_name = ? AND inode = ?`, fileName, inode)
 	return err
 }
 
 // GetProcessingState retrieves the processing state for a file
 func (s *Store) GetProcessingState(fileName string) (*ProcessingState, error) {
 	query := `
-	SELECT file_name, last_byte_offset, last_processed_time, file_size_bytes, COALESCE(inode, 0), updated_at
+	SELECT file_name, last_processed_line, last_byte_offset, last_processed_time, file_size_bytes, COALESCE(inode, 0), updated_at
 	FROM processing_state WHERE file_name = ?
 	`
 
 	var state ProcessingState
 	var lastProcessedTime, updatedAt int64
 
-	err := s.db.QueryRow(query, fileName).Scan(
-		&state.FileName, &state.LastByteOffset, &lastProcessedTime,
+	err := s.queryRow(queryGetProcessingState, query, fileName).Scan(
+		&state.FileName, &state.LastProcessedLine, &state.LastByteOffset, &lastProcessedTime,
 		&state.FileSizeBytes, &state.Inode, &updatedAt,
 	)
 
 	if err == sql.ErrNoRows {
 		// Return empty state if not found
 		return &ProcessingState{
-			FileName:       fileName,
-			LastByteOffset: 0,
-			Inode:          0,
+			FileName:          fileName,
+			LastProcessedLine: 0,
+			LastByteOffset:    0,
+			Inode:             0,
 		}, nil
 	}
 
@@ -323,205 +207,6 @@ func (s *Store) GetProcessingState(fileName string) (*ProcessingState, error) {
 	return &state, nil
 }
 
-// GetUserSessionStats retrieves all sessions for a user
-func (s *Store) GetUserSessionStats(userID string, limit int) ([]*SessionStats, error) {
-	query := `
-	SELECT session_id, user_id, organization_id, service_name,
-		start_time, last_update_time,
-		terminal_type, host_arch, os_type,
-		total_cost_usd, total_input_tokens, total_output_tokens,
-		total_cache_read_tokens, total_cache_creation_tokens, total_active_time_seconds,
-		api_request_count, user_prompt_count, tool_execution_count,
-		tool_success_count, tool_failure_count,
-		avg_api_latency_ms, total_api_latency_ms,
-		models_used, tools_used,
-		created_at, updated_at
-	FROM session_stats WHERE user_id = ?
-	ORDER BY start_time DESC
-	LIMIT ?
-	`
-
-	rows, err := s.db.Query(query, userID, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var sessions []*SessionStats
-	for rows.Next() {
-		var stats SessionStats
-		var startTime, lastUpdateTime, createdAt, updatedAt int64
-		var serviceName, terminalType, hostArch, osType sql.NullString
-		var modelsUsed, toolsUsed sql.NullString
-
-		err := rows.Scan(
-			&stats.SessionID, &stats.UserID, &stats.OrganizationID, &serviceName,
-			&startTime, &lastUpdateTime,
-			&terminalType, &hostArch, &osType,
-			&stats.TotalCostUSD, &stats.TotalInputTokens, &stats.TotalOutputTokens,
-			&stats.TotalCacheReadTokens, &stats.TotalCacheCreationTokens, &stats.TotalActiveTimeSeconds,
-			&stats.APIRequestCount, &stats.UserPromptCount, &stats.ToolExecutionCount,
-			&stats.ToolSuccessCount, &stats.ToolFailureCount,
-			&stats.AvgAPILatencyMS, &stats.TotalAPILatencyMS,
-			&modelsUsed, &toolsUsed,
-			&createdAt, &updatedAt,
-		)
-
-		if err != nil {
-			return nil, err
-		}
-
-		stats.ServiceName = serviceName.String
-		stats.TerminalType = terminalType.String
-		stats.HostArch = hostArch.String
-		stats.OSType = osType.String
-		stats.ModelsUsed = modelsUsed.String
-		stats.ToolsUsed = toolsUsed.String
-		stats.StartTime = time.Unix(startTime, 0)
-		stats.LastUpdateTime = time.Unix(lastUpdateTime, 0)
-		stats.CreatedAt = time.Unix(createdAt, 0)
-		stats.UpdatedAt = time.Unix(updatedAt, 0)
-
-		sessions = append(sessions, &stats)
-	}
-
-	return sessions, rows.Err()
-}
-
-// GetOrgSessionStats retrieves all sessions for an organization
-func (s *Store) GetOrgSessionStats(orgID string, limit int) ([]*SessionStats, error) {
-	query := `
-	SELECT session_id, user_id, organization_id, service_name,
-		start_time, last_update_time,
-		terminal_type, host_arch, os_type,
-		total_cost_usd, total_input_tokens, total_output_tokens,
-		total_cache_read_tokens, total_cache_creation_tokens, total_active_time_seconds,
-		api_request_count, user_prompt_count, tool_execution_count,
-		tool_success_count, tool_failure_count,
-		avg_api_latency_ms, total_api_latency_ms,
-		models_used, tools_used,
-		created_at, updated_at
-	FROM session_stats WHERE organization_id = ?
-	ORDER BY start_time DESC
-	LIMIT ?
-	`
-
-	rows, err := s.db.Query(query, orgID, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var sessions []*SessionStats
-	for rows.Next() {
-		var stats SessionStats
-		var startTime, lastUpdateTime, createdAt, updatedAt int64
-		var serviceName, terminalType, hostArch, osType sql.NullString
-		var modelsUsed, toolsUsed sql.NullString
-
-		err := rows.Scan(
-			&stats.SessionID, &stats.UserID, &stats.OrganizationID, &serviceName,
-			&startTime, &lastUpdateTime,
-			&terminalType, &hostArch, &osType,
-			&stats.TotalCostUSD, &stats.TotalInputTokens, &stats.TotalOutputTokens,
-			&stats.TotalCacheReadTokens, &stats.TotalCacheCreationTokens, &stats.TotalActiveTimeSeconds,
-			&stats.APIRequestCount, &stats.UserPromptCount, &stats.ToolExecutionCount,
-			&stats.ToolSuccessCount, &stats.ToolFailureCount,
-			&stats.AvgAPILatencyMS, &stats.TotalAPILatencyMS,
-			&modelsUsed, &toolsUsed,
-			&createdAt, &updatedAt,
-		)
-
-		if err != nil {
-			return nil, err
-		}
-
-		stats.ServiceName = serviceName.String
-		stats.TerminalType = terminalType.String
-		stats.HostArch = hostArch.String
-		stats.OSType = osType.String
-		stats.ModelsUsed = modelsUsed.String
-		stats.ToolsUsed = toolsUsed.String
-		stats.StartTime = time.Unix(startTime, 0)
-		stats.LastUpdateTime = time.Unix(lastUpdateTime, 0)
-		stats.CreatedAt = time.Unix(createdAt, 0)
-		stats.UpdatedAt = time.Unix(updatedAt, 0)
-
-		sessions = append(sessions, &stats)
-	}
-
-	return sessions, rows.Err()
-}
-
-// GetSessionModelStats retrieves per-model statistics for a specific session
-func (s *Store) GetSessionModelStats(sessionID string) ([]*SessionModelStats, error) {
-	query := `
-	SELECT session_id, model, cost_usd, input_tokens, output_tokens,
-		cache_read_tokens, cache_creation_tokens, request_count,
-		total_latency_ms, avg_latency_ms
-	FROM session_model_stats
-	WHERE session_id = ?
-	ORDER BY cost_usd DESC
-	`
-
-	rows, err := s.db.Query(query, sessionID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var modelStats []*SessionModelStats
-	for rows.Next() {
-		var stats SessionModelStats
-		err := rows.Scan(
-			&stats.SessionID, &stats.Model, &stats.CostUSD,
-			&stats.InputTokens, &stats.OutputTokens,
-			&stats.CacheReadTokens, &stats.CacheCreationTokens,
-			&stats.RequestCount, &stats.TotalLatencyMS, &stats.AvgLatencyMS,
-		)
-		if err != nil {
-			return nil, err
-		}
-		modelStats = append(modelStats, &stats)
-	}
-
-	return modelStats, rows.Err()
-}
-
-// GetSessionToolStats retrieves per-tool statistics for a specific session
-func (s *Store) GetSessionToolStats(sessionID string) ([]*SessionToolStats, error) {
-	query := `
-	SELECT session_id, tool_name, execution_count, success_count, failure_count,
-		total_duration_ms, avg_duration_ms, min_duration_ms, max_duration_ms
-	FROM session_tool_stats
-	WHERE session_id = ?
-	ORDER BY execution_count DESC
-	`
-
-	rows, err := s.db.Query(query, sessionID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var toolStats []*SessionToolStats
-	for rows.Next() {
-		var stats SessionToolStats
-		err := rows.Scan(
-			&stats.SessionID, &stats.ToolName,
-			&stats.ExecutionCount, &stats.SuccessCount, &stats.FailureCount,
-			&stats.TotalDurationMS, &stats.AvgDurationMS,
-			&stats.MinDurationMS, &stats.MaxDurationMS,
-		)
-		if err != nil {
-			return nil, err
-		}
-		toolStats = append(toolStats, &stats)
-	}
-
-	return toolStats, rows.Err()
-}
-
 // ModelAggregates represents aggregated statistics for a model across all sessions
 type ModelAggregates struct {
 	Model                    string
@@ -536,9 +221,19 @@ type ModelAggregates struct {
 	AvgLatencyMS             float64
 }
 
-// GetAllModelStats retrieves aggregated statistics across all models
-func (s *Store) GetAllModelStats(limit int) ([]*ModelAggregates, error) {
-	query := `
+// GetModelAggregates retrieves aggregated statistics across all models, over
+// the sessions started in the window; a window without bounds covers every
+// session
+func (s *Store) GetModelAggregates(window TimeWindow, limit int) ([]*ModelAggregates, error) {
+	filter := ""
+	var args []interface{}
+	if !window.Start.IsZero() || !window.End.IsZero() {
+		start, end := windowBounds(window)
+		filter = "WHERE session_id IN (SELECT session_id FROM sessions WHERE start_time >= ? AND start_time < ?)"
+		args = append(args, start, end)
+	}
+
+	query := fmt.Sprintf(`
 	SELECT
 		model,
 		COUNT(DISTINCT session_id) as total_sessions,
@@ -549,14 +244,17 @@ func (s *Store) GetAllModelStats(limit int) ([]*ModelAggregates, error) {
 		SUM(cache_read_tokens) as total_cache_read_tokens,
 		SUM(cache_creation_tokens) as total_cache_creation_tokens,
 		AVG(cost_usd) as avg_cost_per_session,
-		AVG(avg_latency_ms) as avg_latency_ms
-	FROM session_model_stats
+		CASE WHEN SUM(request_count) > 0
+			THEN SUM(total_latency_ms) / SUM(request_count)
+			ELSE 0 END as avg_latency_ms
+	FROM session_models
+	%s
 	GROUP BY model
 	ORDER BY total_cost DESC
 	LIMIT ?
-	`
+	`, filter)
 
-	rows, err := s.db.Query(query, limit)
+	rows, err := s.query(queryGetModelAggregates, query, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -591,47 +289,6 @@ type ToolAggregates struct {
 	SessionsUsedIn  int
 }
 
-// GetAllToolStats retrieves aggregated statistics across all tools
-func (s *Store) GetAllToolStats(limit int) ([]*ToolAggregates, error) {
-	query := `
-	SELECT
-		tool_name,
-		SUM(execution_count) as total_executions,
-		SUM(success_count) as total_successes,
-		SUM(failure_count) as total_failures,
-		CAST(SUM(success_count) AS REAL) / CAST(SUM(execution_count) AS REAL) as success_rate,
-		AVG(avg_duration_ms) as avg_duration_ms,
-		COUNT(DISTINCT session_id) as sessions_used_in
-	FROM session_tool_stats
-	GROUP BY tool_name
-	ORDER BY total_executions DESC
-	LIMIT ?
-	`
-
-	rows, err := s.db.Query(query, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var aggregates []*ToolAggregates
-	for rows.Next() {
-		var agg ToolAggregates
-		err := rows.Scan(
-			&agg.ToolName, &agg.TotalExecutions,
-			&agg.TotalSuccesses, &agg.TotalFailures,
-			&agg.SuccessRate, &agg.AvgDurationMS,
-			&agg.SessionsUsedIn,
-		)
-		if err != nil {
-			return nil, err
-		}
-		aggregates = append(aggregates, &agg)
-	}
-
-	return aggregates, rows.Err()
-}
-
 // UpsertSession inserts or updates a session in the new sessions table
 func (s *Store) UpsertSession(session *Session) error {
 	query := `
@@ -640,10 +297,13 @@ func (s *Store) UpsertSession(session *Session) error {
 		client_name, client_version, terminal_type, host_arch, os_type, os_version,
 		total_cost_usd, total_input_tokens, total_output_tokens,
 		total_cache_read_tokens, total_cache_creation_tokens, tool_call_count,
-		api_request_count, api_error_count, user_prompt_count, total_api_latency_ms,
-		created_at, updated_at
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		api_request_count, api_error_count, api_error_rate, user_prompt_count, total_api_latency_ms,
+		turn_count, avg_tokens_per_turn, first_model, primary_model,
+		business_seconds, after_hours_seconds, total_active_time_seconds, active_time_estimated,
+		tool_success_count, tool_failure_count, env_changed, created_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(session_id) DO UPDATE SET
+		start_time = MIN(start_time, excluded.start_time),
 		end_time = excluded.end_time,
 		client_name = COALESCE(excluded.client_name, client_name),
 		client_version = COALESCE(excluded.client_version, client_version),
@@ -651,6 +311,13 @@ func (s *Store) UpsertSession(session *Session) error {
 		host_arch = COALESCE(excluded.host_arch, host_arch),
 		os_type = COALESCE(excluded.os_type, os_type),
 		os_version = COALESCE(excluded.os_version, os_version),
+		env_changed = env_changed OR excluded.env_changed
+			OR COALESCE(excluded.client_name != client_name, 0)
+			OR COALESCE(excluded.client_version != client_version, 0)
+			OR COALESCE(excluded.terminal_type != terminal_type, 0)
+			OR COALESCE(excluded.host_arch != host_arch, 0)
+			OR COALESCE(excluded.os_type != os_type, 0)
+			OR COALESCE(excluded.os_version != os_version, 0),
 		total_cost_usd = excluded.total_cost_usd,
 		total_input_tokens = excluded.total_input_tokens,
 		total_output_tokens = excluded.total_output_tokens,
@@ -659,8 +326,19 @@ func (s *Store) UpsertSession(session *Session) error {
 		tool_call_count = excluded.tool_call_count,
 		api_request_count = excluded.api_request_count,
 		api_error_count = excluded.api_error_count,
+		api_error_rate = excluded.api_error_rate,
 		user_prompt_count = excluded.user_prompt_count,
 		total_api_latency_ms = excluded.total_api_latency_ms,
+		turn_count = excluded.turn_count,
+		avg_tokens_per_turn = excluded.avg_tokens_per_turn,
+		first_model = COALESCE(first_model, excluded.first_model),
+		primary_model = COALESCE(excluded.primary_model, primary_model),
+		business_seconds = excluded.business_seconds,
+		after_hours_seconds = excluded.after_hours_seconds,
+		total_active_time_seconds = excluded.total_active_time_seconds,
+		active_time_estimated = excluded.active_time_estimated,
+		tool_success_count = excluded.tool_success_count,
+		tool_failure_count = excluded.tool_failure_count,
 		updated_at = excluded.updated_at
 	`
 
@@ -670,7 +348,7 @@ func (s *Store) UpsertSession(session *Session) error {
 		endTime = &t
 	}
 
-	_, err := s.db.Exec(query,
+	_, err := s.exec(queryUpsertSession, query,
 		session.SessionID, session.OrganizationID, session.UserID,
 		session.StartTime.Unix(), endTime,
 		nilIfEmpty(session.ClientName), nilIfEmpty(session.ClientVersion),
@@ -678,7 +356,11 @@ func (s *Store) UpsertSession(session *Session) error {
 		nilIfEmpty(session.OSType), nilIfEmpty(session.OSVersion),
 		session.TotalCostUSD, session.TotalInputTokens, session.TotalOutputTokens,
 		session.TotalCacheReadTokens, session.TotalCacheCreationTokens, session.ToolCallCount,
-		session.APIRequestCount, session.APIErrorCount, session.UserPromptCount, session.TotalAPILatencyMS,
+		session.APIRequestCount, session.APIErrorCount, session.APIErrorRate, session.UserPromptCount, session.TotalAPILatencyMS,
+		session.TurnCount, session.AvgTokensPerTurn,
+		nilIfEmpty(session.FirstModel), nilIfEmpty(session.PrimaryModel),
+		session.BusinessSeconds, session.AfterHoursSeconds, session.TotalActiveTimeSeconds, session.ActiveTimeEstimated,
+		session.ToolSuccessCount, session.ToolFailureCount, session.EnvChanged,
 		session.CreatedAt.Unix(), session.UpdatedAt.Unix(),
 	)
 
@@ -699,8 +381,8 @@ func (s *Store) UpsertSessionModel(model *SessionModel) error {
 	INSERT INTO session_models (
 		session_id, model, request_count, cost_usd,
 		input_tokens, output_tokens, cache_read_tokens, cache_creation_tokens,
-		total_latency_ms
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		total_latency_ms, p50_latency_ms, p95_latency_ms, p99_latency_ms
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(session_id, model) DO UPDATE SET
 		request_count = excluded.request_count,
 		cost_usd = excluded.cost_usd,
@@ -708,13 +390,16 @@ func (s *Store) UpsertSessionModel(model *SessionModel) error {
 		output_tokens = excluded.output_tokens,
 		cache_read_tokens = excluded.cache_read_tokens,
 		cache_creation_tokens = excluded.cache_creation_tokens,
-		total_latency_ms = excluded.total_latency_ms
+		total_latency_ms = excluded.total_latency_ms,
+		p50_latency_ms = excluded.p50_latency_ms,
+		p95_latency_ms = excluded.p95_latency_ms,
+		p99_latency_ms = excluded.p99_latency_ms
 	`
 
-	_, err := s.db.Exec(query,
+	_, err := s.exec(queryUpsertSessionModel, query,
 		model.SessionID, model.Model, model.RequestCount, model.CostUSD,
 		model.InputTokens, model.OutputTokens, model.CacheReadTokens, model.CacheCreationTokens,
-		model.TotalLatencyMS,
+		model.TotalLatencyMS, model.P50LatencyMS, model.P95LatencyMS, model.P99LatencyMS,
 	)
 
 	return err
@@ -726,8 +411,10 @@ func (s *Store) UpsertSessionTool(tool *SessionTool) error {
 	INSERT INTO session_tools (
 		session_id, tool_name, call_count, success_count, failure_count,
 		total_execution_time_ms, auto_approved_count, user_approved_count,
-		rejected_count, total_result_size_bytes
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		rejected_count, total_result_size_bytes,
+		min_execution_time_ms, max_execution_time_ms,
+		p50_execution_time_ms, p95_execution_time_ms, p99_execution_time_ms
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(session_id, tool_name) DO UPDATE SET
 		call_count = excluded.call_count,
 		success_count = excluded.success_count,
@@ -736,14 +423,21 @@ func (s *Store) UpsertSessionTool(tool *SessionTool) error {
 		auto_approved_count = excluded.auto_approved_count,
 		user_approved_count = excluded.user_approved_count,
 		rejected_count = excluded.rejected_count,
-		total_result_size_bytes = excluded.total_result_size_bytes
+		total_result_size_bytes = excluded.total_result_size_bytes,
+		min_execution_time_ms = excluded.min_execution_time_ms,
+		max_execution_time_ms = excluded.max_execution_time_ms,
+		p50_execution_time_ms = excluded.p50_execution_time_ms,
+		p95_execution_time_ms = excluded.p95_execution_time_ms,
+		p99_execution_time_ms = excluded.p99_execution_time_ms
 	`
 
-	_, err := s.db.Exec(query,
+	_, err := s.exec(queryUpsertSessionTool, query,
 		tool.SessionID, tool.ToolName, tool.CallCount,
 		tool.SuccessCount, tool.FailureCount, tool.TotalExecutionTimeMS,
 		tool.AutoApprovedCount, tool.UserApprovedCount,
 		tool.RejectedCount, tool.TotalResultSizeBytes,
+		tool.MinExecutionTimeMS, tool.MaxExecutionTimeMS,
+		tool.P50ExecutionTimeMS, tool.P95ExecutionTimeMS, tool.P99ExecutionTimeMS,
 	)
 
 	return err
@@ -755,7 +449,13 @@ func (s *Store) GetSession(sessionID string) (*Session, error) {
 	SELECT session_id, organization_id, user_id, start_time, end_time,
 		total_cost_usd, total_input_tokens, total_output_tokens,
 		total_cache_read_tokens, total_cache_creation_tokens, tool_call_count,
-		created_at, updated_at
+		COALESCE(turn_count, 0), COALESCE(avg_tokens_per_turn, 0),
+		COALESCE(first_model, ''), COALESCE(primary_model, ''),
+		COALESCE(client_name, ''), COALESCE(client_version, ''), COALESCE(terminal_type, ''),
+		COALESCE(host_arch, ''), COALESCE(os_type, ''), COALESCE(os_version, ''), env_changed,
+		tool_success_count, tool_failure_count,
+		api_request_count, api_error_count, api_error_rate, user_prompt_count, total_api_latency_ms,
+		total_active_time_seconds, active_time_estimated, created_at, updated_at
 	FROM sessions WHERE session_id = ?
 	`
 
@@ -763,12 +463,18 @@ func (s *Store) GetSession(sessionID string) (*Session, error) {
 	var startTime, createdAt, updatedAt int64
 	var endTime sql.NullInt64
 
-	err := s.db.QueryRow(query, sessionID).Scan(
+	err := s.queryRow(queryGetSession, query, sessionID).Scan(
 		&session.SessionID, &session.OrganizationID, &session.UserID,
 		&startTime, &endTime,
 		&session.TotalCostUSD, &session.TotalInputTokens, &session.TotalOutputTokens,
 		&session.TotalCacheReadTokens, &session.TotalCacheCreationTokens, &session.ToolCallCount,
-		&createdAt, &updatedAt,
+		&session.TurnCount, &session.AvgTokensPerTurn,
+		&session.FirstModel, &session.PrimaryModel,
+		&session.ClientName, &session.ClientVersion, &session.TerminalType,
+		&session.HostArch, &session.OSType, &session.OSVersion, &session.EnvChanged,
+		&session.ToolSuccessCount, &session.ToolFailureCount,
+		&session.APIRequestCount, &session.APIErrorCount, &session.APIErrorRate, &session.UserPromptCount, &session.TotalAPILatencyMS,
+		&session.TotalActiveTimeSeconds, &session.ActiveTimeEstimated, &createdAt, &updatedAt,
 	)
 
 	if err != nil {
@@ -790,13 +496,15 @@ func (s *Store) GetSessionTools(sessionID string) ([]*SessionTool, error) {
 	query := `
 	SELECT session_id, tool_name, call_count, success_count, failure_count,
 		total_execution_time_ms, auto_approved_count, user_approved_count,
-		rejected_count, total_result_size_bytes
+		rejected_count, total_result_size_bytes,
+		min_execution_time_ms, max_execution_time_ms,
+		p50_execution_time_ms, p95_execution_time_ms, p99_execution_time_ms
 	FROM session_tools
 	WHERE session_id = ?
 	ORDER BY call_count DESC
 	`
 
-	rows, err := s.db.Query(query, sessionID)
+	rows, err := s.query(queryGetSessionTools, query, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -810,6 +518,8 @@ func (s *Store) GetSessionTools(sessionID string) ([]*SessionTool, error) {
 			&tool.SuccessCount, &tool.FailureCount, &tool.TotalExecutionTimeMS,
 			&tool.AutoApprovedCount, &tool.UserApprovedCount,
 			&tool.RejectedCount, &tool.TotalResultSizeBytes,
+			&tool.MinExecutionTimeMS, &tool.MaxExecutionTimeMS,
+			&tool.P50ExecutionTimeMS, &tool.P95ExecutionTimeMS, &tool.P99ExecutionTimeMS,
 		)
 		if err != nil {
 			return nil, err
@@ -820,35 +530,137 @@ func (s *Store) GetSessionTools(sessionID string) ([]*SessionTool, error) {
 	return tools, rows.Err()
 }
 
-// GetAllSessions retrieves all sessions ordered by start time
-func (s *Store) GetAllSessions(limit int) ([]*Session, error) {
+// GetSessionModels retrieves per-model statistics for a session, most
+// expensive first
+func (s *Store) GetSessionModels(sessionID string) ([]*SessionModel, error) {
 	query := `
-	SELECT session_id, organization_id, user_id, start_time, end_time,
-		total_cost_usd, total_input_tokens, total_output_tokens,
-		total_cache_read_tokens, total_cache_creation_tokens, tool_call_count,
-		created_at, updated_at
-	FROM sessions
-	ORDER BY start_time DESC
-	LIMIT ?
+	SELECT session_id, model, request_count, cost_usd,
+		input_tokens, output_tokens, cache_read_tokens, cache_creation_tokens,
+		total_latency_ms, p50_latency_ms, p95_latency_ms, p99_latency_ms
+	FROM session_models
+	WHERE session_id = ?
+	ORDER BY cost_usd DESC
 	`
 
-	rows, err := s.db.Query(query, limit)
+	rows, err := s.query(queryGetSessionModels, query, sessionID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var sessions []*Session
+	var models []*SessionModel
 	for rows.Next() {
-		var session Session
-		var startTime, createdAt, updatedAt int64
-		var endTime sql.NullInt64
+		var model SessionModel
+		err := rows.Scan(
+			&model.SessionID, &model.Model, &model.RequestCount, &model.CostUSD,
+			&model.InputTokens, &model.OutputTokens, &model.CacheReadTokens, &model.CacheCreationTokens,
+			&model.TotalLatencyMS, &model.P50LatencyMS, &model.P95LatencyMS, &model.P99LatencyMS,
+		)
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, &model)
+	}
+
+	return models, rows.Err()
+}
+
+// GetModelSessionCounts counts the sessions among sessionIDs that used each model
+func (s *Store) GetModelSessionCounts(sessionIDs []string) (map[string]int, error) {
+	counts := make(map[string]int)
+	if len(sessionIDs) == 0 {
+		return counts, nil
+	}
+
+	query := `SELECT model, COUNT(DISTINCT session_id) FROM session_models
+	WHERE session_id IN (?` + strings.Repeat(", ?", len(sessionIDs)-1) + `)
+	GROUP BY model`
+	rows, err := s.query(queryGetModelSessionCounts, query, stringArgs(sessionIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var model string
+		var count int
+		if err := rows.Scan(&model, &count); err != nil {
+			return nil, err
+		}
+		counts[model] = count
+	}
+	return counts, rows.Err()
+}
+
+// GetToolCallCounts sums the calls to each tool across sessionIDs
+func (s *Store) GetToolCallCounts(sessionIDs []string) (map[string]int, error) {
+	counts := make(map[string]int)
+	if len(sessionIDs) == 0 {
+		return counts, nil
+	}
+
+	query := `SELECT tool_name, SUM(call_count) FROM session_tools
+	WHERE session_id IN (?` + strings.Repeat(", ?", len(sessionIDs)-1) + `)
+	GROUP BY tool_name`
+	rows, err := s.query(queryGetToolCallCounts, query, stringArgs(sessionIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var toolName string
+		var count int
+		if err := rows.Scan(&toolName, &count); err != nil {
+			return nil, err
+		}
+		counts[toolName] = count
+	}
+	return counts, rows.Err()
+}
+
+// stringArgs converts values to query arguments
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+// GetAllSessions retrieves all sessions ordered by start time
+func (s *Store) GetAllSessions(limit int) ([]*Session, error) {
+	query := `
+	SELECT session_id, organization_id, user_id, start_time, end_time,
+		total_cost_usd, total_input_tokens, total_output_tokens,
+		total_cache_read_tokens, total_cache_creation_tokens, tool_call_count,
+		api_request_count, user_prompt_count, total_active_time_seconds,
+		COALESCE(first_model, ''), COALESCE(primary_model, ''),
+		created_at, updated_at
+	FROM sessions
+	ORDER BY start_time DESC
+	LIMIT ?
+	`
+
+	rows, err := s.query(queryGetAllSessions, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var session Session
+		var startTime, createdAt, updatedAt int64
+		var endTime sql.NullInt64
 
 		err := rows.Scan(
 			&session.SessionID, &session.OrganizationID, &session.UserID,
 			&startTime, &endTime,
 			&session.TotalCostUSD, &session.TotalInputTokens, &session.TotalOutputTokens,
 			&session.TotalCacheReadTokens, &session.TotalCacheCreationTokens, &session.ToolCallCount,
+			&session.APIRequestCount, &session.UserPromptCount, &session.TotalActiveTimeSeconds,
+			&session.FirstModel, &session.PrimaryModel,
 			&createdAt, &updatedAt,
 		)
 		if err != nil {
@@ -868,19 +680,24 @@ func (s *Store) GetAllSessions(limit int) ([]*Session, error) {
 	return sessions, rows.Err()
 }
 
-// GetSessionsByOrg retrieves sessions for an organization
-func (s *Store) GetSessionsByOrg(orgID string, limit int) ([]*Session, error) {
-	query := `
+// GetSessionsByOrg retrieves an organization's sessions, newest first,
+// starting after the before cursor when it isn't nil
+func (s *Store) GetSessionsByOrg(orgID string, before *SessionCursor, limit int) ([]*Session, error) {
+	condition, args := sessionCursorCondition(before)
+	query := fmt.Sprintf(`
 	SELECT session_id, organization_id, user_id, start_time, end_time,
 		total_cost_usd, total_input_tokens, total_output_tokens,
 		total_cache_read_tokens, total_cache_creation_tokens, tool_call_count,
+		api_request_count, user_prompt_count, total_active_time_seconds,
+		COALESCE(first_model, ''), COALESCE(primary_model, ''),
 		created_at, updated_at
-	FROM sessions WHERE organization_id = ?
-	ORDER BY start_time DESC
+	FROM sessions WHERE organization_id = ? AND %s
+	ORDER BY start_time DESC, session_id DESC
 	LIMIT ?
-	`
+	`, condition)
 
-	rows, err := s.db.Query(query, orgID, limit)
+	args = append(append([]interface{}{orgID}, args...), limit)
+	rows, err := s.query(queryGetSessionsByOrg, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -897,6 +714,8 @@ func (s *Store) GetSessionsByOrg(orgID string, limit int) ([]*Session, error) {
 			&startTime, &endTime,
 			&session.TotalCostUSD, &session.TotalInputTokens, &session.TotalOutputTokens,
 			&session.TotalCacheReadTokens, &session.TotalCacheCreationTokens, &session.ToolCallCount,
+			&session.APIRequestCount, &session.UserPromptCount, &session.TotalActiveTimeSeconds,
+			&session.FirstModel, &session.PrimaryModel,
 			&createdAt, &updatedAt,
 		)
 		if err != nil {
@@ -916,19 +735,24 @@ func (s *Store) GetSessionsByOrg(orgID string, limit int) ([]*Session, error) {
 	return sessions, rows.Err()
 }
 
-// GetSessionsByUser retrieves sessions for a user
-func (s *Store) GetSessionsByUser(userID string, limit int) ([]*Session, error) {
-	query := `
+// GetSessionsByUser retrieves a user's sessions, newest first, starting
+// after the before cursor when it isn't nil
+func (s *Store) GetSessionsByUser(userID string, before *SessionCursor, limit int) ([]*Session, error) {
+	condition, args := sessionCursorCondition(before)
+	query := fmt.Sprintf(`
 	SELECT session_id, organization_id, user_id, start_time, end_time,
 		total_cost_usd, total_input_tokens, total_output_tokens,
 		total_cache_read_tokens, total_cache_creation_tokens, tool_call_count,
+		api_request_count, user_prompt_count, total_active_time_seconds,
+		COALESCE(first_model, ''), COALESCE(primary_model, ''),
 		created_at, updated_at
-	FROM sessions WHERE user_id = ?
-	ORDER BY start_time DESC
+	FROM sessions WHERE user_id = ? AND %s
+	ORDER BY start_time DESC, session_id DESC
 	LIMIT ?
-	`
+	`, condition)
 
-	rows, err := s.db.Query(query, userID, limit)
+	args = append(append([]interface{}{userID}, args...), limit)
+	rows, err := s.query(queryGetSessionsByUser, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -945,6 +769,8 @@ func (s *Store) GetSessionsByUser(userID string, limit int) ([]*Session, error)
 			&startTime, &endTime,
 			&session.TotalCostUSD, &session.TotalInputTokens, &session.TotalOutputTokens,
 			&session.TotalCacheReadTokens, &session.TotalCacheCreationTokens, &session.ToolCallCount,
+			&session.APIRequestCount, &session.UserPromptCount, &session.TotalActiveTimeSeconds,
+			&session.FirstModel, &session.PrimaryModel,
 			&createdAt, &updatedAt,
 		)
 		if err != nil {
@@ -971,47 +797,152 @@ func (s *Store) InsertSessionPrompt(prompt *SessionPrompt) error {
 	VALUES (?, ?, ?, ?)
 	`
 
-	_, err := s.db.Exec(query,
+	_, err := s.exec(queryInsertSessionPrompt, query,
 		prompt.SessionID, prompt.PromptText, prompt.PromptLength, prompt.Timestamp.UnixNano(),
 	)
 
 	return err
 }
 
+// InsertSessionPrompts inserts prompts in one transaction, skipping
+// duplicates; if any insert fails, none are kept
+func (s *Store) InsertSessionPrompts(prompts []*SessionPrompt) error {
+	query := `
+	INSERT OR IGNORE INTO session_prompts (session_id, prompt_text, prompt_length, timestamp)
+	VALUES (?, ?, ?, ?)
+	`
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, prompt := range prompts {
+		if _, err := s.execOn(tx, queryInsertSessionPrompts, query,
+			prompt.SessionID, prompt.PromptText, prompt.PromptLength, prompt.Timestamp.UnixNano(),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // GetSessionPrompts retrieves all prompts for a session, ordered by timestamp
 func (s *Store) GetSessionPrompts(sessionID string) ([]*SessionPrompt, error) {
+	prompts, _, err := s.GetSessionPromptsPage(sessionID, nil, -1)
+	return prompts, err
+}
+
+// topPromptLength is how many characters of a normalized prompt are compared
+// when grouping, so long prompts that differ only at the end count together
+const topPromptLength = 200
+
+// GetTopPrompts retrieves the most common prompts sent in the window, grouped
+// by their trimmed, lowercased and truncated text. Redacted prompts are never
+// stored, so they do not appear.
+func (s *Store) GetTopPrompts(window TimeWindow, limit int) ([]*PromptFrequency, error) {
 	query := `
-	SELECT id, session_id, prompt_text, prompt_length, timestamp
+	SELECT
+		SUBSTR(LOWER(TRIM(prompt_text)), 1, ?) as normalized,
+		COUNT(*) as occurrences,
+		COUNT(DISTINCT session_id),
+		MAX(timestamp)
 	FROM session_prompts
+	WHERE timestamp >= ? AND timestamp < ? AND TRIM(prompt_text) != ''
+	GROUP BY normalized
+	ORDER BY occurrences DESC, normalized
+	LIMIT ?
+	`
+
+	start, end := int64(math.MinInt64), int64(math.MaxInt64)
+	if !window.Start.IsZero() {
+		start = window.Start.UnixNano()
+	}
+	if !window.End.IsZero() {
+		end = window.End.UnixNano()
+	}
+
+	rows, err := s.query(queryGetTopPrompts, query, topPromptLength, start, end, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prompts []*PromptFrequency
+	for rows.Next() {
+		var pf PromptFrequency
+		var lastSeen int64
+		if err := rows.Scan(&pf.PromptText, &pf.Count, &pf.Sessions, &lastSeen); err != nil {
+			return nil, err
+		}
+		pf.LastSeen = time.Unix(0, lastSeen)
+		prompts = append(prompts, &pf)
+	}
+
+	return prompts, rows.Err()
+}
+
+// UpsertSessionTurn inserts or updates a conversation turn for a session
+func (s *Store) UpsertSessionTurn(turn *SessionTurn) error {
+	query := `
+	INSERT INTO session_turns (
+		session_id, turn_index, start_time, end_time, prompted,
+		request_count, input_tokens, output_tokens, cost_usd
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(session_id, turn_index) DO UPDATE SET
+		end_time = excluded.end_time,
+		request_count = excluded.request_count,
+		input_tokens = excluded.input_tokens,
+		output_tokens = excluded.output_tokens,
+		cost_usd = excluded.cost_usd
+	`
+
+	_, err := s.exec(queryUpsertSessionTurn, query,
+		turn.SessionID, turn.TurnIndex, turn.StartTime.UnixNano(), turn.EndTime.UnixNano(), turn.Prompted,
+		turn.RequestCount, turn.InputTokens, turn.OutputTokens, turn.CostUSD,
+	)
+
+	return err
+}
+
+// GetSessionTurns retrieves all turns for a session, in order
+func (s *Store) GetSessionTurns(sessionID string) ([]*SessionTurn, error) {
+	query := `
+	SELECT session_id, turn_index, start_time, end_time, prompted,
+		request_count, input_tokens, output_tokens, cost_usd
+	FROM session_turns
 	WHERE session_id = ?
-	ORDER BY timestamp ASC
+	ORDER BY turn_index ASC
 	`
 
-	rows, err := s.db.Query(query, sessionID)
+	rows, err := s.query(queryGetSessionTurns, query, sessionID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var prompts []*SessionPrompt
+	var turns []*SessionTurn
 	for rows.Next() {
-		var prompt SessionPrompt
-		var timestamp int64
+		var turn SessionTurn
+		var startTime, endTime int64
 		err := rows.Scan(
-			&prompt.ID, &prompt.SessionID, &prompt.PromptText,
-			&prompt.PromptLength, &timestamp,
+			&turn.SessionID, &turn.TurnIndex, &startTime, &endTime, &turn.Prompted,
+			&turn.RequestCount, &turn.InputTokens, &turn.OutputTokens, &turn.CostUSD,
 		)
 		if err != nil {
 			return nil, err
 		}
-		prompt.Timestamp = time.Unix(0, timestamp)
-		prompts = append(prompts, &prompt)
+		turn.StartTime = time.Unix(0, startTime)
+		turn.EndTime = time.Unix(0, endTime)
+		turns = append(turns, &turn)
 	}
 
-	return prompts, rows.Err()
+	return turns, rows.Err()
 }
 
-// GetToolAggregates retrieves aggregated statistics across all tools from the new table
+// GetToolAggregates retrieves aggregated statistics across all tools from
+// session_tools, most executed first
 func (s *Store) GetToolAggregates(limit int) ([]*ToolAggregates, error) {
 	query := `
 	SELECT
@@ -1028,11 +959,11 @@ func (s *Store) GetToolAggregates(limit int) ([]*ToolAggregates, error) {
 		COUNT(DISTINCT session_id) as sessions_used_in
 	FROM session_tools
 	GROUP BY tool_name
-	ORDER BY total_executions DESC
+	ORDER BY total_executions DESC, tool_name
 	LIMIT ?
 	`
 
-	rows, err := s.db.Query(query, limit)
+	rows, err := s.query(queryGetToolAggregates, query, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -1055,3 +986,408 @@ func (s *Store) GetToolAggregates(limit int) ([]*ToolAggregates, error) {
 
 	return aggregates, rows.Err()
 }
+
+// UpsertAPIKeyIdentity inserts or updates the user mapping for an API key
+func (s *Store) UpsertAPIKeyIdentity(identity *APIKeyIdentity) error {
+	query := `
+	INSERT INTO api_key_identities (api_key, user_id, organization_id)
+	VALUES (?, ?, ?)
+	ON CONFLICT(api_key) DO UPDATE SET
+		user_id = excluded.user_id,
+		organization_id = excluded.organization_id
+	`
+
+	_, err := s.exec(queryUpsertAPIKeyIdentity, query, identity.APIKey, identity.UserID, identity.OrganizationID)
+	return err
+}
+
+// GetAPIKeyIdentities retrieves all API key mappings keyed by API key
+func (s *Store) GetAPIKeyIdentities() (map[string]*APIKeyIdentity, error) {
+	query := `SELECT api_key, user_id, organization_id FROM api_key_identities`
+
+	rows, err := s.query(queryGetAPIKeyIdentities, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make(map[string]*APIKeyIdentity)
+	for rows.Next() {
+		var identity APIKeyIdentity
+		if err := rows.Scan(&identity.APIKey, &identity.UserID, &identity.OrganizationID); err != nil {
+			return nil, err
+		}
+		identities[identity.APIKey] = &identity
+	}
+
+	return identities, rows.Err()
+}
+
+// UpsertDailyUsage inserts or replaces a daily usage rollup row
+func (s *Store) UpsertDailyUsage(usage *DailyUsage) error {
+	query := `
+	INSERT INTO daily_usage (
+		day, user_id, organization_id, model, source,
+		input_tokens, output_tokens, cache_read_tokens, cache_creation_tokens,
+		cost_usd, created_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(day, user_id, model, source) DO UPDATE SET
+		organization_id = excluded.organization_id,
+		input_tokens = excluded.input_tokens,
+		output_tokens = excluded.output_tokens,
+		cache_read_tokens = excluded.cache_read_tokens,
+		cache_creation_tokens = excluded.cache_creation_tokens,
+		cost_usd = excluded.cost_usd,
+		updated_at = excluded.updated_at
+	`
+
+	_, err := s.exec(queryUpsertDailyUsage, query,
+		usage.Day, usage.UserID, usage.OrganizationID, usage.Model, usage.Source,
+		usage.InputTokens, usage.OutputTokens, usage.CacheReadTokens, usage.CacheCreationTokens,
+		usage.CostUSD, usage.CreatedAt.Unix(), usage.UpdatedAt.Unix(),
+	)
+
+	return err
+}
+
+// GetDailyUsageByUser retrieves daily usage rows for a user, ordered by day
+func (s *Store) GetDailyUsageByUser(userID string) ([]*DailyUsage, error) {
+	query := `
+	SELECT day, user_id, organization_id, model, source,
+		input_tokens, output_tokens, cache_read_tokens, cache_creation_tokens,
+		cost_usd, created_at, updated_at
+	FROM daily_usage
+	WHERE user_id = ?
+	ORDER BY day ASC, model ASC
+	`
+
+	rows, err := s.query(queryGetDailyUsageByUser, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usages []*DailyUsage
+	for rows.Next() {
+		var usage DailyUsage
+		var createdAt, updatedAt int64
+		err := rows.Scan(
+			&usage.Day, &usage.UserID, &usage.OrganizationID, &usage.Model, &usage.Source,
+			&usage.InputTokens, &usage.OutputTokens, &usage.CacheReadTokens, &usage.CacheCreationTokens,
+			&usage.CostUSD, &createdAt, &updatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		usage.CreatedAt = time.Unix(createdAt, 0)
+		usage.UpdatedAt = time.Unix(updatedAt, 0)
+		usages = append(usages, &usage)
+	}
+
+	return usages, rows.Err()
+}
+
+// GetImportedUsageTotals sums the daily usage imported for a user
+func (s *Store) GetImportedUsageTotals(userID string) (*ImportedUsageTotals, error) {
+	query := `
+	SELECT COUNT(DISTINCT day), COALESCE(MIN(day), ''), COALESCE(MAX(day), ''),
+		COALESCE(SUM(input_tokens), 0), COALESCE(SUM(output_tokens), 0),
+		COALESCE(SUM(cache_read_tokens), 0), COALESCE(SUM(cache_creation_tokens), 0),
+		COALESCE(SUM(cost_usd), 0)
+	FROM daily_usage
+	WHERE user_id = ?
+	`
+
+	var totals ImportedUsageTotals
+	err := s.queryRow(queryGetImportedUsageTotals, query, userID).Scan(
+		&totals.Days, &totals.FirstDay, &totals.LastDay,
+		&totals.InputTokens, &totals.OutputTokens,
+		&totals.CacheReadTokens, &totals.CacheCreationTokens,
+		&totals.CostUSD,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &totals, nil
+}
+
+// GetUserDayCost returns the number of otis sessions a user started on a UTC day
+// and their total cost
+func (s *Store) GetUserDayCost(userID string, day time.Time) (int, float64, error) {
+	query := `
+	SELECT COUNT(*), COALESCE(SUM(total_cost_usd), 0)
+	FROM sessions
+	WHERE user_id = ? AND start_time >= ? AND start_time < ?
+	`
+
+	start := day.UTC().Truncate(24 * time.Hour)
+	var count int
+	var cost float64
+	err := s.queryRow(queryGetUserDayCost, query, userID, start.Unix(), start.Add(24*time.Hour).Unix()).Scan(&count, &cost)
+	return count, cost, err
+}
+
+// DefaultSessionDurationBuckets are the upper bounds of the default duration
+// histogram buckets: <1m, 1-5m, 5-30m, 30m-2h and 2h+
+var DefaultSessionDurationBuckets = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+}
+
+// GetSessionDurationHistogram buckets session durations (end_time - start_time)
+// for sessions started within the window. Sessions without an end time use their
+// last update as the end. buckets holds ascending upper bounds; a final open-ended
+// bucket catches everything longer.
+func (s *Store) GetSessionDurationHistogram(window TimeWindow, buckets []time.Duration) ([]*DurationBucket, error) {
+	query := `
+	SELECT COALESCE(end_time, updated_at, start_time) - start_time
+	FROM sessions
+	WHERE start_time >= ? AND start_time < ?
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryGetSessionDurationHistogram, query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histogram := make([]*DurationBucket, len(buckets)+1)
+	var lower time.Duration
+	for i, upper := range buckets {
+		histogram[i] = &DurationBucket{
+			Label:      fmt.Sprintf("%s-%s", formatBucketDuration(lower), formatBucketDuration(upper)),
+			MinSeconds: lower.Seconds(),
+			MaxSeconds: upper.Seconds(),
+		}
+		lower = upper
+	}
+	histogram[len(buckets)] = &DurationBucket{
+		Label:      formatBucketDuration(lower) + "+",
+		MinSeconds: lower.Seconds(),
+	}
+
+	for rows.Next() {
+		var seconds int64
+		if err := rows.Scan(&seconds); err != nil {
+			return nil, err
+		}
+
+		duration := time.Duration(seconds) * time.Second
+		bucket := len(buckets)
+		for i, upper := range buckets {
+			if duration < upper {
+				bucket = i
+				break
+			}
+		}
+		histogram[bucket].Count++
+	}
+
+	return histogram, rows.Err()
+}
+
+// GetOrgUserCosts sums session cost per user in an organization over a
+// window, highest first
+func (s *Store) GetOrgUserCosts(orgID string, window TimeWindow) ([]*UserCost, error) {
+	query := `
+	SELECT user_id, SUM(total_cost_usd) as total_cost
+	FROM sessions
+	WHERE organization_id = ? AND start_time >= ? AND start_time < ?
+	GROUP BY user_id
+	ORDER BY total_cost DESC
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryGetOrgUserCosts, query, orgID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var costs []*UserCost
+	for rows.Next() {
+		var uc UserCost
+		if err := rows.Scan(&uc.UserID, &uc.CostUSD); err != nil {
+			return nil, err
+		}
+		costs = append(costs, &uc)
+	}
+
+	return costs, rows.Err()
+}
+
+// GetOrgSessionHours returns the business and after hours split of each of
+// an organization's sessions started in a window, oldest first
+func (s *Store) GetOrgSessionHours(orgID string, window TimeWindow) ([]*SessionHours, error) {
+	query := `
+	SELECT session_id, user_id, start_time, business_seconds, after_hours_seconds, total_cost_usd
+	FROM sessions
+	WHERE organization_id = ? AND start_time >= ? AND start_time < ?
+	ORDER BY start_time ASC, session_id ASC
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryGetOrgSessionHours, query, orgID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hours []*SessionHours
+	for rows.Next() {
+		var sh SessionHours
+		var startTime int64
+		if err := rows.Scan(&sh.SessionID, &sh.UserID, &startTime, &sh.BusinessSeconds, &sh.AfterHoursSeconds, &sh.CostUSD); err != nil {
+			return nil, err
+		}
+		sh.StartTime = time.Unix(startTime, 0)
+		hours = append(hours, &sh)
+	}
+
+	return hours, rows.Err()
+}
+
+// CountOrgActiveUsers counts the distinct users with a session in an
+// organization started between start and end
+func (s *Store) CountOrgActiveUsers(orgID string, start, end time.Time) (int, error) {
+	query := `
+	SELECT COUNT(DISTINCT user_id)
+	FROM sessions
+	WHERE organization_id = ? AND user_id != '' AND start_time >= ? AND start_time < ?
+	`
+
+	var count int
+	err := s.queryRow(queryCountOrgActiveUsers, query, orgID, start.Unix(), end.Unix()).Scan(&count)
+	return count, err
+}
+
+// GetOrgUserLastActivity retrieves when each of an organization's users last
+// had a session, by its end or, for open sessions, its start. The least
+// recently active users come first.
+func (s *Store) GetOrgUserLastActivity(orgID string) ([]*UserActivity, error) {
+	query := `
+	SELECT user_id, MAX(COALESCE(end_time, start_time)) AS last_active
+	FROM sessions
+	WHERE organization_id = ? AND user_id != ''
+	GROUP BY user_id
+	ORDER BY last_active ASC, user_id ASC
+	`
+
+	rows, err := s.query(queryGetOrgUserLastActivity, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*UserActivity
+	for rows.Next() {
+		var ua UserActivity
+		var lastActive int64
+		if err := rows.Scan(&ua.UserID, &lastActive); err != nil {
+			return nil, err
+		}
+		ua.LastActive = time.Unix(lastActive, 0)
+		users = append(users, &ua)
+	}
+
+	return users, rows.Err()
+}
+
+// GetOrgModelCacheUsage sums cache token usage per model over an
+// organization's sessions started in a window
+func (s *Store) GetOrgModelCacheUsage(orgID string, window TimeWindow) ([]*ModelCacheUsage, error) {
+	query := `
+	SELECT sm.model, SUM(sm.cache_creation_tokens), SUM(sm.cache_read_tokens)
+	FROM session_models sm
+	JOIN sessions s ON s.session_id = sm.session_id
+	WHERE s.organization_id = ? AND s.start_time >= ? AND s.start_time < ?
+	GROUP BY sm.model
+	ORDER BY sm.model
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryGetOrgModelCacheUsage, query, orgID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []*ModelCacheUsage
+	for rows.Next() {
+		var mu ModelCacheUsage
+		if err := rows.Scan(&mu.Model, &mu.CacheCreationTokens, &mu.CacheReadTokens); err != nil {
+			return nil, err
+		}
+		usage = append(usage, &mu)
+	}
+
+	return usage, rows.Err()
+}
+
+// GetServiceBreakdown sums session usage per service over a window, highest
+// cost first. Sessions without a service name are grouped as "unknown".
+func (s *Store) GetServiceBreakdown(window TimeWindow) ([]*ServiceUsage, error) {
+	query := `
+	SELECT
+		COALESCE(NULLIF(client_name, ''), 'unknown') as service_name,
+		COUNT(*) as sessions,
+		SUM(total_cost_usd) as total_cost,
+		SUM(total_input_tokens),
+		SUM(total_output_tokens),
+		SUM(total_cache_read_tokens),
+		SUM(total_cache_creation_tokens)
+	FROM sessions
+	WHERE start_time >= ? AND start_time < ?
+	GROUP BY service_name
+	ORDER BY total_cost DESC, service_name
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryGetServiceBreakdown, query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var services []*ServiceUsage
+	for rows.Next() {
+		var su ServiceUsage
+		if err := rows.Scan(&su.ServiceName, &su.Sessions, &su.CostUSD,
+			&su.InputTokens, &su.OutputTokens, &su.CacheReadTokens, &su.CacheCreationTokens); err != nil {
+			return nil, err
+		}
+		services = append(services, &su)
+	}
+
+	return services, rows.Err()
+}
+
+// windowBounds returns the window as Unix second bounds; zero times are unbounded
+func windowBounds(window TimeWindow) (int64, int64) {
+	start := int64(math.MinInt64)
+	end := int64(math.MaxInt64)
+	if !window.Start.IsZero() {
+		start = window.Start.Unix()
+	}
+	if !window.End.IsZero() {
+		end = window.End.Unix()
+	}
+	return start, end
+}
+
+// formatBucketDuration formats a bucket bound compactly, e.g. 0, 5m, 2h
+func formatBucketDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
@@ -18,152 +18,17 @@ func TestStoreInitialization(t *testing.T) {
 	}
 	defer store.Close()
 
-	// Verify tables were created
-	var count int
-	err = store.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='session_stats'").Scan(&count)
-	if err != nil {
-		t.Fatalf("Failed to query session_stats table: %v", err)
-	}
-	if count != 1 {
-		t.Errorf("Expected session_stats table to exist, got count: %d", count)
-	}
-}
-
-func TestSessionStatsUpsert(t *testing.T) {
-	dbPath := "./test_otis.db"
-	defer os.Remove(dbPath)
-
-	store, err := NewStore(dbPath)
-	if err != nil {
-		t.Fatalf("Failed to create store: %v", err)
-	}
-	defer store.Close()
-
-	// Create test session stats
-	stats := &SessionStats{
-		SessionID:        "test-session-123",
-		UserID:           "user-456",
-		OrganizationID:   "org-789",
-		ServiceName:      "test-service",
-		StartTime:        time.Now(),
-		LastUpdateTime:   time.Now(),
-		TotalCostUSD:     1.23,
-		TotalInputTokens: 1000,
-		TotalOutputTokens: 500,
-		APIRequestCount:  5,
-		ModelsUsed:       `["claude-3-5-sonnet"]`,
-		ToolsUsed:        `{"Read": 3, "Write": 2}`,
-		CreatedAt:        time.Now(),
-		UpdatedAt:        time.Now(),
-	}
-
-	// Insert
-	err = store.UpsertSessionStats(stats)
-	if err != nil {
-		t.Fatalf("Failed to insert session stats: %v", err)
-	}
-
-	// Retrieve
-	retrieved, err := store.GetSessionStats("test-session-123")
-	if err != nil {
-		t.Fatalf("Failed to retrieve session stats: %v", err)
-	}
-
-	// Verify
-	if retrieved.SessionID != stats.SessionID {
-		t.Errorf("Expected session_id %s, got %s", stats.SessionID, retrieved.SessionID)
-	}
-	if retrieved.UserID != stats.UserID {
-		t.Errorf("Expected user_id %s, got %s", stats.UserID, retrieved.UserID)
-	}
-	if retrieved.TotalCostUSD != stats.TotalCostUSD {
-		t.Errorf("Expected cost %f, got %f", stats.TotalCostUSD, retrieved.TotalCostUSD)
-	}
-	if retrieved.TotalInputTokens != stats.TotalInputTokens {
-		t.Errorf("Expected input tokens %d, got %d", stats.TotalInputTokens, retrieved.TotalInputTokens)
-	}
-
-	// Update
-	stats.TotalCostUSD = 2.46
-	stats.APIRequestCount = 10
-	stats.UpdatedAt = time.Now()
-
-	err = store.UpsertSessionStats(stats)
-	if err != nil {
-		t.Fatalf("Failed to update session stats: %v", err)
-	}
-
-	// Retrieve updated
-	updated, err := store.GetSessionStats("test-session-123")
-	if err != nil {
-		t.Fatalf("Failed to retrieve updated session stats: %v", err)
-	}
-
-	if updated.TotalCostUSD != 2.46 {
-		t.Errorf("Expected updated cost 2.46, got %f", updated.TotalCostUSD)
-	}
-	if updated.APIRequestCount != 10 {
-		t.Errorf("Expected updated API count 10, got %d", updated.APIRequestCount)
-	}
-}
-
-func TestGetUserSessionStats(t *testing.T) {
-	dbPath := "./test_otis.db"
-	defer os.Remove(dbPath)
-
-	store, err := NewStore(dbPath)
-	if err != nil {
-		t.Fatalf("Failed to create store: %v", err)
-	}
-	defer store.Close()
-
-	// Insert multiple sessions for same user
-	userID := "test-user-123"
-	for i := 0; i < 5; i++ {
-		stats := &SessionStats{
-			SessionID:        fmt.Sprintf("session-%d", i),
-			UserID:           userID,
-			OrganizationID:   "org-789",
-			StartTime:        time.Now().Add(time.Duration(i) * time.Hour),
-			LastUpdateTime:   time.Now(),
-			TotalCostUSD:     float64(i) * 0.5,
-			TotalInputTokens: int64(i * 100),
-			CreatedAt:        time.Now(),
-			UpdatedAt:        time.Now(),
-			ModelsUsed:       "[]",
-			ToolsUsed:        "{}",
-		}
-		if err := store.UpsertSessionStats(stats); err != nil {
-			t.Fatalf("Failed to insert session %d: %v", i, err)
+	// Verify tables were created, and the legacy ones dropped
+	for table, want := range map[string]int{"sessions": 1, "session_stats": 0} {
+		var count int
+		err = store.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&count)
+		if err != nil {
+			t.Fatalf("Failed to query %s table: %v", table, err)
 		}
-	}
-
-	// Retrieve user sessions
-	sessions, err := store.GetUserSessionStats(userID, 10)
-	if err != nil {
-		t.Fatalf("Failed to get user sessions: %v", err)
-	}
-
-	if len(sessions) != 5 {
-		t.Errorf("Expected 5 sessions, got %d", len(sessions))
-	}
-
-	// Verify they're all for the correct user
-	for _, session := range sessions {
-		if session.UserID != userID {
-			t.Errorf("Expected user_id %s, got %s", userID, session.UserID)
+		if count != want {
+			t.Errorf("Expected %d %s tables, got %d", want, table, count)
 		}
 	}
-
-	// Test limit
-	limited, err := store.GetUserSessionStats(userID, 3)
-	if err != nil {
-		t.Fatalf("Failed to get limited user sessions: %v", err)
-	}
-
-	if len(limited) != 3 {
-		t.Errorf("Expected 3 sessions with limit, got %d", len(limited))
-	}
 }
 
 func TestProcessingState(t *testing.T) {
@@ -185,9 +50,12 @@ func TestProcessingState(t *testing.T) {
 	if state.LastByteOffset != 0 {
 		t.Errorf("Expected initial byte offset 0, got %d", state.LastByteOffset)
 	}
+	if state.LastProcessedLine != 0 {
+		t.Errorf("Expected initial line 0, got %d", state.LastProcessedLine)
+	}
 
 	// Update state
-	err = store.UpdateProcessingState("test.jsonl", 42, 1024, 12345)
+	err = store.UpdateProcessingState("test.jsonl", 3, 42, 1024, 12345)
 	if err != nil {
 		t.Fatalf("Failed to update processing state: %v", err)
 	}
@@ -201,6 +69,9 @@ func TestProcessingState(t *testing.T) {
 	if updated.LastByteOffset != 42 {
 		t.Errorf("Expected byte offset 42, got %d", updated.LastByteOffset)
 	}
+	if updated.LastProcessedLine != 3 {
+		t.Errorf("Expected line 3, got %d", updated.LastProcessedLine)
+	}
 	if updated.FileSizeBytes != 1024 {
 		t.Errorf("Expected size 1024, got %d", updated.FileSizeBytes)
 	}
@@ -209,8 +80,8 @@ func TestProcessingState(t *testing.T) {
 	}
 }
 
-func TestSessionModelStatsUpsert(t *testing.T) {
-	dbPath := "./test_model_stats.db"
+func TestSessionModelUpsert(t *testing.T) {
+	dbPath := "./test_session_models.db"
 	defer os.Remove(dbPath)
 
 	store, err := NewStore(dbPath)
@@ -219,8 +90,8 @@ func TestSessionModelStatsUpsert(t *testing.T) {
 	}
 	defer store.Close()
 
-	// Create test model stats
-	modelStats := &SessionModelStats{
+	// Create test session model
+	model := &SessionModel{
 		SessionID:           "session-123",
 		Model:               "claude-sonnet-4-5",
 		CostUSD:             0.0042,
@@ -230,160 +101,60 @@ func TestSessionModelStatsUpsert(t *testing.T) {
 		CacheCreationTokens: 200,
 		RequestCount:        5,
 		TotalLatencyMS:      6252.5,
-		AvgLatencyMS:        1250.5,
+		P50LatencyMS:        1200,
+		P95LatencyMS:        1800,
+		P99LatencyMS:        1950,
 	}
 
 	// Insert
-	err = store.UpsertSessionModelStats(modelStats)
+	err = store.UpsertSessionModel(model)
 	if err != nil {
-		t.Fatalf("Failed to insert model stats: %v", err)
+		t.Fatalf("Failed to insert session model: %v", err)
 	}
 
-	// Verify by querying database directly
-	var retrieved SessionModelStats
-	err = store.db.QueryRow(`
-		SELECT session_id, model, cost_usd, input_tokens, output_tokens,
-			cache_read_tokens, cache_creation_tokens, request_count,
-			total_latency_ms, avg_latency_ms
-		FROM session_model_stats
-		WHERE session_id = ? AND model = ?
-	`, "session-123", "claude-sonnet-4-5").Scan(
-		&retrieved.SessionID, &retrieved.Model, &retrieved.CostUSD,
-		&retrieved.InputTokens, &retrieved.OutputTokens,
-		&retrieved.CacheReadTokens, &retrieved.CacheCreationTokens,
-		&retrieved.RequestCount, &retrieved.TotalLatencyMS, &retrieved.AvgLatencyMS,
-	)
-	if err != nil {
-		t.Fatalf("Failed to retrieve model stats: %v", err)
-	}
-
-	// Verify values
-	if retrieved.CostUSD != modelStats.CostUSD {
-		t.Errorf("Expected cost %f, got %f", modelStats.CostUSD, retrieved.CostUSD)
-	}
-	if retrieved.InputTokens != modelStats.InputTokens {
-		t.Errorf("Expected input tokens %d, got %d", modelStats.InputTokens, retrieved.InputTokens)
-	}
-	if retrieved.RequestCount != modelStats.RequestCount {
-		t.Errorf("Expected request count %d, got %d", modelStats.RequestCount, retrieved.RequestCount)
-	}
-
-	// Update
-	modelStats.CostUSD = 0.0084
-	modelStats.RequestCount = 10
-	modelStats.AvgLatencyMS = 1100.0
-
-	err = store.UpsertSessionModelStats(modelStats)
-	if err != nil {
-		t.Fatalf("Failed to update model stats: %v", err)
-	}
-
-	// Retrieve updated
-	err = store.db.QueryRow(`
-		SELECT cost_usd, request_count, avg_latency_ms
-		FROM session_model_stats
-		WHERE session_id = ? AND model = ?
-	`, "session-123", "claude-sonnet-4-5").Scan(
-		&retrieved.CostUSD, &retrieved.RequestCount, &retrieved.AvgLatencyMS,
-	)
-	if err != nil {
-		t.Fatalf("Failed to retrieve updated model stats: %v", err)
-	}
-
-	if retrieved.CostUSD != 0.0084 {
-		t.Errorf("Expected updated cost 0.0084, got %f", retrieved.CostUSD)
-	}
-	if retrieved.RequestCount != 10 {
-		t.Errorf("Expected updated request count 10, got %d", retrieved.RequestCount)
-	}
-}
-
-func TestSessionToolStatsUpsert(t *testing.T) {
-	dbPath := "./test_tool_stats.db"
-	defer os.Remove(dbPath)
-
-	store, err := NewStore(dbPath)
+	// Retrieve
+	models, err := store.GetSessionModels("session-123")
 	if err != nil {
-		t.Fatalf("Failed to create store: %v", err)
+		t.Fatalf("Failed to retrieve session models: %v", err)
 	}
-	defer store.Close()
-
-	// Create test tool stats
-	toolStats := &SessionToolStats{
-		SessionID:       "session-456",
-		ToolName:        "Read",
-		ExecutionCount:  12,
-		SuccessCount:    12,
-		FailureCount:    0,
-		TotalDurationMS: 542.4,
-		AvgDurationMS:   45.2,
-		MinDurationMS:   12.3,
-		MaxDurationMS:   120.8,
-	}
-
-	// Insert
-	err = store.UpsertSessionToolStats(toolStats)
-	if err != nil {
-		t.Fatalf("Failed to insert tool stats: %v", err)
+	if len(models) != 1 {
+		t.Fatalf("Expected 1 model, got %d", len(models))
 	}
 
-	// Verify by querying database directly
-	var retrieved SessionToolStats
-	err = store.db.QueryRow(`
-		SELECT session_id, tool_name, execution_count, success_count, failure_count,
-			total_duration_ms, avg_duration_ms, min_duration_ms, max_duration_ms
-		FROM session_tool_stats
-		WHERE session_id = ? AND tool_name = ?
-	`, "session-456", "Read").Scan(
-		&retrieved.SessionID, &retrieved.ToolName,
-		&retrieved.ExecutionCount, &retrieved.SuccessCount, &retrieved.FailureCount,
-		&retrieved.TotalDurationMS, &retrieved.AvgDurationMS,
-		&retrieved.MinDurationMS, &retrieved.MaxDurationMS,
-	)
-	if err != nil {
-		t.Fatalf("Failed to retrieve tool stats: %v", err)
+	retrieved := models[0]
+	if retrieved.CostUSD != model.CostUSD {
+		t.Errorf("Expected cost %f, got %f", model.CostUSD, retrieved.CostUSD)
 	}
-
-	// Verify values
-	if retrieved.ExecutionCount != toolStats.ExecutionCount {
-		t.Errorf("Expected execution count %d, got %d", toolStats.ExecutionCount, retrieved.ExecutionCount)
+	if retrieved.InputTokens != model.InputTokens {
+		t.Errorf("Expected input tokens %d, got %d", model.InputTokens, retrieved.InputTokens)
 	}
-	if retrieved.SuccessCount != toolStats.SuccessCount {
-		t.Errorf("Expected success count %d, got %d", toolStats.SuccessCount, retrieved.SuccessCount)
+	if retrieved.RequestCount != model.RequestCount {
+		t.Errorf("Expected request count %d, got %d", model.RequestCount, retrieved.RequestCount)
 	}
-	if retrieved.AvgDurationMS != toolStats.AvgDurationMS {
-		t.Errorf("Expected avg duration %f, got %f", toolStats.AvgDurationMS, retrieved.AvgDurationMS)
+	if retrieved.P95LatencyMS != model.P95LatencyMS {
+		t.Errorf("Expected p95 latency %f, got %f", model.P95LatencyMS, retrieved.P95LatencyMS)
 	}
 
 	// Update
-	toolStats.ExecutionCount = 15
-	toolStats.SuccessCount = 14
-	toolStats.FailureCount = 1
-	toolStats.AvgDurationMS = 50.0
+	model.CostUSD = 0.0084
+	model.RequestCount = 10
 
-	err = store.UpsertSessionToolStats(toolStats)
+	err = store.UpsertSessionModel(model)
 	if err != nil {
-		t.Fatalf("Failed to update tool stats: %v", err)
+		t.Fatalf("Failed to update session model: %v", err)
 	}
 
 	// Retrieve updated
-	err = store.db.QueryRow(`
-		SELECT execution_count, success_count, failure_count, avg_duration_ms
-		FROM session_tool_stats
-		WHERE session_id = ? AND tool_name = ?
-	`, "session-456", "Read").Scan(
-		&retrieved.ExecutionCount, &retrieved.SuccessCount,
-		&retrieved.FailureCount, &retrieved.AvgDurationMS,
-	)
+	models, err = store.GetSessionModels("session-123")
 	if err != nil {
-		t.Fatalf("Failed to retrieve updated tool stats: %v", err)
+		t.Fatalf("Failed to retrieve updated session models: %v", err)
 	}
 
-	if retrieved.ExecutionCount != 15 {
-		t.Errorf("Expected updated execution count 15, got %d", retrieved.ExecutionCount)
+	if models[0].CostUSD != 0.0084 {
+		t.Errorf("Expected updated cost 0.0084, got %f", models[0].CostUSD)
 	}
-	if retrieved.FailureCount != 1 {
-		t.Errorf("Expected updated failure count 1, got %d", retrieved.FailureCount)
+	if models[0].RequestCount != 10 {
+		t.Errorf("Expected updated request count 10, got %d", models[0].RequestCount)
 	}
 }
 
@@ -504,6 +275,8 @@ func TestSessionToolUpsert(t *testing.T) {
 		SuccessCount:         10,
 		FailureCount:         2,
 		TotalExecutionTimeMS: 542.4,
+		MinExecutionTimeMS:   12.3,
+		MaxExecutionTimeMS:   120.8,
 	}
 
 	// Insert
@@ -535,6 +308,9 @@ func TestSessionToolUpsert(t *testing.T) {
 	if retrieved.FailureCount != tool.FailureCount {
 		t.Errorf("Expected failure_count %d, got %d", tool.FailureCount, retrieved.FailureCount)
 	}
+	if retrieved.MinExecutionTimeMS != tool.MinExecutionTimeMS || retrieved.MaxExecutionTimeMS != tool.MaxExecutionTimeMS {
+		t.Errorf("Expected execution time range %f-%f, got %f-%f", tool.MinExecutionTimeMS, tool.MaxExecutionTimeMS, retrieved.MinExecutionTimeMS, retrieved.MaxExecutionTimeMS)
+	}
 
 	// Update
 	tool.CallCount = 20
@@ -676,23 +452,19 @@ func TestGetSessionsByUser(t *testing.T) {
 
 	// Insert multiple sessions for same user
 	for i := 0; i < 5; i++ {
-		session := &Session{
-			SessionID:        fmt.Sprintf("session-v2-%d", i),
-			OrganizationID:   "org-789",
-			UserID:           userID,
-			StartTime:        time.Now().Add(time.Duration(i) * time.Hour),
-			TotalCostUSD:     float64(i) * 0.5,
-			TotalInputTokens: int64(i * 100),
-			CreatedAt:        time.Now(),
-			UpdatedAt:        time.Now(),
-		}
-		if err := store.UpsertSession(session); err != nil {
+		if err := SeedSession(store, SessionSpec{
+			SessionID:      fmt.Sprintf("session-v2-%d", i),
+			OrganizationID: "org-789",
+			UserID:         userID,
+			StartTime:      time.Now().Add(time.Duration(i) * time.Hour),
+			Models:         []ModelSpec{{Model: "claude-sonnet-4-5", Requests: i, CostUSD: float64(i) * 0.5, InputTokens: int64(i * 100)}},
+		}); err != nil {
 			t.Fatalf("Failed to insert session %d: %v", i, err)
 		}
 	}
 
 	// Retrieve user sessions
-	sessions, err := store.GetSessionsByUser(userID, 10)
+	sessions, err := store.GetSessionsByUser(userID, nil, 10)
 	if err != nil {
 		t.Fatalf("Failed to get user sessions: %v", err)
 	}
@@ -709,7 +481,7 @@ func TestGetSessionsByUser(t *testing.T) {
 	}
 
 	// Test limit
-	limited, err := store.GetSessionsByUser(userID, 3)
+	limited, err := store.GetSessionsByUser(userID, nil, 3)
 	if err != nil {
 		t.Fatalf("Failed to get limited user sessions: %v", err)
 	}
@@ -733,23 +505,19 @@ func TestGetSessionsByOrg(t *testing.T) {
 
 	// Insert multiple sessions for same org with different users
 	for i := 0; i < 5; i++ {
-		session := &Session{
-			SessionID:        fmt.Sprintf("session-org-%d", i),
-			OrganizationID:   orgID,
-			UserID:           fmt.Sprintf("user-%d", i),
-			StartTime:        time.Now().Add(time.Duration(i) * time.Hour),
-			TotalCostUSD:     float64(i) * 0.5,
-			TotalInputTokens: int64(i * 100),
-			CreatedAt:        time.Now(),
-			UpdatedAt:        time.Now(),
-		}
-		if err := store.UpsertSession(session); err != nil {
+		if err := SeedSession(store, SessionSpec{
+			SessionID:      fmt.Sprintf("session-org-%d", i),
+			OrganizationID: orgID,
+			UserID:         fmt.Sprintf("user-%d", i),
+			StartTime:      time.Now().Add(time.Duration(i) * time.Hour),
+			Models:         []ModelSpec{{Model: "claude-sonnet-4-5", Requests: i, CostUSD: float64(i) * 0.5, InputTokens: int64(i * 100)}},
+		}); err != nil {
 			t.Fatalf("Failed to insert session %d: %v", i, err)
 		}
 	}
 
 	// Retrieve org sessions
-	sessions, err := store.GetSessionsByOrg(orgID, 10)
+	sessions, err := store.GetSessionsByOrg(orgID, nil, 10)
 	if err != nil {
 		t.Fatalf("Failed to get org sessions: %v", err)
 	}
@@ -776,33 +544,21 @@ func TestGetToolAggregates(t *testing.T) {
 	}
 	defer store.Close()
 
-	// Create sessions first
-	for i := 0; i < 3; i++ {
-		session := &Session{
+	// Tools across multiple sessions
+	toolsBySession := [][]ToolSpec{
+		{{Name: "Read", Successes: 9, Failures: 1, ExecutionTimeMS: 100}, {Name: "Write", Successes: 5, ExecutionTimeMS: 50}},
+		{{Name: "Read", Successes: 8, ExecutionTimeMS: 80}, {Name: "Edit", Successes: 2, Failures: 1, ExecutionTimeMS: 30}},
+		{{Name: "Read", Successes: 11, Failures: 1, ExecutionTimeMS: 120}},
+	}
+	for i, tools := range toolsBySession {
+		if err := SeedSession(store, SessionSpec{
 			SessionID:      fmt.Sprintf("session-agg-%d", i),
 			OrganizationID: "org-123",
 			UserID:         "user-456",
 			StartTime:      time.Now(),
-			CreatedAt:      time.Now(),
-			UpdatedAt:      time.Now(),
-		}
-		if err := store.UpsertSession(session); err != nil {
-			t.Fatalf("Failed to create session: %v", err)
-		}
-	}
-
-	// Insert tools across multiple sessions
-	tools := []SessionTool{
-		{SessionID: "session-agg-0", ToolName: "Read", CallCount: 10, SuccessCount: 9, FailureCount: 1, TotalExecutionTimeMS: 100},
-		{SessionID: "session-agg-0", ToolName: "Write", CallCount: 5, SuccessCount: 5, FailureCount: 0, TotalExecutionTimeMS: 50},
-		{SessionID: "session-agg-1", ToolName: "Read", CallCount: 8, SuccessCount: 8, FailureCount: 0, TotalExecutionTimeMS: 80},
-		{SessionID: "session-agg-1", ToolName: "Edit", CallCount: 3, SuccessCount: 2, FailureCount: 1, TotalExecutionTimeMS: 30},
-		{SessionID: "session-agg-2", ToolName: "Read", CallCount: 12, SuccessCount: 11, FailureCount: 1, TotalExecutionTimeMS: 120},
-	}
-
-	for _, tool := range tools {
-		if err := store.UpsertSessionTool(&tool); err != nil {
-			t.Fatalf("Failed to insert tool: %v", err)
+			Tools:          tools,
+		}); err != nil {
+			t.Fatalf("Failed to seed session: %v", err)
 		}
 	}
 
@@ -954,3 +710,206 @@ func TestSessionPromptsEmptySession(t *testing.T) {
 		t.Errorf("Expected 0 prompts for non-existent session, got %d", len(retrieved))
 	}
 }
+
+func TestGetSessionDurationHistogram(t *testing.T) {
+	dbPath := "./test_duration_histogram.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	durations := []time.Duration{
+		30 * time.Second, // <1m
+		3 * time.Minute,  // 1-5m
+		4 * time.Minute,  // 1-5m
+		10 * time.Minute, // 5-30m
+		time.Hour,        // 30m-2h
+		5 * time.Hour,    // 2h+
+	}
+	for i, d := range durations {
+		if err := SeedSession(store, SessionSpec{
+			SessionID:      fmt.Sprintf("session-%d", i),
+			OrganizationID: "org-1",
+			UserID:         "user-1",
+			StartTime:      base,
+			Duration:       d,
+		}); err != nil {
+			t.Fatalf("Failed to seed session: %v", err)
+		}
+	}
+
+	// Open session with no end time falls back to its last update (45m)
+	if _, err := store.db.Exec(`
+		INSERT INTO sessions (session_id, organization_id, user_id, start_time, created_at, updated_at)
+		VALUES ('open', 'org-1', 'user-1', ?, ?, ?)
+	`, base.Unix(), base.Unix(), base.Add(45*time.Minute).Unix()); err != nil {
+		t.Fatalf("Failed to insert open session: %v", err)
+	}
+
+	histogram, err := store.GetSessionDurationHistogram(TimeWindow{Type: "all-time"}, DefaultSessionDurationBuckets)
+	if err != nil {
+		t.Fatalf("Failed to get histogram: %v", err)
+	}
+
+	expected := []struct {
+		label string
+		count int
+	}{
+		{"0-1m", 1},
+		{"1m-5m", 2},
+		{"5m-30m", 1},
+		{"30m-2h", 2},
+		{"2h+", 1},
+	}
+	if len(histogram) != len(expected) {
+		t.Fatalf("Expected %d buckets, got %d", len(expected), len(histogram))
+	}
+	for i, want := range expected {
+		if histogram[i].Label != want.label || histogram[i].Count != want.count {
+			t.Errorf("Bucket %d: expected %s=%d, got %s=%d", i, want.label, want.count, histogram[i].Label, histogram[i].Count)
+		}
+	}
+
+	// A window that excludes every session returns empty buckets
+	window := TimeWindow{Start: base.Add(time.Hour), End: base.Add(2 * time.Hour), Type: "custom"}
+	histogram, err = store.GetSessionDurationHistogram(window, DefaultSessionDurationBuckets)
+	if err != nil {
+		t.Fatalf("Failed to get histogram: %v", err)
+	}
+	for _, bucket := range histogram {
+		if bucket.Count != 0 {
+			t.Errorf("Expected empty bucket %s, got %d", bucket.Label, bucket.Count)
+		}
+	}
+}
+
+func TestGetServiceBreakdown(t *testing.T) {
+	dbPath := "./test_service_breakdown.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	sessions := []struct {
+		service string
+		cost    float64
+		input   int64
+	}{
+		{"claude-code", 1.5, 1000},
+		{"claude-code", 0.5, 500},
+		{"review-bot", 3.0, 4000},
+		{"", 0.25, 10},
+	}
+	for i, s := range sessions {
+		if err := SeedSession(store, SessionSpec{
+			SessionID:      fmt.Sprintf("service-session-%d", i),
+			OrganizationID: "org-1",
+			UserID:         "user-1",
+			StartTime:      base.Add(time.Duration(i) * time.Minute),
+			ClientName:     s.service,
+			Models:         []ModelSpec{{Model: "claude-sonnet-4-5", Requests: 1, CostUSD: s.cost, InputTokens: s.input}},
+		}); err != nil {
+			t.Fatalf("Failed to seed session: %v", err)
+		}
+	}
+
+	services, err := store.GetServiceBreakdown(TimeWindow{Type: "all-time"})
+	if err != nil {
+		t.Fatalf("Failed to get service breakdown: %v", err)
+	}
+
+	expected := []ServiceUsage{
+		{ServiceName: "review-bot", Sessions: 1, CostUSD: 3.0, InputTokens: 4000},
+		{ServiceName: "claude-code", Sessions: 2, CostUSD: 2.0, InputTokens: 1500},
+		{ServiceName: "unknown", Sessions: 1, CostUSD: 0.25, InputTokens: 10},
+	}
+	if len(services) != len(expected) {
+		t.Fatalf("Expected %d services, got %d", len(expected), len(services))
+	}
+	for i, want := range expected {
+		if *services[i] != want {
+			t.Errorf("Service %d: expected %+v, got %+v", i, want, *services[i])
+		}
+	}
+
+	// The window filters on session start
+	window := TimeWindow{Start: base.Add(2 * time.Minute), End: base.Add(time.Hour), Type: "custom"}
+	services, err = store.GetServiceBreakdown(window)
+	if err != nil {
+		t.Fatalf("Failed to get service breakdown: %v", err)
+	}
+	if len(services) != 2 || services[0].ServiceName != "review-bot" || services[1].ServiceName != "unknown" {
+		t.Errorf("Expected review-bot and unknown in the window, got %d services", len(services))
+	}
+}
+
+func TestGetTopPrompts(t *testing.T) {
+	dbPath := "./test_top_prompts.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	prompts := []struct {
+		session string
+		text    string
+		offset  time.Duration
+	}{
+		{"session-1", "Run the tests", 0},
+		{"session-1", "  run the TESTS ", time.Minute},
+		{"session-2", "run the tests", 2 * time.Minute},
+		{"session-2", "fix the build", 3 * time.Minute},
+		{"session-3", "Fix the build", 4 * time.Minute},
+		{"session-3", "explain this", 5 * time.Minute},
+		{"session-3", "run the tests", 10 * 24 * time.Hour},
+	}
+	for _, p := range prompts {
+		if err := store.InsertSessionPrompt(&SessionPrompt{
+			SessionID:    p.session,
+			PromptText:   p.text,
+			PromptLength: len(p.text),
+			Timestamp:    base.Add(p.offset),
+		}); err != nil {
+			t.Fatalf("Failed to insert prompt: %v", err)
+		}
+	}
+
+	top, err := store.GetTopPrompts(TimeWindow{Type: "all-time"}, 2)
+	if err != nil {
+		t.Fatalf("Failed to get top prompts: %v", err)
+	}
+	if len(top) != 2 {
+		t.Fatalf("Expected 2 prompts, got %d", len(top))
+	}
+	if top[0].PromptText != "run the tests" || top[0].Count != 4 || top[0].Sessions != 3 {
+		t.Errorf("Unexpected top prompt: %+v", top[0])
+	}
+	if !top[0].LastSeen.Equal(base.Add(10 * 24 * time.Hour)) {
+		t.Errorf("Expected last seen %v, got %v", base.Add(10*24*time.Hour), top[0].LastSeen)
+	}
+	if top[1].PromptText != "fix the build" || top[1].Count != 2 || top[1].Sessions != 2 {
+		t.Errorf("Unexpected second prompt: %+v", top[1])
+	}
+
+	// The window leaves out the later repeat
+	window := TimeWindow{Type: "custom", Start: base, End: base.Add(24 * time.Hour)}
+	top, err = store.GetTopPrompts(window, 10)
+	if err != nil {
+		t.Fatalf("Failed to get top prompts: %v", err)
+	}
+	if len(top) != 3 || top[0].Count != 3 || top[2].PromptText != "explain this" {
+		t.Errorf("Unexpected windowed prompts: %+v", top)
+	}
+}
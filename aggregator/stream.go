@@ -0,0 +1,153 @@
+package aggregator
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// streamFlushRows is how many sessions a streamed list writes between
+// flushes to the client
+const streamFlushRows = 100
+
+// streamWriteTimeout is how long a streamed list may take to write each
+// batch of rows, extended after every flush so a long stream is not cut off
+// by the server's write timeout
+const streamWriteTimeout = 30 * time.Second
+
+// StreamSessions calls fn for each session matching the filters and started in
+// the window, newest first, without loading them all into memory. Empty
+// filters match every session. A scan error stops the stream and is returned
+// after the rows already passed to fn.
+//
+// An in-memory store reads every session before calling fn instead: its one
+// connection would otherwise be held for as long as a slow client takes to
+// read, stalling cache flushes and, behind them, direct ingestion.
+func (s *Store) StreamSessions(orgID, userID string, window TimeWindow, fn func(*Session) error) error {
+	if !s.memory {
+		return s.streamSessions(orgID, userID, window, fn)
+	}
+
+	var sessions []*Session
+	err := s.streamSessions(orgID, userID, window, func(session *Session) error {
+		sessions = append(sessions, session)
+		return nil
+	})
+	for _, session := range sessions {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *Store) streamSessions(orgID, userID string, window TimeWindow, fn func(*Session) error) error {
+	query := `
+	SELECT session_id, organization_id, user_id, start_time, end_time,
+		total_cost_usd, total_input_tokens, total_output_tokens,
+		total_cache_read_tokens, total_cache_creation_tokens, tool_call_count,
+		COALESCE(first_model, ''), COALESCE(primary_model, ''),
+		created_at, updated_at
+	FROM sessions
+	WHERE start_time >= ? AND start_time < ?
+		AND (? = '' OR organization_id = ?)
+		AND (? = '' OR user_id = ?)
+	ORDER BY start_time DESC
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryStreamSessions, query, start, end, orgID, orgID, userID, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session Session
+		var startTime, createdAt, updatedAt int64
+		var endTime sql.NullInt64
+
+		err := rows.Scan(
+			&session.SessionID, &session.OrganizationID, &session.UserID,
+			&startTime, &endTime,
+			&session.TotalCostUSD, &session.TotalInputTokens, &session.TotalOutputTokens,
+			&session.TotalCacheReadTokens, &session.TotalCacheCreationTokens, &session.ToolCallCount,
+			&session.FirstModel, &session.PrimaryModel,
+			&createdAt, &updatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to scan session: %w", err)
+		}
+
+		session.StartTime = time.Unix(startTime, 0)
+		if endTime.Valid {
+			session.EndTime = time.Unix(endTime.Int64, 0)
+		}
+		session.CreatedAt = time.Unix(createdAt, 0)
+		session.UpdatedAt = time.Unix(updatedAt, 0)
+
+		if err := fn(&session); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// streamV2Sessions handles GET /api/v2/sessions?stream=true, writing every
+// matching session as a JSON array as it is read rather than a page of them.
+// Once the array has started the status can no longer change, so a failure
+// partway through ends the array with an {"error": "..."} element instead;
+// clients check whether the last element has an error key.
+func (s *APIServer) streamV2Sessions(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	window, err := ParseTimeWindow(query.Get("window"), query.Get("start"), query.Get("end"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("["))
+
+	encoder := json.NewEncoder(w)
+	rows := 0
+	err = s.store.StreamSessions(query.Get("org_id"), query.Get("user_id"), window, func(session *Session) error {
+		if rows > 0 {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		if err := encoder.Encode(buildV2SessionResponse(session)); err != nil {
+			return err
+		}
+		rows++
+
+		if rows%streamFlushRows == 0 {
+			if err := controller.Flush(); err != nil {
+				return err
+			}
+			controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		}
+		return r.Context().Err()
+	})
+
+	if err != nil {
+		log.Printf("Error streaming sessions after %d rows: %v", rows, err)
+		if rows > 0 {
+			w.Write([]byte(","))
+		}
+		encoder.Encode(map[string]interface{}{
+			"error": fmt.Sprintf("Error retrieving sessions: %v", err),
+			"rows":  rows,
+		})
+	}
+	w.Write([]byte("]\n"))
+	controller.Flush()
+}
@@ -0,0 +1,146 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// seedStreamSessions seeds n sessions a minute apart, alternating between
+// org-a and org-b, and returns them newest first
+func seedStreamSessions(t *testing.T, store *Store, n int) []string {
+	t.Helper()
+
+	base := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	ids := make([]string, n)
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("stream-%03d", i)
+		err := SeedSession(store, SessionSpec{
+			SessionID:      id,
+			UserID:         "user-1",
+			OrganizationID: []string{"org-a", "org-b"}[i%2],
+			StartTime:      base.Add(time.Duration(i) * time.Minute),
+			Duration:       time.Minute,
+			Models:         []ModelSpec{{Model: "claude-sonnet-4", Requests: 1, CostUSD: 0.01, InputTokens: 100}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to seed session: %v", err)
+		}
+		ids[n-1-i] = id
+	}
+	return ids
+}
+
+// readSessionStream reads a streamed sessions list one element at a time
+func readSessionStream(t *testing.T, url string) []map[string]interface{} {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('[') {
+		t.Fatalf("Expected the array to open, got %v (%v)", tok, err)
+	}
+	var elements []map[string]interface{}
+	for decoder.More() {
+		var element map[string]interface{}
+		if err := decoder.Decode(&element); err != nil {
+			t.Fatalf("Failed to decode element %d: %v", len(elements), err)
+		}
+		elements = append(elements, element)
+	}
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim(']') {
+		t.Fatalf("Expected the array to close, got %v (%v)", tok, err)
+	}
+	return elements
+}
+
+func TestStreamSessionsList(t *testing.T) {
+	server := newTestAPIServer(t, "./test_stream_sessions.db")
+	ids := seedStreamSessions(t, server.store, 2*streamFlushRows+5)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	// Every session, past the default limit and across several flushes
+	all := readSessionStream(t, ts.URL+"/api/v2/sessions?stream=true")
+	if len(all) != len(ids) {
+		t.Fatalf("Expected %d sessions, got %d", len(ids), len(all))
+	}
+	for i, element := range all {
+		if element["session_id"] != ids[i] {
+			t.Fatalf("Expected %s at %d (newest first), got %v", ids[i], i, element["session_id"])
+		}
+	}
+
+	elements := readSessionStream(t, ts.URL+"/api/v2/sessions?stream=true&org_id=org-b")
+	if len(elements) != len(ids)/2 {
+		t.Errorf("Expected %d org-b sessions, got %d", len(ids)/2, len(elements))
+	}
+	for _, element := range elements {
+		if element["organization_id"] != "org-b" {
+			t.Errorf("Expected only org-b sessions, got %v", element["organization_id"])
+		}
+	}
+
+	// The tenth newest session starts the window, so it holds the ten newest
+	elements = readSessionStream(t, ts.URL+"/api/v2/sessions?stream=true&window=custom&start="+
+		all[9]["start_time"].(string)+"&end="+time.Now().Format(time.RFC3339))
+	if len(elements) != 10 {
+		t.Errorf("Expected the 10 sessions in the window, got %d", len(elements))
+	}
+
+	if elements := readSessionStream(t, ts.URL+"/api/v2/sessions?stream=true&user_id=nobody"); len(elements) != 0 {
+		t.Errorf("Expected an empty array, got %d elements", len(elements))
+	}
+}
+
+func TestStreamSessionsListInvalidWindow(t *testing.T) {
+	server := newTestAPIServer(t, "./test_stream_window.db")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/sessions?stream=true&window=90d", nil)
+	w := httptest.NewRecorder()
+	server.handleV2SessionsList(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", w.Code)
+	}
+}
+
+func TestStreamSessionsListFailsMidway(t *testing.T) {
+	server := newTestAPIServer(t, "./test_stream_failure.db")
+	ids := seedStreamSessions(t, server.store, 5)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	// A row that no longer scans stops the stream after the rows before it
+	if _, err := server.store.db.Exec(`UPDATE sessions SET created_at = 'garbage' WHERE session_id = ?`, ids[2]); err != nil {
+		t.Fatalf("Failed to corrupt session: %v", err)
+	}
+
+	elements := readSessionStream(t, ts.URL+"/api/v2/sessions?stream=true")
+	if len(elements) != 3 {
+		t.Fatalf("Expected 2 sessions and an error, got %d elements", len(elements))
+	}
+	for i, element := range elements[:2] {
+		if element["session_id"] != ids[i] {
+			t.Errorf("Expected %s at %d, got %v", ids[i], i, element["session_id"])
+		}
+	}
+	sentinel := elements[2]
+	if _, ok := sentinel["error"].(string); !ok {
+		t.Fatalf("Expected the last element to be an error, got %v", sentinel)
+	}
+	if sentinel["rows"] != float64(2) {
+		t.Errorf("Expected the error to report 2 rows, got %v", sentinel["rows"])
+	}
+}
@@ -0,0 +1,153 @@
+package aggregator
+
+import "time"
+
+const (
+	throughputBucketSize  = 10 * time.Second
+	throughputBucketCount = 6 // 60 second window
+	throughputWindow      = throughputBucketSize * throughputBucketCount
+)
+
+// Throughput is the live rate of activity over the last throughput window
+type Throughput struct {
+	TokensPerSecond   float64
+	RequestsPerMinute float64
+}
+
+// ActiveSessionThroughput is the live throughput of a single active session
+type ActiveSessionThroughput struct {
+	SessionID      string
+	OrganizationID string
+	Throughput     Throughput
+}
+
+// throughputBucket holds counts for one bucket-sized slice of time
+type throughputBucket struct {
+	start    int64 // Unix seconds, aligned to throughputBucketSize
+	tokens   int64
+	requests int
+}
+
+// sessionThroughput is a ring of buckets covering the throughput window
+type sessionThroughput struct {
+	orgID   string
+	buckets [throughputBucketCount]throughputBucket
+}
+
+// throughputTracker maintains sliding-window counters for active sessions.
+// It is not safe for concurrent use; the Engine guards it with cacheMutex.
+type throughputTracker struct {
+	now      func() time.Time
+	sessions map[string]*sessionThroughput
+}
+
+func newThroughputTracker() *throughputTracker {
+	return &throughputTracker{
+		now:      time.Now,
+		sessions: make(map[string]*sessionThroughput),
+	}
+}
+
+// record adds tokens and requests observed at timestamp to a session's window.
+// Activity that already falls outside the window is ignored.
+func (t *throughputTracker) record(sessionID, orgID string, timestamp time.Time, tokens int64, requests int) {
+	if !t.inWindow(timestamp) {
+		return
+	}
+
+	st, exists := t.sessions[sessionID]
+	if !exists {
+		st = &sessionThroughput{orgID: orgID}
+		t.sessions[sessionID] = st
+	}
+
+	start := bucketStart(timestamp)
+	bucket := &st.buckets[(start/int64(throughputBucketSize.Seconds()))%throughputBucketCount]
+	if bucket.start != start {
+		*bucket = throughputBucket{start: start}
+	}
+	bucket.tokens += tokens
+	bucket.requests += requests
+}
+
+// rate computes the throughput of a session over the current window
+func (t *throughputTracker) rate(st *sessionThroughput) (Throughput, bool) {
+	var tokens int64
+	var requests int
+	active := false
+
+	for _, bucket := range st.buckets {
+		if bucket.start == 0 || !t.inWindow(time.Unix(bucket.start, 0)) {
+			continue
+		}
+		active = true
+		tokens += bucket.tokens
+		requests += bucket.requests
+	}
+
+	return Throughput{
+		TokensPerSecond:   float64(tokens) / throughputWindow.Seconds(),
+		RequestsPerMinute: float64(requests) / throughputWindow.Minutes(),
+	}, active
+}
+
+// prune drops sessions with no activity inside the window
+func (t *throughputTracker) prune() {
+	for sessionID, st := range t.sessions {
+		if _, active := t.rate(st); !active {
+			delete(t.sessions, sessionID)
+		}
+	}
+}
+
+// inWindow reports whether a timestamp falls in a bucket still inside the window
+func (t *throughputTracker) inWindow(timestamp time.Time) bool {
+	oldest := bucketStart(t.now()) - int64(throughputWindow.Seconds()) + int64(throughputBucketSize.Seconds())
+	return bucketStart(timestamp) >= oldest
+}
+
+func bucketStart(timestamp time.Time) int64 {
+	return timestamp.Truncate(throughputBucketSize).Unix()
+}
+
+// SessionThroughput returns the live throughput for a session, if it is active
+func (e *Engine) SessionThroughput(sessionID string) (Throughput, bool) {
+	e.cacheMutex.RLock()
+	defer e.cacheMutex.RUnlock()
+
+	st, exists := e.throughput.sessions[sessionID]
+	if !exists {
+		return Throughput{}, false
+	}
+	return e.throughput.rate(st)
+}
+
+// ActiveSessions returns the live throughput of every active session
+func (e *Engine) ActiveSessions() []*ActiveSessionThroughput {
+	e.cacheMutex.RLock()
+	defer e.cacheMutex.RUnlock()
+
+	var active []*ActiveSessionThroughput
+	for sessionID, st := range e.throughput.sessions {
+		if tp, ok := e.throughput.rate(st); ok {
+			active = append(active, &ActiveSessionThroughput{
+				SessionID:      sessionID,
+				OrganizationID: st.orgID,
+				Throughput:     tp,
+			})
+		}
+	}
+	return active
+}
+
+// OrgThroughput returns the summed live throughput of active sessions per organization
+func (e *Engine) OrgThroughput() map[string]Throughput {
+	orgs := make(map[string]Throughput)
+	for _, session := range e.ActiveSessions() {
+		tp := orgs[session.OrganizationID]
+		tp.TokensPerSecond += session.Throughput.TokensPerSecond
+		tp.RequestsPerMinute += session.Throughput.RequestsPerMinute
+		orgs[session.OrganizationID] = tp
+	}
+	return orgs
+}
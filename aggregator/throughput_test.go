@@ -0,0 +1,127 @@
+package aggregator
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestThroughputTrackerRates(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tracker := newThroughputTracker()
+	tracker.now = func() time.Time { return now }
+
+	tracker.record("session-1", "org-1", now, 600, 1)
+	tracker.record("session-1", "org-1", now.Add(-15*time.Second), 600, 2)
+
+	tp, active := tracker.rate(tracker.sessions["session-1"])
+	if !active {
+		t.Fatal("Expected session to be active")
+	}
+	if tp.TokensPerSecond != 20 {
+		t.Errorf("Expected 20 tokens/sec, got %f", tp.TokensPerSecond)
+	}
+	if tp.RequestsPerMinute != 3 {
+		t.Errorf("Expected 3 requests/min, got %f", tp.RequestsPerMinute)
+	}
+}
+
+func TestThroughputTrackerBucketRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tracker := newThroughputTracker()
+	tracker.now = func() time.Time { return now }
+
+	tracker.record("session-1", "org-1", now, 600, 6)
+
+	// Advance past five more buckets; the first bucket is still in the window
+	now = now.Add(50 * time.Second)
+	tracker.record("session-1", "org-1", now, 60, 0)
+
+	tp, _ := tracker.rate(tracker.sessions["session-1"])
+	if tp.TokensPerSecond != 11 {
+		t.Errorf("Expected 11 tokens/sec, got %f", tp.TokensPerSecond)
+	}
+	if tp.RequestsPerMinute != 6 {
+		t.Errorf("Expected 6 requests/min, got %f", tp.RequestsPerMinute)
+	}
+
+	// One more bucket rotates the first one out of the window and reuses its slot
+	now = now.Add(10 * time.Second)
+	tracker.record("session-1", "org-1", now, 120, 0)
+
+	tp, _ = tracker.rate(tracker.sessions["session-1"])
+	if tp.TokensPerSecond != 3 {
+		t.Errorf("Expected 3 tokens/sec after rotation, got %f", tp.TokensPerSecond)
+	}
+	if tp.RequestsPerMinute != 0 {
+		t.Errorf("Expected 0 requests/min after rotation, got %f", tp.RequestsPerMinute)
+	}
+}
+
+func TestThroughputTrackerIgnoresStaleAndPrunes(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tracker := newThroughputTracker()
+	tracker.now = func() time.Time { return now }
+
+	tracker.record("stale", "org-1", now.Add(-2*time.Minute), 1000, 1)
+	if _, exists := tracker.sessions["stale"]; exists {
+		t.Error("Expected activity outside the window to be ignored")
+	}
+
+	tracker.record("session-1", "org-1", now, 100, 1)
+	now = now.Add(2 * time.Minute)
+	tracker.prune()
+
+	if len(tracker.sessions) != 0 {
+		t.Errorf("Expected inactive sessions to be pruned, got %d", len(tracker.sessions))
+	}
+}
+
+func TestEngineThroughput(t *testing.T) {
+	dbPath := "./test_engine_throughput.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+	now := time.Unix(1700000000, 0)
+	engine.throughput.now = func() time.Time { return now }
+
+	for _, sessionID := range []string{"session-a", "session-b"} {
+		engine.ProcessMetric(&MetricRecord{
+			Timestamp:      now,
+			SessionID:      sessionID,
+			OrganizationID: "org-1",
+			MetricName:     "claude_code.token.usage",
+			MetricValue:    int64(300),
+			Attributes:     map[string]string{"type": "input"},
+		})
+		engine.ProcessLog(&LogRecord{
+			Timestamp:      now,
+			SessionID:      sessionID,
+			OrganizationID: "org-1",
+			Body:           "claude_code.api_request",
+		})
+	}
+
+	tp, active := engine.SessionThroughput("session-a")
+	if !active {
+		t.Fatal("Expected session-a to be active")
+	}
+	if tp.TokensPerSecond != 5 || tp.RequestsPerMinute != 1 {
+		t.Errorf("Unexpected session throughput: %+v", tp)
+	}
+
+	if got := len(engine.ActiveSessions()); got != 2 {
+		t.Errorf("Expected 2 active sessions, got %d", got)
+	}
+
+	org := engine.OrgThroughput()["org-1"]
+	if org.TokensPerSecond != 10 || org.RequestsPerMinute != 2 {
+		t.Errorf("Unexpected org throughput: %+v", org)
+	}
+}
@@ -0,0 +1,303 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// rollupInterval is the granularity usage is kept at over time. Time
+	// series buckets are whole multiples of it.
+	rollupInterval = time.Minute
+
+	// maxTimeseriesBuckets caps the buckets a single time series returns
+	maxTimeseriesBuckets = 1000
+
+	defaultTimeseriesBucket = time.Hour
+	defaultTimeseriesRange  = 24 * time.Hour
+)
+
+// timeseriesMetrics maps each metric a time series can chart to the
+// session_rollups expression it sums. Tokens count input, output and cache
+// reads, as the reported token totals do.
+var timeseriesMetrics = map[string]string{
+	"cost":         "cost_usd",
+	"tokens":       "input_tokens + output_tokens + cache_read_tokens",
+	"api_requests": "api_request_count",
+	"tool_calls":   "tool_call_count",
+}
+
+// dailyUsageMetrics maps the metrics imported daily usage also records to
+// the daily_usage expression they sum. A day's usage counts toward the bucket
+// holding its start, midnight UTC, which is exact for buckets of whole days.
+var dailyUsageMetrics = map[string]string{
+	"cost":   "cost_usd",
+	"tokens": "input_tokens + output_tokens + cache_read_tokens",
+}
+
+// TimeseriesQuery selects the usage a time series charts. Buckets start at
+// multiples of Bucket since the Unix epoch, covering From up to To.
+type TimeseriesQuery struct {
+	Metric         string
+	Bucket         time.Duration
+	From           time.Time
+	To             time.Time
+	UserID         string
+	OrganizationID string
+}
+
+// start returns the start of the query's first bucket, in Unix seconds
+func (q TimeseriesQuery) start() int64 {
+	bucket := int64(q.Bucket.Seconds())
+	return q.From.Unix() - q.From.Unix()%bucket
+}
+
+// buckets returns the number of buckets the query's range spans
+func (q TimeseriesQuery) buckets() int64 {
+	bucket := int64(q.Bucket.Seconds())
+	return (q.To.Unix() - q.start() + bucket - 1) / bucket
+}
+
+// rollupStart returns the start of the rollup interval timestamp falls in,
+// in Unix seconds
+func rollupStart(timestamp time.Time) int64 {
+	return timestamp.Truncate(rollupInterval).Unix()
+}
+
+// sessionRollup gets or creates the rollup of a session's usage in the
+// interval timestamp falls in. Callers must hold cacheMutex.
+func (e *Engine) sessionRollup(session *Session, timestamp time.Time) *SessionRollup {
+	start := rollupStart(timestamp)
+	rollups, exists := e.sessionRollupsCache[session.SessionID]
+	if !exists {
+		rollups = make(map[int64]*SessionRollup)
+		e.sessionRollupsCache[session.SessionID] = rollups
+	}
+
+	rollup, exists := rollups[start]
+	if !exists {
+		rollup = &SessionRollup{
+			SessionID:      session.SessionID,
+			OrganizationID: session.OrganizationID,
+			UserID:         session.UserID,
+			BucketStart:    time.Unix(start, 0),
+		}
+		rollups[start] = rollup
+	}
+	return rollup
+}
+
+// UpsertSessionRollups saves a session's usage over time
+func (s *Store) UpsertSessionRollups(sessionID string, rollups []*SessionRollup) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert := `
+	INSERT INTO session_rollups (
+		session_id, bucket_start, organization_id, user_id, cost_usd,
+		input_tokens, output_tokens, cache_read_tokens, cache_creation_tokens,
+		api_request_count, tool_call_count
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(session_id, bucket_start) DO UPDATE SET
+		cost_usd = excluded.cost_usd,
+		input_tokens = excluded.input_tokens,
+		output_tokens = excluded.output_tokens,
+		cache_read_tokens = excluded.cache_read_tokens,
+		cache_creation_tokens = excluded.cache_creation_tokens,
+		api_request_count = excluded.api_request_count,
+		tool_call_count = excluded.tool_call_count
+	`
+	stmt, err := tx.Prepare(upsert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rollups {
+		if _, err := s.execStmt(tx, stmt, queryUpsertSessionRollups, upsert,
+			sessionID, r.BucketStart.Unix(), r.OrganizationID, r.UserID, r.CostUSD,
+			r.InputTokens, r.OutputTokens, r.CacheReadTokens, r.CacheCreationTokens,
+			r.APIRequestCount, r.ToolCallCount); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetTimeseries sums a metric over each bucket of the query's range, oldest
+// first. Buckets with no usage are included with a zero value. Cost and tokens
+// include daily usage imported from before otis, such as Anthropic Console
+// exports.
+func (s *Store) GetTimeseries(q TimeseriesQuery) ([]*TimeseriesPoint, error) {
+	expr, ok := timeseriesMetrics[q.Metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric %q", q.Metric)
+	}
+	bucket := int64(q.Bucket.Seconds())
+	from := q.start()
+
+	var filter string
+	var filterArgs []interface{}
+	if q.UserID != "" {
+		filter += ` AND user_id = ?`
+		filterArgs = append(filterArgs, q.UserID)
+	}
+	if q.OrganizationID != "" {
+		filter += ` AND organization_id = ?`
+		filterArgs = append(filterArgs, q.OrganizationID)
+	}
+
+	query := `
+	SELECT (bucket_start / ?) * ? AS bucket, ` + expr + ` AS value
+	FROM session_rollups
+	WHERE bucket_start >= ? AND bucket_start < ?` + filter
+	args := append([]interface{}{bucket, bucket, from, q.To.Unix()}, filterArgs...)
+	if dailyExpr, ok := dailyUsageMetrics[q.Metric]; ok {
+		query += `
+	UNION ALL
+	SELECT (CAST(strftime('%s', day) AS INTEGER) / ?) * ?, ` + dailyExpr + `
+	FROM daily_usage
+	WHERE CAST(strftime('%s', day) AS INTEGER) >= ? AND CAST(strftime('%s', day) AS INTEGER) < ?` + filter
+		args = append(append(args, bucket, bucket, from, q.To.Unix()), filterArgs...)
+	}
+	query = `
+	SELECT bucket, SUM(value) FROM (` + query + `
+	)
+	GROUP BY bucket`
+
+	rows, err := s.query(queryGetTimeseries, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[int64]float64)
+	for rows.Next() {
+		var start int64
+		var value float64
+		if err := rows.Scan(&start, &value); err != nil {
+			return nil, err
+		}
+		values[start] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var points []*TimeseriesPoint
+	for start := from; start < q.To.Unix(); start += bucket {
+		points = append(points, &TimeseriesPoint{BucketStart: time.Unix(start, 0), Value: values[start]})
+	}
+	return points, nil
+}
+
+// parseTimeseriesBucket parses a bucket size such as 5m, 1h or 1d, which must
+// be a whole number of rollup intervals
+func parseTimeseriesBucket(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultTimeseriesBucket, nil
+	}
+
+	var bucket time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid bucket %q", value)
+		}
+		bucket = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if bucket, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("invalid bucket %q", value)
+		}
+	}
+
+	if bucket < rollupInterval || bucket%rollupInterval != 0 {
+		return 0, fmt.Errorf("bucket must be a whole number of minutes, got %q", value)
+	}
+	return bucket, nil
+}
+
+// parseTimeseriesQuery reads a time series query from the request's
+// parameters. The range defaults to the last day, ending at now.
+func parseTimeseriesQuery(r *http.Request, now time.Time) (TimeseriesQuery, error) {
+	params := r.URL.Query()
+	q := TimeseriesQuery{
+		Metric:         params.Get("metric"),
+		To:             now,
+		UserID:         params.Get("user_id"),
+		OrganizationID: params.Get("org_id"),
+	}
+	if _, ok := timeseriesMetrics[q.Metric]; !ok {
+		return q, fmt.Errorf("unknown metric %q (use cost, tokens, api_requests or tool_calls)", q.Metric)
+	}
+
+	var err error
+	if q.Bucket, err = parseTimeseriesBucket(params.Get("bucket")); err != nil {
+		return q, err
+	}
+	if to := params.Get("to"); to != "" {
+		if q.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return q, fmt.Errorf("invalid to: %v", err)
+		}
+	}
+	q.From = q.To.Add(-defaultTimeseriesRange)
+	if from := params.Get("from"); from != "" {
+		if q.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return q, fmt.Errorf("invalid from: %v", err)
+		}
+	}
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must be before to")
+	}
+
+	if buckets := q.buckets(); buckets > maxTimeseriesBuckets {
+		return q, fmt.Errorf("range spans %d buckets, more than the %d allowed; use a larger bucket or a shorter range", buckets, maxTimeseriesBuckets)
+	}
+	return q, nil
+}
+
+// handleTimeseries handles GET /api/stats/timeseries?metric=cost&bucket=1h&from=X&to=Y&user_id=Z&org_id=W
+func (s *APIServer) handleTimeseries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := parseTimeseriesQuery(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	points, err := s.store.GetTimeseries(q)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving time series: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	series := make([]map[string]interface{}, len(points))
+	for i, p := range points {
+		series[i] = map[string]interface{}{
+			"bucket_start": p.BucketStart.UTC().Format(time.RFC3339),
+			"value":        p.Value,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"metric":         q.Metric,
+		"bucket_seconds": int64(q.Bucket.Seconds()),
+		"from":           q.From.UTC().Format(time.RFC3339),
+		"to":             q.To.UTC().Format(time.RFC3339),
+		"series":         series,
+	})
+}
@@ -0,0 +1,155 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// getTimeseries requests a time series and returns its bucket values
+func getTimeseries(t *testing.T, server *APIServer, query string) []float64 {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	server.handleTimeseries(rec, httptest.NewRequest(http.MethodGet, "/api/stats/timeseries?"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var body struct {
+		Series []struct {
+			BucketStart string  `json:"bucket_start"`
+			Value       float64 `json:"value"`
+		} `json:"series"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	values := make([]float64, len(body.Series))
+	for i, point := range body.Series {
+		values[i] = point.Value
+	}
+	return values
+}
+
+func TestTimeseriesBucketsUsage(t *testing.T) {
+	server := newTestAPIServer(t, "./test_timeseries.db")
+	engine := newEngine(server.store)
+
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	for _, r := range []struct {
+		offset time.Duration
+		userID string
+		cost   float64
+		tokens int64
+	}{
+		{10 * time.Minute, "user-1", 1.5, 100},
+		{50 * time.Minute, "user-1", 0.5, 200},
+		{2*time.Hour + 5*time.Minute, "user-1", 2, 400},
+		{20 * time.Minute, "user-2", 7, 800},
+	} {
+		sessionID := "session-" + r.userID
+		engine.ProcessMetric(&MetricRecord{
+			Timestamp: start.Add(r.offset), SessionID: sessionID, UserID: r.userID, OrganizationID: "org-1",
+			MetricName: "claude_code.cost.usage", MetricValue: r.cost,
+		})
+		engine.ProcessMetric(&MetricRecord{
+			Timestamp: start.Add(r.offset), SessionID: sessionID, UserID: r.userID, OrganizationID: "org-1",
+			MetricName: "claude_code.token.usage", MetricValue: r.tokens, Attributes: map[string]string{"type": "input"},
+		})
+		engine.ProcessLog(&LogRecord{
+			Timestamp: start.Add(r.offset), SessionID: sessionID, UserID: r.userID, OrganizationID: "org-1",
+			Body: "claude_code.api_request",
+		})
+	}
+	engine.FlushCache()
+
+	window := "&from=2025-06-01T10:00:00Z&to=2025-06-01T13:00:00Z"
+	tests := []struct {
+		query    string
+		expected []float64
+	}{
+		{"metric=cost&bucket=1h&user_id=user-1" + window, []float64{2, 0, 2}},
+		{"metric=cost&bucket=1h" + window, []float64{9, 0, 2}},
+		{"metric=tokens&bucket=30m&user_id=user-1" + window, []float64{100, 200, 0, 0, 400, 0}},
+		{"metric=api_requests&bucket=1d&org_id=org-1" + window, []float64{4}},
+		{"metric=tool_calls&bucket=1h" + window, []float64{0, 0, 0}},
+		{"metric=cost&bucket=1h&org_id=org-2" + window, []float64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		values := getTimeseries(t, server, tt.query)
+		if len(values) != len(tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.query, tt.expected, values)
+			continue
+		}
+		for i := range values {
+			if math.Abs(values[i]-tt.expected[i]) > 1e-9 {
+				t.Errorf("%s: expected %v, got %v", tt.query, tt.expected, values)
+				break
+			}
+		}
+	}
+}
+
+func TestTimeseriesReportedCostReplacesEstimate(t *testing.T) {
+	server := newTestAPIServer(t, "./test_timeseries_estimate.db")
+	engine := newEngine(server.store)
+	engine.SetPricing(Pricing{"claude-sonnet-4": {InputPerMTok: 3}})
+
+	// Estimated at 10:00, then replaced by the cost reported at 11:30
+	engine.ProcessMetric(tokenUsage("estimated", "claude-sonnet-4", "input", 1_000_000))
+	engine.ProcessMetric(&MetricRecord{
+		Timestamp:   time.Date(2025, 6, 1, 11, 30, 0, 0, time.UTC),
+		SessionID:   "estimated",
+		UserID:      "user-1",
+		MetricName:  "claude_code.cost.usage",
+		MetricValue: 2.5,
+		Attributes:  map[string]string{"model": "claude-sonnet-4"},
+	})
+	engine.FlushCache()
+
+	values := getTimeseries(t, server, "metric=cost&bucket=1h&from=2025-06-01T10:00:00Z&to=2025-06-01T12:00:00Z")
+	if len(values) != 2 || values[0] != 0 || values[1] != 2.5 {
+		t.Errorf("Expected the estimate replaced by the reported cost, got %v", values)
+	}
+}
+
+func TestParseTimeseriesQuery(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	parse := func(query string) (TimeseriesQuery, error) {
+		return parseTimeseriesQuery(httptest.NewRequest(http.MethodGet, "/api/stats/timeseries?"+query, nil), now)
+	}
+
+	q, err := parse("metric=tokens")
+	if err != nil {
+		t.Fatalf("Expected defaults to parse, got %v", err)
+	}
+	if q.Bucket != time.Hour || !q.To.Equal(now) || !q.From.Equal(now.Add(-24*time.Hour)) || q.buckets() != 24 {
+		t.Errorf("Expected the last day in hourly buckets, got %+v", q)
+	}
+
+	q, err = parse("metric=cost&bucket=1d&from=2025-05-01T06:00:00Z")
+	if err != nil {
+		t.Fatalf("Expected a day bucket to parse, got %v", err)
+	}
+	if q.Bucket != 24*time.Hour || time.Unix(q.start(), 0).UTC() != time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("Expected day buckets starting at midnight UTC, got %+v", q)
+	}
+
+	for _, query := range []string{
+		"",
+		"metric=latency",
+		"metric=cost&bucket=30s",
+		"metric=cost&bucket=90s",
+		"metric=cost&bucket=xd",
+		"metric=cost&from=2025-06-01T12:00:00Z",
+		"metric=cost&bucket=1m&from=2025-05-01T00:00:00Z",
+	} {
+		if _, err := parse(query); err == nil {
+			t.Errorf("Expected %q to be rejected", query)
+		}
+	}
+}
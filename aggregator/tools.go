@@ -0,0 +1,162 @@
+package aggregator
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// knownTools maps lowercased Claude Code tool names to their canonical spelling
+var knownTools = map[string]string{
+	"bash":         "Bash",
+	"bashoutput":   "BashOutput",
+	"edit":         "Edit",
+	"exitplanmode": "ExitPlanMode",
+	"glob":         "Glob",
+	"grep":         "Grep",
+	"killshell":    "KillShell",
+	"ls":           "LS",
+	"multiedit":    "MultiEdit",
+	"notebookedit": "NotebookEdit",
+	"notebookread": "NotebookRead",
+	"read":         "Read",
+	"slashcommand": "SlashCommand",
+	"task":         "Task",
+	"todowrite":    "TodoWrite",
+	"webfetch":     "WebFetch",
+	"websearch":    "WebSearch",
+	"write":        "Write",
+}
+
+// normalizeToolName trims stray whitespace and restores the canonical case of
+// known tools. Unknown tools, such as MCP tools, keep their reported case.
+func normalizeToolName(name string) string {
+	name = strings.TrimSpace(name)
+	if canonical, ok := knownTools[strings.ToLower(name)]; ok {
+		return canonical
+	}
+	return name
+}
+
+// ToolMergeResult summarizes a RepairToolNames run
+type ToolMergeResult struct {
+	SessionToolsMerged int // session_tools rows renamed or folded into their normalized name
+}
+
+// RepairToolNames merges tool rows that were recorded under variants of the
+// same name, summing their counters under the normalized name.
+func (s *Store) RepairToolNames() (*ToolMergeResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &ToolMergeResult{}
+	if result.SessionToolsMerged, err = s.mergeSessionTools(tx); err != nil {
+		return nil, fmt.Errorf("failed to merge session_tools: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// toolKey identifies a tool row by session and normalized name
+type toolKey struct {
+	sessionID string
+	toolName  string
+}
+
+// mergeSessionTools folds session_tools rows sharing a normalized name
+func (s *Store) mergeSessionTools(tx *sql.Tx) (int, error) {
+	rows, err := s.queryOn(tx, queryRepairToolNames, `
+	SELECT session_id, tool_name, call_count, success_count, failure_count,
+		total_execution_time_ms, min_execution_time_ms, max_execution_time_ms,
+		auto_approved_count, user_approved_count, rejected_count, total_result_size_bytes
+	FROM session_tools
+	ORDER BY session_id, tool_name
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	merged := make(map[toolKey]*SessionTool)
+	var order []toolKey
+	var stale []toolKey
+	for rows.Next() {
+		var tool SessionTool
+		if err := rows.Scan(
+			&tool.SessionID, &tool.ToolName, &tool.CallCount,
+			&tool.SuccessCount, &tool.FailureCount, &tool.TotalExecutionTimeMS,
+			&tool.MinExecutionTimeMS, &tool.MaxExecutionTimeMS,
+			&tool.AutoApprovedCount, &tool.UserApprovedCount,
+			&tool.RejectedCount, &tool.TotalResultSizeBytes,
+		); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		key := toolKey{tool.SessionID, normalizeToolName(tool.ToolName)}
+		if key.toolName != tool.ToolName {
+			stale = append(stale, toolKey{tool.SessionID, tool.ToolName})
+		}
+
+		target, ok := merged[key]
+		if !ok {
+			tool.ToolName = key.toolName
+			merged[key] = &tool
+			order = append(order, key)
+			continue
+		}
+		target.CallCount += tool.CallCount
+		target.SuccessCount += tool.SuccessCount
+		target.FailureCount += tool.FailureCount
+		target.TotalExecutionTimeMS += tool.TotalExecutionTimeMS
+		if tool.MinExecutionTimeMS > 0 && (target.MinExecutionTimeMS == 0 || tool.MinExecutionTimeMS < target.MinExecutionTimeMS) {
+			target.MinExecutionTimeMS = tool.MinExecutionTimeMS
+		}
+		if tool.MaxExecutionTimeMS > target.MaxExecutionTimeMS {
+			target.MaxExecutionTimeMS = tool.MaxExecutionTimeMS
+		}
+		target.AutoApprovedCount += tool.AutoApprovedCount
+		target.UserApprovedCount += tool.UserApprovedCount
+		target.RejectedCount += tool.RejectedCount
+		target.TotalResultSizeBytes += tool.TotalResultSizeBytes
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	for _, key := range stale {
+		if _, err := s.execOn(tx, queryRepairToolNames, `DELETE FROM session_tools WHERE session_id = ? AND tool_name = ?`, key.sessionID, key.toolName); err != nil {
+			return 0, err
+		}
+	}
+	for _, key := range order {
+		tool := merged[key]
+		if _, err := s.execOn(tx, queryRepairToolNames, `
+		INSERT OR REPLACE INTO session_tools (
+			session_id, tool_name, call_count, success_count, failure_count,
+			total_execution_time_ms, min_execution_time_ms, max_execution_time_ms,
+			auto_approved_count, user_approved_count, rejected_count, total_result_size_bytes
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			tool.SessionID, tool.ToolName, tool.CallCount,
+			tool.SuccessCount, tool.FailureCount, tool.TotalExecutionTimeMS,
+			tool.MinExecutionTimeMS, tool.MaxExecutionTimeMS,
+			tool.AutoApprovedCount, tool.UserApprovedCount,
+			tool.RejectedCount, tool.TotalResultSizeBytes,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(stale), nil
+}
@@ -0,0 +1,147 @@
+package aggregator
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNormalizeToolName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Bash", "Bash"},
+		{"bash", "Bash"},
+		{" BASH\t", "Bash"},
+		{"WebSearch ", "WebSearch"},
+		{"websearch", "WebSearch"},
+		{"mcp__github__create_issue", "mcp__github__create_issue"},
+		{" mcp__Linear__Search ", "mcp__Linear__Search"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeToolName(tt.input); got != tt.expected {
+			t.Errorf("normalizeToolName(%q) = %q, expected %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func toolResultEvent(sessionID, toolName string, durationMS float64) *LogRecord {
+	return &LogRecord{
+		Timestamp: time.Now(),
+		SessionID: sessionID,
+		UserID:    "user-1",
+		Body:      "claude_code.tool_result",
+		Attributes: map[string]interface{}{
+			"success":     true,
+			"tool_name":   toolName,
+			"duration_ms": durationMS,
+		},
+	}
+}
+
+func TestEngineMergesToolNameVariants(t *testing.T) {
+	dbPath := "./test_tool_variants.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+
+	// The same variants in two orders must land on the same canonical row
+	orders := map[string][]string{
+		"session-lower-first": {"bash", "Bash", " Bash ", "WebSearch ", "websearch"},
+		"session-upper-first": {"WebSearch", "Bash ", "websearch ", "BASH", "bash"},
+	}
+	for sessionID, names := range orders {
+		for i, name := range names {
+			engine.ProcessLog(toolResultEvent(sessionID, name, float64(10*(i+1))))
+		}
+	}
+	engine.FlushCache()
+
+	for sessionID := range orders {
+		tools, err := store.GetSessionTools(sessionID)
+		if err != nil {
+			t.Fatalf("Failed to get session tools: %v", err)
+		}
+		calls := make(map[string]int)
+		for _, tool := range tools {
+			calls[tool.ToolName] = tool.CallCount
+		}
+		if len(calls) != 2 || calls["Bash"] != 3 || calls["WebSearch"] != 2 {
+			t.Errorf("%s: expected Bash=3 and WebSearch=2, got %v", sessionID, calls)
+		}
+	}
+}
+
+func TestRepairToolNames(t *testing.T) {
+	dbPath := "./test_repair_tools.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	sessionID := "fragmented-session"
+	if err := store.UpsertSession(&Session{SessionID: sessionID, OrganizationID: "org-1", UserID: "user-1", StartTime: time.Now()}); err != nil {
+		t.Fatalf("Failed to seed session: %v", err)
+	}
+
+	// Rows written before names were normalized
+	for _, tool := range []*SessionTool{
+		{SessionID: sessionID, ToolName: "bash", CallCount: 2, SuccessCount: 2, TotalExecutionTimeMS: 30, MinExecutionTimeMS: 10, MaxExecutionTimeMS: 20, AutoApprovedCount: 1},
+		{SessionID: sessionID, ToolName: "Bash", CallCount: 1, FailureCount: 1, TotalExecutionTimeMS: 20, MinExecutionTimeMS: 20, MaxExecutionTimeMS: 20, RejectedCount: 1},
+		{SessionID: sessionID, ToolName: "WebSearch ", CallCount: 4, SuccessCount: 4, TotalResultSizeBytes: 100},
+		{SessionID: sessionID, ToolName: "mcp__custom", CallCount: 1, SuccessCount: 1},
+	} {
+		if err := store.UpsertSessionTool(tool); err != nil {
+			t.Fatalf("Failed to seed session tool: %v", err)
+		}
+	}
+
+	result, err := store.RepairToolNames()
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	if result.SessionToolsMerged != 2 {
+		t.Errorf("Unexpected repair result: %+v", result)
+	}
+
+	tools, err := store.GetSessionTools(sessionID)
+	if err != nil {
+		t.Fatalf("Failed to get session tools: %v", err)
+	}
+	byName := make(map[string]*SessionTool)
+	for _, tool := range tools {
+		byName[tool.ToolName] = tool
+	}
+	if len(byName) != 3 {
+		t.Fatalf("Expected Bash, WebSearch and mcp__custom rows, got %d rows", len(tools))
+	}
+	bash := byName["Bash"]
+	if bash == nil || bash.CallCount != 3 || bash.SuccessCount != 2 || bash.FailureCount != 1 ||
+		bash.TotalExecutionTimeMS != 50 || bash.MinExecutionTimeMS != 10 || bash.MaxExecutionTimeMS != 20 ||
+		bash.AutoApprovedCount != 1 || bash.RejectedCount != 1 {
+		t.Errorf("Expected merged Bash counters, got %+v", bash)
+	}
+	if ws := byName["WebSearch"]; ws == nil || ws.CallCount != 4 || ws.TotalResultSizeBytes != 100 {
+		t.Errorf("Expected trimmed WebSearch row, got %+v", ws)
+	}
+
+	// A second run has nothing left to merge
+	result, err = store.RepairToolNames()
+	if err != nil {
+		t.Fatalf("Second repair failed: %v", err)
+	}
+	if result.SessionToolsMerged != 0 {
+		t.Errorf("Expected second repair to be a no-op, got %+v", result)
+	}
+}
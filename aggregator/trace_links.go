@@ -0,0 +1,192 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sizes in bytes of OTLP trace and span IDs
+const (
+	traceIDSize = 16
+	spanIDSize  = 8
+)
+
+// maxSessionTraces bounds the traces kept per session, dropping the oldest
+const maxSessionTraces = 100
+
+// normalizeOTLPID converts a trace or span ID of size bytes, encoded as hex or
+// base64, to lowercase hex. Invalid and all-zero IDs give "".
+func normalizeOTLPID(id string, size int) string {
+	if len(id) == 2*size {
+		if b, err := hex.DecodeString(id); err == nil {
+			return encodeOTLPID(b, size)
+		}
+	}
+	if b, err := base64.StdEncoding.DecodeString(id); err == nil {
+		return encodeOTLPID(b, size)
+	}
+	return ""
+}
+
+// encodeOTLPID hex-encodes a binary trace or span ID of size bytes. Invalid
+// and all-zero IDs give "".
+func encodeOTLPID(id []byte, size int) string {
+	if len(id) != size || bytes.Count(id, []byte{0}) == size {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// recordSessionTrace notes the trace a span belongs to, evicting the session's
+// oldest trace once it has maxSessionTraces. Callers must hold cacheMutex.
+func (e *Engine) recordSessionTrace(record *TraceRecord) {
+	traces, exists := e.sessionTracesCache[record.SessionID]
+	if !exists {
+		traces = make(map[string]*SessionTrace)
+		e.sessionTracesCache[record.SessionID] = traces
+	}
+
+	if trace, exists := traces[record.TraceID]; exists {
+		if record.Timestamp.Before(trace.FirstSeen) {
+			trace.FirstSeen = record.Timestamp
+		}
+		if record.Timestamp.After(trace.LastSeen) {
+			trace.LastSeen = record.Timestamp
+		}
+		return
+	}
+
+	if len(traces) >= maxSessionTraces {
+		var oldest *SessionTrace
+		for _, trace := range traces {
+			if oldest == nil || trace.FirstSeen.Before(oldest.FirstSeen) {
+				oldest = trace
+			}
+		}
+		delete(traces, oldest.TraceID)
+	}
+
+	traces[record.TraceID] = &SessionTrace{
+		SessionID: record.SessionID,
+		TraceID:   record.TraceID,
+		FirstSeen: record.Timestamp,
+		LastSeen:  record.Timestamp,
+	}
+}
+
+// SetTraceURLTemplate links session traces to an external tracing UI, such as
+// https://jaeger.example.com/trace/{traceId}, with {traceId} replaced by each
+// trace's hex ID. Without a template traces are listed without links.
+func (s *APIServer) SetTraceURLTemplate(template string) {
+	s.traceURLTemplate = template
+}
+
+// buildTraceList builds the JSON response for a list of session traces
+func (s *APIServer) buildTraceList(traces []*SessionTrace) []map[string]interface{} {
+	traceList := make([]map[string]interface{}, len(traces))
+	for i, trace := range traces {
+		traceList[i] = map[string]interface{}{
+			"trace_id":   trace.TraceID,
+			"first_seen": trace.FirstSeen.Format(time.RFC3339Nano),
+			"last_seen":  trace.LastSeen.Format(time.RFC3339Nano),
+		}
+		if s.traceURLTemplate != "" {
+			traceList[i]["url"] = strings.ReplaceAll(s.traceURLTemplate, "{traceId}", trace.TraceID)
+		}
+	}
+	return traceList
+}
+
+// tracesByTurn assigns each trace to the turn its first span started in. A
+// turn runs until the next one starts; traces before the first turn go to it.
+func tracesByTurn(turns []*SessionTurn, traces []*SessionTrace) [][]*SessionTrace {
+	byTurn := make([][]*SessionTrace, len(turns))
+	for _, trace := range traces {
+		i := sort.Search(len(turns), func(i int) bool {
+			return turns[i].StartTime.After(trace.FirstSeen)
+		}) - 1
+		if i < 0 {
+			i = 0
+		}
+		if i < len(turns) {
+			byTurn[i] = append(byTurn[i], trace)
+		}
+	}
+	return byTurn
+}
+
+// UpsertSessionTraces saves a session's traces, then drops all but its
+// maxSessionTraces most recent
+func (s *Store) UpsertSessionTraces(sessionID string, traces []*SessionTrace) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert := `
+	INSERT INTO session_traces (session_id, trace_id, first_seen, last_seen)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(session_id, trace_id) DO UPDATE SET
+		first_seen = MIN(first_seen, excluded.first_seen),
+		last_seen = MAX(last_seen, excluded.last_seen)
+	`
+	stmt, err := tx.Prepare(upsert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, trace := range traces {
+		if _, err := s.execStmt(tx, stmt, queryUpsertSessionTraces, upsert, sessionID, trace.TraceID, trace.FirstSeen.UnixNano(), trace.LastSeen.UnixNano()); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.execOn(tx, queryUpsertSessionTraces, `
+	DELETE FROM session_traces
+	WHERE session_id = ? AND trace_id NOT IN (
+		SELECT trace_id FROM session_traces WHERE session_id = ?
+		ORDER BY first_seen DESC LIMIT ?
+	)
+	`, sessionID, sessionID, maxSessionTraces)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSessionTraces retrieves the traces seen for a session, oldest first
+func (s *Store) GetSessionTraces(sessionID string) ([]*SessionTrace, error) {
+	query := `
+	SELECT session_id, trace_id, first_seen, last_seen
+	FROM session_traces
+	WHERE session_id = ?
+	ORDER BY first_seen ASC, trace_id ASC
+	`
+
+	rows, err := s.query(queryGetSessionTraces, query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var traces []*SessionTrace
+	for rows.Next() {
+		var trace SessionTrace
+		var firstSeen, lastSeen int64
+		if err := rows.Scan(&trace.SessionID, &trace.TraceID, &firstSeen, &lastSeen); err != nil {
+			return nil, err
+		}
+		trace.FirstSeen = time.Unix(0, firstSeen)
+		trace.LastSeen = time.Unix(0, lastSeen)
+		traces = append(traces, &trace)
+	}
+
+	return traces, rows.Err()
+}
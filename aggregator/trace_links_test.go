@@ -0,0 +1,144 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNormalizeOTLPID(t *testing.T) {
+	tests := []struct {
+		id   string
+		size int
+		want string
+	}{
+		{"5B8EFFF798038103D269B633813FC60C", traceIDSize, "5b8efff798038103d269b633813fc60c"},
+		{"W47/95gDgQPSabYzgT/GDA==", traceIDSize, "5b8efff798038103d269b633813fc60c"}, // protojson base64
+		{"eee19b7ec3c1b174", spanIDSize, "eee19b7ec3c1b174"},
+		{"7uGbfsPBsXQ=", spanIDSize, "eee19b7ec3c1b174"},
+		{"00000000000000000000000000000000", traceIDSize, ""},
+		{"eee19b7ec3c1b174", traceIDSize, ""},
+		{"not an id", traceIDSize, ""},
+		{"", traceIDSize, ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeOTLPID(tt.id, tt.size); got != tt.want {
+			t.Errorf("normalizeOTLPID(%q, %d) = %q, want %q", tt.id, tt.size, got, tt.want)
+		}
+	}
+}
+
+// traceLine builds a traces.jsonl line with one span of session-turns
+func traceLine(traceID, spanID string, offset time.Duration) string {
+	start := time.Unix(1700000000, 0).Add(offset)
+	return fmt.Sprintf(`{"receivedAt":%q,"data":{"resourceSpans":[{"resource":{"attributes":[{"key":"session.id","value":{"stringValue":"session-turns"}}]},`+
+		`"scopeSpans":[{"spans":[{"traceId":%q,"spanId":%q,"name":"claude_code.request","startTimeUnixNano":"%d","endTimeUnixNano":"%d"}]}]}]}}`,
+		start.Format(time.RFC3339Nano), traceID, spanID, start.UnixNano(), start.Add(time.Second).UnixNano())
+}
+
+func TestSessionTracesLinkedFromSessionAndTimeline(t *testing.T) {
+	server := newTestAPIServer(t, "./test_session_traces.db")
+	server.SetTraceURLTemplate("https://jaeger.example.com/trace/{traceId}")
+
+	for _, event := range []*LogRecord{
+		turnEvent("claude_code.user_prompt", 0, nil),
+		apiRequestEvent(2*time.Second, 100, 50, 0.01),
+		turnEvent("claude_code.user_prompt", 3*time.Second, nil),
+		turnEvent("claude_code.user_prompt", 5*time.Second, nil),
+		apiRequestEvent(7*time.Second, 40, 20, 0.005),
+	} {
+		server.engine.ProcessLog(event)
+	}
+
+	// The first trace arrives base64 encoded, as the collector writes it, in
+	// two spans; the second in OTLP/JSON hex
+	for _, line := range []string{
+		traceLine("W47/95gDgQPSabYzgT/GDA==", "7uGbfsPBsXQ=", time.Second),
+		traceLine("W47/95gDgQPSabYzgT/GDA==", "AAAAAAAAAAE=", 2*time.Second),
+		traceLine("0AF7651916CD43DD8448EB211C80319C", "b7ad6b7169203331", 6*time.Second),
+	} {
+		if err := decodeLine(SignalTraces, line, server.engine); err != nil {
+			t.Fatalf("Failed to decode trace line: %v", err)
+		}
+	}
+	server.engine.FlushCache()
+
+	rec := httptest.NewRecorder()
+	server.handleV2Session(rec, httptest.NewRequest(http.MethodGet, "/api/v2/sessions/session-turns", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var session map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&session)
+
+	traces := session["traces"].([]interface{})
+	if len(traces) != 2 {
+		t.Fatalf("Expected 2 distinct traces, got %v", traces)
+	}
+	first := traces[0].(map[string]interface{})
+	if first["trace_id"] != "5b8efff798038103d269b633813fc60c" ||
+		first["url"] != "https://jaeger.example.com/trace/5b8efff798038103d269b633813fc60c" {
+		t.Errorf("Unexpected first trace: %v", first)
+	}
+	if first["first_seen"] != time.Unix(1700000001, 0).Format(time.RFC3339Nano) ||
+		first["last_seen"] != time.Unix(1700000002, 0).Format(time.RFC3339Nano) {
+		t.Errorf("Expected the first trace to cover both its spans, got %v", first)
+	}
+	if id := traces[1].(map[string]interface{})["trace_id"]; id != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("Expected the hex trace ID lowercased, got %v", id)
+	}
+
+	rec = httptest.NewRecorder()
+	server.handleV2Session(rec, httptest.NewRequest(http.MethodGet, "/api/v2/sessions/session-turns/timeline", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var timeline struct {
+		Turns []struct {
+			Traces []map[string]interface{} `json:"traces"`
+		} `json:"turns"`
+	}
+	json.NewDecoder(rec.Body).Decode(&timeline)
+
+	want := [][]string{{"5b8efff798038103d269b633813fc60c"}, {}, {"0af7651916cd43dd8448eb211c80319c"}}
+	if len(timeline.Turns) != len(want) {
+		t.Fatalf("Expected %d turns, got %d", len(want), len(timeline.Turns))
+	}
+	for i, turn := range timeline.Turns {
+		var ids []string
+		for _, trace := range turn.Traces {
+			ids = append(ids, trace["trace_id"].(string))
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(want[i]) {
+			t.Errorf("Turn %d: expected traces %v, got %v", i, want[i], ids)
+		}
+	}
+}
+
+func TestSessionTracesBounded(t *testing.T) {
+	server := newTestAPIServer(t, "./test_session_traces_bounded.db")
+
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < maxSessionTraces+5; i++ {
+		server.engine.ProcessTrace(&TraceRecord{
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			SessionID: "session-bounded",
+			SpanName:  "claude_code.request",
+			TraceID:   fmt.Sprintf("%032x", i+1),
+		})
+	}
+	server.engine.FlushCache()
+
+	traces, err := server.store.GetSessionTraces("session-bounded")
+	if err != nil {
+		t.Fatalf("Failed to get session traces: %v", err)
+	}
+	if len(traces) != maxSessionTraces || traces[0].TraceID != fmt.Sprintf("%032x", 6) {
+		t.Errorf("Expected the %d most recent traces from the 6th, got %d starting at %s",
+			maxSessionTraces, len(traces), traces[0].TraceID)
+	}
+}
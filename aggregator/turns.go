@@ -0,0 +1,58 @@
+package aggregator
+
+import "time"
+
+// startTurn opens a new turn for a session at a user prompt. Callers must
+// hold cacheMutex.
+func (e *Engine) startTurn(session *Session, timestamp time.Time, prompted bool) *SessionTurn {
+	turns := e.sessionTurnsCache[session.SessionID]
+	turn := &SessionTurn{
+		SessionID: session.SessionID,
+		TurnIndex: len(turns),
+		StartTime: timestamp,
+		EndTime:   timestamp,
+		Prompted:  prompted,
+	}
+	e.sessionTurnsCache[session.SessionID] = append(turns, turn)
+
+	e.updateTurnTotals(session)
+	return turn
+}
+
+// recordTurnRequest adds an api_request to the session's open turn. A session
+// first seen mid-stream has no open turn, so one is started without a prompt.
+// Callers must hold cacheMutex.
+func (e *Engine) recordTurnRequest(session *Session, record *LogRecord) {
+	var turn *SessionTurn
+	if turns := e.sessionTurnsCache[session.SessionID]; len(turns) > 0 {
+		turn = turns[len(turns)-1]
+	} else {
+		turn = e.startTurn(session, record.Timestamp, false)
+	}
+
+	turn.RequestCount++
+	turn.InputTokens += extractInt(record.Attributes, "input_tokens")
+	turn.OutputTokens += extractInt(record.Attributes, "output_tokens")
+	turn.CostUSD += extractFloat(record.Attributes, "cost_usd")
+	if record.Timestamp.After(turn.EndTime) {
+		turn.EndTime = record.Timestamp
+	}
+
+	e.updateTurnTotals(session)
+}
+
+// updateTurnTotals refreshes the session's turn count and average tokens per turn
+func (e *Engine) updateTurnTotals(session *Session) {
+	turns := e.sessionTurnsCache[session.SessionID]
+
+	var tokens int64
+	for _, turn := range turns {
+		tokens += turn.InputTokens + turn.OutputTokens
+	}
+
+	session.TurnCount = len(turns)
+	session.AvgTokensPerTurn = 0
+	if len(turns) > 0 {
+		session.AvgTokensPerTurn = float64(tokens) / float64(len(turns))
+	}
+}
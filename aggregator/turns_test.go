@@ -0,0 +1,144 @@
+package aggregator
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// turnEvent builds a log record for a turn test sequence
+func turnEvent(body string, offset time.Duration, attrs map[string]interface{}) *LogRecord {
+	if attrs == nil {
+		attrs = map[string]interface{}{}
+	}
+	return &LogRecord{
+		Timestamp:      time.Unix(1700000000, 0).Add(offset),
+		SessionID:      "session-turns",
+		UserID:         "user-1",
+		OrganizationID: "org-1",
+		Body:           body,
+		Attributes:     attrs,
+	}
+}
+
+func apiRequestEvent(offset time.Duration, input, output int64, cost float64) *LogRecord {
+	return turnEvent("claude_code.api_request", offset, map[string]interface{}{
+		"input_tokens":  input,
+		"output_tokens": output,
+		"cost_usd":      cost,
+	})
+}
+
+func TestEngineTurnBoundaries(t *testing.T) {
+	dbPath := "./test_turns.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+
+	// prompt, 2 requests, prompt with no requests, prompt, 1 request
+	events := []*LogRecord{
+		turnEvent("claude_code.user_prompt", 0, nil),
+		apiRequestEvent(1*time.Second, 100, 50, 0.01),
+		apiRequestEvent(2*time.Second, 200, 50, 0.02),
+		turnEvent("claude_code.user_prompt", 3*time.Second, nil),
+		turnEvent("claude_code.tool_result", 4*time.Second, nil),
+		turnEvent("claude_code.user_prompt", 5*time.Second, nil),
+		apiRequestEvent(6*time.Second, 40, 20, 0.005),
+	}
+	for _, event := range events {
+		engine.ProcessLog(event)
+	}
+
+	turns := engine.sessionTurnsCache["session-turns"]
+	if len(turns) != 3 {
+		t.Fatalf("Expected 3 turns, got %d", len(turns))
+	}
+
+	expected := []struct {
+		requests int
+		tokens   int64
+		end      time.Duration
+	}{
+		{2, 400, 2 * time.Second},
+		{0, 0, 3 * time.Second},
+		{1, 60, 6 * time.Second},
+	}
+	for i, want := range expected {
+		turn := turns[i]
+		if turn.TurnIndex != i || !turn.Prompted {
+			t.Errorf("Turn %d: unexpected index %d or prompted %v", i, turn.TurnIndex, turn.Prompted)
+		}
+		if turn.RequestCount != want.requests {
+			t.Errorf("Turn %d: expected %d requests, got %d", i, want.requests, turn.RequestCount)
+		}
+		if got := turn.InputTokens + turn.OutputTokens; got != want.tokens {
+			t.Errorf("Turn %d: expected %d tokens, got %d", i, want.tokens, got)
+		}
+		if end := time.Unix(1700000000, 0).Add(want.end); !turn.EndTime.Equal(end) {
+			t.Errorf("Turn %d: expected end %v, got %v", i, end, turn.EndTime)
+		}
+	}
+
+	session := engine.sessionsCache["session-turns"]
+	if session.TurnCount != 3 {
+		t.Errorf("Expected turn count 3, got %d", session.TurnCount)
+	}
+	if session.AvgTokensPerTurn != 460.0/3 {
+		t.Errorf("Expected %f avg tokens per turn, got %f", 460.0/3, session.AvgTokensPerTurn)
+	}
+
+	// Turns and totals persist on flush
+	engine.FlushCache()
+
+	stored, err := store.GetSessionTurns("session-turns")
+	if err != nil {
+		t.Fatalf("Failed to get session turns: %v", err)
+	}
+	if len(stored) != 3 || stored[0].RequestCount != 2 || stored[0].CostUSD != 0.03 {
+		t.Errorf("Unexpected stored turns: %+v", stored)
+	}
+
+	storedSession, err := store.GetSession("session-turns")
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if storedSession.TurnCount != 3 || storedSession.AvgTokensPerTurn != session.AvgTokensPerTurn {
+		t.Errorf("Expected stored turn totals, got %d / %f", storedSession.TurnCount, storedSession.AvgTokensPerTurn)
+	}
+}
+
+func TestEngineTurnsSessionStartingMidStream(t *testing.T) {
+	dbPath := "./test_turns_midstream.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	engine := NewEngine(store)
+
+	// The prompt for the first requests was never observed
+	engine.ProcessLog(apiRequestEvent(0, 100, 10, 0.01))
+	engine.ProcessLog(apiRequestEvent(time.Second, 100, 10, 0.01))
+	engine.ProcessLog(turnEvent("claude_code.user_prompt", 2*time.Second, nil))
+	engine.ProcessLog(apiRequestEvent(3*time.Second, 50, 5, 0.01))
+
+	turns := engine.sessionTurnsCache["session-turns"]
+	if len(turns) != 2 {
+		t.Fatalf("Expected 2 turns, got %d", len(turns))
+	}
+	if turns[0].Prompted || turns[0].RequestCount != 2 {
+		t.Errorf("Expected an unprompted first turn with 2 requests, got %+v", turns[0])
+	}
+	if !turns[1].Prompted || turns[1].RequestCount != 1 {
+		t.Errorf("Expected a prompted second turn with 1 request, got %+v", turns[1])
+	}
+}
@@ -0,0 +1,90 @@
+package aggregator
+
+// UsageTotals is the cost and tokens of all stored sessions of an
+// organization, or of one of its users
+type UsageTotals struct {
+	OrganizationID      string
+	UserID              string // Empty when totalled per organization
+	CostUSD             float64
+	InputTokens         int64
+	OutputTokens        int64
+	CacheReadTokens     int64
+	CacheCreationTokens int64
+}
+
+// ToolCallTotals is how often a tool succeeded and failed across all stored
+// sessions
+type ToolCallTotals struct {
+	ToolName  string
+	Successes int64
+	Failures  int64
+}
+
+// GetUsageTotals totals cost and tokens over all stored sessions per
+// organization, or per organization and user when byUser is set
+func (s *Store) GetUsageTotals(byUser bool) ([]*UsageTotals, error) {
+	user := "''"
+	if byUser {
+		user = "user_id"
+	}
+	query := `
+	SELECT
+		organization_id,
+		` + user + `,
+		COALESCE(SUM(total_cost_usd), 0),
+		COALESCE(SUM(total_input_tokens), 0),
+		COALESCE(SUM(total_output_tokens), 0),
+		COALESCE(SUM(total_cache_read_tokens), 0),
+		COALESCE(SUM(total_cache_creation_tokens), 0)
+	FROM sessions
+	GROUP BY 1, 2
+	ORDER BY 1, 2
+	`
+
+	rows, err := s.query(queryGetUsageTotals, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []*UsageTotals
+	for rows.Next() {
+		var t UsageTotals
+		err := rows.Scan(
+			&t.OrganizationID, &t.UserID, &t.CostUSD,
+			&t.InputTokens, &t.OutputTokens, &t.CacheReadTokens, &t.CacheCreationTokens,
+		)
+		if err != nil {
+			return nil, err
+		}
+		totals = append(totals, &t)
+	}
+	return totals, rows.Err()
+}
+
+// GetToolCallTotals totals each tool's successes and failures over all
+// stored sessions, by tool name
+func (s *Store) GetToolCallTotals() ([]*ToolCallTotals, error) {
+	query := `
+	SELECT tool_name, COALESCE(SUM(success_count), 0), COALESCE(SUM(failure_count), 0)
+	FROM session_tools
+	GROUP BY tool_name
+	ORDER BY tool_name
+	`
+
+	rows, err := s.query(queryGetToolCallTotals, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []*ToolCallTotals
+	for rows.Next() {
+		var t ToolCallTotals
+		if err := rows.Scan(&t.ToolName, &t.Successes, &t.Failures); err != nil {
+			return nil, err
+		}
+		totals = append(totals, &t)
+	}
+	return totals, rows.Err()
+}
@@ -0,0 +1,415 @@
+package aggregator
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// verifiedFields are the sessions columns the checker recomputes, with the
+// matching value of a recomputed session
+var verifiedFields = []struct {
+	column string
+	value  func(*Session) float64
+}{
+	{"total_cost_usd", func(s *Session) float64 { return s.TotalCostUSD }},
+	{"total_input_tokens", func(s *Session) float64 { return float64(s.TotalInputTokens) }},
+	{"total_output_tokens", func(s *Session) float64 { return float64(s.TotalOutputTokens) }},
+	{"total_cache_read_tokens", func(s *Session) float64 { return float64(s.TotalCacheReadTokens) }},
+	{"total_cache_creation_tokens", func(s *Session) float64 { return float64(s.TotalCacheCreationTokens) }},
+	{"tool_call_count", func(s *Session) float64 { return float64(s.ToolCallCount) }},
+	{"api_request_count", func(s *Session) float64 { return float64(s.APIRequestCount) }},
+	{"api_error_count", func(s *Session) float64 { return float64(s.APIErrorCount) }},
+	{"user_prompt_count", func(s *Session) float64 { return float64(s.UserPromptCount) }},
+	{"total_api_latency_ms", func(s *Session) float64 { return s.TotalAPILatencyMS }},
+	{"turn_count", func(s *Session) float64 { return float64(s.TurnCount) }},
+}
+
+// Discrepancy is a sessions column whose stored value differs from the value
+// recomputed from the raw files
+type Discrepancy struct {
+	Field      string
+	Stored     float64
+	Recomputed float64
+}
+
+// VerifyResult compares one session's stored totals with totals recomputed
+// from the raw JSONL files
+type VerifyResult struct {
+	SessionID     string
+	Stored        bool // The session has a sessions row
+	Recomputed    bool // The raw files hold records of the session
+	Indexed       bool // Read through session_file_ranges instead of a full scan
+	Discrepancies []Discrepancy
+}
+
+// Consistent reports whether the stored and recomputed totals agree
+func (r *VerifyResult) Consistent() bool {
+	return r.Stored && r.Recomputed && len(r.Discrepancies) == 0
+}
+
+// Verifier cross-checks stored session totals against the raw JSONL files in
+// dataDir. Records are recomputed by a scratch Engine with the same
+// extraction code as live processing, leaving the live caches untouched.
+type Verifier struct {
+	store   *Store
+	dataDir string
+	files   InputFiles
+}
+
+// NewVerifier creates a verifier over the given files in the collector's
+// output directory
+func NewVerifier(store *Store, dataDir string, files InputFiles) *Verifier {
+	return &Verifier{store: store, dataDir: dataDir, files: files}
+}
+
+// sessionFilter passes on records of the wanted sessions only
+type sessionFilter struct {
+	h        recordHandler
+	sessions map[string]bool
+}
+
+func (f *sessionFilter) ProcessMetric(record *MetricRecord) {
+	if f.sessions[record.SessionID] {
+		f.h.ProcessMetric(record)
+	}
+}
+
+func (f *sessionFilter) ProcessLog(record *LogRecord) {
+	if f.sessions[record.SessionID] {
+		f.h.ProcessLog(record)
+	}
+}
+
+func (f *sessionFilter) ProcessTrace(record *TraceRecord) {
+	if f.sessions[record.SessionID] {
+		f.h.ProcessTrace(record)
+	}
+}
+
+// VerifySession checks one session, reading only its indexed ranges when
+// the session index covers it and every file otherwise
+func (v *Verifier) VerifySession(sessionID string) (*VerifyResult, error) {
+	ranges, err := v.store.GetSessionFileRanges(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session index: %w", err)
+	}
+
+	engine := newEngine(nil)
+	filter := &sessionFilter{h: engine, sessions: map[string]bool{sessionID: true}}
+
+	indexed := len(ranges) > 0
+	if indexed {
+		indexed, err = v.readRanges(ranges, filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !indexed {
+		// Fall back to a full scan when nothing is indexed, or an indexed
+		// generation has since been rotated away
+		engine = newEngine(nil)
+		filter.h = engine
+		if err := v.scanAll(filter); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := v.compare(sessionID, engine)
+	if err != nil {
+		return nil, err
+	}
+	result.Indexed = indexed
+	return result, nil
+}
+
+// VerifyWindow checks every stored session starting within window with a
+// single scan of the raw files
+func (v *Verifier) VerifyWindow(window TimeWindow) ([]*VerifyResult, error) {
+	sessionIDs, err := v.store.GetSessionIDsInWindow(window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+
+	engine := newEngine(nil)
+	filter := &sessionFilter{h: engine, sessions: make(map[string]bool)}
+	for _, sessionID := range sessionIDs {
+		filter.sessions[sessionID] = true
+	}
+	if err := v.scanAll(filter); err != nil {
+		return nil, err
+	}
+
+	results := make([]*VerifyResult, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		result, err := v.compare(sessionID, engine)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// compare diffs a session's stored row against the scratch engine's totals
+func (v *Verifier) compare(sessionID string, engine *Engine) (*VerifyResult, error) {
+	result := &VerifyResult{SessionID: sessionID}
+
+	recomputed, exists := engine.sessionsCache[sessionID]
+	if exists {
+		result.Recomputed = true
+	} else {
+		recomputed = &Session{SessionID: sessionID}
+	}
+
+	stored, err := v.store.getVerifiedTotals(sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session %s: %w", sessionID, err)
+	}
+	result.Stored = true
+
+	for i, field := range verifiedFields {
+		want := field.value(recomputed)
+		if math.Abs(stored[i]-want) > 1e-6*math.Max(1, math.Abs(want)) {
+			result.Discrepancies = append(result.Discrepancies, Discrepancy{
+				Field:      field.column,
+				Stored:     stored[i],
+				Recomputed: want,
+			})
+		}
+	}
+	return result, nil
+}
+
+// readRanges feeds the lines of each indexed range to h, reporting false if
+// any range's file generation is no longer on disk or no longer an input file
+func (v *Verifier) readRanges(ranges []*SessionFileRange, h recordHandler) (bool, error) {
+	for _, r := range ranges {
+		signal, ok := v.files.signal(r.FileName)
+		if !ok {
+			return false, nil
+		}
+		filePath := filepath.Join(v.dataDir, r.FileName)
+		candidates, err := filepath.Glob(filePath + ".*")
+		if err != nil {
+			return false, err
+		}
+
+		found := false
+		for _, candidate := range append([]string{filePath}, candidates...) {
+			reader, ok, err := openRotatedFile(candidate, r.Inode, r.StartOffset)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				continue
+			}
+			found = true
+			if reader == nil {
+				break
+			}
+			err = decodeLines(signal, io.LimitReader(reader, r.EndOffset-r.StartOffset), h)
+			reader.Close()
+			if err != nil {
+				return false, fmt.Errorf("error reading %s: %w", candidate, err)
+			}
+			break
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// scanAll feeds every line of each JSONL file to h, rotated generations
+// first from oldest to newest
+func (v *Verifier) scanAll(h recordHandler) error {
+	for _, filename := range v.files.paths(v.dataDir) {
+		signal, _ := v.files.signal(filename)
+		paths, err := generationsOldestFirst(filepath.Join(v.dataDir, filename))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if err := scanFile(path, signal, h); err != nil {
+				return fmt.Errorf("error reading %s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// generationsOldestFirst lists filePath's rotated generations <name>.N and
+// <name>.N.gz from the highest N down, followed by filePath itself
+func generationsOldestFirst(filePath string) ([]string, error) {
+	candidates, err := filepath.Glob(filePath + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	generation := make(map[string]int)
+	var paths []string
+	for _, candidate := range candidates {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(candidate, filePath+"."), ".gz")
+		if n, err := strconv.Atoi(suffix); err == nil {
+			generation[candidate] = n
+			paths = append(paths, candidate)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return generation[paths[i]] > generation[paths[j]] })
+
+	return append(paths, filePath), nil
+}
+
+// scanFile feeds every line of a plain or gzipped file to h; a missing file
+// has no lines
+func scanFile(path string, signal Signal, h recordHandler) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		reader = zr
+	}
+	return decodeLines(signal, reader, h)
+}
+
+// decodeLines decodes each non-empty line of r into h, skipping lines that
+// fail to decode as live processing does
+func decodeLines(signal Signal, r io.Reader, h recordHandler) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxRawLineBytes)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		decodeLine(signal, line, h)
+	}
+	return scanner.Err()
+}
+
+// getVerifiedTotals reads a session's verifiedFields columns
+func (s *Store) getVerifiedTotals(sessionID string) ([]float64, error) {
+	columns := make([]string, len(verifiedFields))
+	for i, field := range verifiedFields {
+		columns[i] = "COALESCE(" + field.column + ", 0)"
+	}
+	query := `SELECT ` + strings.Join(columns, ", ") + ` FROM sessions WHERE session_id = ?`
+
+	values := make([]float64, len(verifiedFields))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := s.queryRow(queryGetVerifiedTotals, query, sessionID).Scan(dest...); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// GetSessionIDsInWindow lists the sessions starting within a window
+func (s *Store) GetSessionIDsInWindow(window TimeWindow) ([]string, error) {
+	query := `
+	SELECT session_id FROM sessions
+	WHERE start_time >= ? AND start_time < ?
+	ORDER BY start_time, session_id
+	`
+
+	start, end := windowBounds(window)
+	rows, err := s.query(queryGetSessionIDsInWindow, query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessionIDs []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+
+	return sessionIDs, rows.Err()
+}
+
+// handleAdminVerify handles GET /api/admin/verify?session_id=X
+func (s *APIServer) handleAdminVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		http.Error(w, "session_id is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := NewVerifier(s.store, s.dataDir, s.inputFiles).VerifySession(sessionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error verifying session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !result.Stored && !result.Recomputed {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("API: Verified session %s, %d discrepancies", sessionID, len(result.Discrepancies))
+
+	discrepancies := make([]map[string]interface{}, 0, len(result.Discrepancies))
+	for _, d := range result.Discrepancies {
+		discrepancies = append(discrepancies, map[string]interface{}{
+			"field":      d.Field,
+			"stored":     d.Stored,
+			"recomputed": d.Recomputed,
+		})
+	}
+
+	response := map[string]interface{}{
+		"session_id":    result.SessionID,
+		"consistent":    result.Consistent(),
+		"stored":        result.Stored,
+		"recomputed":    result.Recomputed,
+		"indexed":       result.Indexed,
+		"discrepancies": discrepancies,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,159 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func verifyCostLine(sessionID string, at time.Time, cost float64) string {
+	return fmt.Sprintf(`{"receivedAt":%q,"data":{"resourceMetrics":[{"resource":{"attributes":[]},"scopeMetrics":[{"metrics":[`+
+		`{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"asDouble":%g,"timeUnixNano":"%d",`+
+		`"attributes":[{"key":"session.id","value":{"stringValue":%q}},{"key":"model","value":{"stringValue":"claude-sonnet-4-5"}}]}]}}]}]}]}}`,
+		at.Format(time.RFC3339Nano), cost, at.UnixNano(), sessionID)
+}
+
+func verifyLogLine(sessionID string, at time.Time, event string) string {
+	return fmt.Sprintf(`{"receivedAt":%q,"data":{"resourceLogs":[{"resource":{"attributes":[{"key":"session.id","value":{"stringValue":%q}}]},`+
+		`"scopeLogs":[{"logRecords":[{"timeUnixNano":"%d","body":{"stringValue":%q}}]}]}]}}`,
+		at.Format(time.RFC3339Nano), sessionID, at.UnixNano(), event)
+}
+
+func writeLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// processVerifyFixture writes two interleaved sessions to dataDir and
+// processes them with the session index enabled
+func processVerifyFixture(t *testing.T, server *APIServer, dataDir string) {
+	t.Helper()
+
+	base := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	writeLines(t, filepath.Join(dataDir, "metrics.jsonl"),
+		verifyCostLine("session-b", base, 1.0),
+		verifyCostLine("session-a", base, 0.5),
+		verifyCostLine("session-a", base.Add(time.Minute), 0.25),
+	)
+	writeLines(t, filepath.Join(dataDir, "logs.jsonl"),
+		verifyLogLine("session-a", base, "claude_code.user_prompt"),
+		verifyLogLine("session-b", base, "claude_code.api_request"),
+		verifyLogLine("session-a", base, "claude_code.api_request"),
+		verifyLogLine("session-a", base.Add(time.Minute), "claude_code.api_request"),
+	)
+
+	processor := NewProcessor(dataDir, DefaultInputFiles, server.store, server.engine, 60)
+	processor.SetSessionIndex(true)
+	processor.processAllFiles()
+	server.engine.FlushCache()
+}
+
+func TestVerifySessionCatchesCorruptedTotal(t *testing.T) {
+	server := newTestAPIServer(t, "./test_verify.db")
+	dataDir := t.TempDir()
+	processVerifyFixture(t, server, dataDir)
+
+	ranges, err := server.store.GetSessionFileRanges("session-a")
+	if err != nil || len(ranges) != 2 {
+		t.Fatalf("Expected session-a indexed in both files, got %d ranges (%v)", len(ranges), err)
+	}
+
+	verifier := NewVerifier(server.store, dataDir, DefaultInputFiles)
+	result, err := verifier.VerifySession("session-a")
+	if err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+	if !result.Consistent() || !result.Indexed {
+		t.Fatalf("Expected a consistent indexed result, got %+v", result)
+	}
+
+	if _, err := server.store.db.Exec(`UPDATE sessions SET total_cost_usd = 9.99, api_request_count = 7 WHERE session_id = ?`, "session-a"); err != nil {
+		t.Fatalf("Failed to corrupt session: %v", err)
+	}
+
+	result, err = verifier.VerifySession("session-a")
+	if err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+	expected := []Discrepancy{
+		{Field: "total_cost_usd", Stored: 9.99, Recomputed: 0.75},
+		{Field: "api_request_count", Stored: 7, Recomputed: 2},
+	}
+	if result.Consistent() || fmt.Sprint(result.Discrepancies) != fmt.Sprint(expected) {
+		t.Errorf("Expected discrepancies %v, got %v", expected, result.Discrepancies)
+	}
+
+	// A window check scans everything and flags only the corrupted session
+	results, err := verifier.VerifyWindow(TimeWindow{Type: "all-time"})
+	if err != nil {
+		t.Fatalf("Failed to verify window: %v", err)
+	}
+	inconsistent := map[string]bool{}
+	for _, r := range results {
+		inconsistent[r.SessionID] = !r.Consistent()
+	}
+	if len(results) != 2 || !inconsistent["session-a"] || inconsistent["session-b"] {
+		t.Errorf("Expected only session-a inconsistent, got %v", inconsistent)
+	}
+
+	// The admin endpoint reports the same discrepancies
+	server.SetRawAccess(dataDir, DefaultInputFiles, "admin-secret")
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/verify?session_id=session-a", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rec := httptest.NewRecorder()
+	server.handleAdminVerify(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if body["consistent"] != false || len(body["discrepancies"].([]interface{})) != 2 {
+		t.Errorf("Unexpected verify response: %v", body)
+	}
+}
+
+func TestVerifySessionFallsBackWhenLinesAreGone(t *testing.T) {
+	server := newTestAPIServer(t, "./test_verify_pruned.db")
+	dataDir := t.TempDir()
+	processVerifyFixture(t, server, dataDir)
+
+	// The indexed logs are truncated away before the verify, so the sessions
+	// that had lines there lose their index
+	logsPath := filepath.Join(dataDir, "logs.jsonl")
+	if err := os.Remove(logsPath); err != nil {
+		t.Fatalf("Failed to remove logs: %v", err)
+	}
+	writeLines(t, logsPath, verifyLogLine("session-c", time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC), "claude_code.api_request"))
+
+	processor := NewProcessor(dataDir, DefaultInputFiles, server.store, server.engine, 60)
+	processor.SetSessionIndex(true)
+	processor.processAllFiles()
+
+	ranges, err := server.store.GetSessionFileRanges("session-a")
+	if err != nil || len(ranges) != 0 {
+		t.Fatalf("Expected session-a's index to be pruned, got %d ranges (%v)", len(ranges), err)
+	}
+
+	result, err := NewVerifier(server.store, dataDir, DefaultInputFiles).VerifySession("session-a")
+	if err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+	if result.Indexed {
+		t.Errorf("Expected a full scan once the indexed lines are gone")
+	}
+	found := false
+	for _, d := range result.Discrepancies {
+		found = found || (d.Field == "api_request_count" && d.Stored == 2 && d.Recomputed == 0)
+	}
+	if !found {
+		t.Errorf("Expected the lost log lines to show as an api_request_count discrepancy, got %v", result.Discrepancies)
+	}
+}
@@ -0,0 +1,111 @@
+package aggregator
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Processor watch modes
+const (
+	WatchPoll   = "poll"
+	WatchNotify = "notify"
+)
+
+// watchFallbackInterval is the slowest the polling ticker runs in notify
+// mode, catching changes on filesystems that drop notifications
+const watchFallbackInterval = time.Minute
+
+// SetWatchMode chooses how Start notices new data: WatchPoll re-checks every
+// file each interval, WatchNotify processes a file as soon as the data
+// directory reports a write to it and keeps polling only as a slow fallback
+func (p *Processor) SetWatchMode(mode string) error {
+	if mode != WatchPoll && mode != WatchNotify {
+		return fmt.Errorf("unknown watch mode %q (use %s or %s)", mode, WatchPoll, WatchNotify)
+	}
+	p.watchMode = mode
+	return nil
+}
+
+// newWatcher watches the data directory, so files created by rotation are
+// covered along with the ones that exist now. Partitioned by organization,
+// it watches each organization's directory too.
+func (p *Processor) newWatcher() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(p.dataDir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	if p.files.ByOrg {
+		entries, err := os.ReadDir(p.dataDir)
+		if err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				p.watchOrgDir(watcher, filepath.Join(p.dataDir, entry.Name()))
+			}
+		}
+	}
+	return watcher, nil
+}
+
+// watchOrgDir adds an organization's directory to watcher. A directory that
+// can't be watched is still picked up by the fallback polling.
+func (p *Processor) watchOrgDir(watcher *fsnotify.Watcher, dir string) {
+	if !orgDirPattern.MatchString(filepath.Base(dir)) {
+		return
+	}
+	if err := watcher.Add(dir); err != nil {
+		log.Printf("Cannot watch %s: %v", dir, err)
+	}
+}
+
+// handleEvents processes the files named by event and by any further events
+// already queued, once each. Organization directories created meanwhile are
+// added to watcher, with any file already written to them processed.
+func (p *Processor) handleEvents(watcher *fsnotify.Watcher, event fsnotify.Event, events <-chan fsnotify.Event) {
+	changed := make(map[string]bool)
+	for {
+		if event.Has(fsnotify.Create) && p.files.ByOrg && filepath.Dir(event.Name) == filepath.Clean(p.dataDir) {
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+				p.watchOrgDir(watcher, event.Name)
+				for _, name := range p.files.names() {
+					changed[p.relativeName(filepath.Join(event.Name, name))] = true
+				}
+			}
+		}
+		if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+			filename := p.relativeName(event.Name)
+			if _, ok := p.files.signal(filename); ok {
+				changed[filename] = true
+			}
+		}
+
+		select {
+		case next, ok := <-events:
+			if ok {
+				event = next
+				continue
+			}
+		default:
+		}
+		break
+	}
+
+	for filename := range changed {
+		if p.directIngest {
+			p.skipFile(filename)
+		} else if err := p.ProcessFile(filepath.Join(p.dataDir, filename)); err != nil {
+			log.Printf("Error processing %s: %v", filename, err)
+		}
+	}
+}
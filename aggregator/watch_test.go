@@ -0,0 +1,85 @@
+package aggregator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// appendLine appends a JSONL line to path, creating the file if needed
+func appendLine(t *testing.T, path, line string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// waitForRequests waits for the engine to count want API requests in a session
+func waitForRequests(t *testing.T, engine *Engine, sessionID string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		engine.cacheMutex.RLock()
+		got := 0
+		if session, ok := engine.sessionsCache[sessionID]; ok {
+			got = session.APIRequestCount
+		}
+		engine.cacheMutex.RUnlock()
+
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d API requests for %s, got %d", want, sessionID, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProcessorNotifyModeProcessesWritesAndRotation(t *testing.T) {
+	dbPath := "./test_processor_notify.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	engine := NewEngine(store)
+	// The polling fallback never fires during the test
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 3600)
+	if err := processor.SetWatchMode(WatchNotify); err != nil {
+		t.Fatalf("Failed to set watch mode: %v", err)
+	}
+	processor.Start()
+	defer processor.Stop()
+
+	logsPath := filepath.Join(dataDir, "logs.jsonl")
+	at := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	appendLine(t, logsPath, verifyLogLine("notify-session", at, "claude_code.api_request"))
+	waitForRequests(t, engine, "notify-session", 1)
+
+	// A line written just before rotation is drained from the rotated file
+	// when the new file's creation is noticed
+	appendLine(t, logsPath, verifyLogLine("notify-session", at.Add(time.Second), "claude_code.api_request"))
+	if err := os.Rename(logsPath, logsPath+".1"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	appendLine(t, logsPath, verifyLogLine("notify-session", at.Add(2*time.Second), "claude_code.api_request"))
+	waitForRequests(t, engine, "notify-session", 3)
+}
+
+func TestProcessorRejectsUnknownWatchMode(t *testing.T) {
+	processor := NewProcessor(t.TempDir(), DefaultInputFiles, nil, nil, 5)
+	if err := processor.SetWatchMode("inotify"); err == nil {
+		t.Errorf("Expected an error for an unknown watch mode")
+	}
+}
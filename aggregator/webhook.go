@@ -0,0 +1,103 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookTimeout bounds each anomaly webhook delivery
+	webhookTimeout = 10 * time.Second
+
+	// webhookQueueSize bounds the anomalies waiting to be delivered
+	webhookQueueSize = 100
+)
+
+// webhookNotifier posts each newly flagged anomaly as JSON to a URL, such as
+// a chat or paging integration. Deliveries are made one at a time by their
+// own goroutine, so a slow or unreachable webhook never holds up the flush
+// that flagged them. Each is attempted once: a failure, or an anomaly
+// arriving to a full queue, is logged, and the anomaly stays recorded in the
+// anomalies table.
+type webhookNotifier struct {
+	url      string
+	client   *http.Client
+	queue    chan *Anomaly
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	n := &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan *Anomaly, webhookQueueSize),
+		stop:   make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// SetAnomalyWebhook posts every newly flagged anomaly to url; an empty url
+// disables the webhook
+func (e *Engine) SetAnomalyWebhook(url string) {
+	var n *webhookNotifier
+	if url != "" {
+		n = newWebhookNotifier(url)
+	}
+	e.webhook.Swap(n).close()
+}
+
+// notify queues an anomaly for delivery; a nil notifier does nothing
+func (n *webhookNotifier) notify(anomaly *Anomaly) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.queue <- anomaly:
+	default:
+		log.Printf("Anomaly webhook queue full at %d, not delivering %s anomaly for %q", cap(n.queue), anomaly.Kind, anomaly.Subject)
+	}
+}
+
+// close stops delivering; anomalies still queued are not delivered
+func (n *webhookNotifier) close() {
+	if n == nil {
+		return
+	}
+	n.stopOnce.Do(func() { close(n.stop) })
+}
+
+func (n *webhookNotifier) run() {
+	for {
+		select {
+		case anomaly := <-n.queue:
+			if err := n.post(anomaly); err != nil {
+				log.Printf("Failed to deliver %s anomaly webhook: %v", anomaly.Kind, err)
+			}
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+func (n *webhookNotifier) post(anomaly *Anomaly) error {
+	body, err := json.Marshal(anomaly)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
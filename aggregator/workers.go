@@ -0,0 +1,117 @@
+package aggregator
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DefaultProcessingWorkers is how many goroutines process the JSONL files by
+// default, both across files and when decoding the lines of one file
+const DefaultProcessingWorkers = 4
+
+// processBatchLines is how many lines of a file are read ahead and decoded
+// in parallel before being applied to the engine
+const processBatchLines = 256
+
+// SetWorkers sets how many files are processed at once, and how many
+// goroutines decode the lines of each. Records still reach the engine in file
+// order. Zero or less restores DefaultProcessingWorkers.
+func (p *Processor) SetWorkers(n int) {
+	if n <= 0 {
+		n = DefaultProcessingWorkers
+	}
+	p.workers = n
+}
+
+// pendingLine is a line read ahead of being applied to the engine, occupying
+// [start, end) of its file
+type pendingLine struct {
+	line       string
+	start, end int64
+	skip       bool // Empty or oversized, so only its position counts
+
+	records recordBuffer
+	err     error
+}
+
+// decode extracts the line's records into its buffer
+func (pl *pendingLine) decode(files InputFiles, filename string) {
+	signal, ok := files.signal(filename)
+	if !ok {
+		pl.err = fmt.Errorf("unknown file type: %s", filename)
+		return
+	}
+	pl.err = decodeLine(signal, pl.line, &pl.records)
+}
+
+// recordBuffer holds decoded records in the order they were decoded
+type recordBuffer struct {
+	records []interface{}
+}
+
+func (b *recordBuffer) ProcessMetric(record *MetricRecord) { b.records = append(b.records, record) }
+func (b *recordBuffer) ProcessLog(record *LogRecord)       { b.records = append(b.records, record) }
+func (b *recordBuffer) ProcessTrace(record *TraceRecord)   { b.records = append(b.records, record) }
+
+// replay hands the buffered records to h in order
+func (b *recordBuffer) replay(h recordHandler) {
+	for _, record := range b.records {
+		switch r := record.(type) {
+		case *MetricRecord:
+			h.ProcessMetric(r)
+		case *LogRecord:
+			h.ProcessLog(r)
+		case *TraceRecord:
+			h.ProcessTrace(r)
+		}
+	}
+}
+
+// decodeBatch decodes the lines of a file, splitting them into contiguous
+// runs across the processor's workers. Decoding is independent per line;
+// only applying the records to the engine has to happen in order.
+func (p *Processor) decodeBatch(filename string, batch []pendingLine) {
+	workers := min(p.workers, len(batch))
+	if workers <= 1 {
+		for i := range batch {
+			if !batch[i].skip {
+				batch[i].decode(p.files, filename)
+			}
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	size := (len(batch) + workers - 1) / workers
+	for start := 0; start < len(batch); start += size {
+		run := batch[start:min(start+size, len(batch))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range run {
+				if !run[i].skip {
+					run[i].decode(p.files, filename)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// applyLine hands a decoded line's records to the engine, adding the line
+// to index under every session it holds. A nil index applies it unindexed.
+func (p *Processor) applyLine(pl *pendingLine, index *sessionFileIndex) error {
+	if index == nil {
+		pl.records.replay(p.engine)
+		return pl.err
+	}
+
+	recorder := &sessionRecorder{h: p.engine, sessions: make(map[string]bool)}
+	pl.records.replay(recorder)
+	for sessionID := range recorder.sessions {
+		if sessionID != "" {
+			index.add(sessionID, pl.start, pl.end)
+		}
+	}
+	return pl.err
+}
@@ -0,0 +1,185 @@
+package aggregator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// workerLinesStart is when the first worker test line was recorded, recent
+// enough not to fall back to the wall clock
+var workerLinesStart = time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+// workerCostLine is a cost metric line for one of five sessions, timestamped
+// by its position so the order records reach the engine shows in the session
+func workerCostLine(i int) string {
+	return fmt.Sprintf(`{"resourceMetrics":[{"scopeMetrics":[{"metrics":[{"name":"claude_code.cost.usage","sum":{"dataPoints":[{"timeUnixNano":"%d","asDouble":%g,`+
+		`"attributes":[{"key":"session.id","value":{"stringValue":"session-%d"}},{"key":"model","value":{"stringValue":"model-%d"}}]}]}}]}]}]}`,
+		workerLinesStart.Add(time.Duration(i)*time.Second).UnixNano(), float64(i%7)*0.01+0.001, i%5, i%3)
+}
+
+func TestParallelDecodingMatchesSequential(t *testing.T) {
+	// Several batches, with blank and invalid lines among them
+	var content strings.Builder
+	for i := 0; i < 3*processBatchLines+17; i++ {
+		switch {
+		case i%101 == 0:
+			content.WriteString("\n")
+		case i%151 == 0:
+			content.WriteString("{not json\n")
+		default:
+			content.WriteString(workerCostLine(i) + "\n")
+		}
+	}
+
+	type result struct {
+		sessions map[string]string
+		state    string
+		ranges   []string
+	}
+	process := func(workers int) result {
+		dbPath := fmt.Sprintf("./test_parallel_decoding_%d.db", workers)
+		defer os.Remove(dbPath)
+		store, err := NewStore(dbPath)
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		defer store.Close()
+
+		dataDir := t.TempDir()
+		engine := NewEngine(store)
+		processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+		processor.SetWorkers(workers)
+		processor.SetSessionIndex(true)
+		appendToFile(t, filepath.Join(dataDir, "metrics.jsonl"), content.String())
+		if err := processor.ProcessFile(filepath.Join(dataDir, "metrics.jsonl")); err != nil {
+			t.Fatalf("Failed to process file: %v", err)
+		}
+
+		r := result{sessions: map[string]string{}}
+		for id, session := range engine.sessionsCache {
+			r.sessions[id] = fmt.Sprintf("cost=%.6f start=%d end=%d", session.TotalCostUSD, session.StartTime.Unix(), session.EndTime.Unix())
+		}
+		state, _ := store.GetProcessingState("metrics.jsonl")
+		r.state = fmt.Sprintf("line=%d offset=%d", state.LastProcessedLine, state.LastByteOffset)
+		for i := 0; i < 5; i++ {
+			fileRanges, err := store.GetSessionFileRanges(fmt.Sprintf("session-%d", i))
+			if err != nil {
+				t.Fatalf("Failed to get session ranges: %v", err)
+			}
+			for _, fr := range fileRanges {
+				r.ranges = append(r.ranges, fmt.Sprintf("%s %d-%d", fr.SessionID, fr.StartOffset, fr.EndOffset))
+			}
+		}
+		return r
+	}
+
+	sequential, parallel := process(1), process(8)
+	if len(sequential.sessions) != 5 {
+		t.Fatalf("Expected 5 sessions, got %v", sequential.sessions)
+	}
+	if fmt.Sprint(parallel) != fmt.Sprint(sequential) {
+		t.Errorf("Expected parallel decoding to match sequential\nsequential: %v\nparallel:   %v", sequential, parallel)
+	}
+	if want := fmt.Sprintf("line=%d offset=%d", 3*processBatchLines+17, content.Len()); sequential.state != want {
+		t.Errorf("Expected state %s, got %s", want, sequential.state)
+	}
+}
+
+func TestProcessAllFilesConcurrently(t *testing.T) {
+	dbPath := "./test_concurrent_files.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	dataDir := t.TempDir()
+	engine := NewEngine(store)
+	processor := NewProcessor(dataDir, DefaultInputFiles, store, engine, 60)
+	processor.SetWorkers(3)
+
+	var metrics strings.Builder
+	for i := 0; i < 1000; i++ {
+		metrics.WriteString(workerCostLine(i) + "\n")
+	}
+	logLine := `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"timeUnixNano":"1000000000","body":{"stringValue":"claude_code.user_prompt"},` +
+		`"attributes":[{"key":"session.id","value":{"stringValue":"session-0"}}]}]}]}]}` + "\n"
+	traceLine := `{"resourceSpans":[{"scopeSpans":[{"spans":[{"name":"claude_code.request","startTimeUnixNano":"1000000000","endTimeUnixNano":"2000000000",` +
+		`"attributes":[{"key":"session.id","value":{"stringValue":"session-1"}}]}]}]}]}` + "\n"
+	contents := map[string]string{
+		"metrics.jsonl": metrics.String(),
+		"logs.jsonl":    strings.Repeat(logLine, 300),
+		"traces.jsonl":  strings.Repeat(traceLine, 200),
+	}
+	for name, data := range contents {
+		appendToFile(t, filepath.Join(dataDir, name), data)
+	}
+
+	processor.processAllFiles()
+
+	// Each file keeps its own state row, whatever order the workers finish in
+	for name, data := range contents {
+		state, err := store.GetProcessingState(name)
+		if err != nil {
+			t.Fatalf("Failed to get processing state: %v", err)
+		}
+		if lines := int64(strings.Count(data, "\n")); state.LastByteOffset != int64(len(data)) || state.LastProcessedLine != lines {
+			t.Errorf("%s: expected line %d at offset %d, got line %d at offset %d", name, lines, len(data), state.LastProcessedLine, state.LastByteOffset)
+		}
+	}
+	if session := engine.sessionsCache["session-0"]; session == nil || session.UserPromptCount != 300 {
+		t.Errorf("Expected 300 prompts on session-0, got %+v", session)
+	}
+}
+
+// BenchmarkProcessFileWorkers_Large processes 100,000 lines from the start of
+// the file on every iteration, sequentially and with the default worker count
+func BenchmarkProcessFileWorkers_Large(b *testing.B) {
+	for _, workers := range []int{1, DefaultProcessingWorkers} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			benchmarkProcessFileWorkers(b, 100000, workers)
+		})
+	}
+}
+
+func benchmarkProcessFileWorkers(b *testing.B, lineCount, workers int) {
+	dbPath := fmt.Sprintf("./bench_workers_%d.db", workers)
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		b.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	testDir := b.TempDir()
+	testFile := filepath.Join(testDir, "metrics.jsonl")
+	var content strings.Builder
+	for i := 0; i < lineCount; i++ {
+		content.WriteString(workerCostLine(i) + "\n")
+	}
+	if err := os.WriteFile(testFile, []byte(content.String()), 0644); err != nil {
+		b.Fatalf("Failed to write test file: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if err := store.UpdateProcessingState("metrics.jsonl", 0, 0, 0, 0); err != nil {
+			b.Fatalf("Failed to reset processing state: %v", err)
+		}
+		processor := NewProcessor(testDir, DefaultInputFiles, store, NewEngine(store), 5)
+		processor.SetWorkers(workers)
+		b.StartTimer()
+
+		if err := processor.ProcessFile(testFile); err != nil {
+			b.Fatalf("Failed to process file: %v", err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Package anonymize replaces user and organization identifiers with keyed
+// hashes, for deployments that must not store the real ones.
+//
+// The collector hashes identifiers before requests are written, and the
+// aggregator hashes those it reads from files written before anonymization
+// was enabled. Hashing is deterministic for a given secret, so per-user and
+// per-organization aggregation still works, and hashed identifiers are
+// recognized and left alone so neither side hashes them twice.
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Prefix marks a hashed identifier
+const Prefix = "anon-"
+
+// Keys are the attributes holding identifiers to hash
+var Keys = []string{"user.id", "organization.id"}
+
+// Hasher hashes identifiers with HMAC-SHA256 keyed by a secret. A nil
+// Hasher leaves identifiers unchanged.
+type Hasher struct {
+	key []byte
+}
+
+// New returns a Hasher keyed by secret, or nil when secret is empty
+func New(secret string) *Hasher {
+	if secret == "" {
+		return nil
+	}
+	return &Hasher{key: []byte(secret)}
+}
+
+// ID returns id's hash: Prefix followed by the hex HMAC-SHA256 of id. Empty
+// and already hashed identifiers are returned as is.
+func (h *Hasher) ID(id string) string {
+	if h == nil || id == "" || Hashed(id) {
+		return id
+	}
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(id))
+	return Prefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsKey reports whether key is an attribute holding an identifier to hash
+func IsKey(key string) bool {
+	for _, k := range Keys {
+		if key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Hashed reports whether id has the form of a hashed identifier
+func Hashed(id string) bool {
+	digest, ok := strings.CutPrefix(id, Prefix)
+	if !ok || len(digest) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil && digest == strings.ToLower(digest)
+}
@@ -0,0 +1,50 @@
+package anonymize
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIDIsDeterministic(t *testing.T) {
+	h := New("secret")
+
+	first, second := h.ID("user@example.com"), New("secret").ID("user@example.com")
+	if first != second {
+		t.Errorf("Expected the same hash for the same ID, got %s and %s", first, second)
+	}
+	if !strings.HasPrefix(first, Prefix) || strings.Contains(first, "user@example.com") {
+		t.Errorf("Expected a prefixed hash without the raw ID, got %s", first)
+	}
+	if other := h.ID("other@example.com"); other == first {
+		t.Errorf("Expected different IDs to hash differently, both got %s", first)
+	}
+	if keyed := New("another secret").ID("user@example.com"); keyed == first {
+		t.Errorf("Expected the hash to depend on the secret, both got %s", first)
+	}
+}
+
+func TestIDLeavesHashedAndEmptyIDs(t *testing.T) {
+	h := New("secret")
+
+	hashed := h.ID("user-1")
+	if again := h.ID(hashed); again != hashed {
+		t.Errorf("Expected a hashed ID to be left alone, got %s from %s", again, hashed)
+	}
+	if id := h.ID(""); id != "" {
+		t.Errorf("Expected an empty ID to stay empty, got %s", id)
+	}
+	// Only the exact hashed form is recognized; anything else is hashed
+	if id := h.ID(Prefix + "user-1"); id == Prefix+"user-1" {
+		t.Errorf("Expected a raw ID with the prefix to be hashed, got %s", id)
+	}
+}
+
+func TestNilHasherLeavesIDs(t *testing.T) {
+	if h := New(""); h != nil {
+		t.Fatalf("Expected no hasher without a secret, got %+v", h)
+	}
+	var h *Hasher
+	if id := h.ID("user-1"); id != "user-1" {
+		t.Errorf("Expected the ID unchanged, got %s", id)
+	}
+}
@@ -0,0 +1,108 @@
+// Package buildinfo reports which build of otis is running.
+//
+// Version, Commit and Date are injected at build time:
+//
+//	go build -ldflags "-X github.com/zmack/otis/buildinfo.Version=v1.2.0 \
+//		-X github.com/zmack/otis/buildinfo.Commit=$(git rev-parse HEAD) \
+//		-X github.com/zmack/otis/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without ldflags, the module version and VCS stamp embedded by the Go
+// toolchain are used instead.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/zmack/otis/config"
+)
+
+// Set via -ldflags -X
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// startTime approximates process start for uptime reporting
+var startTime = time.Now()
+
+// Info describes the running build
+type Info struct {
+	Version   string
+	Commit    string
+	Date      string
+	GoVersion string
+}
+
+// Get returns the build info, falling back to debug.ReadBuildInfo for any
+// value not injected through ldflags
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" {
+			info.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = setting.Value
+				}
+			}
+		}
+	}
+
+	if info.Version == "" || info.Version == "(devel)" {
+		info.Version = "dev"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+
+	return info
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("otis %s (commit %s, built %s, %s)", i.Version, i.Commit, i.Date, i.GoVersion)
+}
+
+// Uptime returns how long the process has been running
+func Uptime() time.Duration {
+	return time.Since(startTime)
+}
+
+// Features reports which optional features the configuration enables
+func Features(cfg *config.Config) map[string]bool {
+	return map[string]bool{
+		"aggregator":      cfg.AggregatorEnabled,
+		"ingest_auth":     cfg.IngestToken != "",
+		"tls":             cfg.TLSCertFile != "" && cfg.TLSKeyFile != "",
+		"buffered_writes": cfg.WriteFlushIntervalMS > 0,
+		"rate_limit":      cfg.MaxRequestsPerSecond > 0,
+		"file_rotation":   cfg.MaxFileSizeBytes > 0,
+		"rotated_gzip":    cfg.MaxFileSizeBytes > 0 && cfg.CompressRotated,
+		"cost_breakdown":  cfg.PricingFile != "",
+		"cost_estimation": cfg.PricingFile != "",
+		"direct_ingest":   cfg.DirectIngest,
+		"forwarding":      cfg.ForwardEndpoint != "",
+		"anonymize_ids":   cfg.AnonymizeIDs,
+		"single_port":     cfg.SinglePort,
+		"seat_tracking":   cfg.Seats > 0 || len(cfg.OrgSeats) > 0,
+	}
+}
@@ -0,0 +1,40 @@
+package buildinfo
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/zmack/otis/config"
+)
+
+func TestGetFallsBackWithoutLdflags(t *testing.T) {
+	info := Get()
+
+	if info.Version == "" || info.Commit == "" || info.Date == "" {
+		t.Errorf("Expected every field to be populated, got %+v", info)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("Expected Go version %s, got %s", runtime.Version(), info.GoVersion)
+	}
+}
+
+func TestGetPrefersLdflags(t *testing.T) {
+	Version, Commit, Date = "v1.2.3", "abc123", "2025-01-01T00:00:00Z"
+	defer func() { Version, Commit, Date = "", "", "" }()
+
+	info := Get()
+	if info.Version != "v1.2.3" || info.Commit != "abc123" || info.Date != "2025-01-01T00:00:00Z" {
+		t.Errorf("Expected injected values, got %+v", info)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	features := Features(&config.Config{AggregatorEnabled: true, IngestToken: "secret"})
+
+	if !features["aggregator"] || !features["ingest_auth"] {
+		t.Errorf("Expected aggregator and ingest_auth to be enabled, got %v", features)
+	}
+	if features["tls"] || features["rate_limit"] {
+		t.Errorf("Expected tls and rate_limit to be disabled, got %v", features)
+	}
+}
@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// authorizeAdmin checks a request for the admin bearer token, writing the
+// error response when it is missing or wrong. With no token configured the
+// admin endpoints are disabled.
+func authorizeAdmin(token string, w http.ResponseWriter, r *http.Request) bool {
+	if token == "" {
+		http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+		return false
+	}
+	provided := []byte(r.Header.Get("Authorization"))
+	if subtle.ConstantTimeCompare(provided, []byte("Bearer "+token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// rotateHandler handles POST /admin/rotate?file=metrics.jsonl, rotating the
+// named JSONL file, or every file without one. An organization's files are
+// named by their path under the output directory, as in acme/metrics.jsonl.
+// Files with nothing written since their last rotation are left as they are.
+func rotateHandler(token string, writers func() []*FileWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeAdmin(token, w, r) {
+			return
+		}
+
+		file := r.URL.Query().Get("file")
+		var targets []*FileWriter
+		for _, writer := range writers() {
+			if file == "" || writer.name == file {
+				targets = append(targets, writer)
+			}
+		}
+		if len(targets) == 0 {
+			http.Error(w, "No such JSONL file", http.StatusNotFound)
+			return
+		}
+
+		files := make([]map[string]interface{}, 0, len(targets))
+		for _, writer := range targets {
+			name := writer.name
+			rotated, err := writer.Rotate()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error rotating %s: %v", name, err), http.StatusInternalServerError)
+				return
+			}
+			if rotated {
+				log.Printf("Rotated %s on request", name)
+			}
+			files = append(files, map[string]interface{}{
+				"file":      name,
+				"rotated":   rotated,
+				"rotations": writer.Rotations(),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"files": files})
+	}
+}
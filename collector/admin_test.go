@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zmack/otis/config"
+)
+
+func TestAdminRotateEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	server, err := NewServer(&config.Config{
+		OutputDir:       dir,
+		TraceFileName:   "traces.jsonl",
+		MetricFileName:  "metrics.jsonl",
+		LogFileName:     "logs.jsonl",
+		MaxRequestBytes: 1 << 20,
+		AdminToken:      "admin-secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handler := server.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(newTestTraceRequest(t, 1))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	rotate := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/rotate"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := rotate("", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with the wrong token, got %d", rec.Code)
+	}
+	if rec := rotate("?file=secrets.jsonl", "admin-secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown file, got %d", rec.Code)
+	}
+
+	rec = rotate("", "admin-secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response struct {
+		Files []struct {
+			File    string `json:"file"`
+			Rotated bool   `json:"rotated"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	rotated := make(map[string]bool)
+	for _, f := range response.Files {
+		rotated[f.File] = f.Rotated
+	}
+	if len(rotated) != 3 || !rotated["traces.jsonl"] || rotated["metrics.jsonl"] || rotated["logs.jsonl"] {
+		t.Errorf("Expected only traces.jsonl rotated, got %s", rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "traces.jsonl.1")); err != nil {
+		t.Errorf("Expected traces.jsonl.1 on disk: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var stats struct {
+		Writers []struct {
+			File      string `json:"file"`
+			Lines     int64  `json:"lines"`
+			Rotations int64  `json:"rotations"`
+		} `json:"writers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	found := false
+	for _, w := range stats.Writers {
+		if w.File == "traces.jsonl" {
+			found = true
+			if w.Lines != 1 || w.Rotations != 1 {
+				t.Errorf("Expected 1 line and 1 rotation for traces.jsonl, got %+v", w)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected traces.jsonl in /stats writers, got %s", rec.Body.String())
+	}
+}
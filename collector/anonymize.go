@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"github.com/zmack/otis/anonymize"
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+)
+
+// anonymizeAttributes replaces identifier values in attrs with their hashes,
+// reporting whether anything changed
+func anonymizeAttributes(h *anonymize.Hasher, attrs []*commonpb.KeyValue) bool {
+	var changed bool
+	for _, kv := range attrs {
+		if !anonymize.IsKey(kv.Key) {
+			continue
+		}
+		id := kv.GetValue().GetStringValue()
+		if hashed := h.ID(id); hashed != id {
+			kv.Value = stringValue(hashed)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// anonymizeLogs anonymizes req in place at every attribute level, reporting
+// whether anything changed
+func anonymizeLogs(h *anonymize.Hasher, req *logsv1.ExportLogsServiceRequest) bool {
+	if h == nil {
+		return false
+	}
+
+	var changed bool
+	for _, rl := range req.GetResourceLogs() {
+		changed = anonymizeAttributes(h, rl.GetResource().GetAttributes()) || changed
+		for _, sl := range rl.GetScopeLogs() {
+			changed = anonymizeAttributes(h, sl.GetScope().GetAttributes()) || changed
+			for _, lr := range sl.GetLogRecords() {
+				changed = anonymizeAttributes(h, lr.Attributes) || changed
+			}
+		}
+	}
+	return changed
+}
+
+// anonymizeMetrics anonymizes req in place at every attribute level,
+// reporting whether anything changed
+func anonymizeMetrics(h *anonymize.Hasher, req *metricsv1.ExportMetricsServiceRequest) bool {
+	if h == nil {
+		return false
+	}
+
+	var changed bool
+	for _, rm := range req.GetResourceMetrics() {
+		changed = anonymizeAttributes(h, rm.GetResource().GetAttributes()) || changed
+		for _, sm := range rm.GetScopeMetrics() {
+			changed = anonymizeAttributes(h, sm.GetScope().GetAttributes()) || changed
+			for _, m := range sm.GetMetrics() {
+				for _, dp := range m.GetGauge().GetDataPoints() {
+					changed = anonymizeAttributes(h, dp.Attributes) || changed
+				}
+				for _, dp := range m.GetSum().GetDataPoints() {
+					changed = anonymizeAttributes(h, dp.Attributes) || changed
+				}
+				for _, dp := range m.GetHistogram().GetDataPoints() {
+					changed = anonymizeAttributes(h, dp.Attributes) || changed
+				}
+				for _, dp := range m.GetExponentialHistogram().GetDataPoints() {
+					changed = anonymizeAttributes(h, dp.Attributes) || changed
+				}
+				for _, dp := range m.GetSummary().GetDataPoints() {
+					changed = anonymizeAttributes(h, dp.Attributes) || changed
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// anonymizeTraces anonymizes req in place at every attribute level,
+// reporting whether anything changed
+func anonymizeTraces(h *anonymize.Hasher, req *tracev1.ExportTraceServiceRequest) bool {
+	if h == nil {
+		return false
+	}
+
+	var changed bool
+	for _, rs := range req.GetResourceSpans() {
+		changed = anonymizeAttributes(h, rs.GetResource().GetAttributes()) || changed
+		for _, ss := range rs.GetScopeSpans() {
+			changed = anonymizeAttributes(h, ss.GetScope().GetAttributes()) || changed
+			for _, span := range ss.GetSpans() {
+				changed = anonymizeAttributes(h, span.Attributes) || changed
+				for _, event := range span.GetEvents() {
+					changed = anonymizeAttributes(h, event.Attributes) || changed
+				}
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zmack/otis/anonymize"
+	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	v1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMetricsHandlerAnonymizesIDsBeforeWriting(t *testing.T) {
+	body, err := proto.Marshal(&metricsv1.ExportMetricsServiceRequest{
+		ResourceMetrics: []*v1.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "user.id", Value: stringValue("alice@example.com")},
+			}},
+			ScopeMetrics: []*v1.ScopeMetrics{{Metrics: []*v1.Metric{{
+				Name: "claude_code.cost.usage",
+				Data: &v1.Metric_Sum{Sum: &v1.Sum{DataPoints: []*v1.NumberDataPoint{{
+					Attributes: []*commonpb.KeyValue{
+						{Key: "session.id", Value: stringValue("session-1")},
+						{Key: "organization.id", Value: stringValue("acme-org")},
+					},
+				}}}},
+			}}}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "metrics.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	hasher := anonymize.New("secret")
+	handler := NewMetricsHandler(writer, RequestLimits{MaxBytes: 1 << 20}, NewMetrics())
+	handler.SetAnonymizer(hasher)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if bytes.Contains(data, []byte("alice")) || bytes.Contains(data, []byte("acme-org")) {
+		t.Errorf("Expected raw IDs never written, got %s", data)
+	}
+	for _, id := range []string{hasher.ID("alice@example.com"), hasher.ID("acme-org"), "session-1"} {
+		if !bytes.Contains(data, []byte(id)) {
+			t.Errorf("Expected %s in output, got %s", id, data)
+		}
+	}
+}
+
+func TestAnonymizeTracesIsIdempotent(t *testing.T) {
+	req := &tracev1.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{
+			ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{
+				Attributes: []*commonpb.KeyValue{
+					{Key: "user.id", Value: stringValue("alice@example.com")},
+				},
+			}}}},
+		}},
+	}
+	hasher := anonymize.New("secret")
+
+	if !anonymizeTraces(hasher, req) {
+		t.Fatal("Expected the request to be anonymized")
+	}
+	span := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if got := span.Attributes[0].GetValue().GetStringValue(); got != hasher.ID("alice@example.com") {
+		t.Errorf("Expected the hashed user ID, got %s", got)
+	}
+	if anonymizeTraces(hasher, req) {
+		t.Error("Expected an anonymized request to be unchanged")
+	}
+	if anonymizeTraces(nil, req) {
+		t.Error("Expected a nil hasher to change nothing")
+	}
+}
@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+)
+
+// writeQueueRetryAfter is the Retry-After, in seconds, sent with a 429 for a
+// full write queue. A slow disk gives no hint of when it will catch up, so
+// exporters simply back off and try again shortly.
+const writeQueueRetryAfter = 1
+
+// diskFullRetryAfter is the Retry-After, in seconds, sent with a 503 for a
+// full disk, which takes longer to clear than a slow one
+const diskFullRetryAfter = 30
+
+// reserveWrite claims a place in writer's queue for a request's writes. When
+// the queue is full it answers 429 with Retry-After straight away, so the
+// exporter retries later instead of timing out behind the slow disk. Only
+// files have a queue; other writers are never full.
+func reserveWrite(w http.ResponseWriter, writer LineWriter, metrics *Metrics, signal string) (release func(), ok bool) {
+	file, isFile := writer.(*FileWriter)
+	if !isFile {
+		return func() {}, true
+	}
+
+	leave, ok := file.Reserve()
+	if !ok {
+		metrics.recordQueueRejected(signal)
+		log.Printf("Write queue full, rejected %s request", signal)
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", writeQueueRetryAfter))
+		writeStatus(w, http.StatusTooManyRequests, codes.ResourceExhausted, "write queue full")
+		return nil, false
+	}
+
+	metrics.recordQueued(signal, 1)
+	return func() {
+		leave()
+		metrics.recordQueued(signal, -1)
+	}, true
+}
+
+// rejectDiskFull answers 503 with Retry-After when a request's writes failed
+// because the disk is full, so exporters keep the data and send it again
+// instead of dropping it as rejected. It reports whether it answered. Only
+// requests of which nothing was written may be answered this way; once part
+// of one is accepted, the rest is reported as a partial success instead, so
+// the retry doesn't write the accepted part twice.
+func rejectDiskFull(w http.ResponseWriter, signal string, err error) bool {
+	if !errors.Is(err, ErrDiskFull) {
+		return false
+	}
+	log.Printf("Disk full, rejected %s request: %v", signal, err)
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", diskFullRetryAfter))
+	writeStatus(w, http.StatusServiceUnavailable, codes.Unavailable, "disk full")
+	return true
+}
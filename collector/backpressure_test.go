@@ -0,0 +1,222 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmack/otis/config"
+)
+
+func TestFullWriteQueueRejectsWith429(t *testing.T) {
+	writer, err := NewFileWriter(filepath.Join(t.TempDir(), "traces.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetQueueSize(2)
+	metrics := NewMetrics()
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20}, metrics)
+	body := newTestTraceRequest(t, 1)
+
+	// Holding the writer's lock stands in for a disk that has stopped keeping up
+	writer.mu.Lock()
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(body)))
+			codes <- rec.Code
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); writer.QueueDepth() < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			writer.mu.Unlock()
+			t.Fatalf("Expected 2 queued requests, got %d", writer.QueueDepth())
+		}
+	}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(body)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 with the queue full, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected an immediate rejection, took %v", elapsed)
+	}
+
+	metricsText := func() string {
+		rec := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+		return rec.Body.String()
+	}
+	output := metricsText()
+	for _, want := range []string{
+		`otis_write_queue_depth{signal="traces"} 2`,
+		`otis_write_queue_rejections_total{signal="traces"} 1`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected metrics output to contain %q, got:\n%s", want, output)
+		}
+	}
+
+	// Once the disk catches up the queued requests succeed and the queue drains
+	writer.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("Expected queued request to succeed, got %d", code)
+		}
+	}
+	if depth := writer.QueueDepth(); depth != 0 {
+		t.Errorf("Expected an empty queue, got %d", depth)
+	}
+	if output := metricsText(); !strings.Contains(output, `otis_write_queue_depth{signal="traces"} 0`) {
+		t.Errorf("Expected the depth gauge back at 0, got:\n%s", output)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 once the queue drained, got %d", rec.Code)
+	}
+}
+
+func TestUnboundedWriteQueueNeverRejects(t *testing.T) {
+	writer, err := NewFileWriter(filepath.Join(t.TempDir(), "traces.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := writer.Reserve(); !ok {
+			t.Fatalf("Expected reservation %d to succeed without a queue size", i)
+		}
+	}
+	if depth := writer.QueueDepth(); depth != 0 {
+		t.Errorf("Expected no depth without a queue, got %d", depth)
+	}
+}
+
+func TestDiskFullRejectsWith503(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("No /dev/full to simulate a full disk")
+	}
+	writer, err := NewFileWriter("/dev/full")
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetWriteRetry(1, 0)
+	metrics := NewMetrics()
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20}, metrics)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(newTestTraceRequest(t, 1))))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with the disk full, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Expected Retry-After 30, got %q", got)
+	}
+	if output := metricsOutput(metrics); !strings.Contains(output, `otis_disk_full{signal="traces"} 1`) {
+		t.Error("Expected the disk full gauge set")
+	}
+}
+
+// diskFillingWriter accepts its first lines, then fails every write as if
+// the disk had filled up
+type diskFillingWriter struct {
+	room  int
+	lines []string
+	tries int
+}
+
+func (w *diskFillingWriter) WriteLine(s string) error {
+	w.tries++
+	if len(w.lines) == w.room {
+		return fmt.Errorf("write: %w", ErrDiskFull)
+	}
+	w.lines = append(w.lines, s)
+	return nil
+}
+
+func (w *diskFillingWriter) WriteJSON(data interface{}) error { return nil }
+func (w *diskFillingWriter) Close() error                     { return nil }
+
+func TestDiskFullAfterPartialWriteReportsPartialSuccess(t *testing.T) {
+	writer := &diskFillingWriter{room: 1}
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20, MaxResources: 1}, NewMetrics())
+
+	// Three resources are written as three lines; only the first fits
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(newTestTraceRequestWithResources(t, 3, 2))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 once part of the request was written, got %d", rec.Code)
+	}
+	resp := decodeTraceResponse(t, rec.Body)
+	if resp.PartialSuccess == nil || resp.PartialSuccess.RejectedSpans != 4 {
+		t.Errorf("Expected the 4 unwritten spans rejected, got %v", resp.PartialSuccess)
+	}
+	if len(writer.lines) != 1 || writer.tries != 2 {
+		t.Errorf("Expected no writes tried after the disk filled up, got %d lines in %d tries", len(writer.lines), writer.tries)
+	}
+}
+
+func TestReadyReportsPersistentWriteFailures(t *testing.T) {
+	outputDir := t.TempDir()
+	server, err := NewServer(&config.Config{
+		OutputDir:              outputDir,
+		TraceFileName:          "traces.jsonl",
+		MetricFileName:         "metrics.jsonl",
+		LogFileName:            "logs.jsonl",
+		MaxRequestBytes:        1 << 20,
+		MaxResourcesPerRequest: 1,
+		WriteRetryAttempts:     1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ready := func() (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		var body map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return rec.Code, body
+	}
+	post := func() {
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(newTestTraceRequest(t, 1))))
+	}
+
+	// A directory where the trace file belongs fails every write
+	tracePath := filepath.Join(outputDir, "traces.jsonl")
+	if err := os.Mkdir(tracePath, 0755); err != nil {
+		t.Fatalf("Failed to block the trace file: %v", err)
+	}
+	post()
+	if code, _ := ready(); code != http.StatusOK {
+		t.Errorf("Expected a single failed write to leave the collector ready, got %d", code)
+	}
+	post()
+	post()
+	code, body := ready()
+	failing, _ := body["failing_files"].([]interface{})
+	if code != http.StatusServiceUnavailable || body["status"] != "not_ready" || len(failing) != 1 ||
+		failing[0].(map[string]interface{})["file"] != "traces.jsonl" {
+		t.Errorf("Expected not ready with the trace file failing, got %d: %v", code, body)
+	}
+
+	os.Remove(tracePath)
+	post()
+	if code, body := ready(); code != http.StatusOK || body["status"] != "ready" {
+		t.Errorf("Expected ready again after a successful write, got %d: %v", code, body)
+	}
+}
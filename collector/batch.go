@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maxTraceStateBytes bounds the tracestate header kept with a batch; longer
+// ones are dropped rather than truncated, since a cut list is malformed
+const maxTraceStateBytes = 512
+
+// traceParentPattern matches a W3C traceparent header: version, trace ID,
+// parent span ID and flags, lowercase hex
+var traceParentPattern = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// BatchContext tags the JSONL lines written for one export request, and the
+// requests passed to a Sink, so the records aggregated from them can be
+// traced back to the request and, when the exporter sent W3C trace context,
+// to the trace it was part of
+type BatchContext struct {
+	ID          string `json:"id"`
+	TraceParent string `json:"traceparent,omitempty"` // Empty when the request had no valid traceparent
+	TraceState  string `json:"tracestate,omitempty"`
+}
+
+// newBatchContext gives a request a fresh batch ID and captures its trace
+// context headers. An invalid traceparent is ignored, along with the
+// tracestate that depends on it.
+func newBatchContext(r *http.Request) *BatchContext {
+	id := make([]byte, 8)
+	rand.Read(id)
+	batch := &BatchContext{ID: hex.EncodeToString(id)}
+
+	traceParent := strings.TrimSpace(r.Header.Get("traceparent"))
+	if !validTraceParent(traceParent) {
+		return batch
+	}
+	batch.TraceParent = traceParent
+	if traceState := strings.TrimSpace(r.Header.Get("tracestate")); len(traceState) <= maxTraceStateBytes {
+		batch.TraceState = traceState
+	}
+	return batch
+}
+
+// validTraceParent reports whether header is a W3C traceparent with a known
+// version and non-zero trace and parent IDs
+func validTraceParent(header string) bool {
+	if !traceParentPattern.MatchString(header) || strings.HasPrefix(header, "ff") {
+		return false
+	}
+	parts := strings.Split(header, "-")
+	return strings.Trim(parts[1], "0") != "" && strings.Trim(parts[2], "0") != ""
+}
@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewBatchContextCapturesTraceContext(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tests := []struct {
+		name        string
+		traceParent string
+		traceState  string
+		wantParent  string
+		wantState   string
+	}{
+		{"valid", valid, "vendor=abc", valid, "vendor=abc"},
+		{"missing", "", "vendor=abc", "", ""},
+		{"malformed", "00-xyz-00f067aa0ba902b7-01", "vendor=abc", "", ""},
+		{"uppercase", strings.ToUpper(valid), "", "", ""},
+		{"forbidden version", "ff" + valid[2:], "", "", ""},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", "", ""},
+		{"zero parent ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", "", ""},
+		{"oversized tracestate", valid, strings.Repeat("a", maxTraceStateBytes+1), valid, ""},
+	}
+
+	seen := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/logs", nil)
+			if tt.traceParent != "" {
+				req.Header.Set("traceparent", tt.traceParent)
+			}
+			if tt.traceState != "" {
+				req.Header.Set("tracestate", tt.traceState)
+			}
+			batch := newBatchContext(req)
+			if len(batch.ID) != 16 || seen[batch.ID] {
+				t.Errorf("Expected a fresh 16 character batch ID, got %q", batch.ID)
+			}
+			seen[batch.ID] = true
+			if batch.TraceParent != tt.wantParent || batch.TraceState != tt.wantState {
+				t.Errorf("Expected traceparent %q and tracestate %q, got %q and %q",
+					tt.wantParent, tt.wantState, batch.TraceParent, batch.TraceState)
+			}
+		})
+	}
+}
+
+func TestEnvelopeLineCarriesBatch(t *testing.T) {
+	receivedAt := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	var envelope struct {
+		ReceivedAt string          `json:"receivedAt"`
+		Batch      *BatchContext   `json:"batch"`
+		Data       json.RawMessage `json:"data"`
+	}
+
+	line := envelopeLine(receivedAt, &BatchContext{ID: "0123456789abcdef", TraceState: `a="quoted"`}, `{"resourceLogs":[]}`)
+	if err := json.Unmarshal([]byte(line), &envelope); err != nil {
+		t.Fatalf("Expected valid JSON, got %v: %s", err, line)
+	}
+	if envelope.Batch == nil || envelope.Batch.ID != "0123456789abcdef" || envelope.Batch.TraceState != `a="quoted"` {
+		t.Errorf("Expected the batch in the envelope, got %s", line)
+	}
+	if strings.Contains(line, "traceparent") {
+		t.Errorf("Expected an empty traceparent left out, got %s", line)
+	}
+
+	if line := envelopeLine(receivedAt, nil, `{"resourceLogs":[]}`); strings.Contains(line, "batch") {
+		t.Errorf("Expected no batch in an untagged envelope, got %s", line)
+	}
+}
@@ -0,0 +1,236 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTrackedClients bounds the per-client response counters. Clients first
+// seen after that many are counted together under otherClients.
+const maxTrackedClients = 256
+
+const otherClients = "other"
+
+// ClientStats counts the responses returned to each exporter by status
+// class, and warns when one client keeps failing, as an exporter retrying
+// with a stale token or against a full disk does. Clients are told apart by
+// remote IP.
+type ClientStats struct {
+	mu            sync.Mutex
+	now           func() time.Time
+	metrics       *Metrics
+	warnPerMinute int
+	clients       map[string]*clientCounts
+
+	// writers lists the JSONL file writers whose counters /stats also reports
+	writers func() []*FileWriter
+}
+
+type clientCounts struct {
+	classes  map[string]int64 // Status class, e.g. "4xx"
+	errors   map[int]int64    // Error status code
+	lastSeen time.Time
+
+	// Errors in the current minute, for the retry storm warning
+	windowStart  time.Time
+	windowErrors map[int]int
+	lastWarning  time.Time
+}
+
+// NewClientStats tracks responses per client, logging a warning at most once
+// a minute for a client with more than warnPerMinute error responses in a
+// minute. Zero disables the warning.
+func NewClientStats(metrics *Metrics, warnPerMinute int) *ClientStats {
+	return &ClientStats{
+		now:           time.Now,
+		metrics:       metrics,
+		warnPerMinute: warnPerMinute,
+		clients:       make(map[string]*clientCounts),
+	}
+}
+
+// SetWriters adds the counters of the file writers listed by writers to
+// /stats. It is called for each request, so writers created later are included.
+func (s *ClientStats) SetWriters(writers func() []*FileWriter) {
+	s.writers = writers
+}
+
+// record counts a response with status returned to client
+func (s *ClientStats) record(client string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts, ok := s.clients[client]
+	if !ok {
+		if len(s.clients) >= maxTrackedClients {
+			client = otherClients
+			counts = s.clients[client]
+		}
+		if counts == nil {
+			counts = &clientCounts{classes: make(map[string]int64), errors: make(map[int]int64)}
+			s.clients[client] = counts
+		}
+	}
+
+	now := s.now()
+	class := fmt.Sprintf("%dxx", status/100)
+	counts.classes[class]++
+	counts.lastSeen = now
+	s.metrics.recordResponse(client, class)
+	if status < http.StatusBadRequest {
+		return
+	}
+	counts.errors[status]++
+
+	if now.Sub(counts.windowStart) >= time.Minute {
+		counts.windowStart = now
+		counts.windowErrors = make(map[int]int)
+	}
+	counts.windowErrors[status]++
+
+	total, common := 0, 0
+	for code, n := range counts.windowErrors {
+		total += n
+		if n > counts.windowErrors[common] || (n == counts.windowErrors[common] && code < common) {
+			common = code
+		}
+	}
+	if s.warnPerMinute > 0 && total > s.warnPerMinute && now.Sub(counts.lastWarning) >= time.Minute {
+		counts.lastWarning = now
+		log.Printf("Warning: client %s got %d error responses in the last minute, mostly %d %s; its exporter may be stuck retrying",
+			client, total, common, http.StatusText(common))
+	}
+}
+
+// clientAddress identifies the client of r by its remote IP
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder captures the status code a handler responds with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// clientStatsMiddleware counts the responses of the OTLP ingest endpoints
+// per client. It wraps authentication so rejected tokens are counted too.
+func clientStatsMiddleware(stats *ClientStats, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v1/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		stats.record(clientAddress(r), rec.status)
+	})
+}
+
+// handleStats handles GET /stats, listing each client's response counts,
+// most errors first, and each JSONL writer's counters
+func (s *ClientStats) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type clientEntry struct {
+		Client    string           `json:"client"`
+		Responses map[string]int64 `json:"responses"`
+		Errors    map[string]int64 `json:"errors"`
+		LastSeen  string           `json:"last_seen"`
+		total     int64
+	}
+
+	s.mu.Lock()
+	clients := make([]clientEntry, 0, len(s.clients))
+	for client, counts := range s.clients {
+		entry := clientEntry{
+			Client:    client,
+			Responses: make(map[string]int64, len(counts.classes)),
+			Errors:    make(map[string]int64, len(counts.errors)),
+			LastSeen:  counts.lastSeen.UTC().Format(time.RFC3339),
+		}
+		for class, n := range counts.classes {
+			entry.Responses[class] = n
+		}
+		for code, n := range counts.errors {
+			entry.Errors[fmt.Sprintf("%d", code)] = n
+			entry.total += n
+		}
+		clients = append(clients, entry)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].total != clients[j].total {
+			return clients[i].total > clients[j].total
+		}
+		return clients[i].Client < clients[j].Client
+	})
+
+	var fileWriters []*FileWriter
+	if s.writers != nil {
+		fileWriters = s.writers()
+	}
+	writers := make([]map[string]interface{}, len(fileWriters))
+	for i, writer := range fileWriters {
+		stats := writer.Stats()
+		writers[i] = map[string]interface{}{
+			"file":          stats.File,
+			"lines":         stats.Lines,
+			"bytes":         stats.Bytes,
+			"flushes":       stats.Flushes,
+			"rotations":     stats.Rotations,
+			"errors":        stats.Errors,
+			"segment_bytes": stats.SegmentBytes,
+			"queue_depth":   stats.QueueDepth,
+			"failed_writes": stats.FailedWrites,
+			"disk_full":     stats.DiskFull,
+		}
+		if !stats.SegmentOpenedAt.IsZero() {
+			writers[i]["segment_opened_at"] = stats.SegmentOpenedAt.UTC().Format(time.RFC3339)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"clients":             clients,
+		"max_tracked_clients": maxTrackedClients,
+		"writers":             writers,
+	})
+}
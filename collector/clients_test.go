@@ -0,0 +1,152 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmack/otis/config"
+)
+
+func TestServerCountsResponsesPerClient(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		OutputDir:       t.TempDir(),
+		TraceFileName:   "traces.jsonl",
+		MetricFileName:  "metrics.jsonl",
+		LogFileName:     "logs.jsonl",
+		MaxRequestBytes: 1 << 20,
+		IngestToken:     "secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handler := server.httpServer.Handler
+
+	send := func(client, path, token string, body []byte) int {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.RemoteAddr = client + ":51234"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// The laptop with a stale token, and a client sending one bad body
+	traces := newTestTraceRequest(t, 1)
+	for i := 0; i < 3; i++ {
+		send("10.0.0.1", "/v1/traces", "stale", traces)
+	}
+	send("10.0.0.1", "/v1/traces", "secret", traces)
+	send("10.0.0.2", "/v1/traces", "secret", traces)
+	send("10.0.0.2", "/v1/traces", "secret", traces)
+	if code := send("10.0.0.2", "/v1/logs", "secret", []byte("not a protobuf")); code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", code)
+	}
+
+	// The stats name client IPs, so they sit behind the ingest token too
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 from /stats without a token, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from /stats, got %d", rec.Code)
+	}
+	var stats struct {
+		Clients []struct {
+			Client    string           `json:"client"`
+			Responses map[string]int64 `json:"responses"`
+			Errors    map[string]int64 `json:"errors"`
+		} `json:"clients"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	got := make([]string, len(stats.Clients))
+	for i, c := range stats.Clients {
+		got[i] = fmt.Sprintf("%s %v %v", c.Client, c.Responses, c.Errors)
+	}
+	expected := []string{"10.0.0.1 map[2xx:1 4xx:3] map[401:3]", "10.0.0.2 map[2xx:2 4xx:1] map[400:1]"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected clients %v, most errors first, got %v", expected, got)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`otis_responses_total{class="4xx",client="10.0.0.1"} 3`,
+		`otis_responses_total{class="2xx",client="10.0.0.2"} 2`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+}
+
+func TestClientStatsWarnsOnRetryStorm(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	clock := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	stats := NewClientStats(NewMetrics(), 3)
+	stats.now = func() time.Time { return clock }
+
+	warnings := func() int { return strings.Count(logs.String(), "Warning: client") }
+
+	stats.record("10.0.0.1", http.StatusServiceUnavailable)
+	for i := 0; i < 3; i++ {
+		stats.record("10.0.0.1", http.StatusUnauthorized)
+		stats.record("10.0.0.2", http.StatusOK)
+	}
+	stats.record("10.0.0.2", http.StatusBadRequest)
+	if warnings() != 1 || !strings.Contains(logs.String(), "client 10.0.0.1 got 4 error responses in the last minute, mostly 401 Unauthorized") {
+		t.Fatalf("Expected one warning naming 10.0.0.1 and 401, got:\n%s", logs.String())
+	}
+
+	// At most one warning a minute per client
+	clock = clock.Add(30 * time.Second)
+	for i := 0; i < 5; i++ {
+		stats.record("10.0.0.1", http.StatusUnauthorized)
+	}
+	if warnings() != 1 {
+		t.Errorf("Expected the warning rate limited, got:\n%s", logs.String())
+	}
+
+	clock = clock.Add(31 * time.Second)
+	for i := 0; i < 4; i++ {
+		stats.record("10.0.0.1", http.StatusInsufficientStorage)
+	}
+	if warnings() != 2 || !strings.Contains(logs.String(), "mostly 507 Insufficient Storage") {
+		t.Errorf("Expected a second warning a minute later, got:\n%s", logs.String())
+	}
+}
+
+func TestClientStatsBoundsTrackedClients(t *testing.T) {
+	stats := NewClientStats(NewMetrics(), 0)
+	for i := 0; i < maxTrackedClients+10; i++ {
+		stats.record(fmt.Sprintf("10.0.%d.%d", i/256, i%256), http.StatusOK)
+	}
+	stats.record("10.0.0.0", http.StatusOK)
+
+	if len(stats.clients) != maxTrackedClients+1 {
+		t.Fatalf("Expected %d tracked clients plus %q, got %d", maxTrackedClients, otherClients, len(stats.clients))
+	}
+	if n := stats.clients[otherClients].classes["2xx"]; n != 10 {
+		t.Errorf("Expected 10 responses counted as %q, got %d", otherClients, n)
+	}
+	if n := stats.clients["10.0.0.0"].classes["2xx"]; n != 2 {
+		t.Errorf("Expected a tracked client to keep its own counts, got %d", n)
+	}
+}
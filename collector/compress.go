@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// gzipInodeComment records the source file's inode in the gzip header, so the
+// processor can still match a compressed generation to the file it was reading.
+// The aggregator parses the same format.
+const gzipInodeComment = "otis-inode=%d"
+
+// compressRotated gzips src, a generation rotate just renamed to <name>.1,
+// into <name>.<inode>.gz.tmp, named by its inode so that archives of
+// successive generations never share a file. Rotations carry on meanwhile:
+// only the final step, which finds where the generation has shifted to by
+// its inode, renames the archive beside it and removes the plain file, takes
+// turns with them on w.generations. A generation dropped as the oldest before
+// its archive is done just has the archive discarded. A leftover .tmp marks
+// a compression interrupted by a crash, and a .gz is always complete.
+func (w *FileWriter) compressRotated(src *os.File, rotatedFiles int) error {
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src.Name(), err)
+	}
+	inode := getInode(info)
+
+	tmpPath := fmt.Sprintf("%s.%d.gz.tmp", w.filePath, inode)
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(src.Name())
+	zw.ModTime = info.ModTime()
+	zw.Comment = fmt.Sprintf(gzipInodeComment, inode)
+
+	_, copyErr := io.Copy(zw, src)
+	closeErr := zw.Close()
+	syncErr := dst.Sync()
+	fileErr := dst.Close()
+	for _, err := range []error{copyErr, closeErr, syncErr, fileErr} {
+		if err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+		}
+	}
+
+	w.generations.Lock()
+	defer w.generations.Unlock()
+
+	path := w.rotatedPathOf(inode, rotatedFiles)
+	if path == "" {
+		os.Remove(tmpPath)
+		return nil
+	}
+	if err := os.Rename(tmpPath, path+".gz"); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename %s: %w", tmpPath, err)
+	}
+	// The processor can read either file until this point
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
+
+// rotatedPathOf returns the path of the uncompressed rotated generation with
+// the given inode, or "" once it is gone. Callers must hold w.generations.
+func (w *FileWriter) rotatedPathOf(inode uint64, rotatedFiles int) string {
+	for i := 1; i <= rotatedFiles; i++ {
+		path := w.rotatedPath(i)
+		if info, err := os.Stat(path); err == nil && getInode(info) == inode {
+			return path
+		}
+	}
+	return ""
+}
+
+// getInode returns the file's inode, or 0 where it is unavailable
+func getInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Flusher periodically flushes a set of buffered writers from a single goroutine
+type Flusher struct {
+	mu       sync.Mutex
+	writers  []*FileWriter
+	interval time.Duration
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewFlusher creates a flusher for the given writers
+func NewFlusher(interval time.Duration, writers ...*FileWriter) *Flusher {
+	return &Flusher{
+		writers:  writers,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start begins flushing the writers every interval
+func (f *Flusher) Start() {
+	ticker := time.NewTicker(f.interval)
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		for {
+			select {
+			case <-ticker.C:
+				f.FlushAll()
+			case <-f.stopChan:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background flusher and flushes any remaining buffered data
+func (f *Flusher) Stop() {
+	close(f.stopChan)
+	f.wg.Wait()
+	f.FlushAll()
+}
+
+// Add includes a writer created after the flusher, such as an organization's
+func (f *Flusher) Add(w *FileWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.writers = append(f.writers, w)
+}
+
+// FlushAll flushes every writer, logging failures
+func (f *Flusher) FlushAll() {
+	f.mu.Lock()
+	writers := f.writers
+	f.mu.Unlock()
+
+	for _, w := range writers {
+		if err := w.Flush(); err != nil {
+			log.Printf("Failed to flush writer: %v", err)
+		}
+	}
+}
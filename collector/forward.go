@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultForwardQueueSize is how many requests wait for the upstream
+	// before new ones are dropped
+	DefaultForwardQueueSize = 1000
+
+	// forwardAttempts is how many times a request is sent before it is
+	// counted as failed
+	forwardAttempts = 4
+)
+
+// Forward outcomes for the self-monitoring counters
+const (
+	forwardSent    = "sent"
+	forwardFailed  = "failed"
+	forwardDropped = "dropped"
+)
+
+// forwardRequest is a received OTLP protobuf body waiting to be re-exported
+type forwardRequest struct {
+	signal string
+	body   []byte
+}
+
+// Forwarder relays received OTLP requests to an upstream collector's
+// /v1/traces, /v1/metrics and /v1/logs endpoints. Requests are queued and
+// sent in the background, so the upstream never slows down or fails a client
+// export; a full queue drops the request, and failures are retried with
+// backoff before being given up on. Every outcome is counted in Metrics.
+type Forwarder struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+	metrics  *Metrics
+	queue    chan forwardRequest
+	backoff  time.Duration
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewForwarder creates a forwarder to the upstream base URL, e.g.
+// http://otel-collector:4318, sending headers with every request
+func NewForwarder(endpoint string, headers map[string]string, queueSize int, metrics *Metrics) *Forwarder {
+	if queueSize <= 0 {
+		queueSize = DefaultForwardQueueSize
+	}
+	return &Forwarder{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		headers:  headers,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		metrics:  metrics,
+		queue:    make(chan forwardRequest, queueSize),
+		backoff:  500 * time.Millisecond,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start sends queued requests until Stop is called
+func (f *Forwarder) Start() {
+	go func() {
+		defer close(f.done)
+		for req := range f.queue {
+			f.send(req)
+		}
+	}()
+}
+
+// Stop stops accepting requests and waits for the queue to drain, giving up
+// when ctx is done. Start must have been called.
+func (f *Forwarder) Stop(ctx context.Context) error {
+	f.stopOnce.Do(func() { close(f.queue) })
+	select {
+	case <-f.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("forward queue not drained: %w", ctx.Err())
+	}
+}
+
+// Forward queues a request body received on signal's endpoint. It never
+// blocks: when the queue is full the request is dropped and counted.
+func (f *Forwarder) Forward(signal string, body []byte) {
+	select {
+	case f.queue <- forwardRequest{signal: signal, body: body}:
+	default:
+		f.metrics.recordForward(signal, forwardDropped)
+	}
+}
+
+// send posts a request, retrying connection errors, 429s and 5xx responses
+func (f *Forwarder) send(req forwardRequest) {
+	url := f.endpoint + "/v1/" + req.signal
+	backoff := f.backoff
+
+	var err error
+	for attempt := 1; attempt <= forwardAttempts; attempt++ {
+		var retry bool
+		retry, err = f.post(url, req.body)
+		if err == nil {
+			f.metrics.recordForward(req.signal, forwardSent)
+			return
+		}
+		if !retry || attempt == forwardAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	log.Printf("Failed to forward %s to %s: %v", req.signal, url, err)
+	f.metrics.recordForward(req.signal, forwardFailed)
+}
+
+// post sends body once, reporting whether a failure is worth retrying
+func (f *Forwarder) post(url string, body []byte) (bool, error) {
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	for key, value := range f.headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := f.client.Do(httpReq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("upstream returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("upstream returned %s", resp.Status)
+	}
+}
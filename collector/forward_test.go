@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// metricsOutput renders the self-monitoring counters
+func metricsOutput(metrics *Metrics) string {
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestForwarderRelaysPersistedRequests(t *testing.T) {
+	var mu sync.Mutex
+	var received [][]byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer upstream" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, body)
+		mu.Unlock()
+	}))
+	defer upstream.Close()
+
+	writer, err := NewFileWriter(filepath.Join(t.TempDir(), "traces.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	metrics := NewMetrics()
+	forwarder := NewForwarder(upstream.URL, map[string]string{"Authorization": "Bearer upstream"}, 10, metrics)
+	forwarder.Start()
+
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20}, metrics)
+	handler.SetForwarder(forwarder)
+
+	body := newTestTraceRequest(t, 3)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	if err := forwarder.Stop(context.Background()); err != nil {
+		t.Fatalf("Failed to stop forwarder: %v", err)
+	}
+	if len(received) != 1 || !bytes.Equal(received[0], body) {
+		t.Fatalf("Expected the original payload upstream once, got %d requests", len(received))
+	}
+	if want := `otis_forward_requests_total{result="sent",signal="traces"} 1`; !strings.Contains(metricsOutput(metrics), want) {
+		t.Errorf("Expected metrics output to contain %q", want)
+	}
+}
+
+func TestForwarderFailuresDoNotFailClient(t *testing.T) {
+	var attempts int
+	var mu sync.Mutex
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	metrics := NewMetrics()
+	forwarder := NewForwarder(upstream.URL, nil, 10, metrics)
+	forwarder.backoff = time.Millisecond
+	forwarder.Start()
+
+	handler := NewLogsHandler(nil, RequestLimits{MaxBytes: 1 << 20}, metrics)
+	handler.SetForwarder(forwarder)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/logs", bytes.NewReader(nil)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 despite the upstream failing, got %d", rec.Code)
+	}
+
+	if err := forwarder.Stop(context.Background()); err != nil {
+		t.Fatalf("Failed to stop forwarder: %v", err)
+	}
+	if attempts != forwardAttempts {
+		t.Errorf("Expected %d attempts, got %d", forwardAttempts, attempts)
+	}
+	if want := `otis_forward_requests_total{result="failed",signal="logs"} 1`; !strings.Contains(metricsOutput(metrics), want) {
+		t.Errorf("Expected metrics output to contain %q", want)
+	}
+}
+
+func TestForwarderDropsWhenQueueFull(t *testing.T) {
+	metrics := NewMetrics()
+	// Not started, so nothing leaves the queue
+	forwarder := NewForwarder("http://upstream.invalid", nil, 1, metrics)
+	forwarder.Forward(signalMetrics, []byte("first"))
+	forwarder.Forward(signalMetrics, []byte("second"))
+
+	if want := `otis_forward_requests_total{result="dropped",signal="metrics"} 1`; !strings.Contains(metricsOutput(metrics), want) {
+		t.Errorf("Expected metrics output to contain %q", want)
+	}
+}
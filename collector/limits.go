@@ -0,0 +1,34 @@
+package collector
+
+// RequestLimits bounds the size of a single OTLP export request.
+// Zero values disable the corresponding limit.
+type RequestLimits struct {
+	// MaxBytes caps the request body size; larger bodies get 413
+	MaxBytes int64
+
+	// MaxResources caps the resource entries written per JSONL line;
+	// larger batches are split across several lines
+	MaxResources int
+
+	// MaxRecords caps the spans, data points or log records per request;
+	// larger requests get 413
+	MaxRecords int64
+}
+
+// chunkResources splits resource entries into batches of at most max.
+// A max of zero or less keeps everything in one batch.
+func chunkResources[T any](resources []T, max int) [][]T {
+	if max <= 0 || len(resources) <= max {
+		return [][]T{resources}
+	}
+
+	var chunks [][]T
+	for start := 0; start < len(resources); start += max {
+		end := start + max
+		if end > len(resources) {
+			end = len(resources)
+		}
+		chunks = append(chunks, resources[start:end])
+	}
+	return chunks
+}
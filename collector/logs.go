@@ -2,74 +2,71 @@ package collector
 
 import (
 	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"time"
 
 	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
-	"google.golang.org/protobuf/encoding/protojson"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
 	"google.golang.org/protobuf/proto"
 )
 
 type LogsHandler struct {
-	writer *FileWriter
+	*otlpHandler[*logsv1.ExportLogsServiceRequest, *logspb.ResourceLogs]
 }
 
-func NewLogsHandler(writer *FileWriter) *LogsHandler {
-	return &LogsHandler{
-		writer: writer,
-	}
-}
-
-func (h *LogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Failed to read request body: %v", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
-
-	req := &logsv1.ExportLogsServiceRequest{}
-	if err := proto.Unmarshal(body, req); err != nil {
-		log.Printf("Failed to unmarshal logs request: %v", err)
-		http.Error(w, "Failed to unmarshal request", http.StatusBadRequest)
-		return
-	}
-
-	jsonData := protojson.MarshalOptions{
-		Multiline:       false,
-		Indent:          "",
-		EmitUnpopulated: false,
-	}.Format(req)
+// logsSignal handles logs export requests, counting their log records
+var logsSignal = otlpSignal[*logsv1.ExportLogsServiceRequest, *logspb.ResourceLogs]{
+	name:      signalLogs,
+	title:     "Logs",
+	noun:      "logs",
+	records:   "log records",
+	resources: "resource logs",
 
-	if err := h.writer.WriteLine(jsonData); err != nil {
-		log.Printf("Failed to write logs data: %v", err)
-		http.Error(w, "Failed to write data", http.StatusInternalServerError)
-		return
-	}
-
-	resp := &logsv1.ExportLogsServiceResponse{}
-	respData, err := proto.Marshal(resp)
-	if err != nil {
-		log.Printf("Failed to marshal response: %v", err)
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
-		return
-	}
+	newRequest: func() *logsv1.ExportLogsServiceRequest { return &logsv1.ExportLogsServiceRequest{} },
+	getResources: func(req *logsv1.ExportLogsServiceRequest) []*logspb.ResourceLogs {
+		return req.ResourceLogs
+	},
+	withResources: func(resources []*logspb.ResourceLogs) *logsv1.ExportLogsServiceRequest {
+		return &logsv1.ExportLogsServiceRequest{ResourceLogs: resources}
+	},
+	count:      countLogRecords,
+	splitByOrg: splitLogsByOrg,
+	anonymize:  anonymizeLogs,
+	consume: func(sink Sink, req *logsv1.ExportLogsServiceRequest, receivedAt time.Time, batch *BatchContext) {
+		sink.ConsumeLogs(req, receivedAt, batch)
+	},
+	respond: func(rejected int64, writeErr error) proto.Message {
+		resp := &logsv1.ExportLogsServiceResponse{}
+		if writeErr != nil {
+			resp.PartialSuccess = &logsv1.ExportLogsPartialSuccess{
+				RejectedLogRecords: rejected,
+				ErrorMessage:       partialSuccessMessage(writeErr),
+			}
+		}
+		return resp
+	},
+}
 
-	w.Header().Set("Content-Type", "application/x-protobuf")
-	if _, err := w.Write(respData); err != nil {
-		log.Printf("Failed to write response: %v", err)
-	}
+func NewLogsHandler(writer LineWriter, limits RequestLimits, metrics *Metrics) *LogsHandler {
+	return &LogsHandler{newOTLPHandler(logsSignal, writer, limits, metrics)}
+}
 
-	log.Printf("Received and stored logs data with %d resource logs", len(req.ResourceLogs))
+// SetRedactor redacts attribute values before requests are written, passed
+// to the sink or forwarded
+func (h *LogsHandler) SetRedactor(redactor *Redactor) {
+	h.redact = redactor.redactLogs
 }
 
 func (h *LogsHandler) String() string {
 	return fmt.Sprintf("LogsHandler{writer: %v}", h.writer)
 }
+
+// countLogRecords returns the number of log records carried by a logs export request
+func countLogRecords(req *logsv1.ExportLogsServiceRequest) int64 {
+	var count int64
+	for _, rl := range req.GetResourceLogs() {
+		for _, sl := range rl.GetScopeLogs() {
+			count += int64(len(sl.GetLogRecords()))
+		}
+	}
+	return count
+}
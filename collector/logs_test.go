@@ -0,0 +1,44 @@
+package collector
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	v1 "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestLogsHandlerPartialSuccessOnWriteFailure(t *testing.T) {
+	body, err := proto.Marshal(&logsv1.ExportLogsServiceRequest{
+		ResourceLogs: []*v1.ResourceLogs{{ScopeLogs: []*v1.ScopeLogs{{
+			LogRecords: []*v1.LogRecord{{}, {}, {}, {}},
+		}}}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	handler := NewLogsHandler(newFailingWriter(t, "logs.jsonl"), RequestLimits{MaxBytes: 1 << 20}, NewMetrics())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/logs", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	resp := &logsv1.ExportLogsServiceResponse{}
+	if err := proto.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.PartialSuccess == nil {
+		t.Fatal("Expected partial success in response")
+	}
+	if resp.PartialSuccess.RejectedLogRecords != 4 {
+		t.Errorf("Expected 4 rejected log records, got %d", resp.PartialSuccess.RejectedLogRecords)
+	}
+	if resp.PartialSuccess.ErrorMessage == "" {
+		t.Error("Expected an error message")
+	}
+}
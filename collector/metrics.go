@@ -2,74 +2,71 @@ package collector
 
 import (
 	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"time"
 
 	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
-	"google.golang.org/protobuf/encoding/protojson"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
 	"google.golang.org/protobuf/proto"
 )
 
 type MetricsHandler struct {
-	writer *FileWriter
+	*otlpHandler[*metricsv1.ExportMetricsServiceRequest, *metricspb.ResourceMetrics]
 }
 
-func NewMetricsHandler(writer *FileWriter) *MetricsHandler {
-	return &MetricsHandler{
-		writer: writer,
-	}
-}
-
-func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Failed to read request body: %v", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
-
-	req := &metricsv1.ExportMetricsServiceRequest{}
-	if err := proto.Unmarshal(body, req); err != nil {
-		log.Printf("Failed to unmarshal metrics request: %v", err)
-		http.Error(w, "Failed to unmarshal request", http.StatusBadRequest)
-		return
-	}
-
-	jsonData := protojson.MarshalOptions{
-		Multiline:       false,
-		Indent:          "",
-		EmitUnpopulated: false,
-	}.Format(req)
+// metricsSignal handles metrics export requests, counting their data points
+var metricsSignal = otlpSignal[*metricsv1.ExportMetricsServiceRequest, *metricspb.ResourceMetrics]{
+	name:      signalMetrics,
+	title:     "Metrics",
+	noun:      "metrics",
+	records:   "data points",
+	resources: "resource metrics",
 
-	if err := h.writer.WriteLine(jsonData); err != nil {
-		log.Printf("Failed to write metrics data: %v", err)
-		http.Error(w, "Failed to write data", http.StatusInternalServerError)
-		return
-	}
-
-	resp := &metricsv1.ExportMetricsServiceResponse{}
-	respData, err := proto.Marshal(resp)
-	if err != nil {
-		log.Printf("Failed to marshal response: %v", err)
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/x-protobuf")
-	if _, err := w.Write(respData); err != nil {
-		log.Printf("Failed to write response: %v", err)
-	}
+	newRequest: func() *metricsv1.ExportMetricsServiceRequest { return &metricsv1.ExportMetricsServiceRequest{} },
+	getResources: func(req *metricsv1.ExportMetricsServiceRequest) []*metricspb.ResourceMetrics {
+		return req.ResourceMetrics
+	},
+	withResources: func(resources []*metricspb.ResourceMetrics) *metricsv1.ExportMetricsServiceRequest {
+		return &metricsv1.ExportMetricsServiceRequest{ResourceMetrics: resources}
+	},
+	count:      countDataPoints,
+	splitByOrg: splitMetricsByOrg,
+	anonymize:  anonymizeMetrics,
+	consume: func(sink Sink, req *metricsv1.ExportMetricsServiceRequest, receivedAt time.Time, batch *BatchContext) {
+		sink.ConsumeMetrics(req, receivedAt, batch)
+	},
+	respond: func(rejected int64, writeErr error) proto.Message {
+		resp := &metricsv1.ExportMetricsServiceResponse{}
+		if writeErr != nil {
+			resp.PartialSuccess = &metricsv1.ExportMetricsPartialSuccess{
+				RejectedDataPoints: rejected,
+				ErrorMessage:       partialSuccessMessage(writeErr),
+			}
+		}
+		return resp
+	},
+}
 
-	log.Printf("Received and stored metrics data with %d resource metrics", len(req.ResourceMetrics))
+func NewMetricsHandler(writer LineWriter, limits RequestLimits, metrics *Metrics) *MetricsHandler {
+	return &MetricsHandler{newOTLPHandler(metricsSignal, writer, limits, metrics)}
 }
 
 func (h *MetricsHandler) String() string {
 	return fmt.Sprintf("MetricsHandler{writer: %v}", h.writer)
 }
+
+// countDataPoints returns the number of data points carried by a metrics export request
+func countDataPoints(req *metricsv1.ExportMetricsServiceRequest) int64 {
+	var count int64
+	for _, rm := range req.GetResourceMetrics() {
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				count += int64(len(m.GetGauge().GetDataPoints()))
+				count += int64(len(m.GetSum().GetDataPoints()))
+				count += int64(len(m.GetHistogram().GetDataPoints()))
+				count += int64(len(m.GetExponentialHistogram().GetDataPoints()))
+				count += int64(len(m.GetSummary().GetDataPoints()))
+			}
+		}
+	}
+	return count
+}
@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	v1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCountDataPoints(t *testing.T) {
+	req := &metricsv1.ExportMetricsServiceRequest{
+		ResourceMetrics: []*v1.ResourceMetrics{{
+			ScopeMetrics: []*v1.ScopeMetrics{{
+				Metrics: []*v1.Metric{
+					{Name: "sum", Data: &v1.Metric_Sum{Sum: &v1.Sum{
+						DataPoints: []*v1.NumberDataPoint{{}, {}},
+					}}},
+					{Name: "gauge", Data: &v1.Metric_Gauge{Gauge: &v1.Gauge{
+						DataPoints: []*v1.NumberDataPoint{{}},
+					}}},
+					{Name: "histogram", Data: &v1.Metric_Histogram{Histogram: &v1.Histogram{
+						DataPoints: []*v1.HistogramDataPoint{{}, {}, {}},
+					}}},
+				},
+			}},
+		}},
+	}
+
+	if got := countDataPoints(req); got != 6 {
+		t.Errorf("Expected 6 data points, got %d", got)
+	}
+}
+
+func TestMetricsHandlerPartialSuccessOnWriteFailure(t *testing.T) {
+	body, err := proto.Marshal(&metricsv1.ExportMetricsServiceRequest{
+		ResourceMetrics: []*v1.ResourceMetrics{{ScopeMetrics: []*v1.ScopeMetrics{{Metrics: []*v1.Metric{
+			{Name: "claude_code.cost.usage", Data: &v1.Metric_Sum{Sum: &v1.Sum{
+				DataPoints: []*v1.NumberDataPoint{{}, {}},
+			}}},
+		}}}}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	handler := NewMetricsHandler(newFailingWriter(t, "metrics.jsonl"), RequestLimits{MaxBytes: 1 << 20}, NewMetrics())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	resp := &metricsv1.ExportMetricsServiceResponse{}
+	if err := proto.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.PartialSuccess == nil {
+		t.Fatal("Expected partial success in response")
+	}
+	if resp.PartialSuccess.RejectedDataPoints != 2 {
+		t.Errorf("Expected 2 rejected data points, got %d", resp.PartialSuccess.RejectedDataPoints)
+	}
+	if resp.PartialSuccess.ErrorMessage == "" {
+		t.Error("Expected an error message")
+	}
+}
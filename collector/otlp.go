@@ -0,0 +1,288 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/zmack/otis/anonymize"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpSignal describes one OTLP signal to otlpHandler: its export request
+// type Req, the resource type R it carries, and how to count, split,
+// rewrite and answer for them
+type otlpSignal[Req proto.Message, R any] struct {
+	name      string // signalTraces, signalMetrics or signalLogs
+	title     string // "Trace", for the timing log line
+	noun      string // "trace", for log lines
+	records   string // "spans", what the signal's records are called
+	resources string // "resource spans", what its resources are called
+
+	newRequest    func() Req
+	getResources  func(Req) []R
+	withResources func([]R) Req
+	count         func(Req) int64
+	splitByOrg    func([]R) []orgPart[R]
+	anonymize     func(*anonymize.Hasher, Req) bool
+	consume       func(Sink, Req, time.Time, *BatchContext)
+
+	// respond builds the response, reporting rejected records as a partial
+	// success when writeErr is set
+	respond func(rejected int64, writeErr error) proto.Message
+}
+
+// otlpHandler accepts OTLP/HTTP export requests for one signal, writing each
+// as JSONL envelopes and passing it on to the sink and forwarder. The trace,
+// metrics and logs handlers wrap one each.
+type otlpHandler[Req proto.Message, R any] struct {
+	signal otlpSignal[Req, R]
+
+	writer  LineWriter // nil when nothing is written
+	limits  RequestLimits
+	metrics *Metrics
+	sink    Sink
+
+	forwarder  *Forwarder
+	anonymizer *anonymize.Hasher
+	debug      bool
+	tagBatches bool
+
+	// redact rewrites requests in place before they are anonymized,
+	// reporting whether anything changed; nil redacts nothing
+	redact func(Req) bool
+
+	// orgs partitions the written lines by organization; nil writes them
+	// all to writer
+	orgs *orgWriters
+
+	// tee, when set, gets a copy of every line written; nil copies nothing
+	tee *teeWriter
+}
+
+func newOTLPHandler[Req proto.Message, R any](signal otlpSignal[Req, R], writer LineWriter, limits RequestLimits, metrics *Metrics) *otlpHandler[Req, R] {
+	return &otlpHandler[Req, R]{
+		signal:  signal,
+		writer:  writer,
+		limits:  limits,
+		metrics: metrics,
+	}
+}
+
+// SetSink passes each accepted request on to sink as well
+func (h *otlpHandler[Req, R]) SetSink(sink Sink) {
+	h.sink = sink
+}
+
+// SetBatchTagging tags the lines written for each request with a batch ID
+// and the request's W3C trace context headers
+func (h *otlpHandler[Req, R]) SetBatchTagging(enabled bool) {
+	h.tagBatches = enabled
+}
+
+// SetDebug logs how long each request spends in each phase
+func (h *otlpHandler[Req, R]) SetDebug(enabled bool) {
+	h.debug = enabled
+}
+
+// SetForwarder relays each fully persisted request to an upstream collector
+func (h *otlpHandler[Req, R]) SetForwarder(forwarder *Forwarder) {
+	h.forwarder = forwarder
+}
+
+// SetAnonymizer replaces user and organization IDs with their hashes before
+// requests are written, passed to the sink or forwarded
+func (h *otlpHandler[Req, R]) SetAnonymizer(anonymizer *anonymize.Hasher) {
+	h.anonymizer = anonymizer
+}
+
+// setOrgWriters writes each organization's records to its own file
+func (h *otlpHandler[Req, R]) setOrgWriters(orgs *orgWriters) {
+	h.orgs = orgs
+}
+
+// setTee copies every line written to tee as well
+func (h *otlpHandler[Req, R]) setTee(tee *teeWriter) {
+	h.tee = tee
+}
+
+// splitByOrg splits resources by organization when output is partitioned,
+// and otherwise returns them as a single part
+func (h *otlpHandler[Req, R]) splitByOrg(resources []R) []orgPart[R] {
+	if h.orgs == nil {
+		return []orgPart[R]{{resources: resources}}
+	}
+	return h.signal.splitByOrg(resources)
+}
+
+// writeLine writes a line holding org's records to its file, then copies it
+// to the tee output
+func (h *otlpHandler[Req, R]) writeLine(org, line string) error {
+	writer := h.writer
+	if h.orgs != nil {
+		orgWriter, err := h.orgs.writer(org)
+		if err != nil {
+			return err
+		}
+		writer = orgWriter
+	}
+	if err := writer.WriteLine(line); err != nil {
+		return err
+	}
+	h.tee.copyLine(line)
+	return nil
+}
+
+func (h *otlpHandler[Req, R]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	signal := h.signal
+	h.metrics.recordRequest(signal.name)
+	timer := newPhaseTimer(h.metrics, signal.name)
+	if h.debug {
+		defer func() { log.Printf("%s request timing: %s", signal.title, timer) }()
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.limits.MaxBytes)
+	body, err := io.ReadAll(r.Body)
+	timer.end(phaseRead)
+	h.metrics.recordRequestBytes(signal.name, len(body))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Printf("Rejected request body larger than %d bytes", maxBytesErr.Limit)
+			writeStatus(w, http.StatusRequestEntityTooLarge, codes.ResourceExhausted, "request body too large")
+			return
+		}
+		log.Printf("Failed to read request body: %v", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	req := signal.newRequest()
+	err = proto.Unmarshal(body, req)
+	timer.end(phaseUnmarshal)
+	if err != nil {
+		log.Printf("Failed to unmarshal %s request: %v", signal.noun, err)
+		h.metrics.recordUnmarshalError(signal.name)
+		http.Error(w, "Failed to unmarshal request", http.StatusBadRequest)
+		return
+	}
+
+	records := signal.count(req)
+	h.metrics.recordReceived(signal.name, records)
+
+	if h.limits.MaxRecords > 0 && records > h.limits.MaxRecords {
+		log.Printf("Rejected %s request with %d %s (limit %d)", signal.noun, records, signal.records, h.limits.MaxRecords)
+		writeStatus(w, http.StatusRequestEntityTooLarge, codes.ResourceExhausted,
+			fmt.Sprintf("request has %d %s, limit is %d", records, signal.records, h.limits.MaxRecords))
+		return
+	}
+
+	// A full write queue means the disk is falling behind; turn the request
+	// away now rather than after it has waited out the exporter's timeout
+	if h.writer != nil {
+		release, ok := reserveWrite(w, h.writer, h.metrics, signal.name)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
+	// Redact and anonymize before anything leaves the handler; the
+	// forwarder then relays the rewritten request instead of the body as
+	// received
+	redacted := h.redact != nil && h.redact(req)
+	if signal.anonymize(h.anonymizer, req) || redacted {
+		if body, err = proto.Marshal(req); err != nil {
+			log.Printf("Failed to marshal rewritten %s request: %v", signal.noun, err)
+			body = nil
+		}
+	}
+
+	// Large batches are split across lines so no single JSONL line grows
+	// unbounded. A failed write rejects that line's records; report them as
+	// a partial success so exporters can surface the rejection instead of
+	// retrying forever. Once the disk is full the remaining parts aren't
+	// tried, and the request is only turned away for a retry if no part of
+	// it was accepted, so a retry never duplicates lines or sink records.
+	receivedAt := time.Now()
+	var batch *BatchContext
+	if h.tagBatches {
+		batch = newBatchContext(r)
+	}
+	var rejected int64
+	var writeErr error
+	accepted := false
+	for _, resources := range chunkResources(signal.getResources(req), h.limits.MaxResources) {
+		for _, org := range h.splitByOrg(resources) {
+			part := signal.withResources(org.resources)
+			if errors.Is(writeErr, ErrDiskFull) {
+				rejected += signal.count(part)
+				continue
+			}
+			if h.writer != nil {
+				jsonData := envelopeLine(receivedAt, batch, protojson.MarshalOptions{
+					Multiline:       false,
+					Indent:          "",
+					EmitUnpopulated: false,
+				}.Format(part))
+
+				err := h.writeLine(org.org, jsonData)
+				h.metrics.recordWrite(signal.name, len(jsonData)+1, err)
+				if err != nil {
+					rejected += signal.count(part)
+					writeErr = err
+					continue
+				}
+			}
+			accepted = true
+			if h.sink != nil {
+				signal.consume(h.sink, part, receivedAt, batch)
+			}
+		}
+	}
+	timer.end(phaseWrite)
+	if !accepted && rejectDiskFull(w, signal.name, writeErr) {
+		return
+	}
+	if writeErr != nil {
+		log.Printf("Failed to write %s data: %v", signal.noun, writeErr)
+	} else if h.forwarder != nil && body != nil {
+		// Only fully persisted requests are relayed, as received but rewritten
+		h.forwarder.Forward(signal.name, body)
+	}
+
+	respData, err := proto.Marshal(signal.respond(rejected, writeErr))
+	if err != nil {
+		log.Printf("Failed to marshal response: %v", err)
+		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	if _, err := w.Write(respData); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+
+	if writeErr != nil {
+		log.Printf("Rejected %s data with %d %s", signal.noun, rejected, signal.records)
+		return
+	}
+
+	log.Printf("Received and stored %s data with %d %s", signal.noun, len(signal.getResources(req)), signal.resources)
+}
+
+// partialSuccessMessage is the error message reported with rejected records
+func partialSuccessMessage(writeErr error) string {
+	return fmt.Sprintf("failed to write data: %v", writeErr)
+}
@@ -0,0 +1,251 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpTestSignal builds requests for one signal and reads its responses, so
+// the same handler behavior can be checked across all three
+type otlpTestSignal struct {
+	name       string
+	path       string
+	newHandler func(LineWriter, RequestLimits, *Metrics) http.Handler
+	// request carries records spread over resources
+	request func(resources, records int) proto.Message
+	// count counts the records in a written line's payload
+	count func(t *testing.T, data []byte) int64
+	// rejected reads the rejected record count from a response
+	rejected func(t *testing.T, body []byte) int64
+}
+
+var otlpTestSignals = []otlpTestSignal{
+	{
+		name: signalTraces,
+		path: "/v1/traces",
+		newHandler: func(w LineWriter, l RequestLimits, m *Metrics) http.Handler {
+			return NewTraceHandler(w, l, m)
+		},
+		request: func(resources, records int) proto.Message {
+			req := &tracev1.ExportTraceServiceRequest{}
+			for r := 0; r < resources; r++ {
+				scope := &tracepb.ScopeSpans{}
+				for i := 0; i < records/resources; i++ {
+					scope.Spans = append(scope.Spans, &tracepb.Span{Name: "span"})
+				}
+				req.ResourceSpans = append(req.ResourceSpans, &tracepb.ResourceSpans{ScopeSpans: []*tracepb.ScopeSpans{scope}})
+			}
+			return req
+		},
+		count: func(t *testing.T, data []byte) int64 {
+			req := &tracev1.ExportTraceServiceRequest{}
+			if err := protojson.Unmarshal(data, req); err != nil {
+				t.Fatalf("Failed to parse written line: %v", err)
+			}
+			return countSpans(req)
+		},
+		rejected: func(t *testing.T, body []byte) int64 {
+			resp := &tracev1.ExportTraceServiceResponse{}
+			if err := proto.Unmarshal(body, resp); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return resp.GetPartialSuccess().GetRejectedSpans()
+		},
+	},
+	{
+		name: signalMetrics,
+		path: "/v1/metrics",
+		newHandler: func(w LineWriter, l RequestLimits, m *Metrics) http.Handler {
+			return NewMetricsHandler(w, l, m)
+		},
+		request: func(resources, records int) proto.Message {
+			req := &metricsv1.ExportMetricsServiceRequest{}
+			for r := 0; r < resources; r++ {
+				sum := &metricspb.Sum{}
+				for i := 0; i < records/resources; i++ {
+					sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{})
+				}
+				req.ResourceMetrics = append(req.ResourceMetrics, &metricspb.ResourceMetrics{ScopeMetrics: []*metricspb.ScopeMetrics{{
+					Metrics: []*metricspb.Metric{{Name: "sum", Data: &metricspb.Metric_Sum{Sum: sum}}},
+				}}})
+			}
+			return req
+		},
+		count: func(t *testing.T, data []byte) int64 {
+			req := &metricsv1.ExportMetricsServiceRequest{}
+			if err := protojson.Unmarshal(data, req); err != nil {
+				t.Fatalf("Failed to parse written line: %v", err)
+			}
+			return countDataPoints(req)
+		},
+		rejected: func(t *testing.T, body []byte) int64 {
+			resp := &metricsv1.ExportMetricsServiceResponse{}
+			if err := proto.Unmarshal(body, resp); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return resp.GetPartialSuccess().GetRejectedDataPoints()
+		},
+	},
+	{
+		name: signalLogs,
+		path: "/v1/logs",
+		newHandler: func(w LineWriter, l RequestLimits, m *Metrics) http.Handler {
+			return NewLogsHandler(w, l, m)
+		},
+		request: func(resources, records int) proto.Message {
+			req := &logsv1.ExportLogsServiceRequest{}
+			for r := 0; r < resources; r++ {
+				scope := &logspb.ScopeLogs{}
+				for i := 0; i < records/resources; i++ {
+					scope.LogRecords = append(scope.LogRecords, &logspb.LogRecord{})
+				}
+				req.ResourceLogs = append(req.ResourceLogs, &logspb.ResourceLogs{ScopeLogs: []*logspb.ScopeLogs{scope}})
+			}
+			return req
+		},
+		count: func(t *testing.T, data []byte) int64 {
+			req := &logsv1.ExportLogsServiceRequest{}
+			if err := protojson.Unmarshal(data, req); err != nil {
+				t.Fatalf("Failed to parse written line: %v", err)
+			}
+			return countLogRecords(req)
+		},
+		rejected: func(t *testing.T, body []byte) int64 {
+			resp := &logsv1.ExportLogsServiceResponse{}
+			if err := proto.Unmarshal(body, resp); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			return resp.GetPartialSuccess().GetRejectedLogRecords()
+		},
+	},
+}
+
+func (s otlpTestSignal) body(t *testing.T, resources, records int) []byte {
+	t.Helper()
+	body, err := proto.Marshal(s.request(resources, records))
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	return body
+}
+
+func TestOTLPHandlers(t *testing.T) {
+	for _, signal := range otlpTestSignals {
+		t.Run(signal.name, func(t *testing.T) {
+			newWriter := func(t *testing.T) (*FileWriter, string) {
+				filePath := filepath.Join(t.TempDir(), signal.name+".jsonl")
+				writer, err := NewFileWriter(filePath)
+				if err != nil {
+					t.Fatalf("Failed to create writer: %v", err)
+				}
+				return writer, filePath
+			}
+			serve := func(handler http.Handler, method string, body []byte) *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(method, signal.path, bytes.NewReader(body)))
+				return rec
+			}
+
+			t.Run("stores chunked lines", func(t *testing.T) {
+				writer, filePath := newWriter(t)
+				metrics := NewMetrics()
+				handler := signal.newHandler(writer, RequestLimits{MaxBytes: 1 << 20, MaxResources: 2}, metrics)
+
+				rec := serve(handler, http.MethodPost, signal.body(t, 3, 6))
+				if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-protobuf" {
+					t.Fatalf("Expected a protobuf 200, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+				}
+				if got := signal.rejected(t, rec.Body.Bytes()); got != 0 {
+					t.Errorf("Expected nothing rejected, got %d", got)
+				}
+				writer.Close()
+
+				file, err := os.Open(filePath)
+				if err != nil {
+					t.Fatalf("Failed to open output: %v", err)
+				}
+				defer file.Close()
+				var lines int
+				var records int64
+				scanner := bufio.NewScanner(file)
+				for scanner.Scan() {
+					lines++
+					records += signal.count(t, unwrapEnvelope(t, scanner.Text()))
+				}
+				if lines != 2 || records != 6 {
+					t.Errorf("Expected 6 records over 2 lines, got %d over %d", records, lines)
+				}
+				if want := fmt.Sprintf(`otis_records_received_total{signal="%s"} 6`, signal.name); !strings.Contains(metricsOutput(metrics), want) {
+					t.Errorf("Expected %s in metrics", want)
+				}
+			})
+
+			t.Run("rejects other methods", func(t *testing.T) {
+				writer, _ := newWriter(t)
+				handler := signal.newHandler(writer, RequestLimits{MaxBytes: 1 << 20}, NewMetrics())
+				if rec := serve(handler, http.MethodGet, nil); rec.Code != http.StatusMethodNotAllowed {
+					t.Errorf("Expected status 405, got %d", rec.Code)
+				}
+			})
+
+			t.Run("rejects undecodable body", func(t *testing.T) {
+				writer, _ := newWriter(t)
+				metrics := NewMetrics()
+				handler := signal.newHandler(writer, RequestLimits{MaxBytes: 1 << 20}, metrics)
+				if rec := serve(handler, http.MethodPost, []byte("not a protobuf")); rec.Code != http.StatusBadRequest {
+					t.Errorf("Expected status 400, got %d", rec.Code)
+				}
+				if want := fmt.Sprintf(`otis_unmarshal_errors_total{signal="%s"} 1`, signal.name); !strings.Contains(metricsOutput(metrics), want) {
+					t.Errorf("Expected %s in metrics", want)
+				}
+			})
+
+			t.Run("rejects oversized body", func(t *testing.T) {
+				writer, _ := newWriter(t)
+				body := signal.body(t, 1, 50)
+				handler := signal.newHandler(writer, RequestLimits{MaxBytes: int64(len(body) - 1)}, NewMetrics())
+				if rec := serve(handler, http.MethodPost, body); rec.Code != http.StatusRequestEntityTooLarge {
+					t.Errorf("Expected status 413, got %d", rec.Code)
+				}
+			})
+
+			t.Run("rejects too many records", func(t *testing.T) {
+				writer, filePath := newWriter(t)
+				handler := signal.newHandler(writer, RequestLimits{MaxBytes: 1 << 20, MaxRecords: 4}, NewMetrics())
+				if rec := serve(handler, http.MethodPost, signal.body(t, 1, 5)); rec.Code != http.StatusRequestEntityTooLarge {
+					t.Errorf("Expected status 413, got %d", rec.Code)
+				}
+				if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+					t.Errorf("Expected nothing to be written, stat returned %v", err)
+				}
+			})
+
+			t.Run("reports failed writes as partial success", func(t *testing.T) {
+				handler := signal.newHandler(newFailingWriter(t, signal.name+".jsonl"), RequestLimits{MaxBytes: 1 << 20}, NewMetrics())
+				rec := serve(handler, http.MethodPost, signal.body(t, 2, 4))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("Expected status 200, got %d", rec.Code)
+				}
+				if got := signal.rejected(t, rec.Body.Bytes()); got != 4 {
+					t.Errorf("Expected 4 rejected records, got %d", got)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// orgAttribute is the attribute partitioned output is split on. As in the
+// aggregator, the most specific level that sets it wins: the record's own
+// attributes, then its scope's, then its resource's.
+const orgAttribute = "organization.id"
+
+// orgDirPattern matches organization IDs used as directory names as they
+// are. The aggregator accepts the same pattern when discovering them.
+var orgDirPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// orgDirName returns the directory an organization's files are written to:
+// the ID itself when it is a safe path component, otherwise a name derived
+// from its hash, so an ID can never escape the output directory
+func orgDirName(org string) string {
+	if orgDirPattern.MatchString(org) {
+		return org
+	}
+	sum := sha256.Sum256([]byte(org))
+	return "org-" + hex.EncodeToString(sum[:8])
+}
+
+// orgWriters partitions one signal's output by organization, writing each
+// organization's records to <dir>/<org>/<file name> with a FileWriter
+// created on first use. Records without an organization go to base, as do
+// those of organizations first seen once max writers exist: organization IDs
+// come from clients, so they mustn't be able to open files without bound.
+type orgWriters struct {
+	base     *FileWriter
+	dir      string
+	fileName string
+	max      int
+	create   func(org string, filePath string) (*FileWriter, error)
+
+	mu         sync.Mutex
+	writers    map[string]*FileWriter
+	overflowed bool // Whether the cap has been logged
+}
+
+func newOrgWriters(base *FileWriter, dir, fileName string, max int, create func(org, filePath string) (*FileWriter, error)) *orgWriters {
+	return &orgWriters{
+		base:     base,
+		dir:      dir,
+		fileName: fileName,
+		max:      max,
+		create:   create,
+		writers:  make(map[string]*FileWriter),
+	}
+}
+
+// writer returns the writer for org's records
+func (o *orgWriters) writer(org string) (*FileWriter, error) {
+	if org == "" {
+		return o.base, nil
+	}
+
+	name := orgDirName(org)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if w, ok := o.writers[name]; ok {
+		return w, nil
+	}
+	if len(o.writers) >= o.max {
+		if !o.overflowed {
+			log.Printf("Writing %s for organizations past the first %d to the shared file", o.fileName, o.max)
+			o.overflowed = true
+		}
+		return o.base, nil
+	}
+	w, err := o.create(name, filepath.Join(o.dir, name, o.fileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create writer for organization %s: %w", name, err)
+	}
+	o.writers[name] = w
+	return w, nil
+}
+
+// writerSet is every FileWriter the server has, including organizations'
+// writers created while it runs
+type writerSet struct {
+	mu      sync.Mutex
+	writers []*FileWriter
+}
+
+func (s *writerSet) add(w *FileWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writers = append(s.writers, w)
+}
+
+// list returns the writers created so far
+func (s *writerSet) list() []*FileWriter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*FileWriter(nil), s.writers...)
+}
+
+// orgPart is the share of a request's resources belonging to one organization
+type orgPart[T any] struct {
+	org       string
+	resources []T
+}
+
+// orgParts groups resources by organization in the order each is first seen
+type orgParts[T any] struct {
+	parts []orgPart[T]
+	index map[string]int
+}
+
+func (p *orgParts[T]) add(org string, resource T) {
+	if p.index == nil {
+		p.index = make(map[string]int)
+	}
+	i, ok := p.index[org]
+	if !ok {
+		i = len(p.parts)
+		p.index[org] = i
+		p.parts = append(p.parts, orgPart[T]{org: org})
+	}
+	p.parts[i].resources = append(p.parts[i].resources, resource)
+}
+
+// orgSeen collects distinct organizations in the order they are first seen
+type orgSeen []string
+
+func (s *orgSeen) add(org string) {
+	for _, seen := range *s {
+		if seen == org {
+			return
+		}
+	}
+	*s = append(*s, org)
+}
+
+// resolveOrg returns the organization from the first of levels, ordered most
+// specific first, that sets it
+func resolveOrg(levels ...[]*commonpb.KeyValue) string {
+	for _, attrs := range levels {
+		for _, kv := range attrs {
+			if kv.Key == orgAttribute {
+				if org := kv.GetValue().GetStringValue(); org != "" {
+					return org
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// filterByOrg keeps the records whose organization, resolved with the
+// enclosing levels, is org
+func filterByOrg[T interface{ GetAttributes() []*commonpb.KeyValue }](records []T, org string, enclosing ...[]*commonpb.KeyValue) []T {
+	kept := records[:0]
+	for _, record := range records {
+		if resolveOrg(append([][]*commonpb.KeyValue{record.GetAttributes()}, enclosing...)...) == org {
+			kept = append(kept, record)
+		}
+	}
+	return kept
+}
+
+// splitMetricsByOrg splits resources by organization. A resource whose data
+// points all belong to one organization is kept whole; one mixing several is
+// copied once per organization, each copy holding only its data points.
+func splitMetricsByOrg(resources []*metricspb.ResourceMetrics) []orgPart[*metricspb.ResourceMetrics] {
+	var parts orgParts[*metricspb.ResourceMetrics]
+	for _, rm := range resources {
+		orgs := metricsOrgs(rm)
+		if len(orgs) == 1 {
+			parts.add(orgs[0], rm)
+			continue
+		}
+		for _, org := range orgs {
+			part := proto.Clone(rm).(*metricspb.ResourceMetrics)
+			filterMetricsByOrg(part, org)
+			parts.add(org, part)
+		}
+	}
+	return parts.parts
+}
+
+// metricsOrgs lists the organizations of rm's data points, or of rm itself
+// when it has none
+func metricsOrgs(rm *metricspb.ResourceMetrics) []string {
+	resource := rm.GetResource().GetAttributes()
+	var orgs orgSeen
+	for _, sm := range rm.GetScopeMetrics() {
+		scope := sm.GetScope().GetAttributes()
+		for _, m := range sm.GetMetrics() {
+			for _, attrs := range dataPointAttributes(m) {
+				orgs.add(resolveOrg(attrs, scope, resource))
+			}
+		}
+	}
+	if len(orgs) == 0 {
+		orgs.add(resolveOrg(resource))
+	}
+	return orgs
+}
+
+// dataPointAttributes returns the attributes of each of m's data points
+func dataPointAttributes(m *metricspb.Metric) [][]*commonpb.KeyValue {
+	var attrs [][]*commonpb.KeyValue
+	for _, dp := range m.GetGauge().GetDataPoints() {
+		attrs = append(attrs, dp.GetAttributes())
+	}
+	for _, dp := range m.GetSum().GetDataPoints() {
+		attrs = append(attrs, dp.GetAttributes())
+	}
+	for _, dp := range m.GetHistogram().GetDataPoints() {
+		attrs = append(attrs, dp.GetAttributes())
+	}
+	for _, dp := range m.GetExponentialHistogram().GetDataPoints() {
+		attrs = append(attrs, dp.GetAttributes())
+	}
+	for _, dp := range m.GetSummary().GetDataPoints() {
+		attrs = append(attrs, dp.GetAttributes())
+	}
+	return attrs
+}
+
+// filterMetricsByOrg drops the data points of rm not belonging to org,
+// along with any metric or scope left empty
+func filterMetricsByOrg(rm *metricspb.ResourceMetrics, org string) {
+	resource := rm.GetResource().GetAttributes()
+	scopes := rm.ScopeMetrics[:0]
+	for _, sm := range rm.ScopeMetrics {
+		scope := sm.GetScope().GetAttributes()
+		metrics := sm.Metrics[:0]
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case *metricspb.Metric_Gauge:
+				data.Gauge.DataPoints = filterByOrg(data.Gauge.DataPoints, org, scope, resource)
+			case *metricspb.Metric_Sum:
+				data.Sum.DataPoints = filterByOrg(data.Sum.DataPoints, org, scope, resource)
+			case *metricspb.Metric_Histogram:
+				data.Histogram.DataPoints = filterByOrg(data.Histogram.DataPoints, org, scope, resource)
+			case *metricspb.Metric_ExponentialHistogram:
+				data.ExponentialHistogram.DataPoints = filterByOrg(data.ExponentialHistogram.DataPoints, org, scope, resource)
+			case *metricspb.Metric_Summary:
+				data.Summary.DataPoints = filterByOrg(data.Summary.DataPoints, org, scope, resource)
+			}
+			if len(dataPointAttributes(m)) > 0 {
+				metrics = append(metrics, m)
+			}
+		}
+		sm.Metrics = metrics
+		if len(metrics) > 0 {
+			scopes = append(scopes, sm)
+		}
+	}
+	rm.ScopeMetrics = scopes
+}
+
+// splitLogsByOrg splits resources by organization as splitMetricsByOrg does,
+// by log record
+func splitLogsByOrg(resources []*logspb.ResourceLogs) []orgPart[*logspb.ResourceLogs] {
+	var parts orgParts[*logspb.ResourceLogs]
+	for _, rl := range resources {
+		resource := rl.GetResource().GetAttributes()
+		var orgs orgSeen
+		for _, sl := range rl.GetScopeLogs() {
+			for _, lr := range sl.GetLogRecords() {
+				orgs.add(resolveOrg(lr.GetAttributes(), sl.GetScope().GetAttributes(), resource))
+			}
+		}
+		if len(orgs) == 0 {
+			orgs.add(resolveOrg(resource))
+		}
+		if len(orgs) == 1 {
+			parts.add(orgs[0], rl)
+			continue
+		}
+
+		for _, org := range orgs {
+			part := proto.Clone(rl).(*logspb.ResourceLogs)
+			scopes := part.ScopeLogs[:0]
+			for _, sl := range part.ScopeLogs {
+				sl.LogRecords = filterByOrg(sl.LogRecords, org, sl.GetScope().GetAttributes(), resource)
+				if len(sl.LogRecords) > 0 {
+					scopes = append(scopes, sl)
+				}
+			}
+			part.ScopeLogs = scopes
+			parts.add(org, part)
+		}
+	}
+	return parts.parts
+}
+
+// splitTracesByOrg splits resources by organization as splitMetricsByOrg
+// does, by span
+func splitTracesByOrg(resources []*tracepb.ResourceSpans) []orgPart[*tracepb.ResourceSpans] {
+	var parts orgParts[*tracepb.ResourceSpans]
+	for _, rs := range resources {
+		resource := rs.GetResource().GetAttributes()
+		var orgs orgSeen
+		for _, ss := range rs.GetScopeSpans() {
+			for _, span := range ss.GetSpans() {
+				orgs.add(resolveOrg(span.GetAttributes(), ss.GetScope().GetAttributes(), resource))
+			}
+		}
+		if len(orgs) == 0 {
+			orgs.add(resolveOrg(resource))
+		}
+		if len(orgs) == 1 {
+			parts.add(orgs[0], rs)
+			continue
+		}
+
+		for _, org := range orgs {
+			part := proto.Clone(rs).(*tracepb.ResourceSpans)
+			scopes := part.ScopeSpans[:0]
+			for _, ss := range part.ScopeSpans {
+				ss.Spans = filterByOrg(ss.Spans, org, ss.GetScope().GetAttributes(), resource)
+				if len(ss.Spans) > 0 {
+					scopes = append(scopes, ss)
+				}
+			}
+			part.ScopeSpans = scopes
+			parts.add(org, part)
+		}
+	}
+	return parts.parts
+}
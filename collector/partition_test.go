@@ -0,0 +1,155 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zmack/otis/config"
+	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	v1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// orgDataPoint returns a data point for session, tagged with org when set
+func orgDataPoint(session, org string) *v1.NumberDataPoint {
+	attrs := []*commonpb.KeyValue{{Key: "session.id", Value: stringValue(session)}}
+	if org != "" {
+		attrs = append(attrs, &commonpb.KeyValue{Key: orgAttribute, Value: stringValue(org)})
+	}
+	return &v1.NumberDataPoint{Attributes: attrs}
+}
+
+func TestServerPartitionsOutputByOrg(t *testing.T) {
+	dir := t.TempDir()
+	server, err := NewServer(&config.Config{
+		OutputDir:        dir,
+		TraceFileName:    "traces.jsonl",
+		MetricFileName:   "metrics.jsonl",
+		LogFileName:      "logs.jsonl",
+		MaxRequestBytes:  1 << 20,
+		PartitionByOrg:   true,
+		MaxPartitionOrgs: 10,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// One resource mixing organizations, one set at the resource level
+	body, err := proto.Marshal(&metricsv1.ExportMetricsServiceRequest{
+		ResourceMetrics: []*v1.ResourceMetrics{
+			{ScopeMetrics: []*v1.ScopeMetrics{{Metrics: []*v1.Metric{{
+				Name: "claude_code.cost.usage",
+				Data: &v1.Metric_Sum{Sum: &v1.Sum{DataPoints: []*v1.NumberDataPoint{
+					orgDataPoint("session-acme", "acme"),
+					orgDataPoint("session-none", ""),
+					orgDataPoint("session-unsafe", "../escape"),
+				}}},
+			}}}}},
+			{
+				Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+					{Key: orgAttribute, Value: stringValue("globex")},
+				}},
+				ScopeMetrics: []*v1.ScopeMetrics{{Metrics: []*v1.Metric{{
+					Name: "claude_code.cost.usage",
+					Data: &v1.Metric_Sum{Sum: &v1.Sum{DataPoints: []*v1.NumberDataPoint{
+						orgDataPoint("session-globex", ""),
+					}}},
+				}}}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+
+	for path, session := range map[string]string{
+		"metrics.jsonl":                            "session-none",
+		"acme/metrics.jsonl":                       "session-acme",
+		"globex/metrics.jsonl":                     "session-globex",
+		orgDirName("../escape") + "/metrics.jsonl": "session-unsafe",
+	} {
+		data := readFile(t, filepath.Join(dir, path))
+		if lines := strings.Split(strings.TrimSpace(data), "\n"); len(lines) != 1 {
+			t.Errorf("Expected one line in %s, got %q", path, data)
+		}
+		if strings.Count(data, `"session-`) != 1 || !strings.Contains(data, session) {
+			t.Errorf("Expected only %s in %s, got %q", session, path, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written outside the output directory, got %v", err)
+	}
+
+	var names []string
+	for _, w := range server.writers.list() {
+		names = append(names, w.name)
+	}
+	if len(names) != 6 {
+		t.Errorf("Expected the organizations' writers listed with the shared ones, got %v", names)
+	}
+}
+
+func TestServerSendsOrgsPastTheLimitToTheSharedFile(t *testing.T) {
+	dir := t.TempDir()
+	server, err := NewServer(&config.Config{
+		OutputDir:        dir,
+		TraceFileName:    "traces.jsonl",
+		MetricFileName:   "metrics.jsonl",
+		LogFileName:      "logs.jsonl",
+		MaxRequestBytes:  1 << 20,
+		PartitionByOrg:   true,
+		MaxPartitionOrgs: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	for _, org := range []string{"acme", "globex", "acme"} {
+		body, err := proto.Marshal(&metricsv1.ExportMetricsServiceRequest{
+			ResourceMetrics: []*v1.ResourceMetrics{{ScopeMetrics: []*v1.ScopeMetrics{{Metrics: []*v1.Metric{{
+				Name: "claude_code.cost.usage",
+				Data: &v1.Metric_Sum{Sum: &v1.Sum{DataPoints: []*v1.NumberDataPoint{
+					orgDataPoint("session-"+org, org),
+				}}},
+			}}}}}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+	}
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+
+	if data := readFile(t, filepath.Join(dir, "acme", "metrics.jsonl")); strings.Count(data, "session-acme") != 2 {
+		t.Errorf("Expected both acme records in its own file, got %q", data)
+	}
+	if data := readFile(t, filepath.Join(dir, "metrics.jsonl")); strings.Count(data, `"session-`) != 1 || !strings.Contains(data, "session-globex") {
+		t.Errorf("Expected only the globex record in the shared file, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "globex")); !os.IsNotExist(err) {
+		t.Errorf("Expected no directory for an organization past the limit, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// RateLimiter is a token-bucket limiter shared by the OTLP handlers, with a
+// separate bucket per signal so one noisy signal cannot starve the others
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // Tokens added per second
+	burst   float64
+	now     func() time.Time
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows requestsPerSecond per signal, with bursts of up to one second's worth
+func NewRateLimiter(requestsPerSecond int) *RateLimiter {
+	return &RateLimiter{
+		rate:    float64(requestsPerSecond),
+		burst:   math.Max(1, float64(requestsPerSecond)),
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token for signal without blocking. When the bucket is empty
+// it reports how long until the next token is available.
+func (l *RateLimiter) Allow(signal string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket, exists := l.buckets[signal]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[signal] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// rateLimitMiddleware rejects requests for signal with 429 once its bucket is empty
+func rateLimitMiddleware(limiter *RateLimiter, metrics *Metrics, signal string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, wait := limiter.Allow(signal)
+		if !allowed {
+			metrics.recordRateLimited(signal)
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			log.Printf("Rate limited %s request, retry after %ds", signal, retryAfter)
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
+			writeStatus(w, http.StatusTooManyRequests, codes.ResourceExhausted, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
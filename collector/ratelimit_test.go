@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefillsPerSignal(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(2)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.Allow(signalTraces); !ok {
+			t.Fatalf("Expected request %d within the burst to be allowed", i+1)
+		}
+	}
+
+	ok, wait := limiter.Allow(signalTraces)
+	if ok {
+		t.Fatal("Expected request beyond the burst to be rejected")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("Expected a 500ms wait, got %v", wait)
+	}
+
+	// Other signals have their own bucket
+	if ok, _ := limiter.Allow(signalLogs); !ok {
+		t.Error("Expected logs to be unaffected by traces")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := limiter.Allow(signalTraces); !ok {
+		t.Error("Expected a token after refilling")
+	}
+}
+
+func TestRateLimitMiddlewareRejectsWith429(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(1)
+	limiter.now = func() time.Time { return now }
+	metrics := NewMetrics()
+
+	calls := 0
+	handler := rateLimitMiddleware(limiter, metrics, signalMetrics, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/metrics", nil))
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/metrics", nil))
+
+	if calls != 1 {
+		t.Errorf("Expected only the first request to reach the handler, got %d", calls)
+	}
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `otis_requests_rate_limited_total{signal="metrics"} 2`) {
+		t.Errorf("Expected 2 rate limited metrics requests, got:\n%s", rec.Body.String())
+	}
+}
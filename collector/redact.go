@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// redactedValue replaces redacted attribute values. It is what Claude Code
+// itself sends for prompts when prompt logging is off, which the aggregator
+// already skips.
+const redactedValue = "<REDACTED>"
+
+// Redactor blanks the values of configured attributes in log requests, so
+// they never reach the JSONL files, the sink or the forwarded copy
+type Redactor struct {
+	keys map[string]bool
+	hash bool
+}
+
+// NewRedactor redacts the attributes named by keys, at the resource, scope
+// and log record levels. With hash set, a hex SHA-256 of each original value
+// is added as <key>.sha256 so records can still be correlated. It returns nil,
+// which redacts nothing, when keys is empty.
+func NewRedactor(keys []string, hash bool) *Redactor {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	r := &Redactor{keys: make(map[string]bool, len(keys)), hash: hash}
+	for _, key := range keys {
+		r.keys[key] = true
+	}
+	return r
+}
+
+// redactLogs redacts req in place, reporting whether anything changed
+func (r *Redactor) redactLogs(req *logsv1.ExportLogsServiceRequest) bool {
+	if r == nil {
+		return false
+	}
+
+	var changed bool
+	redact := func(attrs []*commonpb.KeyValue) []*commonpb.KeyValue {
+		attrs, c := r.redactAttributes(attrs)
+		changed = changed || c
+		return attrs
+	}
+	for _, rl := range req.GetResourceLogs() {
+		if rl.Resource != nil {
+			rl.Resource.Attributes = redact(rl.Resource.Attributes)
+		}
+		for _, sl := range rl.GetScopeLogs() {
+			if sl.Scope != nil {
+				sl.Scope.Attributes = redact(sl.Scope.Attributes)
+			}
+			for _, lr := range sl.GetLogRecords() {
+				lr.Attributes = redact(lr.Attributes)
+			}
+		}
+	}
+	return changed
+}
+
+// redactAttributes replaces the values of redacted keys in attrs, appending
+// their hashes when configured. Values already redacted are left alone.
+func (r *Redactor) redactAttributes(attrs []*commonpb.KeyValue) ([]*commonpb.KeyValue, bool) {
+	var hashes []*commonpb.KeyValue
+	var changed bool
+	for _, kv := range attrs {
+		if !r.keys[kv.Key] || kv.GetValue().GetStringValue() == redactedValue {
+			continue
+		}
+		if r.hash {
+			hashes = append(hashes, &commonpb.KeyValue{
+				Key:   kv.Key + ".sha256",
+				Value: stringValue(hashValue(kv.GetValue())),
+			})
+		}
+		kv.Value = stringValue(redactedValue)
+		changed = true
+	}
+	return append(attrs, hashes...), changed
+}
+
+// hashValue hashes a string value's text, so the hash of a prompt can be
+// computed from the prompt alone, and any other value's encoding
+func hashValue(value *commonpb.AnyValue) string {
+	var data []byte
+	if s, ok := value.GetValue().(*commonpb.AnyValue_StringValue); ok {
+		data = []byte(s.StringValue)
+	} else {
+		data, _ = proto.MarshalOptions{Deterministic: true}.Marshal(value)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func stringValue(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
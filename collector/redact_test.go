@@ -0,0 +1,142 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	v1 "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const testPrompt = "please refactor the billing module for acme corp"
+
+func newTestPromptRequest(t *testing.T) []byte {
+	t.Helper()
+
+	body, err := proto.Marshal(&logsv1.ExportLogsServiceRequest{
+		ResourceLogs: []*v1.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: stringValue("claude-code")},
+			}},
+			ScopeLogs: []*v1.ScopeLogs{{LogRecords: []*v1.LogRecord{{
+				Body: stringValue("claude_code.user_prompt"),
+				Attributes: []*commonpb.KeyValue{
+					{Key: "session.id", Value: stringValue("session-1")},
+					{Key: "prompt", Value: stringValue(testPrompt)},
+					{Key: "prompt_length", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(len(testPrompt))}}},
+				},
+			}}}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	return body
+}
+
+func TestLogsHandlerRedactsPromptBeforeWriting(t *testing.T) {
+	var mu sync.Mutex
+	var forwarded []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		forwarded = body
+		mu.Unlock()
+	}))
+	defer upstream.Close()
+
+	filePath := filepath.Join(t.TempDir(), "logs.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	metrics := NewMetrics()
+	forwarder := NewForwarder(upstream.URL, nil, 10, metrics)
+	forwarder.Start()
+
+	handler := NewLogsHandler(writer, RequestLimits{MaxBytes: 1 << 20}, metrics)
+	handler.SetRedactor(NewRedactor([]string{"prompt"}, false))
+	handler.SetForwarder(forwarder)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/logs", bytes.NewReader(newTestPromptRequest(t))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if err := forwarder.Stop(context.Background()); err != nil {
+		t.Fatalf("Failed to stop forwarder: %v", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if bytes.Contains(data, []byte("acme")) {
+		t.Errorf("Expected the raw prompt never written, got %s", data)
+	}
+	if !bytes.Contains(data, []byte(redactedValue)) || !bytes.Contains(data, []byte("session-1")) {
+		t.Errorf("Expected the prompt redacted and other attributes kept, got %s", data)
+	}
+	if bytes.Contains(data, []byte(".sha256")) {
+		t.Errorf("Expected no hash unless configured, got %s", data)
+	}
+
+	if len(forwarded) == 0 || bytes.Contains(forwarded, []byte("acme")) {
+		t.Errorf("Expected the redacted request forwarded, got %q", forwarded)
+	}
+}
+
+func TestRedactorHashesOriginalValue(t *testing.T) {
+	req := &logsv1.ExportLogsServiceRequest{}
+	if err := proto.Unmarshal(newTestPromptRequest(t), req); err != nil {
+		t.Fatalf("Failed to unmarshal request: %v", err)
+	}
+
+	if !NewRedactor([]string{"prompt", "service.name"}, true).redactLogs(req) {
+		t.Fatal("Expected the request to be redacted")
+	}
+
+	attrs := map[string]string{}
+	for _, kv := range req.ResourceLogs[0].ScopeLogs[0].LogRecords[0].Attributes {
+		attrs[kv.Key] = kv.GetValue().GetStringValue()
+	}
+	sum := sha256.Sum256([]byte(testPrompt))
+	if attrs["prompt"] != redactedValue || attrs["prompt.sha256"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the prompt redacted with its hash alongside, got %v", attrs)
+	}
+	if got := req.ResourceLogs[0].Resource.Attributes; len(got) != 2 || got[0].GetValue().GetStringValue() != redactedValue {
+		t.Errorf("Expected resource attributes redacted too, got %v", got)
+	}
+
+	// Redacting again leaves redacted values and their hashes as they are
+	if NewRedactor([]string{"prompt"}, true).redactLogs(req) {
+		t.Error("Expected an already redacted request to be unchanged")
+	}
+}
+
+func TestNilRedactorRedactsNothing(t *testing.T) {
+	if NewRedactor(nil, true) != nil {
+		t.Fatal("Expected no redactor without keys")
+	}
+
+	req := &logsv1.ExportLogsServiceRequest{}
+	if err := proto.Unmarshal(newTestPromptRequest(t), req); err != nil {
+		t.Fatalf("Failed to unmarshal request: %v", err)
+	}
+	var redactor *Redactor
+	if redactor.redactLogs(req) {
+		t.Error("Expected a nil redactor to change nothing")
+	}
+}
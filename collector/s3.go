@@ -0,0 +1,532 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultS3SegmentBytes is the size at which a segment is closed and
+	// uploaded
+	DefaultS3SegmentBytes = 64 << 20
+
+	// DefaultS3SegmentInterval is the age at which a segment is closed and
+	// uploaded, however small
+	DefaultS3SegmentInterval = 5 * time.Minute
+
+	// s3MaxBackoff caps the wait between attempts to upload a segment
+	s3MaxBackoff = 5 * time.Minute
+
+	// s3SealCheckInterval is how often open segments are checked for age
+	s3SealCheckInterval = time.Second
+
+	// Spooled segments are written with openSegmentSuffix and renamed to
+	// segmentSuffix once closed, when they are ready to upload
+	segmentSuffix     = ".jsonl"
+	openSegmentSuffix = ".jsonl.open"
+)
+
+// S3 upload outcomes for the self-monitoring counters
+const (
+	s3Uploaded = "uploaded"
+	s3Failed   = "failed"
+)
+
+// S3Config is where an S3Sink uploads to and how it cuts segments
+type S3Config struct {
+	// Endpoint is the base URL of the S3-compatible service; objects are
+	// addressed path-style as Endpoint/Bucket/key
+	Endpoint string
+	Bucket   string
+	Prefix   string
+	Region   string
+
+	// Requests are signed with AWS Signature Version 4 when AccessKeyID is
+	// set, and sent anonymously otherwise
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// SpoolDir holds segments from the first line written to them until
+	// their upload is confirmed, so nothing is lost to a failed upload or a
+	// restart
+	SpoolDir        string
+	SegmentBytes    int64
+	SegmentInterval time.Duration
+}
+
+// S3Sink ships received telemetry to an S3-compatible bucket. Each signal's
+// lines are appended to a segment in the spool directory; a segment is
+// closed once it reaches the size limit or age limit and uploaded as
+// <prefix>/<signal>/<date>/<timestamp>.jsonl. A segment is only removed from
+// the spool once the upload succeeds, and failed uploads are retried with
+// backoff, so data waits on disk rather than being dropped.
+type S3Sink struct {
+	config   S3Config
+	client   *http.Client
+	metrics  *Metrics
+	writers  []*S3Writer
+	backoff  time.Duration
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewS3Sink creates a sink uploading to the bucket in cfg, reporting uploads
+// in metrics. Segments left open by a previous run are closed so they are
+// uploaded with the rest.
+func NewS3Sink(cfg S3Config, metrics *Metrics) (*S3Sink, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("the S3 sink needs an endpoint and a bucket")
+	}
+	if cfg.SpoolDir == "" {
+		return nil, errors.New("the S3 sink needs a spool directory")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.SegmentBytes <= 0 {
+		cfg.SegmentBytes = DefaultS3SegmentBytes
+	}
+	if cfg.SegmentInterval <= 0 {
+		cfg.SegmentInterval = DefaultS3SegmentInterval
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+
+	s := &S3Sink{
+		config:  cfg,
+		client:  &http.Client{Timeout: time.Minute},
+		metrics: metrics,
+		backoff: time.Second,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, signal := range []string{signalTraces, signalMetrics, signalLogs} {
+		dir := filepath.Join(cfg.SpoolDir, signal)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create spool directory: %w", err)
+		}
+		s.writers = append(s.writers, &S3Writer{sink: s, signal: signal, dir: dir})
+	}
+	if err := s.recoverOpenSegments(); err != nil {
+		return nil, fmt.Errorf("failed to recover spooled segments: %w", err)
+	}
+	return s, nil
+}
+
+// Writers returns the trace, metrics and logs writers
+func (s *S3Sink) Writers() (traces, metrics, logs *S3Writer) {
+	return s.writers[0], s.writers[1], s.writers[2]
+}
+
+// recoverOpenSegments closes segments a previous run was writing when it
+// stopped; their lines were accepted, so they are uploaded like any other
+func (s *S3Sink) recoverOpenSegments() error {
+	return filepath.WalkDir(s.config.SpoolDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, openSegmentSuffix) {
+			return err
+		}
+		log.Printf("Recovering unfinished S3 segment %s", path)
+		return os.Rename(path, strings.TrimSuffix(path, openSegmentSuffix)+segmentSuffix)
+	})
+}
+
+// Start closes segments as they age and uploads closed segments until Stop
+// is called
+func (s *S3Sink) Start() {
+	go func() {
+		defer close(s.done)
+
+		tick := s3SealCheckInterval
+		if s.config.SegmentInterval < tick {
+			tick = s.config.SegmentInterval
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		var backoff time.Duration
+		var retryAt time.Time
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				for _, w := range s.writers {
+					if err := w.sealIfOlder(now.Add(-s.config.SegmentInterval)); err != nil {
+						log.Printf("Failed to close S3 segment for %s: %v", w.signal, err)
+					}
+				}
+			case <-s.wake:
+			}
+
+			if time.Now().Before(retryAt) {
+				continue
+			}
+			if err := s.uploadPending(context.Background()); err != nil {
+				backoff = nextS3Backoff(backoff, s.backoff)
+				retryAt = time.Now().Add(backoff)
+				log.Printf("S3 upload failed, retrying in %v: %v", backoff, err)
+				continue
+			}
+			backoff, retryAt = 0, time.Time{}
+		}
+	}()
+}
+
+// Stop closes every open segment and keeps trying to upload what is spooled
+// until it is all uploaded or ctx is done. Anything left stays in the spool
+// directory and is uploaded on the next start. Start must have been called.
+func (s *S3Sink) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return fmt.Errorf("S3 uploader not stopped: %w", ctx.Err())
+	}
+
+	var errs []error
+	for _, w := range s.writers {
+		if err := w.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	var backoff time.Duration
+	for {
+		err := s.uploadPending(ctx)
+		if err == nil {
+			return errors.Join(errs...)
+		}
+		backoff = nextS3Backoff(backoff, s.backoff)
+		select {
+		case <-ctx.Done():
+			return errors.Join(append(errs, fmt.Errorf("segments left in %s for the next start: %w", s.config.SpoolDir, err))...)
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// nextS3Backoff doubles the previous wait, starting at initial and capped at
+// s3MaxBackoff
+func nextS3Backoff(previous, initial time.Duration) time.Duration {
+	if previous == 0 {
+		return initial
+	}
+	return min(previous*2, s3MaxBackoff)
+}
+
+// notify wakes the uploader after a segment is closed
+func (s *S3Sink) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pendingSegments lists closed segments in the spool directory, oldest first
+// within each signal
+func (s *S3Sink) pendingSegments() ([]string, error) {
+	var segments []string
+	err := filepath.WalkDir(s.config.SpoolDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, segmentSuffix) {
+			return err
+		}
+		segments = append(segments, path)
+		return nil
+	})
+	sort.Strings(segments)
+	return segments, err
+}
+
+// uploadPending uploads every closed segment, removing each from the spool
+// once the bucket has it. It stops at the first failure so the caller can
+// back off.
+func (s *S3Sink) uploadPending(ctx context.Context) error {
+	segments, err := s.pendingSegments()
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[string]int)
+	for _, path := range segments {
+		pending[s.segmentSignal(path)]++
+	}
+	defer func() {
+		for _, w := range s.writers {
+			s.metrics.setS3Pending(w.signal, pending[w.signal])
+		}
+	}()
+
+	for _, path := range segments {
+		signal := s.segmentSignal(path)
+		if err := s.upload(ctx, path); err != nil {
+			s.metrics.recordS3Upload(signal, s3Failed)
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove uploaded segment: %w", err)
+		}
+		s.metrics.recordS3Upload(signal, s3Uploaded)
+		pending[signal]--
+	}
+	return nil
+}
+
+// segmentSignal is the signal a spooled segment belongs to, the first
+// directory under the spool
+func (s *S3Sink) segmentSignal(path string) string {
+	rel, _ := filepath.Rel(s.config.SpoolDir, path)
+	signal, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return signal
+}
+
+// objectKey is the key a spooled segment is uploaded under: its path within
+// the spool directory, after the prefix
+func (s *S3Sink) objectKey(path string) string {
+	rel, _ := filepath.Rel(s.config.SpoolDir, path)
+	key := filepath.ToSlash(rel)
+	if s.config.Prefix != "" {
+		key = s.config.Prefix + "/" + key
+	}
+	return key
+}
+
+// upload puts a segment in the bucket
+func (s *S3Sink) upload(ctx context.Context, path string) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	key := s.objectKey(path)
+	url := s.config.Endpoint + "/" + s3Escape(s.config.Bucket) + "/" + s3Escape(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.config.AccessKeyID != "" {
+		if s.config.SessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+		}
+		signS3Request(req, body, s.config.Region, s.config.AccessKeyID, s.config.SecretAccessKey, time.Now())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to upload %s: bucket returned %s: %s", key, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// s3Escape percent-encodes a key for a URL path the way Signature Version 4
+// expects, leaving only unreserved characters and slashes
+func s3Escape(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// signS3Request signs req with AWS Signature Version 4 for the s3 service,
+// covering the host and every header already set on it
+func signS3Request(req *http.Request, body []byte, region, accessKeyID, secretAccessKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// S3Writer appends a signal's JSONL lines to its open segment in the spool
+// directory, closing the segment for upload once it is big or old enough
+type S3Writer struct {
+	mu     sync.Mutex
+	sink   *S3Sink
+	signal string
+	dir    string
+	file   *os.File
+	path   string
+	size   int64
+	opened time.Time
+	closed bool
+}
+
+func (w *S3Writer) WriteJSON(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data to JSON: %w", err)
+	}
+	return w.WriteLine(string(jsonData))
+}
+
+func (w *S3Writer) WriteLine(s string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("S3 writer is closed")
+	}
+	if w.file == nil {
+		if err := w.open(time.Now().UTC()); err != nil {
+			return err
+		}
+	}
+
+	// Cut off whatever part of the line made it, so the segment never holds
+	// a partial line and a retry starts clean
+	n, err := io.WriteString(w.file, s+"\n")
+	if err != nil {
+		w.file.Truncate(w.size)
+		return fmt.Errorf("failed to write to S3 segment: %w", err)
+	}
+	w.size += int64(n)
+	if w.size >= w.sink.config.SegmentBytes {
+		return w.seal()
+	}
+	return nil
+}
+
+// open starts a segment named for when it was opened, under a directory for
+// the day
+func (w *S3Writer) open(now time.Time) error {
+	dir := filepath.Join(w.dir, now.Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	path := filepath.Join(dir, now.Format("20060102T150405.000000000Z")+openSegmentSuffix)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open S3 segment: %w", err)
+	}
+	w.file, w.path, w.size, w.opened = file, path, 0, now
+	return nil
+}
+
+// seal closes the open segment and marks it ready to upload. Callers hold
+// w.mu.
+func (w *S3Writer) seal() error {
+	if w.file == nil {
+		return nil
+	}
+	file, path := w.file, w.path
+	w.file, w.path, w.size = nil, "", 0
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync S3 segment: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close S3 segment: %w", err)
+	}
+	if err := os.Rename(path, strings.TrimSuffix(path, openSegmentSuffix)+segmentSuffix); err != nil {
+		return fmt.Errorf("failed to close S3 segment: %w", err)
+	}
+	w.sink.notify()
+	return nil
+}
+
+// sealIfOlder closes the open segment if it was opened before cutoff
+func (w *S3Writer) sealIfOlder(cutoff time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil || !w.opened.Before(cutoff) {
+		return nil
+	}
+	return w.seal()
+}
+
+// Close closes the open segment for upload; later writes fail
+func (w *S3Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	return w.seal()
+}
@@ -0,0 +1,225 @@
+package collector
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Signal labels for self-monitoring metrics
+const (
+	signalTraces  = "traces"
+	signalMetrics = "metrics"
+	signalLogs    = "logs"
+)
+
+// Metrics holds the collector's self-monitoring Prometheus counters and histograms
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestBytes    *prometheus.CounterVec
+	unmarshalErrors *prometheus.CounterVec
+	recordsReceived *prometheus.CounterVec
+	writeErrors     *prometheus.CounterVec
+	bytesWritten    *prometheus.CounterVec
+	diskFull        *prometheus.GaugeVec
+	teeErrors       *prometheus.CounterVec
+	rateLimited     *prometheus.CounterVec
+	queueDepth      *prometheus.GaugeVec
+	queueRejected   *prometheus.CounterVec
+	forwarded       *prometheus.CounterVec
+	s3Uploads       *prometheus.CounterVec
+	s3Pending       *prometheus.GaugeVec
+	responses       *prometheus.CounterVec
+	phaseDuration   *prometheus.HistogramVec
+}
+
+// NewMetrics creates the collector counters on a dedicated registry
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_requests_total",
+			Help: "Export requests received by the collector, whatever their outcome.",
+		}, []string{"signal"}),
+		requestBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_request_bytes_total",
+			Help: "Bytes of export request bodies read by the collector.",
+		}, []string{"signal"}),
+		unmarshalErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_unmarshal_errors_total",
+			Help: "Export requests rejected because their body was not a valid OTLP protobuf.",
+		}, []string{"signal"}),
+		recordsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_records_received_total",
+			Help: "Spans, data points and log records received by the collector.",
+		}, []string{"signal"}),
+		writeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_write_errors_total",
+			Help: "Failed writes of received data to the output files.",
+		}, []string{"signal"}),
+		bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_bytes_written_total",
+			Help: "Bytes written to the output files.",
+		}, []string{"signal"}),
+		diskFull: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "otis_disk_full",
+			Help: "1 while the last write of a signal to its output file failed because the disk is full.",
+		}, []string{"signal"}),
+		teeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_tee_write_errors_total",
+			Help: "Failed copies of written lines to the tee output directory; the request still succeeds.",
+		}, []string{"signal"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_requests_rate_limited_total",
+			Help: "Requests rejected with 429 by the rate limiter.",
+		}, []string{"signal"}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "otis_write_queue_depth",
+			Help: "Requests holding a place in the output file's write queue.",
+		}, []string{"signal"}),
+		queueRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_write_queue_rejections_total",
+			Help: "Requests rejected with 429 because the output file's write queue was full.",
+		}, []string{"signal"}),
+		forwarded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_forward_requests_total",
+			Help: "Requests relayed to the upstream collector, by result (sent, failed or dropped).",
+		}, []string{"signal", "result"}),
+		s3Uploads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_s3_uploads_total",
+			Help: "Attempts to upload spooled segments to the S3 sink, by result (uploaded or failed).",
+		}, []string{"signal", "result"}),
+		s3Pending: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "otis_s3_pending_segments",
+			Help: "Closed segments held in the spool directory until their upload is confirmed.",
+		}, []string{"signal"}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otis_responses_total",
+			Help: "Responses returned to exporters on the OTLP endpoints, by client IP and status class (2xx, 4xx, 5xx).",
+		}, []string{"client", "class"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "otis_request_phase_duration_seconds",
+			Help:    "Time export requests spend reading the body, unmarshalling it and writing it out.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"signal", "phase"}),
+	}
+
+	m.registry.MustRegister(m.requests, m.requestBytes, m.unmarshalErrors, m.recordsReceived, m.writeErrors, m.bytesWritten, m.diskFull, m.teeErrors, m.rateLimited, m.queueDepth, m.queueRejected, m.forwarded, m.s3Uploads, m.s3Pending, m.responses, m.phaseDuration)
+
+	// Pre-create every signal so the series exist before the first request
+	for _, signal := range []string{signalTraces, signalMetrics, signalLogs} {
+		m.requests.WithLabelValues(signal)
+		m.requestBytes.WithLabelValues(signal)
+		m.unmarshalErrors.WithLabelValues(signal)
+		m.recordsReceived.WithLabelValues(signal)
+		m.writeErrors.WithLabelValues(signal)
+		m.bytesWritten.WithLabelValues(signal)
+		m.diskFull.WithLabelValues(signal)
+		m.teeErrors.WithLabelValues(signal)
+		m.rateLimited.WithLabelValues(signal)
+		m.queueDepth.WithLabelValues(signal)
+		m.queueRejected.WithLabelValues(signal)
+		for _, result := range []string{forwardSent, forwardFailed, forwardDropped} {
+			m.forwarded.WithLabelValues(signal, result)
+		}
+		for _, result := range []string{s3Uploaded, s3Failed} {
+			m.s3Uploads.WithLabelValues(signal, result)
+		}
+		m.s3Pending.WithLabelValues(signal)
+		for _, phase := range []string{phaseRead, phaseUnmarshal, phaseWrite} {
+			m.phaseDuration.WithLabelValues(signal, phase)
+		}
+	}
+
+	return m
+}
+
+// Handler serves the counters in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// recordRequest counts an export request for a signal
+func (m *Metrics) recordRequest(signal string) {
+	m.requests.WithLabelValues(signal).Inc()
+}
+
+// recordRequestBytes counts the request body bytes read for a signal
+func (m *Metrics) recordRequestBytes(signal string, bytes int) {
+	m.requestBytes.WithLabelValues(signal).Add(float64(bytes))
+}
+
+// recordUnmarshalError counts a request whose body failed to decode
+func (m *Metrics) recordUnmarshalError(signal string) {
+	m.unmarshalErrors.WithLabelValues(signal).Inc()
+}
+
+// recordReceived counts records received for a signal
+func (m *Metrics) recordReceived(signal string, count int64) {
+	m.recordsReceived.WithLabelValues(signal).Add(float64(count))
+}
+
+// recordWrite counts the outcome of writing a batch for a signal, tracking
+// whether the disk is full
+func (m *Metrics) recordWrite(signal string, bytes int, err error) {
+	if errors.Is(err, ErrDiskFull) {
+		m.diskFull.WithLabelValues(signal).Set(1)
+	} else {
+		m.diskFull.WithLabelValues(signal).Set(0)
+	}
+	if err != nil {
+		m.writeErrors.WithLabelValues(signal).Inc()
+		return
+	}
+	m.bytesWritten.WithLabelValues(signal).Add(float64(bytes))
+}
+
+// recordTeeError counts a line that could not be copied to the tee output
+func (m *Metrics) recordTeeError(signal string) {
+	m.teeErrors.WithLabelValues(signal).Inc()
+}
+
+// recordRateLimited counts a request rejected by the rate limiter
+func (m *Metrics) recordRateLimited(signal string) {
+	m.rateLimited.WithLabelValues(signal).Inc()
+}
+
+// recordQueued tracks a request taking (delta 1) or giving back (delta -1)
+// a place in a signal's write queue
+func (m *Metrics) recordQueued(signal string, delta float64) {
+	m.queueDepth.WithLabelValues(signal).Add(delta)
+}
+
+// recordQueueRejected counts a request rejected because the write queue was full
+func (m *Metrics) recordQueueRejected(signal string) {
+	m.queueRejected.WithLabelValues(signal).Inc()
+}
+
+// recordForward counts the result of relaying a request upstream
+func (m *Metrics) recordForward(signal, result string) {
+	m.forwarded.WithLabelValues(signal, result).Inc()
+}
+
+// recordS3Upload counts the result of uploading a spooled segment
+func (m *Metrics) recordS3Upload(signal, result string) {
+	m.s3Uploads.WithLabelValues(signal, result).Inc()
+}
+
+// setS3Pending sets how many closed segments of a signal await upload
+func (m *Metrics) setS3Pending(signal string, segments int) {
+	m.s3Pending.WithLabelValues(signal).Set(float64(segments))
+}
+
+// recordResponse counts a response returned to a client
+func (m *Metrics) recordResponse(client, class string) {
+	m.responses.WithLabelValues(client, class).Inc()
+}
+
+// recordPhase observes how long a request spent in one phase
+func (m *Metrics) recordPhase(signal, phase string, d time.Duration) {
+	m.phaseDuration.WithLabelValues(signal, phase).Observe(d.Seconds())
+}
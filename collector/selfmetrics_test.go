@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zmack/otis/config"
+)
+
+func TestMetricsCountReceivedRecordsAndWrites(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := NewFileWriter(filepath.Join(dir, "traces.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	failingWriter, err := NewFileWriter(filepath.Join(dir, "broken.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := os.Mkdir(failingWriter.filePath, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	metrics := NewMetrics()
+	body := newTestTraceRequest(t, 2)
+	for _, handler := range []*TraceHandler{
+		NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20}, metrics),
+		NewTraceHandler(failingWriter, RequestLimits{MaxBytes: 1 << 20}, metrics),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(body)))
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/internal/metrics", nil))
+	output := rec.Body.String()
+
+	for _, want := range []string{
+		`otis_records_received_total{signal="traces"} 4`,
+		`otis_write_errors_total{signal="traces"} 1`,
+		`otis_records_received_total{signal="logs"} 0`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected metrics output to contain %q, got:\n%s", want, output)
+		}
+	}
+
+	data, err := os.ReadFile(writer.filePath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if want := `otis_bytes_written_total{signal="traces"} ` + strconv.Itoa(len(data)); !strings.Contains(output, want) {
+		t.Errorf("Expected metrics output to contain %q, got:\n%s", want, output)
+	}
+}
+
+func TestServerExposesRequestCountersAtMetrics(t *testing.T) {
+	server, err := NewServer(&config.Config{
+		OutputDir:       t.TempDir(),
+		TraceFileName:   "traces.jsonl",
+		MetricFileName:  "metrics.jsonl",
+		LogFileName:     "logs.jsonl",
+		MaxRequestBytes: 1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handler := server.httpServer.Handler
+
+	// Concurrent exports are all counted
+	body := newTestTraceRequest(t, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	garbage := []byte("not a protobuf")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/logs", bytes.NewReader(garbage)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an invalid body, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from /metrics, got %d", rec.Code)
+	}
+	output := rec.Body.String()
+
+	for _, want := range []string{
+		`otis_requests_total{signal="traces"} 10`,
+		`otis_request_bytes_total{signal="traces"} ` + strconv.Itoa(10*len(body)),
+		`otis_records_received_total{signal="traces"} 30`,
+		`otis_unmarshal_errors_total{signal="traces"} 0`,
+		`otis_write_errors_total{signal="traces"} 0`,
+		`otis_requests_total{signal="logs"} 1`,
+		`otis_request_bytes_total{signal="logs"} ` + strconv.Itoa(len(garbage)),
+		`otis_unmarshal_errors_total{signal="logs"} 1`,
+		`otis_requests_total{signal="metrics"} 0`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected metrics output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestPhaseTimingsLoggedAtDebugLevel(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	body := newTestTraceRequest(t, 2)
+	for _, level := range []string{"info", "debug"} {
+		server, err := NewServer(&config.Config{
+			OutputDir:       t.TempDir(),
+			TraceFileName:   "traces.jsonl",
+			MetricFileName:  "metrics.jsonl",
+			LogFileName:     "logs.jsonl",
+			MaxRequestBytes: 1 << 20,
+			LogLevel:        level,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		handler := server.httpServer.Handler
+
+		logs.Reset()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 at %s level, got %d", level, rec.Code)
+		}
+		if logged := strings.Contains(logs.String(), "Trace request timing: read="); logged != (level == "debug") {
+			t.Errorf("Expected timing logged only at debug level, got %v at %s level:\n%s", logged, level, logs.String())
+		}
+
+		// The histograms are kept whatever the level
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		for _, phase := range []string{phaseRead, phaseUnmarshal, phaseWrite} {
+			want := `otis_request_phase_duration_seconds_count{phase="` + phase + `",signal="traces"} 1`
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("Expected metrics output to contain %q at %s level", want, level)
+			}
+		}
+	}
+}
@@ -2,12 +2,22 @@ package collector
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/zmack/otis/accesslog"
+	"github.com/zmack/otis/anonymize"
 	"github.com/zmack/otis/config"
 )
 
@@ -17,38 +27,267 @@ type Server struct {
 	traceHandler   *TraceHandler
 	metricsHandler *MetricsHandler
 	logsHandler    *LogsHandler
+	writers        *writerSet
+	flusher        *Flusher
+	forwarder      *Forwarder
+	s3             *S3Sink
+	api            http.Handler
+
+	// handler serves every request; the access log wraps it, and with the
+	// API mounted, the API too
+	handler      http.Handler
+	accessLog    *accesslog.Logger
+	accessOut    accesslog.LineWriter
+	accessWriter *FileWriter
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
-	traceWriter, err := NewFileWriter(filepath.Join(cfg.OutputDir, cfg.TraceFileName))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create trace writer: %w", err)
+	newWriter := NewFileWriter
+	if cfg.WriteFlushIntervalMS > 0 {
+		newWriter = func(filePath string) (*FileWriter, error) {
+			return NewBufferedFileWriterSize(filePath, cfg.WriteBufferBytes)
+		}
 	}
 
-	metricsWriter, err := NewFileWriter(filepath.Join(cfg.OutputDir, cfg.MetricFileName))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create metrics writer: %w", err)
+	// An unset sink writes files, the default
+	switch cfg.Sink {
+	case "", "file", "stdout", "s3":
+	default:
+		return nil, fmt.Errorf("unknown sink %q (use file, stdout or s3)", cfg.Sink)
 	}
 
-	logsWriter, err := NewFileWriter(filepath.Join(cfg.OutputDir, cfg.LogFileName))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create logs writer: %w", err)
+	// Direct ingestion can skip writing output entirely
+	writeOutput := !cfg.DirectIngest || cfg.DirectIngestWriteFiles
+	var traceWriter, metricsWriter, logsWriter *FileWriter
+	var writers []*FileWriter
+	if writeOutput && (cfg.Sink == "" || cfg.Sink == "file") {
+		var err error
+		traceWriter, err = newWriter(filepath.Join(cfg.OutputDir, cfg.TraceFileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create trace writer: %w", err)
+		}
+
+		metricsWriter, err = newWriter(filepath.Join(cfg.OutputDir, cfg.MetricFileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metrics writer: %w", err)
+		}
+
+		logsWriter, err = newWriter(filepath.Join(cfg.OutputDir, cfg.LogFileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create logs writer: %w", err)
+		}
+
+		writers = []*FileWriter{traceWriter, metricsWriter, logsWriter}
+	}
+
+	// The tee directory gets a best-effort copy of every line written to the
+	// output files, under the same file names
+	var teeWriters []*FileWriter
+	if cfg.TeeOutputDir != "" && len(writers) > 0 {
+		for _, fileName := range []string{cfg.TraceFileName, cfg.MetricFileName, cfg.LogFileName} {
+			w, err := newWriter(filepath.Join(cfg.TeeOutputDir, fileName))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create tee writer: %w", err)
+			}
+			w.name = "tee/" + fileName
+			teeWriters = append(teeWriters, w)
+		}
+	}
+	files := append(append([]*FileWriter(nil), writers...), teeWriters...)
+
+	// The access log file is rotated and flushed like the output files
+	var accessWriter *FileWriter
+	var accessOut accesslog.LineWriter
+	if cfg.AccessLogFile != "" {
+		var err error
+		accessWriter, err = newWriter(cfg.AccessLogFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create access log writer: %w", err)
+		}
+		accessOut = accessWriter
+		files = append(files, accessWriter)
+	}
+
+	configure := func(w *FileWriter) {
+		w.SetSync(cfg.Fsync)
+		w.SetWriteRetry(cfg.WriteRetryAttempts, time.Duration(cfg.WriteRetryBackoffMS)*time.Millisecond)
+		if cfg.MaxFileSizeBytes > 0 {
+			w.SetMaxFileSize(cfg.MaxFileSizeBytes)
+			w.SetRotatedFiles(cfg.MaxRotatedFiles)
+			w.SetCompressRotated(cfg.CompressRotated)
+		}
+	}
+	for _, w := range files {
+		configure(w)
+		w.SetQueueSize(cfg.WriteQueueSize)
+	}
+
+	// Tee copies are best effort: they aren't retried, holding up the
+	// request, and don't count towards readiness
+	isTee := make(map[*FileWriter]bool)
+	for _, w := range teeWriters {
+		w.SetWriteRetry(1, 0)
+		isTee[w] = true
+	}
+
+	// Buffered writers share a single background flusher
+	var flusher *Flusher
+	if cfg.WriteFlushIntervalMS > 0 && len(files) > 0 {
+		interval := time.Duration(cfg.WriteFlushIntervalMS) * time.Millisecond
+		flusher = NewFlusher(interval, files...)
+	}
+	writerSet := &writerSet{writers: files}
+
+	// An organization's writers are set up like the shared ones, except that
+	// requests queue on the shared writer for their signal
+	orgWritersFor := func(base *FileWriter, fileName string) *orgWriters {
+		return newOrgWriters(base, cfg.OutputDir, fileName, cfg.MaxPartitionOrgs, func(org, filePath string) (*FileWriter, error) {
+			w, err := newWriter(filePath)
+			if err != nil {
+				return nil, err
+			}
+			w.name = org + "/" + fileName
+			configure(w)
+			writerSet.add(w)
+			if flusher != nil {
+				flusher.Add(w)
+			}
+			log.Printf("Writing %s for organization %s", fileName, org)
+			return w, nil
+		})
+	}
+
+	metrics := NewMetrics()
+
+	var s3Sink *S3Sink
+	if writeOutput && cfg.Sink == "s3" {
+		var err error
+		s3Sink, err = NewS3Sink(S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Bucket:          cfg.S3Bucket,
+			Prefix:          cfg.S3Prefix,
+			Region:          cfg.S3Region,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			SessionToken:    cfg.S3SessionToken,
+			SpoolDir:        cfg.S3SpoolDir,
+			SegmentBytes:    cfg.S3SegmentBytes,
+			SegmentInterval: time.Duration(cfg.S3SegmentSeconds) * time.Second,
+		}, metrics)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create S3 sink: %w", err)
+		}
+	}
+
+	// Handlers are given no writer at all, rather than a nil file, when
+	// nothing is written
+	var traceOut, metricsOut, logsOut LineWriter
+	switch {
+	case len(writers) > 0:
+		traceOut, metricsOut, logsOut = traceWriter, metricsWriter, logsWriter
+	case s3Sink != nil:
+		traceOut, metricsOut, logsOut = s3Sink.Writers()
+	case writeOutput:
+		traceOut, metricsOut, logsOut = NewStdoutWriters(os.Stdout)
+		log.Println("Writing received telemetry to stdout")
 	}
 
-	traceHandler := NewTraceHandler(traceWriter)
-	metricsHandler := NewMetricsHandler(metricsWriter)
-	logsHandler := NewLogsHandler(logsWriter)
+	limits := RequestLimits{
+		MaxBytes:     cfg.MaxRequestBytes,
+		MaxResources: cfg.MaxResourcesPerRequest,
+		MaxRecords:   cfg.MaxRecordsPerRequest,
+	}
+	traceHandler := NewTraceHandler(traceOut, limits, metrics)
+	metricsHandler := NewMetricsHandler(metricsOut, limits, metrics)
+	logsHandler := NewLogsHandler(logsOut, limits, metrics)
+
+	debug := strings.EqualFold(cfg.LogLevel, "debug")
+	traceHandler.SetDebug(debug)
+	metricsHandler.SetDebug(debug)
+	logsHandler.SetDebug(debug)
+	traceHandler.SetBatchTagging(cfg.TagBatches)
+	metricsHandler.SetBatchTagging(cfg.TagBatches)
+	logsHandler.SetBatchTagging(cfg.TagBatches)
+	if cfg.PartitionByOrg && len(writers) > 0 {
+		traceHandler.setOrgWriters(orgWritersFor(traceWriter, cfg.TraceFileName))
+		metricsHandler.setOrgWriters(orgWritersFor(metricsWriter, cfg.MetricFileName))
+		logsHandler.setOrgWriters(orgWritersFor(logsWriter, cfg.LogFileName))
+	}
+	if len(teeWriters) > 0 {
+		traceHandler.setTee(newTeeWriter(teeWriters[0], metrics, signalTraces))
+		metricsHandler.setTee(newTeeWriter(teeWriters[1], metrics, signalMetrics))
+		logsHandler.setTee(newTeeWriter(teeWriters[2], metrics, signalLogs))
+	}
+	logsHandler.SetRedactor(NewRedactor(cfg.RedactAttributes, cfg.RedactHash))
+	if cfg.AnonymizeIDs {
+		anonymizer := anonymize.New(cfg.AnonymizeSecret)
+		traceHandler.SetAnonymizer(anonymizer)
+		metricsHandler.SetAnonymizer(anonymizer)
+		logsHandler.SetAnonymizer(anonymizer)
+	}
+
+	var forwarder *Forwarder
+	if cfg.ForwardEndpoint != "" {
+		forwarder = NewForwarder(cfg.ForwardEndpoint, cfg.ForwardHeaders, cfg.ForwardQueueSize, metrics)
+		traceHandler.SetForwarder(forwarder)
+		metricsHandler.SetForwarder(forwarder)
+		logsHandler.SetForwarder(forwarder)
+	}
+
+	var traces, metricsIngest, logs http.Handler = traceHandler, metricsHandler, logsHandler
+	if cfg.MaxRequestsPerSecond > 0 {
+		limiter := NewRateLimiter(cfg.MaxRequestsPerSecond)
+		traces = rateLimitMiddleware(limiter, metrics, signalTraces, traces)
+		metricsIngest = rateLimitMiddleware(limiter, metrics, signalMetrics, metricsIngest)
+		logs = rateLimitMiddleware(limiter, metrics, signalLogs, logs)
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/v1/traces", traceHandler)
-	mux.Handle("/v1/metrics", metricsHandler)
-	mux.Handle("/v1/logs", logsHandler)
+	mux.Handle("/v1/traces", traces)
+	mux.Handle("/v1/metrics", metricsIngest)
+	mux.Handle("/v1/logs", logs)
+	mux.Handle("/internal/metrics", metrics.Handler())
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("/ready", readyHandler(func() []*FileWriter {
+		var primary []*FileWriter
+		for _, w := range writerSet.list() {
+			if !isTee[w] && w != accessWriter {
+				primary = append(primary, w)
+			}
+		}
+		return primary
+	}))
+	clientStats := NewClientStats(metrics, cfg.ClientErrorsPerMinute)
+	clientStats.SetWriters(writerSet.list)
+	mux.HandleFunc("/stats", clientStats.handleStats)
+	mux.HandleFunc("/admin/rotate", rotateHandler(cfg.AdminToken, writerSet.list))
 
+	var handler http.Handler = mux
+	if cfg.IngestToken != "" {
+		handler = authMiddleware(cfg.IngestToken, handler)
+	}
+	handler = clientStatsMiddleware(clientStats, handler)
+	accessLog := accesslog.New(accessOut)
+
+	httpLimits := cfg.CollectorHTTP
 	httpServer := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.ServerPort),
-		Handler:      loggingMiddleware(mux),
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Addr:              net.JoinHostPort(cfg.BindAddr, strconv.Itoa(cfg.ServerPort)),
+		Handler:           accessLog.Middleware("collector", handler),
+		ReadTimeout:       time.Duration(httpLimits.ReadTimeoutSeconds) * time.Second,
+		ReadHeaderTimeout: time.Duration(httpLimits.ReadHeaderTimeoutSeconds) * time.Second,
+		WriteTimeout:      time.Duration(httpLimits.WriteTimeoutSeconds) * time.Second,
+		IdleTimeout:       time.Duration(httpLimits.IdleTimeoutSeconds) * time.Second,
+		MaxHeaderBytes:    httpLimits.MaxHeaderBytes,
+	}
+
+	// Load certificates up front so a bad path fails at startup, not on first request
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		httpServer.TLSConfig = tlsConfig
 	}
 
 	return &Server{
@@ -57,17 +296,128 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		traceHandler:   traceHandler,
 		metricsHandler: metricsHandler,
 		logsHandler:    logsHandler,
+		writers:        writerSet,
+		flusher:        flusher,
+		forwarder:      forwarder,
+		s3:             s3Sink,
+		handler:        handler,
+		accessLog:      accessLog,
+		accessOut:      accessOut,
+		accessWriter:   accessWriter,
 	}, nil
 }
 
+// SetSink hands every accepted OTLP request to sink, such as the aggregator
+// engine, in addition to any file writes. It must be called before Start.
+func (s *Server) SetSink(sink Sink) {
+	s.traceHandler.SetSink(sink)
+	s.metricsHandler.SetSink(sink)
+	s.logsHandler.SetSink(sink)
+}
+
+// SetAPIHandler serves api under /api/ alongside the OTLP endpoints, so the
+// aggregator API shares the collector's port. The API keeps its own access
+// checks; the ingest token still only guards the OTLP endpoints. Its requests
+// are logged by the collector's access log, so api should not log them
+// itself. It must be called before Start.
+func (s *Server) SetAPIHandler(api http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/api/", api)
+	mux.Handle("/", s.handler)
+	s.handler = mux
+	s.httpServer.Handler = s.accessLog.Middleware("collector", mux)
+	s.api = api
+}
+
+// AccessLog returns the file the access log is written to, for the
+// aggregator API to share; nil when it goes to the process log
+func (s *Server) AccessLog() accesslog.LineWriter {
+	return s.accessOut
+}
+
+// CloseAccessLog closes the access log file, if any. Shutdown only flushes
+// it, as the aggregator API may still be logging to it; call this once
+// every server sharing it has stopped.
+func (s *Server) CloseAccessLog() error {
+	if s.accessWriter == nil {
+		return nil
+	}
+	return s.accessWriter.Close()
+}
+
+// Handler returns the collector's HTTP handler, for serving it in-process
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
 func (s *Server) Start() error {
-	log.Printf("Starting OTLP collector on port %d", s.config.ServerPort)
-	log.Printf("Trace endpoint: http://localhost:%d/v1/traces", s.config.ServerPort)
-	log.Printf("Metrics endpoint: http://localhost:%d/v1/metrics", s.config.ServerPort)
-	log.Printf("Logs endpoint: http://localhost:%d/v1/logs", s.config.ServerPort)
-	log.Printf("Output directory: %s", s.config.OutputDir)
+	// Over a Unix socket the host is ignored, as with curl --unix-socket
+	base := fmt.Sprintf("http://localhost:%d", s.config.ServerPort)
+	if s.config.ListenSocket != "" {
+		log.Printf("Starting OTLP collector on unix socket %s", s.config.ListenSocket)
+		base = "http://localhost"
+	} else {
+		log.Printf("Starting OTLP collector on %s", s.httpServer.Addr)
+	}
+	log.Printf("Trace endpoint: %s/v1/traces", base)
+	log.Printf("Metrics endpoint: %s/v1/metrics", base)
+	log.Printf("Logs endpoint: %s/v1/logs", base)
+	log.Printf("Self-metrics endpoint: %s/metrics", base)
+	log.Printf("Client stats endpoint: %s/stats", base)
+	log.Printf("Health endpoint: %s/health", base)
+	log.Printf("Readiness endpoint: %s/ready", base)
+	if s.config.AdminToken != "" && len(s.writers.list()) > 0 {
+		log.Printf("Manual rotation endpoint: POST %s/admin/rotate", base)
+	}
+	if s.api != nil {
+		log.Printf("Aggregation API: %s/api/", base)
+	}
+	if len(s.writers.list()) > 0 {
+		log.Printf("Output directory: %s", s.config.OutputDir)
+		if s.config.PartitionByOrg {
+			log.Printf("Partitioning output by %s into per-organization directories", orgAttribute)
+		}
+		if s.config.TeeOutputDir != "" {
+			log.Printf("Copying output to tee directory: %s", s.config.TeeOutputDir)
+		}
+	} else if s.traceHandler.writer == nil {
+		log.Printf("Not writing JSONL files, data goes straight to the aggregator")
+	}
 
-	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if s.flusher != nil {
+		log.Printf("Buffering writes, flushing every %dms", s.config.WriteFlushIntervalMS)
+		s.flusher.Start()
+	}
+
+	if s.s3 != nil {
+		log.Printf("Uploading received telemetry to bucket %s at %s, spooling in %s", s.config.S3Bucket, s.config.S3Endpoint, s.config.S3SpoolDir)
+		s.s3.Start()
+	}
+
+	if s.forwarder != nil {
+		log.Printf("Forwarding received data to %s", s.config.ForwardEndpoint)
+		s.forwarder.Start()
+	}
+
+	var err error
+	if s.config.ListenSocket != "" {
+		listener, lerr := listenUnixSocket(s.config.ListenSocket)
+		if lerr != nil {
+			return fmt.Errorf("failed to start server: %w", lerr)
+		}
+		if s.httpServer.TLSConfig != nil {
+			log.Printf("Serving OTLP over TLS")
+			err = s.httpServer.ServeTLS(listener, "", "")
+		} else {
+			err = s.httpServer.Serve(listener)
+		}
+	} else if s.httpServer.TLSConfig != nil {
+		log.Printf("Serving OTLP over TLS")
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 	return nil
@@ -75,20 +425,143 @@ func (s *Server) Start() error {
 
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Println("Shutting down server...")
-	return s.httpServer.Shutdown(ctx)
+	var errs []error
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if s.config.ListenSocket != "" {
+		if err := removeSocket(s.config.ListenSocket); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Close writers after the server stops accepting requests so no write is
+	// lost; a failed close may mean buffered exports never reached disk
+	if s.flusher != nil {
+		s.flusher.Stop()
+	}
+	for _, w := range s.writers.list() {
+		if w == s.accessWriter {
+			if err := w.Flush(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to flush access log: %w", err))
+			}
+			continue
+		}
+		if err := w.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close writer: %w", err))
+		}
+	}
+
+	// Upload closed segments while there is time; the rest wait in the spool
+	if s.s3 != nil {
+		if err := s.s3.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to upload S3 segments: %w", err))
+		}
+	}
+
+	// Give queued requests a chance to reach the upstream
+	if s.forwarder != nil {
+		if ferr := s.forwarder.Stop(ctx); ferr != nil {
+			log.Printf("Failed to drain forward queue: %v", ferr)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// handleHealth handles GET /health
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status":"ok","service":"otis-collector","timestamp":%q}`+"\n", time.Now().Format(time.RFC3339))
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
+// readyHandler handles GET /ready, answering 503 while any output file's
+// writes are persistently failing, so load balancers stop sending it data.
+// A write that failed but succeeded on retry, or a single failed one,
+// doesn't count.
+func readyHandler(writers func() []*FileWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		failing := []map[string]interface{}{}
+		for _, writer := range writers() {
+			if !writer.Failing() {
+				continue
+			}
+			stats := writer.Stats()
+			failing = append(failing, map[string]interface{}{
+				"file":          stats.File,
+				"failed_writes": stats.FailedWrites,
+				"disk_full":     stats.DiskFull,
+			})
+		}
+
+		status, code := "ready", http.StatusOK
+		if len(failing) > 0 {
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":        status,
+			"failing_files": failing,
+		})
+	}
+}
+
+// newTLSConfig loads the configured certificate and minimum TLS version
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, fmt.Errorf("both OTIS_TLS_CERT and OTIS_TLS_KEY must be set to enable TLS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	var minVersion uint16
+	switch cfg.TLSMinVersion {
+	case "", "1.2":
+		minVersion = tls.VersionTLS12
+	case "1.3":
+		minVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported TLS minimum version %q (use 1.2 or 1.3)", cfg.TLSMinVersion)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+	}, nil
+}
+
+// authMiddleware requires a bearer token on the OTLP ingest endpoints and
+// on /stats, which names client IPs. Other paths, such as health checks, are
+// left unauthenticated.
+func authMiddleware(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip logging HTTP/2 connection preface
-		if r.Method == "PRI" {
+		if !strings.HasPrefix(r.URL.Path, "/v1/") && r.URL.Path != "/stats" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		start := time.Now()
-		log.Printf("Started %s %s", r.Method, r.URL.Path)
+		provided := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(provided, expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
 		next.ServeHTTP(w, r)
-		log.Printf("Completed %s %s in %v", r.Method, r.URL.Path, time.Since(start))
 	})
 }
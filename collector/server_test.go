@@ -0,0 +1,414 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmack/otis/config"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := authMiddleware("secret-token", next)
+
+	tests := []struct {
+		name       string
+		path       string
+		authHeader string
+		wantStatus int
+	}{
+		{"missing token", "/v1/traces", "", http.StatusUnauthorized},
+		{"wrong token", "/v1/metrics", "Bearer wrong-token", http.StatusUnauthorized},
+		{"wrong scheme", "/v1/logs", "Basic secret-token", http.StatusUnauthorized},
+		{"correct token", "/v1/logs", "Bearer secret-token", http.StatusOK},
+		{"non-ingest path", "/health", "", http.StatusOK},
+		{"client stats without token", "/stats", "", http.StatusUnauthorized},
+		{"client stats with token", "/stats", "Bearer secret-token", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+		})
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and key to dir
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestNewServerWithTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	cfg := &config.Config{
+		OutputDir:     dir,
+		TLSCertFile:   certFile,
+		TLSKeyFile:    keyFile,
+		TLSMinVersion: "1.3",
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if server.httpServer.TLSConfig == nil {
+		t.Fatal("Expected TLS to be configured")
+	}
+	if server.httpServer.TLSConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3 minimum, got %x", server.httpServer.TLSConfig.MinVersion)
+	}
+}
+
+func TestNewServerWithoutTLS(t *testing.T) {
+	server, err := NewServer(&config.Config{OutputDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if server.httpServer.TLSConfig != nil {
+		t.Error("Expected plaintext server when no certificate is configured")
+	}
+}
+
+func TestNewServerHTTPLimits(t *testing.T) {
+	server, err := NewServer(&config.Config{OutputDir: t.TempDir(), CollectorHTTP: config.HTTPLimits{
+		ReadTimeoutSeconds:       120,
+		ReadHeaderTimeoutSeconds: 2,
+		WriteTimeoutSeconds:      15,
+		IdleTimeoutSeconds:       60,
+		MaxHeaderBytes:           8192,
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	got := server.httpServer
+	if got.ReadTimeout != 2*time.Minute || got.ReadHeaderTimeout != 2*time.Second || got.WriteTimeout != 15*time.Second ||
+		got.IdleTimeout != time.Minute || got.MaxHeaderBytes != 8192 {
+		t.Errorf("Expected the configured limits, got read %v, header %v, write %v, idle %v, %d header bytes",
+			got.ReadTimeout, got.ReadHeaderTimeout, got.WriteTimeout, got.IdleTimeout, got.MaxHeaderBytes)
+	}
+}
+
+func TestNewServerAccessLogFile(t *testing.T) {
+	accessLog := filepath.Join(t.TempDir(), "access.log")
+	server, err := NewServer(&config.Config{OutputDir: t.TempDir(), AccessLogFile: accessLog, WriteFlushIntervalMS: 1000, WriteBufferBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	out := server.AccessLog()
+	if out == nil {
+		t.Fatal("Expected the access log file to be shared with the aggregator API")
+	}
+	server.SetAPIHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("api"))
+	}))
+
+	for _, path := range []string{"/health", "/api/sessions"} {
+		server.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+	// The aggregator API may log after the collector stops, until the log closes
+	out.WriteLine(`{"server":"aggregator"}`)
+	if err := server.CloseAccessLog(); err != nil {
+		t.Fatalf("Failed to close access log: %v", err)
+	}
+
+	data, err := os.ReadFile(accessLog)
+	if err != nil {
+		t.Fatalf("Failed to read access log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"path":"/health","status":200`) ||
+		!strings.Contains(lines[1], `"server":"collector"`) || !strings.Contains(lines[1], `"path":"/api/sessions"`) ||
+		lines[2] != `{"server":"aggregator"}` {
+		t.Errorf("Expected each request logged once and the late line kept, got %q", data)
+	}
+}
+
+func TestSetAPIHandlerSharesCollectorPort(t *testing.T) {
+	server, err := NewServer(&config.Config{OutputDir: t.TempDir(), IngestToken: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.SetAPIHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("api " + r.URL.Path))
+	}))
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantBody     string
+	}{
+		{http.MethodGet, "/api/v2/sessions", http.StatusOK, "api /api/v2/sessions"},
+		{http.MethodGet, "/api/health", http.StatusOK, "api /api/health"},
+		{http.MethodGet, "/health", http.StatusOK, ""},
+		{http.MethodPost, "/v1/traces", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/apis", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.wantStatus, rec.Code)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s %s: expected the API to answer, got %q", tt.method, tt.path, rec.Body.String())
+		}
+	}
+}
+
+func TestNewServerBindAddr(t *testing.T) {
+	tests := []struct {
+		bindAddr string
+		want     string
+	}{
+		{"", ":4318"},
+		{"0.0.0.0", "0.0.0.0:4318"},
+		{"localhost", "localhost:4318"},
+		{"127.0.0.1", "127.0.0.1:4318"},
+		{"::1", "[::1]:4318"},
+	}
+	for _, tt := range tests {
+		server, err := NewServer(&config.Config{OutputDir: t.TempDir(), ServerPort: 4318, BindAddr: tt.bindAddr})
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		if server.httpServer.Addr != tt.want {
+			t.Errorf("Bind address %q: expected %s, got %s", tt.bindAddr, tt.want, server.httpServer.Addr)
+		}
+	}
+}
+
+func TestLoopbackListenerRefusesExternalConnections(t *testing.T) {
+	// Any non-loopback address of this host stands in for an external client
+	var external net.IP
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			external = ipNet.IP
+			break
+		}
+	}
+	if external == nil {
+		t.Skip("No non-loopback IPv4 address to connect from")
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	if conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", port), time.Second); err != nil {
+		t.Fatalf("Expected a loopback connection to succeed: %v", err)
+	} else {
+		conn.Close()
+	}
+	if conn, err := net.DialTimeout("tcp", net.JoinHostPort(external.String(), port), time.Second); err == nil {
+		conn.Close()
+		t.Errorf("Expected a connection to %s to be refused", external)
+	}
+}
+
+func TestNewServerTLSMisconfigured(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	tests := []struct {
+		name string
+		cfg  *config.Config
+	}{
+		{"missing cert file", &config.Config{OutputDir: dir, TLSCertFile: filepath.Join(dir, "missing.pem"), TLSKeyFile: keyFile}},
+		{"key without cert", &config.Config{OutputDir: dir, TLSKeyFile: keyFile}},
+		{"bad min version", &config.Config{OutputDir: dir, TLSCertFile: certFile, TLSKeyFile: keyFile, TLSMinVersion: "1.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewServer(tt.cfg); err == nil {
+				t.Error("Expected NewServer to fail")
+			}
+		})
+	}
+}
+
+func TestShutdownFlushesBufferedWrites(t *testing.T) {
+	dir := t.TempDir()
+	server, err := NewServer(&config.Config{
+		OutputDir:      dir,
+		TraceFileName:  "traces.jsonl",
+		MetricFileName: "metrics.jsonl",
+		LogFileName:    "logs.jsonl",
+		// Long enough that only Shutdown can flush the buffer
+		WriteFlushIntervalMS: 60000,
+		WriteBufferBytes:     1 << 20,
+		MaxRequestBytes:      1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(newTestTraceRequest(t, 1))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	tracePath := filepath.Join(dir, "traces.jsonl")
+	if data, _ := os.ReadFile(tracePath); len(data) != 0 {
+		t.Fatalf("Expected the write to still be buffered, found %q", data)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+
+	data, err := os.ReadFile(tracePath)
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"resourceSpans"`) {
+		t.Errorf("Expected the buffered line on disk after shutdown, got %q", data)
+	}
+}
+
+// unixSocketClient returns a client whose every request goes to the socket at path
+func unixSocketClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func TestServerListensOnUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "otis.sock")
+
+	// A socket file left behind by a crashed run
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	server, err := NewServer(&config.Config{OutputDir: dir, ListenSocket: socketPath})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.Start() }()
+
+	client := unixSocketClient(socketPath)
+	var resp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if resp, err = client.Get("http://otis/health"); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("Failed to reach the collector over its socket: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("Failed to stat socket: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected socket permissions 0600, got %o", perm)
+	}
+
+	// A second server must not take over a live socket
+	if _, err := listenUnixSocket(socketPath); err == nil {
+		t.Error("Expected listening on a live socket to fail")
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Expected Start to return cleanly, got %v", err)
+	}
+	if _, err := os.Lstat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket file to be removed, got %v", err)
+	}
+}
+
+func TestListenUnixSocketKeepsRegularFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "otis.sock")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := listenUnixSocket(path); err == nil {
+		t.Fatal("Expected a regular file to be refused")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "data" {
+		t.Errorf("Expected the file to be left alone, got %q", data)
+	}
+}
@@ -0,0 +1,21 @@
+package collector
+
+import (
+	"time"
+
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+// Sink receives decoded OTLP requests directly from the handlers, alongside
+// or instead of the JSONL files. When files are written, only the batches that
+// were stored successfully are passed on, so a retried partial success is not
+// counted twice. batch is the request's batch, as written to the JSONL
+// envelope, or nil when batch tagging is off. Implementations must be safe
+// for concurrent use.
+type Sink interface {
+	ConsumeTraces(req *tracev1.ExportTraceServiceRequest, receivedAt time.Time, batch *BatchContext)
+	ConsumeMetrics(req *metricsv1.ExportMetricsServiceRequest, receivedAt time.Time, batch *BatchContext)
+	ConsumeLogs(req *logsv1.ExportLogsServiceRequest, receivedAt time.Time, batch *BatchContext)
+}
@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	logsv1 "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+// recordingSink counts the spans it is handed
+type recordingSink struct {
+	mu    sync.Mutex
+	spans int64
+	calls int
+}
+
+func (s *recordingSink) ConsumeTraces(req *tracev1.ExportTraceServiceRequest, receivedAt time.Time, batch *BatchContext) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spans += countSpans(req)
+	s.calls++
+}
+
+func (s *recordingSink) ConsumeMetrics(*metricsv1.ExportMetricsServiceRequest, time.Time, *BatchContext) {
+}
+func (s *recordingSink) ConsumeLogs(*logsv1.ExportLogsServiceRequest, time.Time, *BatchContext) {}
+
+func TestTraceHandlerSinkWithoutWriter(t *testing.T) {
+	sink := &recordingSink{}
+	handler := NewTraceHandler(nil, RequestLimits{MaxBytes: 1 << 20, MaxResources: 2}, NewMetrics())
+	handler.SetSink(sink)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces",
+		bytes.NewReader(newTestTraceRequestWithResources(t, 3, 2))))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if sink.spans != 6 || sink.calls != 2 {
+		t.Errorf("Expected 6 spans in 2 batches, got %d spans in %d batches", sink.spans, sink.calls)
+	}
+}
+
+func TestTraceHandlerSinkSkipsFailedWrites(t *testing.T) {
+	// Point the writer at a directory so every write fails
+	writer, err := NewFileWriter(filepath.Join(t.TempDir(), "traces.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := os.Mkdir(writer.filePath, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	sink := &recordingSink{}
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20}, NewMetrics())
+	handler.SetSink(sink)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(newTestTraceRequest(t, 3))))
+
+	if resp := decodeTraceResponse(t, rec.Body); resp.PartialSuccess == nil {
+		t.Fatal("Expected partial success in response")
+	}
+	// The exporter will retry the rejected spans, so the sink must not count them now
+	if sink.spans != 0 {
+		t.Errorf("Expected rejected spans to be kept from the sink, got %d", sink.spans)
+	}
+}
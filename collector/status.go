@@ -0,0 +1,29 @@
+package collector
+
+import (
+	"log"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// writeStatus writes an OTLP/HTTP error response: a protobuf-encoded google.rpc.Status
+func writeStatus(w http.ResponseWriter, httpCode int, code codes.Code, message string) {
+	data, err := proto.Marshal(&status.Status{
+		Code:    int32(code),
+		Message: message,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal status: %v", err)
+		http.Error(w, message, httpCode)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(httpCode)
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
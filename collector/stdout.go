@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// LineWriter is where a handler's JSONL lines go: a FileWriter, or a
+// StdoutWriter in containers that collect output from standard output
+type LineWriter interface {
+	WriteLine(s string) error
+	WriteJSON(data interface{}) error
+	Close() error
+}
+
+// lineOutput serializes the lines of every signal written to one stream, so
+// lines from concurrent requests never interleave
+type lineOutput struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// StdoutWriter writes JSONL lines to a shared stream, each prefixed with its
+// signal and a space so a log pipeline can route them
+type StdoutWriter struct {
+	output *lineOutput
+	prefix string
+}
+
+// NewStdoutWriters creates the trace, metrics and logs writers for out
+func NewStdoutWriters(out io.Writer) (traces, metrics, logs *StdoutWriter) {
+	output := &lineOutput{out: out}
+	return &StdoutWriter{output: output, prefix: signalTraces + " "},
+		&StdoutWriter{output: output, prefix: signalMetrics + " "},
+		&StdoutWriter{output: output, prefix: signalLogs + " "}
+}
+
+func (w *StdoutWriter) WriteJSON(data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data to JSON: %w", err)
+	}
+	return w.WriteLine(string(jsonData))
+}
+
+func (w *StdoutWriter) WriteLine(s string) error {
+	w.output.mu.Lock()
+	defer w.output.mu.Unlock()
+
+	if _, err := io.WriteString(w.output.out, w.prefix+s+"\n"); err != nil {
+		return fmt.Errorf("failed to write to stdout: %w", err)
+	}
+	return nil
+}
+
+// Close does nothing; the stream belongs to the process
+func (w *StdoutWriter) Close() error {
+	return nil
+}
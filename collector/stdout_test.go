@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zmack/otis/config"
+)
+
+func TestStdoutWritersPrefixLinesWithSignal(t *testing.T) {
+	var out bytes.Buffer
+	traces, metrics, logs := NewStdoutWriters(&out)
+
+	handler := NewTraceHandler(traces, RequestLimits{MaxBytes: 1 << 20, MaxResources: 2}, NewMetrics())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces",
+		bytes.NewReader(newTestTraceRequestWithResources(t, 3, 1))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if err := metrics.WriteJSON(map[string]int{"n": 1}); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	if err := logs.WriteLine(`{"data":{}}`); err != nil {
+		t.Fatalf("Failed to write logs: %v", err)
+	}
+
+	var signals []string
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		signal, line, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || !json.Valid([]byte(line)) {
+			t.Fatalf("Expected a signal and a JSON line, got %q", scanner.Text())
+		}
+		signals = append(signals, signal)
+	}
+
+	// Three resources in chunks of two make two trace lines
+	if got := strings.Join(signals, ","); got != "traces,traces,metrics,logs" {
+		t.Errorf("Expected two trace lines, then metrics and logs, got %s", got)
+	}
+}
+
+func TestNewServerStdoutSink(t *testing.T) {
+	server, err := NewServer(&config.Config{OutputDir: t.TempDir(), Sink: "stdout"})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if files := server.writers.list(); len(files) != 0 {
+		t.Errorf("Expected no file writers, got %d", len(files))
+	}
+	if _, ok := server.traceHandler.writer.(*StdoutWriter); !ok {
+		t.Errorf("Expected traces written to stdout, got %v", server.traceHandler.writer)
+	}
+
+	// Direct ingestion without an archive writes nothing at all
+	server, err = NewServer(&config.Config{OutputDir: t.TempDir(), Sink: "stdout", DirectIngest: true})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if server.traceHandler.writer != nil {
+		t.Errorf("Expected no trace writer, got %v", server.traceHandler.writer)
+	}
+
+	if _, err := NewServer(&config.Config{OutputDir: t.TempDir(), Sink: "kafka"}); err == nil {
+		t.Error("Expected an unknown sink to be rejected")
+	}
+}
@@ -0,0 +1,28 @@
+package collector
+
+import "log"
+
+// teeWriter copies a signal's lines to a secondary file, such as a scratch
+// directory read by an experimental pipeline. Copies are best effort: a
+// failure is logged and counted but never fails the request, which only
+// depends on the primary write.
+type teeWriter struct {
+	writer  *FileWriter
+	metrics *Metrics
+	signal  string
+}
+
+func newTeeWriter(writer *FileWriter, metrics *Metrics, signal string) *teeWriter {
+	return &teeWriter{writer: writer, metrics: metrics, signal: signal}
+}
+
+// copyLine writes a line the primary writer accepted; a nil tee does nothing
+func (t *teeWriter) copyLine(line string) {
+	if t == nil {
+		return
+	}
+	if err := t.writer.WriteLine(line); err != nil {
+		t.metrics.recordTeeError(t.signal)
+		log.Printf("Failed to copy %s to the tee output: %v", t.signal, err)
+	}
+}
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zmack/otis/config"
+)
+
+func newTeeTestServer(t *testing.T, outputDir, teeDir string) *Server {
+	t.Helper()
+	server, err := NewServer(&config.Config{
+		OutputDir:              outputDir,
+		TeeOutputDir:           teeDir,
+		TraceFileName:          "traces.jsonl",
+		MetricFileName:         "metrics.jsonl",
+		LogFileName:            "logs.jsonl",
+		MaxRequestBytes:        1 << 20,
+		MaxResourcesPerRequest: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return server
+}
+
+func TestTeeOutputDirGetsIdenticalLines(t *testing.T) {
+	outputDir, teeDir := t.TempDir(), t.TempDir()
+	server := newTeeTestServer(t, outputDir, teeDir)
+
+	// Three resources make three lines in each file
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces",
+			bytes.NewReader(newTestTraceRequestWithResources(t, 3, 1))))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+	}
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+
+	primary, err := os.ReadFile(filepath.Join(outputDir, "traces.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read the primary file: %v", err)
+	}
+	tee, err := os.ReadFile(filepath.Join(teeDir, "traces.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read the tee file: %v", err)
+	}
+	if lines := strings.Count(string(primary), "\n"); lines != 6 {
+		t.Errorf("Expected 6 primary lines, got %d", lines)
+	}
+	if !bytes.Equal(primary, tee) {
+		t.Errorf("Expected identical files, got %q and %q", primary, tee)
+	}
+	if _, err := os.Stat(filepath.Join(teeDir, "logs.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("Expected no tee logs file before any logs, got %v", err)
+	}
+}
+
+func TestTeeFailuresDoNotFailRequests(t *testing.T) {
+	outputDir, teeDir := t.TempDir(), t.TempDir()
+
+	// A directory where the tee's trace file belongs makes every copy fail
+	if err := os.Mkdir(filepath.Join(teeDir, "traces.jsonl"), 0755); err != nil {
+		t.Fatalf("Failed to block the tee file: %v", err)
+	}
+	server := newTeeTestServer(t, outputDir, teeDir)
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces",
+		bytes.NewReader(newTestTraceRequestWithResources(t, 2, 1))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 despite the tee failing, got %d", rec.Code)
+	}
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+
+	primary, err := os.ReadFile(filepath.Join(outputDir, "traces.jsonl"))
+	if err != nil || strings.Count(string(primary), "\n") != 2 {
+		t.Errorf("Expected both lines in the primary file, got %q (err %v)", primary, err)
+	}
+	if output := metricsOutput(server.traceHandler.metrics); !strings.Contains(output, `otis_tee_write_errors_total{signal="traces"} 2`) {
+		t.Error("Expected both failed copies counted")
+	}
+}
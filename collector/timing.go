@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Phases of an export request timed by the handlers
+const (
+	phaseRead      = "read"
+	phaseUnmarshal = "unmarshal"
+	phaseWrite     = "write"
+)
+
+// phaseTimer times the phases of a single export request, recording each in
+// the phase duration histogram as it ends
+type phaseTimer struct {
+	metrics *Metrics
+	signal  string
+	last    time.Time
+	phases  []string
+	times   []time.Duration
+}
+
+func newPhaseTimer(metrics *Metrics, signal string) *phaseTimer {
+	return &phaseTimer{
+		metrics: metrics,
+		signal:  signal,
+		last:    time.Now(),
+	}
+}
+
+// end ends phase, timing it from the end of the previous phase
+func (t *phaseTimer) end(phase string) {
+	now := time.Now()
+	elapsed := now.Sub(t.last)
+	t.last = now
+
+	t.phases = append(t.phases, phase)
+	t.times = append(t.times, elapsed)
+	t.metrics.recordPhase(t.signal, phase, elapsed)
+}
+
+// String lists the phases ended so far, e.g. "read=1.2ms unmarshal=310µs"
+func (t *phaseTimer) String() string {
+	parts := make([]string, len(t.phases))
+	for i, phase := range t.phases {
+		parts[i] = fmt.Sprintf("%s=%s", phase, t.times[i])
+	}
+	return strings.Join(parts, " ")
+}
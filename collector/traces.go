@@ -2,74 +2,65 @@ package collector
 
 import (
 	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"time"
 
 	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
-	"google.golang.org/protobuf/encoding/protojson"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
 	"google.golang.org/protobuf/proto"
 )
 
 type TraceHandler struct {
-	writer *FileWriter
+	*otlpHandler[*tracev1.ExportTraceServiceRequest, *tracepb.ResourceSpans]
 }
 
-func NewTraceHandler(writer *FileWriter) *TraceHandler {
-	return &TraceHandler{
-		writer: writer,
-	}
-}
-
-func (h *TraceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Failed to read request body: %v", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
-
-	req := &tracev1.ExportTraceServiceRequest{}
-	if err := proto.Unmarshal(body, req); err != nil {
-		log.Printf("Failed to unmarshal trace request: %v", err)
-		http.Error(w, "Failed to unmarshal request", http.StatusBadRequest)
-		return
-	}
-
-	jsonData := protojson.MarshalOptions{
-		Multiline:       false,
-		Indent:          "",
-		EmitUnpopulated: false,
-	}.Format(req)
+// traceSignal handles trace export requests, counting their spans
+var traceSignal = otlpSignal[*tracev1.ExportTraceServiceRequest, *tracepb.ResourceSpans]{
+	name:      signalTraces,
+	title:     "Trace",
+	noun:      "trace",
+	records:   "spans",
+	resources: "resource spans",
 
-	if err := h.writer.WriteLine(jsonData); err != nil {
-		log.Printf("Failed to write trace data: %v", err)
-		http.Error(w, "Failed to write data", http.StatusInternalServerError)
-		return
-	}
-
-	resp := &tracev1.ExportTraceServiceResponse{}
-	respData, err := proto.Marshal(resp)
-	if err != nil {
-		log.Printf("Failed to marshal response: %v", err)
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/x-protobuf")
-	if _, err := w.Write(respData); err != nil {
-		log.Printf("Failed to write response: %v", err)
-	}
+	newRequest: func() *tracev1.ExportTraceServiceRequest { return &tracev1.ExportTraceServiceRequest{} },
+	getResources: func(req *tracev1.ExportTraceServiceRequest) []*tracepb.ResourceSpans {
+		return req.ResourceSpans
+	},
+	withResources: func(resources []*tracepb.ResourceSpans) *tracev1.ExportTraceServiceRequest {
+		return &tracev1.ExportTraceServiceRequest{ResourceSpans: resources}
+	},
+	count:      countSpans,
+	splitByOrg: splitTracesByOrg,
+	anonymize:  anonymizeTraces,
+	consume: func(sink Sink, req *tracev1.ExportTraceServiceRequest, receivedAt time.Time, batch *BatchContext) {
+		sink.ConsumeTraces(req, receivedAt, batch)
+	},
+	respond: func(rejected int64, writeErr error) proto.Message {
+		resp := &tracev1.ExportTraceServiceResponse{}
+		if writeErr != nil {
+			resp.PartialSuccess = &tracev1.ExportTracePartialSuccess{
+				RejectedSpans: rejected,
+				ErrorMessage:  partialSuccessMessage(writeErr),
+			}
+		}
+		return resp
+	},
+}
 
-	log.Printf("Received and stored trace data with %d resource spans", len(req.ResourceSpans))
+func NewTraceHandler(writer LineWriter, limits RequestLimits, metrics *Metrics) *TraceHandler {
+	return &TraceHandler{newOTLPHandler(traceSignal, writer, limits, metrics)}
 }
 
 func (h *TraceHandler) String() string {
 	return fmt.Sprintf("TraceHandler{writer: %v}", h.writer)
 }
+
+// countSpans returns the number of spans carried by a trace export request
+func countSpans(req *tracev1.ExportTraceServiceRequest) int64 {
+	var count int64
+	for _, rs := range req.GetResourceSpans() {
+		for _, ss := range rs.GetScopeSpans() {
+			count += int64(len(ss.GetSpans()))
+		}
+	}
+	return count
+}
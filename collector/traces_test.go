@@ -0,0 +1,258 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	v1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestTraceRequest(t *testing.T, spans int) []byte {
+	t.Helper()
+	return newTestTraceRequestWithResources(t, 1, spans)
+}
+
+func newTestTraceRequestWithResources(t *testing.T, resources, spansPerResource int) []byte {
+	t.Helper()
+
+	req := &tracev1.ExportTraceServiceRequest{}
+	for r := 0; r < resources; r++ {
+		scopeSpans := &v1.ScopeSpans{}
+		for i := 0; i < spansPerResource; i++ {
+			scopeSpans.Spans = append(scopeSpans.Spans, &v1.Span{Name: "test-span"})
+		}
+		req.ResourceSpans = append(req.ResourceSpans, &v1.ResourceSpans{ScopeSpans: []*v1.ScopeSpans{scopeSpans}})
+	}
+
+	body, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	return body
+}
+
+// unwrapEnvelope checks a JSONL line's envelope and returns its OTLP payload
+func unwrapEnvelope(t *testing.T, line string) []byte {
+	t.Helper()
+
+	var envelope struct {
+		ReceivedAt time.Time       `json:"receivedAt"`
+		Data       json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(line), &envelope); err != nil {
+		t.Fatalf("Failed to parse envelope: %v", err)
+	}
+	if envelope.ReceivedAt.IsZero() {
+		t.Errorf("Expected receivedAt in envelope, got %s", line)
+	}
+	return envelope.Data
+}
+
+func decodeTraceResponse(t *testing.T, body io.Reader) *tracev1.ExportTraceServiceResponse {
+	t.Helper()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp := &tracev1.ExportTraceServiceResponse{}
+	if err := proto.Unmarshal(data, resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return resp
+}
+
+func TestTraceHandlerStoresData(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20}, NewMetrics())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(newTestTraceRequest(t, 2))))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if resp := decodeTraceResponse(t, rec.Body); resp.PartialSuccess != nil {
+		t.Errorf("Expected no partial success, got %v", resp.PartialSuccess)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Contains(data, []byte("test-span")) {
+		t.Errorf("Expected output to contain span, got %s", data)
+	}
+}
+
+// newFailingWriter returns a writer pointed at a directory, so every write fails
+func newFailingWriter(t *testing.T, name string) *FileWriter {
+	t.Helper()
+
+	writer, err := NewFileWriter(filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := os.Mkdir(writer.filePath, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	return writer
+}
+
+func TestTraceHandlerPartialSuccessOnWriteFailure(t *testing.T) {
+	writer := newFailingWriter(t, "traces.jsonl")
+
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20}, NewMetrics())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(newTestTraceRequest(t, 3))))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	resp := decodeTraceResponse(t, rec.Body)
+	if resp.PartialSuccess == nil {
+		t.Fatal("Expected partial success in response")
+	}
+	if resp.PartialSuccess.RejectedSpans != 3 {
+		t.Errorf("Expected 3 rejected spans, got %d", resp.PartialSuccess.RejectedSpans)
+	}
+	if resp.PartialSuccess.ErrorMessage == "" {
+		t.Error("Expected an error message")
+	}
+}
+
+func TestTraceHandlerRejectsUndecodableBody(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20}, NewMetrics())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", strings.NewReader("not a protobuf")))
+
+	// Nothing was accepted, so this is an error rather than a partial success
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", rec.Code)
+	}
+	if data, _ := os.ReadFile(filePath); len(data) != 0 {
+		t.Errorf("Expected nothing written, got %s", data)
+	}
+}
+
+func TestTraceHandlerRejectsOversizedBody(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	body := newTestTraceRequest(t, 50)
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: int64(len(body) - 1)}, NewMetrics())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d", rec.Code)
+	}
+
+	st := &status.Status{}
+	if err := proto.Unmarshal(rec.Body.Bytes(), st); err != nil {
+		t.Fatalf("Failed to unmarshal status: %v", err)
+	}
+	if st.Message == "" {
+		t.Error("Expected a status message")
+	}
+
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written, stat returned %v", err)
+	}
+}
+
+func TestTraceHandlerSplitsManyResources(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20, MaxResources: 2}, NewMetrics())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces",
+		bytes.NewReader(newTestTraceRequestWithResources(t, 5, 1))))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 5 resources split across 3 lines, got %d", len(lines))
+	}
+
+	resources := 0
+	for _, line := range lines {
+		part := &tracev1.ExportTraceServiceRequest{}
+		if err := protojson.Unmarshal(unwrapEnvelope(t, line), part); err != nil {
+			t.Fatalf("Failed to parse line: %v", err)
+		}
+		if len(part.ResourceSpans) > 2 {
+			t.Errorf("Expected at most 2 resources per line, got %d", len(part.ResourceSpans))
+		}
+		resources += len(part.ResourceSpans)
+	}
+	if resources != 5 {
+		t.Errorf("Expected all 5 resources to be written, got %d", resources)
+	}
+}
+
+func TestTraceHandlerRejectsTooManyRecords(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	handler := NewTraceHandler(writer, RequestLimits{MaxBytes: 1 << 20, MaxRecords: 10}, NewMetrics())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/traces",
+		bytes.NewReader(newTestTraceRequestWithResources(t, 3, 4))))
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d", rec.Code)
+	}
+
+	st := &status.Status{}
+	if err := proto.Unmarshal(rec.Body.Bytes(), st); err != nil {
+		t.Fatalf("Failed to unmarshal status: %v", err)
+	}
+	if !strings.Contains(st.Message, "12 spans") {
+		t.Errorf("Expected the status to report the span count, got %q", st.Message)
+	}
+
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written, stat returned %v", err)
+	}
+}
@@ -2,15 +2,122 @@ package collector
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+const (
+	// DefaultRotatedFiles is how many rotated generations are kept when
+	// SetRotatedFiles has not been called
+	DefaultRotatedFiles = 5
+
+	// DefaultWriteBufferSize is the buffered writer threshold at which data is
+	// written to disk ahead of the next timed flush
+	DefaultWriteBufferSize = 64 << 10
+
+	// DefaultWriteRetryAttempts is how many times a line is tried before its
+	// write fails, and DefaultWriteRetryBackoff the wait before the first
+	// retry, doubling for each one after
+	DefaultWriteRetryAttempts = 3
+	DefaultWriteRetryBackoff  = 50 * time.Millisecond
+
+	// failingWrites is how many writes in a row must fail, retries and all,
+	// before a writer counts as failing rather than having a blip
+	failingWrites = 3
+)
+
+// ErrDiskFull classifies a write that failed because the disk is full, which
+// is worth retrying later rather than rejecting the data for good
+var ErrDiskFull = errors.New("disk full")
+
+// FileWriter appends JSONL lines to a file, rotating it into numbered
+// generations. Writes, flushes and rotations take turns on mu, so a line
+// always lands whole in a single segment. Counters are kept separately and
+// updated atomically, so reading them never waits on a slow disk.
 type FileWriter struct {
 	mu       sync.Mutex
 	filePath string
+	name     string // filePath relative to the output directory
+
+	// active is the segment being appended to; nil until the first write
+	// after startup or a rotation
+	active *segment
+
+	// Buffered mode keeps the segment open and only writes through on Flush
+	buffered   bool
+	bufferSize int
+
+	// Size-based rotation; maxBytes of zero disables it
+	maxBytes     int64
+	rotatedFiles int
+
+	// sync fsyncs after every write to disk, trading throughput for durability
+	sync bool
+
+	// compress gzips <name>.1 in the background after each rotation.
+	// generations is held while rotated files are renamed or removed, so a
+	// compression finishing never races a rotation shifting its file.
+	compress    bool
+	compressing sync.WaitGroup
+	generations sync.Mutex
+
+	// queue bounds the requests waiting to write; nil leaves it unbounded
+	queue chan struct{}
+
+	// A failed line is tried up to retryAttempts times in all, sleeping
+	// retryBackoff, then twice as long, and so on between attempts
+	retryAttempts int
+	retryBackoff  time.Duration
+	sleep         func(time.Duration)
+
+	counters writerCounters
+}
+
+// segment is the file a FileWriter is currently appending to, from when the
+// writer started on it until it is rotated away
+type segment struct {
+	file     *os.File // Held open in buffered mode only; nil after Close
+	pending  []byte   // Buffered mode only: whole lines not yet on disk
+	size     int64    // Bytes in the file, buffered ones included
+	openedAt time.Time
+}
+
+// writerCounters are a FileWriter's running totals
+type writerCounters struct {
+	lines        atomic.Int64
+	bytes        atomic.Int64
+	flushes      atomic.Int64
+	rotations    atomic.Int64
+	errors       atomic.Int64
+	segmentBytes atomic.Int64
+	segmentStart atomic.Int64 // Unix nanoseconds; zero before the first segment
+
+	// Writes failed in a row, and whether the last one failed for a full disk
+	failedWrites atomic.Int64
+	diskFull     atomic.Bool
+}
+
+// WriterStats is a snapshot of a FileWriter's counters
+type WriterStats struct {
+	File            string
+	Lines           int64
+	Bytes           int64
+	Flushes         int64 // Buffer writes to disk
+	Rotations       int64
+	Errors          int64
+	SegmentBytes    int64
+	SegmentOpenedAt time.Time // Zero before the first write
+	QueueDepth      int
+	FailedWrites    int64 // In a row, since the last write that succeeded
+	DiskFull        bool
 }
 
 func NewFileWriter(filePath string) (*FileWriter, error) {
@@ -20,45 +127,478 @@ func NewFileWriter(filePath string) (*FileWriter, error) {
 	}
 
 	return &FileWriter{
-		filePath: filePath,
+		filePath:      filePath,
+		name:          filepath.Base(filePath),
+		rotatedFiles:  DefaultRotatedFiles,
+		retryAttempts: DefaultWriteRetryAttempts,
+		retryBackoff:  DefaultWriteRetryBackoff,
+		sleep:         time.Sleep,
 	}, nil
 }
 
-func (w *FileWriter) WriteJSON(data interface{}) error {
+// NewBufferedFileWriter creates a FileWriter that buffers writes in memory.
+// Data reaches disk when Flush is called, typically by a shared Flusher, or
+// once DefaultWriteBufferSize bytes are buffered.
+func NewBufferedFileWriter(filePath string) (*FileWriter, error) {
+	return NewBufferedFileWriterSize(filePath, DefaultWriteBufferSize)
+}
+
+// NewBufferedFileWriterSize creates a buffered FileWriter that writes to disk
+// whenever more than size bytes are buffered
+func NewBufferedFileWriterSize(filePath string, size int) (*FileWriter, error) {
+	w, err := NewFileWriter(filePath)
+	if err != nil {
+		return nil, err
+	}
+	w.buffered = true
+	w.bufferSize = size
+	return w, nil
+}
+
+// envelopeLine wraps an OTLP JSON payload in the canonical JSONL envelope:
+// {"receivedAt": "<RFC3339Nano>", "data": <payload>}
+// with the request's batch, when tagged, as
+// "batch": {"id": "...", "traceparent": "...", "tracestate": "..."}
+func envelopeLine(receivedAt time.Time, batch *BatchContext, data string) string {
+	if batch != nil {
+		if meta, err := json.Marshal(batch); err == nil {
+			return fmt.Sprintf(`{"receivedAt":%q,"batch":%s,"data":%s}`, receivedAt.UTC().Format(time.RFC3339Nano), meta, data)
+		}
+	}
+	return fmt.Sprintf(`{"receivedAt":%q,"data":%s}`, receivedAt.UTC().Format(time.RFC3339Nano), data)
+}
+
+// SetMaxFileSize enables rotation once the file reaches maxBytes.
+// The full file is renamed to <name>.1, older generations shift up one,
+// and a fresh file is started.
+func (w *FileWriter) SetMaxFileSize(maxBytes int64) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	f, err := os.OpenFile(w.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", w.filePath, err)
+	w.maxBytes = maxBytes
+}
+
+// SetRotatedFiles sets how many rotated generations are kept; the oldest is
+// deleted on rotation. At least one is always kept so the processor can
+// finish reading the file it was on.
+func (w *FileWriter) SetRotatedFiles(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if n < 1 {
+		n = 1
 	}
-	defer f.Close()
+	w.rotatedFiles = n
+}
+
+// SetSync fsyncs data as it is written to the file, so it survives a power
+// loss. Unbuffered writers sync after each line; buffered writers sync each
+// time the buffer is written out, so up to a flush interval can still be lost.
+func (w *FileWriter) SetSync(enabled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.sync = enabled
+}
+
+// SetCompressRotated gzips each newly rotated generation to <name>.1.gz in
+// the background. The uncompressed file is removed once the archive is
+// complete; a failed compression is logged and leaves it in place.
+func (w *FileWriter) SetCompressRotated(enabled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.compress = enabled
+}
+
+// SetQueueSize bounds how many requests may wait on the writer at once, so
+// a slow disk turns into rejected requests rather than a growing backlog of
+// blocked ones. Zero leaves it unbounded. It must be called before any
+// Reserve.
+func (w *FileWriter) SetQueueSize(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
+	w.queue = nil
+	if n > 0 {
+		w.queue = make(chan struct{}, n)
+	}
+}
+
+// SetWriteRetry sets how many times a line is tried before its write fails,
+// waiting backoff before the first retry and doubling the wait for each one
+// after. Retries hold up the writer's other writes, so keep both small; one
+// attempt disables retrying.
+func (w *FileWriter) SetWriteRetry(attempts int, backoff time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if attempts < 1 {
+		attempts = 1
+	}
+	w.retryAttempts = attempts
+	w.retryBackoff = backoff
+}
+
+// Reserve claims a place in the write queue for one request's writes without
+// blocking, reporting false when the queue is full. The returned release
+// gives the place back once the request is done writing.
+func (w *FileWriter) Reserve() (release func(), ok bool) {
+	if w.queue == nil {
+		return func() {}, true
+	}
+	select {
+	case w.queue <- struct{}{}:
+		return func() { <-w.queue }, true
+	default:
+		return nil, false
+	}
+}
+
+// QueueDepth returns how many requests hold a place in the write queue
+func (w *FileWriter) QueueDepth() int {
+	return len(w.queue)
+}
+
+// Failing reports whether the writer's recent writes have all failed, as
+// opposed to a transient error it recovered from
+func (w *FileWriter) Failing() bool {
+	return w.counters.failedWrites.Load() >= failingWrites
+}
+
+// Rotations returns the number of rotations performed
+func (w *FileWriter) Rotations() int64 {
+	return w.counters.rotations.Load()
+}
+
+// Stats returns the writer's counters without waiting on writes in progress
+func (w *FileWriter) Stats() WriterStats {
+	stats := WriterStats{
+		File:         w.name,
+		Lines:        w.counters.lines.Load(),
+		Bytes:        w.counters.bytes.Load(),
+		Flushes:      w.counters.flushes.Load(),
+		Rotations:    w.counters.rotations.Load(),
+		Errors:       w.counters.errors.Load(),
+		SegmentBytes: w.counters.segmentBytes.Load(),
+		QueueDepth:   w.QueueDepth(),
+		FailedWrites: w.counters.failedWrites.Load(),
+		DiskFull:     w.counters.diskFull.Load(),
+	}
+	if start := w.counters.segmentStart.Load(); start != 0 {
+		stats.SegmentOpenedAt = time.Unix(0, start)
+	}
+	return stats
+}
+
+func (w *FileWriter) WriteJSON(data interface{}) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal data to JSON: %w", err)
 	}
 
-	if _, err := f.Write(append(jsonData, '\n')); err != nil {
-		return fmt.Errorf("failed to write to file %s: %w", w.filePath, err)
-	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	return nil
+	return w.write(append(jsonData, '\n'))
 }
 
 func (w *FileWriter) WriteLine(s string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	return w.write([]byte(s + "\n"))
+}
+
+// write appends one line to the active segment, rotating first when a
+// rotation policy says the segment is done, and retrying as configured. A
+// failed attempt leaves nothing of the line behind, so a retry can't
+// duplicate it. Callers must hold w.mu.
+func (w *FileWriter) write(data []byte) error {
+	err := w.appendLine(data)
+	backoff := w.retryBackoff
+	for attempt := 1; err != nil && attempt < w.retryAttempts; attempt++ {
+		w.sleep(backoff)
+		backoff *= 2
+		err = w.appendLine(data)
+	}
+
+	if err != nil {
+		w.counters.errors.Add(1)
+		w.counters.failedWrites.Add(1)
+		w.counters.diskFull.Store(errors.Is(err, syscall.ENOSPC))
+		return classifyWriteError(err)
+	}
+	w.counters.failedWrites.Store(0)
+	w.counters.diskFull.Store(false)
+	return nil
+}
+
+// classifyWriteError marks errors from a full disk as ErrDiskFull
+func classifyWriteError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %w", ErrDiskFull, err)
+	}
+	return err
+}
+
+func (w *FileWriter) appendLine(data []byte) error {
+	seg, err := w.segment()
+	if err != nil {
+		return err
+	}
+	if w.rotationDue(seg) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+		if seg, err = w.segment(); err != nil {
+			return err
+		}
+	}
+
+	if err := w.writeData(seg, data); err != nil {
+		return err
+	}
+	seg.size += int64(len(data))
+	w.counters.lines.Add(1)
+	w.counters.bytes.Add(int64(len(data)))
+	w.counters.segmentBytes.Store(seg.size)
+	return nil
+}
+
+// segment returns the active segment, starting one on the file as it is on
+// disk if there is none. Callers must hold w.mu.
+func (w *FileWriter) segment() (*segment, error) {
+	if w.active != nil {
+		return w.active, nil
+	}
+
+	seg := &segment{openedAt: time.Now()}
+	info, err := os.Stat(w.filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to stat file %s: %w", w.filePath, err)
+	}
+	if err == nil {
+		seg.size = info.Size()
+	}
+
+	w.active = seg
+	w.counters.segmentBytes.Store(seg.size)
+	w.counters.segmentStart.Store(seg.openedAt.UnixNano())
+	return seg, nil
+}
+
+// rotationDue evaluates the rotation policies against seg before a write.
+// The only policy is size: a segment at maxBytes or more is done.
+func (w *FileWriter) rotationDue(seg *segment) bool {
+	return w.maxBytes > 0 && seg.size >= w.maxBytes
+}
+
+func (w *FileWriter) writeData(seg *segment, data []byte) error {
+	if w.buffered {
+		if seg.file == nil {
+			f, err := os.OpenFile(w.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return fmt.Errorf("failed to open file %s: %w", w.filePath, err)
+			}
+			seg.file = f
+		}
+
+		// Flush whole lines only, so the processor never reads a partial record
+		if len(seg.pending) > 0 && len(seg.pending)+len(data) > w.bufferSize {
+			if err := w.flushBuffer(seg); err != nil {
+				return err
+			}
+		}
+		seg.pending = append(seg.pending, data...)
+
+		// A line larger than the buffer goes straight out. Once buffered the
+		// line is accepted, so a failure here is left for the next write or
+		// flush to retry rather than failing this one.
+		if len(seg.pending) > w.bufferSize {
+			if err := w.flushBuffer(seg); err != nil {
+				log.Printf("Failed to flush %s, will retry: %v", w.filePath, err)
+			}
+		}
+		return nil
+	}
+
 	f, err := os.OpenFile(w.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open file %s: %w", w.filePath, err)
 	}
 	defer f.Close()
 
-	if _, err := f.WriteString(s + "\n"); err != nil {
+	// Cut off whatever part of the line made it, so a retry starts clean.
+	// The end is read from the file just opened rather than seg.size, in
+	// case something outside the writer truncated or replaced the file.
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek file %s: %w", w.filePath, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Truncate(end)
 		return fmt.Errorf("failed to write to file %s: %w", w.filePath, err)
 	}
+	if w.sync {
+		if err := f.Sync(); err != nil {
+			f.Truncate(end)
+			return fmt.Errorf("failed to sync file %s: %w", w.filePath, err)
+		}
+	}
+
+	return nil
+}
+
+// flushBuffer writes seg's buffer to the file, syncing it in durable mode.
+// Whatever a failed write left unwritten stays buffered for the next flush.
+// Callers must hold w.mu and have an open buffer.
+func (w *FileWriter) flushBuffer(seg *segment) error {
+	if len(seg.pending) == 0 {
+		return nil
+	}
+	n, err := seg.file.Write(seg.pending)
+	if err != nil {
+		seg.pending = seg.pending[n:]
+		return fmt.Errorf("failed to flush file %s: %w", w.filePath, err)
+	}
+	seg.pending = seg.pending[:0]
+	if w.sync {
+		if err := seg.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync file %s: %w", w.filePath, err)
+		}
+	}
+	w.counters.flushes.Add(1)
+	return nil
+}
 
+// closeSegment flushes and closes the active segment's file, if open. The
+// segment stays active, and a later write reopens it. Callers must hold w.mu.
+func (w *FileWriter) closeSegment() error {
+	seg := w.active
+	if seg == nil || seg.file == nil {
+		return nil
+	}
+
+	// Keep the file open while lines are still buffered, so they aren't lost
+	if err := w.flushBuffer(seg); err != nil {
+		return err
+	}
+	closeErr := seg.file.Close()
+	seg.pending = nil
+	seg.file = nil
+
+	if closeErr != nil {
+		return fmt.Errorf("failed to close file %s: %w", w.filePath, closeErr)
+	}
+	return nil
+}
+
+// Rotate rotates the file now, whatever its size, as the size policy would.
+// It reports false without rotating when nothing has been written to the
+// file since it was last rotated, so repeated calls don't leave a trail of
+// empty generations.
+func (w *FileWriter) Rotate() (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seg, err := w.segment()
+	if err != nil {
+		return false, err
+	}
+	if seg.size == 0 {
+		return false, nil
+	}
+	if err := w.rotate(); err != nil {
+		w.counters.errors.Add(1)
+		return false, err
+	}
+	return true, nil
+}
+
+// rotate renames the active segment's file out of the way; the next write
+// starts a fresh one. Callers must hold w.mu.
+func (w *FileWriter) rotate() error {
+	// Close the buffered file first so no data lands after the rename
+	if err := w.closeSegment(); err != nil {
+		return err
+	}
+
+	w.generations.Lock()
+	defer w.generations.Unlock()
+
+	// Shift <name>.N-1 to <name>.N, dropping the oldest generation. Renames
+	// keep each file's inode, which the processor uses to follow rotation.
+	// Compressed generations shift alongside uncompressed ones.
+	for _, suffix := range []string{"", ".gz"} {
+		oldest := w.rotatedPath(w.rotatedFiles) + suffix
+		if err := os.Remove(oldest); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", oldest, err)
+		}
+		for i := w.rotatedFiles - 1; i >= 1; i-- {
+			if err := os.Rename(w.rotatedPath(i)+suffix, w.rotatedPath(i+1)+suffix); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to rotate file %s: %w", w.rotatedPath(i)+suffix, err)
+			}
+		}
+	}
+	if err := os.Rename(w.filePath, w.rotatedPath(1)); err != nil {
+		return fmt.Errorf("failed to rotate file %s: %w", w.filePath, err)
+	}
+
+	w.active = nil
+	w.counters.rotations.Add(1)
+	w.counters.segmentBytes.Store(0)
+
+	if w.compress {
+		// Opened now, while it is still <name>.1, since the next rotation
+		// may shift it before the compression gets going
+		path := w.rotatedPath(1)
+		src, err := os.Open(path)
+		if err != nil {
+			log.Printf("Failed to compress rotated file %s: %v", path, err)
+			return nil
+		}
+		rotatedFiles := w.rotatedFiles
+		w.compressing.Add(1)
+		go func() {
+			defer w.compressing.Done()
+			defer src.Close()
+			if err := w.compressRotated(src, rotatedFiles); err != nil {
+				log.Printf("Failed to compress rotated file %s: %v", path, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// rotatedPath returns the path of the nth rotated generation
+func (w *FileWriter) rotatedPath(n int) string {
+	return fmt.Sprintf("%s.%d", w.filePath, n)
+}
+
+// Close flushes any buffered data and closes the file, waiting for any
+// background compression to finish. A later write reopens it.
+func (w *FileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.compressing.Wait()
+
+	return w.closeSegment()
+}
+
+// Flush writes any buffered data to disk. It is a no-op for unbuffered writers.
+func (w *FileWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.active == nil || w.active.file == nil {
+		return nil
+	}
+
+	if err := w.flushBuffer(w.active); err != nil {
+		w.counters.errors.Add(1)
+		return err
+	}
 	return nil
 }
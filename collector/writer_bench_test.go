@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// benchmarkLine is roughly the size of a small OTLP logs export
+var benchmarkLine = `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"` +
+	strings.Repeat("x", 900) + `"}}]}]}]}`
+
+// BenchmarkFileWriterWriteThrough opens and closes the file on every write
+func BenchmarkFileWriterWriteThrough(b *testing.B) {
+	writer, err := NewFileWriter(filepath.Join(b.TempDir(), "logs.jsonl"))
+	if err != nil {
+		b.Fatalf("Failed to create writer: %v", err)
+	}
+	benchmarkFileWriter(b, writer)
+}
+
+// BenchmarkFileWriterBuffered keeps the file open and buffers writes
+func BenchmarkFileWriterBuffered(b *testing.B) {
+	writer, err := NewBufferedFileWriter(filepath.Join(b.TempDir(), "logs.jsonl"))
+	if err != nil {
+		b.Fatalf("Failed to create writer: %v", err)
+	}
+	defer writer.Close()
+	benchmarkFileWriter(b, writer)
+}
+
+// BenchmarkFileWriterWriteThroughFsync syncs the file after every write
+func BenchmarkFileWriterWriteThroughFsync(b *testing.B) {
+	writer, err := NewFileWriter(filepath.Join(b.TempDir(), "logs.jsonl"))
+	if err != nil {
+		b.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetSync(true)
+	benchmarkFileWriter(b, writer)
+}
+
+// BenchmarkFileWriterBufferedFsync syncs each time the buffer is written out
+func BenchmarkFileWriterBufferedFsync(b *testing.B) {
+	writer, err := NewBufferedFileWriter(filepath.Join(b.TempDir(), "logs.jsonl"))
+	if err != nil {
+		b.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetSync(true)
+	defer writer.Close()
+	benchmarkFileWriter(b, writer)
+}
+
+func benchmarkFileWriter(b *testing.B, writer *FileWriter) {
+	b.SetBytes(int64(len(benchmarkLine) + 1))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := writer.WriteLine(benchmarkLine); err != nil {
+			b.Fatalf("Failed to write line: %v", err)
+		}
+	}
+}
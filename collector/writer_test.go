@@ -0,0 +1,757 @@
+package collector
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestFlusherFlushesOnTick(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.jsonl")
+	writer, err := NewBufferedFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	flusher := NewFlusher(50*time.Millisecond, writer)
+	flusher.Start()
+	defer flusher.Stop()
+
+	if err := writer.WriteLine("line-1"); err != nil {
+		t.Fatalf("Failed to write line: %v", err)
+	}
+
+	if got := readFile(t, filePath); got != "" {
+		t.Errorf("Expected buffered data not to be on disk yet, got %q", got)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if got := readFile(t, filePath); got != "line-1\n" {
+		t.Errorf("Expected data on disk after flush tick, got %q", got)
+	}
+}
+
+func TestFlusherStopFlushesRemainingData(t *testing.T) {
+	dir := t.TempDir()
+	tracePath := filepath.Join(dir, "traces.jsonl")
+	logsPath := filepath.Join(dir, "logs.jsonl")
+
+	traceWriter, err := NewBufferedFileWriter(tracePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	logsWriter, err := NewBufferedFileWriter(logsPath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	// Long interval so only Stop can flush
+	flusher := NewFlusher(time.Hour, traceWriter, logsWriter)
+	flusher.Start()
+
+	if err := traceWriter.WriteLine("trace"); err != nil {
+		t.Fatalf("Failed to write line: %v", err)
+	}
+	if err := logsWriter.WriteJSON(map[string]string{"body": "log"}); err != nil {
+		t.Fatalf("Failed to write JSON: %v", err)
+	}
+
+	flusher.Stop()
+
+	if got := readFile(t, tracePath); got != "trace\n" {
+		t.Errorf("Expected trace data flushed on stop, got %q", got)
+	}
+	if got := readFile(t, logsPath); got != "{\"body\":\"log\"}\n" {
+		t.Errorf("Expected log data flushed on stop, got %q", got)
+	}
+}
+
+func TestFileWriterRotatesAtMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "logs.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetMaxFileSize(20)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writer.WriteLine("0123456789"); err != nil {
+				t.Errorf("Failed to write line: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Each file holds two 11-byte lines before rotating
+	if got := writer.Rotations(); got != 4 {
+		t.Errorf("Expected 4 rotations, got %d", got)
+	}
+
+	rotated, err := filepath.Glob(filePath + ".*")
+	if err != nil {
+		t.Fatalf("Failed to list rotated files: %v", err)
+	}
+	if len(rotated) != 4 {
+		t.Fatalf("Expected 4 rotated files, got %d", len(rotated))
+	}
+
+	lines := strings.Count(readFile(t, filePath), "\n")
+	for _, path := range rotated {
+		lines += strings.Count(readFile(t, path), "\n")
+	}
+	if lines != 10 {
+		t.Errorf("Expected 10 lines across all files, got %d", lines)
+	}
+}
+
+func TestFileWriterShiftsRotatedGenerations(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetMaxFileSize(1)
+	writer.SetRotatedFiles(2)
+
+	for _, line := range []string{"a", "b", "c", "d"} {
+		if err := writer.WriteLine(line); err != nil {
+			t.Fatalf("Failed to write line: %v", err)
+		}
+	}
+
+	// .1 is the newest generation; "a" fell off the end
+	expected := map[string]string{
+		filePath:        "d\n",
+		filePath + ".1": "c\n",
+		filePath + ".2": "b\n",
+	}
+	for path, want := range expected {
+		if got := readFile(t, path); got != want {
+			t.Errorf("Expected %s to hold %q, got %q", filepath.Base(path), want, got)
+		}
+	}
+	if _, err := os.Stat(filePath + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 generations to be kept, stat .3 returned %v", err)
+	}
+}
+
+func TestFileWriterRotationPreservesInode(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "logs.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetMaxFileSize(1)
+
+	writer.WriteLine("first")
+	before, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+
+	writer.WriteLine("second")
+	rotated, err := os.Stat(filePath + ".1")
+	if err != nil {
+		t.Fatalf("Failed to stat rotated file: %v", err)
+	}
+	current, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+
+	if !os.SameFile(before, rotated) {
+		t.Error("Expected the rotated generation to be the original file")
+	}
+	if os.SameFile(before, current) {
+		t.Error("Expected a fresh file after rotation")
+	}
+}
+
+func TestBufferedFileWriterRotationFlushesFirst(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	writer, err := NewBufferedFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetMaxFileSize(5)
+
+	writer.WriteLine("first")
+	writer.WriteLine("second")
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	rotated, _ := filepath.Glob(filePath + ".*")
+	if len(rotated) != 1 {
+		t.Fatalf("Expected 1 rotated file, got %d", len(rotated))
+	}
+	if got := readFile(t, rotated[0]); got != "first\n" {
+		t.Errorf("Expected rotated file to hold the first line, got %q", got)
+	}
+	if got := readFile(t, filePath); got != "second\n" {
+		t.Errorf("Expected current file to hold the second line, got %q", got)
+	}
+}
+
+func TestBufferedFileWriterCloseFlushesAndReopens(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "logs.jsonl")
+	writer, err := NewBufferedFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	writer.WriteLine("before-close")
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	if got := readFile(t, filePath); got != "before-close\n" {
+		t.Errorf("Expected data on disk after Close, got %q", got)
+	}
+
+	writer.WriteLine("after-close")
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	if got := readFile(t, filePath); got != "before-close\nafter-close\n" {
+		t.Errorf("Expected append after reopening, got %q", got)
+	}
+}
+
+func TestBufferedFileWriterFlushesWholeLines(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "logs.jsonl")
+	writer, err := NewBufferedFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	defer writer.Close()
+
+	line := strings.Repeat("x", 1500)
+	for i := 0; i < 10; i++ {
+		writer.WriteLine(line)
+	}
+
+	// Whatever reached disk before an explicit flush must end on a line boundary
+	if got := readFile(t, filePath); got != "" && !strings.HasSuffix(got, "\n") {
+		t.Errorf("Expected only whole lines on disk, got %d bytes ending mid-line", len(got))
+	}
+}
+
+func TestBufferedFileWriterFlushesAtThreshold(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.jsonl")
+	writer, err := NewBufferedFileWriterSize(filePath, 16)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	defer writer.Close()
+
+	writer.WriteLine("line-1")
+	if got := readFile(t, filePath); got != "" {
+		t.Errorf("Expected data below the threshold to stay buffered, got %q", got)
+	}
+
+	// The second line no longer fits, so the first is written ahead of it
+	writer.WriteLine("line-2-xx")
+	if got := readFile(t, filePath); got != "line-1\n" {
+		t.Errorf("Expected the buffered line on disk after crossing the threshold, got %q", got)
+	}
+}
+
+func TestFileWriterCompressesRotatedGenerations(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetMaxFileSize(1)
+	writer.SetRotatedFiles(2)
+	writer.SetCompressRotated(true)
+
+	writer.WriteLine("a")
+	inode := fileInode(t, filePath)
+	for _, line := range []string{"b", "c"} {
+		if err := writer.WriteLine(line); err != nil {
+			t.Fatalf("Failed to write line: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	expected := map[string]string{
+		filePath + ".1.gz": "b\n",
+		filePath + ".2.gz": "a\n",
+	}
+	for path, want := range expected {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", filepath.Base(path), err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", filepath.Base(path), err)
+		}
+		data, _ := io.ReadAll(zr)
+		f.Close()
+		if string(data) != want {
+			t.Errorf("Expected %s to hold %q, got %q", filepath.Base(path), want, data)
+		}
+		if path == filePath+".2.gz" && zr.Comment != fmt.Sprintf(gzipInodeComment, inode) {
+			t.Errorf("Expected the source inode %d in the header, got %q", inode, zr.Comment)
+		}
+	}
+
+	leftovers, _ := filepath.Glob(filePath + ".[0-9]")
+	tmp, _ := filepath.Glob(filePath + ".*.tmp")
+	if len(leftovers) != 0 || len(tmp) != 0 {
+		t.Errorf("Expected only compressed generations, found %v %v", leftovers, tmp)
+	}
+}
+
+func TestCompressRotatedFailureKeepsSource(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "logs.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := os.WriteFile(filePath+".1", []byte("line\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	src, err := os.Open(filePath + ".1")
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer src.Close()
+	// A directory in the way of the temp archive makes compression fail
+	if err := os.Mkdir(fmt.Sprintf("%s.%d.gz.tmp", filePath, fileInode(t, filePath+".1")), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	if err := writer.compressRotated(src, 1); err == nil {
+		t.Fatal("Expected compression to fail")
+	}
+	if got := readFile(t, filePath+".1"); got != "line\n" {
+		t.Errorf("Expected the uncompressed file to be kept, got %q", got)
+	}
+	if _, err := os.Stat(filePath + ".1.gz"); !os.IsNotExist(err) {
+		t.Errorf("Expected no archive after a failure, stat returned %v", err)
+	}
+}
+
+func TestCompressRotatedFollowsGenerationShiftedMeanwhile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "logs.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetRotatedFiles(2)
+
+	writer.WriteLine("a")
+	if _, err := writer.Rotate(); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	// The compression of "a" as <name>.1 is still running when the next
+	// rotation shifts it to <name>.2 and puts "b" in its place
+	src, err := os.Open(filePath + ".1")
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer src.Close()
+	writer.WriteLine("b")
+	if _, err := writer.Rotate(); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+
+	if err := writer.compressRotated(src, 2); err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	if got := readFile(t, filePath+".1"); got != "b\n" {
+		t.Errorf("Expected the newer generation left alone, got %q", got)
+	}
+	f, err := os.Open(filePath + ".2.gz")
+	if err != nil {
+		t.Fatalf("Expected the archive beside the shifted generation: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != "a\n" {
+		t.Errorf("Expected the archive to hold %q, got %q", "a\n", data)
+	}
+	if _, err := os.Stat(filePath + ".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected the compressed generation removed, stat returned %v", err)
+	}
+
+	// A generation dropped before its archive is done leaves nothing behind
+	src.Seek(0, io.SeekStart)
+	os.Remove(filePath + ".2.gz")
+	if err := writer.compressRotated(src, 2); err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	if leftovers, _ := filepath.Glob(filePath + ".*.gz*"); len(leftovers) != 0 {
+		t.Errorf("Expected no archive for a dropped generation, found %v", leftovers)
+	}
+}
+
+func fileInode(t *testing.T, path string) uint64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	return getInode(info)
+}
+
+func TestFileWriterSyncMode(t *testing.T) {
+	dir := t.TempDir()
+	unbuffered, err := NewFileWriter(filepath.Join(dir, "metrics.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	buffered, err := NewBufferedFileWriter(filepath.Join(dir, "logs.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	for _, writer := range []*FileWriter{unbuffered, buffered} {
+		writer.SetSync(true)
+		for _, line := range []string{"first", "second"} {
+			if err := writer.WriteLine(line); err != nil {
+				t.Fatalf("Failed to write line: %v", err)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+		if got := readFile(t, writer.filePath); got != "first\nsecond\n" {
+			t.Errorf("Expected both lines in %s, got %q", filepath.Base(writer.filePath), got)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Failed to close writer: %v", err)
+		}
+	}
+}
+
+// segmentsOldestFirst returns the contents of every generation of filePath,
+// oldest first, ending with the live file
+func segmentsOldestFirst(t *testing.T, filePath string) []string {
+	t.Helper()
+	var segments []string
+	for n := 1; ; n++ {
+		if _, err := os.Stat(fmt.Sprintf("%s.%d", filePath, n)); err != nil {
+			break
+		}
+		segments = append([]string{readFile(t, fmt.Sprintf("%s.%d", filePath, n))}, segments...)
+	}
+	return append(segments, readFile(t, filePath))
+}
+
+// TestFileWriterConcurrentRotation hammers writers with concurrent writes
+// while size rotation, manual rotation and flushes run, then checks that
+// every line landed exactly once, whole, in order for its writer, and
+// within a single segment
+func TestFileWriterConcurrentRotation(t *testing.T) {
+	for _, buffered := range []bool{false, true} {
+		for seed := int64(1); seed <= 3; seed++ {
+			t.Run(fmt.Sprintf("buffered=%v/seed=%d", buffered, seed), func(t *testing.T) {
+				testConcurrentRotation(t, buffered, seed)
+			})
+		}
+	}
+}
+
+func testConcurrentRotation(t *testing.T, buffered bool, seed int64) {
+	const writers, linesPerWriter = 8, 150
+
+	filePath := filepath.Join(t.TempDir(), "metrics.jsonl")
+	newWriter := NewFileWriter
+	if buffered {
+		newWriter = func(path string) (*FileWriter, error) { return NewBufferedFileWriterSize(path, 512) }
+	}
+	writer, err := newWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	// Enough generations are kept that none is dropped
+	writer.SetMaxFileSize(4096)
+	writer.SetRotatedFiles(200)
+
+	// Line lengths vary with the seed so rotations fall at different points
+	rng := rand.New(rand.NewSource(seed))
+	padding := make([]int, writers*linesPerWriter)
+	for i := range padding {
+		padding[i] = rng.Intn(200)
+	}
+	line := func(w, i int) string {
+		return fmt.Sprintf("w%d-%d-%s", w, i, strings.Repeat("x", padding[w*linesPerWriter+i]))
+	}
+
+	done := make(chan struct{})
+	var background sync.WaitGroup
+	var manual int64
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			rotated, err := writer.Rotate()
+			if err != nil {
+				t.Errorf("Failed to rotate: %v", err)
+			}
+			if rotated {
+				manual++
+			}
+			if err := writer.Flush(); err != nil {
+				t.Errorf("Failed to flush: %v", err)
+			}
+			time.Sleep(time.Duration(500+rng.Intn(1500)) * time.Microsecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < linesPerWriter; i++ {
+				if err := writer.WriteLine(line(w, i)); err != nil {
+					t.Errorf("Failed to write line: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	background.Wait()
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+
+	segments := segmentsOldestFirst(t, filePath)
+	if len(segments) > 200 {
+		t.Fatalf("Expected fewer than 200 rotations, got %d", len(segments)-1)
+	}
+	next := make([]int, writers)
+	for n, segment := range segments {
+		if segment != "" && !strings.HasSuffix(segment, "\n") {
+			t.Fatalf("Segment %d ends mid-line: %q", n, segment[max(0, len(segment)-40):])
+		}
+		for _, got := range strings.Split(strings.TrimSuffix(segment, "\n"), "\n") {
+			if got == "" {
+				continue
+			}
+			var w, i int
+			if _, err := fmt.Sscanf(got, "w%d-%d-", &w, &i); err != nil || w < 0 || w >= writers {
+				t.Fatalf("Segment %d has a malformed line %q", n, got)
+			}
+			if i != next[w] || got != line(w, i) {
+				t.Fatalf("Segment %d: expected line %d of writer %d next, got %q", n, next[w], w, got)
+			}
+			next[w]++
+		}
+	}
+	for w, n := range next {
+		if n != linesPerWriter {
+			t.Errorf("Expected %d lines from writer %d, found %d", linesPerWriter, w, n)
+		}
+	}
+
+	stats := writer.Stats()
+	if stats.Lines != writers*linesPerWriter || stats.Errors != 0 {
+		t.Errorf("Expected %d lines and no errors counted, got %+v", writers*linesPerWriter, stats)
+	}
+	if stats.Rotations != int64(len(segments)-1) || stats.Rotations < manual {
+		t.Errorf("Expected %d rotations, %d of them manual, got %d", len(segments)-1, manual, stats.Rotations)
+	}
+	if stats.SegmentBytes != int64(len(segments[len(segments)-1])) {
+		t.Errorf("Expected the live segment's %d bytes counted, got %d", len(segments[len(segments)-1]), stats.SegmentBytes)
+	}
+}
+
+func TestFileWriterRotateSkipsEmptyFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "logs.jsonl")
+	writer, err := NewBufferedFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	if rotated, err := writer.Rotate(); err != nil || rotated {
+		t.Errorf("Expected nothing to rotate before the first write, got %v (%v)", rotated, err)
+	}
+
+	// Rotation without a size limit, with the line still buffered
+	if err := writer.WriteLine("line-1"); err != nil {
+		t.Fatalf("Failed to write line: %v", err)
+	}
+	if rotated, err := writer.Rotate(); err != nil || !rotated {
+		t.Fatalf("Expected the file rotated, got %v (%v)", rotated, err)
+	}
+	if got := readFile(t, filePath+".1"); got != "line-1\n" {
+		t.Errorf("Expected the buffered line flushed into the rotated file, got %q", got)
+	}
+
+	if rotated, err := writer.Rotate(); err != nil || rotated {
+		t.Errorf("Expected nothing to rotate right after a rotation, got %v (%v)", rotated, err)
+	}
+	if _, err := os.Stat(filePath + ".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected no empty generation, got %v", err)
+	}
+	if stats := writer.Stats(); stats.Rotations != 1 || stats.Lines != 1 || stats.Flushes != 1 {
+		t.Errorf("Expected 1 rotation, line and flush, got %+v", stats)
+	}
+}
+
+func TestFileWriterRetriesTransientFailures(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetWriteRetry(3, 10*time.Millisecond)
+
+	// A directory where the file belongs fails the first attempt; it clears
+	// while the writer backs off
+	if err := os.Mkdir(filePath, 0755); err != nil {
+		t.Fatalf("Failed to block the file: %v", err)
+	}
+	var waits []time.Duration
+	writer.sleep = func(d time.Duration) {
+		waits = append(waits, d)
+		os.Remove(filePath)
+	}
+
+	if err := writer.WriteLine("line-1"); err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	if len(waits) != 1 || waits[0] != 10*time.Millisecond {
+		t.Errorf("Expected a single 10ms backoff, got %v", waits)
+	}
+	if got := readFile(t, filePath); got != "line-1\n" {
+		t.Errorf("Expected the line written once, got %q", got)
+	}
+	if stats := writer.Stats(); stats.Errors != 0 || stats.FailedWrites != 0 {
+		t.Errorf("Expected a recovered write not to count as failed, got %+v", stats)
+	}
+}
+
+func TestFileWriterFailsPersistently(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "logs.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetWriteRetry(2, 0)
+	var attempts int
+	writer.sleep = func(time.Duration) { attempts++ }
+
+	if err := os.Mkdir(filePath, 0755); err != nil {
+		t.Fatalf("Failed to block the file: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if writer.Failing() {
+			t.Fatalf("Expected %d failed writes not to count as failing", i-1)
+		}
+		if err := writer.WriteLine("line"); err == nil || errors.Is(err, ErrDiskFull) {
+			t.Fatalf("Expected a write error other than a full disk, got %v", err)
+		}
+	}
+	if !writer.Failing() || attempts != 3 {
+		t.Errorf("Expected the writer failing after 3 writes retried once each, got %v after %d retries", writer.Failing(), attempts)
+	}
+
+	os.Remove(filePath)
+	if err := writer.WriteLine("line"); err != nil {
+		t.Fatalf("Failed to write once unblocked: %v", err)
+	}
+	if stats := writer.Stats(); writer.Failing() || stats.FailedWrites != 0 || stats.Errors != 3 {
+		t.Errorf("Expected a successful write to clear the failure, got %+v", stats)
+	}
+}
+
+func TestFileWriterClassifiesDiskFull(t *testing.T) {
+	// Writes to /dev/full always fail with ENOSPC
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("No /dev/full to simulate a full disk")
+	}
+	writer, err := NewFileWriter("/dev/full")
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetWriteRetry(1, 0)
+
+	if err := writer.WriteLine("line"); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("Expected ErrDiskFull, got %v", err)
+	}
+	if !writer.Stats().DiskFull {
+		t.Error("Expected the writer to report a full disk")
+	}
+}
+
+func TestFileWriterFailedWriteTruncatesToActualEnd(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "traces.jsonl")
+	writer, err := NewFileWriter(filePath)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	writer.SetWriteRetry(1, 0)
+
+	if err := writer.WriteLine("line-1"); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	// Something outside the writer empties the file, so the writer's own
+	// idea of its size is stale
+	if err := os.Truncate(filePath, 0); err != nil {
+		t.Fatalf("Failed to truncate: %v", err)
+	}
+
+	// A file size limit lets part of the next line through before failing
+	// (the runtime ignores SIGXFSZ, so the write returns EFBIG)
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Skipf("Cannot read the file size limit: %v", err)
+	}
+	lowered := syscall.Rlimit{Cur: 5, Max: limit.Max}
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &lowered); err != nil {
+		t.Skipf("Cannot lower the file size limit: %v", err)
+	}
+	err = writer.WriteLine("line-2")
+	syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit)
+	if err == nil {
+		t.Fatal("Expected the write past the size limit to fail")
+	}
+
+	if got := readFile(t, filePath); got != "" {
+		t.Errorf("Expected the partial line cut back to the empty file, got %q", got)
+	}
+}
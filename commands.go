@@ -0,0 +1,281 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zmack/otis/aggregator"
+	"github.com/zmack/otis/buildinfo"
+	"github.com/zmack/otis/config"
+	"github.com/zmack/otis/healthcheck"
+)
+
+// runCommand runs a CLI subcommand and returns the process exit code
+func runCommand(cfg *config.Config, name string, args []string) int {
+	switch name {
+	case "import-console-csv":
+		return runImportConsoleCSV(cfg, args)
+	case "map-api-key":
+		return runMapAPIKey(cfg, args)
+	case "healthcheck":
+		return runHealthcheck(cfg, args)
+	case "migrate":
+		return runMigrate(cfg, args)
+	case "repair-tool-names":
+		return runRepairToolNames(cfg, args)
+	case "verify":
+		return runVerify(cfg, args)
+	case "--version", "-version", "version":
+		fmt.Println(buildinfo.Get())
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		fmt.Fprintln(os.Stderr, "Usage:")
+		fmt.Fprintln(os.Stderr, "  otis                              Run the collector and aggregator")
+		fmt.Fprintln(os.Stderr, "  otis import-console-csv <file>    Backfill daily usage from an Anthropic Console CSV")
+		fmt.Fprintln(os.Stderr, "  otis map-api-key <key> <user> <org>  Attribute a Console API key's usage to a user")
+		fmt.Fprintln(os.Stderr, "  otis healthcheck [--collector] [--aggregator]  Check the local services")
+		fmt.Fprintln(os.Stderr, "  otis migrate status|up|down       Inspect or repair database migrations")
+		fmt.Fprintln(os.Stderr, "  otis repair-tool-names            Merge tool rows fragmented by case or whitespace")
+		fmt.Fprintln(os.Stderr, "  otis verify --session <id>|--window <w>  Check stored session totals against the raw files")
+		fmt.Fprintln(os.Stderr, "  otis --version                    Print build information")
+		return 2
+	}
+}
+
+// runImportConsoleCSV handles `otis import-console-csv <file>`
+func runImportConsoleCSV(cfg *config.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: otis import-console-csv <file>")
+		return 2
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", args[0], err)
+		return 1
+	}
+	defer f.Close()
+
+	store, err := aggregator.NewStore(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open store: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	result, err := store.ImportConsoleCSV(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Read %d rows, imported %d daily usage rows, skipped %d user-days with otis data\n",
+		result.RowsRead, result.RowsImported, result.ConflictedDays)
+	for _, apiKey := range result.UnmappedKeys {
+		fmt.Printf("No user mapping for API key %q (map it with otis map-api-key and import again)\n", apiKey)
+	}
+
+	return 0
+}
+
+// runMapAPIKey handles `otis map-api-key <key> <user> <org>`, replacing any
+// earlier mapping for the key. Console CSV imports read the mappings.
+func runMapAPIKey(cfg *config.Config, args []string) int {
+	if len(args) != 3 {
+		fmt.Fprintln(os.Stderr, "Usage: otis map-api-key <key> <user> <org>")
+		return 2
+	}
+
+	store, err := aggregator.NewStore(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open store: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	if err := store.UpsertAPIKeyIdentity(&aggregator.APIKeyIdentity{
+		APIKey:         args[0],
+		UserID:         args[1],
+		OrganizationID: args[2],
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to map API key: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Mapped API key %q to user %s in organization %s\n", args[0], args[1], args[2])
+	return 0
+}
+
+// runHealthcheck handles `otis healthcheck [--collector] [--aggregator]`.
+// With neither flag, both services are checked.
+func runHealthcheck(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	checkCollector := flags.Bool("collector", false, "check the OTLP collector")
+	checkAggregator := flags.Bool("aggregator", false, "check the aggregator API")
+	timeout := flags.Duration("timeout", 5*time.Second, "per-check timeout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if !*checkCollector && !*checkAggregator {
+		*checkCollector = true
+		*checkAggregator = true
+	}
+
+	targets := healthcheck.Targets(cfg, *checkCollector, *checkAggregator)
+	if err := healthcheck.Run(healthcheck.NewClient(*timeout), cfg.IngestToken, targets); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// runMigrate handles `otis migrate status|up|down`. The store is opened
+// without migrating so a database that fails to start can be repaired.
+func runMigrate(cfg *config.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: otis migrate status|up|down")
+		return 2
+	}
+
+	store, err := aggregator.OpenStore(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open store: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "status":
+		states, err := store.MigrationStatus()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, state := range states {
+			if state.Applied {
+				fmt.Printf("applied  %s  %s\n", state.AppliedAt.Format(time.RFC3339), state.Name)
+			} else {
+				fmt.Printf("pending  %-20s  %s\n", "", state.Name)
+			}
+		}
+	case "up":
+		if err := store.RunMigrations(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println("Database is up to date")
+	case "down":
+		name, err := store.MigrateDown()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Rolled back %s\n", name)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown migrate action %q\n", args[0])
+		fmt.Fprintln(os.Stderr, "Usage: otis migrate status|up|down")
+		return 2
+	}
+
+	return 0
+}
+
+// runRepairToolNames handles `otis repair-tool-names`
+func runRepairToolNames(cfg *config.Config, args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: otis repair-tool-names")
+		return 2
+	}
+
+	store, err := aggregator.NewStore(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open store: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	result, err := store.RepairToolNames()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Repair failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Normalized %d session_tools rows\n", result.SessionToolsMerged)
+	return 0
+}
+
+// runVerify handles `otis verify --session <id>` and `otis verify --window <w>`,
+// exiting 1 when any session's stored totals disagree with the raw files
+func runVerify(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	sessionID := flags.String("session", "", "session ID to verify")
+	window := flags.String("window", "", "verify sessions started in this window: all-time, 7d, 30d or custom")
+	start := flags.String("start", "", "RFC3339 start of a custom window")
+	end := flags.String("end", "", "RFC3339 end of a custom window")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if (*sessionID == "") == (*window == "") {
+		fmt.Fprintln(os.Stderr, "Usage: otis verify --session <id> | --window all-time|7d|30d|custom [--start <t> --end <t>]")
+		return 2
+	}
+
+	store, err := aggregator.NewStore(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open store: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	verifier := aggregator.NewVerifier(store, cfg.OutputDir, inputFiles(cfg))
+	var results []*aggregator.VerifyResult
+	if *sessionID != "" {
+		result, err := verifier.VerifySession(*sessionID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Verify failed: %v\n", err)
+			return 1
+		}
+		results = append(results, result)
+	} else {
+		timeWindow, err := aggregator.ParseTimeWindow(*window, *start, *end, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		results, err = verifier.VerifyWindow(timeWindow)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Verify failed: %v\n", err)
+			return 1
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		switch {
+		case !result.Stored:
+			fmt.Printf("%s: no stored session\n", result.SessionID)
+		case !result.Recomputed:
+			fmt.Printf("%s: no records in the raw files\n", result.SessionID)
+		case len(result.Discrepancies) == 0:
+			fmt.Printf("%s: ok\n", result.SessionID)
+			continue
+		default:
+			fmt.Printf("%s: %d discrepancies\n", result.SessionID, len(result.Discrepancies))
+			for _, d := range result.Discrepancies {
+				fmt.Printf("  %-28s stored %-14g recomputed %g\n", d.Field, d.Stored, d.Recomputed)
+			}
+		}
+		failed++
+	}
+
+	fmt.Printf("Verified %d sessions, %d inconsistent\n", len(results), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -1,37 +1,383 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
 	// Collector config
 	ServerPort     int
+	BindAddr       string // Host the collector listens on; empty for all interfaces
 	OutputDir      string
 	TraceFileName  string
 	MetricFileName string
 	LogFileName    string
 
+	// Sink is where received telemetry is written: "file" for JSONL files in
+	// OutputDir, "stdout" for one line per batch on standard output,
+	// prefixed with its signal, for a log pipeline to collect, or "s3" for
+	// segments uploaded to an S3-compatible bucket
+	Sink string
+
+	// TeeOutputDir, when set, gets a best-effort copy of every line written
+	// to the output files, such as for a pipeline under test
+	TeeOutputDir string
+
+	// AccessLogFile, when set, gets the collector's and aggregator API's
+	// access log instead of the process log
+	AccessLogFile string
+
+	// The S3 sink uploads to S3Bucket under S3Prefix at S3Endpoint, spooling
+	// segments in S3SpoolDir until they are uploaded. A segment is closed
+	// for upload at S3SegmentBytes or after S3SegmentSeconds.
+	S3Endpoint        string
+	S3Bucket          string
+	S3Prefix          string
+	S3Region          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3SessionToken    string
+	S3SpoolDir        string
+	S3SegmentBytes    int64
+	S3SegmentSeconds  int
+
+	// ListenSocket is a Unix socket path the collector listens on instead of
+	// its TCP port
+	ListenSocket string
+
+	// WriteFlushIntervalMS enables buffered writes flushed on this cadence.
+	// Zero writes every request straight through to disk.
+	WriteFlushIntervalMS int
+
+	// WriteBufferBytes is how much buffered data triggers a flush ahead of the interval
+	WriteBufferBytes int
+
+	// WriteQueueSize is how many requests may wait to write to each output
+	// file before more are rejected with 429; zero leaves it unbounded
+	WriteQueueSize int
+
+	// WriteRetryAttempts is how many times a line is tried before its write
+	// fails; WriteRetryBackoffMS the wait before the first retry, doubling
+	// for each one after
+	WriteRetryAttempts  int
+	WriteRetryBackoffMS int
+
+	// IngestToken, when set, is required as a bearer token on OTLP endpoints
+	IngestToken string
+
+	// MaxRequestBytes caps the size of an OTLP request body
+	MaxRequestBytes int64
+
+	// MaxResourcesPerRequest splits larger batches across several JSONL lines
+	MaxResourcesPerRequest int
+
+	// MaxRecordsPerRequest rejects requests with more spans, data points or log records
+	MaxRecordsPerRequest int64
+
+	// MaxRequestsPerSecond rate limits each OTLP signal; zero disables limiting
+	MaxRequestsPerSecond int
+
+	// TagBatches tags the lines written for each OTLP request with a batch ID
+	// and the request's traceparent and tracestate headers, which the
+	// aggregator records per session
+	TagBatches bool
+
+	// Fsync syncs output files after every write to disk, for durability across power loss
+	Fsync bool
+
+	// MaxFileSizeBytes rotates output files once they reach this size; zero disables rotation
+	MaxFileSizeBytes int64
+
+	// MaxRotatedFiles is how many rotated generations (<name>.1 ... <name>.N) are kept
+	MaxRotatedFiles int
+
+	// CompressRotated gzips rotated generations to <name>.N.gz
+	CompressRotated bool
+
+	// PartitionByOrg writes each organization's records, by organization.id,
+	// to its own OutputDir/<org>/ directory, for up to MaxPartitionOrgs
+	// organizations; later ones share the main files
+	PartitionByOrg   bool
+	MaxPartitionOrgs int
+
+	// ForwardEndpoint, when set, relays received OTLP requests to this upstream
+	// collector base URL, with ForwardHeaders on every request
+	ForwardEndpoint  string
+	ForwardHeaders   map[string]string
+	ForwardQueueSize int
+
+	// RedactAttributes are log attributes whose values are replaced with
+	// "<REDACTED>" before anything is written or forwarded, with a SHA-256
+	// of each original kept in <key>.sha256 when RedactHash is set
+	RedactAttributes []string
+	RedactHash       bool
+
+	// AnonymizeIDs replaces user.id and organization.id values with an
+	// HMAC-SHA256 keyed by AnonymizeSecret, in the collector and in the
+	// aggregator's extraction from existing files
+	AnonymizeIDs    bool
+	AnonymizeSecret string
+
+	// ClientErrorsPerMinute is how many error responses one client may get
+	// in a minute before a warning is logged; zero disables the warning
+	ClientErrorsPerMinute int
+
+	// LogLevel is "info", or "debug" to also log how long each OTLP request
+	// spends reading, unmarshalling and writing
+	LogLevel string
+
+	// DirectIngest hands OTLP requests from the collector straight to the
+	// aggregator engine instead of going through the file processor
+	DirectIngest bool
+
+	// DirectIngestWriteFiles keeps writing JSONL files as a raw archive in direct mode
+	DirectIngestWriteFiles bool
+
+	// InMemory keeps the database in memory and ingests directly, for
+	// ephemeral deployments; JSONL files are only written when
+	// DirectIngestWriteFiles is set explicitly
+	InMemory bool
+
+	// TLS for the collector; both files must be set to enable it
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSMinVersion string // "1.2" or "1.3"
+
+	// CollectorHTTP bounds the collector's connections and request headers
+	CollectorHTTP HTTPLimits
+
 	// Aggregator config
 	AggregatorEnabled  bool
 	AggregatorPort     int
+	AggregatorBindAddr string // Host the aggregator API listens on; empty for all interfaces
+	// AggregatorHTTP bounds the aggregator API's connections and request
+	// headers
+	AggregatorHTTP HTTPLimits
+	// SinglePort serves the aggregator API under /api/ on the collector's
+	// port instead of its own listener
+	SinglePort         bool
 	DBPath             string
 	ProcessingInterval int
+
+	// SlowQueryMS logs database statements that take longer, with their
+	// query plan when LogLevel is "debug"; zero disables the log.
+	// QueryTimeoutMS interrupts statements that take longer; zero disables it.
+	SlowQueryMS    int
+	QueryTimeoutMS int
+
+	// WatchMode is "poll" to re-check the JSONL files every ProcessingInterval,
+	// or "notify" to process them on filesystem notifications
+	WatchMode string
+
+	// CatchUpLinesPerSecond and CatchUpMaxCPUPercent throttle processing
+	// while a file has at least CatchUpLagBytes left to read, so a backlog
+	// after downtime doesn't starve the collector; zero and 100 disable them
+	CatchUpLinesPerSecond int
+	CatchUpMaxCPUPercent  int
+	CatchUpLagBytes       int64
+
+	// RawRetentionDays deletes rotated JSONL files older than this many days
+	// once they are fully processed; zero keeps them forever
+	RawRetentionDays int
+
+	// EstimateActiveTime fills in active time from api_request timestamps for
+	// sessions without the active time metric, counting pauses shorter than
+	// ActiveTimeGapSeconds
+	EstimateActiveTime   bool
+	ActiveTimeGapSeconds int
+
+	// MaxIDLength is the longest session, user or organization ID accepted;
+	// records with longer ones are dropped
+	MaxIDLength int
+
+	// StatsRollupSeconds is how often user and org stats are rolled up for
+	// each rolling window; zero disables the rollup
+	StatsRollupSeconds int
+
+	// DegradedStaleSeconds is how old a cached stats response may be and
+	// still be served while the database is unavailable; zero disables
+	// degraded mode. DegradedProbeSeconds is how often the database is
+	// retried meanwhile.
+	DegradedStaleSeconds int
+	DegradedProbeSeconds int
+
+	// MaxLineBytes is the longest JSONL line the aggregator processes;
+	// longer lines are logged and skipped
+	MaxLineBytes int
+
+	// Timezone, BusinessDays and BusinessHours define business hours, which
+	// sessions' active time is split by for the after hours report
+	Timezone      string
+	BusinessDays  string
+	BusinessHours string
+
+	// ProcessingWorkers bounds how many JSONL files are processed at once
+	// and how many goroutines decode each file's lines
+	ProcessingWorkers int
+
+	// SessionIndex records which byte ranges of the JSONL files hold each
+	// session during processing, so otis verify can skip full scans
+	SessionIndex bool
+
+	// PricingFile is a JSON file of per-model token rates used for cost breakdowns
+	PricingFile string
+
+	// Seats is the paid seat count of every organization, and OrgSeats
+	// overrides it per organization ID; seat tracking is off without either
+	Seats    int
+	OrgSeats map[string]string
+
+	// MetricsUserLabel splits the usage series served at /metrics by user
+	// as well as organization
+	MetricsUserLabel bool
+
+	// ExportDir holds finished session exports for ExportRetentionHours
+	ExportDir            string
+	ExportRetentionHours int
+
+	// TraceURLTemplate links session traces to an external tracing UI, with
+	// {traceId} replaced by the hex trace ID
+	TraceURLTemplate string
+
+	// AdminToken, when set, enables the admin API endpoints behind this bearer token
+	AdminToken string
+
+	// ComparativeStatsOptOut lists orgs whose user stats omit the cost rank among peers
+	ComparativeStatsOptOut []string
+
+	// ModelAllowlist lists the models organizations are expected to use;
+	// sessions using any other are flagged as anomalies. Empty disables it.
+	ModelAllowlist []string
+
+	// AnomalyWebhookURL receives each newly flagged anomaly as JSON
+	AnomalyWebhookURL string
+
+	// PublicSummaryFields allowlists the aggregates served unauthenticated at
+	// /api/public/summary, recomputed at most PublicSummaryRPS times a
+	// second; empty disables the endpoint
+	PublicSummaryFields []string
+	PublicSummaryRPS    int
 }
 
 func Load() *Config {
-	return &Config{
-		ServerPort:         getEnvAsInt("OTIS_PORT", 4318),
-		OutputDir:          getEnv("OTIS_OUTPUT_DIR", "./data"),
-		TraceFileName:      getEnv("OTIS_TRACE_FILE", "traces.jsonl"),
-		MetricFileName:     getEnv("OTIS_METRIC_FILE", "metrics.jsonl"),
-		LogFileName:        getEnv("OTIS_LOG_FILE", "logs.jsonl"),
-		AggregatorEnabled:  getEnvAsBool("OTIS_AGGREGATOR_ENABLED", true),
-		AggregatorPort:     getEnvAsInt("OTIS_AGGREGATOR_PORT", 8080),
-		DBPath:             getEnv("OTIS_DB_PATH", "./db/otis.db"),
-		ProcessingInterval: getEnvAsInt("OTIS_PROCESSING_INTERVAL", 5),
+	cfg := &Config{
+		ServerPort:             getEnvAsInt("OTIS_PORT", 4318),
+		BindAddr:               getEnv("OTIS_BIND_ADDR", ""),
+		ListenSocket:           getEnv("OTIS_LISTEN_SOCKET", ""),
+		OutputDir:              getEnv("OTIS_OUTPUT_DIR", "./data"),
+		Sink:                   getEnv("OTIS_SINK", "file"),
+		TeeOutputDir:           getEnv("OTIS_TEE_OUTPUT_DIR", ""),
+		AccessLogFile:          getEnv("OTIS_ACCESS_LOG_FILE", ""),
+		S3Endpoint:             getEnv("OTIS_S3_ENDPOINT", "https://s3.amazonaws.com"),
+		S3Bucket:               getEnv("OTIS_S3_BUCKET", ""),
+		S3Prefix:               getEnv("OTIS_S3_PREFIX", ""),
+		S3Region:               getEnv("OTIS_S3_REGION", getEnv("AWS_REGION", "us-east-1")),
+		S3AccessKeyID:          getEnv("OTIS_S3_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+		S3SecretAccessKey:      getEnv("OTIS_S3_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		S3SessionToken:         getEnv("OTIS_S3_SESSION_TOKEN", os.Getenv("AWS_SESSION_TOKEN")),
+		S3SpoolDir:             getEnv("OTIS_S3_SPOOL_DIR", ""),
+		S3SegmentBytes:         getEnvAsInt64("OTIS_S3_SEGMENT_BYTES", 64<<20),
+		S3SegmentSeconds:       getEnvAsInt("OTIS_S3_SEGMENT_SECONDS", 300),
+		TraceFileName:          getEnv("OTIS_TRACE_FILE", "traces.jsonl"),
+		MetricFileName:         getEnv("OTIS_METRIC_FILE", "metrics.jsonl"),
+		LogFileName:            getEnv("OTIS_LOG_FILE", "logs.jsonl"),
+		WriteFlushIntervalMS:   getEnvAsInt("OTIS_WRITE_FLUSH_INTERVAL_MS", 1000),
+		WriteBufferBytes:       getEnvAsInt("OTIS_WRITE_BUFFER_BYTES", 64<<10),
+		WriteQueueSize:         getEnvAsInt("OTIS_WRITE_QUEUE_SIZE", 100),
+		WriteRetryAttempts:     getEnvAsInt("OTIS_WRITE_RETRY_ATTEMPTS", 3),
+		WriteRetryBackoffMS:    getEnvAsInt("OTIS_WRITE_RETRY_BACKOFF_MS", 50),
+		IngestToken:            getEnv("OTIS_INGEST_TOKEN", ""),
+		MaxRequestBytes:        getEnvAsInt64("OTIS_MAX_REQUEST_BYTES", 16<<20),
+		MaxResourcesPerRequest: getEnvAsInt("OTIS_MAX_RESOURCES_PER_REQUEST", 1000),
+		MaxRecordsPerRequest:   getEnvAsInt64("OTIS_MAX_RECORDS_PER_REQUEST", 100000),
+		MaxRequestsPerSecond:   getEnvAsInt("OTIS_MAX_RPS", 0),
+		Fsync:                  getEnvAsBool("OTIS_FSYNC", false),
+		TagBatches:             getEnvAsBool("OTIS_TAG_BATCHES", false),
+		LogLevel:               getEnv("OTIS_LOG_LEVEL", "info"),
+		MaxFileSizeBytes:       getEnvAsInt64("OTIS_MAX_FILE_SIZE", getEnvAsInt64("OTIS_MAX_FILE_SIZE_MB", 0)<<20),
+		MaxRotatedFiles:        getEnvAsInt("OTIS_MAX_ROTATED_FILES", 5),
+		CompressRotated:        getEnvAsBool("OTIS_COMPRESS_ROTATED", false),
+		PartitionByOrg:         getEnvAsBool("OTIS_PARTITION_BY_ORG", false),
+		MaxPartitionOrgs:       getEnvAsInt("OTIS_MAX_PARTITION_ORGS", 256),
+		ForwardEndpoint:        getEnv("OTIS_FORWARD_ENDPOINT", ""),
+		ForwardHeaders:         getEnvAsMap("OTIS_FORWARD_HEADERS"),
+		ForwardQueueSize:       getEnvAsInt("OTIS_FORWARD_QUEUE_SIZE", 1000),
+		ClientErrorsPerMinute:  getEnvAsInt("OTIS_CLIENT_ERRORS_PER_MINUTE", 60),
+		RedactAttributes:       getEnvAsListOr("OTIS_REDACT_ATTRIBUTES", []string{"prompt"}),
+		RedactHash:             getEnvAsBool("OTIS_REDACT_HASH", false),
+		AnonymizeIDs:           getEnvAsBool("OTIS_ANONYMIZE_IDS", false),
+		AnonymizeSecret:        getEnv("OTIS_ANONYMIZE_SECRET", ""),
+		DirectIngest:           getEnvAsBool("OTIS_DIRECT_INGEST", false),
+		DirectIngestWriteFiles: getEnvAsBool("OTIS_DIRECT_INGEST_WRITE_FILES", true),
+		InMemory:               getEnvAsBool("OTIS_IN_MEMORY", false),
+		TLSCertFile:            getEnv("OTIS_TLS_CERT", ""),
+		TLSKeyFile:             getEnv("OTIS_TLS_KEY", ""),
+		TLSMinVersion:          getEnv("OTIS_TLS_MIN_VERSION", "1.2"),
+		CollectorHTTP:          loadHTTPLimits("OTIS_COLLECTOR"),
+		AggregatorEnabled:      getEnvAsBool("OTIS_AGGREGATOR_ENABLED", true),
+		AggregatorPort:         getEnvAsInt("OTIS_AGGREGATOR_PORT", 8080),
+		AggregatorBindAddr:     getEnv("OTIS_AGGREGATOR_BIND_ADDR", ""),
+		AggregatorHTTP:         loadHTTPLimits("OTIS_AGGREGATOR"),
+		SinglePort:             getEnvAsBool("OTIS_SINGLE_PORT", false),
+		DBPath:                 getEnv("OTIS_DB_PATH", "./db/otis.db"),
+		ProcessingInterval:     getEnvAsInt("OTIS_PROCESSING_INTERVAL", 5),
+		SlowQueryMS:            getEnvAsInt("OTIS_SLOW_QUERY_MS", 500),
+		QueryTimeoutMS:         getEnvAsInt("OTIS_QUERY_TIMEOUT_MS", 0),
+		WatchMode:              getEnv("OTIS_WATCH_MODE", "poll"),
+		CatchUpLinesPerSecond:  getEnvAsInt("OTIS_CATCHUP_MAX_LINES_PER_SECOND", 0),
+		CatchUpMaxCPUPercent:   getEnvAsInt("OTIS_CATCHUP_MAX_CPU_PERCENT", 100),
+		CatchUpLagBytes:        getEnvAsInt64("OTIS_CATCHUP_LAG_BYTES", 16<<20),
+		RawRetentionDays:       getEnvAsInt("OTIS_RAW_RETENTION_DAYS", 0),
+		EstimateActiveTime:     getEnvAsBool("OTIS_ESTIMATE_ACTIVE_TIME", false),
+		ActiveTimeGapSeconds:   getEnvAsInt("OTIS_ACTIVE_TIME_GAP_SECONDS", 300),
+		MaxIDLength:            getEnvAsInt("OTIS_MAX_ID_LENGTH", 256),
+		StatsRollupSeconds:     getEnvAsInt("OTIS_STATS_ROLLUP_SECONDS", 300),
+		DegradedStaleSeconds:   getEnvAsInt("OTIS_DEGRADED_STALE_SECONDS", 0),
+		DegradedProbeSeconds:   getEnvAsInt("OTIS_DEGRADED_PROBE_SECONDS", 5),
+		MaxLineBytes:           getEnvAsInt("OTIS_MAX_LINE_BYTES", 16<<20),
+		ProcessingWorkers:      getEnvAsInt("OTIS_PROCESSING_WORKERS", 4),
+		Timezone:               getEnv("OTIS_TIMEZONE", "UTC"),
+		BusinessDays:           getEnv("OTIS_BUSINESS_DAYS", "mon-fri"),
+		BusinessHours:          getEnv("OTIS_BUSINESS_HOURS", "09:00-17:00"),
+		SessionIndex:           getEnvAsBool("OTIS_SESSION_INDEX", false),
+		PricingFile:            getEnv("OTIS_PRICING_FILE", ""),
+		Seats:                  getEnvAsInt("OTIS_SEATS", 0),
+		OrgSeats:               getEnvAsMap("OTIS_ORG_SEATS"),
+		MetricsUserLabel:       getEnvAsBool("OTIS_METRICS_USER_LABEL", false),
+		ExportDir:              getEnv("OTIS_EXPORT_DIR", "./exports"),
+		ExportRetentionHours:   getEnvAsInt("OTIS_EXPORT_RETENTION_HOURS", 24),
+		TraceURLTemplate:       getEnv("OTIS_TRACE_URL_TEMPLATE", ""),
+		AdminToken:             getEnv("OTIS_ADMIN_TOKEN", ""),
+		ComparativeStatsOptOut: getEnvAsList("OTIS_COMPARATIVE_STATS_OPT_OUT"),
+		ModelAllowlist:         getEnvAsList("OTIS_MODEL_ALLOWLIST"),
+		AnomalyWebhookURL:      getEnv("OTIS_ANOMALY_WEBHOOK_URL", ""),
+		PublicSummaryFields:    getEnvAsList("OTIS_PUBLIC_SUMMARY_FIELDS"),
+		PublicSummaryRPS:       getEnvAsInt("OTIS_PUBLIC_SUMMARY_RPS", 5),
+	}
+
+	// Nothing touches disk in memory mode unless files are asked for
+	if cfg.InMemory {
+		cfg.DBPath = ":memory:"
+		cfg.DirectIngest = true
+		cfg.DirectIngestWriteFiles = getEnvAsBool("OTIS_DIRECT_INGEST_WRITE_FILES", false)
+	}
+
+	// Spooled S3 segments default to a directory beside the output files
+	if cfg.S3SpoolDir == "" {
+		cfg.S3SpoolDir = filepath.Join(cfg.OutputDir, "s3-spool")
+	}
+
+	// Stdout and S3 output leave no files for the aggregator to read, so it
+	// only runs when ingesting directly
+	if (cfg.Sink == "stdout" || cfg.Sink == "s3") && !cfg.DirectIngest {
+		cfg.AggregatorEnabled = false
 	}
+
+	return cfg
 }
 
 func getEnv(key, defaultValue string) string {
@@ -50,6 +396,15 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsInt64(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
@@ -58,3 +413,80 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvAsList splits a comma-separated value, dropping empty entries
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvAsListOr is getEnvAsList with a default for when key is unset; set
+// to an empty value, it yields an empty list
+func getEnvAsListOr(key string, defaultValue []string) []string {
+	if _, ok := os.LookupEnv(key); !ok {
+		return defaultValue
+	}
+	return getEnvAsList(key)
+}
+
+// getEnvAsMap parses comma-separated key=value pairs, as in
+// OTEL_EXPORTER_OTLP_HEADERS
+func getEnvAsMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range getEnvAsList(key) {
+		k, v, ok := strings.Cut(pair, "=")
+		if k = strings.TrimSpace(k); ok && k != "" {
+			values[k] = strings.TrimSpace(v)
+		}
+	}
+	return values
+}
+
+// HTTPLimits bounds how long an HTTP server waits on each connection and how
+// large the request headers it accepts may be
+type HTTPLimits struct {
+	ReadTimeoutSeconds       int // Reading a whole request, body included
+	ReadHeaderTimeoutSeconds int // Reading a request's headers
+	WriteTimeoutSeconds      int // Writing a response
+	IdleTimeoutSeconds       int // Waiting for the next request on a kept-alive connection
+	MaxHeaderBytes           int
+
+	envPrefix string // Prefix of the variables they were loaded from
+}
+
+// loadHTTPLimits reads a server's limits from the variables starting with
+// prefix, defaulting to what the servers used before they were configurable
+func loadHTTPLimits(prefix string) HTTPLimits {
+	return HTTPLimits{
+		ReadTimeoutSeconds:       getEnvAsInt(prefix+"_READ_TIMEOUT_SECONDS", 10),
+		ReadHeaderTimeoutSeconds: getEnvAsInt(prefix+"_READ_HEADER_TIMEOUT_SECONDS", 5),
+		WriteTimeoutSeconds:      getEnvAsInt(prefix+"_WRITE_TIMEOUT_SECONDS", 10),
+		IdleTimeoutSeconds:       getEnvAsInt(prefix+"_IDLE_TIMEOUT_SECONDS", 10),
+		MaxHeaderBytes:           getEnvAsInt(prefix+"_MAX_HEADER_BYTES", 1<<20),
+		envPrefix:                prefix,
+	}
+}
+
+// Validate rejects limits that aren't positive, naming the variable to fix
+func (l HTTPLimits) Validate() error {
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{
+		{"READ_TIMEOUT_SECONDS", l.ReadTimeoutSeconds},
+		{"READ_HEADER_TIMEOUT_SECONDS", l.ReadHeaderTimeoutSeconds},
+		{"WRITE_TIMEOUT_SECONDS", l.WriteTimeoutSeconds},
+		{"IDLE_TIMEOUT_SECONDS", l.IdleTimeoutSeconds},
+		{"MAX_HEADER_BYTES", l.MaxHeaderBytes},
+	} {
+		if limit.value <= 0 {
+			return fmt.Errorf("%s_%s must be positive, got %d", l.envPrefix, limit.name, limit.value)
+		}
+	}
+	return nil
+}
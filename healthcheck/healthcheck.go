@@ -0,0 +1,140 @@
+package healthcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zmack/otis/config"
+)
+
+// Target is a health endpoint to probe
+type Target struct {
+	Name   string
+	URL    string
+	Socket string // Unix socket to dial instead of the URL's host, if any
+}
+
+// Targets resolves the local collector and aggregator readiness endpoints
+// from the same config the server uses. Readiness, rather than liveness, is
+// probed so a full disk or an unavailable database fails the check.
+func Targets(cfg *config.Config, collector, aggregator bool) []Target {
+	var targets []Target
+
+	collectorScheme := "http"
+	if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
+		collectorScheme = "https"
+	}
+
+	// Over a Unix socket the host is ignored, as with curl --unix-socket
+	collectorHost := probeHost(cfg.BindAddr, cfg.ServerPort)
+	if cfg.ListenSocket != "" {
+		collectorHost = "localhost"
+	}
+	if collector {
+		targets = append(targets, Target{
+			Name:   "collector",
+			URL:    fmt.Sprintf("%s://%s/ready", collectorScheme, collectorHost),
+			Socket: cfg.ListenSocket,
+		})
+	}
+
+	if aggregator && cfg.AggregatorEnabled {
+		// In single port mode the API shares the collector's listener
+		target := Target{
+			Name: "aggregator",
+			URL:  fmt.Sprintf("http://%s/api/health/ready", probeHost(cfg.AggregatorBindAddr, cfg.AggregatorPort)),
+		}
+		if cfg.SinglePort {
+			target.URL = fmt.Sprintf("%s://%s/api/health/ready", collectorScheme, collectorHost)
+			target.Socket = cfg.ListenSocket
+		}
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+// probeHost is the host and port to reach a server listening on bindAddr at:
+// localhost when it listens on every interface, and bindAddr itself otherwise
+func probeHost(bindAddr string, port int) string {
+	host := bindAddr
+	if ip := net.ParseIP(bindAddr); bindAddr == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// NewClient returns an HTTP client suited to probing the local server
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// The probe only talks to localhost, where the serving certificate
+			// is usually issued for another hostname
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
+
+// Run probes every target and returns an error describing each failing check
+func Run(client *http.Client, token string, targets []Target) error {
+	var failures []string
+
+	for _, target := range targets {
+		if err := probe(client, token, target); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", target.Name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("health check failed:\n  %s", strings.Join(failures, "\n  "))
+	}
+	return nil
+}
+
+func probe(client *http.Client, token string, target Target) error {
+	if target.Socket != "" {
+		client = socketClient(client, target.Socket)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target.URL, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", target.URL, resp.Status)
+	}
+	return nil
+}
+
+// socketClient returns a copy of client that dials socket for every request
+func socketClient(client *http.Client, socket string) *http.Client {
+	transport := &http.Transport{}
+	if t, ok := client.Transport.(*http.Transport); ok {
+		transport = t.Clone()
+	}
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+
+	socketClient := *client
+	socketClient.Transport = transport
+	return &socketClient
+}
@@ -0,0 +1,138 @@
+package healthcheck
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmack/otis/config"
+)
+
+func TestRunHealthy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	targets := []Target{{Name: "collector", URL: server.URL + "/health"}}
+	if err := Run(NewClient(time.Second), "", targets); err != nil {
+		t.Errorf("Expected healthy check, got %v", err)
+	}
+}
+
+func TestRunDegraded(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	degraded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer degraded.Close()
+
+	targets := []Target{
+		{Name: "collector", URL: healthy.URL + "/health"},
+		{Name: "aggregator", URL: degraded.URL + "/api/health"},
+	}
+	err := Run(NewClient(time.Second), "", targets)
+	if err == nil {
+		t.Fatal("Expected degraded check to fail")
+	}
+	if !strings.Contains(err.Error(), "aggregator") || strings.Contains(err.Error(), "collector") {
+		t.Errorf("Expected only the aggregator to be reported, got %v", err)
+	}
+}
+
+func TestRunUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	if err := Run(NewClient(time.Second), "", []Target{{Name: "collector", URL: url}}); err == nil {
+		t.Error("Expected unreachable server to fail")
+	}
+}
+
+func TestRunSendsToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	if err := Run(NewClient(time.Second), "secret", []Target{{Name: "collector", URL: server.URL}}); err != nil {
+		t.Errorf("Expected token to be sent, got %v", err)
+	}
+}
+
+func TestRunOverUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "otis.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	targets := Targets(&config.Config{ServerPort: 4318, BindAddr: "10.0.0.5", ListenSocket: socket}, true, false)
+	if err := Run(NewClient(time.Second), "", targets); err != nil {
+		t.Errorf("Expected the check to pass over the socket, got %v", err)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	cfg := &config.Config{
+		ServerPort:        4318,
+		AggregatorEnabled: true,
+		AggregatorPort:    8080,
+		TLSCertFile:       "cert.pem",
+		TLSKeyFile:        "key.pem",
+	}
+
+	targets := Targets(cfg, true, true)
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+	if targets[0].URL != "https://localhost:4318/ready" {
+		t.Errorf("Unexpected collector URL %s", targets[0].URL)
+	}
+	if targets[1].URL != "http://localhost:8080/api/health/ready" {
+		t.Errorf("Unexpected aggregator URL %s", targets[1].URL)
+	}
+
+	cfg.SinglePort = true
+	if targets := Targets(cfg, false, true); len(targets) != 1 || targets[0].URL != "https://localhost:4318/api/health/ready" {
+		t.Errorf("Expected the aggregator on the collector's port in single port mode, got %v", targets)
+	}
+
+	// A server bound to one address is only reachable there
+	cfg.SinglePort = false
+	cfg.BindAddr = "10.0.0.5"
+	cfg.AggregatorBindAddr = "::1"
+	if targets := Targets(cfg, true, true); targets[0].URL != "https://10.0.0.5:4318/ready" || targets[1].URL != "http://[::1]:8080/api/health/ready" {
+		t.Errorf("Expected the configured bind addresses, got %v", targets)
+	}
+	cfg.BindAddr = "0.0.0.0"
+	if targets := Targets(cfg, true, false); targets[0].URL != "https://localhost:4318/ready" {
+		t.Errorf("Expected localhost for a server on every interface, got %v", targets)
+	}
+
+	cfg.AggregatorEnabled = false
+	if targets := Targets(cfg, false, true); len(targets) != 0 {
+		t.Errorf("Expected no targets when the aggregator is disabled, got %v", targets)
+	}
+}
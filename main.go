@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/zmack/otis/aggregator"
+	"github.com/zmack/otis/anonymize"
+	"github.com/zmack/otis/buildinfo"
 	"github.com/zmack/otis/collector"
 	"github.com/zmack/otis/config"
 )
@@ -16,23 +18,48 @@ import (
 func main() {
 	cfg := config.Load()
 
-	// Start OTLP collector
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, os.Args[1], os.Args[2:]))
+	}
+
+	log.Printf("Starting %s, features: %v", buildinfo.Get(), buildinfo.Features(cfg))
+
+	if cfg.InMemory && !cfg.AggregatorEnabled {
+		log.Fatalf("OTIS_IN_MEMORY requires the aggregator to be enabled")
+	}
+	if cfg.DirectIngest && !cfg.AggregatorEnabled {
+		log.Fatalf("OTIS_DIRECT_INGEST requires the aggregator to be enabled")
+	}
+	if cfg.SinglePort && !cfg.AggregatorEnabled {
+		log.Fatalf("OTIS_SINGLE_PORT requires the aggregator to be enabled")
+	}
+	if cfg.AnonymizeIDs && cfg.AnonymizeSecret == "" {
+		log.Fatalf("OTIS_ANONYMIZE_IDS requires OTIS_ANONYMIZE_SECRET")
+	}
+	for _, limits := range []config.HTTPLimits{cfg.CollectorHTTP, cfg.AggregatorHTTP} {
+		if err := limits.Validate(); err != nil {
+			log.Fatalf("Invalid HTTP limits: %v", err)
+		}
+	}
+	if (cfg.Sink == "stdout" || cfg.Sink == "s3") && !cfg.DirectIngest {
+		log.Printf("Warning: OTIS_SINK=%s leaves no files for the aggregator to read, so it is disabled; set OTIS_DIRECT_INGEST to aggregate directly", cfg.Sink)
+	}
+	if cfg.InMemory {
+		log.Println("In-memory mode: the database lives in memory and is lost on exit")
+	}
+
+	// Create the OTLP collector; it is started once the aggregator is ready
 	collectorServer, err := collector.NewServer(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create collector server: %v", err)
 	}
 
-	go func() {
-		if err := collectorServer.Start(); err != nil {
-			log.Fatalf("Failed to start collector server: %v", err)
-		}
-	}()
-
 	// Start aggregator if enabled
 	var aggStore *aggregator.Store
 	var aggEngine *aggregator.Engine
 	var aggProcessor *aggregator.Processor
 	var aggAPI *aggregator.APIServer
+	var aggExporter *aggregator.Exporter
 
 	if cfg.AggregatorEnabled {
 		log.Println("Starting aggregator...")
@@ -42,23 +69,120 @@ func main() {
 		if err != nil {
 			log.Fatalf("Failed to create aggregator store: %v", err)
 		}
+		aggStore.SetSlowQueryThreshold(time.Duration(cfg.SlowQueryMS) * time.Millisecond)
+		aggStore.SetQueryTimeout(time.Duration(cfg.QueryTimeoutMS) * time.Millisecond)
+		aggStore.SetExplainSlowQueries(cfg.LogLevel == "debug")
 
 		// Initialize engine
 		aggEngine = aggregator.NewEngine(aggStore)
+		if cfg.EstimateActiveTime {
+			aggEngine.SetActiveTimeEstimation(time.Duration(cfg.ActiveTimeGapSeconds) * time.Second)
+		}
+		aggEngine.SetMaxIDLength(cfg.MaxIDLength)
+		aggEngine.SetStatsRollupInterval(time.Duration(cfg.StatsRollupSeconds) * time.Second)
+		aggEngine.SetModelAllowlist(cfg.ModelAllowlist)
+		aggEngine.SetAnomalyWebhook(cfg.AnomalyWebhookURL)
+		businessHours, err := aggregator.ParseBusinessHours(cfg.Timezone, cfg.BusinessDays, cfg.BusinessHours)
+		if err != nil {
+			log.Fatalf("Invalid business hours: %v", err)
+		}
+		aggEngine.SetBusinessHours(businessHours)
+		if cfg.AnonymizeIDs {
+			aggEngine.SetAnonymizer(anonymize.New(cfg.AnonymizeSecret))
+		}
+
+		// Pricing prices token usage for models that don't report cost, so it
+		// is set before the processor's first pass
+		var pricing aggregator.Pricing
+		if cfg.PricingFile != "" {
+			pricing, err = aggregator.LoadPricing(cfg.PricingFile)
+			if err != nil {
+				log.Fatalf("Failed to load pricing: %v", err)
+			}
+			aggEngine.SetPricing(pricing)
+			log.Printf("Loaded pricing for %d models from %s", len(pricing), cfg.PricingFile)
+		}
 
-		// Initialize processor
-		aggProcessor = aggregator.NewProcessor(cfg.OutputDir, aggStore, aggEngine, cfg.ProcessingInterval)
-		aggProcessor.Start()
+		// Initialize processor. Its first pass runs before the collector
+		// starts, so files left from file mode are caught up before direct
+		// ingestion begins. In memory mode there are no files to catch up on.
+		if !cfg.InMemory {
+			aggProcessor = aggregator.NewProcessor(cfg.OutputDir, inputFiles(cfg), aggStore, aggEngine, cfg.ProcessingInterval)
+			aggProcessor.SetSessionIndex(cfg.SessionIndex)
+			aggProcessor.SetMaxLineBytes(cfg.MaxLineBytes)
+			aggProcessor.SetWorkers(cfg.ProcessingWorkers)
+			if err := aggProcessor.SetWatchMode(cfg.WatchMode); err != nil {
+				log.Fatalf("Invalid OTIS_WATCH_MODE: %v", err)
+			}
+			aggProcessor.SetDirectIngest(cfg.DirectIngest)
+			aggProcessor.SetCatchUpThrottle(cfg.CatchUpLinesPerSecond, float64(cfg.CatchUpMaxCPUPercent)/100, cfg.CatchUpLagBytes)
+			aggProcessor.SetRawRetention(cfg.RawRetentionDays)
+			aggProcessor.Start()
+		}
+		if cfg.DirectIngest {
+			collectorServer.SetSink(aggregator.NewDirectIngester(aggEngine))
+			log.Println("Direct ingestion enabled, the collector feeds the aggregator engine")
+		}
 
 		// Initialize API server
 		aggAPI = aggregator.NewAPIServer(cfg.AggregatorPort, aggStore, aggEngine)
-		go func() {
-			if err := aggAPI.Start(); err != nil {
-				log.Fatalf("Failed to start aggregator API: %v", err)
+		aggAPI.SetBindAddr(cfg.AggregatorBindAddr)
+		aggAPI.SetHTTPTimeouts(
+			time.Duration(cfg.AggregatorHTTP.ReadTimeoutSeconds)*time.Second,
+			time.Duration(cfg.AggregatorHTTP.ReadHeaderTimeoutSeconds)*time.Second,
+			time.Duration(cfg.AggregatorHTTP.WriteTimeoutSeconds)*time.Second,
+			time.Duration(cfg.AggregatorHTTP.IdleTimeoutSeconds)*time.Second,
+		)
+		aggAPI.SetMaxHeaderBytes(cfg.AggregatorHTTP.MaxHeaderBytes)
+		aggAPI.SetFeatures(buildinfo.Features(cfg))
+		if pricing != nil {
+			aggAPI.SetPricing(pricing)
+		}
+		aggAPI.SetComparativeStatsOptOut(cfg.ComparativeStatsOptOut)
+		aggAPI.SetRawAccess(cfg.OutputDir, inputFiles(cfg), cfg.AdminToken)
+		aggAPI.SetTraceURLTemplate(cfg.TraceURLTemplate)
+		aggAPI.SetDegradedMode(time.Duration(cfg.DegradedStaleSeconds)*time.Second, time.Duration(cfg.DegradedProbeSeconds)*time.Second)
+		if err := aggAPI.SetPublicSummary(cfg.PublicSummaryFields, cfg.PublicSummaryRPS); err != nil {
+			log.Fatalf("Invalid OTIS_PUBLIC_SUMMARY_FIELDS: %v", err)
+		}
+		if err := aggAPI.SetSeats(cfg.Seats, cfg.OrgSeats); err != nil {
+			log.Fatalf("Invalid seat counts: %v", err)
+		}
+		aggAPI.SetMetricsUserLabel(cfg.MetricsUserLabel)
+		aggAPI.SetAccessLog(collectorServer.AccessLog())
+		if aggProcessor != nil {
+			aggAPI.SetProcessor(aggProcessor)
+		}
+
+		// Exports run in the background and are downloaded once finished.
+		// They are written to disk, so memory mode goes without them.
+		if !cfg.InMemory {
+			aggExporter = aggregator.NewExporter(aggStore, cfg.ExportDir, time.Duration(cfg.ExportRetentionHours)*time.Hour)
+			if err := aggExporter.Start(); err != nil {
+				log.Fatalf("Failed to start exporter: %v", err)
 			}
-		}()
+			aggAPI.SetExporter(aggExporter)
+		}
+
+		if cfg.SinglePort {
+			collectorServer.SetAPIHandler(aggAPI.Handler())
+			log.Printf("Single port mode, serving the aggregation API on the collector's port %d", cfg.ServerPort)
+		} else {
+			go func() {
+				if err := aggAPI.Start(); err != nil {
+					log.Fatalf("Failed to start aggregator API: %v", err)
+				}
+			}()
+		}
 	}
 
+	// The collector starts last so the engine exists before any request arrives
+	go func() {
+		if err := collectorServer.Start(); err != nil {
+			log.Fatalf("Failed to start collector server: %v", err)
+		}
+	}()
+
 	// Wait for shutdown signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -71,7 +195,9 @@ func main() {
 
 	log.Println("Shutting down services...")
 
-	// Shutdown collector
+	// Shutdown collector. A writer that fails to close is logged and the
+	// rest of the shutdown carries on. In single port mode this also drains
+	// in-flight API requests, before the store they read from is closed.
 	if err := collectorServer.Shutdown(ctx); err != nil {
 		log.Printf("Collector shutdown error: %v", err)
 	}
@@ -84,14 +210,19 @@ func main() {
 
 		if aggEngine != nil {
 			aggEngine.FlushCache()
+			aggEngine.Close()
 		}
 
-		if aggAPI != nil {
+		if aggAPI != nil && !cfg.SinglePort {
 			if err := aggAPI.Shutdown(ctx); err != nil {
 				log.Printf("Aggregator API shutdown error: %v", err)
 			}
 		}
 
+		if aggExporter != nil {
+			aggExporter.Stop()
+		}
+
 		if aggStore != nil {
 			if err := aggStore.Close(); err != nil {
 				log.Printf("Store close error: %v", err)
@@ -99,5 +230,20 @@ func main() {
 		}
 	}
 
+	// Both servers have stopped logging requests by now
+	if err := collectorServer.CloseAccessLog(); err != nil {
+		log.Printf("Access log close error: %v", err)
+	}
+
 	log.Println("All services stopped gracefully")
 }
+
+// inputFiles is the collector's configured file names, for the aggregator
+func inputFiles(cfg *config.Config) aggregator.InputFiles {
+	return aggregator.InputFiles{
+		Metrics: cfg.MetricFileName,
+		Logs:    cfg.LogFileName,
+		Traces:  cfg.TraceFileName,
+		ByOrg:   cfg.PartitionByOrg,
+	}
+}